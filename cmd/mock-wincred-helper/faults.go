@@ -0,0 +1,145 @@
+// SPDX-License-Identifier: Apache-2.0
+
+//go:build !windows
+
+package main
+
+import (
+	"encoding/base64"
+	"fmt"
+	"math/rand/v2"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/akihiro/wsl-secret-service/internal/ipc"
+)
+
+// Fault injection, for testing how the Bridge copes with a slow or failing
+// Credential Manager. Each fault is configured by an environment variable,
+// read on every request so that a test can change it between calls:
+//
+//	MOCK_WINCRED_LATENCY        delay before answering: a duration ("300ms"),
+//	                            or a range ("100ms-2s") to pick from uniformly
+//	MOCK_WINCRED_FAIL_RATE      probability (0 to 1) that a request fails
+//	MOCK_WINCRED_FAIL_ERROR     the error failing requests report: a Win32
+//	                            error name (see win32Errors) or number
+//	                            (default ERROR_NO_SUCH_LOGON_SESSION)
+//	MOCK_WINCRED_FAULT_ACTIONS  comma-separated actions the faults apply to
+//	                            (default get,set,delete,list)
+//	MOCK_WINCRED_MAX_BLOB       reject "set" of secrets larger than this many
+//	                            bytes with ERROR_INVALID_PARAMETER, as Windows
+//	                            does beyond 2560 (default: no limit)
+
+// win32Error is an error the Credential Manager reports.
+type win32Error struct {
+	code    uint32
+	message string
+}
+
+// win32Errors are the errors MOCK_WINCRED_FAIL_ERROR can name, with the
+// messages Windows gives for them.
+var win32Errors = map[string]win32Error{
+	"ERROR_ACCESS_DENIED":         {5, "Access is denied."},
+	"ERROR_INVALID_PARAMETER":     {87, "The parameter is incorrect."},
+	"ERROR_BUSY":                  {170, "The requested resource is in use."},
+	"ERROR_NOT_FOUND":             {1168, "Element not found."},
+	"ERROR_NO_SUCH_LOGON_SESSION": {1312, "A specified logon session does not exist. It may already have been terminated."},
+	"ERROR_BAD_USERNAME":          {2202, "The specified username is invalid."},
+	"RPC_S_SERVER_UNAVAILABLE":    {1722, "The RPC server is unavailable."},
+	"RPC_S_SERVER_TOO_BUSY":       {1723, "The RPC server is too busy to complete this operation."},
+	"RPC_S_CALL_FAILED":           {1726, "The remote procedure call failed."},
+}
+
+// defaultFaultActions are the actions faults apply to unless
+// MOCK_WINCRED_FAULT_ACTIONS names others.
+var defaultFaultActions = []string{"get", "set", "delete", "list"}
+
+// injectFault delays req and returns the response of an injected failure,
+// as configured by the environment. ok is false if req is to be processed
+// normally. A malformed setting fails the request, so that a typo in a test
+// does not go unnoticed.
+func injectFault(req ipc.Request) (resp ipc.Response, ok bool) {
+	actions := defaultFaultActions
+	if s := os.Getenv("MOCK_WINCRED_FAULT_ACTIONS"); s != "" {
+		actions = strings.Split(s, ",")
+	}
+	if !slices.Contains(actions, req.Action) {
+		return ipc.Response{}, false
+	}
+
+	if s := os.Getenv("MOCK_WINCRED_LATENCY"); s != "" {
+		d, err := parseLatency(s)
+		if err != nil {
+			return ipc.Response{OK: false, Error: fmt.Sprintf("MOCK_WINCRED_LATENCY: %v", err)}, true
+		}
+		time.Sleep(d)
+	}
+
+	if s := os.Getenv("MOCK_WINCRED_MAX_BLOB"); s != "" && req.Action == "set" {
+		limit, err := strconv.Atoi(s)
+		if err != nil {
+			return ipc.Response{OK: false, Error: fmt.Sprintf("MOCK_WINCRED_MAX_BLOB: %v", err)}, true
+		}
+		if secret, err := base64.StdEncoding.DecodeString(req.Secret); err == nil && len(secret) > limit {
+			e := win32Errors["ERROR_INVALID_PARAMETER"]
+			return ipc.Response{OK: false, Error: e.message, Code: e.code}, true
+		}
+	}
+
+	if s := os.Getenv("MOCK_WINCRED_FAIL_RATE"); s != "" {
+		rate, err := strconv.ParseFloat(s, 64)
+		if err != nil {
+			return ipc.Response{OK: false, Error: fmt.Sprintf("MOCK_WINCRED_FAIL_RATE: %v", err)}, true
+		}
+		if rand.Float64() < rate {
+			e, err := parseWin32Error(os.Getenv("MOCK_WINCRED_FAIL_ERROR"))
+			if err != nil {
+				return ipc.Response{OK: false, Error: fmt.Sprintf("MOCK_WINCRED_FAIL_ERROR: %v", err)}, true
+			}
+			return ipc.Response{OK: false, Error: e.message, Code: e.code}, true
+		}
+	}
+	return ipc.Response{}, false
+}
+
+// parseLatency parses a duration or a "min-max" range of durations and
+// returns the delay to apply.
+func parseLatency(s string) (time.Duration, error) {
+	lo, hi, isRange := strings.Cut(s, "-")
+	from, err := time.ParseDuration(lo)
+	if err != nil || !isRange {
+		return from, err
+	}
+	to, err := time.ParseDuration(hi)
+	if err != nil {
+		return 0, err
+	}
+	if to <= from {
+		return from, nil
+	}
+	return from + rand.N(to-from), nil
+}
+
+// parseWin32Error returns the error named by s, a key of win32Errors or an
+// error number.
+func parseWin32Error(s string) (win32Error, error) {
+	if s == "" {
+		s = "ERROR_NO_SUCH_LOGON_SESSION"
+	}
+	if e, ok := win32Errors[s]; ok {
+		return e, nil
+	}
+	code, err := strconv.ParseUint(s, 10, 32)
+	if err != nil {
+		return win32Error{}, fmt.Errorf("unknown Win32 error %q", s)
+	}
+	for _, e := range win32Errors {
+		if e.code == uint32(code) {
+			return e, nil
+		}
+	}
+	return win32Error{uint32(code), fmt.Sprintf("Windows error %d.", code)}, nil
+}
@@ -5,10 +5,29 @@
 // mock-wincred-helper is a Linux-native stand-in for wincred-helper.exe used
 // during development and testing in non-WSL2 environments. It stores secrets as
 // a JSON map in a file specified by the MOCK_WINCRED_STORE environment variable
-// (default: /tmp/mock-wincred-store.json).
+// (default: /tmp/mock-wincred-store.json), together with the comment and
+// attributes sent by "set".
 //
-// Protocol: identical to wincred-helper.exe — reads one JSON request line from
-// stdin, writes one JSON response line to stdout, then exits.
+// Protocol: identical to wincred-helper.exe — answers JSON lines and
+// length-prefixed frames from stdin on stdout until stdin is closed.
+//
+// The "verify" action succeeds unless MOCK_WINCRED_VERIFY is set to a
+// verification result other than "Verified" (e.g. "Canceled"); "confirm"
+// succeeds unless MOCK_WINCRED_CONFIRM is set to "deny"; "password" returns
+// MOCK_WINCRED_PASSWORD and fails as if cancelled if it is unset. "protect"
+// and "unprotect" only add and strip a marker prefix; nothing is encrypted.
+// Credentials of other types than generic are kept under "<type>:<target>";
+// like on Windows, the password of a domain credential cannot be read.
+// If MOCK_WINCRED_TRANSIENT names a file holding a number n, the next n
+// credential requests fail with ERROR_NO_SUCH_LOGON_SESSION, as they do on
+// Windows right after the session is unlocked, and the file is counted down.
+// Latency, random failures with a chosen Win32 error and the Credential
+// Manager's blob size limit can be injected with further MOCK_WINCRED_*
+// variables (see faults.go).
+// --watch <prefix> and --watch-interval poll the store file like
+// wincred-helper.exe polls the Credential Manager.
+// --replay <file> answers with the responses of a recording made with the
+// daemon's --record-helper instead of using the store (see replay.go).
 //
 // Usage:
 //
@@ -18,13 +37,20 @@
 package main
 
 import (
+	"bytes"
+	"encoding/base64"
 	"encoding/json"
+	"flag"
 	"fmt"
+	"io"
 	"os"
+	"strconv"
 	"strings"
 	"syscall"
+	"time"
 
 	"github.com/akihiro/wsl-secret-service/internal/ipc"
+	"github.com/akihiro/wsl-secret-service/internal/version"
 )
 
 func storePath() string {
@@ -34,8 +60,37 @@ func storePath() string {
 	return "/tmp/mock-wincred-store.json"
 }
 
-func loadStore(f *os.File) (map[string]string, error) {
-	store := make(map[string]string)
+// credential is a stored entry. Stores written before comments and
+// attributes were kept hold just the base64 secret, which is accepted too.
+type credential struct {
+	Secret     string            `json:"secret"`
+	Comment    string            `json:"comment,omitempty"`
+	Attributes map[string]string `json:"attributes,omitempty"`
+	Persist    string            `json:"persist,omitempty"`
+
+	UserName    string    `json:"user_name,omitempty"`
+	LastWritten time.Time `json:"last_written,omitzero"`
+}
+
+// storeKey returns the key of the credential target of type typ.
+func storeKey(typ, target string) string {
+	if typ == "" || typ == ipc.CredGeneric {
+		return target
+	}
+	return typ + ":" + target
+}
+
+func (c *credential) UnmarshalJSON(data []byte) error {
+	if len(data) > 0 && data[0] == '"' {
+		*c = credential{}
+		return json.Unmarshal(data, &c.Secret)
+	}
+	type plain credential
+	return json.Unmarshal(data, (*plain)(c))
+}
+
+func loadStore(f *os.File) (map[string]credential, error) {
+	store := make(map[string]credential)
 	info, err := f.Stat()
 	if err != nil {
 		return nil, err
@@ -49,7 +104,7 @@ func loadStore(f *os.File) (map[string]string, error) {
 	return store, nil
 }
 
-func saveStore(f *os.File, store map[string]string) error {
+func saveStore(f *os.File, store map[string]credential) error {
 	if err := f.Truncate(0); err != nil {
 		return err
 	}
@@ -59,93 +114,276 @@ func saveStore(f *os.File, store map[string]string) error {
 	return json.NewEncoder(f).Encode(store)
 }
 
-func handleGet(store map[string]string, target string) ipc.Response {
-	v, ok := store[target]
+func handleGet(store map[string]credential, typ, target string) ipc.Response {
+	c, ok := store[storeKey(typ, target)]
 	if !ok {
 		return ipc.Response{OK: false, Error: "credential not found"}
 	}
-	return ipc.Response{OK: true, Secret: v}
+	if typ == ipc.CredDomainPassword {
+		return ipc.Response{OK: false, Error: "the password of a domain credential cannot be read by applications"}
+	}
+	return ipc.Response{OK: true, Secret: c.Secret}
 }
 
-func handleSet(store map[string]string, target, secret string) ipc.Response {
-	store[target] = secret
+func handleSet(store map[string]credential, req ipc.Request) ipc.Response {
+	if !ipc.ValidPersist(req.Persist) {
+		return ipc.Response{OK: false, Error: fmt.Sprintf("unknown persistence scope %q", req.Persist)}
+	}
+	userName := "wsl-secret-service"
+	if req.Type == ipc.CredDomainPassword {
+		userName = req.Attributes["username"]
+	}
+	store[storeKey(req.Type, req.Target)] = credential{
+		Secret:      req.Secret,
+		Comment:     req.Comment,
+		Attributes:  req.Attributes,
+		Persist:     req.Persist,
+		UserName:    userName,
+		LastWritten: time.Now().UTC(),
+	}
 	return ipc.Response{OK: true}
 }
 
-func handleDelete(store map[string]string, target string) ipc.Response {
-	if _, ok := store[target]; !ok {
+func handleDelete(store map[string]credential, typ, target string) ipc.Response {
+	key := storeKey(typ, target)
+	if _, ok := store[key]; !ok {
 		return ipc.Response{OK: false, Error: "credential not found"}
 	}
-	delete(store, target)
+	delete(store, key)
 	return ipc.Response{OK: true}
 }
 
-func handleList(store map[string]string, filter string) ipc.Response {
-	targets := []string{}
-	for k := range store {
-		if strings.HasPrefix(k, filter) {
-			targets = append(targets, k)
+func handleList(store map[string]credential, typ, filter string, details bool) ipc.Response {
+	resp := ipc.Response{OK: true, Targets: []string{}}
+	prefix := storeKey(typ, "")
+	for k, c := range store {
+		k, ok := strings.CutPrefix(k, prefix)
+		if !ok || !strings.HasPrefix(k, filter) || prefix == "" && strings.HasPrefix(k, ipc.CredDomainPassword+":") {
+			continue
+		}
+		resp.Targets = append(resp.Targets, k)
+		if details {
+			resp.Entries = append(resp.Entries, ipc.Entry{Target: k, UserName: c.UserName, LastWritten: c.LastWritten, Comment: c.Comment, Attributes: c.Attributes})
 		}
 	}
-	return ipc.Response{OK: true, Targets: targets}
+	return resp
 }
 
-func writeResponse(r ipc.Response) {
-	_ = json.NewEncoder(os.Stdout).Encode(r)
+func handleVerify() ipc.Response {
+	if r := os.Getenv("MOCK_WINCRED_VERIFY"); r != "" && r != "Verified" {
+		return ipc.Response{OK: false, Error: "verification result: " + r}
+	}
+	return ipc.Response{OK: true}
+}
+
+func handleConfirm() ipc.Response {
+	if os.Getenv("MOCK_WINCRED_CONFIRM") == "deny" {
+		return ipc.Response{OK: false, Error: "denied by the user"}
+	}
+	return ipc.Response{OK: true}
+}
+
+func handlePassword() ipc.Response {
+	pw, ok := os.LookupEnv("MOCK_WINCRED_PASSWORD")
+	if !ok {
+		return ipc.Response{OK: false, Error: "cancelled by the user"}
+	}
+	return ipc.Response{OK: true, Secret: base64.StdEncoding.EncodeToString([]byte(pw))}
+}
+
+// mockDPAPIPrefix marks data "protected" by the mock helper.
+const mockDPAPIPrefix = "mock-dpapi:"
+
+func handleProtect(dataB64 string) ipc.Response {
+	data, err := base64.StdEncoding.DecodeString(dataB64)
+	if err != nil {
+		return ipc.Response{OK: false, Error: fmt.Sprintf("decode base64 data: %v", err)}
+	}
+	return ipc.Response{OK: true, Secret: base64.StdEncoding.EncodeToString(append([]byte(mockDPAPIPrefix), data...))}
+}
+
+func handleUnprotect(dataB64 string) ipc.Response {
+	data, err := base64.StdEncoding.DecodeString(dataB64)
+	if err != nil {
+		return ipc.Response{OK: false, Error: fmt.Sprintf("decode base64 data: %v", err)}
+	}
+	plain, ok := bytes.CutPrefix(data, []byte(mockDPAPIPrefix))
+	if !ok {
+		return ipc.Response{OK: false, Error: "The data is invalid."}
+	}
+	return ipc.Response{OK: true, Secret: base64.StdEncoding.EncodeToString(plain)}
+}
+
+// failTransient reports whether a request for action should fail with a
+// transient error, counting down the file named by MOCK_WINCRED_TRANSIENT.
+func failTransient(action string) bool {
+	path := os.Getenv("MOCK_WINCRED_TRANSIENT")
+	switch {
+	case path == "":
+		return false
+	case action != "get" && action != "set" && action != "delete" && action != "list":
+		return false
+	}
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return false
+	}
+	n, err := strconv.Atoi(strings.TrimSpace(string(b)))
+	if err != nil || n <= 0 {
+		return false
+	}
+	_ = os.WriteFile(path, []byte(strconv.Itoa(n-1)), 0o600)
+	return true
+}
+
+// snapshot returns the last write time of every generic credential under
+// prefix, for --watch.
+func snapshot(prefix string) (map[string]time.Time, error) {
+	f, err := os.OpenFile(storePath(), os.O_RDONLY|os.O_CREATE, 0o600)
+	if err != nil {
+		return nil, fmt.Errorf("open store: %w", err)
+	}
+	defer f.Close()
+	if err := syscall.Flock(int(f.Fd()), syscall.LOCK_SH); err != nil {
+		return nil, fmt.Errorf("lock store: %w", err)
+	}
+	store, err := loadStore(f)
+	if err != nil {
+		return nil, fmt.Errorf("load store: %w", err)
+	}
+	m := make(map[string]time.Time)
+	for k, c := range store {
+		if strings.HasPrefix(k, prefix) {
+			m[k] = c.LastWritten
+		}
+	}
+	return m, nil
 }
 
 func main() {
-	var req ipc.Request
-	if err := json.NewDecoder(os.Stdin).Decode(&req); err != nil {
-		writeResponse(ipc.Response{OK: false, Error: fmt.Sprintf("decode request: %v", err)})
-		os.Exit(1)
+	flag.Bool("serve", false, "answer requests until stdin is closed (the default)")
+	watchPrefix := flag.String("watch", "", "report changes to credentials under this prefix instead of answering requests")
+	watchInterval := flag.Duration("watch-interval", 2*time.Second, "how often --watch polls the store")
+	replayPath := flag.String("replay", "", "answer with the responses recorded in this file by --record-helper")
+	flag.Parse()
+	if *watchPrefix != "" {
+		if err := ipc.Watch(os.Stdout, os.Stdin, *watchInterval, func() (map[string]time.Time, error) {
+			return snapshot(*watchPrefix)
+		}); err != nil {
+			fmt.Fprintf(os.Stderr, "mock-wincred-helper: %v\n", err)
+			os.Exit(1)
+		}
+		return
 	}
 
+	var rp *replayer
+	if *replayPath != "" {
+		var err error
+		if rp, err = loadReplay(*replayPath); err != nil {
+			fmt.Fprintf(os.Stderr, "mock-wincred-helper: %v\n", err)
+			os.Exit(1)
+		}
+	}
+
+	conn := ipc.NewServerConn(os.Stdin, os.Stdout)
+	for {
+		req, err := conn.ReadRequest()
+		if err == io.EOF {
+			return
+		}
+		if err != nil {
+			_ = conn.WriteResponse(ipc.Response{OK: false, Error: fmt.Sprintf("decode request: %v", err)})
+			os.Exit(1)
+		}
+		if rp != nil {
+			resp, err := rp.respond(req)
+			if err != nil {
+				// Fail the exchange as the recorded helper did.
+				fmt.Fprintf(os.Stderr, "mock-wincred-helper: %v\n", err)
+				os.Exit(1)
+			}
+			_ = conn.WriteResponse(resp)
+			continue
+		}
+		resp, err := process(req)
+		if err != nil {
+			resp = ipc.Response{OK: false, Error: err.Error()}
+		}
+		_ = conn.WriteResponse(resp)
+	}
+}
+
+// process executes one request against the store file, holding an exclusive
+// lock for its duration. A non-nil error means the store itself could not be
+// accessed.
+func process(req ipc.Request) (ipc.Response, error) {
+	// Injected faults come before the store is locked, so that a slow
+	// request does not hold up the others.
+	if resp, ok := injectFault(req); ok {
+		return resp, nil
+	}
 	f, err := os.OpenFile(storePath(), os.O_RDWR|os.O_CREATE, 0o600)
 	if err != nil {
-		writeResponse(ipc.Response{OK: false, Error: fmt.Sprintf("open store: %v", err)})
-		os.Exit(1)
+		return ipc.Response{}, fmt.Errorf("open store: %w", err)
 	}
 	defer f.Close()
 
 	if err := syscall.Flock(int(f.Fd()), syscall.LOCK_EX); err != nil {
-		writeResponse(ipc.Response{OK: false, Error: fmt.Sprintf("lock store: %v", err)})
-		os.Exit(1)
+		return ipc.Response{}, fmt.Errorf("lock store: %w", err)
 	}
 	defer syscall.Flock(int(f.Fd()), syscall.LOCK_UN) //nolint:errcheck
 
 	store, err := loadStore(f)
 	if err != nil {
-		writeResponse(ipc.Response{OK: false, Error: fmt.Sprintf("load store: %v", err)})
-		os.Exit(1)
+		return ipc.Response{}, fmt.Errorf("load store: %w", err)
 	}
 
 	var resp ipc.Response
 	var mutated bool
 
+	if r, ok := ipc.CheckRequestVersion(req); !ok {
+		return r, nil
+	}
+	if !ipc.ValidCredType(req.Type) {
+		return ipc.Response{OK: false, Error: fmt.Sprintf("unknown credential type %q", req.Type)}, nil
+	}
+	if failTransient(req.Action) {
+		e := win32Errors["ERROR_NO_SUCH_LOGON_SESSION"]
+		return ipc.Response{OK: false, Error: e.message, Code: e.code}, nil
+	}
 	switch req.Action {
+	case "hello":
+		resp = ipc.Hello(version.String())
 	case "get":
-		resp = handleGet(store, req.Target)
+		resp = handleGet(store, req.Type, req.Target)
 	case "set":
-		resp = handleSet(store, req.Target, req.Secret)
+		resp = handleSet(store, req)
 		mutated = true
 	case "delete":
-		resp = handleDelete(store, req.Target)
+		resp = handleDelete(store, req.Type, req.Target)
 		if resp.OK {
 			mutated = true
 		}
 	case "list":
-		resp = handleList(store, req.Filter)
+		resp = handleList(store, req.Type, req.Filter, req.Details)
+	case "verify":
+		resp = handleVerify()
+	case "confirm":
+		resp = handleConfirm()
+	case "password":
+		resp = handlePassword()
+	case "protect":
+		resp = handleProtect(req.Secret)
+	case "unprotect":
+		resp = handleUnprotect(req.Secret)
 	default:
 		resp = ipc.Response{OK: false, Error: fmt.Sprintf("unknown action: %q", req.Action)}
 	}
 
 	if mutated && resp.OK {
 		if err := saveStore(f, store); err != nil {
-			writeResponse(ipc.Response{OK: false, Error: fmt.Sprintf("save store: %v", err)})
-			os.Exit(1)
+			return ipc.Response{}, fmt.Errorf("save store: %w", err)
 		}
 	}
-
-	writeResponse(resp)
+	return resp, nil
 }
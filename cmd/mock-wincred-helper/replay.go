@@ -0,0 +1,105 @@
+// SPDX-License-Identifier: Apache-2.0
+
+//go:build !windows
+
+package main
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"os"
+	"syscall"
+
+	"github.com/akihiro/wsl-secret-service/internal/ipc"
+)
+
+// replayer answers requests with the responses of a recording made with
+// --record-helper, instead of using the store.
+//
+// A request gets the response recorded for the first request with the same
+// action, credential type, target and filter that it has not been given
+// yet; once all of those are used up, the last one is repeated. Since the
+// daemon may start a helper per call, the number of responses given so far
+// is kept in <recording>.state, which is removed to start over.
+type replayer struct {
+	records   []ipc.Record
+	statePath string
+}
+
+func loadReplay(path string) (*replayer, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	records, err := ipc.ReadRecords(f)
+	if err != nil {
+		return nil, fmt.Errorf("read recording %s: %w", path, err)
+	}
+	return &replayer{records: records, statePath: path + ".state"}, nil
+}
+
+// replayKey identifies the requests that share recorded responses.
+func replayKey(req ipc.Request) string {
+	return fmt.Sprintf("%s\x00%s\x00%s\x00%s\x00%t", req.Action, req.Type, req.Target, req.Filter, req.Details)
+}
+
+// respond returns the recorded outcome of req: a response, or the error
+// the exchange failed with.
+func (r *replayer) respond(req ipc.Request) (ipc.Response, error) {
+	key := replayKey(req)
+	var matches []ipc.Record
+	for _, rec := range r.records {
+		if replayKey(rec.Request) == key {
+			matches = append(matches, rec)
+		}
+	}
+	if len(matches) == 0 {
+		return ipc.Response{OK: false, Error: fmt.Sprintf("no recorded response to %q of %q", req.Action, req.Target)}, nil
+	}
+	n, err := r.next(key)
+	if err != nil {
+		return ipc.Response{}, err
+	}
+	rec := matches[min(n, len(matches)-1)]
+	if rec.Error != "" || rec.Response == nil {
+		return ipc.Response{}, fmt.Errorf("recorded failure: %s", rec.Error)
+	}
+	resp := *rec.Response
+	if rec.ResponseSecretLen > 0 {
+		resp.Secret = base64.StdEncoding.EncodeToString(bytes.Repeat([]byte("x"), rec.ResponseSecretLen))
+	}
+	return resp, nil
+}
+
+// next returns how many responses to requests with key were given before,
+// and counts this one.
+func (r *replayer) next(key string) (int, error) {
+	f, err := os.OpenFile(r.statePath, os.O_RDWR|os.O_CREATE, 0o600)
+	if err != nil {
+		return 0, fmt.Errorf("open replay state: %w", err)
+	}
+	defer f.Close()
+	if err := syscall.Flock(int(f.Fd()), syscall.LOCK_EX); err != nil {
+		return 0, fmt.Errorf("lock replay state: %w", err)
+	}
+	defer syscall.Flock(int(f.Fd()), syscall.LOCK_UN) //nolint:errcheck
+
+	given := make(map[string]int)
+	if info, err := f.Stat(); err == nil && info.Size() > 0 {
+		if err := json.NewDecoder(f).Decode(&given); err != nil {
+			return 0, fmt.Errorf("decode replay state: %w", err)
+		}
+	}
+	n := given[key]
+	given[key]++
+	if err := f.Truncate(0); err != nil {
+		return 0, err
+	}
+	if _, err := f.Seek(0, 0); err != nil {
+		return 0, err
+	}
+	return n, json.NewEncoder(f).Encode(given)
+}
@@ -0,0 +1,40 @@
+// SPDX-License-Identifier: Apache-2.0
+
+//go:build windows
+
+package main
+
+import (
+	"fmt"
+
+	"golang.org/x/sys/windows"
+
+	"github.com/akihiro/wsl-secret-service/internal/ipc"
+)
+
+// idYes is the MessageBox result of the Yes button.
+const idYes = 6
+
+// handleConfirm shows message in a topmost Yes/No message box, owned by
+// the window titled window if there is one, and succeeds only if the user
+// chooses Yes. No is the default button, so a stray Enter does not release
+// a secret.
+func handleConfirm(message, window string) ipc.Response {
+	if message == "" {
+		message = "A WSL application wants to read a secret."
+	}
+	text, err := windows.UTF16PtrFromString(message + "\n\nAllow access?")
+	if err != nil {
+		return errorResponse(fmt.Sprintf("encode message: %v", err))
+	}
+	caption, _ := windows.UTF16PtrFromString("wsl-secret-service")
+	ret, err := windows.MessageBox(ownerWindow(window), text, caption,
+		windows.MB_YESNO|windows.MB_ICONQUESTION|windows.MB_DEFBUTTON2|windows.MB_TOPMOST|windows.MB_SETFOREGROUND)
+	if ret == 0 {
+		return errorResponse(fmt.Sprintf("show confirmation dialog: %v", err))
+	}
+	if ret != idYes {
+		return errorResponse("denied by the user")
+	}
+	return ipc.Response{OK: true}
+}
@@ -0,0 +1,93 @@
+// SPDX-License-Identifier: Apache-2.0
+
+//go:build windows
+
+package main
+
+import (
+	"errors"
+	"maps"
+	"slices"
+	"unicode/utf16"
+
+	"github.com/danieljoos/wincred"
+
+	"github.com/akihiro/wsl-secret-service/internal/ipc"
+)
+
+// errUnreadable is returned by readSecret for a domain credential, whose
+// password only Windows itself can read.
+var errUnreadable = errors.New("the password of a domain credential cannot be read by applications")
+
+// credential is a credential of one type (ipc.Cred*).
+type credential interface {
+	Write() error
+	Delete() error
+}
+
+// readCredential reads the credential target of type typ.
+func readCredential(typ, target string) (*wincred.Credential, credential, error) {
+	if typ == ipc.CredDomainPassword {
+		c, err := wincred.GetDomainPassword(target)
+		if err != nil {
+			return nil, nil, err
+		}
+		return &c.Credential, c, nil
+	}
+	c, err := wincred.GetGenericCredential(target)
+	if err != nil {
+		return nil, nil, err
+	}
+	return &c.Credential, c, nil
+}
+
+// readSecret returns the secret of cred of type typ. Domain passwords are
+// stored as UTF-16 and returned as UTF-8.
+func readSecret(typ string, cred *wincred.Credential) ([]byte, error) {
+	if typ != ipc.CredDomainPassword {
+		return cred.CredentialBlob, nil
+	}
+	if len(cred.CredentialBlob) == 0 {
+		return nil, errUnreadable
+	}
+	u := make([]uint16, len(cred.CredentialBlob)/2)
+	for i := range u {
+		u[i] = uint16(cred.CredentialBlob[2*i]) | uint16(cred.CredentialBlob[2*i+1])<<8
+	}
+	return []byte(string(utf16.Decode(u))), nil
+}
+
+// newCredential prepares the credential target of type typ for writing
+// secret. A domain credential keeps the user name, comment and attributes
+// it already has, as Windows uses it to log on; a new one takes its user
+// name from the "username" attribute of req.
+func newCredential(req ipc.Request, secret []byte) (*wincred.Credential, credential) {
+	if req.Type == ipc.CredDomainPassword {
+		c, err := wincred.GetDomainPassword(req.Target)
+		if err != nil {
+			c = wincred.NewDomainPassword(req.Target)
+			c.UserName = req.Attributes["username"]
+		}
+		c.SetPassword(string(secret))
+		return &c.Credential, c
+	}
+	c := wincred.NewGenericCredential(req.Target)
+	c.CredentialBlob = secret
+	c.UserName = "wsl-secret-service"
+	c.Comment = req.Comment
+	for _, k := range slices.Sorted(maps.Keys(req.Attributes)) {
+		c.Attributes = append(c.Attributes, wincred.CredentialAttribute{Keyword: k, Value: []byte(req.Attributes[k])})
+	}
+	return &c.Credential, c
+}
+
+// hasType reports whether a credential named target of type typ exists.
+// wincred.FilteredList returns credentials of every type without saying
+// which, so listing other types than generic checks each result.
+func hasType(typ, target string) bool {
+	if typ != ipc.CredDomainPassword {
+		return true
+	}
+	_, err := wincred.GetDomainPassword(target)
+	return err == nil
+}
@@ -0,0 +1,53 @@
+// SPDX-License-Identifier: Apache-2.0
+
+//go:build windows
+
+package main
+
+import (
+	"encoding/base64"
+	"fmt"
+	"unsafe"
+
+	"golang.org/x/sys/windows"
+
+	"github.com/akihiro/wsl-secret-service/internal/ipc"
+)
+
+// handleProtect encrypts data (base64-encoded) with CryptProtectData under
+// the current user's DPAPI master key and returns the base64 ciphertext.
+func handleProtect(dataB64 string) ipc.Response {
+	return dpapi(dataB64, func(in, out *windows.DataBlob) error {
+		return windows.CryptProtectData(in, nil, nil, 0, nil, windows.CRYPTPROTECT_UI_FORBIDDEN, out)
+	})
+}
+
+// handleUnprotect reverses handleProtect with CryptUnprotectData.
+func handleUnprotect(dataB64 string) ipc.Response {
+	return dpapi(dataB64, func(in, out *windows.DataBlob) error {
+		return windows.CryptUnprotectData(in, nil, nil, 0, nil, windows.CRYPTPROTECT_UI_FORBIDDEN, out)
+	})
+}
+
+// dpapi decodes dataB64, passes it through fn and returns the result
+// base64-encoded, freeing the buffer DPAPI allocated for it.
+func dpapi(dataB64 string, fn func(in, out *windows.DataBlob) error) ipc.Response {
+	data, err := base64.StdEncoding.DecodeString(dataB64)
+	if err != nil {
+		return errorResponse(fmt.Sprintf("decode base64 data: %v", err))
+	}
+	var in windows.DataBlob
+	if len(data) > 0 {
+		in = windows.DataBlob{Size: uint32(len(data)), Data: &data[0]}
+	}
+	var out windows.DataBlob
+	if err := fn(&in, &out); err != nil {
+		return windowsErrorResponse(err)
+	}
+	defer windows.LocalFree(windows.Handle(unsafe.Pointer(out.Data))) //nolint:errcheck
+	result := unsafe.Slice(out.Data, out.Size)
+	resp := ipc.Response{OK: true, Secret: base64.StdEncoding.EncodeToString(result)}
+	clear(result)
+	clear(data)
+	return resp
+}
@@ -6,113 +6,246 @@
 // It is cross-compiled (GOOS=windows) and called from WSL2 via
 // interop whenever the Linux daemon needs to access the Windows Credential Manager.
 //
-// Protocol: reads one JSON request line from stdin, writes one JSON response
-// line to stdout, then exits. Exit code 0 means the response was written
-// (including error responses where ok=false). Non-zero exit means a fatal error
-// before a response could be written.
+// Protocol: reads requests from stdin and writes one response per request
+// to stdout until stdin is closed. Each request is either a JSON line or,
+// from protocol version 3 on, a length-prefixed frame (see ipc.WriteFrame)
+// carrying the secret as raw bytes; it is answered in the same encoding.
+// The daemon sends one JSON line per helper process, or a batch of frames.
+// Exit code 0 means every response was written (including error responses
+// where ok=false). Non-zero exit means a request could not be decoded.
+//
+// --serve is accepted for daemons that start a persistent helper with it;
+// the protocol is the same.
+//
+// With --listen-vsock <port> the helper instead runs as a resident process
+// listening on the Hyper-V socket that the WSL2 VM reaches as vsock port
+// <port> on the host, and answers every connection the same way
+// (wsl-secret-service --helper-vsock-port). It accepts connections from
+// the WSL utility VM only (--vm-id, or the one found through the Host
+// Compute Service), and writes a new random token to --vsock-token-file
+// on every start; a connection must present it in a "hello" before any
+// other request is served.
+//
+// With --watch <prefix> the helper answers no requests but polls the
+// Credential Manager every --watch-interval for credentials under
+// <prefix> and writes an ipc.Event JSON line for each one created, changed
+// or deleted, until stdin is closed. Windows offers no change notifications
+// for credentials, so polling is the only way.
 //
 // Request fields:
 //
-//	action  string  "get" | "set" | "delete" | "list"
-//	target  string  Windows Credential Manager TargetName
-//	secret  string  base64-encoded CredentialBlob (only for "set")
+//	action  string  "hello" | "get" | "set" | "delete" | "list" | "verify" | "confirm" | "password" | "protect" | "unprotect"
+//	target  string  Windows Credential Manager TargetName, or the collection
+//	                whose password is asked for (only for "password")
+//	secret  string  base64-encoded CredentialBlob (only for "set"), or the
+//	                data to encrypt/decrypt for "protect"/"unprotect"
 //	filter  string  TargetName prefix for "list"
+//	details bool    "list" also returns the comment and attributes of each entry
+//	message string  text shown in the Windows Hello dialog (only for "verify")
+//	                or the Allow/Deny dialog (only for "confirm") or the
+//	                password dialog (only for "password")
+//	window  string  title of the window the dialog of "confirm" or "password"
+//	                belongs to; the dialog is owned by it if it is found
+//	version int     protocol version of the daemon; requests from a newer
+//	                daemon are rejected
+//	daemon_version string  release version of the daemon (only for "hello")
+//	token   string  the token of a resident helper (only for "hello", which
+//	                must be the first request on a vsock connection)
+//	comment string  Comment of the credential, the item label (only for "set")
+//	attributes map[string]string  credential attributes (only for "set"): the
+//	                item attributes and ipc.CollectionAttribute
+//	persist string  persistence scope of a new credential (only for "set")
+//	type    string  credential type, "generic" (the default) or
+//	                "domain_password"
 //
 // Response fields:
 //
 //	ok      bool
-//	secret  string  base64-encoded CredentialBlob (only for "get")
+//	secret  string  base64-encoded CredentialBlob (only for "get"), the
+//	                entered password (only for "password"), or the DPAPI
+//	                result for "protect"/"unprotect"
 //	targets []string  matched TargetNames (only for "list")
+//	entries []object  target, user name, last write time, comment and
+//	                attributes of each matched credential (only for "list"
+//	                with details)
 //	error   string  human-readable error (only when ok=false)
+//	code    uint32  Windows error code behind error, if known
+//	version int     protocol version of the helper (only for "hello")
+//	helper_version string  release version of the helper (only for "hello")
 package main
 
 import (
 	"encoding/base64"
-	"encoding/json"
+	"errors"
+	"flag"
 	"fmt"
+	"io"
 	"os"
 	"strings"
+	"syscall"
+	"time"
 
 	"github.com/danieljoos/wincred"
 	"github.com/akihiro/wsl-secret-service/internal/ipc"
+	"github.com/akihiro/wsl-secret-service/internal/version"
 )
 
 func main() {
-	var req ipc.Request
-	dec := json.NewDecoder(os.Stdin)
-	if err := dec.Decode(&req); err != nil {
-		writeError(fmt.Sprintf("decode request: %v", err))
+	showVersion := flag.Bool("version", false, "print the version, commit and build date, and exit")
+	flag.Bool("serve", false, "answer requests until stdin is closed (the default)")
+	vsockPort := flag.Uint("listen-vsock", 0, "run resident, serving requests on this Hyper-V socket (vsock) port")
+	vmID := flag.String("vm-id", "", "with --listen-vsock, the id of the VM to accept connections from (default: the running WSL utility VM)")
+	tokenFile := flag.String("vsock-token-file", "", `with --listen-vsock, where to write the token clients must present (default: %LOCALAPPDATA%\wsl-secret-service\vsock-<port>.token)`)
+	watchPrefix := flag.String("watch", "", "report changes to credentials under this TargetName prefix instead of answering requests")
+	watchInterval := flag.Duration("watch-interval", 2*time.Second, "how often --watch polls the Credential Manager")
+	flag.Parse()
+	if *showVersion {
+		fmt.Println("wincred-helper", version.Long())
+		return
+	}
+
+	if *watchPrefix != "" {
+		if err := ipc.Watch(os.Stdout, os.Stdin, *watchInterval, func() (map[string]time.Time, error) {
+			return snapshot(*watchPrefix)
+		}); err != nil {
+			fmt.Fprintf(os.Stderr, "wincred-helper: %v\n", err)
+			os.Exit(1)
+		}
+		return
+	}
+
+	if *vsockPort != 0 {
+		if err := listenVsock(uint32(*vsockPort), *vmID, *tokenFile); err != nil {
+			fmt.Fprintf(os.Stderr, "wincred-helper: %v\n", err)
+			os.Exit(1)
+		}
+		return
+	}
+	if err := serve(ipc.NewServerConn(os.Stdin, os.Stdout)); err != nil {
 		os.Exit(1)
 	}
+}
 
+// serve answers requests on conn until the client closes it. A request
+// that cannot be decoded is answered with an error and ends the connection.
+func serve(conn *ipc.ServerConn) error {
+	for {
+		req, err := conn.ReadRequest()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			err = fmt.Errorf("decode request: %w", err)
+			_ = conn.WriteResponse(errorResponse(err.Error()))
+			return err
+		}
+		if err := conn.WriteResponse(handle(req)); err != nil {
+			return err
+		}
+	}
+}
+
+// handle dispatches a single request to its action handler.
+func handle(req ipc.Request) ipc.Response {
+	if resp, ok := ipc.CheckRequestVersion(req); !ok {
+		return resp
+	}
+	if !ipc.ValidCredType(req.Type) {
+		return errorResponse(fmt.Sprintf("unknown credential type %q", req.Type))
+	}
 	switch req.Action {
+	case "hello":
+		return ipc.Hello(version.String())
 	case "get":
-		handleGet(req.Target)
+		return handleGet(req.Type, req.Target)
 	case "set":
-		handleSet(req.Target, req.Secret)
+		return handleSet(req)
 	case "delete":
-		handleDelete(req.Target)
+		return handleDelete(req.Type, req.Target)
 	case "list":
-		handleList(req.Filter)
+		return handleList(req.Type, req.Filter, req.Details)
+	case "verify":
+		return handleVerify(req.Message)
+	case "confirm":
+		return handleConfirm(req.Message, req.Window)
+	case "password":
+		return handlePassword(req.Target, req.Message, req.Window)
+	case "protect":
+		return handleProtect(req.Secret)
+	case "unprotect":
+		return handleUnprotect(req.Secret)
 	default:
-		writeError(fmt.Sprintf("unknown action: %q", req.Action))
-		os.Exit(1)
+		return errorResponse(fmt.Sprintf("unknown action: %q", req.Action))
 	}
 }
 
-// handleGet retrieves a generic credential from Windows Credential Manager
-// and writes its CredentialBlob (base64-encoded) in the response.
-func handleGet(target string) {
-	cred, err := wincred.GetGenericCredential(target)
+// handleGet retrieves a credential of type typ from Windows Credential
+// Manager and returns its secret (base64-encoded) in the response.
+func handleGet(typ, target string) ipc.Response {
+	cred, _, err := readCredential(typ, target)
 	if err != nil {
-		writeError(err.Error())
-		return
+		return windowsErrorResponse(err)
+	}
+	secret, err := readSecret(typ, cred)
+	if err != nil {
+		return errorResponse(err.Error())
 	}
-	writeOK(ipc.Response{
+	return ipc.Response{
 		OK:     true,
-		Secret: base64.StdEncoding.EncodeToString(cred.CredentialBlob),
-	})
+		Secret: base64.StdEncoding.EncodeToString(secret),
+	}
 }
 
-// handleSet stores secret bytes (base64-encoded in request) as a generic
-// credential in Windows Credential Manager with PersistLocalMachine scope.
-func handleSet(target, secretB64 string) {
-	secretBytes, err := base64.StdEncoding.DecodeString(secretB64)
+// handleSet stores secret bytes (base64-encoded in request) as a
+// credential of the request's type (generic by default) in Windows
+// Credential Manager with the request's persistence scope
+// (PersistLocalMachine by default), comment and attributes, so that the
+// entry can be recognised in the Credential Manager UI.
+func handleSet(req ipc.Request) ipc.Response {
+	secretBytes, err := base64.StdEncoding.DecodeString(req.Secret)
 	if err != nil {
-		writeError(fmt.Sprintf("decode base64 secret: %v", err))
-		return
+		return errorResponse(fmt.Sprintf("decode base64 secret: %v", err))
 	}
 
-	cred := wincred.NewGenericCredential(target)
-	cred.CredentialBlob = secretBytes
-	cred.UserName = "wsl-secret-service"
-	cred.Persist = wincred.PersistLocalMachine
-	if err := cred.Write(); err != nil {
-		writeError(err.Error())
-		return
+	cred, w := newCredential(req, secretBytes)
+	switch req.Persist {
+	case "":
+		// New credentials are created with PersistLocalMachine.
+	case ipc.PersistLocalMachine:
+		cred.Persist = wincred.PersistLocalMachine
+	case ipc.PersistSession:
+		cred.Persist = wincred.PersistSession
+	case ipc.PersistEnterprise:
+		cred.Persist = wincred.PersistEnterprise
+	default:
+		return errorResponse(fmt.Sprintf("unknown persistence scope %q", req.Persist))
 	}
-	writeOK(ipc.Response{OK: true})
+	if err := w.Write(); err != nil {
+		return windowsErrorResponse(err)
+	}
+	return ipc.Response{OK: true}
 }
 
-// handleDelete removes a generic credential from Windows Credential Manager.
-func handleDelete(target string) {
-	cred, err := wincred.GetGenericCredential(target)
+// handleDelete removes a credential of type typ from Windows Credential
+// Manager.
+func handleDelete(typ, target string) ipc.Response {
+	_, cred, err := readCredential(typ, target)
 	if err != nil {
-		writeError(err.Error())
-		return
+		return windowsErrorResponse(err)
 	}
 	if err := cred.Delete(); err != nil {
-		writeError(err.Error())
-		return
+		return windowsErrorResponse(err)
 	}
-	writeOK(ipc.Response{OK: true})
+	return ipc.Response{OK: true}
 }
 
-// handleList returns all TargetNames whose prefix matches filter.
+// handleList returns all TargetNames of credentials of type typ whose
+// prefix matches filter, and with details their user names, times of the
+// last change, comments and attributes.
 // wincred.FilteredList uses a wildcard suffix internally; we pass filter+"*"
 // to match all credentials under that prefix, then strip any trailing wildcard
 // characters from results for clean output.
-func handleList(filter string) {
+func handleList(typ, filter string, details bool) ipc.Response {
 	// FilteredList accepts a filter string where "*" acts as a wildcard.
 	// Append "*" so we get all entries with the given prefix.
 	pattern := filter
@@ -122,23 +255,55 @@ func handleList(filter string) {
 
 	creds, err := wincred.FilteredList(pattern)
 	if err != nil {
-		writeError(err.Error())
-		return
+		return windowsErrorResponse(err)
 	}
 
-	targets := make([]string, 0, len(creds))
+	resp := ipc.Response{OK: true, Targets: make([]string, 0, len(creds))}
 	for _, c := range creds {
-		targets = append(targets, c.TargetName)
+		if !hasType(typ, c.TargetName) {
+			continue
+		}
+		resp.Targets = append(resp.Targets, c.TargetName)
+		if !details {
+			continue
+		}
+		e := ipc.Entry{Target: c.TargetName, UserName: c.UserName, LastWritten: c.LastWritten.UTC(), Comment: c.Comment}
+		for _, a := range c.Attributes {
+			if e.Attributes == nil {
+				e.Attributes = make(map[string]string, len(c.Attributes))
+			}
+			e.Attributes[a.Keyword] = string(a.Value)
+		}
+		resp.Entries = append(resp.Entries, e)
 	}
-	writeOK(ipc.Response{OK: true, Targets: targets})
+	return resp
 }
 
-func writeOK(r ipc.Response) {
-	enc := json.NewEncoder(os.Stdout)
-	_ = enc.Encode(r)
+// snapshot returns the last write time of every credential whose
+// TargetName starts with prefix, for --watch.
+func snapshot(prefix string) (map[string]time.Time, error) {
+	creds, err := wincred.FilteredList(prefix + "*")
+	if err != nil {
+		return nil, err
+	}
+	m := make(map[string]time.Time, len(creds))
+	for _, c := range creds {
+		m[c.TargetName] = c.LastWritten
+	}
+	return m, nil
+}
+
+func errorResponse(msg string) ipc.Response {
+	return ipc.Response{OK: false, Error: msg}
 }
 
-func writeError(msg string) {
-	enc := json.NewEncoder(os.Stdout)
-	_ = enc.Encode(ipc.Response{OK: false, Error: msg})
+// windowsErrorResponse reports err with its Windows error code, which the
+// daemon uses to retry transient failures.
+func windowsErrorResponse(err error) ipc.Response {
+	resp := errorResponse(err.Error())
+	var errno syscall.Errno
+	if errors.As(err, &errno) {
+		resp.Code = uint32(errno)
+	}
+	return resp
 }
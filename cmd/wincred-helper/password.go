@@ -0,0 +1,95 @@
+// SPDX-License-Identifier: Apache-2.0
+
+//go:build windows
+
+package main
+
+import (
+	"encoding/base64"
+	"fmt"
+	"syscall"
+	"unicode/utf16"
+	"unicode/utf8"
+	"unsafe"
+
+	"golang.org/x/sys/windows"
+
+	"github.com/akihiro/wsl-secret-service/internal/ipc"
+)
+
+// CredUIPromptForCredentials flags and limits from wincred.h.
+const (
+	creduiFlagsDoNotPersist       = 0x2
+	creduiFlagsAlwaysShowUI       = 0x80
+	creduiFlagsGenericCredentials = 0x40000
+	creduiFlagsKeepUsername       = 0x100000
+
+	creduiMaxUsernameLength = 513
+	creduiMaxPasswordLength = 256
+)
+
+// creduiInfo is CREDUI_INFOW.
+type creduiInfo struct {
+	Size    uint32
+	Parent  windows.HWND
+	Message *uint16
+	Caption *uint16
+	Banner  windows.Handle
+}
+
+var (
+	credui           = windows.NewLazySystemDLL("credui.dll")
+	procCredUIPrompt = credui.NewProc("CredUIPromptForCredentialsW")
+)
+
+// handlePassword asks for the password of collection in the classic
+// Windows credential dialog, with the collection shown read-only as the
+// user name, and returns the entered password base64-encoded. The dialog is
+// owned by the window titled window, if there is one. Nothing is saved in
+// Credential Manager.
+func handlePassword(collection, message, window string) ipc.Response {
+	if message == "" {
+		message = "Enter the password of the collection."
+	}
+	text, err := windows.UTF16PtrFromString(message)
+	if err != nil {
+		return errorResponse(fmt.Sprintf("encode message: %v", err))
+	}
+	caption, _ := windows.UTF16PtrFromString("wsl-secret-service")
+	info := creduiInfo{Parent: ownerWindow(window), Message: text, Caption: caption}
+	info.Size = uint32(unsafe.Sizeof(info))
+
+	var user [creduiMaxUsernameLength + 1]uint16
+	copy(user[:creduiMaxUsernameLength], utf16.Encode([]rune(collection)))
+	var pass [creduiMaxPasswordLength + 1]uint16
+	defer clear(pass[:])
+	target, _ := windows.UTF16PtrFromString("wsl-secret-service")
+	var save int32
+	r, _, _ := procCredUIPrompt.Call(
+		uintptr(unsafe.Pointer(&info)),
+		uintptr(unsafe.Pointer(target)),
+		0, 0,
+		uintptr(unsafe.Pointer(&user[0])), uintptr(len(user)),
+		uintptr(unsafe.Pointer(&pass[0])), uintptr(len(pass)),
+		uintptr(unsafe.Pointer(&save)),
+		creduiFlagsGenericCredentials|creduiFlagsDoNotPersist|creduiFlagsAlwaysShowUI|creduiFlagsKeepUsername,
+	)
+	switch syscall.Errno(r) {
+	case 0:
+	case windows.ERROR_CANCELLED:
+		return errorResponse("cancelled by the user")
+	default:
+		return errorResponse(fmt.Sprintf("show password dialog: %v", syscall.Errno(r)))
+	}
+	n := 0
+	for n < len(pass) && pass[n] != 0 {
+		n++
+	}
+	// Convert without an intermediate string so every copy can be cleared.
+	var pw []byte
+	for _, r := range utf16.Decode(pass[:n]) {
+		pw = utf8.AppendRune(pw, r)
+	}
+	defer clear(pw)
+	return ipc.Response{OK: true, Secret: base64.StdEncoding.EncodeToString(pw)}
+}
@@ -0,0 +1,55 @@
+// SPDX-License-Identifier: Apache-2.0
+
+//go:build windows
+
+package main
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+
+	"github.com/akihiro/wsl-secret-service/internal/ipc"
+)
+
+// verifyScript asks Windows Hello (UserConsentVerifier) to verify the user
+// and prints the UserConsentVerificationResult name. The dialog message is
+// passed through the environment so it never has to be quoted into the script.
+const verifyScript = `
+$ErrorActionPreference = 'Stop'
+Add-Type -AssemblyName System.Runtime.WindowsRuntime
+$asTask = [System.WindowsRuntimeSystemExtensions].GetMethods() | Where-Object {
+    $_.Name -eq 'AsTask' -and $_.GetParameters().Count -eq 1 -and
+    $_.GetParameters()[0].ParameterType.Name -eq 'IAsyncOperation` + "`" + `1'
+} | Select-Object -First 1
+$null = [Windows.Security.Credentials.UI.UserConsentVerifier, Windows.Security.Credentials.UI, ContentType = WindowsRuntime]
+$op = [Windows.Security.Credentials.UI.UserConsentVerifier]::RequestVerificationAsync($env:WSL_SS_VERIFY_MESSAGE)
+$task = $asTask.MakeGenericMethod([Windows.Security.Credentials.UI.UserConsentVerificationResult]).Invoke($null, @($op))
+$null = $task.Wait(-1)
+$task.Result.ToString()
+`
+
+// handleVerify shows a Windows Hello prompt with message and succeeds only
+// if the user verified (face, fingerprint or PIN). Any other result, such as
+// "Canceled" or "DeviceNotPresent", is returned as the error.
+//
+// UserConsentVerifier is a WinRT API; it is driven through Windows
+// PowerShell, which ships with every Windows 10/11 installation and can
+// project WinRT types without extra bindings.
+func handleVerify(message string) ipc.Response {
+	if message == "" {
+		message = "wsl-secret-service: allow access to a protected secret?"
+	}
+	cmd := exec.Command("powershell.exe", "-NoProfile", "-NonInteractive", "-Command", verifyScript)
+	cmd.Env = append(os.Environ(), "WSL_SS_VERIFY_MESSAGE="+message)
+	out, err := cmd.Output()
+	if err != nil {
+		return errorResponse(fmt.Sprintf("run UserConsentVerifier: %v", err))
+	}
+	result := strings.TrimSpace(string(out))
+	if result != "Verified" {
+		return errorResponse("verification result: " + result)
+	}
+	return ipc.Response{OK: true}
+}
@@ -0,0 +1,302 @@
+// SPDX-License-Identifier: Apache-2.0
+
+//go:build windows
+
+package main
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+	"unsafe"
+
+	"golang.org/x/sys/windows"
+
+	"github.com/akihiro/wsl-secret-service/internal/ipc"
+)
+
+// Hyper-V socket constants from hvsocket.h.
+const (
+	afHyperV      = 34
+	hvProtocolRaw = 1
+)
+
+// sockaddrHV is SOCKADDR_HV.
+type sockaddrHV struct {
+	Family    uint16
+	Reserved  uint16
+	VMID      windows.GUID
+	ServiceID windows.GUID
+}
+
+var (
+	ws2        = windows.NewLazySystemDLL("ws2_32.dll")
+	procBind   = ws2.NewProc("bind")
+	procAccept = ws2.NewProc("accept")
+
+	vmcompute                      = windows.NewLazySystemDLL("vmcompute.dll")
+	procHcsEnumerateComputeSystems = vmcompute.NewProc("HcsEnumerateComputeSystems")
+)
+
+// vmPollInterval is how often the helper looks for the WSL utility VM
+// while none runs, and checks that the one it listens for still does.
+const vmPollInterval = 5 * time.Second
+
+// vsockServiceID returns the Hyper-V service id that Linux guests reach as
+// vsock port: the VSOCK template GUID with port as its first field.
+func vsockServiceID(port uint32) windows.GUID {
+	return windows.GUID{Data1: port, Data2: 0xfacb, Data3: 0x11e6, Data4: [8]byte{0xbd, 0x58, 0x64, 0x00, 0x6a, 0x79, 0x86, 0xd3}}
+}
+
+// listenVsock serves requests on the Hyper-V socket for vsock port. Only
+// the VM vmID can connect; if vmID is empty, that is the WSL utility VM,
+// which is waited for while it does not run and followed when WSL
+// restarts it with a new id. A new token is written to tokenFile (default
+// %LOCALAPPDATA%\wsl-secret-service\vsock-<port>.token) first; each
+// connection must present it before it is answered like stdin in --serve
+// mode. It only returns on error.
+func listenVsock(port uint32, vmID, tokenFile string) error {
+	var wsa windows.WSAData
+	if err := windows.WSAStartup(uint32(0x202), &wsa); err != nil {
+		return fmt.Errorf("WSAStartup: %w", err)
+	}
+	if tokenFile == "" {
+		tokenFile = filepath.Join(os.Getenv("LOCALAPPDATA"), "wsl-secret-service", fmt.Sprintf("vsock-%d.token", port))
+	}
+	token, err := writeToken(tokenFile)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(os.Stderr, "wincred-helper: token written to %s\n", tokenFile)
+
+	if vmID != "" {
+		id, err := windows.GUIDFromString("{" + strings.Trim(vmID, "{}") + "}")
+		if err != nil {
+			return fmt.Errorf("--vm-id %q: %w", vmID, err)
+		}
+		return listenVM(id, port, token, nil)
+	}
+	for {
+		id, err := waitForWSLVM()
+		if err != nil {
+			return err
+		}
+		gone := make(chan struct{})
+		go func() {
+			defer close(gone)
+			for {
+				time.Sleep(vmPollInterval)
+				if ids, err := wslVMs(); err != nil || !slices.Contains(ids, id) {
+					return
+				}
+			}
+		}()
+		if err := listenVM(id, port, token, gone); err != nil {
+			return err
+		}
+	}
+}
+
+// listenVM accepts connections from VM id until gone is closed, when it
+// returns nil.
+func listenVM(id windows.GUID, port uint32, token string, gone <-chan struct{}) error {
+	s, err := windows.Socket(afHyperV, windows.SOCK_STREAM, hvProtocolRaw)
+	if err != nil {
+		return fmt.Errorf("hyper-v socket: %w", err)
+	}
+	closeSocket := sync.OnceFunc(func() { _ = windows.Closesocket(s) })
+	defer closeSocket()
+
+	addr := sockaddrHV{Family: afHyperV, VMID: id, ServiceID: vsockServiceID(port)}
+	if r, _, err := procBind.Call(uintptr(s), uintptr(unsafe.Pointer(&addr)), unsafe.Sizeof(addr)); r != 0 {
+		return fmt.Errorf("bind vsock port %d of VM %s: %w", port, id, err)
+	}
+	if err := windows.Listen(s, windows.SOMAXCONN); err != nil {
+		return fmt.Errorf("listen on vsock port %d: %w", port, err)
+	}
+	fmt.Fprintf(os.Stderr, "wincred-helper: listening on vsock port %d of VM %s\n", port, id)
+	if gone != nil {
+		// Closing the socket fails the pending accept.
+		stop := make(chan struct{})
+		defer close(stop)
+		go func() {
+			select {
+			case <-gone:
+				closeSocket()
+			case <-stop:
+			}
+		}()
+	}
+	for {
+		r, _, err := procAccept.Call(uintptr(s), 0, 0)
+		if windows.Handle(r) == windows.InvalidHandle {
+			select {
+			case <-gone:
+				fmt.Fprintf(os.Stderr, "wincred-helper: VM %s stopped\n", id)
+				return nil
+			default:
+			}
+			return fmt.Errorf("accept: %w", err)
+		}
+		c := hvConn(r)
+		go func() {
+			defer windows.Closesocket(windows.Handle(c)) //nolint:errcheck
+			if err := serveAuthenticated(ipc.NewServerConn(c, c), token); err != nil {
+				fmt.Fprintf(os.Stderr, "wincred-helper: %v\n", err)
+			}
+		}()
+	}
+}
+
+// serveAuthenticated answers requests on conn like serve once the first
+// request, a "hello", has presented token.
+func serveAuthenticated(conn *ipc.ServerConn, token string) error {
+	req, err := conn.ReadRequest()
+	if err == io.EOF {
+		return nil
+	}
+	if err != nil {
+		err = fmt.Errorf("decode request: %w", err)
+		_ = conn.WriteResponse(errorResponse(err.Error()))
+		return err
+	}
+	if req.Action != "hello" || subtle.ConstantTimeCompare([]byte(req.Token), []byte(token)) != 1 {
+		_ = conn.WriteResponse(errorResponse("vsock connection refused: the hello did not carry the token of this helper"))
+		return errors.New("refused a vsock connection without the token")
+	}
+	if err := conn.WriteResponse(handle(req)); err != nil {
+		return err
+	}
+	return serve(conn)
+}
+
+// writeToken writes a new random token to path, replacing the previous
+// one, and returns it. Under %LOCALAPPDATA%, the file inherits an ACL
+// that lets only the user (and administrators) read it.
+func writeToken(path string) (string, error) {
+	token := rand.Text()
+	if err := os.MkdirAll(filepath.Dir(path), 0o700); err != nil {
+		return "", fmt.Errorf("token file: %w", err)
+	}
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, []byte(token+"\n"), 0o600); err != nil {
+		return "", fmt.Errorf("token file: %w", err)
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		return "", fmt.Errorf("token file: %w", err)
+	}
+	return token, nil
+}
+
+// waitForWSLVM returns the id of the WSL utility VM, waiting for WSL to
+// start it. More than one (as when several users run WSL) is an error:
+// the one to serve must then be given with --vm-id.
+func waitForWSLVM() (windows.GUID, error) {
+	waiting := false
+	for {
+		ids, err := wslVMs()
+		if err != nil {
+			return windows.GUID{}, fmt.Errorf("find the WSL VM (or pass --vm-id): %w", err)
+		}
+		switch len(ids) {
+		case 0:
+			if !waiting {
+				fmt.Fprintln(os.Stderr, "wincred-helper: waiting for the WSL VM to start")
+				waiting = true
+			}
+			time.Sleep(vmPollInterval)
+		case 1:
+			return ids[0], nil
+		default:
+			return windows.GUID{}, fmt.Errorf("%d WSL VMs are running; pass --vm-id", len(ids))
+		}
+	}
+}
+
+// wslVMs asks the Host Compute Service for the running compute systems
+// owned by WSL.
+func wslVMs() ([]windows.GUID, error) {
+	if err := procHcsEnumerateComputeSystems.Find(); err != nil {
+		return nil, err
+	}
+	query, err := windows.UTF16PtrFromString(`{"Owners":["WSL"]}`)
+	if err != nil {
+		return nil, err
+	}
+	var systems, result *uint16
+	hr, _, _ := procHcsEnumerateComputeSystems.Call(uintptr(unsafe.Pointer(query)), uintptr(unsafe.Pointer(&systems)), uintptr(unsafe.Pointer(&result)))
+	list, detail := takeCoTaskString(systems), takeCoTaskString(result)
+	if int32(hr) < 0 {
+		return nil, fmt.Errorf("HcsEnumerateComputeSystems: %w %s", windows.Errno(hr), detail)
+	}
+	var found []struct {
+		ID         string `json:"Id"`
+		Owner      string `json:"Owner"`
+		SystemType string `json:"SystemType"`
+	}
+	if err := json.Unmarshal([]byte(list), &found); err != nil {
+		return nil, fmt.Errorf("parse compute systems: %w", err)
+	}
+	var ids []windows.GUID
+	for _, cs := range found {
+		if cs.Owner != "WSL" || cs.SystemType != "VirtualMachine" {
+			continue
+		}
+		id, err := windows.GUIDFromString("{" + cs.ID + "}")
+		if err != nil {
+			return nil, fmt.Errorf("compute system id %q: %w", cs.ID, err)
+		}
+		ids = append(ids, id)
+	}
+	return ids, nil
+}
+
+// takeCoTaskString converts a string allocated by the callee and frees it.
+func takeCoTaskString(p *uint16) string {
+	if p == nil {
+		return ""
+	}
+	s := windows.UTF16PtrToString(p)
+	windows.CoTaskMemFree(unsafe.Pointer(p))
+	return s
+}
+
+// hvConn is an accepted Hyper-V socket.
+type hvConn windows.Handle
+
+func (c hvConn) Read(p []byte) (int, error) {
+	if len(p) == 0 {
+		return 0, nil
+	}
+	buf := windows.WSABuf{Len: uint32(len(p)), Buf: &p[0]}
+	var n, flags uint32
+	if err := windows.WSARecv(windows.Handle(c), &buf, 1, &n, &flags, nil, nil); err != nil {
+		return 0, err
+	}
+	if n == 0 {
+		return 0, io.EOF
+	}
+	return int(n), nil
+}
+
+func (c hvConn) Write(p []byte) (int, error) {
+	written := 0
+	for written < len(p) {
+		buf := windows.WSABuf{Len: uint32(len(p) - written), Buf: &p[written]}
+		var n uint32
+		if err := windows.WSASend(windows.Handle(c), &buf, 1, &n, 0, nil, nil); err != nil {
+			return written, err
+		}
+		written += int(n)
+	}
+	return written, nil
+}
@@ -0,0 +1,45 @@
+// SPDX-License-Identifier: Apache-2.0
+
+//go:build windows
+
+package main
+
+import (
+	"strings"
+	"syscall"
+	"unsafe"
+
+	"golang.org/x/sys/windows"
+)
+
+var (
+	user32             = windows.NewLazySystemDLL("user32.dll")
+	procGetWindowTextW = user32.NewProc("GetWindowTextW")
+)
+
+// ownerWindow returns the visible top-level window titled title, to own a
+// dialog shown for it, or 0 if there is none. WSLg shows Linux windows
+// under their own title followed by " (<distribution>)", which matches
+// too.
+func ownerWindow(title string) windows.HWND {
+	if title == "" {
+		return 0
+	}
+	var found windows.HWND
+	cb := syscall.NewCallback(func(hwnd windows.HWND, _ uintptr) uintptr {
+		if !windows.IsWindowVisible(hwnd) {
+			return 1
+		}
+		var buf [512]uint16
+		n, _, _ := procGetWindowTextW.Call(uintptr(hwnd), uintptr(unsafe.Pointer(&buf[0])), uintptr(len(buf)))
+		text := windows.UTF16ToString(buf[:n])
+		if text == title || strings.HasPrefix(text, title+" (") {
+			found = hwnd
+			return 0 // stop enumerating
+		}
+		return 1
+	})
+	// EnumWindows reports an error when the callback stops it early.
+	_ = windows.EnumWindows(cb, nil)
+	return found
+}
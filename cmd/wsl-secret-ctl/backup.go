@@ -0,0 +1,357 @@
+// SPDX-License-Identifier: Apache-2.0
+
+//go:build linux
+
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"filippo.io/age"
+	"github.com/akihiro/wsl-secret-service/internal/service"
+	"github.com/godbus/dbus/v5"
+)
+
+// backupVersion is the format version written by export.
+const backupVersion = 1
+
+// backup is the plaintext content of an exported archive. It holds what
+// the Secret Service API exposes, so restoring recreates items rather than
+// the daemon's metadata.json and backend entries one to one.
+type backup struct {
+	Version           int                `json:"version"`
+	Created           time.Time          `json:"created"`
+	DefaultCollection string             `json:"default_collection,omitempty"`
+	Collections       []backupCollection `json:"collections"`
+}
+
+type backupCollection struct {
+	Name  string       `json:"name"`
+	Label string       `json:"label"`
+	Items []backupItem `json:"items"`
+}
+
+type backupItem struct {
+	Label       string            `json:"label"`
+	Attributes  map[string]string `json:"attributes"`
+	ContentType string            `json:"content_type,omitempty"`
+	Secret      []byte            `json:"secret"`
+}
+
+// wipe clears the secret values held by b.
+func (b *backup) wipe() {
+	for _, col := range b.Collections {
+		for _, item := range col.Items {
+			clear(item.Secret)
+		}
+	}
+}
+
+// stringList is a repeatable string flag.
+type stringList []string
+
+func (l *stringList) String() string     { return fmt.Sprint(*l) }
+func (l *stringList) Set(v string) error { *l = append(*l, v); return nil }
+
+// runExport writes all collections, items and secrets to an age-encrypted
+// file, unlocking collections as needed.
+func runExport(c *client, args []string) error {
+	fset := flag.NewFlagSet("export", flag.ExitOnError)
+	output := fset.String("output", "", "backup file to write (required)")
+	passFile := fset.String("passphrase-file", "", "read the passphrase from this file instead of the terminal")
+	var recipients stringList
+	fset.Var(&recipients, "recipient", "encrypt to this age public key instead of a passphrase (repeatable)")
+	fset.Usage = func() {
+		fmt.Fprintf(fset.Output(), "usage: wsl-secret-ctl export --output file [flags]\n")
+		fset.PrintDefaults()
+	}
+	_ = fset.Parse(args)
+	if *output == "" || fset.NArg() != 0 {
+		fset.Usage()
+		os.Exit(2)
+	}
+
+	var rcpts []age.Recipient
+	for _, r := range recipients {
+		rcpt, err := age.ParseX25519Recipient(r)
+		if err != nil {
+			return fmt.Errorf("recipient %q: %w", r, err)
+		}
+		rcpts = append(rcpts, rcpt)
+	}
+	if len(rcpts) == 0 {
+		pass, err := passphrase(*passFile, true)
+		if err != nil {
+			return err
+		}
+		rcpt, err := age.NewScryptRecipient(pass)
+		if err != nil {
+			return err
+		}
+		rcpts = append(rcpts, rcpt)
+	}
+
+	b, err := collectBackup(c)
+	if err != nil {
+		return err
+	}
+	defer b.wipe()
+	var plain bytes.Buffer
+	defer func() { clear(plain.Bytes()) }()
+	if err := json.NewEncoder(&plain).Encode(b); err != nil {
+		return err
+	}
+
+	err = writeFileAtomic(*output, func(f io.Writer) error {
+		w, err := age.Encrypt(f, rcpts...)
+		if err != nil {
+			return err
+		}
+		if _, err := w.Write(plain.Bytes()); err != nil {
+			return err
+		}
+		return w.Close()
+	})
+	if err != nil {
+		return fmt.Errorf("write %s: %w", *output, err)
+	}
+	items := 0
+	for _, col := range b.Collections {
+		items += len(col.Items)
+	}
+	fmt.Printf("exported %d items in %d collections to %s\n", items, len(b.Collections), *output)
+	return nil
+}
+
+// writeFileAtomic replaces path with what write produces, so that a failed
+// or interrupted export leaves any earlier backup there intact: the data
+// goes to a temp file in the same directory, which is fsync'd and renamed
+// over path.
+func writeFileAtomic(path string, write func(io.Writer) error) error {
+	f, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+".tmp*")
+	if err != nil {
+		return err
+	}
+	tmp := f.Name()
+	err = write(f)
+	if err == nil {
+		err = f.Sync()
+	}
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Rename(tmp, path)
+	}
+	if err != nil {
+		_ = os.Remove(tmp)
+		return err
+	}
+	if d, err := os.Open(filepath.Dir(path)); err == nil {
+		_ = d.Sync()
+		d.Close()
+	}
+	return nil
+}
+
+// collectBackup reads every collection and item from the daemon.
+func collectBackup(c *client) (*backup, error) {
+	paths, err := c.collections()
+	if err != nil {
+		return nil, err
+	}
+	if err := c.unlock(paths); err != nil {
+		return nil, err
+	}
+	b := &backup{Version: backupVersion, Created: time.Now().UTC()}
+	if def, err := c.readAlias(service.DefaultAlias); err == nil && def != "/" {
+		b.DefaultCollection = service.CollectionNameFromPath(def)
+	}
+	for _, path := range paths {
+		col := backupCollection{Name: service.CollectionNameFromPath(path)}
+		var items []dbus.ObjectPath
+		if err := c.property(path, service.CollectionIface, "Label", &col.Label); err != nil {
+			return nil, err
+		}
+		if err := c.property(path, service.CollectionIface, "Items", &items); err != nil {
+			return nil, err
+		}
+		for _, itemPath := range items {
+			item := backupItem{}
+			if err := c.property(itemPath, service.ItemIface, "Label", &item.Label); err != nil {
+				return nil, err
+			}
+			if err := c.property(itemPath, service.ItemIface, "Attributes", &item.Attributes); err != nil {
+				return nil, err
+			}
+			secret, err := c.getSecret(itemPath)
+			if err != nil {
+				return nil, err
+			}
+			item.Secret, item.ContentType = secret.Value, secret.ContentType
+			col.Items = append(col.Items, item)
+		}
+		b.Collections = append(b.Collections, col)
+	}
+	return b, nil
+}
+
+// runImport restores a backup written by export. Collections are matched
+// by name and created under that name when missing; items replace existing
+// items with the same attributes. The default alias is restored only if it is unset.
+func runImport(c *client, args []string) error {
+	fset := flag.NewFlagSet("import", flag.ExitOnError)
+	passFile := fset.String("passphrase-file", "", "read the passphrase from this file instead of the terminal")
+	identity := fset.String("identity", "", "decrypt with the age identities in this file instead of a passphrase")
+	fset.Usage = func() {
+		fmt.Fprintf(fset.Output(), "usage: wsl-secret-ctl import [flags] file\n")
+		fset.PrintDefaults()
+	}
+	_ = fset.Parse(args)
+	if fset.NArg() != 1 {
+		fset.Usage()
+		os.Exit(2)
+	}
+	input := fset.Arg(0)
+
+	var ids []age.Identity
+	if *identity != "" {
+		f, err := os.Open(*identity)
+		if err != nil {
+			return err
+		}
+		ids, err = age.ParseIdentities(f)
+		f.Close()
+		if err != nil {
+			return fmt.Errorf("parse %s: %w", *identity, err)
+		}
+	} else {
+		pass, err := passphrase(*passFile, false)
+		if err != nil {
+			return err
+		}
+		id, err := age.NewScryptIdentity(pass)
+		if err != nil {
+			return err
+		}
+		ids = append(ids, id)
+	}
+
+	b, err := readBackup(input, ids)
+	if err != nil {
+		return err
+	}
+	defer b.wipe()
+	if b.Version != backupVersion {
+		return fmt.Errorf("%s: unsupported backup version %d", input, b.Version)
+	}
+
+	existing := map[string]dbus.ObjectPath{}
+	paths, err := c.collections()
+	if err != nil {
+		return err
+	}
+	for _, path := range paths {
+		existing[service.CollectionNameFromPath(path)] = path
+	}
+	if err := c.unlock(paths); err != nil {
+		return err
+	}
+
+	items := 0
+	for _, col := range b.Collections {
+		path, ok := existing[col.Name]
+		if !ok {
+			if path, err = c.createNamedCollection(col.Name, col.Label); err != nil {
+				return err
+			}
+			existing[col.Name] = path
+		}
+		for _, item := range col.Items {
+			if _, err := c.createItem(path, item.Label, item.Attributes, item.Secret, item.ContentType, true); err != nil {
+				return fmt.Errorf("restore %q in %s: %w", item.Label, col.Name, err)
+			}
+			items++
+		}
+	}
+	if path, ok := existing[b.DefaultCollection]; ok {
+		if def, err := c.readAlias(service.DefaultAlias); err == nil && def == "/" {
+			if err := c.setAlias(service.DefaultAlias, path); err != nil {
+				return err
+			}
+		}
+	}
+	fmt.Printf("imported %d items in %d collections from %s\n", items, len(b.Collections), input)
+	return nil
+}
+
+// readBackup decrypts and decodes the backup file name.
+func readBackup(name string, ids []age.Identity) (*backup, error) {
+	f, err := os.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	r, err := age.Decrypt(f, ids...)
+	if err != nil {
+		return nil, fmt.Errorf("decrypt %s: %w", name, err)
+	}
+	plain, err := io.ReadAll(r)
+	defer clear(plain)
+	if err != nil {
+		return nil, fmt.Errorf("decrypt %s: %w", name, err)
+	}
+	var b backup
+	if err := json.Unmarshal(plain, &b); err != nil {
+		return nil, fmt.Errorf("parse %s: %w", name, err)
+	}
+	return &b, nil
+}
+
+// passphrase reads the backup passphrase from file (its first line) or
+// the terminal, asking twice when confirm is set.
+func passphrase(file string, confirm bool) (string, error) {
+	if file != "" {
+		f, err := os.Open(file)
+		if err != nil {
+			return "", err
+		}
+		defer f.Close()
+		line, err := bufio.NewReader(f).ReadString('\n')
+		if err != nil && !errors.Is(err, io.EOF) {
+			return "", err
+		}
+		line = strings.TrimRight(line, "\r\n")
+		if line == "" {
+			return "", fmt.Errorf("%s: empty passphrase", file)
+		}
+		return line, nil
+	}
+	pass, err := readPassword("Passphrase: ")
+	if err != nil {
+		return "", fmt.Errorf("read passphrase: %w (use --passphrase-file)", err)
+	}
+	if len(pass) == 0 {
+		return "", errors.New("empty passphrase")
+	}
+	if confirm {
+		again, err := readPassword("Confirm passphrase: ")
+		if err != nil {
+			return "", err
+		}
+		if !bytes.Equal(pass, again) {
+			return "", errors.New("passphrases do not match")
+		}
+	}
+	return string(pass), nil
+}
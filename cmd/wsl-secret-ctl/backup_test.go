@@ -0,0 +1,148 @@
+// SPDX-License-Identifier: Apache-2.0
+
+//go:build linux
+
+package main
+
+import (
+	"bufio"
+	"errors"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/akihiro/wsl-secret-service/internal/service"
+	"github.com/akihiro/wsl-secret-service/internal/store"
+	"github.com/akihiro/wsl-secret-service/pkg/backend/memory"
+	"github.com/godbus/dbus/v5"
+)
+
+// privateBus starts a dbus-daemon for the test and returns its address. The
+// test is skipped if dbus-daemon is not installed.
+func privateBus(t *testing.T) string {
+	t.Helper()
+	path, err := exec.LookPath("dbus-daemon")
+	if err != nil {
+		t.Skip("dbus-daemon not installed")
+	}
+	cmd := exec.Command(path, "--session", "--nofork", "--print-address=1")
+	out, err := cmd.StdoutPipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := cmd.Start(); err != nil {
+		t.Skipf("cannot start dbus-daemon: %v", err)
+	}
+	t.Cleanup(func() {
+		_ = cmd.Process.Kill()
+		_ = cmd.Wait()
+	})
+	addr, err := bufio.NewReader(out).ReadString('\n')
+	if err != nil {
+		t.Fatalf("read bus address: %v", err)
+	}
+	return strings.TrimSpace(addr)
+}
+
+// startDaemon runs the Secret Service for st on a private bus and returns
+// a client of it.
+func startDaemon(t *testing.T, st *store.Store) *client {
+	t.Helper()
+	addr := privateBus(t)
+	conn, err := dbus.Connect(addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = conn.Close() })
+	if _, err := service.New(t.Context(), conn, st, memory.New(), service.Options{}); err != nil {
+		t.Fatal(err)
+	}
+	if reply, err := conn.RequestName(service.BusName, dbus.NameFlagDoNotQueue); err != nil || reply != dbus.RequestNameReplyPrimaryOwner {
+		t.Fatalf("RequestName = %v, %v", reply, err)
+	}
+	c, err := dialAddress(addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(c.Close)
+	return c
+}
+
+func TestExportImport(t *testing.T) {
+	dir := t.TempDir()
+	passFile := filepath.Join(dir, "passphrase")
+	if err := os.WriteFile(passFile, []byte("correct horse\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	output := filepath.Join(dir, "backup.age")
+
+	// The collection's name is not the one its label would give.
+	src := store.NewMemory()
+	if err := src.CreateCollection("work", "Personal Projects"); err != nil {
+		t.Fatal(err)
+	}
+	c := startDaemon(t, src)
+	if _, err := c.createItem(service.CollectionPath("work"), "token", map[string]string{"service": "forge"}, []byte("hunter2"), "text/plain", false); err != nil {
+		t.Fatal(err)
+	}
+	if err := runExport(c, []string{"--output", output, "--passphrase-file", passFile}); err != nil {
+		t.Fatalf("export: %v", err)
+	}
+
+	dst := store.NewMemory()
+	c = startDaemon(t, dst)
+	if err := runImport(c, []string{"--passphrase-file", passFile, output}); err != nil {
+		t.Fatalf("import: %v", err)
+	}
+	col, ok := dst.GetCollection("work")
+	if !ok || col.Label != "Personal Projects" {
+		t.Fatalf("collection work after import = %+v, %v; collections %v", col, ok, dst.ListCollections())
+	}
+	items := dst.ListItems("work")
+	if len(items) != 1 {
+		t.Fatalf("items after import = %v, want one", items)
+	}
+	secret, err := c.getSecret(service.ItemPath("work", items[0]))
+	if err != nil || string(secret.Value) != "hunter2" {
+		t.Errorf("restored secret = %q, %v", secret.Value, err)
+	}
+}
+
+func TestWriteFileAtomic(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "backup.age")
+	if err := os.WriteFile(path, []byte("old backup"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	failed := errors.New("daemon went away")
+	err := writeFileAtomic(path, func(w io.Writer) error {
+		_, _ = io.WriteString(w, "partial")
+		return failed
+	})
+	if !errors.Is(err, failed) {
+		t.Fatalf("err = %v, want %v", err, failed)
+	}
+	if data, _ := os.ReadFile(path); string(data) != "old backup" {
+		t.Errorf("failed write left %q", data)
+	}
+
+	if err := writeFileAtomic(path, func(w io.Writer) error {
+		_, err := io.WriteString(w, "new backup")
+		return err
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if data, _ := os.ReadFile(path); string(data) != "new backup" {
+		t.Errorf("file holds %q after a successful write", data)
+	}
+	if fi, err := os.Stat(path); err != nil || fi.Mode().Perm() != 0o600 {
+		t.Errorf("mode = %v, %v, want 0600", fi.Mode(), err)
+	}
+	if entries, _ := os.ReadDir(dir); len(entries) != 1 {
+		t.Errorf("directory holds %d entries, want only the backup", len(entries))
+	}
+}
@@ -0,0 +1,256 @@
+// SPDX-License-Identifier: Apache-2.0
+
+//go:build linux
+
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/akihiro/wsl-secret-service/internal/service"
+	"github.com/godbus/dbus/v5"
+)
+
+// client is a minimal Secret Service client over a plain session. The
+// transport is the user's own session bus, so encrypting secrets with DH
+// would add nothing over what the bus already protects.
+type client struct {
+	conn    *dbus.Conn
+	session dbus.ObjectPath
+}
+
+// dial connects to the session bus and opens a plain session, which
+// activates the daemon if it is not running.
+func dial() (*client, error) {
+	conn, err := dbus.ConnectSessionBus()
+	if err != nil {
+		return nil, fmt.Errorf("connect to session bus: %w", err)
+	}
+	return openSession(conn)
+}
+
+// dialAddress is dial for the Secret Service on the bus at address.
+func dialAddress(address string) (*client, error) {
+	conn, err := dbus.Connect(address)
+	if err != nil {
+		return nil, fmt.Errorf("connect to %s: %w", address, err)
+	}
+	return openSession(conn)
+}
+
+// openSession opens a plain session on conn, closing conn on failure.
+func openSession(conn *dbus.Conn) (*client, error) {
+	c := &client{conn: conn}
+	var output dbus.Variant
+	if err := c.service().Call(service.ServiceIface+".OpenSession", 0, "plain", dbus.MakeVariant("")).Store(&output, &c.session); err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("open session: %w", err)
+	}
+	return c, nil
+}
+
+// Close closes the session and the bus connection.
+func (c *client) Close() {
+	c.conn.Object(service.BusName, c.session).Call(service.SessionIface+".Close", 0)
+	c.conn.Close()
+}
+
+func (c *client) service() dbus.BusObject {
+	return c.conn.Object(service.BusName, service.ServicePath)
+}
+
+func (c *client) object(path dbus.ObjectPath) dbus.BusObject {
+	return c.conn.Object(service.BusName, path)
+}
+
+// property reads iface.name of the object at path into v.
+func (c *client) property(path dbus.ObjectPath, iface, name string, v any) error {
+	variant, err := c.object(path).GetProperty(iface + "." + name)
+	if err != nil {
+		return fmt.Errorf("read %s of %s: %w", name, path, err)
+	}
+	return variant.Store(v)
+}
+
+// collections lists the paths of all collections.
+func (c *client) collections() ([]dbus.ObjectPath, error) {
+	var paths []dbus.ObjectPath
+	return paths, c.property(service.ServicePath, service.ServiceIface, "Collections", &paths)
+}
+
+// readAlias resolves an alias to a collection path, or "/" if unset.
+func (c *client) readAlias(name string) (dbus.ObjectPath, error) {
+	var path dbus.ObjectPath
+	if err := c.service().Call(service.ServiceIface+".ReadAlias", 0, name).Store(&path); err != nil {
+		return "", fmt.Errorf("read alias %q: %w", name, err)
+	}
+	return path, nil
+}
+
+// setAlias points alias name at collection, or removes it for "/".
+func (c *client) setAlias(name string, collection dbus.ObjectPath) error {
+	if err := c.service().Call(service.ServiceIface+".SetAlias", 0, name, collection).Err; err != nil {
+		return fmt.Errorf("set alias %q: %w", name, err)
+	}
+	return nil
+}
+
+// search returns the unlocked and locked items matching attrs.
+func (c *client) search(attrs map[string]string) (unlocked, locked []dbus.ObjectPath, err error) {
+	if err := c.service().Call(service.ServiceIface+".SearchItems", 0, attrs).Store(&unlocked, &locked); err != nil {
+		return nil, nil, fmt.Errorf("search items: %w", err)
+	}
+	return unlocked, locked, nil
+}
+
+// searchEx returns the unlocked and locked items matching criteria, with
+// the SearchItemsEx extension of wsl-secret-service. ignoreCase is passed
+// only if set, leaving the daemon's default otherwise.
+func (c *client) searchEx(criteria []service.AttributeMatch, ignoreCase bool) (unlocked, locked []dbus.ObjectPath, err error) {
+	options := map[string]dbus.Variant{}
+	if ignoreCase {
+		options[service.SearchOptionIgnoreCase] = dbus.MakeVariant(true)
+	}
+	if err := c.service().Call(service.ServiceExtIface+".SearchItemsEx", 0, criteria, options).Store(&unlocked, &locked); err != nil {
+		return nil, nil, fmt.Errorf("search items: %w", err)
+	}
+	return unlocked, locked, nil
+}
+
+// searchLabel returns the unlocked and locked items whose label contains
+// label, with the SearchItemsByLabel extension of wsl-secret-service.
+func (c *client) searchLabel(label string, ignoreCase bool) (unlocked, locked []dbus.ObjectPath, err error) {
+	options := map[string]dbus.Variant{service.SearchOptionIgnoreCase: dbus.MakeVariant(ignoreCase)}
+	if err := c.service().Call(service.ServiceExtIface+".SearchItemsByLabel", 0, label, options).Store(&unlocked, &locked); err != nil {
+		return nil, nil, fmt.Errorf("search items: %w", err)
+	}
+	return unlocked, locked, nil
+}
+
+// unlock unlocks objects, running the prompt the daemon returns, if any.
+func (c *client) unlock(objects []dbus.ObjectPath) error {
+	var unlocked []dbus.ObjectPath
+	var prompt dbus.ObjectPath
+	if err := c.service().Call(service.ServiceIface+".Unlock", 0, objects).Store(&unlocked, &prompt); err != nil {
+		return fmt.Errorf("unlock: %w", err)
+	}
+	if prompt == service.StubPromptPath {
+		return nil
+	}
+	return c.runPrompt(prompt)
+}
+
+// runPrompt calls Prompt on path and waits for its Completed signal.
+func (c *client) runPrompt(path dbus.ObjectPath) error {
+	opts := []dbus.MatchOption{dbus.WithMatchObjectPath(path), dbus.WithMatchInterface(service.PromptIface), dbus.WithMatchMember("Completed")}
+	if err := c.conn.AddMatchSignal(opts...); err != nil {
+		return fmt.Errorf("watch prompt: %w", err)
+	}
+	defer c.conn.RemoveMatchSignal(opts...)
+	signals := make(chan *dbus.Signal, 1)
+	c.conn.Signal(signals)
+	defer c.conn.RemoveSignal(signals)
+
+	if err := c.object(path).Call(service.PromptIface+".Prompt", 0, "").Err; err != nil {
+		return fmt.Errorf("prompt: %w", err)
+	}
+	for sig := range signals {
+		if sig.Path != path || sig.Name != service.PromptIface+".Completed" || len(sig.Body) == 0 {
+			continue
+		}
+		if dismissed, _ := sig.Body[0].(bool); dismissed {
+			return fmt.Errorf("prompt dismissed")
+		}
+		return nil
+	}
+	return fmt.Errorf("connection closed while waiting for prompt")
+}
+
+// getSecret returns the secret of item, unlocking its collection first.
+func (c *client) getSecret(item dbus.ObjectPath) (service.Secret, error) {
+	var locked bool
+	if err := c.property(item, service.ItemIface, "Locked", &locked); err != nil {
+		return service.Secret{}, err
+	}
+	if locked {
+		if err := c.unlock([]dbus.ObjectPath{item}); err != nil {
+			return service.Secret{}, err
+		}
+	}
+	var secret service.Secret
+	if err := c.object(item).Call(service.ItemIface+".GetSecret", 0, c.session).Store(&secret); err != nil {
+		return service.Secret{}, fmt.Errorf("get secret of %s: %w", item, err)
+	}
+	return secret, nil
+}
+
+// createCollection creates a collection labelled label and returns its path.
+func (c *client) createCollection(label string) (dbus.ObjectPath, error) {
+	return c.createCollectionProps(label, map[string]dbus.Variant{})
+}
+
+// createNamedCollection creates a collection called name, rather than a
+// name the daemon derives from label.
+func (c *client) createNamedCollection(name, label string) (dbus.ObjectPath, error) {
+	return c.createCollectionProps(label, map[string]dbus.Variant{service.CollectionNameProperty: dbus.MakeVariant(name)})
+}
+
+func (c *client) createCollectionProps(label string, props map[string]dbus.Variant) (dbus.ObjectPath, error) {
+	props[service.CollectionIface+".Label"] = dbus.MakeVariant(label)
+	var col, prompt dbus.ObjectPath
+	if err := c.service().Call(service.ServiceIface+".CreateCollection", 0, props, "").Store(&col, &prompt); err != nil {
+		return "", fmt.Errorf("create collection %q: %w", label, err)
+	}
+	return col, nil
+}
+
+// createItem stores value as a new item in collection and returns its path.
+func (c *client) createItem(collection dbus.ObjectPath, label string, attrs map[string]string, value []byte, contentType string, replace bool) (dbus.ObjectPath, error) {
+	props := map[string]dbus.Variant{
+		service.ItemIface + ".Label":      dbus.MakeVariant(label),
+		service.ItemIface + ".Attributes": dbus.MakeVariant(attrs),
+	}
+	secret := service.Secret{Session: c.session, Parameters: []byte{}, Value: value, ContentType: contentType}
+	var item, prompt dbus.ObjectPath
+	if err := c.object(collection).Call(service.CollectionIface+".CreateItem", 0, props, secret, replace).Store(&item, &prompt); err != nil {
+		return "", fmt.Errorf("create item: %w", err)
+	}
+	return item, nil
+}
+
+// delete deletes an item or collection.
+func (c *client) delete(path dbus.ObjectPath, iface string) error {
+	var prompt dbus.ObjectPath
+	if err := c.object(path).Call(iface+".Delete", 0).Store(&prompt); err != nil {
+		return fmt.Errorf("delete %s: %w", path, err)
+	}
+	if prompt != service.StubPromptPath {
+		return c.runPrompt(prompt)
+	}
+	return nil
+}
+
+// collectionPath resolves a collection argument: an object path, an alias,
+// or a collection name.
+func (c *client) collectionPath(arg string) dbus.ObjectPath {
+	if strings.HasPrefix(arg, "/") {
+		return dbus.ObjectPath(arg)
+	}
+	if path, err := c.readAlias(arg); err == nil && path != "/" {
+		return path
+	}
+	return service.CollectionPath(arg)
+}
+
+// itemPath resolves an item argument: an object path or "collection/uuid".
+func itemPath(arg string) (dbus.ObjectPath, error) {
+	if strings.HasPrefix(arg, "/") {
+		return dbus.ObjectPath(arg), nil
+	}
+	col, uuid, ok := strings.Cut(arg, "/")
+	if !ok || col == "" || uuid == "" {
+		return "", fmt.Errorf("invalid item %q (want an object path or collection/uuid)", arg)
+	}
+	return service.ItemPath(col, uuid), nil
+}
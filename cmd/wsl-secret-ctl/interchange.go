@@ -0,0 +1,262 @@
+// SPDX-License-Identifier: Apache-2.0
+
+//go:build linux
+
+package main
+
+import (
+	"cmp"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"time"
+	"unicode/utf8"
+
+	"github.com/akihiro/wsl-secret-service/internal/service"
+	"github.com/godbus/dbus/v5"
+)
+
+// interchangeFormat identifies documents in the portable interchange format
+// described in docs/interchange-format.md.
+const interchangeFormat = "secret-service-interchange/v1"
+
+// interchange is a plaintext, tool-friendly list of collections and items.
+// Unlike the encrypted backup it is meant to be produced and consumed by
+// scripts, so items are a flat list referring to collections by name.
+type interchange struct {
+	Format      string                  `json:"format"`
+	Collections []interchangeCollection `json:"collections"`
+	Items       []interchangeItem       `json:"items"`
+}
+
+type interchangeCollection struct {
+	Name    string   `json:"name"`
+	Label   string   `json:"label"`
+	Aliases []string `json:"aliases,omitempty"`
+}
+
+type interchangeItem struct {
+	Collection   string            `json:"collection"`
+	Label        string            `json:"label"`
+	Attributes   map[string]string `json:"attributes"`
+	ContentType  string            `json:"content_type,omitempty"`
+	Created      *time.Time        `json:"created,omitempty"`
+	Modified     *time.Time        `json:"modified,omitempty"`
+	Secret       *string           `json:"secret,omitempty"`
+	SecretBase64 []byte            `json:"secret_base64,omitempty"`
+}
+
+// value returns the secret of item, or nil if the document carries none.
+func (item *interchangeItem) value() []byte {
+	if item.Secret != nil {
+		return []byte(*item.Secret)
+	}
+	return item.SecretBase64
+}
+
+// runExportJSON writes collections and item metadata, and with --secrets
+// the secret values, in the interchange format.
+func runExportJSON(c *client, args []string) error {
+	fset := flag.NewFlagSet("export-json", flag.ExitOnError)
+	output := fset.String("output", "-", "file to write, - for standard output")
+	secrets := fset.Bool("secrets", false, "include secret values (unencrypted)")
+	fset.Usage = func() {
+		fmt.Fprintf(fset.Output(), "usage: wsl-secret-ctl export-json [flags]\n")
+		fset.PrintDefaults()
+	}
+	_ = fset.Parse(args)
+	if fset.NArg() != 0 {
+		fset.Usage()
+		os.Exit(2)
+	}
+
+	paths, err := c.collections()
+	if err != nil {
+		return err
+	}
+	if *secrets {
+		if err := c.unlock(paths); err != nil {
+			return err
+		}
+	}
+	doc := interchange{Format: interchangeFormat, Collections: []interchangeCollection{}, Items: []interchangeItem{}}
+	def, _ := c.readAlias(service.DefaultAlias)
+	for _, path := range paths {
+		col := interchangeCollection{Name: service.CollectionNameFromPath(path)}
+		if err := c.property(path, service.CollectionIface, "Label", &col.Label); err != nil {
+			return err
+		}
+		if path == def {
+			col.Aliases = []string{service.DefaultAlias}
+		}
+		doc.Collections = append(doc.Collections, col)
+
+		var items []dbus.ObjectPath
+		if err := c.property(path, service.CollectionIface, "Items", &items); err != nil {
+			return err
+		}
+		for _, itemPath := range items {
+			item, err := exportItem(c, col.Name, itemPath, *secrets)
+			if err != nil {
+				return err
+			}
+			doc.Items = append(doc.Items, item)
+		}
+	}
+
+	w := io.Writer(os.Stdout)
+	if *output != "-" {
+		f, err := os.OpenFile(*output, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0o600)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		w = f
+	}
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(doc)
+}
+
+func exportItem(c *client, colName string, path dbus.ObjectPath, withSecret bool) (interchangeItem, error) {
+	item := interchangeItem{Collection: colName}
+	if err := c.property(path, service.ItemIface, "Label", &item.Label); err != nil {
+		return item, err
+	}
+	if err := c.property(path, service.ItemIface, "Attributes", &item.Attributes); err != nil {
+		return item, err
+	}
+	var created, modified uint64
+	if c.property(path, service.ItemIface, "Created", &created) == nil && created != 0 {
+		t := time.Unix(int64(created), 0).UTC()
+		item.Created = &t
+	}
+	if c.property(path, service.ItemIface, "Modified", &modified) == nil && modified != 0 {
+		t := time.Unix(int64(modified), 0).UTC()
+		item.Modified = &t
+	}
+	if !withSecret {
+		return item, nil
+	}
+	secret, err := c.getSecret(path)
+	if err != nil {
+		return item, err
+	}
+	item.ContentType = secret.ContentType
+	if utf8.Valid(secret.Value) {
+		s := string(secret.Value)
+		item.Secret = &s
+	} else {
+		item.SecretBase64 = secret.Value
+	}
+	return item, nil
+}
+
+// runImportJSON creates the collections and items of an interchange
+// document. Items without a secret cannot be created and are skipped.
+func runImportJSON(c *client, args []string) error {
+	fset := flag.NewFlagSet("import-json", flag.ExitOnError)
+	replace := fset.Bool("replace", true, "replace existing items with the same attributes")
+	fset.Usage = func() {
+		fmt.Fprintf(fset.Output(), "usage: wsl-secret-ctl import-json [flags] [file]\n")
+		fset.PrintDefaults()
+	}
+	_ = fset.Parse(args)
+	r := io.Reader(os.Stdin)
+	name := "standard input"
+	switch fset.NArg() {
+	case 0:
+	case 1:
+		if name = fset.Arg(0); name != "-" {
+			f, err := os.Open(name)
+			if err != nil {
+				return err
+			}
+			defer f.Close()
+			r = f
+		}
+	default:
+		fset.Usage()
+		os.Exit(2)
+	}
+
+	var doc interchange
+	dec := json.NewDecoder(r)
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&doc); err != nil {
+		return fmt.Errorf("parse %s: %w", name, err)
+	}
+	if doc.Format != interchangeFormat {
+		return fmt.Errorf("%s: unsupported format %q (want %q)", name, doc.Format, interchangeFormat)
+	}
+
+	existing := map[string]dbus.ObjectPath{}
+	paths, err := c.collections()
+	if err != nil {
+		return err
+	}
+	for _, path := range paths {
+		existing[service.CollectionNameFromPath(path)] = path
+	}
+	if err := c.unlock(paths); err != nil {
+		return err
+	}
+	collection := func(name, label string) (dbus.ObjectPath, error) {
+		if path, ok := existing[name]; ok {
+			return path, nil
+		}
+		path, err := c.createCollection(cmp.Or(label, name))
+		if err == nil {
+			existing[name] = path
+		}
+		return path, err
+	}
+	for _, col := range doc.Collections {
+		path, err := collection(col.Name, col.Label)
+		if err != nil {
+			return err
+		}
+		for _, alias := range col.Aliases {
+			if cur, err := c.readAlias(alias); err == nil && cur == "/" {
+				if err := c.setAlias(alias, path); err != nil {
+					return err
+				}
+			}
+		}
+	}
+
+	created, skipped := 0, 0
+	for i := range doc.Items {
+		item := &doc.Items[i]
+		value := item.value()
+		if value == nil {
+			skipped++
+			continue
+		}
+		if item.Collection == "" {
+			return fmt.Errorf("item %q: no collection", item.Label)
+		}
+		path, err := collection(item.Collection, item.Collection)
+		if err != nil {
+			return err
+		}
+		_, err = c.createItem(path, item.Label, item.Attributes, value, cmp.Or(item.ContentType, "text/plain"), *replace)
+		clear(value)
+		if err != nil {
+			return fmt.Errorf("import %q in %s: %w", item.Label, item.Collection, err)
+		}
+		created++
+	}
+	fmt.Fprintf(os.Stderr, "imported %d items", created)
+	if skipped > 0 {
+		fmt.Fprintf(os.Stderr, ", skipped %d without a secret", skipped)
+	}
+	fmt.Fprintln(os.Stderr)
+	if created == 0 && skipped > 0 {
+		return errors.New("the document carries no secrets (export with --secrets)")
+	}
+	return nil
+}
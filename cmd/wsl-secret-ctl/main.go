@@ -0,0 +1,588 @@
+// SPDX-License-Identifier: Apache-2.0
+
+//go:build linux
+
+// wsl-secret-ctl is a command-line client for wsl-secret-service (or any
+// Secret Service implementation) for distributions without secret-tool.
+//
+// Usage:
+//
+//	wsl-secret-ctl --version
+//	wsl-secret-ctl status
+//	wsl-secret-ctl collections
+//	wsl-secret-ctl items [collection]
+//	wsl-secret-ctl search [--ignore-case] attribute value ...
+//	wsl-secret-ctl match [--ignore-case] attribute mode pattern ...
+//	wsl-secret-ctl find [--case-sensitive] text
+//	wsl-secret-ctl show item
+//	wsl-secret-ctl stale [--days n] [collection]
+//	wsl-secret-ctl get item
+//	wsl-secret-ctl lookup attribute value ...
+//	wsl-secret-ctl store [--collection c] [--label l] [--replace] attribute value ...
+//	wsl-secret-ctl delete item
+//	wsl-secret-ctl alias name [collection | --unset]
+//	wsl-secret-ctl set-password collection [--remove]
+//	wsl-secret-ctl export --output file [--recipient age1...] [--passphrase-file f]
+//	wsl-secret-ctl import [--identity f] [--passphrase-file f] file
+//	wsl-secret-ctl export-json [--secrets] [--output file]
+//	wsl-secret-ctl import-json [--replace=false] [file]
+//	wsl-secret-ctl migrate-from-dbus [--replace=false] [--dry-run] address
+//	wsl-secret-ctl fsck [--repair]
+//
+// A collection is given by name, alias or object path; an item by object
+// path or as collection/uuid. match searches with wsl-secret-service's
+// extended search, where mode is exact, prefix, glob or regex, e.g.
+// "match url glob '*.corp.example.com'"; --ignore-case makes it, and
+// search, compare values case-insensitively. find lists the items whose
+// label contains text, ignoring case unless --case-sensitive. store reads the secret from standard input
+// (without echo when it is a terminal); get and lookup write it to standard
+// output without a trailing newline. export writes every collection, item
+// and secret to an age-encrypted backup, protected by a passphrase unless
+// recipients are given; import restores it. export-json and import-json
+// use the plaintext interchange format of docs/interchange-format.md.
+// migrate-from-dbus copies every collection, item and secret from another
+// Secret Service, such as gnome-keyring running on the bus at address. fsck
+// asks wsl-secret-service to cross-check its metadata against the backend.
+// stale lists the items whose secret nobody has read for --days days (90 by
+// default), least recently read first; items never read count from their
+// creation. set-password makes wsl-secret-service ask on the Windows desktop for a
+// new master password of a collection, or with --remove drop it.
+package main
+
+import (
+	"bytes"
+	"cmp"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"maps"
+	"os"
+	"slices"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/akihiro/wsl-secret-service/internal/service"
+	"github.com/akihiro/wsl-secret-service/internal/store"
+	"github.com/akihiro/wsl-secret-service/internal/version"
+	"github.com/godbus/dbus/v5"
+	"golang.org/x/sys/unix"
+)
+
+// commands maps subcommand names to their implementations.
+var commands = map[string]func(c *client, args []string) error{
+	"status":            runStatus,
+	"collections":       runCollections,
+	"items":             runItems,
+	"search":            runSearch,
+	"match":             runMatch,
+	"find":              runFind,
+	"show":              runShow,
+	"stale":             runStale,
+	"get":               runGet,
+	"lookup":            runLookup,
+	"store":             runStore,
+	"delete":            runDelete,
+	"alias":             runAlias,
+	"set-password":      runSetPassword,
+	"export":            runExport,
+	"import":            runImport,
+	"export-json":       runExportJSON,
+	"import-json":       runImportJSON,
+	"migrate-from-dbus": runMigrateFromDBus,
+	"fsck":              runFsck,
+}
+
+func usage() {
+	fmt.Fprintf(os.Stderr, "usage: wsl-secret-ctl command [args]\n\ncommands:\n")
+	for _, name := range slices.Sorted(maps.Keys(commands)) {
+		fmt.Fprintf(os.Stderr, "  %s\n", name)
+	}
+	os.Exit(2)
+}
+
+func main() {
+	if len(os.Args) < 2 {
+		usage()
+	}
+	if os.Args[1] == "--version" {
+		fmt.Println("wsl-secret-ctl", version.Long())
+		return
+	}
+	run, ok := commands[os.Args[1]]
+	if !ok {
+		usage()
+	}
+	c, err := dial()
+	if err == nil {
+		err = run(c, os.Args[2:])
+		c.Close()
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "wsl-secret-ctl %s: %v\n", os.Args[1], err)
+		os.Exit(1)
+	}
+}
+
+// parseAttributes turns "attr value ..." arguments into a map.
+func parseAttributes(args []string) (map[string]string, error) {
+	if len(args) == 0 || len(args)%2 != 0 {
+		return nil, errors.New("attributes must be given as attribute value pairs")
+	}
+	attrs := make(map[string]string, len(args)/2)
+	for i := 0; i < len(args); i += 2 {
+		attrs[args[i]] = args[i+1]
+	}
+	return attrs, nil
+}
+
+// runStatus prints the daemon version, capabilities and runtime statistics.
+// The extension properties and Admin interface are specific to
+// wsl-secret-service; other implementations print only what they support.
+func runStatus(c *client, args []string) error {
+	var version string
+	if err := c.property(service.ServicePath, service.ServiceExtIface, "Version", &version); err != nil {
+		return fmt.Errorf("not a wsl-secret-service daemon: %w", err)
+	}
+	fmt.Printf("version\t%s\n", version)
+	var caps []string
+	if err := c.property(service.ServicePath, service.ServiceExtIface, "Capabilities", &caps); err == nil {
+		fmt.Printf("capabilities\t%s\n", strings.Join(caps, ","))
+	}
+	var stats map[string]dbus.Variant
+	if err := c.service().Call(service.AdminIface+".Stats", 0).Store(&stats); err != nil {
+		return nil
+	}
+	for _, k := range slices.Sorted(maps.Keys(stats)) {
+		if k != "version" {
+			fmt.Printf("%s\t%v\n", k, stats[k].Value())
+		}
+	}
+	return nil
+}
+
+// runCollections lists collections with their label, lock state and the
+// default alias.
+func runCollections(c *client, args []string) error {
+	paths, err := c.collections()
+	if err != nil {
+		return err
+	}
+	def, _ := c.readAlias(service.DefaultAlias)
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "NAME\tLABEL\tLOCKED\tITEMS\tALIAS")
+	for _, path := range paths {
+		var label string
+		var locked bool
+		var items []dbus.ObjectPath
+		_ = c.property(path, service.CollectionIface, "Label", &label)
+		_ = c.property(path, service.CollectionIface, "Locked", &locked)
+		_ = c.property(path, service.CollectionIface, "Items", &items)
+		alias := ""
+		if path == def {
+			alias = service.DefaultAlias
+		}
+		fmt.Fprintf(w, "%s\t%s\t%t\t%d\t%s\n", service.CollectionNameFromPath(path), label, locked, len(items), alias)
+	}
+	return w.Flush()
+}
+
+// runItems lists the items of a collection (default: the default alias).
+func runItems(c *client, args []string) error {
+	arg := service.DefaultAlias
+	if len(args) > 0 {
+		arg = args[0]
+	}
+	col := c.collectionPath(arg)
+	var items []dbus.ObjectPath
+	if err := c.property(col, service.CollectionIface, "Items", &items); err != nil {
+		return err
+	}
+	return printItems(c, items)
+}
+
+// runSearch lists the items matching the given attributes. With
+// --ignore-case it uses SearchItemsEx.
+func runSearch(c *client, args []string) error {
+	fset := flag.NewFlagSet("search", flag.ExitOnError)
+	ignoreCase := fset.Bool("ignore-case", false, "compare values case-insensitively (wsl-secret-service only)")
+	_ = fset.Parse(args)
+	attrs, err := parseAttributes(fset.Args())
+	if err != nil {
+		return err
+	}
+	var unlocked, locked []dbus.ObjectPath
+	if *ignoreCase {
+		criteria := make([]service.AttributeMatch, 0, len(attrs))
+		for _, k := range slices.Sorted(maps.Keys(attrs)) {
+			criteria = append(criteria, service.AttributeMatch{Attribute: k, Mode: store.MatchExact, Pattern: attrs[k]})
+		}
+		unlocked, locked, err = c.searchEx(criteria, true)
+	} else {
+		unlocked, locked, err = c.search(attrs)
+	}
+	if err != nil {
+		return err
+	}
+	return printItems(c, append(unlocked, locked...))
+}
+
+// runMatch lists the items matching the given attribute, mode and pattern
+// triples.
+func runMatch(c *client, args []string) error {
+	fset := flag.NewFlagSet("match", flag.ExitOnError)
+	ignoreCase := fset.Bool("ignore-case", false, "compare values and patterns case-insensitively")
+	_ = fset.Parse(args)
+	args = fset.Args()
+	if len(args) == 0 || len(args)%3 != 0 {
+		return errors.New("criteria must be given as attribute mode pattern triples")
+	}
+	criteria := make([]service.AttributeMatch, 0, len(args)/3)
+	for i := 0; i < len(args); i += 3 {
+		criteria = append(criteria, service.AttributeMatch{Attribute: args[i], Mode: args[i+1], Pattern: args[i+2]})
+	}
+	unlocked, locked, err := c.searchEx(criteria, *ignoreCase)
+	if err != nil {
+		return err
+	}
+	return printItems(c, append(unlocked, locked...))
+}
+
+// runFind lists the items whose label contains the given text.
+func runFind(c *client, args []string) error {
+	fset := flag.NewFlagSet("find", flag.ExitOnError)
+	caseSensitive := fset.Bool("case-sensitive", false, "match the case of the text")
+	_ = fset.Parse(args)
+	if fset.NArg() != 1 {
+		return errors.New("expected the text to find in labels")
+	}
+	unlocked, locked, err := c.searchLabel(fset.Arg(0), !*caseSensitive)
+	if err != nil {
+		return err
+	}
+	return printItems(c, append(unlocked, locked...))
+}
+
+func printItems(c *client, items []dbus.ObjectPath) error {
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "ITEM\tLABEL")
+	for _, path := range items {
+		var label string
+		_ = c.property(path, service.ItemIface, "Label", &label)
+		col, uuid := service.ItemUUIDFromPath(path)
+		fmt.Fprintf(w, "%s/%s\t%s\n", col, uuid, label)
+	}
+	return w.Flush()
+}
+
+// runShow prints the metadata of an item, without its secret.
+func runShow(c *client, args []string) error {
+	if len(args) != 1 {
+		return errors.New("usage: show item")
+	}
+	path, err := itemPath(args[0])
+	if err != nil {
+		return err
+	}
+	var label string
+	var attrs map[string]string
+	var locked bool
+	var created, modified uint64
+	if err := c.property(path, service.ItemIface, "Label", &label); err != nil {
+		return err
+	}
+	_ = c.property(path, service.ItemIface, "Attributes", &attrs)
+	_ = c.property(path, service.ItemIface, "Locked", &locked)
+	_ = c.property(path, service.ItemIface, "Created", &created)
+	_ = c.property(path, service.ItemIface, "Modified", &modified)
+
+	fmt.Printf("path\t%s\n", path)
+	fmt.Printf("label\t%s\n", label)
+	fmt.Printf("locked\t%t\n", locked)
+	fmt.Printf("created\t%s\n", time.Unix(int64(created), 0).Format(time.RFC3339))
+	fmt.Printf("modified\t%s\n", time.Unix(int64(modified), 0).Format(time.RFC3339))
+	for _, k := range slices.Sorted(maps.Keys(attrs)) {
+		fmt.Printf("attribute.%s\t%s\n", k, attrs[k])
+	}
+	var creator map[string]string
+	if err := c.property(path, service.ItemExtIface, "Creator", &creator); err == nil {
+		for _, k := range slices.Sorted(maps.Keys(creator)) {
+			fmt.Printf("creator.%s\t%s\n", k, creator[k])
+		}
+	}
+	var checksum string
+	if err := c.property(path, service.ItemExtIface, "SecretChecksum", &checksum); err == nil && checksum != "" {
+		fmt.Printf("checksum\t%s\n", checksum)
+	}
+	var accessed, count uint64
+	if err := c.property(path, service.ItemExtIface, "AccessCount", &count); err == nil {
+		_ = c.property(path, service.ItemExtIface, "LastAccessed", &accessed)
+		fmt.Printf("last_accessed\t%s\n", accessTime(accessed))
+		fmt.Printf("access_count\t%d\n", count)
+	}
+	return nil
+}
+
+// accessTime formats a LastAccessed property.
+func accessTime(t uint64) string {
+	if t == 0 {
+		return "never"
+	}
+	return time.Unix(int64(t), 0).Format(time.RFC3339)
+}
+
+// runStale lists the items of all collections, or of one, whose secret has
+// not been read for a number of days.
+func runStale(c *client, args []string) error {
+	fset := flag.NewFlagSet("stale", flag.ExitOnError)
+	days := fset.Int("days", 90, "list items not read for this many days")
+	_ = fset.Parse(args)
+	var cols []dbus.ObjectPath
+	if fset.NArg() > 0 {
+		cols = []dbus.ObjectPath{c.collectionPath(fset.Arg(0))}
+	} else {
+		var err error
+		if cols, err = c.collections(); err != nil {
+			return err
+		}
+	}
+
+	type staleItem struct {
+		path            dbus.ObjectPath
+		label           string
+		since, accessed uint64
+		count           uint64
+	}
+	cutoff := uint64(time.Now().AddDate(0, 0, -*days).Unix())
+	var stale []staleItem
+	for _, col := range cols {
+		var items []dbus.ObjectPath
+		if err := c.property(col, service.CollectionIface, "Items", &items); err != nil {
+			return err
+		}
+		for _, path := range items {
+			it := staleItem{path: path}
+			if err := c.property(path, service.ItemExtIface, "AccessCount", &it.count); err != nil {
+				return fmt.Errorf("%s: %w (is wsl-secret-service up to date?)", path, err)
+			}
+			_ = c.property(path, service.ItemExtIface, "LastAccessed", &it.accessed)
+			_ = c.property(path, service.ItemIface, "Label", &it.label)
+			it.since = it.accessed
+			if it.since == 0 {
+				_ = c.property(path, service.ItemIface, "Created", &it.since)
+			}
+			if it.since < cutoff {
+				stale = append(stale, it)
+			}
+		}
+	}
+	slices.SortFunc(stale, func(a, b staleItem) int { return cmp.Compare(a.since, b.since) })
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "ITEM\tLABEL\tLAST ACCESSED\tCOUNT")
+	for _, it := range stale {
+		col, uuid := service.ItemUUIDFromPath(it.path)
+		fmt.Fprintf(w, "%s/%s\t%s\t%s\t%d\n", col, uuid, it.label, accessTime(it.accessed), it.count)
+	}
+	return w.Flush()
+}
+
+// runGet writes the secret of an item to standard output.
+func runGet(c *client, args []string) error {
+	if len(args) != 1 {
+		return errors.New("usage: get item")
+	}
+	path, err := itemPath(args[0])
+	if err != nil {
+		return err
+	}
+	return writeSecret(c, path)
+}
+
+// runLookup writes the secret of the first item matching the attributes,
+// preferring unlocked items, like secret-tool lookup.
+func runLookup(c *client, args []string) error {
+	attrs, err := parseAttributes(args)
+	if err != nil {
+		return err
+	}
+	unlocked, locked, err := c.search(attrs)
+	if err != nil {
+		return err
+	}
+	items := append(unlocked, locked...)
+	if len(items) == 0 {
+		return errors.New("no matching item")
+	}
+	return writeSecret(c, items[0])
+}
+
+func writeSecret(c *client, path dbus.ObjectPath) error {
+	secret, err := c.getSecret(path)
+	if err != nil {
+		return err
+	}
+	_, err = os.Stdout.Write(secret.Value)
+	clear(secret.Value)
+	return err
+}
+
+// runStore creates an item from the secret on standard input.
+func runStore(c *client, args []string) error {
+	fset := flag.NewFlagSet("store", flag.ExitOnError)
+	collection := fset.String("collection", service.DefaultAlias, "collection name, alias or path")
+	label := fset.String("label", "", "item label (default: the attributes)")
+	contentType := fset.String("content-type", "text/plain", "content type of the secret")
+	replace := fset.Bool("replace", true, "replace an existing item with the same attributes")
+	fset.Usage = func() {
+		fmt.Fprintf(fset.Output(), "usage: wsl-secret-ctl store [flags] attribute value ...\n")
+		fset.PrintDefaults()
+	}
+	_ = fset.Parse(args)
+	attrs, err := parseAttributes(fset.Args())
+	if err != nil {
+		return err
+	}
+	if *label == "" {
+		*label = strings.Join(fset.Args(), " ")
+	}
+	col := c.collectionPath(*collection)
+	value, err := readSecret()
+	if err != nil {
+		return err
+	}
+	defer clear(value)
+	item, err := c.createItem(col, *label, attrs, value, *contentType, *replace)
+	if err != nil {
+		return err
+	}
+	colName, uuid := service.ItemUUIDFromPath(item)
+	fmt.Printf("%s/%s\n", colName, uuid)
+	return nil
+}
+
+// readSecret reads a secret from standard input. On a terminal it prompts
+// without echo; piped input is taken verbatim.
+func readSecret() ([]byte, error) {
+	if !isTerminal(os.Stdin) {
+		return io.ReadAll(os.Stdin)
+	}
+	return readPassword("Secret: ")
+}
+
+func isTerminal(f *os.File) bool {
+	_, err := unix.IoctlGetTermios(int(f.Fd()), unix.TCGETS)
+	return err == nil
+}
+
+// readPassword prompts on standard error and reads a line from the terminal
+// on standard input with echo disabled, dropping the line terminator.
+func readPassword(prompt string) ([]byte, error) {
+	fd := int(os.Stdin.Fd())
+	termios, err := unix.IoctlGetTermios(fd, unix.TCGETS)
+	if err != nil {
+		return nil, errors.New("standard input is not a terminal")
+	}
+	fmt.Fprint(os.Stderr, prompt)
+	noEcho := *termios
+	noEcho.Lflag &^= unix.ECHO
+	if err := unix.IoctlSetTermios(fd, unix.TCSETS, &noEcho); err != nil {
+		return nil, fmt.Errorf("disable echo: %w", err)
+	}
+	defer func() {
+		_ = unix.IoctlSetTermios(fd, unix.TCSETS, termios)
+		fmt.Fprintln(os.Stderr)
+	}()
+	var buf [1]byte
+	var value []byte
+	for {
+		n, err := os.Stdin.Read(buf[:])
+		if n == 0 || err != nil || buf[0] == '\n' {
+			break
+		}
+		value = append(value, buf[0])
+	}
+	return bytes.TrimSuffix(value, []byte("\r")), nil
+}
+
+// runDelete deletes an item.
+func runDelete(c *client, args []string) error {
+	if len(args) != 1 {
+		return errors.New("usage: delete item")
+	}
+	path, err := itemPath(args[0])
+	if err != nil {
+		return err
+	}
+	return c.delete(path, service.ItemIface)
+}
+
+// runAlias prints the collection an alias points at, or sets or removes it.
+func runAlias(c *client, args []string) error {
+	switch {
+	case len(args) == 1:
+		path, err := c.readAlias(args[0])
+		if err != nil {
+			return err
+		}
+		if path == "/" {
+			return fmt.Errorf("alias %q is not set", args[0])
+		}
+		fmt.Println(service.CollectionNameFromPath(path))
+		return nil
+	case len(args) == 2 && args[1] == "--unset":
+		return c.setAlias(args[0], "/")
+	case len(args) == 2:
+		return c.setAlias(args[0], c.collectionPath(args[1]))
+	}
+	return errors.New("usage: alias name [collection | --unset]")
+}
+
+// runSetPassword sets, changes or removes the master password of a
+// collection. The passwords are entered in dialogs shown by the daemon, not
+// on this terminal.
+func runSetPassword(c *client, args []string) error {
+	switch {
+	case len(args) == 1:
+		return c.service().Call(service.AdminIface+".SetCollectionPassword", 0, c.collectionPath(args[0]), true).Err
+	case len(args) == 2 && args[1] == "--remove":
+		return c.service().Call(service.AdminIface+".SetCollectionPassword", 0, c.collectionPath(args[0]), false).Err
+	}
+	return errors.New("usage: set-password collection [--remove]")
+}
+
+// runFsck reports inconsistencies between the metadata and the backend and,
+// with --repair, removes them. It fails if problems remain.
+func runFsck(c *client, args []string) error {
+	fset := flag.NewFlagSet("fsck", flag.ExitOnError)
+	repair := fset.Bool("repair", false, "delete items without secrets, dangling aliases and, once confirmed, orphaned secrets")
+	_ = fset.Parse(args)
+	var findings []service.FsckFinding
+	if err := c.service().Call(service.AdminIface+".Fsck", 0, *repair).Store(&findings); err != nil {
+		return err
+	}
+	if len(findings) == 0 {
+		fmt.Println("no problems found")
+		return nil
+	}
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "PROBLEM\tOBJECT\tDETAIL\tSTATUS")
+	remaining := 0
+	for _, f := range findings {
+		status := "repaired"
+		if !f.Repaired {
+			status = "-"
+			remaining++
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\n", f.Kind, f.Object, f.Detail, status)
+	}
+	w.Flush()
+	switch {
+	case remaining == 0:
+		return nil
+	case *repair:
+		return fmt.Errorf("%d problems could not be repaired", remaining)
+	}
+	return fmt.Errorf("%d problems found; run with --repair to fix them", remaining)
+}
@@ -0,0 +1,132 @@
+// SPDX-License-Identifier: Apache-2.0
+
+//go:build linux
+
+package main
+
+import (
+	"cmp"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/akihiro/wsl-secret-service/internal/service"
+	"github.com/godbus/dbus/v5"
+)
+
+// runMigrateFromDBus copies the collections and items of the Secret Service
+// on another bus, e.g. the old keyring daemon started with its own
+// dbus-daemon, into the daemon c is connected to. Collections are matched
+// by name and created if missing; the old default alias is carried over
+// if none is set here. Items whose secret cannot be read, e.g. because an
+// unlock prompt was dismissed, are reported and skipped.
+func runMigrateFromDBus(c *client, args []string) error {
+	fset := flag.NewFlagSet("migrate-from-dbus", flag.ExitOnError)
+	replace := fset.Bool("replace", true, "replace existing items with the same attributes")
+	dryRun := fset.Bool("dry-run", false, "only list what would be copied")
+	fset.Usage = func() {
+		fmt.Fprintf(fset.Output(), "usage: wsl-secret-ctl migrate-from-dbus [flags] address\n\n"+
+			"address is the D-Bus address of the bus the old keyring daemon owns %s on,\n"+
+			"e.g. unix:path=/tmp/old-bus.\n\n", service.BusName)
+		fset.PrintDefaults()
+	}
+	_ = fset.Parse(args)
+	if fset.NArg() != 1 {
+		fset.Usage()
+		os.Exit(2)
+	}
+	address := fset.Arg(0)
+	if address == os.Getenv("DBUS_SESSION_BUS_ADDRESS") {
+		return errors.New("the address is this daemon's own session bus; give the bus of the old keyring")
+	}
+	old, err := dialAddress(address)
+	if err != nil {
+		return err
+	}
+	defer old.Close()
+
+	oldPaths, err := old.collections()
+	if err != nil {
+		return err
+	}
+	if !*dryRun {
+		if err := old.unlock(oldPaths); err != nil {
+			return fmt.Errorf("old keyring: %w", err)
+		}
+	}
+	existing := map[string]dbus.ObjectPath{}
+	paths, err := c.collections()
+	if err != nil {
+		return err
+	}
+	for _, path := range paths {
+		existing[service.CollectionNameFromPath(path)] = path
+	}
+	if err := c.unlock(paths); err != nil {
+		return err
+	}
+	oldDefault, _ := old.readAlias(service.DefaultAlias)
+
+	copied, failed := 0, 0
+	for _, oldPath := range oldPaths {
+		name := service.CollectionNameFromPath(oldPath)
+		var label string
+		if err := old.property(oldPath, service.CollectionIface, "Label", &label); err != nil {
+			return err
+		}
+		var items []dbus.ObjectPath
+		if err := old.property(oldPath, service.CollectionIface, "Items", &items); err != nil {
+			return err
+		}
+		fmt.Fprintf(os.Stderr, "%s (%q): %d items\n", name, label, len(items))
+		if *dryRun {
+			for _, itemPath := range items {
+				item, err := exportItem(old, name, itemPath, false)
+				if err != nil {
+					return err
+				}
+				fmt.Printf("%s\t%q\n", name, item.Label)
+			}
+			continue
+		}
+
+		path, ok := existing[name]
+		if !ok {
+			if path, err = c.createCollection(cmp.Or(label, name)); err != nil {
+				return err
+			}
+			existing[name] = path
+		}
+		if oldPath == oldDefault {
+			if cur, err := c.readAlias(service.DefaultAlias); err == nil && cur == "/" {
+				if err := c.setAlias(service.DefaultAlias, path); err != nil {
+					return err
+				}
+			}
+		}
+		for _, itemPath := range items {
+			item, err := exportItem(old, name, itemPath, true)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "skipping %s: %v\n", itemPath, err)
+				failed++
+				continue
+			}
+			value := item.value()
+			_, err = c.createItem(path, item.Label, item.Attributes, value, cmp.Or(item.ContentType, "text/plain"), *replace)
+			clear(value)
+			if err != nil {
+				return fmt.Errorf("copy %q in %s: %w", item.Label, name, err)
+			}
+			copied++
+		}
+	}
+	if *dryRun {
+		return nil
+	}
+	fmt.Fprintf(os.Stderr, "copied %d items\n", copied)
+	if failed > 0 {
+		return fmt.Errorf("%d items could not be read from the old keyring", failed)
+	}
+	return nil
+}
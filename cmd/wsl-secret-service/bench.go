@@ -0,0 +1,221 @@
+// SPDX-License-Identifier: Apache-2.0
+
+//go:build linux
+
+package main
+
+import (
+	"cmp"
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"math"
+	"math/rand/v2"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/akihiro/wsl-secret-service/internal/backend"
+	"github.com/akihiro/wsl-secret-service/internal/config"
+	"github.com/akihiro/wsl-secret-service/internal/store"
+	"github.com/akihiro/wsl-secret-service/pkg/client"
+)
+
+// benchPrefix is the target prefix the backend round trips list. Nothing
+// is stored under it, so the benchmark changes no credentials.
+const benchPrefix = "wsl-ss/.bench/"
+
+// benchTimeout bounds each section of "bench".
+const benchTimeout = 5 * time.Minute
+
+// runBench implements "wsl-secret-service bench [flags]". It measures the
+// backend round trip (for the wincred backends with a helper per call and
+// with a persistent helper), GetSecrets through the running daemon at
+// several batch sizes, and metadata searches at synthetic item counts, and
+// prints latency percentiles for each.
+func runBench(args []string) error {
+	fset := flag.NewFlagSet("bench", flag.ExitOnError)
+	configDir := fset.String("config-dir", defaultConfigDir(), "metadata storage directory")
+	backendName := fset.String("backend", "", "secret storage backend (default: \"backend\" from config.json, else "+defaultBackend+")")
+	helperPath := fset.String("helper-path", "", "path to wincred-helper.exe (auto-discovered if empty)")
+	iterations := fset.Int("n", 20, "measurements per row")
+	batches := fset.String("batch-sizes", "1,10,50", "comma-separated GetSecrets batch sizes")
+	counts := fset.String("item-counts", "100,1000,10000", "comma-separated item counts for the search benchmark")
+	skip := fset.String("skip", "", "comma-separated sections to skip: backend, getsecrets, search")
+	fset.Usage = func() {
+		fmt.Fprintf(fset.Output(), "usage: wsl-secret-service bench [flags]\n")
+		fset.PrintDefaults()
+	}
+	_ = fset.Parse(args)
+	if *iterations < 1 {
+		return errors.New("-n must be at least 1")
+	}
+	batchSizes, err := parseCounts(*batches)
+	if err != nil {
+		return fmt.Errorf("--batch-sizes: %w", err)
+	}
+	itemCounts, err := parseCounts(*counts)
+	if err != nil {
+		return fmt.Errorf("--item-counts: %w", err)
+	}
+	skipped := strings.Split(*skip, ",")
+
+	if !slices.Contains(skipped, "backend") {
+		cfg, err := config.Load(*configDir)
+		if err != nil {
+			return err
+		}
+		name := cmp.Or(*backendName, cfg.Backend, defaultBackend)
+		opts := backend.Options{ConfigDir: *configDir, HelperPath: *helperPath, ProxyBus: cfg.ProxyBus}
+		benchBackend("backend "+name, name, opts, *iterations)
+		if windowsBackends[name] {
+			opts.PersistentHelper = true
+			benchBackend("backend "+name+" (persistent helper)", name, opts, *iterations)
+		}
+	}
+	if !slices.Contains(skipped, "getsecrets") {
+		if err := benchGetSecrets(batchSizes, *iterations); err != nil {
+			fmt.Printf("%-42s skipped: %v\n", "GetSecrets", err)
+		}
+	}
+	if !slices.Contains(skipped, "search") {
+		for _, n := range itemCounts {
+			benchSearch(n, *iterations)
+		}
+	}
+	return nil
+}
+
+func parseCounts(s string) ([]int, error) {
+	var counts []int
+	for _, f := range strings.Split(s, ",") {
+		n, err := strconv.Atoi(strings.TrimSpace(f))
+		if err != nil || n < 1 {
+			return nil, fmt.Errorf("invalid count %q", f)
+		}
+		counts = append(counts, n)
+	}
+	return counts, nil
+}
+
+// benchBackend measures List round trips to the backend name. The first
+// call, which may start a helper and negotiate its protocol, is not
+// counted.
+func benchBackend(row, name string, opts backend.Options, n int) {
+	be, err := backend.Open(name, opts)
+	if err != nil {
+		fmt.Printf("%-42s skipped: %v\n", row, err)
+		return
+	}
+	if c, ok := be.(io.Closer); ok {
+		defer func() { _ = c.Close() }()
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), benchTimeout)
+	defer cancel()
+	if _, err := be.List(ctx, benchPrefix); err != nil {
+		fmt.Printf("%-42s failed: %v\n", row, err)
+		return
+	}
+	samples := make([]time.Duration, 0, n)
+	for range n {
+		start := time.Now()
+		if _, err := be.List(ctx, benchPrefix); err != nil {
+			fmt.Printf("%-42s failed: %v\n", row, err)
+			return
+		}
+		samples = append(samples, time.Since(start))
+	}
+	printSamples(row, samples, "")
+}
+
+// benchGetSecrets measures GetSecrets calls to the running daemon (started
+// by D-Bus activation if need be) on items of a collection it creates for
+// the purpose and deletes afterwards.
+func benchGetSecrets(batchSizes []int, n int) error {
+	ctx, cancel := context.WithTimeout(context.Background(), benchTimeout)
+	defer cancel()
+	c, err := client.Dial(ctx)
+	if err != nil {
+		return err
+	}
+	defer c.Close()
+	col, err := c.CreateCollection(ctx, "wsl-secret-service bench", "")
+	if err != nil {
+		return err
+	}
+	defer func() { _ = col.Delete(context.Background()) }()
+
+	items := make([]*client.Item, slices.Max(batchSizes))
+	for i := range items {
+		attrs := map[string]string{"wss-bench": strconv.Itoa(i)}
+		if items[i], err = col.CreateItem(ctx, "bench "+strconv.Itoa(i), attrs, client.Secret{Value: []byte("bench secret")}, false); err != nil {
+			return err
+		}
+	}
+	for _, size := range batchSizes {
+		samples := make([]time.Duration, 0, n)
+		for range n {
+			start := time.Now()
+			secrets, err := c.Secrets(ctx, items[:size]...)
+			if err != nil {
+				return err
+			}
+			if len(secrets) != size {
+				return fmt.Errorf("GetSecrets returned %d of %d secrets", len(secrets), size)
+			}
+			samples = append(samples, time.Since(start))
+		}
+		var total time.Duration
+		for _, d := range samples {
+			total += d
+		}
+		rate := float64(size*len(samples)) / total.Seconds()
+		printSamples(fmt.Sprintf("GetSecrets batch %d", size), samples, fmt.Sprintf("  %.0f secrets/s", rate))
+	}
+	return nil
+}
+
+// benchSearch measures attribute searches of a memory store holding count
+// synthetic items, spread over 100 services.
+func benchSearch(count, n int) {
+	st := store.NewMemory()
+	for i := range count {
+		meta := store.ItemMeta{Label: "item " + strconv.Itoa(i), Attributes: map[string]string{
+			"service": "svc" + strconv.Itoa(i%100),
+			"user":    "user" + strconv.Itoa(i),
+		}}
+		if err := st.CreateItem("login", fmt.Sprintf("bench-%d", i), meta); err != nil {
+			fmt.Printf("%-42s failed: %v\n", "search", err)
+			return
+		}
+	}
+	samples := make([]time.Duration, 0, n)
+	for range n {
+		i := rand.IntN(count)
+		attrs := map[string]string{"service": "svc" + strconv.Itoa(i%100), "user": "user" + strconv.Itoa(i)}
+		start := time.Now()
+		refs := st.SearchItems(attrs)
+		samples = append(samples, time.Since(start))
+		if len(refs) != 1 {
+			fmt.Printf("%-42s failed: found %d items, want 1\n", "search", len(refs))
+			return
+		}
+	}
+	printSamples(fmt.Sprintf("search %d items", count), samples, "")
+}
+
+// printSamples prints the percentiles of samples in one row.
+func printSamples(row string, samples []time.Duration, extra string) {
+	slices.Sort(samples)
+	fmt.Printf("%-42s p50 %-10s p90 %-10s p99 %-10s max %-10s%s\n", row,
+		percentile(samples, 0.5), percentile(samples, 0.9), percentile(samples, 0.99), samples[len(samples)-1].Round(time.Microsecond), extra)
+}
+
+// percentile returns the nearest-rank q-quantile of the sorted samples.
+func percentile(sorted []time.Duration, q float64) time.Duration {
+	i := int(math.Ceil(q*float64(len(sorted)))) - 1
+	return sorted[max(i, 0)].Round(time.Microsecond)
+}
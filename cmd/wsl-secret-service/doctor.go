@@ -0,0 +1,102 @@
+// SPDX-License-Identifier: Apache-2.0
+
+//go:build linux
+
+package main
+
+import (
+	"cmp"
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/akihiro/wsl-secret-service/internal/backend"
+	"github.com/akihiro/wsl-secret-service/internal/backend/wincred"
+	"github.com/akihiro/wsl-secret-service/internal/config"
+)
+
+// doctorTimeout bounds the backend round trip of "doctor".
+const doctorTimeout = 20 * time.Second
+
+// runDoctor implements "wsl-secret-service doctor [flags]". It runs the
+// checks the daemon depends on and prints one line per check, with the
+// reason of each failure.
+func runDoctor(args []string) error {
+	fset := flag.NewFlagSet("doctor", flag.ExitOnError)
+	configDir := fset.String("config-dir", defaultConfigDir(), "metadata storage directory")
+	backendName := fset.String("backend", "", "secret storage backend (default: \"backend\" from config.json, else "+defaultBackend+")")
+	helperPath := fset.String("helper-path", "", "path to wincred-helper.exe (auto-discovered if empty)")
+	fset.Usage = func() {
+		fmt.Fprintf(fset.Output(), "usage: wsl-secret-service doctor [flags]\n")
+		fset.PrintDefaults()
+	}
+	_ = fset.Parse(args)
+
+	failed := 0
+	report := func(name string, err error) {
+		if err != nil {
+			fmt.Printf("FAIL %s: %v\n", name, err)
+			failed++
+			return
+		}
+		fmt.Printf("ok   %s\n", name)
+	}
+
+	cfg, err := config.Load(*configDir)
+	report("configuration in "+*configDir, err)
+	if cfg == nil {
+		cfg = &config.Config{}
+	}
+	report("D-Bus session bus", sessionBus())
+
+	name := cmp.Or(*backendName, cfg.Backend, defaultBackend)
+	if name == "wincred" || name == "failover" {
+		report("WSL interop", wincred.CheckInterop())
+		if *helperPath == "" {
+			*helperPath, err = wincred.FindHelper()
+			report("wincred-helper.exe", err)
+		}
+	}
+	be, err := backend.Open(name, backend.Options{
+		ConfigDir:  *configDir,
+		HelperPath: *helperPath,
+		ProxyBus:   cfg.ProxyBus,
+	})
+	if err != nil {
+		report("backend "+name, err)
+	} else {
+		if c, ok := be.(io.Closer); ok {
+			defer func() { _ = c.Close() }()
+		}
+		if c, ok := backend.As[backend.Checker](be); ok {
+			ctx, cancel := context.WithTimeout(context.Background(), doctorTimeout)
+			err = c.Check(ctx)
+			cancel()
+		}
+		report("backend "+name, err)
+	}
+
+	if failed > 0 {
+		return fmt.Errorf("%d checks failed", failed)
+	}
+	return nil
+}
+
+// sessionBus reports whether a D-Bus session bus is there to connect to.
+func sessionBus() error {
+	if os.Getenv("DBUS_SESSION_BUS_ADDRESS") != "" {
+		return nil
+	}
+	if dir := os.Getenv("XDG_RUNTIME_DIR"); dir != "" {
+		if _, err := os.Stat(filepath.Join(dir, "bus")); err == nil {
+			return nil
+		}
+	}
+	return errors.New("DBUS_SESSION_BUS_ADDRESS is not set and $XDG_RUNTIME_DIR/bus does not exist; " +
+		"enable systemd in /etc/wsl.conf ([boot] systemd=true) or start dbus-daemon --session")
+}
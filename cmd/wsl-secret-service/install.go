@@ -0,0 +1,284 @@
+// SPDX-License-Identifier: Apache-2.0
+
+//go:build linux
+
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+
+	"github.com/akihiro/wsl-secret-service/internal/backend/wincred"
+	"github.com/akihiro/wsl-secret-service/internal/service"
+)
+
+// File names of the activation files written by "install".
+const (
+	unitName        = "wsl-secret-service.service"
+	dbusServiceName = service.BusName + ".service"
+	helperExe       = "wincred-helper.exe"
+)
+
+// installPaths are the locations "install" writes to and "uninstall" removes.
+type installPaths struct {
+	unit        string // systemd user unit
+	dbusService string // D-Bus activation file
+	helperDir   string // Windows-side directory receiving wincred-helper.exe
+}
+
+// runInstall implements "wsl-secret-service install [flags] [-- daemon flags]".
+// It copies wincred-helper.exe to a directory on the Windows file system,
+// writes a systemd user unit and a D-Bus activation file that start this
+// binary with --helper-path pointing at the copy, and reloads systemd.
+func runInstall(args []string) error {
+	fset := flag.NewFlagSet("install", flag.ExitOnError)
+	helperSrc := fset.String("helper", "", "wincred-helper.exe to install (default: auto-discovered)")
+	helperDir := fset.String("helper-dir", "", `Windows-accessible directory for wincred-helper.exe (default: %LOCALAPPDATA%\wsl-secret-service)`)
+	enable := fset.Bool("enable", false, "also start the service at login (systemctl --user enable --now)")
+	fset.Usage = func() {
+		fmt.Fprintf(fset.Output(), "usage: wsl-secret-service install [flags] [-- daemon flags]\n")
+		fset.PrintDefaults()
+	}
+	_ = fset.Parse(args)
+	daemonArgs := fset.Args()
+
+	paths, err := resolveInstallPaths(*helperDir)
+	if err != nil {
+		return err
+	}
+	exe, err := os.Executable()
+	if err != nil {
+		return fmt.Errorf("locate wsl-secret-service binary: %w", err)
+	}
+	if exe, err = filepath.EvalSymlinks(exe); err != nil {
+		return fmt.Errorf("locate wsl-secret-service binary: %w", err)
+	}
+	if *helperSrc == "" {
+		if *helperSrc, err = wincred.FindHelper(); err != nil {
+			return fmt.Errorf("%w (use --helper)", err)
+		}
+	}
+
+	// Copy the helper first: running it from the Windows file system avoids
+	// the \\wsl$ file share on every invocation.
+	helperDst := filepath.Join(paths.helperDir, helperExe)
+	if err := os.MkdirAll(paths.helperDir, 0o755); err != nil {
+		return fmt.Errorf("create %s: %w", paths.helperDir, err)
+	}
+	if err := copyFile(*helperSrc, helperDst); err != nil {
+		return fmt.Errorf("install %s: %w", helperExe, err)
+	}
+	fmt.Printf("installed %s\n", helperDst)
+
+	argv := append([]string{exe, "--helper-path", helperDst}, daemonArgs...)
+	if err := writeInstallFile(paths.unit, systemdUnit(argv)); err != nil {
+		return err
+	}
+	if err := writeInstallFile(paths.dbusService, dbusService(argv)); err != nil {
+		return err
+	}
+
+	if err := systemctl("daemon-reload"); err != nil {
+		fmt.Printf("warning: %v; run 'systemctl --user daemon-reload' once systemd is available\n", err)
+	} else if *enable {
+		if err := systemctl("enable", "--now", unitName); err != nil {
+			return err
+		}
+		fmt.Printf("enabled %s\n", unitName)
+	}
+	fmt.Printf("%s will now be started on demand\n", service.BusName)
+	return nil
+}
+
+// runBootstrapHelper implements "wsl-secret-service bootstrap-helper
+// [flags]". It copies wincred-helper.exe to %LOCALAPPDATA% like the daemon
+// does with --bootstrap-helper, and prints where the copy is.
+func runBootstrapHelper(args []string) error {
+	fset := flag.NewFlagSet("bootstrap-helper", flag.ExitOnError)
+	configDir := fset.String("config-dir", defaultConfigDir(), "metadata storage directory, where the copy is recorded")
+	helperSrc := fset.String("helper", "", "wincred-helper.exe to copy (default: auto-discovered)")
+	fset.Usage = func() {
+		fmt.Fprintf(fset.Output(), "usage: wsl-secret-service bootstrap-helper [flags]\n")
+		fset.PrintDefaults()
+	}
+	_ = fset.Parse(args)
+
+	var err error
+	if *helperSrc == "" {
+		if *helperSrc, err = wincred.FindHelper(); err != nil {
+			return fmt.Errorf("%w (use --helper)", err)
+		}
+	}
+	if err := os.MkdirAll(*configDir, 0o700); err != nil {
+		return err
+	}
+	dst, err := wincred.Bootstrap(*helperSrc, *configDir)
+	if err != nil {
+		return err
+	}
+	fmt.Println(dst)
+	return nil
+}
+
+// runUninstall implements "wsl-secret-service uninstall [flags]". It stops
+// and disables the unit and removes every file written by runInstall.
+// Metadata and stored secrets are left untouched.
+func runUninstall(args []string) error {
+	fset := flag.NewFlagSet("uninstall", flag.ExitOnError)
+	helperDir := fset.String("helper-dir", "", "directory wincred-helper.exe was installed to (default: as for install)")
+	_ = fset.Parse(args)
+
+	paths, err := resolveInstallPaths(*helperDir)
+	if err != nil {
+		return err
+	}
+	// The unit may not be loaded or enabled; only the removal below matters.
+	_ = systemctl("disable", "--now", unitName)
+
+	var errs []error
+	for _, p := range []string{paths.unit, paths.dbusService, filepath.Join(paths.helperDir, helperExe)} {
+		switch err := os.Remove(p); {
+		case err == nil:
+			fmt.Printf("removed %s\n", p)
+		case !errors.Is(err, fs.ErrNotExist):
+			errs = append(errs, err)
+		}
+	}
+	_ = os.Remove(paths.helperDir) // only if empty
+
+	if err := systemctl("daemon-reload"); err != nil {
+		fmt.Printf("warning: %v\n", err)
+	}
+	return errors.Join(errs...)
+}
+
+// resolveInstallPaths returns the XDG locations of the activation files and
+// the helper directory, defaulting to %LOCALAPPDATA%\wsl-secret-service.
+func resolveInstallPaths(helperDir string) (installPaths, error) {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return installPaths{}, err
+	}
+	configHome := os.Getenv("XDG_CONFIG_HOME")
+	if configHome == "" {
+		configHome = filepath.Join(home, ".config")
+	}
+	dataHome := os.Getenv("XDG_DATA_HOME")
+	if dataHome == "" {
+		dataHome = filepath.Join(home, ".local", "share")
+	}
+	if helperDir == "" {
+		localAppData, err := wincred.LocalAppData()
+		if err != nil {
+			return installPaths{}, fmt.Errorf("%w (use --helper-dir)", err)
+		}
+		helperDir = filepath.Join(localAppData, "wsl-secret-service")
+	}
+	return installPaths{
+		unit:        filepath.Join(configHome, "systemd", "user", unitName),
+		dbusService: filepath.Join(dataHome, "dbus-1", "services", dbusServiceName),
+		helperDir:   helperDir,
+	}, nil
+}
+
+// systemdUnit returns the user unit running argv. Keep it in sync with the
+// wsl-secret-service.service file at the repository root.
+func systemdUnit(argv []string) string {
+	quoted := make([]string, len(argv))
+	for i, a := range argv {
+		// Escape systemd specifiers and variable expansion.
+		a = strings.NewReplacer("%", "%%", "$", "$$").Replace(a)
+		quoted[i] = quoteArg(a)
+	}
+	return `# Written by "wsl-secret-service install"; remove with "wsl-secret-service uninstall".
+
+[Unit]
+Description=WSL2 Secret Service (Windows Credential Manager backend)
+Documentation=https://specifications.freedesktop.org/secret-service/0.2/
+After=dbus.socket
+Requires=dbus.socket
+
+[Service]
+Type=notify
+NotifyAccess=main
+BusName=` + service.BusName + `
+ExecStart=` + strings.Join(quoted, " ") + `
+Restart=on-failure
+RestartSec=3
+
+[Install]
+WantedBy=default.target
+`
+}
+
+// dbusService returns the D-Bus activation file for the session bus. The
+// bus delegates to the systemd unit and only runs Exec without systemd.
+func dbusService(argv []string) string {
+	quoted := make([]string, len(argv))
+	for i, a := range argv {
+		quoted[i] = quoteArg(a)
+	}
+	return `# Written by "wsl-secret-service install"; remove with "wsl-secret-service uninstall".
+
+[D-BUS Service]
+Name=` + service.BusName + `
+Exec=` + strings.Join(quoted, " ") + `
+SystemdService=` + unitName + `
+`
+}
+
+// quoteArg single-quotes a command-line argument if it contains characters
+// that systemd or dbus-daemon would split or interpret. Both accept
+// shell-style single quotes.
+func quoteArg(a string) string {
+	if a != "" && !strings.ContainsAny(a, " \t\n'\"\\;") {
+		return a
+	}
+	return "'" + strings.ReplaceAll(a, "'", `'\''`) + "'"
+}
+
+// writeInstallFile writes content to path, creating parent directories.
+func writeInstallFile(path, content string) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return fmt.Errorf("create %s: %w", filepath.Dir(path), err)
+	}
+	if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+		return fmt.Errorf("write %s: %w", path, err)
+	}
+	fmt.Printf("wrote %s\n", path)
+	return nil
+}
+
+// copyFile copies src to dst, replacing dst.
+func copyFile(src, dst string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	out, err := os.OpenFile(dst, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0o755)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		return err
+	}
+	return out.Close()
+}
+
+// systemctl runs "systemctl --user args...".
+func systemctl(args ...string) error {
+	cmd := exec.Command("systemctl", append([]string{"--user"}, args...)...)
+	if out, err := cmd.CombinedOutput(); err != nil {
+		return fmt.Errorf("systemctl --user %s: %v: %s", strings.Join(args, " "), err, strings.TrimSpace(string(out)))
+	}
+	return nil
+}
@@ -9,120 +9,606 @@
 // Usage:
 //
 //	wsl-secret-service [flags]
+//	wsl-secret-service install [--helper path] [--helper-dir dir] [--enable] [-- flags]
+//	wsl-secret-service uninstall [--helper-dir dir]
+//	wsl-secret-service recover [--config-dir path] [--backend name] [--dry-run]
+//	wsl-secret-service bootstrap-helper [--helper path] [--config-dir path]
+//	wsl-secret-service migrate-prefix --from prefix --to prefix [--dry-run]
+//	wsl-secret-service doctor [--config-dir path] [--backend name] [--helper-path path]
+//	wsl-secret-service bench [-n count] [--batch-sizes list] [--item-counts list] [--skip sections]
+//
+// install copies wincred-helper.exe to %LOCALAPPDATA%\wsl-secret-service (or
+// --helper-dir) and writes the systemd user unit and D-Bus activation file
+// running this binary with the given daemon flags; uninstall removes them.
+// recover rebuilds items for backend entries missing from the metadata, e.g.
+// after metadata.json was lost. bootstrap-helper copies wincred-helper.exe
+// to %LOCALAPPDATA% as --bootstrap-helper does and prints the copy's path.
+// migrate-prefix moves backend entries to a new target prefix and points
+// their items at them. doctor checks the configuration, WSL interop and a
+// round trip to the backend, and explains what is wrong. bench prints
+// latency percentiles of backend round trips, GetSecrets calls to the
+// running daemon and metadata searches.
 //
 // Flags:
 //
+//	--version                   Print the version, commit and build date, and exit
 //	--config-dir         path   Config/metadata directory (default: $XDG_CONFIG_HOME/wsl-secret-service)
 //	--helper-path        path   Path to wincred-helper.exe (default: auto-discover)
+//	--bootstrap-helper          Copy an auto-discovered helper on the Linux file system to
+//	                            %LOCALAPPDATA%\wsl-secret-service and run the copy; it is
+//	                            copied again when the helper changes (recorded in helper.json)
 //	--replace                   Replace an existing org.freedesktop.secrets name owner
-//	--disable-memprotect        [DEBUG] Disable memory protection (prctl, mlockall)
+//	--disable-memprotect        [DEBUG] Disable memory protection (prctl, locked arena)
+//	--timeout            dur    Shut down after this period of inactivity (default: 30s;
+//	                            0 disables, the default with --ssh-agent)
+//	--low-memory                Keep the mlocked footprint small: no caching, secrets
+//	                            decoded into dedicated locked buffers, plaintext capped
+//	--max-plaintext-secrets n   Cap on concurrently decrypted secrets (default: 0 = unlimited,
+//	                            or 4 with --low-memory)
+//	--secret-cache-ttl   dur    Keep secrets read from the backend in mlocked memory for this
+//	                            long, so repeated reads skip the helper (default: 0 = off)
+//	--credentials-collection s  Collection seeded from $CREDENTIALS_DIRECTORY (default: systemd)
+//	--persistent-helper         Keep one wincred-helper.exe running instead of one per call
+//	--domain-credentials        Expose the Windows domain credentials of the user as items
+//	--mirror-windows-credentials Show credentials of Windows applications as read-only items
+//	--watch-credentials         Emit ItemChanged when items are changed in the Credential Manager
+//	--helper-idle-timeout dur   Stop the persistent helper after this idle period (default: 5m)
+//	--helper-rate-limit  n      Maximum wincred-helper calls per second; excess calls queue
+//	                            (default: 20, 0 = unlimited)
+//	--helper-queue-timeout dur  Fail helper calls queued longer than this (default: 20s)
+//	--backend-timeout    dur    Fail a backend call, such as one wincred-helper run, that takes
+//	                            longer than this; the helper is killed (default: 20s, 0 = never)
+//	--helper-vsock-port  n      Use a resident "wincred-helper.exe --listen-vsock n" over a
+//	                            Hyper-V socket instead of WSL interop (default: 0 = off)
+//	--helper-vsock-token file   The token file that helper writes (required with
+//	                            --helper-vsock-port)
+//	--record-helper      path   Append every wincred-helper request and response to this file,
+//	                            secrets left out, for "mock-wincred-helper --replay"
+//	--persist            scope  Windows persistence scope of new credentials: session |
+//	                            local_machine | enterprise (default: local_machine, or
+//	                            "persistence" from config.json per collection)
+//	--target-names       mode   Credential Manager TargetNames for new items: uuid | label (default: uuid)
+//	--unlock-prompt             Unlock locked collections only after the client completes a Prompt
+//	--isolate-sandboxed-apps    Let Flatpak and Snap applications use only the items they created
+//	--case-insensitive-search   Compare attribute values case-insensitively in SearchItems
+//	--prompter           name   Ask the user to allow unlocks and confirmed reads with: auto,
+//	                            terminal, zenity or windows (default: unlock without asking,
+//	                            confirm in a Windows dialog)
+//	--session-max-age    dur    Close sessions whose key is older than this (default: 0 = never)
+//	--session-idle-timeout dur  Close sessions unused for this long (default: 0 = never)
+//	--legacy-session-kdf        Derive DH session keys with truncated SHA-256 instead of HKDF
+//	--backend            name   Secret storage backend: wincred | failover | file | age | local | pass |
+//	                            proxy | memory
+//	                            (default: wincred, or "backend" from config.json; outside WSL the
+//	                            backends running wincred-helper.exe are replaced by "local_backend"
+//	                            from config.json, default local)
+//	--proxy-bus          addr   D-Bus address of the upstream Secret Service of --backend proxy
+//	                            (default: "proxy_bus" from config.json)
+//	--backend-middleware list   Middlewares stacked on the backend, outermost first: logging,
+//	                            metrics, retry[:attempts], cache[:ttl], writebehind[:delay],
+//	                            envelope, e.g. "cache,retry,metrics"
+//	                            (default: none, or "middleware" from config.json)
+//	--audit-log                 Record secret accesses and the calling process in audit.log
+//	--notify             dest   Send item change events to a local http(s) URL or a FIFO
+//	--log-level          spec   Minimum log level, optionally per subsystem (main, service, store,
+//	                            backend, memprotect, sshagent, notify, dbus), e.g. "warn,store=debug" (default: info)
+//	--log-format         fmt    Log output: text | json (default: text)
+//	--trace                     Log every D-Bus method call and wincred-helper call (caller, target,
+//	                            latency, result) at debug level; secret values are never logged
+//	--store              fmt    Metadata store format: json (one metadata.json) | bolt (a bbolt
+//	                            database, metadata.db) (default: json, or "store" from config.json)
+//	--metadata-backups   n      Rotated metadata backups kept in backups/ in the config dir (default: 5; 0 = none)
+//	--metadata-backup-interval dur
+//	                            Back up before a change only if the newest backup is older (default: 1h)
+//	--ssh-agent          path   Serve SSH keys stored as items (xdg:schema=ssh-key) as an
+//	                            ssh-agent on this socket, e.g. $XDG_RUNTIME_DIR/ssh-agent.sock
+//	--ephemeral                 Keep metadata and secrets in (mlocked) memory only, for CI jobs
+//	                            and throwaway environments: config.json is not read, nothing is
+//	                            written to the config dir or the Credential Manager, and
+//	                            everything is lost on exit. Implies --backend memory
+//
+// The daemon is normally started on demand through D-Bus activation of the
+// wsl-secret-service systemd user unit (Type=notify). It reports readiness
+// with sd_notify once the bus name is claimed and all collections are
+// exported, and releases the name before exiting on --timeout or a signal.
 package main
 
 import (
+	"cmp"
 	"context"
 	"flag"
-	"log"
+	"fmt"
+	"io"
 	"os"
 	"os/signal"
 	"path/filepath"
+	"strings"
 	"syscall"
 	"time"
 
+	"github.com/akihiro/wsl-secret-service/internal/audit"
+	"github.com/akihiro/wsl-secret-service/internal/backend"
+	_ "github.com/akihiro/wsl-secret-service/internal/backend/age"
+	_ "github.com/akihiro/wsl-secret-service/internal/backend/envelope"
+	_ "github.com/akihiro/wsl-secret-service/internal/backend/failover"
+	_ "github.com/akihiro/wsl-secret-service/internal/backend/file"
+	_ "github.com/akihiro/wsl-secret-service/internal/backend/middleware"
+	_ "github.com/akihiro/wsl-secret-service/internal/backend/pass"
+	_ "github.com/akihiro/wsl-secret-service/internal/backend/proxy"
 	"github.com/akihiro/wsl-secret-service/internal/backend/wincred"
+	"github.com/akihiro/wsl-secret-service/internal/config"
+	"github.com/akihiro/wsl-secret-service/internal/ipc"
+	"github.com/akihiro/wsl-secret-service/internal/logging"
 	"github.com/akihiro/wsl-secret-service/internal/memprotect"
+	"github.com/akihiro/wsl-secret-service/internal/notify"
+	"github.com/akihiro/wsl-secret-service/internal/sdnotify"
 	"github.com/akihiro/wsl-secret-service/internal/service"
+	"github.com/akihiro/wsl-secret-service/internal/sshagent"
 	"github.com/akihiro/wsl-secret-service/internal/store"
+	"github.com/akihiro/wsl-secret-service/internal/version"
+	_ "github.com/akihiro/wsl-secret-service/pkg/backend/memory"
 	"github.com/godbus/dbus/v5"
 )
 
+// lowMemoryMaxPlaintext is the plaintext cap applied by --low-memory when
+// --max-plaintext-secrets is not given explicitly.
+const lowMemoryMaxPlaintext = 4
+
+// defaultBackend is used when neither --backend nor the config file names one.
+const defaultBackend = "wincred"
+
+// defaultLocalBackend replaces the backends in windowsBackends outside WSL,
+// unless "local_backend" in the config file names another one.
+const defaultLocalBackend = "local"
+
+// windowsBackends are the backends that run wincred-helper.exe.
+var windowsBackends = map[string]bool{"wincred": true, "failover": true, "file": true, "age": true}
+
 func main() {
+	if len(os.Args) > 1 {
+		var run func([]string) error
+		switch os.Args[1] {
+		case "install":
+			run = runInstall
+		case "uninstall":
+			run = runUninstall
+		case "recover":
+			run = runRecover
+		case "bootstrap-helper":
+			run = runBootstrapHelper
+		case "doctor":
+			run = runDoctor
+		case "bench":
+			run = runBench
+		case "migrate-prefix":
+			run = runMigratePrefix
+		}
+		if run != nil {
+			if err := run(os.Args[2:]); err != nil {
+				fmt.Fprintf(os.Stderr, "wsl-secret-service %s: %v\n", os.Args[1], err)
+				os.Exit(1)
+			}
+			return
+		}
+	}
+
+	showVersion := flag.Bool("version", false, "print the version, commit and build date, and exit")
 	configDir := flag.String("config-dir", defaultConfigDir(), "metadata storage directory")
 	helperPath := flag.String("helper-path", "", "path to wincred-helper.exe (auto-discovered if empty)")
+	bootstrapHelper := flag.Bool("bootstrap-helper", false, "copy an auto-discovered wincred-helper.exe to %LOCALAPPDATA% and run the copy, again after upgrades")
 	replace := flag.Bool("replace", false, "replace an existing org.freedesktop.secrets owner")
-	disableMemprotect := flag.Bool("disable-memprotect", false, "[DEBUG] disable memory protection (prctl, mlockall)")
-	timeout := flag.Duration("timeout", 30*time.Second, "shutdown daemon after this period of inactivity")
+	disableMemprotect := flag.Bool("disable-memprotect", false, "[DEBUG] disable memory protection (prctl, locked arena)")
+	timeout := flag.Duration("timeout", 30*time.Second, "shutdown daemon after this period of inactivity (0 = never)")
+	lowMemory := flag.Bool("low-memory", false, "minimise the mlocked footprint for constrained WSL instances")
+	maxPlaintext := flag.Int("max-plaintext-secrets", 0, "maximum concurrently decrypted secrets (0 = unlimited, 4 with --low-memory)")
+	secretCacheTTL := flag.Duration("secret-cache-ttl", 0, "keep secrets read from the backend in locked memory for this long (0 = no cache)")
+	domainCreds := flag.Bool("domain-credentials", false, "expose the Windows domain credentials of the user (Remote Desktop, network shares) as items")
+	mirrorWindows := flag.Bool("mirror-windows-credentials", false, "show the credentials Windows applications keep in the Credential Manager as read-only items of the \"windows\" collection")
+	watchCreds := flag.Bool("watch-credentials", false, "watch the backend for secrets changed by other programs and emit ItemChanged for them")
+	persistentHelper := flag.Bool("persistent-helper", false, "keep one wincred-helper.exe running instead of spawning one per call")
+	helperIdle := flag.Duration("helper-idle-timeout", 5*time.Minute, "stop the persistent helper after this period without requests")
+	helperRate := flag.Int("helper-rate-limit", 20, "maximum wincred-helper calls per second; excess calls queue (0 = unlimited)")
+	helperQueue := flag.Duration("helper-queue-timeout", 20*time.Second, "fail helper calls that wait longer than this for --helper-rate-limit")
+	backendTimeout := flag.Duration("backend-timeout", 20*time.Second, "fail backend calls taking longer than this, killing a hung wincred-helper (0 = never)")
+	persist := flag.String("persist", "", "Windows persistence scope of new credentials: session, local_machine or enterprise (default local_machine; \"persistence\" in config.json sets it per collection)")
+	recordHelper := flag.String("record-helper", "", "append wincred-helper requests and responses, without secrets, to this file for mock-wincred-helper --replay")
+	helperVsock := flag.Uint("helper-vsock-port", 0, "Hyper-V socket port of a resident wincred-helper.exe (--listen-vsock) to use instead of WSL interop (0 = off)")
+	helperVsockToken := flag.String("helper-vsock-token", "", "token file written by the resident wincred-helper.exe, e.g. under /mnt/c/Users/<you>/AppData/Local/wsl-secret-service (required with --helper-vsock-port)")
+	targetNames := flag.String("target-names", service.TargetNamesUUID, "TargetName scheme for new items: uuid or label")
+	unlockPrompt := flag.Bool("unlock-prompt", false, "require clients to complete a Prompt before locked collections are unlocked")
+	caseInsensitive := flag.Bool("case-insensitive-search", false, "compare attribute values case-insensitively in SearchItems (SearchItemsEx: the default of its ignore-case option)")
+	isolateApps := flag.Bool("isolate-sandboxed-apps", false, "let Flatpak and Snap applications see and use only the items they created")
+	prompterName := flag.String("prompter", "", "ask the user to allow unlocks and confirmed reads with: auto, terminal, zenity or windows (default: unlock without asking, confirm in a Windows dialog)")
+	sessionMaxAge := flag.Duration("session-max-age", 0, "close sessions whose key was negotiated or rotated longer ago than this (0 = never)")
+	sessionIdle := flag.Duration("session-idle-timeout", 0, "close sessions not used for this long (0 = never)")
+	legacyKDF := flag.Bool("legacy-session-kdf", false, "derive DH session keys with truncated SHA-256, as versions before HKDF support did")
+	backendName := flag.String("backend", "", "secret storage backend: "+strings.Join(backend.Names(), ", ")+" (default "+defaultBackend+")")
+	proxyBus := flag.String("proxy-bus", "", "D-Bus address of the upstream Secret Service for --backend proxy, e.g. unix:path=/run/user/1000/upstream-bus")
+	middleware := flag.String("backend-middleware", "", "comma-separated middlewares to stack on the backend, outermost first: "+strings.Join(backend.MiddlewareNames(), ", "))
+	credsCollection := flag.String("credentials-collection", "systemd", "collection seeded from systemd credentials ($CREDENTIALS_DIRECTORY)")
+	auditLog := flag.Bool("audit-log", false, "record secret accesses with the calling process in "+audit.FileName+" in the config dir")
+	notifyDest := flag.String("notify", "", "send item change events (operation, collection, item path) to this local http(s) URL or FIFO")
+	logLevel := flag.String("log-level", "info", "minimum log level (debug, info, warn, error), optionally per subsystem: warn,store=debug")
+	logFormat := flag.String("log-format", logging.FormatText, "log output format: text or json")
+	storeFormat := flag.String("store", "", "metadata store format: "+store.FormatJSON+" or "+store.FormatBolt+" (default "+store.FormatJSON+")")
+	metadataBackups := flag.Int("metadata-backups", 5, "rotated copies of the metadata to keep in backups/ in the config dir (0 = none)")
+	backupInterval := flag.Duration("metadata-backup-interval", time.Hour, "take a metadata backup before a change only if the newest one is older than this")
+	sshAgent := flag.String("ssh-agent", "", "serve ssh keys stored as items (xdg:schema=ssh-key) on this socket path")
+	trace := flag.Bool("trace", false, "log every D-Bus method call and wincred-helper call with its latency and result; secrets are never logged")
+	ephemeral := flag.Bool("ephemeral", false, "keep metadata and secrets in memory only; nothing is read from or written to the config dir or the Credential Manager")
 	flag.Parse()
+	if *showVersion {
+		fmt.Println("wsl-secret-service", version.Long())
+		return
+	}
 
-	log.SetPrefix("wsl-secret-service: ")
-	log.SetFlags(0)
+	levels := *logLevel
+	if *trace {
+		levels += "," + logging.DBus + "=debug," + logging.Backend + "=debug"
+	}
+	if err := logging.Setup(os.Stderr, levels, *logFormat); err != nil {
+		fmt.Fprintf(os.Stderr, "wsl-secret-service: %v\n", err)
+		os.Exit(2)
+	}
+
+	if !ipc.ValidPersist(*persist) {
+		fatal("invalid --persist", "value", *persist, "want", []string{ipc.PersistSession, ipc.PersistLocalMachine, ipc.PersistEnterprise})
+	}
+	if *targetNames != service.TargetNamesUUID && *targetNames != service.TargetNamesLabel {
+		fatal("invalid --target-names", "value", *targetNames, "want", []string{service.TargetNamesUUID, service.TargetNamesLabel})
+	}
 
 	// Harden the process against memory inspection by same-user processes.
 	// prctl(PR_SET_DUMPABLE,0) blocks /proc/<pid>/mem reads and ptrace.
-	// mlockall pins pages in RAM so secrets never reach swap.
+	// Secret material is allocated from a locked arena so it never reaches
+	// swap.
 	if *disableMemprotect {
-		log.Printf("[DEBUG] memory protection disabled")
+		logger.Warn("memory protection disabled (debugging only)")
 	} else {
 		if err := memprotect.HardenProcess(); err != nil {
-			log.Fatalf("harden process: %v", err)
+			fatal("harden process", "err", err)
 		}
-		log.Printf("memory protections applied")
+		logger.Info("memory protections applied", "swap_protection", memprotect.CurrentLockMode().String())
 	}
+	logger.Info("memory protection status", "status", memprotect.QueryStatus().String())
 
-	// Connect to the session D-Bus.
-	conn, err := dbus.ConnectSessionBus()
+	// Connect to the session D-Bus. When started by D-Bus activation, use the
+	// bus that activated us.
+	if activated() {
+		logger.Info("started by D-Bus activation")
+	} else if sdnotify.UnderSystemd() {
+		logger.Info("started by systemd")
+	}
+	var connOpts []dbus.ConnOption
+	if *trace {
+		connOpts = newBusTracer().options()
+	}
+	conn, err := connectBus(connOpts...)
 	if err != nil {
-		log.Fatalf("connect to session bus: %v\n"+
-			"hint: ensure DBUS_SESSION_BUS_ADDRESS is set (run: export $(dbus-launch))", err)
+		fatal("connect to session bus", "err", err,
+			"hint", "ensure DBUS_SESSION_BUS_ADDRESS is set (run: export $(dbus-launch))")
 	}
 	defer func() {
 		if err := conn.Close(); err != nil {
-			log.Printf("close D-Bus connection: %v", err)
+			logger.Warn("close D-Bus connection", "err", err)
 		}
 	}()
 
-	// Request the well-known bus name.
-	nameFlags := dbus.NameFlagDoNotQueue
-	if *replace {
-		nameFlags |= dbus.NameFlagReplaceExisting
+	// Fail early if another instance is running; the name itself is claimed
+	// once all objects are exported (see below).
+	if !*replace {
+		var owned bool
+		if err := conn.BusObject().Call("org.freedesktop.DBus.NameHasOwner", 0, service.BusName).Store(&owned); err != nil {
+			fatal("query D-Bus name", "name", service.BusName, "err", err)
+		}
+		if owned {
+			fatal("D-Bus name is already owned (use --replace to take it over)", "name", service.BusName)
+		}
 	}
-	reply, err := conn.RequestName(service.BusName, nameFlags)
-	if err != nil {
-		log.Fatalf("request D-Bus name %s: %v", service.BusName, err)
+
+	if *ephemeral {
+		if *backendName != "" && *backendName != "memory" {
+			fatal("--ephemeral keeps secrets in memory and cannot be combined with --backend", "backend", *backendName)
+		}
+		if *auditLog {
+			fatal("--ephemeral writes nothing to the config dir and cannot be combined with --audit-log")
+		}
+		*backendName = "memory"
 	}
-	if reply != dbus.RequestNameReplyPrimaryOwner {
-		log.Fatalf("D-Bus name %s is already owned (use --replace to take it over)", service.BusName)
+	cfg := &config.Config{}
+	if !*ephemeral {
+		if cfg, err = config.Load(*configDir); err != nil {
+			fatal("load config", "err", err)
+		}
+	}
+	if cfg.RequireMemoryLock && memprotect.CurrentLockMode() != memprotect.LockArena {
+		fatal("secret material cannot be kept in locked memory and require_memory_lock is set",
+			"hint", "raise the hard RLIMIT_MEMLOCK (e.g. LimitMEMLOCK= in the systemd unit)")
 	}
-	log.Printf("claimed D-Bus name: %s", service.BusName)
 
 	// Initialise the metadata store.
-	st, err := store.New(*configDir)
-	if err != nil {
-		log.Fatalf("open metadata store at %s: %v", *configDir, err)
+	var st *store.Store
+	if *ephemeral {
+		st = store.NewMemory()
+		logger.Info("ephemeral mode: metadata and secrets are kept in memory and lost on exit")
+	} else {
+		*storeFormat = cmp.Or(*storeFormat, cfg.Store, store.FormatJSON)
+		if st, err = store.Open(*configDir, *storeFormat); err != nil {
+			fatal("open metadata store", "dir", *configDir, "format", *storeFormat, "err", err)
+		}
+		logger.Info("metadata store opened", "dir", *configDir, "format", *storeFormat)
+		st.SetBackups(*metadataBackups, *backupInterval)
 	}
-	log.Printf("metadata store: %s", *configDir)
 
-	// Initialise the Windows Credential Manager backend.
-	be, err := wincred.New(*helperPath)
+	// Open the secret storage backend.
+	if *backendName == "" {
+		*backendName = cfg.Backend
+	}
+	if *backendName == "" {
+		*backendName = defaultBackend
+	}
+	if local := cmp.Or(cfg.LocalBackend, defaultLocalBackend); windowsBackends[*backendName] && *helperVsock == 0 && local != "none" {
+		// The same configuration may be shared with native Linux, where
+		// the helper cannot run.
+		if err := wincred.CheckInterop(); err != nil {
+			logger.Warn("wincred-helper.exe cannot run here; using a local backend instead",
+				"backend", *backendName, "local_backend", local, "reason", err)
+			*backendName = local
+		}
+	}
+	beOpts := backend.Options{
+		ConfigDir:            *configDir,
+		HelperPath:           *helperPath,
+		BootstrapHelper:      *bootstrapHelper,
+		ProxyBus:             cmp.Or(*proxyBus, cfg.ProxyBus),
+		PersistentHelper:     *persistentHelper,
+		DomainCredentials:    *domainCreds,
+		HelperIdleTimeout:    *helperIdle,
+		HelperRateLimit:      *helperRate,
+		HelperQueueTimeout:   *helperQueue,
+		HelperVsockPort:      uint32(*helperVsock),
+		HelperVsockTokenFile: *helperVsockToken,
+		RecordHelper:         *recordHelper,
+		Persistence: func(collection string) string {
+			return cmp.Or(cfg.Persistence.Scope(collection), *persist)
+		},
+	}
+	switch {
+	case *lowMemory:
+		beOpts.SecretAlloc, beOpts.SecretRelease = memprotect.LockedAlloc, memprotect.Wipe
+		if *maxPlaintext == 0 {
+			*maxPlaintext = lowMemoryMaxPlaintext
+		}
+		if *secretCacheTTL > 0 {
+			logger.Warn("--secret-cache-ttl is ignored with --low-memory")
+			*secretCacheTTL = 0
+		}
+		logger.Info("low-memory mode: caching disabled", "max_plaintext_secrets", *maxPlaintext)
+	case memprotect.CurrentLockMode() == memprotect.LockArena:
+		// Have the backend decode secrets into the locked arena.
+		beOpts.SecretAlloc, beOpts.SecretRelease = wrapAlloc(memprotect.AllocSecret), memprotect.Wipe
+	}
+	be, err := backend.Open(*backendName, beOpts)
 	if err != nil {
-		log.Fatalf("init wincred backend: %v\n"+
-			"hint: build wincred-helper.exe with 'make build-windows' and place it alongside this binary", err)
+		fatal("init backend", "backend", *backendName, "err", err,
+			"hint", "build wincred-helper.exe with 'make build-windows' and place it alongside this binary")
+	}
+	specs := cfg.Middleware
+	if *middleware != "" {
+		specs = strings.Split(*middleware, ",")
+	}
+	if be, err = backend.Wrap(be, specs, beOpts); err != nil {
+		fatal("init backend middleware", "err", err)
+	}
+	if c, ok := be.(io.Closer); ok {
+		defer func() { _ = c.Close() }()
+	}
+	logger.Info("backend ready", "backend", *backendName, "middleware", specs)
+	if c, ok := backend.As[backend.Checker](be); ok {
+		// Report a disabled WSL interop or a broken helper now rather than
+		// on the first secret access, without delaying D-Bus activation.
+		go func() {
+			ctx, cancel := context.WithTimeout(context.Background(), cmp.Or(*backendTimeout, 20*time.Second))
+			defer cancel()
+			if err := c.Check(ctx); err != nil {
+				logger.Error("backend preflight check failed; secret requests will fail", "backend", *backendName, "err", err,
+					"hint", "run 'wsl-secret-service doctor' for details")
+			}
+		}()
+	}
+
+	// Open the audit log.
+	var auditor *audit.Log
+	if *auditLog || cfg.AuditLog {
+		path := filepath.Join(*configDir, audit.FileName)
+		if auditor, err = audit.Open(path); err != nil {
+			fatal("open audit log", "err", err)
+		}
+		defer func() { _ = auditor.Close() }()
+		logger.Info("audit log enabled", "path", path)
+	}
+
+	var notifier *notify.Notifier
+	if *notifyDest != "" {
+		if notifier, err = notify.Open(*notifyDest); err != nil {
+			fatal("open notification sink", "err", err)
+		}
+		defer func() { _ = notifier.Close() }()
+		logger.Info("change notifications enabled", "dest", *notifyDest)
+	}
+
+	if cfg.Policy != nil {
+		logger.Info("access policy loaded", "rules", len(cfg.Policy.Rules), "default", cmp.Or(cfg.Policy.Default, config.Allow))
+	}
+
+	// The agent socket disappears with the daemon and, unlike the bus name,
+	// cannot restart it, so stay up unless a timeout was asked for.
+	if *sshAgent != "" && !flagSet("timeout") {
+		*timeout = 0
 	}
-	log.Printf("wincred backend ready")
 
 	// Create a context for graceful shutdown.
 	ctx, cancel := context.WithCancel(context.Background())
 	defer cancel()
 
 	// Start the Secret Service with timeout.
-	if _, err := service.New(ctx, conn, st, be, *timeout); err != nil {
-		log.Fatalf("start secret service: %v", err)
+	var prompter service.Prompter
+	if *prompterName != "" {
+		if prompter, err = service.NewPrompter(*prompterName, be); err != nil {
+			fatal("configure prompter", "err", err)
+		}
+	}
+	svcOpts := service.Options{
+		IdleTimeout:           *timeout,
+		MaxPlaintextSecrets:   *maxPlaintext,
+		SecretCacheTTL:        *secretCacheTTL,
+		BackendTimeout:        *backendTimeout,
+		TargetNames:           *targetNames,
+		PromptOnUnlock:        *unlockPrompt,
+		SessionMaxAge:         *sessionMaxAge,
+		SessionIdleTimeout:    *sessionIdle,
+		LegacySessionKDF:      *legacyKDF,
+		AuditLog:              auditor,
+		Notifier:              notifier,
+		Policy:                cfg.Policy,
+		AutoLock:              cfg.AutoLock,
+		Hooks:                 cfg.Hooks,
+		WatchBackend:          *watchCreds,
+		MirrorWindows:         *mirrorWindows,
+		Prompter:              prompter,
+		IsolateApps:           *isolateApps,
+		CaseInsensitiveSearch: *caseInsensitive || cfg.CaseInsensitiveSearch,
+	}
+	if !*ephemeral {
+		svcOpts.LoadConfig = func() (*config.Config, error) { return config.Load(*configDir) }
+	}
+	svc, err := service.New(ctx, conn, st, be, svcOpts)
+	if err != nil {
+		fatal("start secret service", "err", err)
+	}
+	defer svc.Close()
+
+	// Seed secrets passed in via systemd's LoadCredential=/SetCredential=.
+	if credDir := os.Getenv("CREDENTIALS_DIRECTORY"); credDir != "" {
+		n, err := svc.ImportSystemdCredentials(credDir, *credsCollection)
+		if err != nil {
+			logger.Warn("import systemd credentials", "err", err)
+		} else {
+			logger.Info("imported systemd credentials", "count", n, "collection", *credsCollection)
+		}
+	}
+	// Expose entries created outside the daemon (e.g. by pass) as items.
+	if ext, ok := backend.As[backend.ExternalEntries](be); ok {
+		n, err := svc.AdoptExternalEntries(ext.ExternalCollection(), ext.ExternalPrefix())
+		if err != nil {
+			logger.Warn("adopt existing backend entries", "err", err)
+		} else if n > 0 {
+			logger.Info("adopted existing backend entries", "count", n, "collection", ext.ExternalCollection())
+		}
+	}
+	if *mirrorWindows {
+		added, removed, err := svc.MirrorWindowsCredentials()
+		if err != nil {
+			logger.Warn("mirror Windows credentials", "err", err)
+		} else {
+			logger.Info("Windows credentials mirrored", "added", added, "removed", removed)
+		}
+	}
+	// Request the well-known bus name last: under D-Bus activation the
+	// queued request that started us is delivered as soon as we own it.
+	// A later instance started with --replace may take it over, which
+	// shuts this one down (service.ErrNameLost).
+	nameFlags := dbus.NameFlagDoNotQueue | dbus.NameFlagAllowReplacement
+	if *replace {
+		nameFlags |= dbus.NameFlagReplaceExisting
+	}
+	reply, err := conn.RequestName(service.BusName, nameFlags)
+	if err != nil {
+		fatal("request D-Bus name", "name", service.BusName, "err", err)
+	}
+	if reply != dbus.RequestNameReplyPrimaryOwner {
+		fatal("D-Bus name is already owned (use --replace to take it over)", "name", service.BusName)
+	}
+	logger.Info("claimed D-Bus name", "name", service.BusName)
+	if *sshAgent != "" {
+		l, err := sshagent.Listen(*sshAgent)
+		if err != nil {
+			fatal("listen for ssh-agent", "path", *sshAgent, "err", err)
+		}
+		defer l.Close()
+		go func() {
+			if err := sshagent.New(svc).Serve(l); err != nil {
+				logger.Error("ssh-agent", "err", err)
+			}
+		}()
+		logger.Info("ssh-agent listening", "path", *sshAgent)
+	}
+	logger.Info("ready")
+	if _, err := sdnotify.Notify(sdnotify.Ready, sdnotify.Status("serving "+service.BusName)); err != nil {
+		logger.Warn("sd_notify", "err", err)
 	}
-	log.Printf("org.freedesktop.secrets is ready")
 
-	// Set up signal handling for graceful shutdown.
+	// Set up signal handling for graceful shutdown, and SIGUSR1 for a
+	// diagnostic dump.
 	sigChan := make(chan os.Signal, 1)
 	signal.Notify(sigChan, syscall.SIGTERM, syscall.SIGINT)
+	dumpChan := make(chan os.Signal, 1)
+	signal.Notify(dumpChan, syscall.SIGUSR1)
 
 	// Block until shutdown signal or context cancellation.
-	select {
-	case <-ctx.Done():
-		log.Printf("shutdown initiated (idle timeout)")
-	case sig := <-sigChan:
-		log.Printf("received signal: %v, shutting down", sig)
-		cancel()
+wait:
+	for {
+		select {
+		case <-svc.Done():
+			logger.Info("shutting down", "reason", svc.Cause())
+			break wait
+		case sig := <-sigChan:
+			logger.Info("shutting down", "reason", "signal", "signal", sig.String())
+			cancel()
+			break wait
+		case <-dumpChan:
+			svc.DumpState()
+		}
+	}
+	_, _ = sdnotify.Notify(sdnotify.Stopping)
+	// Give up the name first, so that requests arriving while we exit
+	// activate a fresh instance instead of failing against this one.
+	if _, err := conn.ReleaseName(service.BusName); err != nil {
+		logger.Warn("release D-Bus name", "err", err)
 	}
 }
 
+// logger is the main subsystem's logger.
+var logger = logging.For(logging.Main)
+
+// fatal logs msg at error level and exits.
+func fatal(msg string, args ...any) {
+	logger.Error(msg, args...)
+	os.Exit(1)
+}
+
+// flagSet reports whether the named flag was given on the command line.
+func flagSet(name string) bool {
+	set := false
+	flag.Visit(func(f *flag.Flag) { set = set || f.Name == name })
+	return set
+}
+
+// activated reports whether the daemon was started by the D-Bus daemon in
+// response to a request for the session bus name.
+func activated() bool {
+	return os.Getenv("DBUS_STARTER_BUS_TYPE") == "session" && os.Getenv("DBUS_STARTER_ADDRESS") != ""
+}
+
+// connectBus connects to the session bus, preferring the activating bus's
+// DBUS_STARTER_ADDRESS when started by D-Bus activation.
+func connectBus(opts ...dbus.ConnOption) (*dbus.Conn, error) {
+	if activated() {
+		return dbus.Connect(os.Getenv("DBUS_STARTER_ADDRESS"), opts...)
+	}
+	return dbus.ConnectSessionBus(opts...)
+}
+
 // defaultConfigDir returns the XDG-compliant config directory for the service.
 func defaultConfigDir() string {
 	if xdg := os.Getenv("XDG_CONFIG_HOME"); xdg != "" {
@@ -134,3 +620,8 @@ func defaultConfigDir() string {
 	}
 	return filepath.Join(home, ".config", "wsl-secret-service")
 }
+
+// wrapAlloc adapts an infallible allocator to wincred.WithSecretAllocator.
+func wrapAlloc(alloc func(int) []byte) func(int) ([]byte, error) {
+	return func(n int) ([]byte, error) { return alloc(n), nil }
+}
@@ -0,0 +1,78 @@
+// SPDX-License-Identifier: Apache-2.0
+
+//go:build linux
+
+package main
+
+import (
+	"cmp"
+	"context"
+	"flag"
+	"fmt"
+	"io"
+
+	"github.com/akihiro/wsl-secret-service/internal/backend"
+	"github.com/akihiro/wsl-secret-service/internal/config"
+	"github.com/akihiro/wsl-secret-service/internal/service"
+	"github.com/akihiro/wsl-secret-service/internal/store"
+)
+
+// runMigratePrefix implements "wsl-secret-service migrate-prefix --from
+// <prefix> --to <prefix> [flags]". It moves backend entries to a new target
+// prefix and updates the items referring to them, with
+// service.MigratePrefix.
+func runMigratePrefix(args []string) error {
+	fset := flag.NewFlagSet("migrate-prefix", flag.ExitOnError)
+	from := fset.String("from", service.TargetPrefix, "target prefix of the entries to move")
+	to := fset.String("to", "", "target prefix to move them to (required)")
+	configDir := fset.String("config-dir", defaultConfigDir(), "metadata storage directory")
+	backendName := fset.String("backend", "", "secret storage backend (default: \"backend\" from config.json, else "+defaultBackend+")")
+	helperPath := fset.String("helper-path", "", "path to wincred-helper.exe (auto-discovered if empty)")
+	storeFormat := fset.String("store", "", "metadata store format: "+store.FormatJSON+" or "+store.FormatBolt+" (default: \"store\" from config.json, else "+store.FormatJSON+")")
+	dryRun := fset.Bool("dry-run", false, "only print the entries that would be moved")
+	fset.Usage = func() {
+		fmt.Fprintf(fset.Output(), "usage: wsl-secret-service migrate-prefix --from <prefix> --to <prefix> [flags]\n\nStop the daemon first; it refuses changes after the metadata was rewritten.\n\n")
+		fset.PrintDefaults()
+	}
+	_ = fset.Parse(args)
+	if *to == "" {
+		fset.Usage()
+		return fmt.Errorf("--to is required")
+	}
+
+	cfg, err := config.Load(*configDir)
+	if err != nil {
+		return err
+	}
+	st, err := store.Open(*configDir, cmp.Or(*storeFormat, cfg.Store, store.FormatJSON))
+	if err != nil {
+		return fmt.Errorf("open metadata store: %w", err)
+	}
+	be, err := backend.Open(cmp.Or(*backendName, cfg.Backend, defaultBackend), backend.Options{
+		ConfigDir:  *configDir,
+		HelperPath: *helperPath,
+	})
+	if err != nil {
+		return fmt.Errorf("open backend: %w", err)
+	}
+	if c, ok := be.(io.Closer); ok {
+		defer func() { _ = c.Close() }()
+	}
+
+	moved, err := service.MigratePrefix(context.Background(), st, be, *from, *to, *dryRun)
+	for _, e := range moved {
+		fmt.Printf("%s\t%s\t%d items\n", e.From, e.To, e.Items)
+	}
+	if err != nil {
+		return err
+	}
+	switch {
+	case len(moved) == 0:
+		fmt.Println("no entries to move")
+	case *dryRun:
+		fmt.Printf("%d entries would be moved\n", len(moved))
+	default:
+		fmt.Printf("moved %d entries\n", len(moved))
+	}
+	return nil
+}
@@ -0,0 +1,72 @@
+// SPDX-License-Identifier: Apache-2.0
+
+//go:build linux
+
+package main
+
+import (
+	"cmp"
+	"context"
+	"flag"
+	"fmt"
+	"io"
+
+	"github.com/akihiro/wsl-secret-service/internal/backend"
+	"github.com/akihiro/wsl-secret-service/internal/config"
+	"github.com/akihiro/wsl-secret-service/internal/service"
+	"github.com/akihiro/wsl-secret-service/internal/store"
+)
+
+// runRecover implements "wsl-secret-service recover [flags]". It rebuilds
+// the metadata of secrets left in the backend without an item, for example
+// after metadata.json was lost, with service.RecoverMetadata.
+func runRecover(args []string) error {
+	fset := flag.NewFlagSet("recover", flag.ExitOnError)
+	configDir := fset.String("config-dir", defaultConfigDir(), "metadata storage directory")
+	backendName := fset.String("backend", "", "secret storage backend (default: \"backend\" from config.json, else "+defaultBackend+")")
+	helperPath := fset.String("helper-path", "", "path to wincred-helper.exe (auto-discovered if empty)")
+	storeFormat := fset.String("store", "", "metadata store format: "+store.FormatJSON+" or "+store.FormatBolt+" (default: \"store\" from config.json, else "+store.FormatJSON+")")
+	dryRun := fset.Bool("dry-run", false, "only print the items that would be created")
+	fset.Usage = func() {
+		fmt.Fprintf(fset.Output(), "usage: wsl-secret-service recover [flags]\n\nStop the daemon first; it refuses changes after the metadata was rewritten.\n\n")
+		fset.PrintDefaults()
+	}
+	_ = fset.Parse(args)
+
+	cfg, err := config.Load(*configDir)
+	if err != nil {
+		return err
+	}
+	st, err := store.Open(*configDir, cmp.Or(*storeFormat, cfg.Store, store.FormatJSON))
+	if err != nil {
+		return fmt.Errorf("open metadata store: %w", err)
+	}
+	be, err := backend.Open(cmp.Or(*backendName, cfg.Backend, defaultBackend), backend.Options{
+		ConfigDir:  *configDir,
+		HelperPath: *helperPath,
+		ProxyBus:   cfg.ProxyBus,
+	})
+	if err != nil {
+		return fmt.Errorf("open backend: %w", err)
+	}
+	if c, ok := be.(io.Closer); ok {
+		defer func() { _ = c.Close() }()
+	}
+
+	items, err := service.RecoverMetadata(context.Background(), st, be, *dryRun)
+	for _, it := range items {
+		fmt.Printf("%s\t%s/%s\t%q\n", it.Target, it.Collection, it.UUID, it.Label)
+	}
+	if err != nil {
+		return err
+	}
+	switch {
+	case len(items) == 0:
+		fmt.Println("no unreferenced entries found")
+	case *dryRun:
+		fmt.Printf("%d items would be recovered\n", len(items))
+	default:
+		fmt.Printf("recovered %d items\n", len(items))
+	}
+	return nil
+}
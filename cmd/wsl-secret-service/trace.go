@@ -0,0 +1,94 @@
+// SPDX-License-Identifier: Apache-2.0
+
+//go:build linux
+
+package main
+
+import (
+	"sync"
+	"time"
+
+	"github.com/godbus/dbus/v5"
+
+	"github.com/akihiro/wsl-secret-service/internal/logging"
+)
+
+// traceLogger receives the D-Bus trace enabled by --trace.
+var traceLogger = logging.For(logging.DBus)
+
+// busTracer logs every D-Bus method call the daemon receives and the reply
+// it sends, with the caller, the object path, the latency and the result.
+// Message bodies are never logged, as they carry secrets and session keys;
+// only the signature of the arguments is.
+type busTracer struct {
+	mu      sync.Mutex
+	pending map[traceKey]tracedCall
+}
+
+// traceKey identifies a call by its sender and serial, as its reply does by
+// destination and reply serial.
+type traceKey struct {
+	peer   string
+	serial uint32
+}
+
+type tracedCall struct {
+	method string
+	path   dbus.ObjectPath
+	start  time.Time
+}
+
+func newBusTracer() *busTracer {
+	return &busTracer{pending: make(map[traceKey]tracedCall)}
+}
+
+// options returns the connection options installing the tracer.
+func (t *busTracer) options() []dbus.ConnOption {
+	return []dbus.ConnOption{
+		dbus.WithIncomingInterceptor(t.incoming),
+		dbus.WithOutgoingInterceptor(t.outgoing),
+	}
+}
+
+func (t *busTracer) incoming(msg *dbus.Message) {
+	if msg.Type != dbus.TypeMethodCall {
+		return
+	}
+	sender, _ := msg.Headers[dbus.FieldSender].Value().(string)
+	path, _ := msg.Headers[dbus.FieldPath].Value().(dbus.ObjectPath)
+	iface, _ := msg.Headers[dbus.FieldInterface].Value().(string)
+	member, _ := msg.Headers[dbus.FieldMember].Value().(string)
+	sig, _ := msg.Headers[dbus.FieldSignature].Value().(dbus.Signature)
+	method := member
+	if iface != "" {
+		method = iface + "." + member
+	}
+	traceLogger.Debug("D-Bus call", "sender", sender, "path", path, "method", method, "signature", sig.String(), "serial", msg.Serial())
+	if msg.Flags&dbus.FlagNoReplyExpected != 0 {
+		return
+	}
+	t.mu.Lock()
+	t.pending[traceKey{sender, msg.Serial()}] = tracedCall{method: method, path: path, start: time.Now()}
+	t.mu.Unlock()
+}
+
+func (t *busTracer) outgoing(msg *dbus.Message) {
+	if msg.Type != dbus.TypeMethodReply && msg.Type != dbus.TypeError {
+		return
+	}
+	dest, _ := msg.Headers[dbus.FieldDestination].Value().(string)
+	serial, _ := msg.Headers[dbus.FieldReplySerial].Value().(uint32)
+	key := traceKey{dest, serial}
+	t.mu.Lock()
+	call, ok := t.pending[key]
+	delete(t.pending, key)
+	t.mu.Unlock()
+	if !ok {
+		return // a reply to a call made before the tracer saw it
+	}
+	result := "ok"
+	if msg.Type == dbus.TypeError {
+		result, _ = msg.Headers[dbus.FieldErrorName].Value().(string)
+	}
+	traceLogger.Debug("D-Bus reply", "sender", dest, "path", call.path, "method", call.method, "duration", time.Since(call.start), "result", result)
+}
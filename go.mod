@@ -6,6 +6,13 @@ require (
 	github.com/danieljoos/wincred v1.2.3
 	github.com/godbus/dbus/v5 v5.2.2
 	github.com/google/uuid v1.6.0
+	go.etcd.io/bbolt v1.4.3
 )
 
-require golang.org/x/sys v0.27.0
+require (
+	filippo.io/age v1.3.2
+	golang.org/x/crypto v0.55.0
+	golang.org/x/sys v0.47.0
+)
+
+require filippo.io/hpke v0.4.0 // indirect
@@ -0,0 +1,85 @@
+// SPDX-License-Identifier: Apache-2.0
+
+// Package audit records which client accessed which secret in an
+// append-only log file (audit.log in the config directory), one JSON object
+// per line. Entries describe the caller, the operation and the object
+// concerned; secret values are never logged.
+package audit
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+)
+
+// FileName is the name of the audit log inside the config directory.
+const FileName = "audit.log"
+
+// Operations recorded in Entry.Op.
+const (
+	OpGetSecret  = "GetSecret"
+	OpSetSecret  = "SetSecret"
+	OpCreateItem = "CreateItem"
+	OpDelete     = "Delete"
+	OpSearch     = "Search"
+	OpSSHSign    = "SSHSign" // signature by the ssh-agent; no D-Bus sender
+	OpConfirm    = "Confirm" // the user's answer to an approval dialog
+)
+
+// Result values for successful operations; failures record the D-Bus error
+// name instead.
+const ResultOK = "ok"
+
+// Entry is one audit record.
+type Entry struct {
+	Time       time.Time         `json:"time"`
+	Sender     string            `json:"sender"`        // unique D-Bus name of the caller
+	PID        uint32            `json:"pid,omitempty"` // 0 if unknown
+	UID        *uint32           `json:"uid,omitempty"`
+	Exe        string            `json:"exe,omitempty"` // resolved /proc/<pid>/exe
+	Op         string            `json:"op"`
+	Object     string            `json:"object"`               // item or collection path
+	Attributes map[string]string `json:"attributes,omitempty"` // search criteria
+	Result     string            `json:"result"`
+}
+
+// Log appends entries to an audit file. It is safe for concurrent use.
+type Log struct {
+	mu sync.Mutex
+	f  *os.File
+}
+
+// Open opens (creating if necessary) the audit log at path for appending.
+func Open(path string) (*Log, error) {
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0o600)
+	if err != nil {
+		return nil, fmt.Errorf("open audit log: %w", err)
+	}
+	return &Log{f: f}, nil
+}
+
+// Record appends e, filling in the time if unset.
+func (l *Log) Record(e Entry) error {
+	if e.Time.IsZero() {
+		e.Time = time.Now()
+	}
+	line, err := json.Marshal(e)
+	if err != nil {
+		return fmt.Errorf("marshal audit entry: %w", err)
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if _, err := l.f.Write(append(line, '\n')); err != nil {
+		return fmt.Errorf("write audit log: %w", err)
+	}
+	return nil
+}
+
+// Close closes the log file.
+func (l *Log) Close() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.f.Close()
+}
@@ -0,0 +1,66 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package audit
+
+import (
+	"bufio"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestRecordAppends(t *testing.T) {
+	path := filepath.Join(t.TempDir(), FileName)
+	uid := uint32(1000)
+
+	for i := range 2 {
+		l, err := Open(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		e := Entry{Sender: ":1.42", PID: 1234, UID: &uid, Exe: "/usr/bin/secret-tool",
+			Op: OpGetSecret, Object: "/org/freedesktop/secrets/collection/login/x", Result: ResultOK}
+		if i == 1 {
+			e.Op, e.Result = OpDelete, "org.freedesktop.Secret.Error.IsLocked"
+		}
+		if err := l.Record(e); err != nil {
+			t.Fatal(err)
+		}
+		if err := l.Close(); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	var got []Entry
+	sc := bufio.NewScanner(f)
+	for sc.Scan() {
+		var e Entry
+		if err := json.Unmarshal(sc.Bytes(), &e); err != nil {
+			t.Fatalf("invalid line %q: %v", sc.Text(), err)
+		}
+		got = append(got, e)
+	}
+	if len(got) != 2 {
+		t.Fatalf("got %d entries, want 2", len(got))
+	}
+	if got[0].Op != OpGetSecret || got[0].Exe != "/usr/bin/secret-tool" || got[0].UID == nil || *got[0].UID != uid || got[0].Time.IsZero() {
+		t.Errorf("entry 0 = %+v", got[0])
+	}
+	if got[1].Op != OpDelete || got[1].Result != "org.freedesktop.Secret.Error.IsLocked" {
+		t.Errorf("entry 1 = %+v", got[1])
+	}
+
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if perm := info.Mode().Perm(); perm != 0o600 {
+		t.Errorf("mode = %o, want 600", perm)
+	}
+}
@@ -0,0 +1,306 @@
+// SPDX-License-Identifier: Apache-2.0
+
+// Package age provides a backend that encrypts each secret with age
+// (X25519) and stores the ciphertext as one file per target under the
+// config directory. The age identity itself is kept in the Windows
+// Credential Manager, so the files are useless without the Windows user's
+// credentials, yet secrets are not limited to 2560 bytes and only a single
+// Credential Manager entry is created.
+package age
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+
+	"filippo.io/age"
+
+	"github.com/akihiro/wsl-secret-service/internal/backend"
+	"github.com/akihiro/wsl-secret-service/internal/backend/wincred"
+)
+
+// IdentityTarget is the Credential Manager entry holding the age identity.
+const IdentityTarget = "wsl-ss/.age-identity"
+
+// DirName is the directory inside the config directory holding ciphertexts.
+const DirName = "age"
+
+// LocalDirName is the directory inside the config directory holding the
+// ciphertexts of the "local" backend, and LocalIdentityFile the name of its
+// age key in there.
+const (
+	LocalDirName      = "local"
+	LocalIdentityFile = "identity.txt"
+)
+
+func init() {
+	backend.Register("age", func(opts backend.Options) (backend.Backend, error) {
+		// The bridge only fetches the identity, so the secret allocator is
+		// not needed for it.
+		opts.SecretAlloc, opts.SecretRelease = nil, nil
+		bridge, err := wincred.Open(opts)
+		if err != nil {
+			return nil, err
+		}
+		return New(filepath.Join(opts.ConfigDir, DirName), bridge)
+	})
+	// "local" needs no Windows at all, for Linux outside WSL.
+	backend.Register("local", func(opts backend.Options) (backend.Backend, error) {
+		dir := filepath.Join(opts.ConfigDir, LocalDirName)
+		return NewLocal(dir, filepath.Join(dir, LocalIdentityFile))
+	})
+}
+
+// Backend implements backend.Backend with age-encrypted files.
+type Backend struct {
+	dir     string
+	keyring backend.Backend // holds IdentityTarget
+
+	mu       sync.Mutex
+	identity *age.X25519Identity // loaded lazily
+}
+
+// New returns a Backend storing ciphertexts in dir, with the identity kept
+// in keyring under IdentityTarget (generated on first use).
+func New(dir string, keyring backend.Backend) (*Backend, error) {
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return nil, fmt.Errorf("create age directory: %w", err)
+	}
+	return &Backend{dir: dir, keyring: keyring}, nil
+}
+
+// NewLocal returns a Backend storing ciphertexts in dir, with the identity
+// kept in the file identityPath (generated on first use) instead of the
+// Credential Manager, so that it works without wincred-helper.exe. The
+// ciphertexts are then only as safe as that file.
+func NewLocal(dir, identityPath string) (*Backend, error) {
+	return New(dir, &identityFile{path: identityPath})
+}
+
+// Close releases the keyring if it holds resources.
+func (b *Backend) Close() error {
+	if c, ok := b.keyring.(io.Closer); ok {
+		return c.Close()
+	}
+	return nil
+}
+
+// Flush implements backend.Flusher: the cached identity is forgotten and
+// re-read from the keyring on next use.
+func (b *Backend) Flush() error {
+	b.mu.Lock()
+	b.identity = nil
+	b.mu.Unlock()
+	if f, ok := b.keyring.(backend.Flusher); ok {
+		return f.Flush()
+	}
+	return nil
+}
+
+// Get returns the raw secret bytes for the given target.
+func (b *Backend) Get(ctx context.Context, target string) ([]byte, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	f, err := os.Open(b.path(target))
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, &backend.ErrNotFound{Target: target}
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	id, err := b.loadIdentity(ctx, false)
+	if err != nil {
+		return nil, err
+	}
+	r, err := age.Decrypt(f, id)
+	if err != nil {
+		return nil, fmt.Errorf("age decrypt %s: %w", target, err)
+	}
+	return io.ReadAll(r)
+}
+
+// Set stores raw secret bytes under the given target.
+func (b *Backend) Set(ctx context.Context, target string, secret []byte) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	id, err := b.loadIdentity(ctx, true)
+	if err != nil {
+		return err
+	}
+	var buf bytes.Buffer
+	w, err := age.Encrypt(&buf, id.Recipient())
+	if err != nil {
+		return fmt.Errorf("age encrypt %s: %w", target, err)
+	}
+	if _, err := w.Write(secret); err != nil {
+		return fmt.Errorf("age encrypt %s: %w", target, err)
+	}
+	if err := w.Close(); err != nil {
+		return fmt.Errorf("age encrypt %s: %w", target, err)
+	}
+
+	path := b.path(target)
+	if err := os.WriteFile(path+".tmp", buf.Bytes(), 0o600); err != nil {
+		return fmt.Errorf("write %s: %w", target, err)
+	}
+	return os.Rename(path+".tmp", path)
+}
+
+// Delete removes the secret for the given target.
+func (b *Backend) Delete(ctx context.Context, target string) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if err := os.Remove(b.path(target)); err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return &backend.ErrNotFound{Target: target}
+		}
+		return err
+	}
+	return nil
+}
+
+// List returns all target strings that have the given prefix.
+func (b *Backend) List(ctx context.Context, prefix string) ([]string, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	entries, err := os.ReadDir(b.dir)
+	if err != nil {
+		return nil, err
+	}
+	targets := []string{}
+	for _, e := range entries {
+		name, ok := strings.CutSuffix(e.Name(), ".age")
+		if !ok {
+			continue
+		}
+		target, err := url.PathUnescape(name)
+		if err != nil {
+			continue
+		}
+		if strings.HasPrefix(target, prefix) {
+			targets = append(targets, target)
+		}
+	}
+	sort.Strings(targets)
+	return targets, nil
+}
+
+// ListEntries implements backend.EntryLister: LastWritten is the
+// modification time of the entry's file.
+func (b *Backend) ListEntries(ctx context.Context, prefix string) ([]backend.Entry, error) {
+	targets, err := b.List(ctx, prefix)
+	if err != nil {
+		return nil, err
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	entries := make([]backend.Entry, 0, len(targets))
+	for _, t := range targets {
+		info, err := os.Stat(b.path(t))
+		if errors.Is(err, fs.ErrNotExist) {
+			continue // deleted meanwhile
+		}
+		if err != nil {
+			return nil, err
+		}
+		entries = append(entries, backend.Entry{Target: t, LastWritten: info.ModTime().UTC()})
+	}
+	return entries, nil
+}
+
+// path returns the ciphertext file for target. Targets are escaped into a
+// single path component, so they can never point outside b.dir.
+func (b *Backend) path(target string) string {
+	return filepath.Join(b.dir, url.PathEscape(target)+".age")
+}
+
+// loadIdentity returns the age identity, fetching it from the keyring on
+// first use. If create is set and the keyring has none, a new identity is
+// generated and stored. Caller must hold b.mu.
+func (b *Backend) loadIdentity(ctx context.Context, create bool) (*age.X25519Identity, error) {
+	if b.identity != nil {
+		return b.identity, nil
+	}
+	raw, err := b.keyring.Get(ctx, IdentityTarget)
+	var nf *backend.ErrNotFound
+	switch {
+	case err == nil:
+		id, err := age.ParseX25519Identity(strings.TrimSpace(string(raw)))
+		clear(raw)
+		if err != nil {
+			return nil, fmt.Errorf("parse age identity: %w", err)
+		}
+		b.identity = id
+	case errors.As(err, &nf) && create:
+		id, err := age.GenerateX25519Identity()
+		if err != nil {
+			return nil, fmt.Errorf("generate age identity: %w", err)
+		}
+		if err := b.keyring.Set(ctx, IdentityTarget, []byte(id.String())); err != nil {
+			return nil, fmt.Errorf("store age identity: %w", err)
+		}
+		b.identity = id
+	default:
+		return nil, fmt.Errorf("load age identity: %w", err)
+	}
+	return b.identity, nil
+}
+
+// identityFile is a keyring holding only IdentityTarget, in a file.
+type identityFile struct {
+	path string
+}
+
+func (f *identityFile) Get(_ context.Context, target string) ([]byte, error) {
+	if target != IdentityTarget {
+		return nil, &backend.ErrNotFound{Target: target}
+	}
+	raw, err := os.ReadFile(f.path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, &backend.ErrNotFound{Target: target}
+	}
+	return raw, err
+}
+
+func (f *identityFile) Set(_ context.Context, target string, secret []byte) error {
+	if target != IdentityTarget {
+		return fmt.Errorf("identity file cannot hold %q", target)
+	}
+	if err := os.WriteFile(f.path+".tmp", secret, 0o600); err != nil {
+		return fmt.Errorf("write %s: %w", f.path, err)
+	}
+	return os.Rename(f.path+".tmp", f.path)
+}
+
+func (f *identityFile) Delete(_ context.Context, target string) error {
+	if target != IdentityTarget {
+		return &backend.ErrNotFound{Target: target}
+	}
+	err := os.Remove(f.path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return &backend.ErrNotFound{Target: target}
+	}
+	return err
+}
+
+func (f *identityFile) List(_ context.Context, prefix string) ([]string, error) {
+	if _, err := os.Stat(f.path); err == nil && strings.HasPrefix(IdentityTarget, prefix) {
+		return []string{IdentityTarget}, nil
+	}
+	return []string{}, nil
+}
@@ -0,0 +1,83 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package age
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/akihiro/wsl-secret-service/internal/backend"
+	"github.com/akihiro/wsl-secret-service/pkg/backend/memory"
+)
+
+func TestRoundTripAndIdentityReuse(t *testing.T) {
+	dir := t.TempDir()
+	keyring := memory.New()
+	b, err := New(dir, keyring)
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+	big := bytes.Repeat([]byte("s"), 8000)
+	if err := b.Set(t.Context(), "wsl-ss/login/a", big); err != nil {
+		t.Fatalf("Set: %v", err)
+	}
+	if _, err := keyring.Get(t.Context(), IdentityTarget); err != nil {
+		t.Fatalf("identity not stored in keyring: %v", err)
+	}
+
+	// A fresh instance must decrypt with the identity from the keyring.
+	b2, _ := New(dir, keyring)
+	got, err := b2.Get(t.Context(), "wsl-ss/login/a")
+	if err != nil {
+		t.Fatalf("Get: %v", err)
+	}
+	if !bytes.Equal(got, big) {
+		t.Errorf("Get returned %d bytes, want %d", len(got), len(big))
+	}
+
+	targets, err := b2.List(t.Context(), "wsl-ss/")
+	if err != nil || len(targets) != 1 || targets[0] != "wsl-ss/login/a" {
+		t.Errorf("List = %v, %v", targets, err)
+	}
+
+	entries, _ := os.ReadDir(dir)
+	for _, e := range entries {
+		data, _ := os.ReadFile(dir + "/" + e.Name())
+		if bytes.Contains(data, big[:64]) {
+			t.Errorf("%s contains plaintext", e.Name())
+		}
+	}
+}
+
+func TestGetWithoutIdentity(t *testing.T) {
+	b, _ := New(t.TempDir(), memory.New())
+	var nf *backend.ErrNotFound
+	if _, err := b.Get(t.Context(), "missing"); !errors.As(err, &nf) {
+		t.Errorf("Get: err = %v, want ErrNotFound", err)
+	}
+	if err := b.Delete(t.Context(), "missing"); !errors.As(err, &nf) {
+		t.Errorf("Delete: err = %v, want ErrNotFound", err)
+	}
+}
+
+func TestLocalBackend(t *testing.T) {
+	configDir := t.TempDir()
+	b, err := backend.Open("local", backend.Options{ConfigDir: configDir})
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	if err := b.Set(t.Context(), "wsl-ss/login/a", []byte("s3cret")); err != nil {
+		t.Fatalf("Set: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(configDir, LocalDirName, LocalIdentityFile)); err != nil {
+		t.Errorf("identity not stored in a file: %v", err)
+	}
+
+	b2, _ := backend.Open("local", backend.Options{ConfigDir: configDir})
+	if got, err := b2.Get(t.Context(), "wsl-ss/login/a"); err != nil || string(got) != "s3cret" {
+		t.Errorf("Get = %q, %v", got, err)
+	}
+}
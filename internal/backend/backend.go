@@ -5,22 +5,107 @@
 // metadata (labels, attributes) is managed separately by the store package.
 package backend
 
+import (
+	"context"
+	"fmt"
+)
+
 // Backend stores and retrieves raw secret bytes keyed by a target string.
+// Every method gives up and returns an error wrapping ctx.Err() once ctx is
+// done; helper processes started for the call are killed.
 type Backend interface {
 	// Get returns the raw secret bytes for the given target.
 	// Returns an error wrapping ErrNotFound if the target does not exist.
-	Get(target string) ([]byte, error)
+	Get(ctx context.Context, target string) ([]byte, error)
 
 	// Set stores raw secret bytes under the given target.
 	// Creates the entry if it does not exist; replaces it if it does.
-	Set(target string, secret []byte) error
+	Set(ctx context.Context, target string, secret []byte) error
 
 	// Delete removes the secret for the given target.
 	// Returns an error wrapping ErrNotFound if the target does not exist.
-	Delete(target string) error
+	Delete(ctx context.Context, target string) error
 
 	// List returns all target strings that have the given prefix.
-	List(prefix string) ([]string, error)
+	List(ctx context.Context, prefix string) ([]string, error)
+}
+
+// Verifier is implemented by backends that can ask the user to confirm
+// their presence (e.g. with Windows Hello) before a secret is released.
+type Verifier interface {
+	// Verify shows message to the user and returns nil only if they
+	// successfully verified their identity.
+	Verify(ctx context.Context, message string) error
+}
+
+// Approver is implemented by backends that can ask the user to allow or
+// deny an access in a dialog on their desktop.
+type Approver interface {
+	// Approve shows message and returns nil only if the user allowed the
+	// access.
+	Approve(ctx context.Context, message string) error
+}
+
+// PasswordPrompter is implemented by backends that can ask the user for a
+// password in a dialog on their desktop.
+type PasswordPrompter interface {
+	// PromptPassword shows message and returns the password the user
+	// entered for collection, or an error if they cancelled. The caller
+	// should clear the result.
+	PromptPassword(ctx context.Context, collection, message string) ([]byte, error)
+}
+
+// Checker is implemented by backends that can check that their storage is
+// reachable, so that a misconfiguration is reported at startup rather than
+// on the first secret access.
+type Checker interface {
+	Check(ctx context.Context) error
+}
+
+type windowKey struct{}
+
+// WithWindow returns a context for an Approve or PromptPassword call whose
+// dialog belongs to the client window titled title, so that backends can
+// show it in front of that window rather than on its own.
+func WithWindow(ctx context.Context, title string) context.Context {
+	return context.WithValue(ctx, windowKey{}, title)
+}
+
+// WindowFrom returns the window title attached to ctx by WithWindow, or "".
+func WindowFrom(ctx context.Context) string {
+	title, _ := ctx.Value(windowKey{}).(string)
+	return title
+}
+
+// ExternalEntries is implemented by backends whose storage may contain
+// entries created outside the daemon (for example by pass(1)). Such entries
+// starting with ExternalPrefix() ("" = all) are adopted as items of the
+// collection labelled ExternalCollection().
+type ExternalEntries interface {
+	ExternalCollection() string
+	ExternalPrefix() string
+}
+
+// Flusher is implemented by backends that keep state in memory between
+// calls (cached keys, helper processes). Flush discards it; it is rebuilt
+// on demand by later calls.
+type Flusher interface {
+	Flush() error
+}
+
+// Change describes a target that was created, changed or deleted in the
+// backend's storage.
+type Change struct {
+	Target  string
+	Deleted bool
+}
+
+// Watcher is implemented by backends whose storage can be changed by other
+// programs, such as the Windows Credential Manager. Watch calls changed for
+// every change to a target starting with prefix, which may include changes
+// made through the backend itself, until ctx is done or watching fails.
+type Watcher interface {
+	Watch(ctx context.Context, prefix string, changed func(Change)) error
 }
 
 // ErrNotFound is returned when a requested secret does not exist.
@@ -31,3 +116,30 @@ type ErrNotFound struct {
 func (e *ErrNotFound) Error() string {
 	return "secret not found: " + e.Target
 }
+
+// ErrTargetTooLong is returned by Set when the target name exceeds what the
+// backend can store. Callers may store the secret under a shorter name.
+type ErrTargetTooLong struct {
+	Target string
+	Max    int
+}
+
+func (e *ErrTargetTooLong) Error() string {
+	return fmt.Sprintf("target name too long (max %d characters): %.64s...", e.Max, e.Target)
+}
+
+// ErrUnavailable is returned when the storage behind a backend cannot be
+// reached, for example because wincred-helper.exe cannot be started or
+// Windows keeps failing with a transient error. Unlike other errors it says
+// nothing about the request, which may succeed later.
+type ErrUnavailable struct {
+	Err error
+}
+
+func (e *ErrUnavailable) Error() string {
+	return "backend unavailable: " + e.Err.Error()
+}
+
+func (e *ErrUnavailable) Unwrap() error {
+	return e.Err
+}
@@ -0,0 +1,72 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package backend
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"maps"
+	"slices"
+)
+
+// Batcher is implemented by backends that can handle several targets in one
+// round trip, for example with a single helper call. Callers use GetMany,
+// SetMany and DeleteMany, which fall back to one call per target for
+// backends that cannot batch.
+type Batcher interface {
+	// GetMany returns the secrets of targets; secrets[i] and errs[i]
+	// belong to targets[i], and errs[i] wraps ErrNotFound for a missing
+	// target.
+	GetMany(ctx context.Context, targets []string) (secrets [][]byte, errs []error)
+
+	// SetMany stores every secret under its target. It returns an error
+	// if any of them could not be stored.
+	SetMany(ctx context.Context, secrets map[string][]byte) error
+
+	// DeleteMany removes targets; errs[i] belongs to targets[i].
+	DeleteMany(ctx context.Context, targets []string) (errs []error)
+}
+
+// GetMany reads the secrets of targets from b, in one batch if b is a
+// Batcher. See Batcher.GetMany.
+func GetMany(ctx context.Context, b Backend, targets []string) ([][]byte, []error) {
+	if bb, ok := b.(Batcher); ok {
+		return bb.GetMany(ctx, targets)
+	}
+	secrets := make([][]byte, len(targets))
+	errs := make([]error, len(targets))
+	for i, t := range targets {
+		secrets[i], errs[i] = b.Get(ctx, t)
+	}
+	return secrets, errs
+}
+
+// SetMany stores secrets in b, in one batch if b is a Batcher. Without
+// batching every target is attempted and the failures are joined, in target
+// order.
+func SetMany(ctx context.Context, b Backend, secrets map[string][]byte) error {
+	if bb, ok := b.(Batcher); ok {
+		return bb.SetMany(ctx, secrets)
+	}
+	var errs []error
+	for _, t := range slices.Sorted(maps.Keys(secrets)) {
+		if err := b.Set(ctx, t, secrets[t]); err != nil {
+			errs = append(errs, fmt.Errorf("set %q: %w", t, err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// DeleteMany removes targets from b, in one batch if b is a Batcher. See
+// Batcher.DeleteMany.
+func DeleteMany(ctx context.Context, b Backend, targets []string) []error {
+	if bb, ok := b.(Batcher); ok {
+		return bb.DeleteMany(ctx, targets)
+	}
+	errs := make([]error, len(targets))
+	for i, t := range targets {
+		errs[i] = b.Delete(ctx, t)
+	}
+	return errs
+}
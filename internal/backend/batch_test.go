@@ -0,0 +1,79 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package backend_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/akihiro/wsl-secret-service/internal/backend"
+	"github.com/akihiro/wsl-secret-service/pkg/backend/memory"
+)
+
+// batching wraps a backend and counts batched calls.
+type batching struct {
+	*memory.Backend
+	calls int
+}
+
+func (b *batching) GetMany(ctx context.Context, targets []string) ([][]byte, []error) {
+	b.calls++
+	secrets := make([][]byte, len(targets))
+	errs := make([]error, len(targets))
+	for i, t := range targets {
+		secrets[i], errs[i] = b.Get(ctx, t)
+	}
+	return secrets, errs
+}
+
+func (b *batching) SetMany(ctx context.Context, secrets map[string][]byte) error {
+	b.calls++
+	for t, s := range secrets {
+		_ = b.Set(ctx, t, s)
+	}
+	return nil
+}
+
+func (b *batching) DeleteMany(ctx context.Context, targets []string) []error {
+	b.calls++
+	errs := make([]error, len(targets))
+	for i, t := range targets {
+		errs[i] = b.Delete(ctx, t)
+	}
+	return errs
+}
+
+func TestBatchFallback(t *testing.T) {
+	be := memory.New()
+	if err := backend.SetMany(t.Context(), be, map[string][]byte{"a": []byte("1"), "b": []byte("2")}); err != nil {
+		t.Fatalf("SetMany: %v", err)
+	}
+
+	secrets, errs := backend.GetMany(t.Context(), be, []string{"b", "missing", "a"})
+	if string(secrets[0]) != "2" || errs[0] != nil || string(secrets[2]) != "1" || errs[2] != nil {
+		t.Errorf("GetMany = %q, %v", secrets, errs)
+	}
+	var notFound *backend.ErrNotFound
+	if !errors.As(errs[1], &notFound) {
+		t.Errorf("missing target: err = %v, want ErrNotFound", errs[1])
+	}
+
+	errs = backend.DeleteMany(t.Context(), be, []string{"a", "missing"})
+	if errs[0] != nil || !errors.As(errs[1], &notFound) {
+		t.Errorf("DeleteMany = %v", errs)
+	}
+	if _, err := be.Get(t.Context(), "a"); err == nil {
+		t.Error("deleted target still present")
+	}
+}
+
+func TestBatchUsesBatcher(t *testing.T) {
+	be := &batching{Backend: memory.New()}
+	_ = backend.SetMany(t.Context(), be, map[string][]byte{"a": []byte("1"), "b": []byte("2")})
+	_, _ = backend.GetMany(t.Context(), be, []string{"a", "b"})
+	_ = backend.DeleteMany(t.Context(), be, []string{"a", "b"})
+	if be.calls != 3 {
+		t.Errorf("batched calls = %d, want 3", be.calls)
+	}
+}
@@ -0,0 +1,199 @@
+// SPDX-License-Identifier: Apache-2.0
+
+// Package envelope provides the "envelope" backend middleware, which
+// encrypts every secret with a key held on the WSL side before it reaches
+// the wrapped backend. With the wincred backend, a Windows process
+// enumerating the Credential Manager then sees only ciphertext.
+//
+// The data key is random and kept in envelope.key in the config directory,
+// wrapped with AES-256-GCM under an Argon2id key derived from a passphrase.
+// The passphrase is read from the Linux kernel keyring, where the user
+// stores it once per boot:
+//
+//	keyctl add user wsl-secret-service:envelope "$passphrase" @u
+//
+// Until it is there, backend calls fail; the key is loaded on first use.
+// Because Windows can read the WSL file system, the key file alone does not
+// reveal the secrets.
+package envelope
+
+import (
+	"bytes"
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"sync"
+
+	"github.com/akihiro/wsl-secret-service/internal/backend"
+	"github.com/akihiro/wsl-secret-service/internal/logging"
+	"github.com/akihiro/wsl-secret-service/internal/memprotect"
+)
+
+var logger = logging.For(logging.Backend).With("middleware", "envelope")
+
+// KeyFile is the name of the wrapped data key inside the config directory.
+const KeyFile = "envelope.key"
+
+// magic starts every value sealed by this package; the rest is the GCM
+// nonce and the ciphertext of the secret, authenticated with its target.
+// Values without it were stored before envelope encryption was enabled and
+// are returned as they are.
+var magic = []byte("wsl-ss-env1:")
+
+func init() {
+	backend.RegisterMiddleware("envelope", func(next backend.Backend, arg string, opts backend.Options) (backend.Backend, error) {
+		if arg != "" {
+			return nil, fmt.Errorf("unexpected argument %q", arg)
+		}
+		return New(next, filepath.Join(opts.ConfigDir, KeyFile), keyringPassphrase), nil
+	})
+}
+
+// Backend encrypts secrets before passing them to the wrapped backend.
+type Backend struct {
+	backend.Wrapper
+	keyPath    string
+	passphrase func() ([]byte, error)
+
+	mu   sync.Mutex
+	aead cipher.AEAD // nil until the data key is loaded
+	key  []byte      // data key, in locked memory
+}
+
+// New returns a Backend encrypting the secrets of next with the data key in
+// keyPath, which is created on first use. passphrase returns the passphrase
+// wrapping it; the caller's buffer is cleared after use.
+func New(next backend.Backend, keyPath string, passphrase func() ([]byte, error)) *Backend {
+	return &Backend{Wrapper: backend.Wrapper{Backend: next}, keyPath: keyPath, passphrase: passphrase}
+}
+
+// Get returns the decrypted secret of target.
+func (b *Backend) Get(ctx context.Context, target string) ([]byte, error) {
+	sealed, err := b.Backend.Get(ctx, target)
+	if err != nil {
+		return nil, err
+	}
+	return b.open(target, sealed)
+}
+
+// GetMany implements backend.Batcher, decrypting the secrets of a batch
+// read from the wrapped backend.
+func (b *Backend) GetMany(ctx context.Context, targets []string) ([][]byte, []error) {
+	secrets, errs := backend.GetMany(ctx, b.Backend, targets)
+	for i, sealed := range secrets {
+		if errs[i] == nil {
+			secrets[i], errs[i] = b.open(targets[i], sealed)
+		}
+	}
+	return secrets, errs
+}
+
+// open decrypts sealed, the value stored under target, wiping it.
+func (b *Backend) open(target string, sealed []byte) ([]byte, error) {
+	if !bytes.HasPrefix(sealed, magic) {
+		logger.Debug("secret stored without envelope encryption", "target", target)
+		return sealed, nil
+	}
+	defer memprotect.Wipe(sealed)
+	aead, err := b.cipher()
+	if err != nil {
+		return nil, err
+	}
+	body := sealed[len(magic):]
+	if len(body) < aead.NonceSize()+aead.Overhead() {
+		return nil, fmt.Errorf("envelope of %q is truncated", target)
+	}
+	nonce, ciphertext := body[:aead.NonceSize()], body[aead.NonceSize():]
+	secret := memprotect.AllocSecret(len(ciphertext) - aead.Overhead())
+	if _, err := aead.Open(secret[:0], nonce, ciphertext, []byte(target)); err != nil {
+		memprotect.Wipe(secret)
+		return nil, fmt.Errorf("decrypt envelope of %q: %w", target, err)
+	}
+	return secret, nil
+}
+
+// Set encrypts secret and stores it under target.
+func (b *Backend) Set(ctx context.Context, target string, secret []byte) error {
+	sealed, err := b.seal(target, secret)
+	if err != nil {
+		return err
+	}
+	return b.Backend.Set(ctx, target, sealed)
+}
+
+// SetMany implements backend.Batcher, encrypting every secret before
+// storing the batch in the wrapped backend.
+func (b *Backend) SetMany(ctx context.Context, secrets map[string][]byte) error {
+	sealed := make(map[string][]byte, len(secrets))
+	for target, secret := range secrets {
+		s, err := b.seal(target, secret)
+		if err != nil {
+			return err
+		}
+		sealed[target] = s
+	}
+	return backend.SetMany(ctx, b.Backend, sealed)
+}
+
+// seal encrypts secret for target.
+func (b *Backend) seal(target string, secret []byte) ([]byte, error) {
+	aead, err := b.cipher()
+	if err != nil {
+		return nil, err
+	}
+	sealed := make([]byte, len(magic)+aead.NonceSize(), len(magic)+aead.NonceSize()+len(secret)+aead.Overhead())
+	copy(sealed, magic)
+	if _, err := rand.Read(sealed[len(magic):]); err != nil {
+		return nil, err
+	}
+	return aead.Seal(sealed, sealed[len(magic):], secret, []byte(target)), nil
+}
+
+// Close forgets the data key and closes the wrapped backend.
+func (b *Backend) Close() error {
+	b.mu.Lock()
+	b.aead = nil
+	memprotect.Wipe(b.key)
+	b.key = nil
+	b.mu.Unlock()
+	return b.Wrapper.Close()
+}
+
+// cipher returns the AEAD for the data key, loading or creating the key on
+// first use.
+func (b *Backend) cipher() (cipher.AEAD, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.aead != nil {
+		return b.aead, nil
+	}
+	passphrase, err := b.passphrase()
+	if err != nil {
+		return nil, fmt.Errorf("envelope passphrase: %w", err)
+	}
+	defer clear(passphrase)
+	key, err := loadKey(b.keyPath, passphrase)
+	if errors.Is(err, errNoKey) {
+		logger.Info("creating envelope encryption key", "path", b.keyPath)
+		key, err = createKey(b.keyPath, passphrase)
+	}
+	if err != nil {
+		return nil, err
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		memprotect.Wipe(key)
+		return nil, err
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		memprotect.Wipe(key)
+		return nil, err
+	}
+	b.key, b.aead = key, aead
+	return aead, nil
+}
@@ -0,0 +1,101 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package envelope
+
+import (
+	"bytes"
+	"errors"
+	"path/filepath"
+	"testing"
+
+	"github.com/akihiro/wsl-secret-service/internal/backend"
+	"github.com/akihiro/wsl-secret-service/pkg/backend/memory"
+)
+
+func fixedPassphrase(pw string) func() ([]byte, error) {
+	return func() ([]byte, error) { return []byte(pw), nil }
+}
+
+func TestEnvelope(t *testing.T) {
+	ctx := t.Context()
+	keyPath := filepath.Join(t.TempDir(), KeyFile)
+	inner := memory.New()
+	if err := inner.Set(ctx, "wsl-ss/login/old", []byte("legacy")); err != nil {
+		t.Fatal(err)
+	}
+
+	b := New(inner, keyPath, fixedPassphrase("correct horse"))
+	if err := b.Set(ctx, "wsl-ss/login/a", []byte("secret")); err != nil {
+		t.Fatal(err)
+	}
+	stored, err := inner.Get(ctx, "wsl-ss/login/a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Contains(stored, []byte("secret")) || !bytes.HasPrefix(stored, magic) {
+		t.Errorf("backend holds %q, want an envelope", stored)
+	}
+	if got, err := b.Get(ctx, "wsl-ss/login/a"); err != nil || string(got) != "secret" {
+		t.Errorf("Get = %q, %v", got, err)
+	}
+	if got, err := b.Get(ctx, "wsl-ss/login/old"); err != nil || string(got) != "legacy" {
+		t.Errorf("Get of a plaintext value = %q, %v", got, err)
+	}
+
+	// An envelope moved to another target fails authentication.
+	if err := inner.Set(ctx, "wsl-ss/login/b", stored); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := b.Get(ctx, "wsl-ss/login/b"); err == nil {
+		t.Error("Get of an envelope copied to another target succeeded")
+	}
+
+	// A new daemon unwraps the same key from the key file.
+	again := New(inner, keyPath, fixedPassphrase("correct horse"))
+	if got, err := again.Get(ctx, "wsl-ss/login/a"); err != nil || string(got) != "secret" {
+		t.Errorf("Get after restart = %q, %v", got, err)
+	}
+	wrong := New(inner, keyPath, fixedPassphrase("battery staple"))
+	if _, err := wrong.Get(ctx, "wsl-ss/login/a"); err == nil {
+		t.Error("Get with a wrong passphrase succeeded")
+	}
+}
+
+func TestEnvelopeBatch(t *testing.T) {
+	ctx := t.Context()
+	inner := memory.New()
+	b := New(inner, filepath.Join(t.TempDir(), KeyFile), fixedPassphrase("correct horse"))
+	targets := []string{"wsl-ss/login/a", "wsl-ss/login/b"}
+	if err := backend.SetMany(ctx, b, map[string][]byte{targets[0]: []byte("one"), targets[1]: []byte("two")}); err != nil {
+		t.Fatal(err)
+	}
+	for _, target := range targets {
+		if stored, err := inner.Get(ctx, target); err != nil || !bytes.HasPrefix(stored, magic) {
+			t.Errorf("backend holds %q, %v for %s, want an envelope", stored, err, target)
+		}
+	}
+	secrets, errs := backend.GetMany(ctx, b, targets)
+	if errs[0] != nil || errs[1] != nil || string(secrets[0]) != "one" || string(secrets[1]) != "two" {
+		t.Errorf("GetMany = %q, %v", secrets, errs)
+	}
+}
+
+func TestEnvelopeNoPassphrase(t *testing.T) {
+	ctx := t.Context()
+	missing := errors.New("no passphrase")
+	calls := 0
+	b := New(memory.New(), filepath.Join(t.TempDir(), KeyFile), func() ([]byte, error) {
+		calls++
+		if calls == 1 {
+			return nil, missing
+		}
+		return []byte("later"), nil
+	})
+	if err := b.Set(ctx, "wsl-ss/login/a", []byte("secret")); !errors.Is(err, missing) {
+		t.Fatalf("Set without a passphrase: err = %v, want %v", err, missing)
+	}
+	// The passphrase is looked up again on the next call.
+	if err := b.Set(ctx, "wsl-ss/login/a", []byte("secret")); err != nil {
+		t.Fatalf("Set once the passphrase is there: %v", err)
+	}
+}
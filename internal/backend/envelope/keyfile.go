@@ -0,0 +1,142 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package envelope
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+
+	"golang.org/x/crypto/argon2"
+
+	"github.com/akihiro/wsl-secret-service/internal/memprotect"
+)
+
+// Argon2id parameters for new key files, as for collection passwords.
+// Existing files keep the parameters they were created with.
+const (
+	kdfTime    = 2
+	kdfMemory  = 19 * 1024 // KiB
+	kdfThreads = 1
+	kdfSaltLen = 16
+	dataKeyLen = 32
+)
+
+// errNoKey is returned by loadKey when the key file does not exist yet.
+var errNoKey = errors.New("no envelope key")
+
+// keyFile is the JSON form of envelope.key: the data key sealed with
+// AES-256-GCM under the Argon2id key of the passphrase.
+type keyFile struct {
+	Version int    `json:"version"`
+	Salt    []byte `json:"salt"`
+	Time    uint32 `json:"time"`
+	Memory  uint32 `json:"memory"`
+	Threads uint8  `json:"threads"`
+	Nonce   []byte `json:"nonce"`
+	Key     []byte `json:"key"`
+}
+
+// loadKey unwraps the data key in path with passphrase. The key is
+// returned in locked memory.
+func loadKey(path string, passphrase []byte) ([]byte, error) {
+	data, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, errNoKey
+	}
+	if err != nil {
+		return nil, err
+	}
+	var kf keyFile
+	if err := json.Unmarshal(data, &kf); err != nil {
+		return nil, fmt.Errorf("parse %s: %w", path, err)
+	}
+	if kf.Version != 1 {
+		return nil, fmt.Errorf("%s: unsupported version %d", path, kf.Version)
+	}
+	aead, err := wrapCipher(passphrase, &kf)
+	if err != nil {
+		return nil, err
+	}
+	if len(kf.Nonce) != aead.NonceSize() || len(kf.Key) != dataKeyLen+aead.Overhead() {
+		return nil, fmt.Errorf("%s is malformed", path)
+	}
+	key, err := memprotect.LockedAlloc(dataKeyLen)
+	if err != nil {
+		key = memprotect.AllocSecret(dataKeyLen)
+	}
+	if _, err := aead.Open(key[:0], kf.Nonce, kf.Key, nil); err != nil {
+		memprotect.Wipe(key)
+		return nil, fmt.Errorf("unwrap %s: wrong passphrase", path)
+	}
+	return key, nil
+}
+
+// createKey generates a data key, writes it to path wrapped with
+// passphrase and returns it in locked memory.
+func createKey(path string, passphrase []byte) ([]byte, error) {
+	kf := keyFile{
+		Version: 1,
+		Salt:    make([]byte, kdfSaltLen),
+		Time:    kdfTime,
+		Memory:  kdfMemory,
+		Threads: kdfThreads,
+	}
+	if _, err := rand.Read(kf.Salt); err != nil {
+		return nil, err
+	}
+	aead, err := wrapCipher(passphrase, &kf)
+	if err != nil {
+		return nil, err
+	}
+	key, err := memprotect.LockedAlloc(dataKeyLen)
+	if err != nil {
+		key = memprotect.AllocSecret(dataKeyLen)
+	}
+	kf.Nonce = make([]byte, aead.NonceSize())
+	if _, err := rand.Read(key); err != nil {
+		memprotect.Wipe(key)
+		return nil, err
+	}
+	if _, err := rand.Read(kf.Nonce); err != nil {
+		memprotect.Wipe(key)
+		return nil, err
+	}
+	kf.Key = aead.Seal(nil, kf.Nonce, key, nil)
+	data, err := json.MarshalIndent(&kf, "", "  ")
+	if err == nil {
+		err = writeNew(path, data)
+	}
+	if err != nil {
+		memprotect.Wipe(key)
+		return nil, fmt.Errorf("write %s: %w", path, err)
+	}
+	return key, nil
+}
+
+// wrapCipher derives the AEAD wrapping the data key of kf from passphrase.
+func wrapCipher(passphrase []byte, kf *keyFile) (cipher.AEAD, error) {
+	kek := argon2.IDKey(passphrase, kf.Salt, kf.Time, kf.Memory, kf.Threads, dataKeyLen)
+	defer clear(kek)
+	block, err := aes.NewCipher(kek)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// writeNew writes data to path with mode 0600, failing if path exists, so
+// that two daemons starting at once cannot replace each other's key.
+func writeNew(path string, data []byte) error {
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0o600); err != nil {
+		return err
+	}
+	defer func() { _ = os.Remove(tmp) }()
+	return os.Link(tmp, path)
+}
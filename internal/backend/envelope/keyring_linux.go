@@ -0,0 +1,49 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package envelope
+
+import (
+	"errors"
+	"fmt"
+
+	"golang.org/x/sys/unix"
+)
+
+// KeyringDescription names the user key holding the passphrase.
+const KeyringDescription = "wsl-secret-service:envelope"
+
+// keyringPassphrase reads the passphrase from the user key
+// KeyringDescription, searching the user keyring and then the session
+// keyring.
+func keyringPassphrase() ([]byte, error) {
+	var id int
+	var err error
+	for _, ring := range []int{unix.KEY_SPEC_USER_KEYRING, unix.KEY_SPEC_SESSION_KEYRING} {
+		if id, err = unix.KeyctlSearch(ring, "user", KeyringDescription, 0); err == nil {
+			break
+		}
+	}
+	if err != nil {
+		if errors.Is(err, unix.ENOKEY) {
+			return nil, fmt.Errorf("no key %q in the kernel keyring; add it with: keyctl add user %s <passphrase> @u", KeyringDescription, KeyringDescription)
+		}
+		return nil, fmt.Errorf("search kernel keyring: %w", err)
+	}
+	n, err := unix.KeyctlBuffer(unix.KEYCTL_READ, id, nil, 0)
+	if err != nil {
+		return nil, fmt.Errorf("read key %q: %w", KeyringDescription, err)
+	}
+	buf := make([]byte, n)
+	if n, err = unix.KeyctlBuffer(unix.KEYCTL_READ, id, buf, 0); err != nil {
+		clear(buf)
+		return nil, fmt.Errorf("read key %q: %w", KeyringDescription, err)
+	}
+	if n > len(buf) {
+		clear(buf)
+		return nil, fmt.Errorf("key %q changed while reading it", KeyringDescription)
+	}
+	if n == 0 {
+		return nil, fmt.Errorf("key %q is empty", KeyringDescription)
+	}
+	return buf[:n], nil
+}
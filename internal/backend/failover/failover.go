@@ -0,0 +1,446 @@
+// SPDX-License-Identifier: Apache-2.0
+
+// Package failover provides a backend that stores secrets in a primary
+// backend (the Windows Credential Manager) and falls back to a secondary one
+// (age-encrypted files with a local key) while the primary is unavailable,
+// for example because WSL interop is disabled. Secrets written to the
+// secondary, and deletions that could not reach the primary, are moved to
+// the primary by a reconciliation pass once it answers again.
+package failover
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"maps"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/akihiro/wsl-secret-service/internal/backend"
+	"github.com/akihiro/wsl-secret-service/internal/backend/age"
+	"github.com/akihiro/wsl-secret-service/internal/backend/wincred"
+	"github.com/akihiro/wsl-secret-service/internal/logging"
+)
+
+var logger = logging.For(logging.Backend).With("backend", "failover")
+
+// DirName is the directory inside the config directory holding the
+// secondary's files, and IdentityFile the name of its age key in there.
+const (
+	DirName      = "fallback"
+	IdentityFile = "identity.txt"
+)
+
+// deletedPrefix marks entries of the secondary that record a deletion the
+// primary has not seen yet; the rest of the target is the deleted target.
+const deletedPrefix = ".deleted/"
+
+// reconcileTimeout bounds the primary and secondary calls for one entry
+// during reconciliation.
+const reconcileTimeout = 20 * time.Second
+
+func init() {
+	backend.Register("failover", func(opts backend.Options) (backend.Backend, error) {
+		dir := filepath.Join(opts.ConfigDir, DirName)
+		secondary, err := age.NewLocal(dir, filepath.Join(dir, IdentityFile))
+		if err != nil {
+			return nil, err
+		}
+		var primary backend.Backend
+		primary, err = wincred.Open(opts)
+		if err != nil {
+			logger.Warn("wincred-helper unavailable, storing secrets in the fallback only", "err", err)
+			primary = unavailable{err: err}
+		}
+		return New(primary, secondary)
+	})
+}
+
+// Backend implements backend.Backend on top of a primary and a secondary
+// backend. The secondary holds only what still has to reach the primary:
+// secrets stored while the primary was unavailable, which are served from
+// there until they are moved, and markers for deletions.
+type Backend struct {
+	primary   backend.Backend
+	secondary backend.Backend
+
+	mu      sync.Mutex
+	pending map[string]bool // targets whose current secret is in the secondary
+	deleted map[string]bool // targets deleted while the primary was unavailable
+	failing bool            // the last primary call found it unavailable
+
+	reconciling atomic.Bool
+	stop        context.CancelFunc
+	stopped     context.Context
+}
+
+// New returns a Backend writing to primary and falling back to secondary.
+// Entries left in secondary by an earlier run are moved to primary by the
+// first successful primary call.
+func New(primary, secondary backend.Backend) (*Backend, error) {
+	b := &Backend{
+		primary:   primary,
+		secondary: secondary,
+		pending:   make(map[string]bool),
+		deleted:   make(map[string]bool),
+	}
+	b.stopped, b.stop = context.WithCancel(context.Background())
+	targets, err := secondary.List(b.stopped, "")
+	if err != nil {
+		return nil, fmt.Errorf("list fallback entries: %w", err)
+	}
+	for _, t := range targets {
+		if name, ok := strings.CutPrefix(t, deletedPrefix); ok {
+			b.deleted[name] = true
+		} else {
+			b.pending[t] = true
+		}
+	}
+	if n := len(b.pending) + len(b.deleted); n > 0 {
+		logger.Info("fallback holds changes for the primary backend", "entries", n)
+	}
+	return b, nil
+}
+
+// Close stops reconciliation and releases both backends.
+func (b *Backend) Close() error {
+	b.stop()
+	var errs []error
+	for _, be := range []backend.Backend{b.primary, b.secondary} {
+		if c, ok := be.(io.Closer); ok {
+			errs = append(errs, c.Close())
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// Flush implements backend.Flusher for both backends.
+func (b *Backend) Flush() error {
+	var errs []error
+	for _, be := range []backend.Backend{b.primary, b.secondary} {
+		if f, ok := be.(backend.Flusher); ok {
+			errs = append(errs, f.Flush())
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// Get returns the raw secret bytes for the given target.
+func (b *Backend) Get(ctx context.Context, target string) ([]byte, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	switch {
+	case b.pending[target]:
+		return b.secondary.Get(ctx, target)
+	case b.deleted[target]:
+		return nil, &backend.ErrNotFound{Target: target}
+	}
+	secret, err := b.primary.Get(ctx, target)
+	b.observeLocked(err)
+	return secret, err
+}
+
+// Set stores raw secret bytes under the given target, in the secondary if
+// the primary is unavailable.
+func (b *Backend) Set(ctx context.Context, target string, secret []byte) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	err := b.primary.Set(ctx, target, secret)
+	b.observeLocked(err)
+	if err == nil {
+		// A copy in the secondary is older now.
+		return b.forgetLocked(ctx, target)
+	}
+	if !isUnavailable(err) {
+		return err
+	}
+	if err := b.secondary.Set(ctx, target, secret); err != nil {
+		return fmt.Errorf("store %q in fallback: %w", target, err)
+	}
+	b.pending[target] = true
+	return b.clearDeletedLocked(ctx, target)
+}
+
+// Delete removes the secret for the given target. If the primary is
+// unavailable, the deletion is recorded and applied to it later.
+func (b *Backend) Delete(ctx context.Context, target string) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.deleted[target] {
+		return &backend.ErrNotFound{Target: target}
+	}
+	wasPending := b.pending[target]
+	if wasPending {
+		if err := b.secondary.Delete(ctx, target); err != nil {
+			return fmt.Errorf("delete %q from fallback: %w", target, err)
+		}
+		delete(b.pending, target)
+	}
+	err := b.primary.Delete(ctx, target)
+	b.observeLocked(err)
+	var notFound *backend.ErrNotFound
+	switch {
+	case err == nil:
+		return nil
+	case wasPending && errors.As(err, &notFound):
+		// The secret only ever existed in the secondary.
+		return nil
+	case !isUnavailable(err):
+		return err
+	}
+	if err := b.secondary.Set(ctx, deletedPrefix+target, []byte{}); err != nil {
+		return fmt.Errorf("record deletion of %q in fallback: %w", target, err)
+	}
+	b.deleted[target] = true
+	return nil
+}
+
+// List returns all target strings that have the given prefix. It needs the
+// primary, as most secrets are only stored there.
+func (b *Backend) List(ctx context.Context, prefix string) ([]string, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	targets, err := b.primary.List(ctx, prefix)
+	b.observeLocked(err)
+	if err != nil {
+		return nil, err
+	}
+	targets = slices.DeleteFunc(targets, func(t string) bool { return b.deleted[t] })
+	for t := range b.pending {
+		if strings.HasPrefix(t, prefix) && !slices.Contains(targets, t) {
+			targets = append(targets, t)
+		}
+	}
+	slices.Sort(targets)
+	return targets, nil
+}
+
+// Verify implements backend.Verifier through the primary.
+func (b *Backend) Verify(ctx context.Context, message string) error {
+	v, ok := b.primary.(backend.Verifier)
+	if !ok {
+		return errors.New("the primary backend cannot verify the user")
+	}
+	return v.Verify(ctx, message)
+}
+
+// Approve implements backend.Approver through the primary.
+func (b *Backend) Approve(ctx context.Context, message string) error {
+	a, ok := b.primary.(backend.Approver)
+	if !ok {
+		return errors.New("the primary backend cannot ask the user")
+	}
+	return a.Approve(ctx, message)
+}
+
+// PromptPassword implements backend.PasswordPrompter through the primary.
+func (b *Backend) PromptPassword(ctx context.Context, collection, message string) ([]byte, error) {
+	p, ok := b.primary.(backend.PasswordPrompter)
+	if !ok {
+		return nil, errors.New("the primary backend cannot ask for a password")
+	}
+	return p.PromptPassword(ctx, collection, message)
+}
+
+// observeLocked notes the outcome of a primary call: it logs when the
+// primary becomes unavailable, and starts reconciliation when it answers
+// while the secondary holds changes. Caller must hold b.mu.
+func (b *Backend) observeLocked(err error) {
+	if isUnavailable(err) {
+		if !b.failing {
+			logger.Warn("primary backend unavailable, falling back", "err", err)
+			b.failing = true
+		}
+		return
+	}
+	if b.failing {
+		logger.Info("primary backend available again")
+		b.failing = false
+	}
+	if len(b.pending)+len(b.deleted) > 0 && b.reconciling.CompareAndSwap(false, true) {
+		go b.reconcile()
+	}
+}
+
+// forgetLocked removes the secondary's copy of target and any record of
+// its deletion. Caller must hold b.mu.
+func (b *Backend) forgetLocked(ctx context.Context, target string) error {
+	if b.pending[target] {
+		if err := b.secondary.Delete(ctx, target); err != nil {
+			return fmt.Errorf("delete %q from fallback: %w", target, err)
+		}
+		delete(b.pending, target)
+	}
+	return b.clearDeletedLocked(ctx, target)
+}
+
+// clearDeletedLocked removes the record of a deletion of target, if any.
+// Caller must hold b.mu.
+func (b *Backend) clearDeletedLocked(ctx context.Context, target string) error {
+	if !b.deleted[target] {
+		return nil
+	}
+	if err := b.secondary.Delete(ctx, deletedPrefix+target); err != nil {
+		return fmt.Errorf("delete fallback record for %q: %w", target, err)
+	}
+	delete(b.deleted, target)
+	return nil
+}
+
+// reconcile moves the secrets held by the secondary to the primary and
+// applies the recorded deletions, one entry at a time. Changes made to the
+// primary after the secondary's (from the Windows side, while the daemon
+// could not reach it) win over them. It stops at the first failure; the
+// next successful primary call starts it again.
+func (b *Backend) reconcile() {
+	defer b.reconciling.Store(false)
+	b.mu.Lock()
+	targets := slices.Sorted(maps.Keys(b.pending))
+	deleted := slices.Sorted(maps.Keys(b.deleted))
+	b.mu.Unlock()
+
+	newer, err := b.newerInPrimary()
+	if err != nil {
+		logger.Warn("could not compare fallback entries with the primary backend", "err", err)
+		return
+	}
+	for _, t := range targets {
+		if newer[t] {
+			b.dropConflict(t)
+			continue
+		}
+		if err := b.moveToPrimary(t); err != nil {
+			logger.Warn("could not move fallback entry to the primary backend", "target", t, "err", err)
+			return
+		}
+	}
+	for _, t := range deleted {
+		if newer[deletedPrefix+t] {
+			b.dropConflict(t)
+			continue
+		}
+		if err := b.deleteFromPrimary(t); err != nil {
+			logger.Warn("could not apply fallback deletion to the primary backend", "target", t, "err", err)
+			return
+		}
+	}
+	logger.Info("fallback entries reconciled with the primary backend", "moved", len(targets), "deleted", len(deleted))
+}
+
+// newerInPrimary returns the secondary's targets, including deletion
+// records, whose primary entry was written after them. It is empty unless
+// both backends implement backend.EntryLister.
+func (b *Backend) newerInPrimary() (map[string]bool, error) {
+	pl, ok := backend.As[backend.EntryLister](b.primary)
+	if !ok {
+		return nil, nil
+	}
+	sl, ok := backend.As[backend.EntryLister](b.secondary)
+	if !ok {
+		return nil, nil
+	}
+	ctx, cancel := context.WithTimeout(b.stopped, reconcileTimeout)
+	defer cancel()
+	secondary, err := sl.ListEntries(ctx, "")
+	if err != nil {
+		return nil, err
+	}
+	primary, err := pl.ListEntries(ctx, "")
+	if err != nil {
+		return nil, err
+	}
+	written := make(map[string]time.Time, len(primary))
+	for _, e := range primary {
+		written[e.Target] = e.LastWritten
+	}
+	newer := make(map[string]bool)
+	for _, e := range secondary {
+		target := strings.TrimPrefix(e.Target, deletedPrefix)
+		if w := written[target]; !e.LastWritten.IsZero() && w.After(e.LastWritten) {
+			newer[e.Target] = true
+		}
+	}
+	return newer, nil
+}
+
+// dropConflict discards the secondary's secret or deletion record of
+// target, which the primary's newer entry replaces.
+func (b *Backend) dropConflict(target string) {
+	ctx, cancel := context.WithTimeout(b.stopped, reconcileTimeout)
+	defer cancel()
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	logger.Warn("primary backend entry changed after the fallback's, keeping it", "target", target)
+	if err := b.forgetLocked(ctx, target); err != nil {
+		logger.Warn("could not discard fallback entry", "target", target, "err", err)
+	}
+}
+
+// moveToPrimary stores the secondary's secret of target in the primary
+// and removes it from the secondary.
+func (b *Backend) moveToPrimary(target string) error {
+	ctx, cancel := context.WithTimeout(b.stopped, reconcileTimeout)
+	defer cancel()
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if !b.pending[target] {
+		return nil // changed meanwhile
+	}
+	secret, err := b.secondary.Get(ctx, target)
+	if err != nil {
+		return err
+	}
+	defer clear(secret)
+	if err := b.primary.Set(ctx, target, secret); err != nil {
+		return err
+	}
+	return b.forgetLocked(ctx, target)
+}
+
+// deleteFromPrimary applies the recorded deletion of target to the
+// primary.
+func (b *Backend) deleteFromPrimary(target string) error {
+	ctx, cancel := context.WithTimeout(b.stopped, reconcileTimeout)
+	defer cancel()
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if !b.deleted[target] {
+		return nil
+	}
+	var notFound *backend.ErrNotFound
+	if err := b.primary.Delete(ctx, target); err != nil && !errors.As(err, &notFound) {
+		return err
+	}
+	return b.clearDeletedLocked(ctx, target)
+}
+
+func isUnavailable(err error) bool {
+	var unavailable *backend.ErrUnavailable
+	return errors.As(err, &unavailable)
+}
+
+// unavailable stands in for a primary that could not be opened.
+type unavailable struct {
+	err error
+}
+
+func (u unavailable) Get(context.Context, string) ([]byte, error) {
+	return nil, &backend.ErrUnavailable{Err: u.err}
+}
+
+func (u unavailable) Set(context.Context, string, []byte) error {
+	return &backend.ErrUnavailable{Err: u.err}
+}
+
+func (u unavailable) Delete(context.Context, string) error {
+	return &backend.ErrUnavailable{Err: u.err}
+}
+
+func (u unavailable) List(context.Context, string) ([]string, error) {
+	return nil, &backend.ErrUnavailable{Err: u.err}
+}
@@ -0,0 +1,231 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package failover
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/akihiro/wsl-secret-service/internal/backend"
+	"github.com/akihiro/wsl-secret-service/pkg/backend/memory"
+)
+
+// flaky is a memory backend that reports itself unavailable while down.
+type flaky struct {
+	*memory.Backend
+	down atomic.Bool
+}
+
+func (f *flaky) check() error {
+	if f.down.Load() {
+		return &backend.ErrUnavailable{Err: errors.New("helper cannot be started")}
+	}
+	return nil
+}
+
+func (f *flaky) Get(ctx context.Context, target string) ([]byte, error) {
+	if err := f.check(); err != nil {
+		return nil, err
+	}
+	return f.Backend.Get(ctx, target)
+}
+
+func (f *flaky) Set(ctx context.Context, target string, secret []byte) error {
+	if err := f.check(); err != nil {
+		return err
+	}
+	return f.Backend.Set(ctx, target, secret)
+}
+
+func (f *flaky) Delete(ctx context.Context, target string) error {
+	if err := f.check(); err != nil {
+		return err
+	}
+	return f.Backend.Delete(ctx, target)
+}
+
+func (f *flaky) List(ctx context.Context, prefix string) ([]string, error) {
+	if err := f.check(); err != nil {
+		return nil, err
+	}
+	return f.Backend.List(ctx, prefix)
+}
+
+func newTestBackend(t *testing.T) (*Backend, *flaky, *memory.Backend) {
+	t.Helper()
+	primary := &flaky{Backend: memory.New()}
+	secondary := memory.New()
+	b, err := New(primary, secondary)
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+	t.Cleanup(func() { _ = b.Close() })
+	return b, primary, secondary
+}
+
+// waitReconciled waits until the secondary is empty.
+func waitReconciled(t *testing.T, secondary *memory.Backend) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		left, _ := secondary.List(t.Context(), "")
+		if len(left) == 0 {
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("fallback still holds %v", left)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func TestFallsBackAndReconciles(t *testing.T) {
+	b, primary, secondary := newTestBackend(t)
+	ctx := t.Context()
+	if err := b.Set(ctx, "wsl-ss/login/old", []byte("old")); err != nil {
+		t.Fatal(err)
+	}
+
+	primary.down.Store(true)
+	if err := b.Set(ctx, "wsl-ss/login/new", []byte("new")); err != nil {
+		t.Fatalf("Set while the primary is down: %v", err)
+	}
+	if got, err := b.Get(ctx, "wsl-ss/login/new"); err != nil || string(got) != "new" {
+		t.Errorf("Get from the fallback = %q, %v", got, err)
+	}
+	if err := b.Delete(ctx, "wsl-ss/login/old"); err != nil {
+		t.Fatalf("Delete while the primary is down: %v", err)
+	}
+	var notFound *backend.ErrNotFound
+	if _, err := b.Get(ctx, "wsl-ss/login/old"); !errors.As(err, &notFound) {
+		t.Errorf("Get of a deleted secret: err = %v, want ErrNotFound", err)
+	}
+	var unavailable *backend.ErrUnavailable
+	if _, err := b.Get(ctx, "wsl-ss/login/other"); !errors.As(err, &unavailable) {
+		t.Errorf("Get of a secret only in the primary: err = %v, want ErrUnavailable", err)
+	}
+
+	primary.down.Store(false)
+	if _, err := b.List(ctx, ""); err != nil {
+		t.Fatal(err)
+	}
+	waitReconciled(t, secondary)
+	if got, err := primary.Backend.Get(ctx, "wsl-ss/login/new"); err != nil || string(got) != "new" {
+		t.Errorf("primary after reconciliation = %q, %v", got, err)
+	}
+	if _, err := primary.Backend.Get(ctx, "wsl-ss/login/old"); !errors.As(err, &notFound) {
+		t.Errorf("deletion not applied to the primary: err = %v", err)
+	}
+}
+
+func TestPendingEntriesSurviveRestart(t *testing.T) {
+	primary := &flaky{Backend: memory.New()}
+	primary.down.Store(true)
+	secondary := memory.New()
+	b, err := New(primary, secondary)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := b.Set(t.Context(), "wsl-ss/login/a", []byte("a")); err != nil {
+		t.Fatal(err)
+	}
+
+	b2, err := New(primary, secondary)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer b2.Close()
+	if got, err := b2.Get(t.Context(), "wsl-ss/login/a"); err != nil || string(got) != "a" {
+		t.Errorf("Get after restart = %q, %v", got, err)
+	}
+	primary.down.Store(false)
+	if got, err := b2.List(t.Context(), "wsl-ss/"); err != nil || !slices.Equal(got, []string{"wsl-ss/login/a"}) {
+		t.Errorf("List = %v, %v", got, err)
+	}
+	waitReconciled(t, secondary)
+}
+
+func TestOtherErrorsDoNotFallBack(t *testing.T) {
+	b, _, secondary := newTestBackend(t)
+	var notFound *backend.ErrNotFound
+	if err := b.Delete(t.Context(), "wsl-ss/login/missing"); !errors.As(err, &notFound) {
+		t.Errorf("Delete of a missing secret: err = %v, want ErrNotFound", err)
+	}
+	if left, _ := secondary.List(t.Context(), ""); len(left) != 0 {
+		t.Errorf("fallback holds %v", left)
+	}
+}
+
+// stamped adds backend.EntryLister to a backend, with write times set by
+// the test.
+type stamped struct {
+	backend.Backend
+	written map[string]time.Time
+}
+
+func (s stamped) ListEntries(ctx context.Context, prefix string) ([]backend.Entry, error) {
+	targets, err := s.List(ctx, prefix)
+	if err != nil {
+		return nil, err
+	}
+	entries := make([]backend.Entry, len(targets))
+	for i, t := range targets {
+		entries[i] = backend.Entry{Target: t, LastWritten: s.written[t]}
+	}
+	return entries, nil
+}
+
+func TestNewerPrimaryEntriesWin(t *testing.T) {
+	ctx := t.Context()
+	flakyPrimary := &flaky{Backend: memory.New()}
+	primary := stamped{flakyPrimary, make(map[string]time.Time)}
+	secondary := stamped{memory.New(), make(map[string]time.Time)}
+	b, err := New(primary, secondary)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer b.Close()
+	for _, target := range []string{"wsl-ss/login/a", "wsl-ss/login/b", "wsl-ss/login/c"} {
+		if err := b.Set(ctx, target, []byte("old")); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	flakyPrimary.down.Store(true)
+	if err := b.Set(ctx, "wsl-ss/login/a", []byte("fallback")); err != nil {
+		t.Fatal(err)
+	}
+	if err := b.Set(ctx, "wsl-ss/login/c", []byte("fallback")); err != nil {
+		t.Fatal(err)
+	}
+	if err := b.Delete(ctx, "wsl-ss/login/b"); err != nil {
+		t.Fatal(err)
+	}
+	offline := time.Now()
+	for _, target := range []string{"wsl-ss/login/a", "wsl-ss/login/c", deletedPrefix + "wsl-ss/login/b"} {
+		secondary.written[target] = offline
+	}
+	// Meanwhile a and b are changed on the Windows side; c is not.
+	for _, target := range []string{"wsl-ss/login/a", "wsl-ss/login/b"} {
+		if err := flakyPrimary.Backend.Set(ctx, target, []byte("windows")); err != nil {
+			t.Fatal(err)
+		}
+		primary.written[target] = offline.Add(time.Minute)
+	}
+	primary.written["wsl-ss/login/c"] = offline.Add(-time.Minute)
+
+	flakyPrimary.down.Store(false)
+	if _, err := b.List(ctx, ""); err != nil {
+		t.Fatal(err)
+	}
+	waitReconciled(t, secondary.Backend.(*memory.Backend))
+	for target, want := range map[string]string{"wsl-ss/login/a": "windows", "wsl-ss/login/b": "windows", "wsl-ss/login/c": "fallback"} {
+		if got, err := flakyPrimary.Backend.Get(ctx, target); err != nil || string(got) != want {
+			t.Errorf("primary %s = %q, %v, want %q", target, got, err, want)
+		}
+	}
+}
@@ -0,0 +1,199 @@
+// SPDX-License-Identifier: Apache-2.0
+
+// Package file provides a backend that keeps all secrets in a single file
+// encrypted with Windows DPAPI (CryptProtectData) via wincred-helper.exe.
+// Unlike the Credential Manager backend it has no per-secret size limit and
+// does not add one Credential Manager entry per item.
+//
+// The file is read, decrypted and, for mutations, re-encrypted and atomically
+// replaced on every call; no decrypted copy is kept between calls.
+package file
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/akihiro/wsl-secret-service/internal/backend"
+	"github.com/akihiro/wsl-secret-service/internal/backend/wincred"
+)
+
+// FileName is the name of the secrets file inside the config directory.
+const FileName = "secrets.dpapi"
+
+func init() {
+	backend.Register("file", func(opts backend.Options) (backend.Backend, error) {
+		// Only the DPAPI calls go through the helper, so the secret
+		// allocator is not needed for the bridge.
+		opts.SecretAlloc, opts.SecretRelease = nil, nil
+		bridge, err := wincred.Open(opts)
+		if err != nil {
+			return nil, err
+		}
+		return New(filepath.Join(opts.ConfigDir, FileName), bridge), nil
+	})
+}
+
+// Protector encrypts and decrypts the secrets file as a whole.
+// *wincred.Bridge implements it with DPAPI.
+type Protector interface {
+	Protect(ctx context.Context, data []byte) ([]byte, error)
+	Unprotect(ctx context.Context, data []byte) ([]byte, error)
+}
+
+// Backend implements backend.Backend on top of a single encrypted file.
+type Backend struct {
+	path      string
+	protector Protector
+
+	mu sync.Mutex
+}
+
+// fileData is the plaintext layout of the secrets file.
+type fileData struct {
+	Version int               `json:"version"`
+	Secrets map[string][]byte `json:"secrets"`
+}
+
+// New returns a Backend that stores secrets in path, encrypted by p.
+// The file is created on the first Set.
+func New(path string, p Protector) *Backend {
+	return &Backend{path: path, protector: p}
+}
+
+// Close releases the protector if it holds resources (such as a persistent
+// helper process).
+func (b *Backend) Close() error {
+	if c, ok := b.protector.(io.Closer); ok {
+		return c.Close()
+	}
+	return nil
+}
+
+// Get returns the raw secret bytes for the given target.
+func (b *Backend) Get(ctx context.Context, target string) ([]byte, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	d, err := b.load(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer d.wipe()
+	v, ok := d.Secrets[target]
+	if !ok {
+		return nil, &backend.ErrNotFound{Target: target}
+	}
+	return append([]byte(nil), v...), nil
+}
+
+// Set stores raw secret bytes under the given target.
+func (b *Backend) Set(ctx context.Context, target string, secret []byte) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	d, err := b.load(ctx)
+	if err != nil {
+		return err
+	}
+	defer d.wipe()
+	d.Secrets[target] = append([]byte(nil), secret...)
+	return b.save(ctx, d)
+}
+
+// Delete removes the secret for the given target.
+func (b *Backend) Delete(ctx context.Context, target string) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	d, err := b.load(ctx)
+	if err != nil {
+		return err
+	}
+	defer d.wipe()
+	v, ok := d.Secrets[target]
+	if !ok {
+		return &backend.ErrNotFound{Target: target}
+	}
+	clear(v)
+	delete(d.Secrets, target)
+	return b.save(ctx, d)
+}
+
+// List returns all target strings that have the given prefix.
+func (b *Backend) List(ctx context.Context, prefix string) ([]string, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	d, err := b.load(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer d.wipe()
+	targets := []string{}
+	for t := range d.Secrets {
+		if strings.HasPrefix(t, prefix) {
+			targets = append(targets, t)
+		}
+	}
+	sort.Strings(targets)
+	return targets, nil
+}
+
+// load reads and decrypts the secrets file. A missing file yields an empty
+// set of secrets. Caller must hold b.mu.
+func (b *Backend) load(ctx context.Context) (*fileData, error) {
+	d := &fileData{Version: 1, Secrets: make(map[string][]byte)}
+	ciphertext, err := os.ReadFile(b.path)
+	if errors.Is(err, os.ErrNotExist) {
+		return d, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("read secrets file: %w", err)
+	}
+	plaintext, err := b.protector.Unprotect(ctx, ciphertext)
+	if err != nil {
+		return nil, fmt.Errorf("decrypt secrets file: %w", err)
+	}
+	defer clear(plaintext)
+	if err := json.Unmarshal(plaintext, d); err != nil {
+		return nil, fmt.Errorf("decode secrets file: %w", err)
+	}
+	if d.Secrets == nil {
+		d.Secrets = make(map[string][]byte)
+	}
+	return d, nil
+}
+
+// save encrypts d and atomically replaces the secrets file.
+// Caller must hold b.mu.
+func (b *Backend) save(ctx context.Context, d *fileData) error {
+	plaintext, err := json.Marshal(d)
+	if err != nil {
+		return fmt.Errorf("encode secrets file: %w", err)
+	}
+	ciphertext, err := b.protector.Protect(ctx, plaintext)
+	clear(plaintext)
+	if err != nil {
+		return fmt.Errorf("encrypt secrets file: %w", err)
+	}
+	tmp := b.path + ".tmp"
+	if err := os.WriteFile(tmp, ciphertext, 0o600); err != nil {
+		return fmt.Errorf("write tmp secrets file: %w", err)
+	}
+	return os.Rename(tmp, b.path)
+}
+
+// wipe zeroes all decrypted secret values.
+func (d *fileData) wipe() {
+	for _, v := range d.Secrets {
+		clear(v)
+	}
+}
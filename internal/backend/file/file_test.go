@@ -0,0 +1,86 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package file
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/akihiro/wsl-secret-service/internal/backend"
+)
+
+// xorProtector is a reversible stand-in for DPAPI.
+type xorProtector struct{}
+
+func (xorProtector) Protect(_ context.Context, data []byte) ([]byte, error) { return xor(data), nil }
+
+func (xorProtector) Unprotect(_ context.Context, data []byte) ([]byte, error) { return xor(data), nil }
+
+func xor(data []byte) []byte {
+	out := make([]byte, len(data))
+	for i, c := range data {
+		out[i] = c ^ 0x5a
+	}
+	return out
+}
+
+func newTestBackend(t *testing.T) (*Backend, string) {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "secrets.dpapi")
+	return New(path, xorProtector{}), path
+}
+
+func TestSetGetAcrossInstances(t *testing.T) {
+	b, path := newTestBackend(t)
+	big := bytes.Repeat([]byte("x"), 10000) // beyond the Credential Manager limit
+	if err := b.Set(t.Context(), "wsl-ss/login/a", big); err != nil {
+		t.Fatalf("Set: %v", err)
+	}
+
+	got, err := New(path, xorProtector{}).Get(t.Context(), "wsl-ss/login/a")
+	if err != nil {
+		t.Fatalf("Get: %v", err)
+	}
+	if !bytes.Equal(got, big) {
+		t.Errorf("Get returned %d bytes, want %d", len(got), len(big))
+	}
+
+	raw, _ := os.ReadFile(path)
+	if bytes.Contains(raw, []byte("wsl-ss/login/a")) {
+		t.Error("secrets file contains plaintext target name")
+	}
+}
+
+func TestGetDeleteNotFound(t *testing.T) {
+	b, _ := newTestBackend(t)
+	var nf *backend.ErrNotFound
+	if _, err := b.Get(t.Context(), "missing"); !errors.As(err, &nf) {
+		t.Errorf("Get: err = %v, want ErrNotFound", err)
+	}
+	if err := b.Delete(t.Context(), "missing"); !errors.As(err, &nf) {
+		t.Errorf("Delete: err = %v, want ErrNotFound", err)
+	}
+}
+
+func TestListAndDelete(t *testing.T) {
+	b, _ := newTestBackend(t)
+	for _, target := range []string{"wsl-ss/a/1", "wsl-ss/a/2", "wsl-ss/b/1"} {
+		if err := b.Set(t.Context(), target, []byte("s")); err != nil {
+			t.Fatalf("Set %s: %v", target, err)
+		}
+	}
+	if err := b.Delete(t.Context(), "wsl-ss/a/1"); err != nil {
+		t.Fatalf("Delete: %v", err)
+	}
+	got, err := b.List(t.Context(), "wsl-ss/a/")
+	if err != nil {
+		t.Fatalf("List: %v", err)
+	}
+	if len(got) != 1 || got[0] != "wsl-ss/a/2" {
+		t.Errorf("List = %v, want [wsl-ss/a/2]", got)
+	}
+}
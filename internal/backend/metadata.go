@@ -0,0 +1,60 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package backend
+
+import (
+	"context"
+	"time"
+)
+
+// Metadata describes the item a secret belongs to. Backends that can keep
+// descriptive data next to a secret (the Windows Credential Manager's
+// comment and attributes) store it on Set, so that their entries make sense
+// when viewed outside the daemon and the item metadata can be rebuilt from
+// them. The others ignore it.
+type Metadata struct {
+	Collection string
+	Label      string
+	Attributes map[string]string
+}
+
+type metadataKey struct{}
+
+// WithMetadata returns a context carrying m for a Set call. It travels in
+// the context rather than as an argument so that middlewares pass it
+// through unchanged.
+func WithMetadata(ctx context.Context, m Metadata) context.Context {
+	return context.WithValue(ctx, metadataKey{}, m)
+}
+
+// MetadataFrom returns the Metadata attached to ctx by WithMetadata.
+func MetadataFrom(ctx context.Context) (Metadata, bool) {
+	m, ok := ctx.Value(metadataKey{}).(Metadata)
+	return m, ok
+}
+
+// MetadataLister is implemented by backends that store Metadata with
+// secrets and can list it back, for rebuilding lost item metadata.
+type MetadataLister interface {
+	// ListMetadata returns the Metadata of every target with the given
+	// prefix. Targets stored without it map to a zero Metadata.
+	ListMetadata(ctx context.Context, prefix string) (map[string]Metadata, error)
+}
+
+// Entry describes a stored secret without its value, as listed by
+// EntryLister.
+type Entry struct {
+	Target      string
+	UserName    string    // account name stored with the secret, if any
+	LastWritten time.Time // zero if unknown
+	Metadata              // Label holds the Credential Manager comment
+}
+
+// EntryLister is implemented by backends that know more about their
+// entries than the target name, for tooling that has to tell which of two
+// copies of a secret is newer.
+type EntryLister interface {
+	// ListEntries returns the entries whose target has the given prefix,
+	// sorted by target.
+	ListEntries(ctx context.Context, prefix string) ([]Entry, error)
+}
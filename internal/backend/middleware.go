@@ -0,0 +1,144 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package backend
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"sort"
+	"strings"
+)
+
+// Middleware wraps next in a backend adding a cross-cutting feature
+// (logging, retries, caching, ...) while forwarding the actual storage to
+// next. arg is the text after the colon in the middleware's spec
+// ("cache:1m"), or "" if there is none.
+type Middleware func(next Backend, arg string, opts Options) (Backend, error)
+
+var middlewares = make(map[string]Middleware) // guarded by registryMu
+
+// RegisterMiddleware makes a middleware available under name, for Wrap.
+// Like Register, it is intended to be called from an init function and
+// panics if name is registered twice.
+func RegisterMiddleware(name string, m Middleware) {
+	registryMu.Lock()
+	defer registryMu.Unlock()
+	if _, dup := middlewares[name]; dup {
+		panic("backend: RegisterMiddleware called twice for " + name)
+	}
+	middlewares[name] = m
+}
+
+// MiddlewareNames returns the names of all registered middlewares, sorted.
+func MiddlewareNames() []string {
+	registryMu.RLock()
+	defer registryMu.RUnlock()
+	names := make([]string, 0, len(middlewares))
+	for name := range middlewares {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Wrap stacks the middlewares given by specs ("name" or "name:arg") on b.
+// The first spec is the outermost layer: with ["cache", "retry"], a Get
+// that misses the cache goes through the retry layer to b.
+func Wrap(b Backend, specs []string, opts Options) (Backend, error) {
+	for i := len(specs) - 1; i >= 0; i-- {
+		name, arg, _ := strings.Cut(specs[i], ":")
+		registryMu.RLock()
+		m, ok := middlewares[name]
+		registryMu.RUnlock()
+		if !ok {
+			return nil, fmt.Errorf("unknown backend middleware %q (available: %s)", name, strings.Join(MiddlewareNames(), ", "))
+		}
+		wrapped, err := m(b, arg, opts)
+		if err != nil {
+			return nil, fmt.Errorf("backend middleware %s: %w", name, err)
+		}
+		b = wrapped
+	}
+	return b, nil
+}
+
+// Wrapper is embedded by middleware backends. It forwards every Backend
+// method to the wrapped backend, so a middleware only overrides what it
+// changes, and passes Flush and Close down the stack. It is a Batcher
+// forwarding batches too, so a middleware overriding Get, Set or Delete
+// must override GetMany, SetMany or DeleteMany as well. The other optional
+// interfaces (Verifier, ExternalEntries, ...) are found through As.
+type Wrapper struct {
+	Backend
+}
+
+var _ Batcher = Wrapper{}
+
+// GetMany implements Batcher for the wrapped backend.
+func (w Wrapper) GetMany(ctx context.Context, targets []string) ([][]byte, []error) {
+	return GetMany(ctx, w.Backend, targets)
+}
+
+// SetMany implements Batcher for the wrapped backend.
+func (w Wrapper) SetMany(ctx context.Context, secrets map[string][]byte) error {
+	return SetMany(ctx, w.Backend, secrets)
+}
+
+// DeleteMany implements Batcher for the wrapped backend.
+func (w Wrapper) DeleteMany(ctx context.Context, targets []string) []error {
+	return DeleteMany(ctx, w.Backend, targets)
+}
+
+// Unwrap returns the wrapped backend.
+func (w Wrapper) Unwrap() Backend {
+	return w.Backend
+}
+
+// Flush implements Flusher for the wrapped backend.
+func (w Wrapper) Flush() error {
+	if f, ok := w.Backend.(Flusher); ok {
+		return f.Flush()
+	}
+	return nil
+}
+
+// Close releases the wrapped backend if it holds resources.
+func (w Wrapper) Close() error {
+	if c, ok := w.Backend.(io.Closer); ok {
+		return c.Close()
+	}
+	return nil
+}
+
+// As returns the first backend in the middleware stack b that implements
+// T, unwrapping layers with an Unwrap method. Use it instead of a type
+// assertion to find optional interfaces such as Verifier.
+func As[T any](b Backend) (T, bool) {
+	for b != nil {
+		if t, ok := b.(T); ok {
+			return t, true
+		}
+		u, ok := b.(interface{ Unwrap() Backend })
+		if !ok {
+			break
+		}
+		b = u.Unwrap()
+	}
+	var zero T
+	return zero, false
+}
+
+// StatsReporter is implemented by backends that count their calls. The
+// counters are added to the daemon's Admin.Stats.
+type StatsReporter interface {
+	Stats() map[string]uint64
+}
+
+// Diagnoser is implemented by backends that can describe their internal
+// state, such as recent call latencies, for the daemon's diagnostic dump.
+// The attributes must not contain secrets.
+type Diagnoser interface {
+	Diagnostics() []slog.Attr
+}
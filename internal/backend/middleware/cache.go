@@ -0,0 +1,149 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package middleware
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/akihiro/wsl-secret-service/internal/backend"
+	"github.com/akihiro/wsl-secret-service/internal/secretcache"
+)
+
+// defaultCacheTTL is how long secrets are cached without an argument.
+const defaultCacheTTL = 30 * time.Second
+
+// cacheBackend keeps secrets returned by Get for a fixed time, in mlocked
+// buffers wiped on expiry, on Set or Delete of the target, on Flush and on
+// Close. A secret that cannot be locked into memory is not cached. Unlike
+// --secret-cache-ttl, it knows nothing about items and collections and so
+// also serves other users of the backend (e.g. the ssh-agent).
+type cacheBackend struct {
+	backend.Wrapper
+	secrets *secretcache.Cache
+}
+
+func newCache(next backend.Backend, arg string, _ backend.Options) (backend.Backend, error) {
+	ttl := defaultCacheTTL
+	if arg != "" {
+		d, err := time.ParseDuration(arg)
+		if err != nil || d <= 0 {
+			return nil, fmt.Errorf("invalid TTL %q", arg)
+		}
+		ttl = d
+	}
+	return &cacheBackend{Wrapper: backend.Wrapper{Backend: next}, secrets: secretcache.New(ttl)}, nil
+}
+
+// Get returns a copy of the cached secret of target or reads it from the
+// wrapped backend.
+func (c *cacheBackend) Get(ctx context.Context, target string) ([]byte, error) {
+	if b, ok := c.secrets.Get(target); ok {
+		return b, nil
+	}
+	version := c.secrets.Version()
+	secret, err := c.Backend.Get(ctx, target)
+	if err == nil {
+		c.put(target, secret, version)
+	}
+	return secret, err
+}
+
+// GetMany implements backend.Batcher, serving what it can from the cache
+// and reading the rest from the wrapped backend in one batch.
+func (c *cacheBackend) GetMany(ctx context.Context, targets []string) ([][]byte, []error) {
+	secrets := make([][]byte, len(targets))
+	errs := make([]error, len(targets))
+	var missIdx []int
+	var missTargets []string
+	for i, t := range targets {
+		if b, ok := c.secrets.Get(t); ok {
+			secrets[i] = b
+			continue
+		}
+		missIdx = append(missIdx, i)
+		missTargets = append(missTargets, t)
+	}
+	if len(missTargets) == 0 {
+		return secrets, errs
+	}
+	version := c.secrets.Version()
+	fetched, fetchErrs := backend.GetMany(ctx, c.Backend, missTargets)
+	for j, i := range missIdx {
+		secrets[i], errs[i] = fetched[j], fetchErrs[j]
+		if errs[i] == nil {
+			c.put(targets[i], secrets[i], version)
+		}
+	}
+	return secrets, errs
+}
+
+// Set stores secret in the wrapped backend, dropping any cached old value.
+// The target is dropped again afterwards so that a Get racing with the
+// write cannot cache the old value.
+func (c *cacheBackend) Set(ctx context.Context, target string, secret []byte) error {
+	c.secrets.Remove(target)
+	defer c.secrets.Remove(target)
+	return c.Backend.Set(ctx, target, secret)
+}
+
+// SetMany implements backend.Batcher like Set for every target.
+func (c *cacheBackend) SetMany(ctx context.Context, secrets map[string][]byte) error {
+	for target := range secrets {
+		c.secrets.Remove(target)
+		defer c.secrets.Remove(target)
+	}
+	return backend.SetMany(ctx, c.Backend, secrets)
+}
+
+// Delete removes target from the wrapped backend and the cache.
+func (c *cacheBackend) Delete(ctx context.Context, target string) error {
+	c.secrets.Remove(target)
+	defer c.secrets.Remove(target)
+	return c.Backend.Delete(ctx, target)
+}
+
+// DeleteMany implements backend.Batcher like Delete for every target.
+func (c *cacheBackend) DeleteMany(ctx context.Context, targets []string) []error {
+	for _, target := range targets {
+		c.secrets.Remove(target)
+		defer c.secrets.Remove(target)
+	}
+	return backend.DeleteMany(ctx, c.Backend, targets)
+}
+
+// Watch implements backend.Watcher for a wrapped backend that can watch,
+// dropping the cached secret of every changed target before reporting it.
+// Otherwise it fails with errors.ErrUnsupported.
+func (c *cacheBackend) Watch(ctx context.Context, prefix string, changed func(backend.Change)) error {
+	w, ok := backend.As[backend.Watcher](c.Backend)
+	if !ok {
+		return errors.ErrUnsupported
+	}
+	return w.Watch(ctx, prefix, func(ch backend.Change) {
+		c.secrets.Remove(ch.Target)
+		changed(ch)
+	})
+}
+
+// Flush implements backend.Flusher: cached secrets are wiped and the
+// wrapped backend flushed.
+func (c *cacheBackend) Flush() error {
+	c.secrets.Flush()
+	return c.Wrapper.Flush()
+}
+
+// Close wipes cached secrets and closes the wrapped backend.
+func (c *cacheBackend) Close() error {
+	c.secrets.Flush()
+	return c.Wrapper.Close()
+}
+
+// put caches secret, read from the wrapped backend after taking version.
+func (c *cacheBackend) put(target string, secret []byte, version uint64) {
+	if err := c.secrets.Put(target, secret, version); err != nil {
+		logger.Debug("not caching secret", "err", err)
+	}
+}
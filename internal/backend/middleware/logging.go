@@ -0,0 +1,73 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package middleware
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/akihiro/wsl-secret-service/internal/backend"
+)
+
+// loggingBackend logs each call at debug level. Unlike --trace, which logs
+// helper calls inside the wincred backend, it works with every backend.
+type loggingBackend struct {
+	backend.Wrapper
+}
+
+func newLogging(next backend.Backend, arg string, _ backend.Options) (backend.Backend, error) {
+	if err := noArg(arg); err != nil {
+		return nil, err
+	}
+	return &loggingBackend{backend.Wrapper{Backend: next}}, nil
+}
+
+func (l *loggingBackend) Get(ctx context.Context, target string) ([]byte, error) {
+	start := time.Now()
+	secret, err := l.Backend.Get(ctx, target)
+	logger.DebugContext(ctx, "backend call", "method", "Get", "target", target, "bytes", len(secret), "duration", time.Since(start), "err", err)
+	return secret, err
+}
+
+func (l *loggingBackend) Set(ctx context.Context, target string, secret []byte) error {
+	start := time.Now()
+	err := l.Backend.Set(ctx, target, secret)
+	logger.DebugContext(ctx, "backend call", "method", "Set", "target", target, "bytes", len(secret), "duration", time.Since(start), "err", err)
+	return err
+}
+
+func (l *loggingBackend) Delete(ctx context.Context, target string) error {
+	start := time.Now()
+	err := l.Backend.Delete(ctx, target)
+	logger.DebugContext(ctx, "backend call", "method", "Delete", "target", target, "duration", time.Since(start), "err", err)
+	return err
+}
+
+func (l *loggingBackend) GetMany(ctx context.Context, targets []string) ([][]byte, []error) {
+	start := time.Now()
+	secrets, errs := backend.GetMany(ctx, l.Backend, targets)
+	logger.DebugContext(ctx, "backend call", "method", "GetMany", "targets", len(targets), "duration", time.Since(start), "err", errors.Join(errs...))
+	return secrets, errs
+}
+
+func (l *loggingBackend) SetMany(ctx context.Context, secrets map[string][]byte) error {
+	start := time.Now()
+	err := backend.SetMany(ctx, l.Backend, secrets)
+	logger.DebugContext(ctx, "backend call", "method", "SetMany", "targets", len(secrets), "duration", time.Since(start), "err", err)
+	return err
+}
+
+func (l *loggingBackend) DeleteMany(ctx context.Context, targets []string) []error {
+	start := time.Now()
+	errs := backend.DeleteMany(ctx, l.Backend, targets)
+	logger.DebugContext(ctx, "backend call", "method", "DeleteMany", "targets", len(targets), "duration", time.Since(start), "err", errors.Join(errs...))
+	return errs
+}
+
+func (l *loggingBackend) List(ctx context.Context, prefix string) ([]string, error) {
+	start := time.Now()
+	targets, err := l.Backend.List(ctx, prefix)
+	logger.DebugContext(ctx, "backend call", "method", "List", "prefix", prefix, "targets", len(targets), "duration", time.Since(start), "err", err)
+	return targets, err
+}
@@ -0,0 +1,108 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package middleware
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"time"
+
+	"github.com/akihiro/wsl-secret-service/internal/backend"
+)
+
+// counters holds the statistics of one method.
+type counters struct {
+	calls, errors atomic.Uint64
+	micros        atomic.Uint64 // total latency
+}
+
+func (c *counters) observe(start time.Time, err error) {
+	c.calls.Add(1)
+	if err != nil {
+		c.errors.Add(1)
+	}
+	c.micros.Add(uint64(time.Since(start).Microseconds()))
+}
+
+// metricsBackend counts calls, failures and latency per method and reports
+// them through backend.StatsReporter.
+type metricsBackend struct {
+	backend.Wrapper
+	get, set, del, list          counters
+	getMany, setMany, deleteMany counters
+}
+
+func newMetrics(next backend.Backend, arg string, _ backend.Options) (backend.Backend, error) {
+	if err := noArg(arg); err != nil {
+		return nil, err
+	}
+	return &metricsBackend{Wrapper: backend.Wrapper{Backend: next}}, nil
+}
+
+func (m *metricsBackend) Get(ctx context.Context, target string) ([]byte, error) {
+	start := time.Now()
+	secret, err := m.Backend.Get(ctx, target)
+	m.get.observe(start, err)
+	return secret, err
+}
+
+func (m *metricsBackend) Set(ctx context.Context, target string, secret []byte) error {
+	start := time.Now()
+	err := m.Backend.Set(ctx, target, secret)
+	m.set.observe(start, err)
+	return err
+}
+
+func (m *metricsBackend) Delete(ctx context.Context, target string) error {
+	start := time.Now()
+	err := m.Backend.Delete(ctx, target)
+	m.del.observe(start, err)
+	return err
+}
+
+func (m *metricsBackend) GetMany(ctx context.Context, targets []string) ([][]byte, []error) {
+	start := time.Now()
+	secrets, errs := backend.GetMany(ctx, m.Backend, targets)
+	m.getMany.observe(start, errors.Join(errs...))
+	return secrets, errs
+}
+
+func (m *metricsBackend) SetMany(ctx context.Context, secrets map[string][]byte) error {
+	start := time.Now()
+	err := backend.SetMany(ctx, m.Backend, secrets)
+	m.setMany.observe(start, err)
+	return err
+}
+
+func (m *metricsBackend) DeleteMany(ctx context.Context, targets []string) []error {
+	start := time.Now()
+	errs := backend.DeleteMany(ctx, m.Backend, targets)
+	m.deleteMany.observe(start, errors.Join(errs...))
+	return errs
+}
+
+func (m *metricsBackend) List(ctx context.Context, prefix string) ([]string, error) {
+	start := time.Now()
+	targets, err := m.Backend.List(ctx, prefix)
+	m.list.observe(start, err)
+	return targets, err
+}
+
+// Stats implements backend.StatsReporter: backend_<method>_calls,
+// backend_<method>_errors and backend_<method>_micros (total latency) for
+// get, set, delete, list and the batches get_many, set_many and
+// delete_many. A batch counts as one call, failed if any target failed.
+func (m *metricsBackend) Stats() map[string]uint64 {
+	stats := make(map[string]uint64, 21)
+	for name, c := range map[string]*counters{
+		"get": &m.get, "set": &m.set, "delete": &m.del, "list": &m.list,
+		"get_many": &m.getMany, "set_many": &m.setMany, "delete_many": &m.deleteMany,
+	} {
+		prefix := "backend_" + name + "_"
+		stats[prefix+"calls"] = c.calls.Load()
+		stats[prefix+"errors"] = c.errors.Load()
+		stats[prefix+"micros"] = c.micros.Load()
+	}
+	return stats
+}
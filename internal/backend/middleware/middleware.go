@@ -0,0 +1,46 @@
+// SPDX-License-Identifier: Apache-2.0
+
+// Package middleware provides backend wrappers that can be stacked on any
+// backend with backend.Wrap, as selected by --backend-middleware or
+// "middleware" in config.json:
+//
+//	logging              log every call with its latency and result at debug level
+//	metrics              count calls, failures and latency per method (Admin.Stats)
+//	retry[:N]            retry calls failing with backend.ErrUnavailable, N attempts
+//	cache[:TTL]          keep secrets read for TTL in locked memory
+//	writebehind[:DELAY]  hold back rewrites of a target for DELAY, coalescing them
+//
+// Secrets are never logged.
+package middleware
+
+import (
+	"fmt"
+
+	"github.com/akihiro/wsl-secret-service/internal/backend"
+	"github.com/akihiro/wsl-secret-service/internal/logging"
+)
+
+var logger = logging.For(logging.Backend)
+
+func init() {
+	backend.RegisterMiddleware("logging", newLogging)
+	backend.RegisterMiddleware("metrics", newMetrics)
+	backend.RegisterMiddleware("retry", newRetry)
+	backend.RegisterMiddleware("cache", newCache)
+	backend.RegisterMiddleware("writebehind", newWriteBehind)
+}
+
+// noArg rejects an argument for middlewares that take none.
+func noArg(arg string) error {
+	if arg != "" {
+		return fmt.Errorf("unexpected argument %q", arg)
+	}
+	return nil
+}
+
+// unbatched hides the batch methods of a middleware, so that backend.GetMany
+// and friends fall back to its own Get, Set and Delete. Middlewares that
+// cannot pass a batch on as a whole use it to implement backend.Batcher.
+type unbatched struct {
+	backend.Backend
+}
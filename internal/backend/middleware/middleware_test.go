@@ -0,0 +1,290 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package middleware
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/akihiro/wsl-secret-service/internal/backend"
+	"github.com/akihiro/wsl-secret-service/pkg/backend/memory"
+)
+
+// counting is a memory backend that counts Gets and Sets and fails the
+// first `failures` Gets as unavailable.
+type counting struct {
+	*memory.Backend
+	gets     int
+	sets     atomic.Int32
+	failures int
+}
+
+func (c *counting) Set(ctx context.Context, target string, secret []byte) error {
+	c.sets.Add(1)
+	return c.Backend.Set(ctx, target, secret)
+}
+
+func (c *counting) Get(ctx context.Context, target string) ([]byte, error) {
+	c.gets++
+	if c.failures > 0 {
+		c.failures--
+		return nil, &backend.ErrUnavailable{Err: errors.New("helper cannot be started")}
+	}
+	return c.Backend.Get(ctx, target)
+}
+
+func newCounting(t *testing.T) *counting {
+	t.Helper()
+	c := &counting{Backend: memory.New()}
+	if err := c.Set(t.Context(), "wsl-ss/login/a", []byte("secret")); err != nil {
+		t.Fatal(err)
+	}
+	return c
+}
+
+func TestRetry(t *testing.T) {
+	c := newCounting(t)
+	c.failures = 1
+	b, err := backend.Wrap(c, []string{"retry:2"}, backend.Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, err := b.Get(t.Context(), "wsl-ss/login/a"); err != nil || string(got) != "secret" {
+		t.Fatalf("Get = %q, %v", got, err)
+	}
+	if c.gets != 2 {
+		t.Errorf("backend called %d times, want 2", c.gets)
+	}
+
+	c.gets, c.failures = 0, 5
+	var unavailable *backend.ErrUnavailable
+	if _, err := b.Get(t.Context(), "wsl-ss/login/a"); !errors.As(err, &unavailable) {
+		t.Errorf("Get after the attempts are used up: err = %v, want ErrUnavailable", err)
+	}
+	if c.gets != 2 {
+		t.Errorf("backend called %d times, want 2", c.gets)
+	}
+
+	c.gets = 0
+	var notFound *backend.ErrNotFound
+	c.failures = 0
+	if _, err := b.Get(t.Context(), "wsl-ss/login/missing"); !errors.As(err, &notFound) || c.gets != 1 {
+		t.Errorf("Get of a missing target: err = %v after %d calls, want ErrNotFound after 1", err, c.gets)
+	}
+
+	if _, err := backend.Wrap(c, []string{"retry:0"}, backend.Options{}); err == nil {
+		t.Error("retry:0 accepted")
+	}
+}
+
+func TestCache(t *testing.T) {
+	c := newCounting(t)
+	b, err := backend.Wrap(c, []string{"cache:1h"}, backend.Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer b.(interface{ Close() error }).Close()
+	ctx := t.Context()
+	for range 3 {
+		if got, err := b.Get(ctx, "wsl-ss/login/a"); err != nil || string(got) != "secret" {
+			t.Fatalf("Get = %q, %v", got, err)
+		}
+	}
+	if c.gets != 1 {
+		t.Errorf("backend called %d times for 3 Gets, want 1", c.gets)
+	}
+
+	if err := b.Set(ctx, "wsl-ss/login/a", []byte("new")); err != nil {
+		t.Fatal(err)
+	}
+	if got, err := b.Get(ctx, "wsl-ss/login/a"); err != nil || string(got) != "new" {
+		t.Errorf("Get after Set = %q, %v", got, err)
+	}
+	if err := b.(backend.Flusher).Flush(); err != nil {
+		t.Fatal(err)
+	}
+	c.gets = 0
+	if _, err := b.Get(ctx, "wsl-ss/login/a"); err != nil || c.gets != 1 {
+		t.Errorf("Get after Flush: err = %v, backend called %d times, want 1", err, c.gets)
+	}
+}
+
+// slowGet is a memory backend whose Gets read the secret, then wait for
+// release before returning it.
+type slowGet struct {
+	*memory.Backend
+	read, release chan struct{}
+}
+
+func (s *slowGet) Get(ctx context.Context, target string) ([]byte, error) {
+	secret, err := s.Backend.Get(ctx, target)
+	s.read <- struct{}{}
+	<-s.release
+	return secret, err
+}
+
+func TestCacheDropsStaleRead(t *testing.T) {
+	ctx := t.Context()
+	s := &slowGet{Backend: memory.New(), read: make(chan struct{}), release: make(chan struct{})}
+	if err := s.Set(ctx, "wsl-ss/login/a", []byte("old")); err != nil {
+		t.Fatal(err)
+	}
+	b, err := backend.Wrap(s, []string{"cache:1h"}, backend.Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer b.(interface{ Close() error }).Close()
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		_, _ = b.Get(ctx, "wsl-ss/login/a")
+	}()
+	<-s.read
+	if err := b.Set(ctx, "wsl-ss/login/a", []byte("new")); err != nil {
+		t.Fatal(err)
+	}
+	close(s.release)
+	<-done
+
+	go func() { <-s.read }()
+	if got, err := b.Get(ctx, "wsl-ss/login/a"); err != nil || string(got) != "new" {
+		t.Errorf("Get after a Set racing with a Get = %q, %v, want \"new\"", got, err)
+	}
+}
+
+func TestBatchThroughMiddlewares(t *testing.T) {
+	c := newCounting(t)
+	c.failures = 1
+	b, err := backend.Wrap(c, []string{"logging", "cache:1h", "metrics", "retry:2"}, backend.Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer b.(interface{ Close() error }).Close()
+	ctx := t.Context()
+	targets := []string{"wsl-ss/login/a", "wsl-ss/login/missing"}
+	for range 2 {
+		secrets, errs := backend.GetMany(ctx, b, targets)
+		var notFound *backend.ErrNotFound
+		if errs[0] != nil || string(secrets[0]) != "secret" || !errors.As(errs[1], &notFound) {
+			t.Fatalf("GetMany = %q, %v", secrets, errs)
+		}
+	}
+	// One failed attempt and its retry for a, two misses for missing.
+	if c.gets != 4 {
+		t.Errorf("backend called %d times, want 4", c.gets)
+	}
+	r, _ := backend.As[backend.StatsReporter](b)
+	if stats := r.Stats(); stats["backend_get_many_calls"] != 2 || stats["backend_get_calls"] != 0 {
+		t.Errorf("Stats = %v", stats)
+	}
+
+	if err := backend.SetMany(ctx, b, map[string][]byte{"wsl-ss/login/a": []byte("new")}); err != nil {
+		t.Fatal(err)
+	}
+	if secrets, errs := backend.GetMany(ctx, b, targets[:1]); errs[0] != nil || string(secrets[0]) != "new" {
+		t.Errorf("GetMany after SetMany = %q, %v", secrets, errs)
+	}
+}
+
+func TestMetrics(t *testing.T) {
+	c := newCounting(t)
+	b, err := backend.Wrap(c, []string{"logging", "metrics"}, backend.Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, _ = b.Get(t.Context(), "wsl-ss/login/a")
+	_, _ = b.Get(t.Context(), "wsl-ss/login/missing")
+	r, ok := backend.As[backend.StatsReporter](b)
+	if !ok {
+		t.Fatal("metrics layer not found")
+	}
+	stats := r.Stats()
+	if stats["backend_get_calls"] != 2 || stats["backend_get_errors"] != 1 || stats["backend_set_calls"] != 0 {
+		t.Errorf("Stats = %v", stats)
+	}
+}
+
+func TestWriteBehind(t *testing.T) {
+	c := newCounting(t)
+	b, err := backend.Wrap(c, []string{"writebehind:1h"}, backend.Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := t.Context()
+	read := func(b backend.Backend) string {
+		t.Helper()
+		got, err := b.Get(ctx, "wsl-ss/login/a")
+		if err != nil {
+			return err.Error()
+		}
+		return string(got)
+	}
+
+	// The first Set of a target is written at once, later ones held back.
+	c.sets.Store(0)
+	for _, v := range []string{"v1", "v2", "v3"} {
+		if err := b.Set(ctx, "wsl-ss/login/a", []byte(v)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if n := c.sets.Load(); n != 1 || read(c) != "v1" || read(b) != "v3" {
+		t.Errorf("after 3 Sets: %d backend writes, backend has %q, Get = %q; want 1, v1, v3", n, read(c), read(b))
+	}
+	if err := b.(backend.Flusher).Flush(); err != nil {
+		t.Fatal(err)
+	}
+	if n := c.sets.Load(); n != 2 || read(c) != "v3" {
+		t.Errorf("after Flush: %d backend writes, backend has %q; want 2, v3", n, read(c))
+	}
+
+	// Delete drops a held back write.
+	if err := b.Set(ctx, "wsl-ss/login/a", []byte("v4")); err != nil {
+		t.Fatal(err)
+	}
+	if err := b.Delete(ctx, "wsl-ss/login/a"); err != nil {
+		t.Fatal(err)
+	}
+	var notFound *backend.ErrNotFound
+	if _, err := b.Get(ctx, "wsl-ss/login/a"); !errors.As(err, &notFound) {
+		t.Errorf("Get after Delete: err = %v, want ErrNotFound", err)
+	}
+
+	// Close writes what is held back before closing the backend, which
+	// for the memory backend drops everything.
+	c.sets.Store(0)
+	for _, v := range []string{"v5", "v6"} {
+		if err := b.Set(ctx, "wsl-ss/login/a", []byte(v)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := b.(interface{ Close() error }).Close(); err != nil {
+		t.Fatal(err)
+	}
+	if n := c.sets.Load(); n != 2 {
+		t.Errorf("%d backend writes for a new target's Set and a held back one, want 2", n)
+	}
+
+	// Without Flush or Close the write happens after the delay.
+	b, err = backend.Wrap(c, []string{"writebehind:10ms"}, backend.Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, v := range []string{"v7", "v8"} {
+		if err := b.Set(ctx, "wsl-ss/login/a", []byte(v)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for deadline := time.Now().Add(5 * time.Second); read(c) != "v8"; time.Sleep(5 * time.Millisecond) {
+		if time.Now().After(deadline) {
+			t.Fatalf("backend still has %q, want v8", read(c))
+		}
+	}
+
+	if _, err := backend.Wrap(c, []string{"writebehind:0"}, backend.Options{}); err == nil {
+		t.Error("writebehind:0 accepted")
+	}
+}
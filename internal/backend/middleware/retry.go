@@ -0,0 +1,165 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package middleware
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/akihiro/wsl-secret-service/internal/backend"
+)
+
+// Retry defaults: the number of attempts and the delay before the first
+// retry, doubled for each further one.
+const (
+	defaultAttempts = 3
+	retryDelay      = 500 * time.Millisecond
+)
+
+// retryBackend repeats calls that fail with *backend.ErrUnavailable, for
+// example because wincred-helper.exe could not be started. Every method is
+// idempotent, so repeating them is safe; other errors are returned at once.
+type retryBackend struct {
+	backend.Wrapper
+	attempts int
+}
+
+func newRetry(next backend.Backend, arg string, _ backend.Options) (backend.Backend, error) {
+	attempts := defaultAttempts
+	if arg != "" {
+		n, err := strconv.Atoi(arg)
+		if err != nil || n < 1 {
+			return nil, fmt.Errorf("invalid number of attempts %q", arg)
+		}
+		attempts = n
+	}
+	return &retryBackend{Wrapper: backend.Wrapper{Backend: next}, attempts: attempts}, nil
+}
+
+// do calls f until it succeeds, fails with another error than
+// *backend.ErrUnavailable, the attempts are used up or ctx is done.
+func (r *retryBackend) do(ctx context.Context, method string, f func() error) error {
+	delay := retryDelay
+	for attempt := 1; ; attempt++ {
+		err := f()
+		var unavailable *backend.ErrUnavailable
+		if !errors.As(err, &unavailable) || attempt == r.attempts {
+			return err
+		}
+		logger.DebugContext(ctx, "retrying unavailable backend", "method", method, "attempt", attempt, "delay", delay, "err", err)
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("%w (gave up retrying: %w)", err, ctx.Err())
+		case <-time.After(delay):
+		}
+		delay *= 2
+	}
+}
+
+// doMany is do for a batch: f handles the targets at the given indices,
+// storing their results in errs, and is called again with the indices of
+// those that failed with *backend.ErrUnavailable.
+func (r *retryBackend) doMany(ctx context.Context, method string, errs []error, f func(at []int)) {
+	at := make([]int, len(errs))
+	for i := range at {
+		at[i] = i
+	}
+	delay := retryDelay
+	for attempt := 1; ; attempt++ {
+		f(at)
+		var failed []int
+		for _, i := range at {
+			var unavailable *backend.ErrUnavailable
+			if errors.As(errs[i], &unavailable) {
+				failed = append(failed, i)
+			}
+		}
+		if len(failed) == 0 || attempt == r.attempts {
+			return
+		}
+		logger.DebugContext(ctx, "retrying unavailable backend", "method", method, "attempt", attempt, "targets", len(failed), "delay", delay)
+		select {
+		case <-ctx.Done():
+			for _, i := range failed {
+				errs[i] = fmt.Errorf("%w (gave up retrying: %w)", errs[i], ctx.Err())
+			}
+			return
+		case <-time.After(delay):
+		}
+		at = failed
+		delay *= 2
+	}
+}
+
+func (r *retryBackend) Get(ctx context.Context, target string) ([]byte, error) {
+	var secret []byte
+	err := r.do(ctx, "Get", func() (err error) {
+		secret, err = r.Backend.Get(ctx, target)
+		return err
+	})
+	return secret, err
+}
+
+func (r *retryBackend) Set(ctx context.Context, target string, secret []byte) error {
+	return r.do(ctx, "Set", func() error {
+		return r.Backend.Set(ctx, target, secret)
+	})
+}
+
+func (r *retryBackend) Delete(ctx context.Context, target string) error {
+	return r.do(ctx, "Delete", func() error {
+		return r.Backend.Delete(ctx, target)
+	})
+}
+
+// GetMany implements backend.Batcher, retrying only the targets that
+// failed with *backend.ErrUnavailable.
+func (r *retryBackend) GetMany(ctx context.Context, targets []string) ([][]byte, []error) {
+	secrets := make([][]byte, len(targets))
+	errs := make([]error, len(targets))
+	r.doMany(ctx, "GetMany", errs, func(at []int) {
+		batch := make([]string, len(at))
+		for j, i := range at {
+			batch[j] = targets[i]
+		}
+		fetched, fetchErrs := backend.GetMany(ctx, r.Backend, batch)
+		for j, i := range at {
+			secrets[i], errs[i] = fetched[j], fetchErrs[j]
+		}
+	})
+	return secrets, errs
+}
+
+func (r *retryBackend) SetMany(ctx context.Context, secrets map[string][]byte) error {
+	return r.do(ctx, "SetMany", func() error {
+		return backend.SetMany(ctx, r.Backend, secrets)
+	})
+}
+
+// DeleteMany implements backend.Batcher, retrying only the targets that
+// failed with *backend.ErrUnavailable.
+func (r *retryBackend) DeleteMany(ctx context.Context, targets []string) []error {
+	errs := make([]error, len(targets))
+	r.doMany(ctx, "DeleteMany", errs, func(at []int) {
+		batch := make([]string, len(at))
+		for j, i := range at {
+			batch[j] = targets[i]
+		}
+		for j, err := range backend.DeleteMany(ctx, r.Backend, batch) {
+			errs[at[j]] = err
+		}
+	})
+	return errs
+}
+
+func (r *retryBackend) List(ctx context.Context, prefix string) ([]string, error) {
+	var targets []string
+	err := r.do(ctx, "List", func() (err error) {
+		targets, err = r.Backend.List(ctx, prefix)
+		return err
+	})
+	return targets, err
+}
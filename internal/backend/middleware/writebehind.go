@@ -0,0 +1,246 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package middleware
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"maps"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/akihiro/wsl-secret-service/internal/backend"
+	"github.com/akihiro/wsl-secret-service/internal/memprotect"
+)
+
+// defaultWriteDelay is how long writes are held back without an argument.
+const defaultWriteDelay = 2 * time.Second
+
+// writeBehindBackend acknowledges a Set of a target it has already stored
+// or read at once and writes the secret to the wrapped backend after a
+// delay, so that an application saving the same token over and over pays
+// for one backend write per delay instead of one per save: Sets of a
+// target arriving while its write is held back only replace the secret to
+// be written. Gets return the held back secret.
+//
+// The first Set of a target goes through at once, so that the errors of
+// creating it (a target too long for the backend, the backend being
+// unreachable) reach the client. A held back write failing later is only
+// logged, and retried if the backend is unavailable. Flush and Close write
+// everything held back; the daemon closes the backend when it shuts down,
+// including on SIGTERM.
+type writeBehindBackend struct {
+	backend.Wrapper
+	delay time.Duration
+
+	// writeMu serializes the writes to the wrapped backend, so that a held
+	// back write cannot overtake a later Delete of its target.
+	writeMu sync.Mutex
+
+	mu      sync.Mutex
+	known   map[string]bool // targets stored or read through this layer
+	pending map[string]*pendingWrite
+	closed  bool
+}
+
+// pendingWrite is a secret waiting to be written.
+type pendingWrite struct {
+	ctx     context.Context // of the last Set, without its cancellation
+	secret  []byte          // from memprotect.AllocSecret
+	timer   *time.Timer
+	writing bool // being written; a new Set queues another write
+}
+
+func newWriteBehind(next backend.Backend, arg string, _ backend.Options) (backend.Backend, error) {
+	delay := defaultWriteDelay
+	if arg != "" {
+		d, err := time.ParseDuration(arg)
+		if err != nil || d <= 0 {
+			return nil, fmt.Errorf("invalid delay %q", arg)
+		}
+		delay = d
+	}
+	return &writeBehindBackend{
+		Wrapper: backend.Wrapper{Backend: next},
+		delay:   delay,
+		known:   make(map[string]bool),
+		pending: make(map[string]*pendingWrite),
+	}, nil
+}
+
+// Get returns a copy of the secret waiting to be written to target, or
+// reads it from the wrapped backend.
+func (w *writeBehindBackend) Get(ctx context.Context, target string) ([]byte, error) {
+	w.mu.Lock()
+	if p, ok := w.pending[target]; ok {
+		b := memprotect.AllocSecret(len(p.secret))
+		copy(b, p.secret)
+		w.mu.Unlock()
+		return b, nil
+	}
+	w.mu.Unlock()
+
+	secret, err := w.Backend.Get(ctx, target)
+	if err == nil {
+		w.mu.Lock()
+		w.known[target] = true
+		w.mu.Unlock()
+	}
+	return secret, err
+}
+
+// GetMany implements backend.Batcher by calling Get for every target, so
+// that held back secrets are returned.
+func (w *writeBehindBackend) GetMany(ctx context.Context, targets []string) ([][]byte, []error) {
+	return backend.GetMany(ctx, unbatched{w}, targets)
+}
+
+// SetMany implements backend.Batcher by calling Set for every target.
+func (w *writeBehindBackend) SetMany(ctx context.Context, secrets map[string][]byte) error {
+	return backend.SetMany(ctx, unbatched{w}, secrets)
+}
+
+// DeleteMany implements backend.Batcher by calling Delete for every
+// target, so that their held back writes are dropped.
+func (w *writeBehindBackend) DeleteMany(ctx context.Context, targets []string) []error {
+	return backend.DeleteMany(ctx, unbatched{w}, targets)
+}
+
+// Set holds back the write of secret if target is known to exist, and
+// otherwise stores it in the wrapped backend at once.
+func (w *writeBehindBackend) Set(ctx context.Context, target string, secret []byte) error {
+	w.mu.Lock()
+	if w.closed || !w.known[target] {
+		w.mu.Unlock()
+		w.writeMu.Lock()
+		defer w.writeMu.Unlock()
+		if err := w.Backend.Set(ctx, target, secret); err != nil {
+			return err
+		}
+		w.mu.Lock()
+		w.known[target] = true
+		w.mu.Unlock()
+		return nil
+	}
+	defer w.mu.Unlock()
+	value := memprotect.AllocSecret(len(secret))
+	copy(value, secret)
+	ctx = context.WithoutCancel(ctx)
+	if p, ok := w.pending[target]; ok && !p.writing {
+		logger.DebugContext(ctx, "coalescing held back write", "target", target)
+		memprotect.Wipe(p.secret)
+		p.secret, p.ctx = value, ctx
+		return nil
+	}
+	w.queueLocked(target, &pendingWrite{ctx: ctx, secret: value})
+	return nil
+}
+
+// queueLocked holds back p, to be written after the delay.
+func (w *writeBehindBackend) queueLocked(target string, p *pendingWrite) {
+	p.timer = time.AfterFunc(w.delay, func() {
+		if err := w.write(target, p); err != nil {
+			logger.Error("held back write failed", "target", target, "err", err)
+		}
+	})
+	w.pending[target] = p
+}
+
+// write stores p in the wrapped backend, unless it was replaced, written or
+// deleted in the meantime. A write failing because the backend is
+// unavailable is queued again, unless the backend is being closed.
+func (w *writeBehindBackend) write(target string, p *pendingWrite) error {
+	w.writeMu.Lock()
+	defer w.writeMu.Unlock()
+	w.mu.Lock()
+	if w.pending[target] != p {
+		w.mu.Unlock()
+		return nil
+	}
+	p.timer.Stop()
+	p.writing = true
+	w.mu.Unlock()
+
+	err := w.Backend.Set(p.ctx, target, p.secret)
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	var unavailable *backend.ErrUnavailable
+	if w.pending[target] == p && errors.As(err, &unavailable) && !w.closed {
+		logger.Warn("backend unavailable, retrying held back write", "target", target, "delay", w.delay, "err", err)
+		p.writing = false
+		w.queueLocked(target, p)
+		return nil
+	}
+	if w.pending[target] == p {
+		delete(w.pending, target)
+	}
+	memprotect.Wipe(p.secret)
+	if err != nil {
+		return fmt.Errorf("write %q: %w", target, err)
+	}
+	return nil
+}
+
+// Delete drops the write held back for target, if any, and removes target
+// from the wrapped backend. A target that only had a held back write is
+// not missing.
+func (w *writeBehindBackend) Delete(ctx context.Context, target string) error {
+	w.writeMu.Lock()
+	defer w.writeMu.Unlock()
+	w.mu.Lock()
+	p, held := w.pending[target]
+	if held {
+		p.timer.Stop()
+		memprotect.Wipe(p.secret)
+		delete(w.pending, target)
+	}
+	delete(w.known, target)
+	w.mu.Unlock()
+
+	err := w.Backend.Delete(ctx, target)
+	var notFound *backend.ErrNotFound
+	if held && errors.As(err, &notFound) {
+		return nil
+	}
+	return err
+}
+
+// writeAll writes everything held back at once.
+func (w *writeBehindBackend) writeAll() error {
+	w.mu.Lock()
+	targets := make([]string, 0, len(w.pending))
+	writes := make([]*pendingWrite, 0, len(w.pending))
+	for _, t := range slices.Sorted(maps.Keys(w.pending)) {
+		targets = append(targets, t)
+		writes = append(writes, w.pending[t])
+	}
+	w.mu.Unlock()
+
+	var errs []error
+	for i, t := range targets {
+		errs = append(errs, w.write(t, writes[i]))
+	}
+	return errors.Join(errs...)
+}
+
+// Flush implements backend.Flusher: everything held back is written and
+// the wrapped backend flushed.
+func (w *writeBehindBackend) Flush() error {
+	return errors.Join(w.writeAll(), w.Wrapper.Flush())
+}
+
+// Close writes everything held back and closes the wrapped backend. Later
+// Sets are written at once.
+func (w *writeBehindBackend) Close() error {
+	w.mu.Lock()
+	w.closed = true
+	w.mu.Unlock()
+	err := w.writeAll()
+	if err != nil {
+		logger.Error("held back writes lost at shutdown", "err", err)
+	}
+	return errors.Join(err, w.Wrapper.Close())
+}
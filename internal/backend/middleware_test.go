@@ -0,0 +1,75 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package backend_test
+
+import (
+	"context"
+	"slices"
+	"testing"
+
+	"github.com/akihiro/wsl-secret-service/internal/backend"
+	"github.com/akihiro/wsl-secret-service/pkg/backend/memory"
+)
+
+// tagging records in wrapOrder the order in which layers see a Get.
+type tagging struct {
+	backend.Wrapper
+	name string
+}
+
+// wrapOrder is where the tagging layers record their Gets.
+var wrapOrder []string
+
+func (t *tagging) Get(ctx context.Context, target string) ([]byte, error) {
+	wrapOrder = append(wrapOrder, t.name)
+	return t.Backend.Get(ctx, target)
+}
+
+// The registry is global and refuses duplicates, so the test middlewares
+// are registered once rather than by the test, which may run repeatedly.
+func init() {
+	for _, name := range []string{"test-outer", "test-inner"} {
+		backend.RegisterMiddleware(name, func(next backend.Backend, arg string, _ backend.Options) (backend.Backend, error) {
+			return &tagging{Wrapper: backend.Wrapper{Backend: next}, name: name + arg}, nil
+		})
+	}
+}
+
+// verifying is a backend that can verify the user.
+type verifying struct {
+	*memory.Backend
+}
+
+func (verifying) Verify(context.Context, string) error { return nil }
+
+func TestWrapOrderAndAs(t *testing.T) {
+	wrapOrder = nil
+	base := verifying{memory.New()}
+	if err := base.Set(t.Context(), "t", []byte("s")); err != nil {
+		t.Fatal(err)
+	}
+	b, err := backend.Wrap(base, []string{"test-outer:1", "test-inner"}, backend.Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := b.Get(t.Context(), "t"); err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"test-outer1", "test-inner"}; !slices.Equal(wrapOrder, want) {
+		t.Errorf("layers called in order %v, want %v", wrapOrder, want)
+	}
+
+	if _, ok := b.(backend.Verifier); ok {
+		t.Fatal("wrapper unexpectedly implements Verifier itself")
+	}
+	if _, ok := backend.As[backend.Verifier](b); !ok {
+		t.Error("As did not find the wrapped Verifier")
+	}
+	if _, ok := backend.As[backend.ExternalEntries](b); ok {
+		t.Error("As found an ExternalEntries nobody implements")
+	}
+
+	if _, err := backend.Wrap(base, []string{"no-such-middleware"}, backend.Options{}); err == nil {
+		t.Error("Wrap accepted an unknown middleware")
+	}
+}
@@ -0,0 +1,230 @@
+// SPDX-License-Identifier: Apache-2.0
+
+// Package pass provides a backend that stores secrets as GPG-encrypted
+// files in a pass(1) password store ($PASSWORD_STORE_DIR, default
+// ~/.password-store). A target such as "wsl-ss/login/<uuid>" is stored as
+// wsl-ss/login/<uuid>.gpg, encrypted to the recipients listed in the
+// nearest .gpg-id file, exactly as pass itself would do.
+//
+// Entries already present in the store are reported by List and adopted as
+// items at startup (see ExternalCollection). Secrets are returned as the
+// whole decrypted file, i.e. the password on the first line followed by
+// any extra lines pass users keep there.
+package pass
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/akihiro/wsl-secret-service/internal/backend"
+)
+
+func init() {
+	backend.Register("pass", func(backend.Options) (backend.Backend, error) {
+		return New(DefaultDir())
+	})
+}
+
+// gpgIDFile lists the GPG recipients for a directory and its subdirectories.
+const gpgIDFile = ".gpg-id"
+
+// Backend implements backend.Backend on a pass password store.
+type Backend struct {
+	dir string
+	gpg string
+
+	mu sync.Mutex
+}
+
+// DefaultDir returns $PASSWORD_STORE_DIR, or ~/.password-store.
+func DefaultDir() string {
+	if d := os.Getenv("PASSWORD_STORE_DIR"); d != "" {
+		return d
+	}
+	home, _ := os.UserHomeDir()
+	return filepath.Join(home, ".password-store")
+}
+
+// New returns a Backend for the password store at dir, which must already
+// be initialised with "pass init".
+func New(dir string) (*Backend, error) {
+	if _, err := os.Stat(filepath.Join(dir, gpgIDFile)); err != nil {
+		return nil, fmt.Errorf("password store %s is not initialised (run 'pass init <gpg-id>'): %w", dir, err)
+	}
+	gpg, err := exec.LookPath("gpg2")
+	if err != nil {
+		if gpg, err = exec.LookPath("gpg"); err != nil {
+			return nil, errors.New("gpg not found in PATH")
+		}
+	}
+	return &Backend{dir: dir, gpg: gpg}, nil
+}
+
+// ExternalCollection implements backend.ExternalEntries: entries created by
+// pass itself are exposed in the "Password Store" collection.
+func (b *Backend) ExternalCollection() string {
+	return "Password Store"
+}
+
+// ExternalPrefix implements backend.ExternalEntries: every entry is adopted.
+func (b *Backend) ExternalPrefix() string {
+	return ""
+}
+
+// Get returns the raw secret bytes for the given target.
+func (b *Backend) Get(ctx context.Context, target string) ([]byte, error) {
+	path, err := b.entryPath(target)
+	if err != nil {
+		return nil, err
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if _, err := os.Stat(path); errors.Is(err, fs.ErrNotExist) {
+		return nil, &backend.ErrNotFound{Target: target}
+	}
+	cmd := exec.CommandContext(ctx, b.gpg, "--quiet", "--batch", "--yes", "--decrypt", path)
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	out, err := cmd.Output()
+	if err != nil {
+		return nil, fmt.Errorf("gpg decrypt %s: %v: %s", target, err, strings.TrimSpace(stderr.String()))
+	}
+	return out, nil
+}
+
+// Set stores raw secret bytes under the given target.
+func (b *Backend) Set(ctx context.Context, target string, secret []byte) error {
+	path, err := b.entryPath(target)
+	if err != nil {
+		return err
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	recipients, err := b.recipients(filepath.Dir(path))
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0o700); err != nil {
+		return fmt.Errorf("create entry directory: %w", err)
+	}
+	args := []string{"--quiet", "--batch", "--yes", "--encrypt", "--output", path + ".tmp"}
+	for _, r := range recipients {
+		args = append(args, "--recipient", r)
+	}
+	cmd := exec.CommandContext(ctx, b.gpg, args...)
+	cmd.Stdin = bytes.NewReader(secret)
+	if out, err := cmd.CombinedOutput(); err != nil {
+		_ = os.Remove(path + ".tmp")
+		return fmt.Errorf("gpg encrypt %s: %v: %s", target, err, strings.TrimSpace(string(out)))
+	}
+	return os.Rename(path+".tmp", path)
+}
+
+// Delete removes the secret for the given target.
+func (b *Backend) Delete(ctx context.Context, target string) error {
+	path, err := b.entryPath(target)
+	if err != nil {
+		return err
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if err := os.Remove(path); err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return &backend.ErrNotFound{Target: target}
+		}
+		return err
+	}
+	// Remove directories left empty, as "pass rm" does.
+	for dir := filepath.Dir(path); dir != b.dir; dir = filepath.Dir(dir) {
+		if os.Remove(dir) != nil {
+			break
+		}
+	}
+	return nil
+}
+
+// List returns all target strings that have the given prefix.
+func (b *Backend) List(ctx context.Context, prefix string) ([]string, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	targets := []string{}
+	err := filepath.WalkDir(b.dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() {
+			if path != b.dir && strings.HasPrefix(d.Name(), ".") {
+				return filepath.SkipDir // .git, .extensions
+			}
+			return nil
+		}
+		name, ok := strings.CutSuffix(path, ".gpg")
+		if !ok {
+			return nil
+		}
+		rel, err := filepath.Rel(b.dir, name)
+		if err != nil {
+			return err
+		}
+		if target := filepath.ToSlash(rel); strings.HasPrefix(target, prefix) {
+			targets = append(targets, target)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("walk password store: %w", err)
+	}
+	sort.Strings(targets)
+	return targets, nil
+}
+
+// entryPath maps a target to its .gpg file, refusing targets that would
+// escape the store.
+func (b *Backend) entryPath(target string) (string, error) {
+	clean := filepath.Clean(filepath.FromSlash(target))
+	if target == "" || filepath.IsAbs(clean) || clean == ".." || strings.HasPrefix(clean, ".."+string(filepath.Separator)) {
+		return "", fmt.Errorf("invalid pass entry name %q", target)
+	}
+	return filepath.Join(b.dir, clean) + ".gpg", nil
+}
+
+// recipients returns the GPG key IDs from the .gpg-id file closest to dir.
+func (b *Backend) recipients(dir string) ([]string, error) {
+	for {
+		data, err := os.ReadFile(filepath.Join(dir, gpgIDFile))
+		if err == nil {
+			var ids []string
+			sc := bufio.NewScanner(bytes.NewReader(data))
+			for sc.Scan() {
+				if id := strings.TrimSpace(sc.Text()); id != "" && !strings.HasPrefix(id, "#") {
+					ids = append(ids, id)
+				}
+			}
+			if len(ids) == 0 {
+				return nil, fmt.Errorf("%s lists no GPG recipients", filepath.Join(dir, gpgIDFile))
+			}
+			return ids, nil
+		}
+		if !errors.Is(err, fs.ErrNotExist) {
+			return nil, err
+		}
+		if dir == b.dir || !strings.HasPrefix(dir, b.dir) {
+			return nil, fmt.Errorf("no %s found in password store", gpgIDFile)
+		}
+		dir = filepath.Dir(dir)
+	}
+}
@@ -0,0 +1,101 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package pass
+
+import (
+	"errors"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+
+	"github.com/akihiro/wsl-secret-service/internal/backend"
+)
+
+// newTestBackend creates a password store encrypted to a throwaway,
+// passphrase-less GPG key in a private GNUPGHOME.
+func newTestBackend(t *testing.T) (*Backend, string) {
+	t.Helper()
+	if _, err := exec.LookPath("gpg"); err != nil {
+		t.Skip("gpg not installed")
+	}
+	home, err := os.MkdirTemp("", "gnupg") // short path: gpg-agent socket length limit
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		_ = exec.Command("gpgconf", "--homedir", home, "--kill", "gpg-agent").Run()
+		_ = os.RemoveAll(home)
+	})
+	t.Setenv("GNUPGHOME", home)
+	gen := exec.Command("gpg", "--batch", "--passphrase", "", "--quick-gen-key", "test@example.invalid", "default", "default", "never")
+	if out, err := gen.CombinedOutput(); err != nil {
+		t.Skipf("cannot generate gpg key: %v\n%s", err, out)
+	}
+
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, gpgIDFile), []byte("test@example.invalid\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	b, err := New(dir)
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+	return b, dir
+}
+
+func TestSetGetDelete(t *testing.T) {
+	b, dir := newTestBackend(t)
+
+	if err := b.Set(t.Context(), "wsl-ss/login/abc", []byte("hunter2\nuser: me\n")); err != nil {
+		t.Fatalf("Set: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "wsl-ss", "login", "abc.gpg")); err != nil {
+		t.Fatalf("entry file not created: %v", err)
+	}
+	got, err := b.Get(t.Context(), "wsl-ss/login/abc")
+	if err != nil {
+		t.Fatalf("Get: %v", err)
+	}
+	if string(got) != "hunter2\nuser: me\n" {
+		t.Errorf("Get = %q", got)
+	}
+
+	if err := b.Delete(t.Context(), "wsl-ss/login/abc"); err != nil {
+		t.Fatalf("Delete: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "wsl-ss")); !os.IsNotExist(err) {
+		t.Errorf("empty directories were not removed")
+	}
+	var nf *backend.ErrNotFound
+	if _, err := b.Get(t.Context(), "wsl-ss/login/abc"); !errors.As(err, &nf) {
+		t.Errorf("Get after Delete: err = %v, want ErrNotFound", err)
+	}
+}
+
+func TestListSkipsHiddenDirs(t *testing.T) {
+	b, dir := newTestBackend(t)
+	for _, f := range []string{"email/gmail.gpg", "bank.gpg", ".git/objects.gpg", "notes.txt"} {
+		p := filepath.Join(dir, filepath.FromSlash(f))
+		_ = os.MkdirAll(filepath.Dir(p), 0o700)
+		if err := os.WriteFile(p, nil, 0o600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	got, err := b.List(t.Context(), "")
+	if err != nil {
+		t.Fatalf("List: %v", err)
+	}
+	if len(got) != 2 || got[0] != "bank" || got[1] != "email/gmail" {
+		t.Errorf("List = %v, want [bank email/gmail]", got)
+	}
+}
+
+func TestEntryPathRejectsEscapes(t *testing.T) {
+	b := &Backend{dir: "/store"}
+	for _, target := range []string{"", "../x", "/etc/passwd", "a/../../x"} {
+		if _, err := b.entryPath(target); err == nil {
+			t.Errorf("entryPath(%q) succeeded", target)
+		}
+	}
+}
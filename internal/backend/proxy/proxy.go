@@ -0,0 +1,384 @@
+// SPDX-License-Identifier: Apache-2.0
+
+// Package proxy provides a backend that keeps secrets in another
+// org.freedesktop.secrets daemon, such as gnome-keyring, acting as its
+// client. The other daemon must be reachable on a bus of its own (e.g. a
+// forwarded session bus), since this daemon owns the name on the session
+// bus. With it, wsl-secret-service serves as a policy and audit shim in
+// front of an existing keyring, or items can be migrated one at a time.
+//
+// Each target becomes an item of the upstream's default collection with
+// the attributes TargetAttribute (the target) and ProxyAttribute, plus the
+// item attributes passed with backend.WithMetadata, so it remains usable
+// by the upstream's own clients. Secrets travel over a plain session.
+package proxy
+
+import (
+	"cmp"
+	"context"
+	"errors"
+	"fmt"
+	"maps"
+	"os"
+	"slices"
+	"strings"
+	"sync"
+
+	"github.com/godbus/dbus/v5"
+
+	"github.com/akihiro/wsl-secret-service/internal/backend"
+)
+
+// D-Bus names of the Secret Service API used upstream.
+const (
+	busName         = "org.freedesktop.secrets"
+	servicePath     = dbus.ObjectPath("/org/freedesktop/secrets")
+	serviceIface    = "org.freedesktop.Secret.Service"
+	collectionIface = "org.freedesktop.Secret.Collection"
+	itemIface       = "org.freedesktop.Secret.Item"
+	sessionIface    = "org.freedesktop.Secret.Session"
+	promptIface     = "org.freedesktop.Secret.Prompt"
+	noPrompt        = dbus.ObjectPath("/")
+	loginCollection = dbus.ObjectPath("/org/freedesktop/secrets/collection/login")
+)
+
+// Attributes marking the items created by the proxy.
+const (
+	TargetAttribute = "wsl-ss:target"
+	ProxyAttribute  = "wsl-ss:proxy"
+)
+
+func init() {
+	backend.Register("proxy", func(opts backend.Options) (backend.Backend, error) {
+		return Dial(opts.ProxyBus)
+	})
+}
+
+// secret is the Secret struct of the Secret Service API.
+type secret struct {
+	Session     dbus.ObjectPath
+	Parameters  []byte
+	Value       []byte
+	ContentType string
+}
+
+// Backend implements backend.Backend on an upstream Secret Service.
+type Backend struct {
+	conn *dbus.Conn
+
+	mu      sync.Mutex
+	session dbus.ObjectPath // "" until opened, and after it was lost
+}
+
+// Dial connects to the bus at address and returns a Backend for the
+// Secret Service there. The session bus of this daemon is refused, as its
+// org.freedesktop.secrets is the daemon itself.
+func Dial(address string) (*Backend, error) {
+	if address == "" {
+		return nil, errors.New("the proxy backend needs the address of the upstream bus (--proxy-bus)")
+	}
+	if address == os.Getenv("DBUS_SESSION_BUS_ADDRESS") || address == os.Getenv("DBUS_STARTER_ADDRESS") {
+		return nil, errors.New("the proxy backend cannot use this daemon's own session bus; give the bus of the upstream Secret Service")
+	}
+	conn, err := dbus.Connect(address)
+	if err != nil {
+		return nil, fmt.Errorf("connect to upstream bus %s: %w", address, err)
+	}
+	return New(conn), nil
+}
+
+// New returns a Backend for the Secret Service on conn.
+func New(conn *dbus.Conn) *Backend {
+	return &Backend{conn: conn}
+}
+
+// Close closes the upstream session and the connection.
+func (b *Backend) Close() error {
+	b.mu.Lock()
+	session := b.session
+	b.session = ""
+	b.mu.Unlock()
+	if session != "" {
+		b.conn.Object(busName, session).Call(sessionIface+".Close", 0)
+	}
+	return b.conn.Close()
+}
+
+// Check implements backend.Checker by opening a session upstream.
+func (b *Backend) Check(ctx context.Context) error {
+	_, err := b.openSession(ctx)
+	return err
+}
+
+// openSession returns the plain session with the upstream, opening one if
+// needed.
+func (b *Backend) openSession(ctx context.Context) (dbus.ObjectPath, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.session != "" {
+		return b.session, nil
+	}
+	var output dbus.Variant
+	var session dbus.ObjectPath
+	if err := b.service().CallWithContext(ctx, serviceIface+".OpenSession", 0, "plain", dbus.MakeVariant("")).Store(&output, &session); err != nil {
+		return "", unavailable("open session", err)
+	}
+	b.session = session
+	return session, nil
+}
+
+// dropSession forgets the session after an error that may mean the
+// upstream restarted, so that the next call opens a new one.
+func (b *Backend) dropSession(err error) {
+	var derr dbus.Error
+	if errors.As(err, &derr) && !strings.HasSuffix(derr.Name, ".NoSession") && !strings.HasSuffix(derr.Name, ".ServiceUnknown") {
+		return
+	}
+	b.mu.Lock()
+	b.session = ""
+	b.mu.Unlock()
+}
+
+func (b *Backend) service() dbus.BusObject {
+	return b.conn.Object(busName, servicePath)
+}
+
+func (b *Backend) object(path dbus.ObjectPath) dbus.BusObject {
+	return b.conn.Object(busName, path)
+}
+
+// find returns the upstream items of target, unlocking locked ones.
+func (b *Backend) find(ctx context.Context, target string) ([]dbus.ObjectPath, error) {
+	var unlocked, locked []dbus.ObjectPath
+	attrs := map[string]string{ProxyAttribute: "1", TargetAttribute: target}
+	if err := b.service().CallWithContext(ctx, serviceIface+".SearchItems", 0, attrs).Store(&unlocked, &locked); err != nil {
+		return nil, unavailable("search items", err)
+	}
+	if len(locked) > 0 {
+		if err := b.unlock(ctx, locked); err != nil {
+			return nil, err
+		}
+	}
+	return append(unlocked, locked...), nil
+}
+
+// unlock unlocks objects, running the upstream's prompt if it shows one.
+func (b *Backend) unlock(ctx context.Context, objects []dbus.ObjectPath) error {
+	var unlocked []dbus.ObjectPath
+	var prompt dbus.ObjectPath
+	if err := b.service().CallWithContext(ctx, serviceIface+".Unlock", 0, objects).Store(&unlocked, &prompt); err != nil {
+		return unavailable("unlock", err)
+	}
+	return b.runPrompt(ctx, prompt)
+}
+
+// runPrompt calls Prompt on path, unless it is noPrompt, and waits for its
+// Completed signal.
+func (b *Backend) runPrompt(ctx context.Context, path dbus.ObjectPath) error {
+	if path == noPrompt || path == "" {
+		return nil
+	}
+	opts := []dbus.MatchOption{dbus.WithMatchObjectPath(path), dbus.WithMatchInterface(promptIface), dbus.WithMatchMember("Completed")}
+	if err := b.conn.AddMatchSignalContext(ctx, opts...); err != nil {
+		return unavailable("watch prompt", err)
+	}
+	defer func() { _ = b.conn.RemoveMatchSignal(opts...) }()
+	signals := make(chan *dbus.Signal, 1)
+	b.conn.Signal(signals)
+	defer b.conn.RemoveSignal(signals)
+
+	if err := b.object(path).CallWithContext(ctx, promptIface+".Prompt", 0, "").Err; err != nil {
+		return unavailable("prompt", err)
+	}
+	for {
+		select {
+		case sig, ok := <-signals:
+			if !ok {
+				return &backend.ErrUnavailable{Err: errors.New("upstream connection closed while waiting for a prompt")}
+			}
+			if sig.Path != path || sig.Name != promptIface+".Completed" || len(sig.Body) == 0 {
+				continue
+			}
+			if dismissed, _ := sig.Body[0].(bool); dismissed {
+				return errors.New("the upstream Secret Service prompt was dismissed")
+			}
+			return nil
+		case <-ctx.Done():
+			b.object(path).Call(promptIface+".Dismiss", 0)
+			return ctx.Err()
+		}
+	}
+}
+
+// Get implements backend.Backend.
+func (b *Backend) Get(ctx context.Context, target string) ([]byte, error) {
+	items, err := b.find(ctx, target)
+	if err != nil {
+		return nil, err
+	}
+	if len(items) == 0 {
+		return nil, &backend.ErrNotFound{Target: target}
+	}
+	session, err := b.openSession(ctx)
+	if err != nil {
+		return nil, err
+	}
+	var s secret
+	if err := b.object(items[0]).CallWithContext(ctx, itemIface+".GetSecret", 0, session).Store(&s); err != nil {
+		b.dropSession(err)
+		return nil, unavailable("get secret", err)
+	}
+	return s.Value, nil
+}
+
+// Set implements backend.Backend. An existing item gets the new secret and,
+// if the context carries backend.Metadata, its label and attributes;
+// otherwise an item is created in the upstream's default collection.
+func (b *Backend) Set(ctx context.Context, target string, value []byte) error {
+	items, err := b.find(ctx, target)
+	if err != nil {
+		return err
+	}
+	session, err := b.openSession(ctx)
+	if err != nil {
+		return err
+	}
+	meta, hasMeta := backend.MetadataFrom(ctx)
+	label, attrs := cmp.Or(meta.Label, target), itemAttributes(target, meta)
+	s := secret{Session: session, Parameters: []byte{}, Value: value, ContentType: "text/plain"}
+
+	if len(items) > 0 {
+		item := b.object(items[0])
+		if err := item.CallWithContext(ctx, itemIface+".SetSecret", 0, s).Err; err != nil {
+			b.dropSession(err)
+			return unavailable("set secret", err)
+		}
+		if hasMeta {
+			if err := item.SetProperty(itemIface+".Label", dbus.MakeVariant(label)); err != nil {
+				return unavailable("set label", err)
+			}
+			if err := item.SetProperty(itemIface+".Attributes", dbus.MakeVariant(attrs)); err != nil {
+				return unavailable("set attributes", err)
+			}
+		}
+		return nil
+	}
+
+	col, err := b.defaultCollection(ctx)
+	if err != nil {
+		return err
+	}
+	props := map[string]dbus.Variant{
+		itemIface + ".Label":      dbus.MakeVariant(label),
+		itemIface + ".Attributes": dbus.MakeVariant(attrs),
+	}
+	var item, prompt dbus.ObjectPath
+	if err := b.object(col).CallWithContext(ctx, collectionIface+".CreateItem", 0, props, s, false).Store(&item, &prompt); err != nil {
+		b.dropSession(err)
+		return unavailable("create item", err)
+	}
+	return b.runPrompt(ctx, prompt)
+}
+
+// defaultCollection returns the upstream collection new items go to: the
+// "default" alias, else the login collection, unlocked.
+func (b *Backend) defaultCollection(ctx context.Context) (dbus.ObjectPath, error) {
+	var col dbus.ObjectPath
+	if err := b.service().CallWithContext(ctx, serviceIface+".ReadAlias", 0, "default").Store(&col); err != nil {
+		return "", unavailable("read default alias", err)
+	}
+	if col == noPrompt {
+		col = loginCollection
+	}
+	if locked, err := b.object(col).GetProperty(collectionIface + ".Locked"); err == nil && locked.Value() == true {
+		if err := b.unlock(ctx, []dbus.ObjectPath{col}); err != nil {
+			return "", err
+		}
+	}
+	return col, nil
+}
+
+// Delete implements backend.Backend.
+func (b *Backend) Delete(ctx context.Context, target string) error {
+	items, err := b.find(ctx, target)
+	if err != nil {
+		return err
+	}
+	if len(items) == 0 {
+		return &backend.ErrNotFound{Target: target}
+	}
+	for _, item := range items {
+		var prompt dbus.ObjectPath
+		if err := b.object(item).CallWithContext(ctx, itemIface+".Delete", 0).Store(&prompt); err != nil {
+			return unavailable("delete item", err)
+		}
+		if err := b.runPrompt(ctx, prompt); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// List implements backend.Backend.
+func (b *Backend) List(ctx context.Context, prefix string) ([]string, error) {
+	metas, err := b.ListMetadata(ctx, prefix)
+	if err != nil {
+		return nil, err
+	}
+	return slices.Sorted(maps.Keys(metas)), nil
+}
+
+// ListMetadata implements backend.MetadataLister with the labels and
+// attributes of the upstream items.
+func (b *Backend) ListMetadata(ctx context.Context, prefix string) (map[string]backend.Metadata, error) {
+	var unlocked, locked []dbus.ObjectPath
+	if err := b.service().CallWithContext(ctx, serviceIface+".SearchItems", 0, map[string]string{ProxyAttribute: "1"}).Store(&unlocked, &locked); err != nil {
+		return nil, unavailable("search items", err)
+	}
+	metas := make(map[string]backend.Metadata)
+	for _, item := range append(unlocked, locked...) {
+		v, err := b.object(item).GetProperty(itemIface + ".Attributes")
+		if err != nil {
+			return nil, unavailable("read attributes", err)
+		}
+		attrs, _ := v.Value().(map[string]string)
+		target := attrs[TargetAttribute]
+		if !strings.HasPrefix(target, prefix) {
+			continue
+		}
+		m := backend.Metadata{Attributes: make(map[string]string)}
+		for k, v := range attrs {
+			if k != TargetAttribute && k != ProxyAttribute {
+				m.Attributes[k] = v
+			}
+		}
+		if v, err := b.object(item).GetProperty(itemIface + ".Label"); err == nil {
+			m.Label, _ = v.Value().(string)
+		}
+		metas[target] = m
+	}
+	return metas, nil
+}
+
+// itemAttributes returns the upstream attributes of the item for target.
+func itemAttributes(target string, meta backend.Metadata) map[string]string {
+	attrs := maps.Clone(meta.Attributes)
+	if attrs == nil {
+		attrs = make(map[string]string)
+	}
+	attrs[TargetAttribute] = target
+	attrs[ProxyAttribute] = "1"
+	return attrs
+}
+
+// unavailable wraps a failed upstream call. Errors from the transport or
+// a missing upstream mean it cannot be reached; errors it returned itself
+// are passed on as they are.
+func unavailable(what string, err error) error {
+	err = fmt.Errorf("upstream Secret Service: %s: %w", what, err)
+	var derr dbus.Error
+	if !errors.As(err, &derr) || strings.HasSuffix(derr.Name, ".ServiceUnknown") || strings.HasSuffix(derr.Name, ".NoReply") {
+		return &backend.ErrUnavailable{Err: err}
+	}
+	return err
+}
@@ -0,0 +1,62 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package proxy
+
+import (
+	"errors"
+	"maps"
+	"testing"
+
+	"github.com/godbus/dbus/v5"
+
+	"github.com/akihiro/wsl-secret-service/internal/backend"
+)
+
+func TestItemAttributes(t *testing.T) {
+	meta := backend.Metadata{Label: "git", Attributes: map[string]string{"service": "github.com"}}
+	got := itemAttributes("wsl-ss/login/a", meta)
+	want := map[string]string{"service": "github.com", TargetAttribute: "wsl-ss/login/a", ProxyAttribute: "1"}
+	if !maps.Equal(got, want) {
+		t.Errorf("itemAttributes = %v, want %v", got, want)
+	}
+	if len(meta.Attributes) != 1 {
+		t.Errorf("itemAttributes changed the metadata: %v", meta.Attributes)
+	}
+	if got := itemAttributes("t", backend.Metadata{}); got[TargetAttribute] != "t" {
+		t.Errorf("itemAttributes without metadata = %v", got)
+	}
+}
+
+func TestUnavailable(t *testing.T) {
+	var ua *backend.ErrUnavailable
+	for _, tc := range []struct {
+		err         error
+		unavailable bool
+	}{
+		{errors.New("connection reset"), true},
+		{dbus.Error{Name: "org.freedesktop.DBus.Error.ServiceUnknown"}, true},
+		{dbus.Error{Name: "org.freedesktop.DBus.Error.NoReply"}, true},
+		{dbus.Error{Name: "org.freedesktop.Secret.Error.IsLocked"}, false},
+	} {
+		err := unavailable("get secret", tc.err)
+		if got := errors.As(err, &ua); got != tc.unavailable {
+			t.Errorf("unavailable(%v) is ErrUnavailable = %v, want %v", tc.err, got, tc.unavailable)
+		}
+		if want, ok := tc.err.(dbus.Error); ok {
+			var derr dbus.Error
+			if !errors.As(err, &derr) || derr.Name != want.Name {
+				t.Errorf("unavailable(%v) = %v, does not wrap it", tc.err, err)
+			}
+		}
+	}
+}
+
+func TestDialRejectsOwnBus(t *testing.T) {
+	t.Setenv("DBUS_SESSION_BUS_ADDRESS", "unix:path=/run/user/1000/bus")
+	if _, err := Dial("unix:path=/run/user/1000/bus"); err == nil {
+		t.Error("Dial of the daemon's own session bus succeeded")
+	}
+	if _, err := Dial(""); err == nil {
+		t.Error("Dial without an address succeeded")
+	}
+}
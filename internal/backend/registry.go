@@ -0,0 +1,108 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package backend
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Options carries the daemon settings a backend may need when it is opened.
+// Backends ignore the fields that do not apply to them.
+type Options struct {
+	// ConfigDir is the daemon's configuration directory; file-based
+	// backends keep their data here.
+	ConfigDir string
+
+	// HelperPath is the path to wincred-helper.exe ("" = auto-discover).
+	HelperPath string
+
+	// BootstrapHelper copies an auto-discovered helper outside the Windows
+	// file system to %LOCALAPPDATA% and runs the copy (see
+	// wincred.Bootstrap).
+	BootstrapHelper bool
+
+	// ProxyBus is the D-Bus address of the bus on which the proxy backend
+	// finds the upstream Secret Service.
+	ProxyBus string
+
+	// Persistence returns the scope in which the secrets of collection are
+	// persisted, an ipc.Persist* constant ("" or nil = the backend's
+	// default). Only the wincred backend honours it.
+	Persistence func(collection string) string
+
+	// DomainCredentials exposes the Windows domain credentials of the user
+	// as items (wincred backend only).
+	DomainCredentials bool
+
+	// PersistentHelper keeps one helper process running, stopped after
+	// HelperIdleTimeout without requests.
+	PersistentHelper  bool
+	HelperIdleTimeout time.Duration
+
+	// HelperRateLimit caps helper calls per second (0 = unlimited); calls
+	// over the limit wait up to HelperQueueTimeout for their turn.
+	HelperRateLimit    int
+	HelperQueueTimeout time.Duration
+
+	// HelperVsockPort, if not 0, is the Hyper-V socket port of a resident
+	// helper to use instead of starting helpers through WSL interop;
+	// HelperVsockTokenFile is where that helper writes its token.
+	HelperVsockPort      uint32
+	HelperVsockTokenFile string
+
+	// RecordHelper, if set, is a file the wincred backend appends its
+	// helper traffic to, with secrets left out, for reproducing problems.
+	RecordHelper string
+
+	// SecretAlloc, if set, provides the buffers secrets are decoded into;
+	// SecretRelease discards such a buffer on error.
+	SecretAlloc   func(n int) ([]byte, error)
+	SecretRelease func([]byte)
+}
+
+// Factory opens a backend.
+type Factory func(opts Options) (Backend, error)
+
+var (
+	registryMu sync.RWMutex
+	registry   = make(map[string]Factory)
+)
+
+// Register makes a backend available under name. It is intended to be
+// called from the init function of the package implementing the backend
+// and panics if name is registered twice.
+func Register(name string, f Factory) {
+	registryMu.Lock()
+	defer registryMu.Unlock()
+	if _, dup := registry[name]; dup {
+		panic("backend: Register called twice for " + name)
+	}
+	registry[name] = f
+}
+
+// Open opens the backend registered under name.
+func Open(name string, opts Options) (Backend, error) {
+	registryMu.RLock()
+	f, ok := registry[name]
+	registryMu.RUnlock()
+	if !ok {
+		return nil, fmt.Errorf("unknown backend %q (available: %s)", name, strings.Join(Names(), ", "))
+	}
+	return f(opts)
+}
+
+// Names returns the names of all registered backends, sorted.
+func Names() []string {
+	registryMu.RLock()
+	defer registryMu.RUnlock()
+	names := make([]string, 0, len(registry))
+	for name := range registry {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
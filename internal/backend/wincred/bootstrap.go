@@ -0,0 +1,193 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package wincred
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+// BootstrapFile is the file in the config directory recording where
+// Bootstrap copied the helper.
+const BootstrapFile = "helper.json"
+
+// bootstrapState is the content of BootstrapFile.
+type bootstrapState struct {
+	Source       string `json:"source"`         // the helper that was copied
+	SHA256       string `json:"sha256"`         // its hash, to notice a new release
+	Path         string `json:"path"`           // the copy, as a WSL path
+	WindowsPath  string `json:"windows_path"`   // the copy, as a Windows path
+	LocalAppData string `json:"local_app_data"` // %LOCALAPPDATA% as a WSL path
+}
+
+// Bootstrap returns a copy of the helper src on the Windows file system,
+// in %LOCALAPPDATA%\wsl-secret-service: starting an .exe that lives on the
+// Linux file system goes through the \\wsl$ share, which is slow and fails
+// under some interop configurations. The copy is recorded in BootstrapFile
+// in configDir, with the hash of src and the resolved Windows path, and made
+// again when src changes, e.g. after an upgrade. A src already on a Windows
+// drive is returned as is.
+func Bootstrap(src, configDir string) (string, error) {
+	return bootstrap(src, configDir, LocalAppData, windowsPath)
+}
+
+func bootstrap(src, configDir string, localAppData func() (string, error), winPath func(string) (string, error)) (string, error) {
+	if onWindowsDrive(src) {
+		return src, nil
+	}
+	sum, err := fileSHA256(src)
+	if err != nil {
+		return "", err
+	}
+	statePath := filepath.Join(configDir, BootstrapFile)
+	var state bootstrapState
+	if data, err := os.ReadFile(statePath); err == nil {
+		_ = json.Unmarshal(data, &state)
+	}
+	if state.SHA256 == sum && state.Path != "" {
+		if got, err := fileSHA256(state.Path); err == nil && got == sum {
+			return state.Path, nil
+		}
+	}
+
+	// Running cmd.exe takes a while, so %LOCALAPPDATA% is remembered.
+	if state.LocalAppData == "" {
+		if state.LocalAppData, err = localAppData(); err != nil {
+			return "", err
+		}
+	}
+	dir := filepath.Join(state.LocalAppData, "wsl-secret-service")
+	dst := filepath.Join(dir, "wincred-helper.exe")
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return "", fmt.Errorf("create %s: %w", dir, err)
+	}
+	// Replace the copy in one step; Windows refuses while it is running.
+	tmp := dst + ".new"
+	if err := copyExecutable(src, tmp); err != nil {
+		_ = os.Remove(tmp)
+		return "", fmt.Errorf("copy helper to %s: %w", dir, err)
+	}
+	if err := os.Rename(tmp, dst); err != nil {
+		_ = os.Remove(tmp)
+		return "", fmt.Errorf("replace %s (is it still running?): %w", dst, err)
+	}
+	state.Source, state.SHA256, state.Path = src, sum, dst
+	if state.WindowsPath, err = winPath(dst); err != nil {
+		logger.Warn("could not resolve the Windows path of the helper", "path", dst, "err", err)
+	}
+	data, err := json.MarshalIndent(state, "", "  ")
+	if err != nil {
+		return "", err
+	}
+	if err := os.WriteFile(statePath, append(data, '\n'), 0o600); err != nil {
+		return "", fmt.Errorf("record helper copy: %w", err)
+	}
+	logger.Info("copied wincred-helper to the Windows file system", "from", src, "to", dst, "windows_path", state.WindowsPath)
+	return dst, nil
+}
+
+// onWindowsDrive reports whether path is on a Windows drive mounted by WSL
+// (DrvFs), as listed in /proc/self/mounts.
+func onWindowsDrive(path string) bool {
+	f, err := os.Open("/proc/self/mounts")
+	if err != nil {
+		return false
+	}
+	defer f.Close()
+	for _, mnt := range drvfsMounts(f) {
+		if strings.HasPrefix(path, strings.TrimSuffix(mnt, "/")+"/") {
+			return true
+		}
+	}
+	return false
+}
+
+// drvfsMounts returns the mount points of Windows drives in a mounts table:
+// 9p mounts of DrvFs on WSL 2 and drvfs mounts on WSL 1.
+func drvfsMounts(r io.Reader) []string {
+	var mounts []string
+	sc := bufio.NewScanner(r)
+	for sc.Scan() {
+		fields := strings.Fields(sc.Text())
+		if len(fields) < 4 {
+			continue
+		}
+		fstype, opts := fields[2], fields[3]
+		if fstype == "drvfs" || fstype == "9p" && strings.Contains(opts, "aname=drvfs") {
+			// Spaces in mount points are escaped as \040.
+			mounts = append(mounts, strings.ReplaceAll(fields[1], `\040`, " "))
+		}
+	}
+	return mounts
+}
+
+// LocalAppData returns %LOCALAPPDATA% of the Windows user as a WSL path,
+// using cmd.exe and wslpath through WSL interop.
+func LocalAppData() (string, error) {
+	cmd := exec.Command("cmd.exe", "/c", "echo %LOCALAPPDATA%")
+	// cmd.exe complains when started in a directory on the Linux file system.
+	if _, err := os.Stat("/mnt/c"); err == nil {
+		cmd.Dir = "/mnt/c"
+	}
+	out, err := cmd.Output()
+	if err != nil {
+		return "", fmt.Errorf("query %%LOCALAPPDATA%% via cmd.exe: %w", err)
+	}
+	winPath := strings.TrimSpace(string(out))
+	if winPath == "" || strings.Contains(winPath, "%") {
+		return "", errors.New("%LOCALAPPDATA% is not set")
+	}
+	out, err = exec.Command("wslpath", "-u", winPath).Output()
+	if err != nil {
+		return "", fmt.Errorf("convert %s with wslpath: %w", winPath, err)
+	}
+	return strings.TrimSpace(string(out)), nil
+}
+
+// windowsPath converts a WSL path on a Windows drive with wslpath.
+func windowsPath(path string) (string, error) {
+	out, err := exec.Command("wslpath", "-w", path).Output()
+	if err != nil {
+		return "", fmt.Errorf("convert %s with wslpath: %w", path, err)
+	}
+	return strings.TrimSpace(string(out)), nil
+}
+
+func fileSHA256(path string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+func copyExecutable(src, dst string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	out, err := os.OpenFile(dst, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0o755)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		return err
+	}
+	return out.Close()
+}
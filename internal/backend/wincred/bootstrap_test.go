@@ -0,0 +1,85 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package wincred
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestBootstrap(t *testing.T) {
+	src := filepath.Join(t.TempDir(), "wincred-helper.exe")
+	if err := os.WriteFile(src, []byte("v1"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	configDir, appData := t.TempDir(), t.TempDir()
+	queries := 0
+	localAppData := func() (string, error) {
+		queries++
+		return appData, nil
+	}
+	winPath := func(p string) (string, error) {
+		return `C:\Users\u\AppData\Local\wsl-secret-service\wincred-helper.exe`, nil
+	}
+	want := filepath.Join(appData, "wsl-secret-service", "wincred-helper.exe")
+
+	check := func(content string) {
+		t.Helper()
+		got, err := bootstrap(src, configDir, localAppData, winPath)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got != want {
+			t.Fatalf("bootstrap = %q, want %q", got, want)
+		}
+		data, err := os.ReadFile(got)
+		if err != nil || string(data) != content {
+			t.Fatalf("copy holds %q (%v), want %q", data, err, content)
+		}
+	}
+	check("v1")
+	state, err := os.ReadFile(filepath.Join(configDir, BootstrapFile))
+	if err != nil || !strings.Contains(string(state), `AppData\\Local`) {
+		t.Errorf("%s = %s (%v), want the Windows path recorded", BootstrapFile, state, err)
+	}
+
+	// An unchanged helper is not copied again.
+	if err := os.Chmod(filepath.Dir(want), 0o555); err != nil {
+		t.Fatal(err)
+	}
+	check("v1")
+	if err := os.Chmod(filepath.Dir(want), 0o755); err != nil {
+		t.Fatal(err)
+	}
+
+	// A new release replaces the copy; %LOCALAPPDATA% is remembered.
+	if err := os.WriteFile(src, []byte("v2"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	check("v2")
+	if queries != 1 {
+		t.Errorf("%%LOCALAPPDATA%% queried %d times, want 1", queries)
+	}
+
+	// A missing %LOCALAPPDATA% fails only when a copy is needed.
+	failing := func() (string, error) { return "", errors.New("no interop") }
+	if _, err := bootstrap(src, t.TempDir(), failing, winPath); err == nil {
+		t.Error("bootstrap without %LOCALAPPDATA% succeeded")
+	}
+}
+
+func TestDrvfsMounts(t *testing.T) {
+	mounts := `none /mnt/wsl tmpfs rw,relatime 0 0
+drvfs /mnt/c 9p rw,noatime,dirsync,aname=drvfs;path=C:\;uid=1000;gid=1000,trans=virtio 0 0
+/dev/sdc / ext4 rw,relatime 0 0
+D:\134 /mnt/my\040drive drvfs rw,noatime 0 0
+`
+	got := drvfsMounts(strings.NewReader(mounts))
+	if want := []string{"/mnt/c", "/mnt/my drive"}; !slices.Equal(got, want) {
+		t.Errorf("drvfsMounts = %q, want %q", got, want)
+	}
+}
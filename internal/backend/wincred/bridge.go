@@ -2,44 +2,260 @@
 
 // Package wincred provides a backend that stores secrets in the Windows
 // Credential Manager by invoking a companion wincred-helper.exe via WSL2
-// interop. Communication uses newline-delimited JSON over stdin/stdout.
+// interop. Requests go over stdin/stdout as length-prefixed frames, or as
+// newline-delimited JSON to helpers older than protocol version 3, either
+// with one helper process per call (or per batch of frames) or, with
+// WithPersistentHelper, a single long-lived helper answering many requests.
+// With WithVsock, the Bridge instead connects to a resident helper over a
+// Hyper-V socket and does not use interop at all.
 package wincred
 
 import (
+	"bufio"
 	"bytes"
+	"context"
 	"encoding/base64"
 	"encoding/json"
 	"errors"
 	"fmt"
+	"io"
+	"maps"
 	"os"
 	"os/exec"
 	"path/filepath"
+	"slices"
 	"strings"
+	"sync"
+	"time"
+	"unicode/utf16"
 
 	"github.com/akihiro/wsl-secret-service/internal/backend"
 	"github.com/akihiro/wsl-secret-service/internal/ipc"
+	"github.com/akihiro/wsl-secret-service/internal/logging"
+	"github.com/akihiro/wsl-secret-service/internal/version"
 )
 
+var logger = logging.For(logging.Backend).With("backend", "wincred")
+
+func init() {
+	backend.Register("wincred", func(opts backend.Options) (backend.Backend, error) {
+		b, err := Open(opts)
+		if err != nil {
+			return nil, err
+		}
+		if opts.DomainCredentials {
+			return DomainBridge{b}, nil
+		}
+		return b, nil
+	})
+}
+
+// notFoundTTL is how long Open remembers that a target does not exist.
+// libsecret clients often look up the same missing credential several times
+// in a row; each lookup would otherwise start a helper.
+const notFoundTTL = 5 * time.Second
+
 // Bridge implements backend.Backend by calling wincred-helper.exe.
 type Bridge struct {
-	helperPath string
+	helperPath    string
+	secretAlloc   func(n int) ([]byte, error)
+	secretRelease func([]byte)
+	persistent    *persistentHelper
+	vsock         *vsockHelper
+	missing       *notFoundCache
+	limiter       *callLimiter
+	retryDelay    time.Duration // wait before the first retry of a transient error
+	persistence   func(collection string) string
+	watchInterval time.Duration
+	recent        recentCalls
+	recordPath    string
+	recorder      *recorder
+	gets          flights
+
+	helloMu       sync.Mutex
+	protocol      int    // the helper's protocol version once it is compatible
+	helperVersion string // the helper's release version, if it reported one
+}
+
+// ProtocolError reports a helper speaking a different IPC protocol
+// version than the daemon, typically an old wincred-helper.exe left behind
+// by an upgrade.
+type ProtocolError struct {
+	Path          string // the helper executable, or its vsock port
+	Version       int    // the helper's protocol version
+	HelperVersion string // the helper's release version, if known
+}
+
+func (e *ProtocolError) Error() string {
+	helper := "wincred-helper"
+	if e.HelperVersion != "" {
+		helper += " " + e.HelperVersion
+	}
+	if e.Version > ipc.ProtocolVersion {
+		return fmt.Sprintf("%s at %s speaks protocol v%d, daemon supports up to v%d: upgrade wsl-secret-service",
+			helper, e.Path, e.Version, ipc.ProtocolVersion)
+	}
+	return fmt.Sprintf("%s at %s speaks protocol v%d, daemon needs v%d or later: reinstall it with 'wsl-secret-service install'",
+		helper, e.Path, e.Version, ipc.MinProtocolVersion)
+}
+
+// Option configures optional Bridge behaviour.
+type Option func(*Bridge)
+
+// WithSecretAllocator makes Get decode secrets straight into buffers obtained
+// from alloc (e.g. memprotect.LockedAlloc) instead of the Go heap. The caller
+// owns the returned buffers and is responsible for releasing them; release is
+// only used by the Bridge to discard a buffer when decoding fails.
+func WithSecretAllocator(alloc func(n int) ([]byte, error), release func([]byte)) Option {
+	return func(b *Bridge) {
+		b.secretAlloc = alloc
+		b.secretRelease = release
+	}
+}
+
+// WithPersistentHelper keeps one helper process running in --serve mode and
+// sends all requests to it instead of spawning a helper per call. The helper
+// is restarted automatically if it dies and stopped after idle without
+// requests (idle <= 0 keeps it running until Close).
+func WithPersistentHelper(idle time.Duration) Option {
+	return func(b *Bridge) {
+		// New sets the path once the helper has been found.
+		b.persistent = newPersistentHelper("", idle)
+	}
+}
+
+// WithVsock sends all requests to a resident helper started on Windows
+// with "wincred-helper.exe --listen-vsock port", over a Hyper-V socket.
+// This avoids the interop process start on every call and works with WSL
+// interop disabled. Each connection presents the token the helper wrote
+// to tokenFile. No helper executable is needed on the Linux side, and
+// WithPersistentHelper has no effect.
+func WithVsock(port uint32, tokenFile string) Option {
+	return func(b *Bridge) {
+		b.vsock = newVsockHelper(port, tokenFile)
+	}
+}
+
+// WithNotFoundCache makes Get report targets found missing within the last
+// ttl as not found without asking the helper. Set and Delete through the
+// Bridge keep the cache current; credentials created outside the daemon
+// become visible once their entry expires.
+func WithNotFoundCache(ttl time.Duration) Option {
+	return func(b *Bridge) {
+		b.missing = &notFoundCache{ttl: ttl, expires: make(map[string]time.Time)}
+	}
+}
+
+// WithRateLimit allows at most perSecond helper calls per second on
+// average, with bursts of up to one second's worth, so that a client
+// hammering the service cannot start hundreds of helper processes per
+// second. Excess calls queue for up to timeout and then fail.
+// perSecond <= 0 disables the limit.
+func WithRateLimit(perSecond int, timeout time.Duration) Option {
+	return func(b *Bridge) {
+		if perSecond > 0 {
+			b.limiter = newCallLimiter(perSecond, perSecond, timeout)
+		}
+	}
+}
+
+// WithPersistence stores the secrets of each collection in the persistence
+// scope scope returns for it (an ipc.Persist* constant, "" = local
+// machine). Secrets stored without backend.Metadata, such as those of
+// SetMany, get scope("").
+func WithPersistence(scope func(collection string) string) Option {
+	return func(b *Bridge) {
+		b.persistence = scope
+	}
 }
 
 // New creates a Bridge that uses the wincred-helper.exe at helperPath.
-// If helperPath is empty, the helper is discovered automatically (see findHelper).
-func New(helperPath string) (*Bridge, error) {
-	if helperPath == "" {
-		discovered, err := findHelper()
+// If helperPath is empty, the helper is discovered automatically (see
+// FindHelper), unless WithVsock is given.
+func New(helperPath string, opts ...Option) (*Bridge, error) {
+	b := &Bridge{helperPath: helperPath, retryDelay: retryBaseDelay, watchInterval: defaultWatchInterval}
+	for _, opt := range opts {
+		opt(b)
+	}
+	if b.vsock != nil && b.vsock.tokenFile == "" {
+		return nil, errors.New("the vsock transport needs the token file of the resident helper")
+	}
+	if b.helperPath == "" && b.vsock == nil {
+		discovered, err := FindHelper()
 		if err != nil {
 			return nil, fmt.Errorf("wincred-helper not found: %w", err)
 		}
-		helperPath = discovered
+		b.helperPath = discovered
+	}
+	if b.persistent != nil {
+		b.persistent.path = b.helperPath
+	}
+	if b.recordPath != "" {
+		r, err := openRecorder(b.recordPath)
+		if err != nil {
+			return nil, fmt.Errorf("open helper recording: %w", err)
+		}
+		b.recorder = r
+	}
+	return b, nil
+}
+
+// Open creates a Bridge configured from the generic backend options.
+func Open(opts backend.Options) (*Bridge, error) {
+	bridgeOpts := []Option{WithNotFoundCache(notFoundTTL)}
+	if opts.SecretAlloc != nil {
+		bridgeOpts = append(bridgeOpts, WithSecretAllocator(opts.SecretAlloc, opts.SecretRelease))
+	}
+	if opts.HelperRateLimit > 0 {
+		bridgeOpts = append(bridgeOpts, WithRateLimit(opts.HelperRateLimit, opts.HelperQueueTimeout))
+	}
+	if opts.PersistentHelper {
+		bridgeOpts = append(bridgeOpts, WithPersistentHelper(opts.HelperIdleTimeout))
+	}
+	if opts.HelperVsockPort != 0 {
+		bridgeOpts = append(bridgeOpts, WithVsock(opts.HelperVsockPort, opts.HelperVsockTokenFile))
+	}
+	if opts.Persistence != nil {
+		bridgeOpts = append(bridgeOpts, WithPersistence(opts.Persistence))
+	}
+	if opts.RecordHelper != "" {
+		bridgeOpts = append(bridgeOpts, WithRecording(opts.RecordHelper))
+	}
+	helperPath := opts.HelperPath
+	if helperPath == "" && opts.HelperVsockPort == 0 && opts.BootstrapHelper {
+		if found, err := FindHelper(); err == nil {
+			if helperPath, err = Bootstrap(found, opts.ConfigDir); err != nil {
+				logger.Warn("could not copy wincred-helper to the Windows file system; running it in place", "helper", found, "err", err)
+				helperPath = found
+			}
+		}
+	}
+	return New(helperPath, bridgeOpts...)
+}
+
+// Close stops the persistent helper process, if one is running, and closes
+// the connection to a resident helper and the recording.
+func (b *Bridge) Close() error {
+	if b.persistent != nil {
+		b.persistent.close()
+	}
+	if b.vsock != nil {
+		b.vsock.close()
 	}
-	return &Bridge{helperPath: helperPath}, nil
+	b.recorder.close()
+	return nil
 }
 
-// findHelper searches for wincred-helper.exe in standard locations.
-func findHelper() (string, error) {
+// Flush implements backend.Flusher by stopping the persistent helper, so
+// that the next request starts a fresh one (or reconnects to the resident
+// helper), and forgetting missing targets.
+func (b *Bridge) Flush() error {
+	b.missing.clear()
+	return b.Close()
+}
+
+// FindHelper searches for wincred-helper.exe in standard locations.
+func FindHelper() (string, error) {
 	var candidates []string
 
 	// 1. Same directory as the running daemon binary.
@@ -71,94 +287,668 @@ func findHelper() (string, error) {
 		"place it alongside wsl-secret-service or in ~/.local/share/wsl-secret-service/")
 }
 
-// call invokes wincred-helper.exe with the given request and returns the response.
-func (b *Bridge) call(req ipc.Request) (*ipc.Response, error) {
-	reqData, err := json.Marshal(req)
+// reply is a helper response with its secret as raw bytes: the "get"
+// result or the output of "protect"/"unprotect".
+type reply struct {
+	ipc.Response
+	data   []byte
+	locked bool // data is from secretAlloc
+}
+
+// release wipes and frees the secret of r.
+func (b *Bridge) release(r *reply) {
+	if r.locked {
+		b.secretRelease(r.data)
+	} else {
+		clear(r.data)
+	}
+}
+
+// call invokes wincred-helper.exe with req, sending data as its secret,
+// and returns the response. The helper's protocol version is checked on
+// first use.
+func (b *Bridge) call(ctx context.Context, req ipc.Request, data []byte) (*reply, error) {
+	replies, err := b.callMany(ctx, []ipc.Request{req}, [][]byte{data})
 	if err != nil {
-		return nil, fmt.Errorf("marshal request: %w", err)
+		return nil, err
 	}
-	reqData = append(reqData, '\n')
+	return replies[0], nil
+}
 
-	cmd := exec.Command(b.helperPath)
+// callMany sends reqs, with data[i] (if data is not nil) as the secret of
+// reqs[i], and returns the responses in order. A helper speaking frames
+// answers all of them from one process; older helpers get one exchange per
+// request. Requests failing with a transient Windows error are retried (see
+// retryTransient). If the helper cannot be used at all, the error is a
+// *backend.ErrUnavailable.
+func (b *Bridge) callMany(ctx context.Context, reqs []ipc.Request, data [][]byte) ([]*reply, error) {
+	v, err := b.hello(ctx)
+	if err != nil {
+		return nil, &backend.ErrUnavailable{Err: err}
+	}
+	if data == nil {
+		data = make([][]byte, len(reqs))
+	}
+	for i := range reqs {
+		if reqs[i].Persist != "" && reqs[i].Persist != ipc.PersistLocalMachine && v < ipc.PersistProtocolVersion {
+			return nil, fmt.Errorf("wincred-helper at %s speaks protocol v%d and cannot store %s credentials: reinstall it with 'wsl-secret-service install'",
+				b.location(), v, reqs[i].Persist)
+		}
+		if reqs[i].Type != "" && v < ipc.CredTypeProtocolVersion {
+			return nil, fmt.Errorf("wincred-helper at %s speaks protocol v%d and cannot reach %s credentials: reinstall it with 'wsl-secret-service install'",
+				b.location(), v, reqs[i].Type)
+		}
+		reqs[i].Version = v
+	}
+	framed := v >= ipc.FramedProtocolVersion
+	replies, err := b.send(ctx, reqs, data, framed)
+	if err != nil {
+		return nil, &backend.ErrUnavailable{Err: err}
+	}
+	b.retryTransient(ctx, reqs, data, framed, replies)
+	return replies, nil
+}
+
+// send exchanges reqs with the helper once: in one exchange if framed,
+// otherwise in one exchange per request.
+func (b *Bridge) send(ctx context.Context, reqs []ipc.Request, data [][]byte, framed bool) ([]*reply, error) {
+	if framed || len(reqs) == 1 {
+		return b.exchange(ctx, reqs, data, framed)
+	}
+	replies := make([]*reply, 0, len(reqs))
+	for i := range reqs {
+		r, err := b.exchange(ctx, reqs[i:i+1], data[i:i+1], false)
+		if err != nil {
+			for _, r := range replies {
+				b.release(r)
+			}
+			return nil, err
+		}
+		replies = append(replies, r[0])
+	}
+	return replies, nil
+}
+
+// hello asks the helper for its protocol version until it has answered
+// with a version between ipc.MinProtocolVersion and ipc.ProtocolVersion,
+// and returns that version. A mismatch is not remembered, so replacing the
+// helper takes effect without restarting the daemon.
+func (b *Bridge) hello(ctx context.Context) (int, error) {
+	b.helloMu.Lock()
+	defer b.helloMu.Unlock()
+	if b.protocol != 0 {
+		return b.protocol, nil
+	}
+	// Every helper understands JSON lines, so hello never uses frames.
+	replies, err := b.exchange(ctx, []ipc.Request{{Action: "hello", Version: ipc.ProtocolVersion, DaemonVersion: version.String()}}, [][]byte{nil}, false)
+	if err != nil {
+		return 0, err
+	}
+	resp := replies[0]
+	v := resp.Version
+	if !resp.OK {
+		if !strings.Contains(resp.Error, "unknown action") {
+			return 0, fmt.Errorf("wincred hello: %s", resp.Error)
+		}
+		v = 1
+	}
+	if v < ipc.MinProtocolVersion || v > ipc.ProtocolVersion {
+		err := &ProtocolError{Path: b.location(), Version: v, HelperVersion: resp.HelperVersion}
+		logger.Error("incompatible wincred-helper", "err", err)
+		return 0, err
+	}
+	if v < ipc.FramedProtocolVersion {
+		logger.Info("wincred-helper is outdated, falling back to JSON lines",
+			"protocol", v, "helper_version", resp.HelperVersion, "path", b.location())
+	}
+	if daemon := version.String(); resp.HelperVersion != "" && resp.HelperVersion != daemon && daemon != "dev" && resp.HelperVersion != "dev" {
+		logger.Warn("wincred-helper is from a different release than the daemon; reinstall it with 'wsl-secret-service install'",
+			"helper_version", resp.HelperVersion, "daemon_version", daemon, "path", b.location())
+	}
+	logger.Debug("wincred-helper ready", "protocol", v, "helper_version", resp.HelperVersion)
+	b.protocol = v
+	b.helperVersion = resp.HelperVersion
+	return v, nil
+}
+
+// exchange sends reqs to one helper process, as frames or as JSON lines,
+// and reads their responses. If ctx is done first, the helper is killed (or
+// the connection to the resident helper dropped) and ctx's error returned.
+func (b *Bridge) exchange(ctx context.Context, reqs []ipc.Request, data [][]byte, framed bool) ([]*reply, error) {
+	var buf bytes.Buffer
+	defer func() { clear(buf.Bytes()) }()
+	for i, req := range reqs {
+		if framed {
+			if err := ipc.WriteFrame(&buf, req, data[i]); err != nil {
+				return nil, err
+			}
+			continue
+		}
+		if data[i] != nil {
+			req.Secret = base64.StdEncoding.EncodeToString(data[i])
+		}
+		line, err := json.Marshal(req)
+		if err != nil {
+			return nil, fmt.Errorf("marshal request: %w", err)
+		}
+		buf.Write(line)
+		buf.WriteByte('\n')
+		clear(line)
+	}
+
+	if err := b.limiter.wait(ctx); err != nil {
+		return nil, err
+	}
+	start := time.Now()
+	var replies []*reply
+	read := func(r *bufio.Reader) error {
+		var err error
+		replies, err = b.readReplies(r, reqs, framed)
+		return err
+	}
+	var err error
+	switch {
+	case b.vsock != nil:
+		err = b.vsock.exchange(ctx, reqs, buf.Bytes(), read)
+	case b.persistent != nil:
+		err = b.persistent.exchange(ctx, reqs, buf.Bytes(), read)
+	default:
+		err = b.runOnce(ctx, buf.Bytes(), read)
+	}
+	if err != nil && ctx.Err() != nil {
+		err = fmt.Errorf("wincred-helper %s: %w", reqs[0].Action, context.Cause(ctx))
+	}
+	failed := 0
+	for _, r := range replies {
+		if !r.OK {
+			failed++
+		}
+	}
+	logger.Debug("helper call", "action", reqs[0].Action, "target", reqs[0].Target, "requests", len(reqs), "framed", framed, "duration", time.Since(start), "failed", failed, "err", err)
+	b.recent.add(start, reqs[0].Action, len(reqs), err)
+	b.recorder.record(start, reqs, data, replies, err)
+	if err != nil {
+		return nil, err
+	}
+	return replies, nil
+}
+
+// location describes where the helper is, for messages.
+func (b *Bridge) location() string {
+	if b.vsock != nil {
+		return fmt.Sprintf("vsock port %d", b.vsock.port)
+	}
+	return b.helperPath
+}
+
+// readReplies reads one response per request from r.
+func (b *Bridge) readReplies(r *bufio.Reader, reqs []ipc.Request, framed bool) ([]*reply, error) {
+	replies := make([]*reply, 0, len(reqs))
+	for _, req := range reqs {
+		rep, err := b.readReply(r, req.Action, framed)
+		if err != nil {
+			for _, r := range replies {
+				b.release(r)
+			}
+			return nil, err
+		}
+		replies = append(replies, rep)
+	}
+	return replies, nil
+}
+
+// readReply reads one response. The secret returned by "get" goes into a
+// buffer from b.secretAlloc, if set, without a plaintext copy on the Go heap.
+func (b *Bridge) readReply(r *bufio.Reader, action string, framed bool) (*reply, error) {
+	rep := &reply{locked: action == "get" && b.secretAlloc != nil}
+	var alloc func(int) ([]byte, error)
+	if rep.locked {
+		alloc = b.secretAlloc
+	}
+	if framed {
+		data, err := ipc.ReadFrame(r, &rep.Response, alloc)
+		if err != nil {
+			return nil, fmt.Errorf("read response: %w", err)
+		}
+		rep.data = data
+		return rep, nil
+	}
+	line, err := r.ReadBytes('\n')
+	if err != nil && (!errors.Is(err, io.EOF) || len(line) == 0) {
+		return nil, fmt.Errorf("read response: %w", err)
+	}
+	if err := json.Unmarshal(bytes.TrimSpace(line), &rep.Response); err != nil {
+		return nil, fmt.Errorf("decode response: %w", err)
+	}
+	if rep.Secret == "" {
+		return rep, nil
+	}
+	if rep.locked {
+		rep.data, err = b.decodeInto(rep.Secret)
+	} else {
+		rep.data, err = base64.StdEncoding.DecodeString(rep.Secret)
+	}
+	rep.Secret = ""
+	if err != nil {
+		return nil, fmt.Errorf("decode secret: %w", err)
+	}
+	return rep, nil
+}
+
+// runOnce spawns a helper for one batch of requests and hands its output
+// to read. The helper is killed if ctx is done before it exits.
+func (b *Bridge) runOnce(ctx context.Context, reqData []byte, read func(*bufio.Reader) error) error {
+	cmd := exec.CommandContext(ctx, b.helperPath)
 	cmd.Stdin = bytes.NewReader(reqData)
 	out, err := cmd.Output()
+	defer clear(out)
 	if err != nil {
 		var exitErr *exec.ExitError
 		if errors.As(err, &exitErr) {
-			return nil, fmt.Errorf("wincred-helper exited %d: %s", exitErr.ExitCode(), string(exitErr.Stderr))
+			return fmt.Errorf("wincred-helper exited %d: %s", exitErr.ExitCode(), string(exitErr.Stderr))
 		}
-		return nil, fmt.Errorf("run wincred-helper: %w", err)
+		err = explainExecError(err)
+		return fmt.Errorf("run wincred-helper: %w", err)
 	}
+	return read(bufio.NewReader(bytes.NewReader(out)))
+}
 
-	var resp ipc.Response
-	if err := json.Unmarshal(bytes.TrimSpace(out), &resp); err != nil {
-		return nil, fmt.Errorf("decode response: %w", err)
+// Get returns the raw secret bytes for the given target. Concurrent Gets
+// of the same target share one helper call (see flights).
+func (b *Bridge) Get(ctx context.Context, target string) ([]byte, error) {
+	if b.missing.has(target) {
+		return nil, &backend.ErrNotFound{Target: target}
 	}
-	return &resp, nil
+	return b.sharedGet(ctx, target, func(ctx context.Context) ([]byte, error) {
+		rep, err := b.call(ctx, request("get", target), nil)
+		if err != nil {
+			return nil, err
+		}
+		return b.getResult(target, rep)
+	})
 }
 
-// Get returns the raw secret bytes for the given target.
-func (b *Bridge) Get(target string) ([]byte, error) {
-	resp, err := b.call(ipc.Request{Action: "get", Target: target})
-	if err != nil {
-		return nil, err
+// GetMany implements backend.Batcher. With a helper speaking frames, all
+// targets are read by one helper process.
+func (b *Bridge) GetMany(ctx context.Context, targets []string) ([][]byte, []error) {
+	secrets := make([][]byte, len(targets))
+	errs := make([]error, len(targets))
+	var reqs []ipc.Request
+	var idx []int
+	for i, t := range targets {
+		if b.missing.has(t) {
+			errs[i] = &backend.ErrNotFound{Target: t}
+			continue
+		}
+		reqs = append(reqs, request("get", t))
+		idx = append(idx, i)
 	}
-	if !resp.OK {
-		if isNotFound(resp.Error) {
+	if len(reqs) == 0 {
+		return secrets, errs
+	}
+	replies, err := b.callMany(ctx, reqs, nil)
+	for j, i := range idx {
+		if err != nil {
+			errs[i] = err
+			continue
+		}
+		secrets[i], errs[i] = b.getResult(targets[i], replies[j])
+	}
+	return secrets, errs
+}
+
+// getResult interprets the response to a "get" of target.
+func (b *Bridge) getResult(target string, rep *reply) ([]byte, error) {
+	if !rep.OK {
+		if isNotFound(rep.Error) {
+			b.missing.add(target)
 			return nil, &backend.ErrNotFound{Target: target}
 		}
-		return nil, fmt.Errorf("wincred get %q: %s", target, resp.Error)
+		return nil, replyError(rep, fmt.Errorf("wincred get %q: %s", target, rep.Error))
 	}
-	decoded, err := base64.StdEncoding.DecodeString(resp.Secret)
+	if rep.data == nil {
+		return []byte{}, nil
+	}
+	return rep.data, nil
+}
+
+// decodeInto streams the base64 secret into a buffer from b.secretAlloc so
+// that no intermediate plaintext copy is made on the Go heap.
+func (b *Bridge) decodeInto(encoded string) ([]byte, error) {
+	buf, err := b.secretAlloc(base64.StdEncoding.DecodedLen(len(encoded)))
 	if err != nil {
+		return nil, fmt.Errorf("allocate secret buffer: %w", err)
+	}
+	dec := base64.NewDecoder(base64.StdEncoding, strings.NewReader(encoded))
+	n, err := io.ReadFull(dec, buf)
+	if err != nil && !errors.Is(err, io.ErrUnexpectedEOF) {
+		b.secretRelease(buf)
 		return nil, fmt.Errorf("decode secret: %w", err)
 	}
-	return decoded, nil
+	return buf[:n], nil
 }
 
 // Set stores raw secret bytes under the given target.
-func (b *Bridge) Set(target string, secret []byte) error {
-	if len(secret) > 2560 {
-		return fmt.Errorf("secret too large for Windows Credential Manager (max 2560 bytes, got %d)", len(secret))
+func (b *Bridge) Set(ctx context.Context, target string, secret []byte) error {
+	if err := checkTarget(target); err != nil {
+		return err
+	}
+	if err := checkSize(secret); err != nil {
+		return err
+	}
+	// Forget the target before the call: if the helper fails after storing
+	// it, a stale entry would hide the new credential.
+	b.missing.remove(target)
+	b.gets.forget(target)
+	req := request("set", target)
+	describe(ctx, &req)
+	if b.persistence != nil {
+		m, _ := backend.MetadataFrom(ctx)
+		req.Persist = b.persistence(m.Collection)
 	}
-	encoded := base64.StdEncoding.EncodeToString(secret)
-	resp, err := b.call(ipc.Request{Action: "set", Target: target, Secret: encoded})
+	rep, err := b.call(ctx, req, secret)
 	if err != nil {
 		return err
 	}
-	if !resp.OK {
-		return fmt.Errorf("wincred set %q: %s", target, resp.Error)
+	if !rep.OK {
+		return replyError(rep, fmt.Errorf("wincred set %q: %s", target, rep.Error))
 	}
 	return nil
 }
 
+// SetMany implements backend.Batcher. Failures are joined in target order.
+func (b *Bridge) SetMany(ctx context.Context, secrets map[string][]byte) error {
+	targets := slices.Sorted(maps.Keys(secrets))
+	reqs := make([]ipc.Request, len(targets))
+	data := make([][]byte, len(targets))
+	for i, t := range targets {
+		if err := checkTarget(t); err != nil {
+			return err
+		}
+		if err := checkSize(secrets[t]); err != nil {
+			return fmt.Errorf("set %q: %w", t, err)
+		}
+		b.missing.remove(t)
+		b.gets.forget(t)
+		reqs[i] = request("set", t)
+		if b.persistence != nil {
+			reqs[i].Persist = b.persistence("")
+		}
+		data[i] = secrets[t]
+	}
+	if len(reqs) == 0 {
+		return nil
+	}
+	replies, err := b.callMany(ctx, reqs, data)
+	if err != nil {
+		return err
+	}
+	var errs []error
+	for i, rep := range replies {
+		if !rep.OK {
+			errs = append(errs, replyError(rep, fmt.Errorf("wincred set %q: %s", targets[i], rep.Error)))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// checkSize rejects secrets the Credential Manager cannot hold.
+func checkSize(secret []byte) error {
+	if len(secret) > ipc.MaxSecretSize {
+		return fmt.Errorf("secret too large for Windows Credential Manager (max %d bytes, got %d)", ipc.MaxSecretSize, len(secret))
+	}
+	return nil
+}
+
+// checkTarget rejects target names the Credential Manager cannot hold, so
+// that they fail with backend.ErrTargetTooLong instead of deep inside the
+// helper.
+func checkTarget(target string) error {
+	if utf16Len(target) > ipc.MaxTargetLen {
+		return &backend.ErrTargetTooLong{Target: target, Max: ipc.MaxTargetLen}
+	}
+	return nil
+}
+
+func utf16Len(s string) int {
+	n := 0
+	for _, r := range s {
+		n += utf16.RuneLen(r)
+	}
+	return n
+}
+
+// Limits on the descriptive fields of a credential: Comment and attribute
+// keywords in UTF-16 units (CRED_MAX_STRING_LENGTH), attribute values in
+// bytes (CRED_MAX_VALUE_SIZE), and the number of attributes
+// (CRED_MAX_ATTRIBUTES).
+const (
+	maxCommentLen    = 256
+	maxKeywordLen    = 256
+	maxAttrValueSize = 256
+	maxAttributes    = 64
+)
+
+// describe adds the backend.Metadata attached to ctx, if any, to the "set"
+// request req, cut down to what the Credential Manager accepts: a long
+// label is truncated, and attributes that do not fit are left out.
+func describe(ctx context.Context, req *ipc.Request) {
+	m, ok := backend.MetadataFrom(ctx)
+	if !ok {
+		return
+	}
+	req.Comment = m.Label
+	if utf16Len(m.Label) > maxCommentLen {
+		n := 0
+		for i, r := range m.Label {
+			if n += utf16.RuneLen(r); n > maxCommentLen {
+				req.Comment = m.Label[:i]
+				break
+			}
+		}
+	}
+	attrs := make(map[string]string, len(m.Attributes)+1)
+	if m.Collection != "" {
+		attrs[ipc.CollectionAttribute] = m.Collection
+	}
+	for _, k := range slices.Sorted(maps.Keys(m.Attributes)) {
+		v := m.Attributes[k]
+		if k == ipc.CollectionAttribute || len(attrs) == maxAttributes ||
+			utf16Len(k) > maxKeywordLen || len(v) > maxAttrValueSize {
+			logger.Debug("attribute not stored in the credential", "target", req.Target, "attribute", k)
+			continue
+		}
+		attrs[k] = v
+	}
+	if len(attrs) > 0 {
+		req.Attributes = attrs
+	}
+}
+
 // Delete removes the secret for the given target.
-func (b *Bridge) Delete(target string) error {
-	resp, err := b.call(ipc.Request{Action: "delete", Target: target})
+func (b *Bridge) Delete(ctx context.Context, target string) error {
+	b.gets.forget(target)
+	rep, err := b.call(ctx, request("delete", target), nil)
 	if err != nil {
 		return err
 	}
-	if !resp.OK {
-		if isNotFound(resp.Error) {
+	return b.deleteResult(target, rep)
+}
+
+// DeleteMany implements backend.Batcher.
+func (b *Bridge) DeleteMany(ctx context.Context, targets []string) []error {
+	errs := make([]error, len(targets))
+	if len(targets) == 0 {
+		return errs
+	}
+	reqs := make([]ipc.Request, len(targets))
+	for i, t := range targets {
+		b.gets.forget(t)
+		reqs[i] = request("delete", t)
+	}
+	replies, err := b.callMany(ctx, reqs, nil)
+	for i, t := range targets {
+		if err != nil {
+			errs[i] = err
+			continue
+		}
+		errs[i] = b.deleteResult(t, replies[i])
+	}
+	return errs
+}
+
+// deleteResult interprets the response to a "delete" of target.
+func (b *Bridge) deleteResult(target string, rep *reply) error {
+	if !rep.OK {
+		if isNotFound(rep.Error) {
+			b.missing.add(target)
 			return &backend.ErrNotFound{Target: target}
 		}
-		return fmt.Errorf("wincred delete %q: %s", target, resp.Error)
+		return replyError(rep, fmt.Errorf("wincred delete %q: %s", target, rep.Error))
 	}
+	b.missing.add(target)
 	return nil
 }
 
-// List returns all target strings that have the given prefix.
-func (b *Bridge) List(prefix string) ([]string, error) {
-	resp, err := b.call(ipc.Request{Action: "list", Filter: prefix})
+// List returns all target strings that have the given prefix. Generic
+// credentials are listed unless prefix starts with the type of others (see
+// TypedTarget).
+func (b *Bridge) List(ctx context.Context, prefix string) ([]string, error) {
+	req, typed := listRequest(prefix, false)
+	resp, err := b.call(ctx, req, nil)
 	if err != nil {
 		return nil, err
 	}
 	if !resp.OK {
-		return nil, fmt.Errorf("wincred list %q: %s", prefix, resp.Error)
+		return nil, replyError(resp, fmt.Errorf("wincred list %q: %s", prefix, resp.Error))
+	}
+	if typed != "" {
+		for i, t := range resp.Targets {
+			resp.Targets[i] = typed + t
+		}
 	}
 	return resp.Targets, nil
 }
 
+// ListMetadata implements backend.MetadataLister with the comments and
+// attributes stored by Set. Helpers predating them list targets only,
+// which all map to a zero Metadata.
+func (b *Bridge) ListMetadata(ctx context.Context, prefix string) (map[string]backend.Metadata, error) {
+	entries, err := b.ListEntries(ctx, prefix)
+	if err != nil {
+		return nil, err
+	}
+	metas := make(map[string]backend.Metadata, len(entries))
+	for _, e := range entries {
+		metas[e.Target] = e.Metadata
+	}
+	return metas, nil
+}
+
+// ListEntries implements backend.EntryLister with the user name, time of
+// the last change, comment and attributes of each credential. Helpers
+// predating details list targets only.
+func (b *Bridge) ListEntries(ctx context.Context, prefix string) ([]backend.Entry, error) {
+	req, typed := listRequest(prefix, true)
+	resp, err := b.call(ctx, req, nil)
+	if err != nil {
+		return nil, err
+	}
+	if !resp.OK {
+		return nil, replyError(resp, fmt.Errorf("wincred list %q: %s", prefix, resp.Error))
+	}
+	details := make(map[string]ipc.Entry, len(resp.Entries))
+	for _, e := range resp.Entries {
+		details[e.Target] = e
+	}
+	entries := make([]backend.Entry, 0, len(resp.Targets))
+	for _, t := range slices.Sorted(slices.Values(resp.Targets)) {
+		e := backend.Entry{Target: typed + t}
+		if d, ok := details[t]; ok {
+			e.UserName, e.LastWritten = d.UserName, d.LastWritten
+			e.Label, e.Collection = d.Comment, d.Attributes[ipc.CollectionAttribute]
+			if len(d.Attributes) > 0 {
+				e.Attributes = maps.Clone(d.Attributes)
+				delete(e.Attributes, ipc.CollectionAttribute)
+			}
+		}
+		entries = append(entries, e)
+	}
+	return entries, nil
+}
+
+// Verify asks the user to confirm their identity with Windows Hello
+// (UserConsentVerifier), showing message in the dialog. It blocks until the
+// user responds and returns an error unless verification succeeded.
+func (b *Bridge) Verify(ctx context.Context, message string) error {
+	resp, err := b.call(ctx, ipc.Request{Action: "verify", Message: message}, nil)
+	if err != nil {
+		return err
+	}
+	if !resp.OK {
+		return fmt.Errorf("windows hello verification: %s", resp.Error)
+	}
+	return nil
+}
+
+// Approve asks the user to allow an access in a Windows dialog showing
+// message, with Allow (Yes) and Deny (No) buttons. It blocks until the user
+// answers and returns an error unless they allowed it.
+func (b *Bridge) Approve(ctx context.Context, message string) error {
+	resp, err := b.call(ctx, ipc.Request{Action: "confirm", Message: message, Window: backend.WindowFrom(ctx)}, nil)
+	if err != nil {
+		return err
+	}
+	if !resp.OK {
+		return fmt.Errorf("windows confirmation: %s", resp.Error)
+	}
+	return nil
+}
+
+// PromptPassword asks the user for the password of collection in a Windows
+// credential dialog showing message. It blocks until the user answers and
+// returns an error if they cancelled. The caller should clear the result.
+func (b *Bridge) PromptPassword(ctx context.Context, collection, message string) ([]byte, error) {
+	rep, err := b.call(ctx, ipc.Request{Action: "password", Target: collection, Message: message, Window: backend.WindowFrom(ctx)}, nil)
+	if err != nil {
+		return nil, err
+	}
+	if !rep.OK {
+		return nil, fmt.Errorf("windows password prompt: %s", rep.Error)
+	}
+	if rep.data == nil {
+		return []byte{}, nil
+	}
+	return rep.data, nil
+}
+
+// Protect encrypts data with the Windows user's DPAPI key
+// (CryptProtectData) and returns the opaque ciphertext.
+func (b *Bridge) Protect(ctx context.Context, data []byte) ([]byte, error) {
+	return b.dpapi(ctx, "protect", data)
+}
+
+// Unprotect decrypts data produced by Protect (CryptUnprotectData).
+// The result is decoded onto the Go heap; the caller should clear it.
+func (b *Bridge) Unprotect(ctx context.Context, data []byte) ([]byte, error) {
+	return b.dpapi(ctx, "unprotect", data)
+}
+
+// dpapi sends data to the helper's "protect" or "unprotect" action.
+func (b *Bridge) dpapi(ctx context.Context, action string, data []byte) ([]byte, error) {
+	rep, err := b.call(ctx, ipc.Request{Action: action}, data)
+	if err != nil {
+		return nil, err
+	}
+	if !rep.OK {
+		return nil, replyError(rep, fmt.Errorf("dpapi %s: %s", action, rep.Error))
+	}
+	if rep.data == nil {
+		return []byte{}, nil
+	}
+	return rep.data, nil
+}
+
 // isNotFound reports whether an error message indicates a missing credential.
 func isNotFound(errMsg string) bool {
 	lower := strings.ToLower(errMsg)
@@ -166,3 +956,63 @@ func isNotFound(errMsg string) bool {
 		strings.Contains(lower, "element not found") ||
 		strings.Contains(lower, "no such")
 }
+
+// notFoundCache remembers targets the helper reported missing. A nil cache
+// remembers nothing.
+type notFoundCache struct {
+	ttl     time.Duration
+	mu      sync.Mutex
+	expires map[string]time.Time
+}
+
+// notFoundCacheSweep is the size above which add drops expired entries.
+const notFoundCacheSweep = 256
+
+func (c *notFoundCache) has(target string) bool {
+	if c == nil {
+		return false
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	exp, ok := c.expires[target]
+	if ok && time.Now().After(exp) {
+		delete(c.expires, target)
+		return false
+	}
+	return ok
+}
+
+func (c *notFoundCache) add(target string) {
+	if c == nil {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	now := time.Now()
+	if len(c.expires) >= notFoundCacheSweep {
+		for t, exp := range c.expires {
+			if now.After(exp) {
+				delete(c.expires, t)
+			}
+		}
+	}
+	c.expires[target] = now.Add(c.ttl)
+}
+
+func (c *notFoundCache) remove(target string) {
+	if c == nil {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	delete(c.expires, target)
+}
+
+func (c *notFoundCache) clear() {
+	if c == nil {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	clear(c.expires)
+}
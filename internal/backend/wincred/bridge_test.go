@@ -3,14 +3,27 @@
 package wincred
 
 import (
+	"bufio"
+	"bytes"
+	"context"
 	"encoding/base64"
+	"encoding/json"
+	"errors"
 	"fmt"
+	"maps"
+	"net"
 	"os"
 	"os/exec"
 	"path/filepath"
 	"runtime"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
 	"testing"
+	"time"
 
+	"github.com/akihiro/wsl-secret-service/internal/backend"
 	"github.com/akihiro/wsl-secret-service/internal/ipc"
 )
 
@@ -22,12 +35,14 @@ func buildMockHelper(t *testing.T) string {
 		t.Skip("mock helper test only runs on Linux (it mocks the Windows side)")
 	}
 
-	// Write a small Go program that acts as the mock wincred-helper.
+	// Write a small Go program that acts as the mock wincred-helper. It
+	// answers a single JSON line, like a protocol v2 helper.
 	src := `package main
 
 import (
 	"encoding/json"
 	"os"
+	"strconv"
 )
 
 type req struct {
@@ -41,6 +56,7 @@ type resp struct {
 	Secret  string   ` + "`json:\"secret,omitempty\"`" + `
 	Targets []string ` + "`json:\"targets,omitempty\"`" + `
 	Error   string   ` + "`json:\"error,omitempty\"`" + `
+	Version int      ` + "`json:\"version,omitempty\"`" + `
 }
 func main() {
 	// In-memory credential store for the mock.
@@ -54,6 +70,13 @@ func main() {
 	}
 	enc := json.NewEncoder(os.Stdout)
 	switch r.Action {
+	case "hello":
+		// MOCK_HELPER_PROTOCOL="" behaves like a helper without versioning.
+		if v, err := strconv.Atoi(os.Getenv("MOCK_HELPER_PROTOCOL")); err == nil {
+			enc.Encode(resp{OK: true, Version: v})
+		} else {
+			enc.Encode(resp{OK: false, Error: "unknown action: \"hello\""})
+		}
 	case "get":
 		if v, ok := store[r.Target]; ok {
 			enc.Encode(resp{OK: true, Secret: v})
@@ -91,6 +114,7 @@ func main() {
 	if out, err := cmd.CombinedOutput(); err != nil {
 		t.Fatalf("build mock helper: %v\n%s", err, out)
 	}
+	t.Setenv("MOCK_HELPER_PROTOCOL", strconv.Itoa(ipc.MinProtocolVersion))
 	return binPath
 }
 
@@ -106,7 +130,7 @@ func newTestBridge(t *testing.T) *Bridge {
 
 func TestGet_Existing(t *testing.T) {
 	b := newTestBridge(t)
-	got, err := b.Get("wsl-ss/login/existing")
+	got, err := b.Get(t.Context(), "wsl-ss/login/existing")
 	if err != nil {
 		t.Fatalf("Get: %v", err)
 	}
@@ -118,7 +142,7 @@ func TestGet_Existing(t *testing.T) {
 
 func TestGet_NotFound(t *testing.T) {
 	b := newTestBridge(t)
-	_, err := b.Get("wsl-ss/login/nonexistent")
+	_, err := b.Get(t.Context(), "wsl-ss/login/nonexistent")
 	if err == nil {
 		t.Fatal("expected error for missing key")
 	}
@@ -132,7 +156,7 @@ func TestSet_And_Get(t *testing.T) {
 
 	secret := []byte("my-password-123")
 	// Mock helper is stateless per invocation, so we test the Set response only.
-	if err := b.Set("wsl-ss/login/new-item", secret); err != nil {
+	if err := b.Set(t.Context(), "wsl-ss/login/new-item", secret); err != nil {
 		t.Fatalf("Set: %v", err)
 	}
 }
@@ -140,22 +164,38 @@ func TestSet_And_Get(t *testing.T) {
 func TestSet_TooLarge(t *testing.T) {
 	b := newTestBridge(t)
 	tooBig := make([]byte, 2561)
-	if err := b.Set("wsl-ss/login/big", tooBig); err == nil {
+	if err := b.Set(t.Context(), "wsl-ss/login/big", tooBig); err == nil {
 		t.Fatal("expected error for oversized secret")
 	}
 }
 
+func TestSet_TargetTooLong(t *testing.T) {
+	b := newTestBridge(t)
+	// Characters outside the BMP count twice, as in UTF-16.
+	long := "wsl-ss/login/" + strings.Repeat("\U0001F511", (ipc.MaxTargetLen-13)/2+1)
+	var tooLong *backend.ErrTargetTooLong
+	if err := b.Set(t.Context(), long, []byte("x")); !errors.As(err, &tooLong) {
+		t.Fatalf("Set: err = %v, want ErrTargetTooLong", err)
+	}
+	if err := b.SetMany(t.Context(), map[string][]byte{long: []byte("x")}); !errors.As(err, &tooLong) {
+		t.Fatalf("SetMany: err = %v, want ErrTargetTooLong", err)
+	}
+	if err := checkTarget("wsl-ss/login/" + strings.Repeat("a", ipc.MaxTargetLen-13)); err != nil {
+		t.Errorf("target of maximum length rejected: %v", err)
+	}
+}
+
 func TestDelete_Existing(t *testing.T) {
 	b := newTestBridge(t)
 	// The mock store starts with "wsl-ss/login/existing".
-	if err := b.Delete("wsl-ss/login/existing"); err != nil {
+	if err := b.Delete(t.Context(), "wsl-ss/login/existing"); err != nil {
 		t.Fatalf("Delete: %v", err)
 	}
 }
 
 func TestDelete_NotFound(t *testing.T) {
 	b := newTestBridge(t)
-	err := b.Delete("wsl-ss/login/gone")
+	err := b.Delete(t.Context(), "wsl-ss/login/gone")
 	if err == nil {
 		t.Fatal("expected error deleting non-existent key")
 	}
@@ -163,7 +203,7 @@ func TestDelete_NotFound(t *testing.T) {
 
 func TestList(t *testing.T) {
 	b := newTestBridge(t)
-	targets, err := b.List("wsl-ss/")
+	targets, err := b.List(t.Context(), "wsl-ss/")
 	if err != nil {
 		t.Fatalf("List: %v", err)
 	}
@@ -196,7 +236,7 @@ func TestFindHelper_NotFound(t *testing.T) {
 		}
 	}()
 
-	_, err := findHelper()
+	_, err := FindHelper()
 	if err == nil {
 		t.Fatal("expected error when wincred-helper.exe is not in any standard location")
 	}
@@ -207,24 +247,884 @@ func TestIpcProtocol(t *testing.T) {
 	helperPath := buildMockHelper(t)
 	b := &Bridge{helperPath: helperPath}
 
-	resp, err := b.call(ipc.Request{Action: "get", Target: "wsl-ss/login/existing"})
+	resp, err := b.call(t.Context(), ipc.Request{Action: "get", Target: "wsl-ss/login/existing"}, nil)
 	if err != nil {
 		t.Fatalf("call: %v", err)
 	}
 	if !resp.OK {
 		t.Errorf("ok=false, error=%q", resp.Error)
 	}
-	if resp.Secret == "" {
-		t.Error("expected non-empty secret in response")
+	if string(resp.data) != "test-secret" {
+		t.Errorf("decoded secret = %q, want %q", resp.data, "test-secret")
+	}
+	fmt.Println("IPC round-trip OK:", string(resp.data))
+}
+
+func TestGet_WithSecretAllocator(t *testing.T) {
+	helperPath := buildMockHelper(t)
+	var allocated, released int
+	alloc := func(n int) ([]byte, error) {
+		allocated++
+		return make([]byte, n), nil
+	}
+	release := func([]byte) { released++ }
+	b, err := New(helperPath, WithSecretAllocator(alloc, release))
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+
+	got, err := b.Get(t.Context(), "wsl-ss/login/existing")
+	if err != nil {
+		t.Fatalf("Get: %v", err)
+	}
+	if string(got) != "test-secret" {
+		t.Errorf("got %q, want %q", got, "test-secret")
+	}
+	if allocated != 1 || released != 0 {
+		t.Errorf("allocated=%d released=%d, want 1 and 0", allocated, released)
+	}
+}
+
+// buildRepoMockHelper compiles cmd/mock-wincred-helper, which supports the
+// --serve mode, backed by a fresh store file.
+func buildRepoMockHelper(t *testing.T) string {
+	t.Helper()
+	if runtime.GOOS == "windows" {
+		t.Skip("mock helper test only runs on Linux (it mocks the Windows side)")
+	}
+	dir := t.TempDir()
+	binPath := filepath.Join(dir, "mock-wincred-helper")
+	cmd := exec.Command("go", "build", "-o", binPath, "../../../cmd/mock-wincred-helper")
+	if out, err := cmd.CombinedOutput(); err != nil {
+		t.Fatalf("build mock helper: %v\n%s", err, out)
+	}
+	t.Setenv("MOCK_WINCRED_STORE", filepath.Join(dir, "store.json"))
+	return binPath
+}
+
+func TestPersistentHelper_RoundTrips(t *testing.T) {
+	b, err := New(buildRepoMockHelper(t), WithPersistentHelper(time.Minute))
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+	defer b.Close()
+
+	if err := b.Set(t.Context(), "wsl-ss/login/p1", []byte("persistent")); err != nil {
+		t.Fatalf("Set: %v", err)
+	}
+	pid := b.persistent.proc.cmd.Process.Pid
+	got, err := b.Get(t.Context(), "wsl-ss/login/p1")
+	if err != nil {
+		t.Fatalf("Get: %v", err)
+	}
+	if string(got) != "persistent" {
+		t.Errorf("got %q, want %q", got, "persistent")
+	}
+	if b.persistent.proc.cmd.Process.Pid != pid {
+		t.Error("expected both calls to be served by the same helper process")
+	}
+}
+
+func TestPersistentHelper_RestartsAfterExit(t *testing.T) {
+	// The inline mock helper ignores --serve and exits after one request,
+	// which looks like a crash to the persistent bridge.
+	b, err := New(buildMockHelper(t), WithPersistentHelper(0))
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+	defer b.Close()
+
+	for i := 0; i < 3; i++ {
+		if _, err := b.Get(t.Context(), "wsl-ss/login/existing"); err != nil {
+			t.Fatalf("Get #%d: %v", i, err)
+		}
+	}
+}
+
+func TestPersistentHelper_IdleShutdown(t *testing.T) {
+	b, err := New(buildRepoMockHelper(t), WithPersistentHelper(50*time.Millisecond))
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+	defer b.Close()
+
+	if _, err := b.List(t.Context(), "wsl-ss/"); err != nil {
+		t.Fatalf("List: %v", err)
+	}
+	time.Sleep(200 * time.Millisecond)
+	b.persistent.mu.Lock()
+	running := b.persistent.proc != nil
+	b.persistent.mu.Unlock()
+	if running {
+		t.Error("helper should have been stopped after the idle timeout")
+	}
+}
+
+// scriptedHelper writes a helper answering "hello" with protocol v2 and
+// running body for the first other request, which is in $line.
+func scriptedHelper(t *testing.T, body string) string {
+	t.Helper()
+	if runtime.GOOS == "windows" {
+		t.Skip("mock helper test only runs on Linux (it mocks the Windows side)")
+	}
+	path := filepath.Join(t.TempDir(), "scripted-helper")
+	script := `#!/bin/sh
+while read -r line; do
+	case $line in
+	*'"hello"'*) echo '{"ok":true,"version":2}' ;;
+	*) ` + body + ` ;;
+	esac
+done
+`
+	if err := os.WriteFile(path, []byte(script), 0o700); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestPersistentHelper_ReplyTimeout(t *testing.T) {
+	b, err := New(scriptedHelper(t, "exec sleep 60"), WithPersistentHelper(time.Minute))
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+	defer b.Close()
+	b.persistent.replyTimeout = 200 * time.Millisecond
+
+	start := time.Now()
+	if _, err := b.Get(t.Context(), "wsl-ss/login/a"); err == nil || !strings.Contains(err.Error(), "no reply") {
+		t.Errorf("Get with a hung helper: err = %v, want the reply timeout", err)
+	}
+	if d := time.Since(start); d > 5*time.Second {
+		t.Errorf("Get returned after %s, want shortly after the reply timeout", d)
+	}
+}
+
+func TestPersistentHelper_WritesNotResent(t *testing.T) {
+	// The helper dies after reading each request, without answering.
+	log := filepath.Join(t.TempDir(), "requests")
+	b, err := New(scriptedHelper(t, fmt.Sprintf(`echo "$line" >>%q; exit 1`, log)), WithPersistentHelper(time.Minute))
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+	defer b.Close()
+	received := func() int {
+		data, _ := os.ReadFile(log)
+		return strings.Count(string(data), "\n")
+	}
+
+	if err := b.Set(t.Context(), "wsl-ss/login/a", []byte("s")); err == nil {
+		t.Fatal("Set succeeded without a reply")
+	}
+	if n := received(); n != 1 {
+		t.Errorf("helper received the Set %d times, want 1", n)
+	}
+	if _, err := b.Get(t.Context(), "wsl-ss/login/a"); err == nil {
+		t.Fatal("Get succeeded without a reply")
+	}
+	if n := received(); n != 3 {
+		t.Errorf("helper received %d requests after the Get, want 3 (the Get is resent)", n)
+	}
+}
+
+func TestVerify(t *testing.T) {
+	b, err := New(buildRepoMockHelper(t))
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+	if err := b.Verify(t.Context(), "allow?"); err != nil {
+		t.Fatalf("Verify: %v", err)
+	}
+
+	t.Setenv("MOCK_WINCRED_VERIFY", "Canceled")
+	if err := b.Verify(t.Context(), "allow?"); err == nil {
+		t.Fatal("expected error for canceled verification")
+	}
+}
+
+func TestApprove(t *testing.T) {
+	b, err := New(buildRepoMockHelper(t))
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+	if err := b.Approve(t.Context(), "git wants to read \"token\""); err != nil {
+		t.Fatalf("Approve: %v", err)
+	}
+
+	t.Setenv("MOCK_WINCRED_CONFIRM", "deny")
+	if err := b.Approve(t.Context(), "git wants to read \"token\""); err == nil || !strings.Contains(err.Error(), "denied") {
+		t.Fatalf("Approve when denied: err = %v, want denial", err)
+	}
+}
+
+func TestPromptPassword(t *testing.T) {
+	b, err := New(buildRepoMockHelper(t))
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+	if _, err := b.PromptPassword(t.Context(), "login", "Enter the password"); err == nil || !strings.Contains(err.Error(), "cancelled") {
+		t.Fatalf("PromptPassword without a password: err = %v, want cancellation", err)
+	}
+
+	t.Setenv("MOCK_WINCRED_PASSWORD", "hunter2")
+	pw, err := b.PromptPassword(t.Context(), "login", "Enter the password")
+	if err != nil {
+		t.Fatalf("PromptPassword: %v", err)
+	}
+	if string(pw) != "hunter2" {
+		t.Errorf("PromptPassword = %q, want %q", pw, "hunter2")
+	}
+}
+
+func TestProtectUnprotect(t *testing.T) {
+	b, err := New(buildRepoMockHelper(t))
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+	ct, err := b.Protect(t.Context(), []byte("payload"))
+	if err != nil {
+		t.Fatalf("Protect: %v", err)
+	}
+	pt, err := b.Unprotect(t.Context(), ct)
+	if err != nil {
+		t.Fatalf("Unprotect: %v", err)
+	}
+	if string(pt) != "payload" {
+		t.Errorf("Unprotect = %q, want %q", pt, "payload")
+	}
+}
+
+func TestNotFoundCache(t *testing.T) {
+	helper := buildRepoMockHelper(t)
+	b, err := New(helper, WithNotFoundCache(time.Minute))
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+	var notFound *backend.ErrNotFound
+	if _, err := b.Get(t.Context(), "wsl-ss/login/missing"); !errors.As(err, &notFound) {
+		t.Fatalf("Get = %v, want ErrNotFound", err)
+	}
+
+	// A cached miss must not run the helper at all.
+	b.helperPath = filepath.Join(t.TempDir(), "absent.exe")
+	if _, err := b.Get(t.Context(), "wsl-ss/login/missing"); !errors.As(err, &notFound) {
+		t.Fatalf("cached Get = %v, want ErrNotFound", err)
+	}
+
+	// Storing the target makes it visible again.
+	b.helperPath = helper
+	if err := b.Set(t.Context(), "wsl-ss/login/missing", []byte("now here")); err != nil {
+		t.Fatalf("Set: %v", err)
+	}
+	if got, err := b.Get(t.Context(), "wsl-ss/login/missing"); err != nil || string(got) != "now here" {
+		t.Fatalf("Get after Set = %q, %v", got, err)
+	}
+	if err := b.Delete(t.Context(), "wsl-ss/login/missing"); err != nil {
+		t.Fatalf("Delete: %v", err)
+	}
+	if !b.missing.has("wsl-ss/login/missing") {
+		t.Error("deleted target should be cached as missing")
+	}
+}
+
+func TestNotFoundCache_Expires(t *testing.T) {
+	b, err := New(buildRepoMockHelper(t), WithNotFoundCache(20*time.Millisecond))
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+	_, _ = b.Get(t.Context(), "wsl-ss/login/missing")
+	if !b.missing.has("wsl-ss/login/missing") {
+		t.Fatal("miss not cached")
+	}
+	time.Sleep(50 * time.Millisecond)
+	if b.missing.has("wsl-ss/login/missing") {
+		t.Error("miss still cached after its ttl")
+	}
+}
+
+func TestCallLimiter(t *testing.T) {
+	l := newCallLimiter(100, 2, time.Second)
+	start := time.Now()
+	for i := 0; i < 4; i++ {
+		if err := l.wait(t.Context()); err != nil {
+			t.Fatalf("wait #%d: %v", i, err)
+		}
+	}
+	// Two calls pass as a burst, the next two are spaced 10ms apart.
+	if d := time.Since(start); d < 15*time.Millisecond {
+		t.Errorf("4 calls took %s, want them queued for about 20ms", d)
+	}
+
+	l = newCallLimiter(1, 1, 10*time.Millisecond)
+	if err := l.wait(t.Context()); err != nil {
+		t.Fatalf("first wait: %v", err)
+	}
+	if err := l.wait(t.Context()); err == nil {
+		t.Error("wait beyond the queue timeout succeeded")
+	}
+}
+
+func TestHelloRejectsIncompatibleHelper(t *testing.T) {
+	helper := buildMockHelper(t)
+	var perr *ProtocolError
+	for _, tc := range []struct{ env, want string }{
+		{"", "protocol v1"}, // predates the hello action
+		{"1", "needs v2 or later"},
+		{strconv.Itoa(ipc.ProtocolVersion + 1), "upgrade wsl-secret-service"},
+	} {
+		t.Setenv("MOCK_HELPER_PROTOCOL", tc.env)
+		b, _ := New(helper)
+		_, err := b.Get(t.Context(), "wsl-ss/login/existing")
+		if !errors.As(err, &perr) || !strings.Contains(err.Error(), tc.want) {
+			t.Errorf("MOCK_HELPER_PROTOCOL=%q: Get error = %v, want ProtocolError mentioning %q", tc.env, err, tc.want)
+		}
+	}
+
+	// Once the helper is replaced, the bridge works without a restart.
+	t.Setenv("MOCK_HELPER_PROTOCOL", strconv.Itoa(ipc.MinProtocolVersion))
+	b, _ := New(helper)
+	if _, err := b.Get(t.Context(), "wsl-ss/login/existing"); err != nil {
+		t.Fatalf("Get with a current helper: %v", err)
+	}
+}
+
+func TestFramedBatch(t *testing.T) {
+	b, err := New(buildRepoMockHelper(t))
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+	// Newlines and NUL bytes survive frames unescaped.
+	secrets := map[string][]byte{
+		"wsl-ss/login/a": []byte("line1\nline2"),
+		"wsl-ss/login/b": {0, 1, 2, '{', '\n'},
+	}
+	if err := b.SetMany(t.Context(), secrets); err != nil {
+		t.Fatalf("SetMany: %v", err)
+	}
+	if b.protocol != ipc.ProtocolVersion {
+		t.Errorf("negotiated protocol v%d, want v%d", b.protocol, ipc.ProtocolVersion)
+	}
+
+	targets := []string{"wsl-ss/login/a", "wsl-ss/login/missing", "wsl-ss/login/b"}
+	got, errs := b.GetMany(t.Context(), targets)
+	for _, i := range []int{0, 2} {
+		if errs[i] != nil || !bytes.Equal(got[i], secrets[targets[i]]) {
+			t.Errorf("GetMany[%d] = %q, %v; want %q", i, got[i], errs[i], secrets[targets[i]])
+		}
+	}
+	var nf *backend.ErrNotFound
+	if !errors.As(errs[1], &nf) {
+		t.Errorf("GetMany of a missing target: err = %v, want ErrNotFound", errs[1])
+	}
+
+	errs = b.DeleteMany(t.Context(), []string{"wsl-ss/login/a", "wsl-ss/login/b"})
+	if errs[0] != nil || errs[1] != nil {
+		t.Fatalf("DeleteMany: %v", errs)
+	}
+	if list, _ := b.List(t.Context(), "wsl-ss/"); len(list) != 0 {
+		t.Errorf("List after DeleteMany = %v, want none", list)
+	}
+}
+
+// TestBatchFallsBackToJSONLines checks that batches still work with a v2
+// helper, which only answers one JSON line per process.
+func TestBatchFallsBackToJSONLines(t *testing.T) {
+	b := newTestBridge(t)
+	got, errs := b.GetMany(t.Context(), []string{"wsl-ss/login/existing", "wsl-ss/login/missing"})
+	if errs[0] != nil || string(got[0]) != "test-secret" {
+		t.Errorf("GetMany[0] = %q, %v; want test-secret", got[0], errs[0])
+	}
+	var nf *backend.ErrNotFound
+	if !errors.As(errs[1], &nf) {
+		t.Errorf("GetMany[1]: err = %v, want ErrNotFound", errs[1])
+	}
+	if b.protocol != ipc.MinProtocolVersion {
+		t.Errorf("negotiated protocol v%d, want v%d", b.protocol, ipc.MinProtocolVersion)
+	}
+}
+
+func TestVsockNeedsNoHelperExecutable(t *testing.T) {
+	t.Setenv("PATH", t.TempDir())
+	t.Setenv("XDG_DATA_HOME", t.TempDir())
+	t.Setenv("HOME", t.TempDir())
+	b, err := New("", WithVsock(1, filepath.Join(t.TempDir(), "vsock-1.token")))
+	if err != nil {
+		t.Fatalf("New with WithVsock: %v", err)
+	}
+	defer b.Close()
+	// No helper listens on vsock port 1 (and there may be no vsock at all).
+	if _, err := b.Get(t.Context(), "wsl-ss/login/existing"); err == nil || !strings.Contains(err.Error(), "vsock") {
+		t.Errorf("Get error = %v, want a vsock error", err)
+	}
+	if _, err := New("", WithVsock(1, "")); err == nil {
+		t.Error("New with WithVsock and no token file succeeded")
+	}
+}
+
+func TestVsockAuthenticate(t *testing.T) {
+	for _, token := range []string{"right", "wrong", ""} {
+		client, server := net.Pipe()
+		go func() {
+			defer server.Close()
+			conn := ipc.NewServerConn(server, server)
+			req, err := conn.ReadRequest()
+			if err != nil {
+				return
+			}
+			if req.Action != "hello" || req.Token != "right" {
+				_ = conn.WriteResponse(ipc.Response{Error: "vsock connection refused"})
+				return
+			}
+			_ = conn.WriteResponse(ipc.Hello("dev"))
+		}()
+		err := authenticate(client, bufio.NewReader(client), token)
+		client.Close()
+		if (err == nil) != (token == "right") {
+			t.Errorf("authenticate with token %q: err = %v", token, err)
+		}
+	}
+}
+
+func TestCallCancelledWithContext(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("mock helper test only runs on Linux (it mocks the Windows side)")
+	}
+	hung := filepath.Join(t.TempDir(), "hung-helper")
+	if err := os.WriteFile(hung, []byte("#!/bin/sh\nexec sleep 60\n"), 0o700); err != nil {
+		t.Fatal(err)
+	}
+	for name, opts := range map[string][]Option{
+		"per call":   nil,
+		"persistent": {WithPersistentHelper(time.Minute)},
+	} {
+		t.Run(name, func(t *testing.T) {
+			b, err := New(hung, opts...)
+			if err != nil {
+				t.Fatalf("New: %v", err)
+			}
+			defer b.Close()
+			ctx, cancel := context.WithTimeout(t.Context(), 100*time.Millisecond)
+			defer cancel()
+			start := time.Now()
+			if _, err := b.Get(ctx, "wsl-ss/login/existing"); !errors.Is(err, context.DeadlineExceeded) {
+				t.Errorf("Get with a hung helper: err = %v, want DeadlineExceeded", err)
+			}
+			if d := time.Since(start); d > 5*time.Second {
+				t.Errorf("Get returned after %s, want shortly after the deadline", d)
+			}
+		})
+	}
+}
+
+func TestRetriesTransientErrors(t *testing.T) {
+	b, err := New(buildRepoMockHelper(t))
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+	b.retryDelay = time.Millisecond
+	failures := filepath.Join(t.TempDir(), "transient")
+	t.Setenv("MOCK_WINCRED_TRANSIENT", failures)
+
+	if err := os.WriteFile(failures, []byte("2"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if err := b.Set(t.Context(), "wsl-ss/login/a", []byte("s")); err != nil {
+		t.Fatalf("Set after two transient failures: %v", err)
+	}
+
+	if err := os.WriteFile(failures, []byte(strconv.Itoa(retryAttempts)), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := b.Get(t.Context(), "wsl-ss/login/a"); err == nil || !strings.Contains(err.Error(), "logon session") {
+		t.Errorf("Get failing on every attempt: err = %v, want the logon session error", err)
+	}
+	if got, err := b.Get(t.Context(), "wsl-ss/login/a"); err != nil || string(got) != "s" {
+		t.Errorf("Get once the error passed = %q, %v", got, err)
+	}
+}
+
+func TestInjectedFaults(t *testing.T) {
+	b, err := New(buildRepoMockHelper(t))
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+	b.retryDelay = time.Millisecond
+	if err := b.Set(t.Context(), "wsl-ss/login/a", []byte("secret")); err != nil {
+		t.Fatal(err)
+	}
+
+	t.Run("latency", func(t *testing.T) {
+		t.Setenv("MOCK_WINCRED_LATENCY", "2s")
+		ctx, cancel := context.WithTimeout(t.Context(), 100*time.Millisecond)
+		defer cancel()
+		if _, err := b.Get(ctx, "wsl-ss/login/a"); !errors.Is(err, context.DeadlineExceeded) {
+			t.Errorf("Get from a slow helper: err = %v, want DeadlineExceeded", err)
+		}
+	})
+
+	t.Run("transient", func(t *testing.T) {
+		t.Setenv("MOCK_WINCRED_FAIL_RATE", "1")
+		t.Setenv("MOCK_WINCRED_FAIL_ERROR", "RPC_S_SERVER_UNAVAILABLE")
+		var unavailable *backend.ErrUnavailable
+		if _, err := b.Get(t.Context(), "wsl-ss/login/a"); !errors.As(err, &unavailable) {
+			t.Errorf("Get failing with RPC_S_SERVER_UNAVAILABLE: err = %v, want ErrUnavailable", err)
+		}
+	})
+
+	t.Run("permanent", func(t *testing.T) {
+		t.Setenv("MOCK_WINCRED_FAIL_RATE", "1")
+		t.Setenv("MOCK_WINCRED_FAIL_ERROR", "5")
+		var unavailable *backend.ErrUnavailable
+		if _, err := b.Get(t.Context(), "wsl-ss/login/a"); err == nil || errors.As(err, &unavailable) || !strings.Contains(err.Error(), "Access is denied") {
+			t.Errorf("Get failing with ERROR_ACCESS_DENIED: err = %v", err)
+		}
+	})
+
+	t.Run("blob size", func(t *testing.T) {
+		t.Setenv("MOCK_WINCRED_MAX_BLOB", "8")
+		if err := b.Set(t.Context(), "wsl-ss/login/a", []byte("too long for the limit")); err == nil || !strings.Contains(err.Error(), "parameter is incorrect") {
+			t.Errorf("Set beyond the blob limit: err = %v", err)
+		}
+		if err := b.Set(t.Context(), "wsl-ss/login/a", []byte("short")); err != nil {
+			t.Errorf("Set within the blob limit: %v", err)
+		}
+	})
+}
+
+func TestConcurrentGetsShareOneCall(t *testing.T) {
+	recording := filepath.Join(t.TempDir(), "helper.jsonl")
+	b, err := New(buildRepoMockHelper(t), WithRecording(recording))
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+	if err := b.Set(t.Context(), "wsl-ss/login/a", []byte("secret")); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("MOCK_WINCRED_LATENCY", "300ms")
+
+	secrets := make([][]byte, 5)
+	errs := make([]error, len(secrets))
+	var wg sync.WaitGroup
+	for i := range secrets {
+		wg.Go(func() { secrets[i], errs[i] = b.Get(t.Context(), "wsl-ss/login/a") })
+	}
+	wg.Wait()
+	for i := range secrets {
+		if errs[i] != nil || string(secrets[i]) != "secret" {
+			t.Fatalf("Get %d = %q, %v", i, secrets[i], errs[i])
+		}
+	}
+	// Each caller wipes its secret without touching the others'.
+	clear(secrets[0])
+	if string(secrets[1]) != "secret" {
+		t.Errorf("secrets share a buffer")
+	}
+
+	// A Get that joined a call given up on makes its own.
+	ctx, cancel := context.WithTimeout(t.Context(), 100*time.Millisecond)
+	defer cancel()
+	go b.Get(ctx, "wsl-ss/login/a") //nolint:errcheck
+	time.Sleep(20 * time.Millisecond)
+	if got, err := b.Get(t.Context(), "wsl-ss/login/a"); err != nil || string(got) != "secret" {
+		t.Errorf("Get after the first caller gave up = %q, %v", got, err)
+	}
+	b.Close()
+
+	data, err := os.ReadFile(recording)
+	if err != nil {
+		t.Fatal(err)
+	}
+	records, err := ipc.ReadRecords(bytes.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	gets := 0
+	for _, rec := range records {
+		if rec.Request.Action == "get" {
+			gets++
+		}
+	}
+	// One for the five concurrent Gets, one given up on, one after it.
+	if gets != 3 {
+		t.Errorf("helper got %d get requests, want 3", gets)
+	}
+}
+
+func TestRecordAndReplay(t *testing.T) {
+	mock := buildRepoMockHelper(t)
+	recording := filepath.Join(t.TempDir(), "helper.jsonl")
+	b, err := New(mock, WithRecording(recording))
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+	if err := b.Set(t.Context(), "wsl-ss/login/a", []byte("s3cret")); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := b.Get(t.Context(), "wsl-ss/login/a"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := b.Get(t.Context(), "wsl-ss/login/missing"); err == nil {
+		t.Fatal("Get of a missing target succeeded")
+	}
+	b.Close()
+
+	data, err := os.ReadFile(recording)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Contains(data, []byte("s3cret")) || bytes.Contains(data, []byte(base64.StdEncoding.EncodeToString([]byte("s3cret")))) {
+		t.Errorf("recording holds the secret:\n%s", data)
 	}
+	records, err := ipc.ReadRecords(bytes.NewReader(data))
+	if err != nil || len(records) != 4 || records[0].Request.Action != "hello" || records[1].RequestSecretLen != 6 {
+		t.Fatalf("records = %+v, %v", records, err)
+	}
+
+	// The Credential Manager is gone; the recording answers instead.
+	t.Setenv("MOCK_WINCRED_STORE", filepath.Join(t.TempDir(), "empty.json"))
+	replay := filepath.Join(t.TempDir(), "replay-helper")
+	script := fmt.Sprintf("#!/bin/sh\nexec %q --replay %q\n", mock, recording)
+	if err := os.WriteFile(replay, []byte(script), 0o700); err != nil {
+		t.Fatal(err)
+	}
+	r, err := New(replay)
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+	defer r.Close()
+	if got, err := r.Get(t.Context(), "wsl-ss/login/a"); err != nil || len(got) != 6 {
+		t.Errorf("replayed Get = %q, %v; want 6 placeholder bytes", got, err)
+	}
+	var nf *backend.ErrNotFound
+	if _, err := r.Get(t.Context(), "wsl-ss/login/missing"); !errors.As(err, &nf) {
+		t.Errorf("replayed Get of the missing target: err = %v, want ErrNotFound", err)
+	}
+	if _, err := r.Get(t.Context(), "wsl-ss/login/unrecorded"); err == nil || !strings.Contains(err.Error(), "no recorded response") {
+		t.Errorf("Get of an unrecorded target: err = %v", err)
+	}
+}
+
+func TestIsTransient(t *testing.T) {
+	for _, tc := range []struct {
+		resp ipc.Response
+		want bool
+	}{
+		{ipc.Response{OK: false, Error: "A specified logon session does not exist.", Code: 1312}, true},
+		{ipc.Response{OK: false, Error: "The RPC server is unavailable."}, true},
+		{ipc.Response{OK: false, Error: "Element not found.", Code: 1168}, false},
+		{ipc.Response{OK: false, Error: "Element not found."}, false},
+		{ipc.Response{OK: true}, false},
+	} {
+		if got := isTransient(&reply{Response: tc.resp}); got != tc.want {
+			t.Errorf("isTransient(%+v) = %v, want %v", tc.resp, got, tc.want)
+		}
+	}
+}
+
+func TestSetStoresMetadata(t *testing.T) {
+	b, err := New(buildRepoMockHelper(t))
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+	attrs := map[string]string{
+		"service":   "github.com",
+		"oversized": strings.Repeat("v", maxAttrValueSize+1),
+		"username":  "octocat",
+	}
+	ctx := backend.WithMetadata(t.Context(), backend.Metadata{
+		Collection: "login",
+		Label:      strings.Repeat("é", maxCommentLen+10),
+		Attributes: attrs,
+	})
+	if err := b.Set(ctx, "wsl-ss/login/a", []byte("s3cret")); err != nil {
+		t.Fatalf("Set: %v", err)
+	}
+
+	raw, err := os.ReadFile(os.Getenv("MOCK_WINCRED_STORE"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var store map[string]struct {
+		Comment    string            `json:"comment"`
+		Attributes map[string]string `json:"attributes"`
+	}
+	if err := json.Unmarshal(raw, &store); err != nil {
+		t.Fatal(err)
+	}
+	cred := store["wsl-ss/login/a"]
+	if want := strings.Repeat("é", maxCommentLen); cred.Comment != want {
+		t.Errorf("Comment has %d runes, want %d", len([]rune(cred.Comment)), maxCommentLen)
+	}
+	want := map[string]string{ipc.CollectionAttribute: "login", "service": "github.com", "username": "octocat"}
+	if !maps.Equal(cred.Attributes, want) {
+		t.Errorf("Attributes = %v, want %v", cred.Attributes, want)
+	}
+
+	metas, err := b.ListMetadata(t.Context(), "wsl-ss/")
+	if err != nil {
+		t.Fatalf("ListMetadata: %v", err)
+	}
+	m := metas["wsl-ss/login/a"]
+	delete(want, ipc.CollectionAttribute)
+	if m.Collection != "login" || m.Label != cred.Comment || !maps.Equal(m.Attributes, want) {
+		t.Errorf("ListMetadata = %+v", metas)
+	}
+
+	entries, err := b.ListEntries(t.Context(), "wsl-ss/")
+	if err != nil {
+		t.Fatalf("ListEntries: %v", err)
+	}
+	if len(entries) != 1 || entries[0].UserName != "wsl-secret-service" || time.Since(entries[0].LastWritten) > time.Minute || entries[0].Collection != "login" {
+		t.Errorf("ListEntries = %+v", entries)
+	}
+}
 
-	// Verify the secret decodes correctly.
-	decoded, err := base64.StdEncoding.DecodeString(resp.Secret)
+func TestSetPersistence(t *testing.T) {
+	helper := buildRepoMockHelper(t)
+	scope := func(collection string) string {
+		if collection == "tmp" {
+			return ipc.PersistSession
+		}
+		return ""
+	}
+	b, err := New(helper, WithPersistence(scope))
 	if err != nil {
-		t.Fatalf("decode secret: %v", err)
+		t.Fatalf("New: %v", err)
+	}
+	tmp := backend.WithMetadata(t.Context(), backend.Metadata{Collection: "tmp"})
+	if err := b.Set(tmp, "wsl-ss/tmp/a", []byte("a")); err != nil {
+		t.Fatalf("Set: %v", err)
+	}
+	if err := b.Set(t.Context(), "wsl-ss/login/b", []byte("b")); err != nil {
+		t.Fatalf("Set: %v", err)
 	}
-	if string(decoded) != "test-secret" {
-		t.Errorf("decoded secret = %q, want %q", decoded, "test-secret")
+	raw, err := os.ReadFile(os.Getenv("MOCK_WINCRED_STORE"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var store map[string]struct {
+		Persist string `json:"persist"`
+	}
+	if err := json.Unmarshal(raw, &store); err != nil {
+		t.Fatal(err)
+	}
+	if got := store["wsl-ss/tmp/a"].Persist; got != ipc.PersistSession {
+		t.Errorf("persist of the tmp item = %q, want %q", got, ipc.PersistSession)
+	}
+	if got := store["wsl-ss/login/b"].Persist; got != "" {
+		t.Errorf("persist of the login item = %q, want the default", got)
+	}
+
+	// A helper that would silently persist the credential is refused.
+	old, _ := New(buildMockHelper(t), WithPersistence(scope))
+	if err := old.Set(tmp, "wsl-ss/tmp/c", []byte("c")); err == nil || !strings.Contains(err.Error(), "reinstall") {
+		t.Errorf("Set with an old helper: err = %v, want a reinstall hint", err)
+	}
+}
+
+func TestDomainCredentials(t *testing.T) {
+	b, err := New(buildRepoMockHelper(t))
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+	ctx := t.Context()
+	target := TypedTarget(ipc.CredDomainPassword, "TERMSRV/host")
+	if err := b.Set(ctx, "wsl-ss/login/a", []byte("generic")); err != nil {
+		t.Fatalf("Set: %v", err)
+	}
+	meta := backend.WithMetadata(ctx, backend.Metadata{Attributes: map[string]string{"username": `CORP\octocat`}})
+	if err := b.Set(meta, target, []byte("pa55")); err != nil {
+		t.Fatalf("Set %s: %v", target, err)
+	}
+
+	d := DomainBridge{b}
+	got, err := d.List(ctx, d.ExternalPrefix())
+	if err != nil || !slices.Equal(got, []string{target}) {
+		t.Errorf("List(%q) = %v, %v, want [%s]", d.ExternalPrefix(), got, err, target)
+	}
+	if got, err := b.List(ctx, ""); err != nil || slices.Contains(got, target) || !slices.Contains(got, "wsl-ss/login/a") {
+		t.Errorf("List(\"\") = %v, %v, want generic credentials only", got, err)
+	}
+	var notFound *backend.ErrNotFound
+	if _, err := b.Get(ctx, target); err == nil || errors.As(err, &notFound) {
+		t.Errorf("Get of a domain password: err = %v, want it to be unreadable", err)
+	}
+	if err := b.Delete(ctx, target); err != nil {
+		t.Fatalf("Delete: %v", err)
+	}
+	if _, err := b.Get(ctx, target); !errors.As(err, &notFound) {
+		t.Errorf("Get after Delete: err = %v, want ErrNotFound", err)
+	}
+
+	// Older helpers would take the request for a generic credential.
+	old, _ := New(buildMockHelper(t))
+	if err := old.Set(ctx, target, []byte("pa55")); err == nil || !strings.Contains(err.Error(), "reinstall") {
+		t.Errorf("Set with an old helper: err = %v, want a reinstall hint", err)
+	}
+}
+
+func TestSplitTarget(t *testing.T) {
+	for _, tc := range []struct{ target, typ, name string }{
+		{"wsl-ss/login/a", "", "wsl-ss/login/a"},
+		{"@domain_password/TERMSRV/host", ipc.CredDomainPassword, "TERMSRV/host"},
+		{"@domain_password/", ipc.CredDomainPassword, ""},
+		{"@generic/x", "", "@generic/x"},
+		{"@someone/x", "", "@someone/x"},
+	} {
+		if typ, name := splitTarget(tc.target); typ != tc.typ || name != tc.name {
+			t.Errorf("splitTarget(%q) = %q, %q, want %q, %q", tc.target, typ, name, tc.typ, tc.name)
+		}
+	}
+}
+
+func TestWatch(t *testing.T) {
+	b, err := New(buildRepoMockHelper(t))
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+	b.watchInterval = 20 * time.Millisecond
+	ctx, cancel := context.WithCancel(t.Context())
+	changes := make(chan backend.Change, 16)
+	done := make(chan error, 1)
+	go func() { done <- b.Watch(ctx, "wsl-ss/", func(ch backend.Change) { changes <- ch }) }()
+
+	// The helper only reports changes after its first poll, so write until
+	// one is seen.
+	const target = "wsl-ss/login/watched"
+	wait := func(want backend.Change, write func() error) {
+		t.Helper()
+		tick := time.NewTicker(100 * time.Millisecond)
+		defer tick.Stop()
+		deadline := time.After(10 * time.Second)
+		if err := write(); err != nil {
+			t.Fatalf("write: %v", err)
+		}
+		for {
+			select {
+			case ch := <-changes:
+				if ch == want {
+					return
+				}
+			case <-tick.C:
+				_ = write()
+			case <-deadline:
+				t.Fatalf("no change %+v reported", want)
+			}
+		}
+	}
+	wait(backend.Change{Target: target}, func() error { return b.Set(ctx, target, []byte("v")) })
+	if err := b.Set(ctx, "other/x", []byte("v")); err != nil {
+		t.Fatalf("Set: %v", err)
+	}
+	wait(backend.Change{Target: target, Deleted: true}, func() error { return b.Delete(ctx, target) })
+
+	cancel()
+	if err := <-done; !errors.Is(err, context.Canceled) {
+		t.Errorf("Watch after cancel: err = %v, want context.Canceled", err)
+	}
+
+	// Older helpers have no --watch.
+	old, _ := New(buildMockHelper(t))
+	if err := old.Watch(t.Context(), "wsl-ss/", func(backend.Change) {}); err == nil || !strings.Contains(err.Error(), "reinstall") {
+		t.Errorf("Watch with an old helper: err = %v, want a reinstall hint", err)
 	}
-	fmt.Println("IPC round-trip OK:", string(decoded))
 }
@@ -0,0 +1,78 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package wincred
+
+import (
+	"strings"
+
+	"github.com/akihiro/wsl-secret-service/internal/backend"
+	"github.com/akihiro/wsl-secret-service/internal/ipc"
+)
+
+// Backend targets normally name generic credentials. Credentials of other
+// types are reached through targets of the form "@<type>/<target name>",
+// e.g. "@domain_password/TERMSRV/host" for the domain credential Remote
+// Desktop keeps for host (see TypedTarget).
+const typedPrefix = "@"
+
+// TypedTarget returns the backend target naming the Windows credential
+// target of type typ (an ipc.Cred* constant).
+func TypedTarget(typ, target string) string {
+	if typ == "" || typ == ipc.CredGeneric {
+		return target
+	}
+	return typedPrefix + typ + "/" + target
+}
+
+// splitTarget returns the credential type and Windows target name of the
+// backend target t; typ is "" for generic credentials.
+func splitTarget(t string) (typ, name string) {
+	rest, ok := strings.CutPrefix(t, typedPrefix)
+	if !ok {
+		return "", t
+	}
+	typ, name, ok = strings.Cut(rest, "/")
+	if !ok || typ == "" || typ == ipc.CredGeneric || !ipc.ValidCredType(typ) {
+		return "", t
+	}
+	return typ, name
+}
+
+// request returns a request for action on the backend target t.
+func request(action, t string) ipc.Request {
+	typ, name := splitTarget(t)
+	return ipc.Request{Action: action, Target: name, Type: typ}
+}
+
+// listRequest returns a "list" request for the backend targets starting
+// with prefix, and the prefix to put back in front of the names listed.
+func listRequest(prefix string, details bool) (ipc.Request, string) {
+	typ, name := splitTarget(prefix)
+	return ipc.Request{Action: "list", Filter: name, Details: details, Type: typ}, TypedTarget(typ, "")
+}
+
+// DomainCollection is the label of the collection holding the adopted
+// domain credentials of a DomainBridge.
+const DomainCollection = "Windows Domain Credentials"
+
+// DomainBridge is a Bridge that also exposes the domain credentials of the
+// Windows user (saved by Remote Desktop, network shares, ...) as items of
+// the DomainCollection, through backend.ExternalEntries. Their passwords
+// can be replaced from Linux but, as Windows keeps them for its own logons,
+// not read.
+type DomainBridge struct {
+	*Bridge
+}
+
+// ExternalCollection implements backend.ExternalEntries.
+func (d DomainBridge) ExternalCollection() string {
+	return DomainCollection
+}
+
+// ExternalPrefix implements backend.ExternalEntries: only domain
+// credentials are adopted, not every generic credential on the machine.
+func (d DomainBridge) ExternalPrefix() string {
+	return TypedTarget(ipc.CredDomainPassword, "")
+}
+
+var _ backend.ExternalEntries = DomainBridge{}
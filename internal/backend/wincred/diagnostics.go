@@ -0,0 +1,81 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package wincred
+
+import (
+	"fmt"
+	"log/slog"
+	"sync"
+	"time"
+)
+
+// recentCallCount is how many helper calls Diagnostics reports.
+const recentCallCount = 16
+
+// recentCalls remembers the last recentCallCount helper calls, without
+// their targets, for Diagnostics.
+type recentCalls struct {
+	mu    sync.Mutex
+	calls [recentCallCount]helperCall
+	next  int // index of the oldest call, overwritten next
+}
+
+type helperCall struct {
+	start    time.Time
+	duration time.Duration
+	action   string
+	requests int
+	err      error
+}
+
+func (r *recentCalls) add(start time.Time, action string, requests int, err error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.calls[r.next] = helperCall{start: start, duration: time.Since(start), action: action, requests: requests, err: err}
+	r.next = (r.next + 1) % recentCallCount
+}
+
+// list describes the remembered calls, oldest first.
+func (r *recentCalls) list() []string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	var calls []string
+	for i := range recentCallCount {
+		c := r.calls[(r.next+i)%recentCallCount]
+		if c.start.IsZero() {
+			continue
+		}
+		result := "ok"
+		if c.err != nil {
+			result = c.err.Error()
+		}
+		calls = append(calls, fmt.Sprintf("%s %s x%d %s: %s", c.start.Format(time.TimeOnly), c.action, c.requests, c.duration.Round(time.Microsecond), result))
+	}
+	return calls
+}
+
+// Diagnostics implements backend.Diagnoser: how the helper is reached, the
+// protocol it speaks and the latencies of the last helper calls.
+func (b *Bridge) Diagnostics() []slog.Attr {
+	mode := "per-call"
+	switch {
+	case b.vsock != nil:
+		mode = "vsock"
+	case b.persistent != nil:
+		mode = "persistent"
+	}
+	protocol := "handshake in progress" // hello holds helloMu while it waits for the helper
+	if b.helloMu.TryLock() {
+		protocol = fmt.Sprint(b.protocol)
+		if b.helperVersion != "" {
+			protocol += " (wincred-helper " + b.helperVersion + ")"
+		}
+		b.helloMu.Unlock()
+	}
+	return []slog.Attr{
+		slog.String("helper", b.location()),
+		slog.String("helper_mode", mode),
+		slog.String("helper_protocol", protocol),
+		slog.Any("recent_helper_calls", b.recent.list()),
+	}
+}
@@ -0,0 +1,35 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package wincred
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRecentCalls(t *testing.T) {
+	var r recentCalls
+	if got := r.list(); len(got) != 0 {
+		t.Fatalf("list of no calls = %q", got)
+	}
+	start := time.Now()
+	for i := range recentCallCount + 2 {
+		var err error
+		if i == recentCallCount+1 {
+			err = errors.New("helper hung")
+		}
+		r.add(start.Add(time.Duration(i)*time.Second), "get", 1, err)
+	}
+	got := r.list()
+	if len(got) != recentCallCount {
+		t.Fatalf("list has %d calls, want %d", len(got), recentCallCount)
+	}
+	if want := start.Add(2 * time.Second).Format(time.TimeOnly); !strings.HasPrefix(got[0], want) {
+		t.Errorf("oldest call = %q, want it to start at %s", got[0], want)
+	}
+	if last := got[len(got)-1]; !strings.HasSuffix(last, ": helper hung") {
+		t.Errorf("newest call = %q, want the error", last)
+	}
+}
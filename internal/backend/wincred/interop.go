@@ -0,0 +1,84 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package wincred
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+)
+
+// binfmtDir is where the kernel lists the interpreters of binfmt_misc,
+// among them the one WSL registers to run Windows executables.
+const binfmtDir = "/proc/sys/fs/binfmt_misc"
+
+// interopEntries are the binfmt_misc entries of WSL interop; recent WSL
+// releases register WSLInterop-late once systemd has started.
+var interopEntries = []string{"WSLInterop", "WSLInterop-late"}
+
+// InteropError explains why Windows executables such as the helper cannot
+// be started.
+type InteropError struct {
+	Reason string
+}
+
+func (e *InteropError) Error() string {
+	return e.Reason
+}
+
+// CheckInterop returns an *InteropError if WSL interop, which starts
+// wincred-helper.exe, is unavailable. Without it exec fails with a bare
+// "exec format error".
+func CheckInterop() error {
+	return checkInterop(binfmtDir)
+}
+
+func checkInterop(dir string) error {
+	for _, name := range interopEntries {
+		data, err := os.ReadFile(filepath.Join(dir, name))
+		if err != nil {
+			continue
+		}
+		status, _, _ := strings.Cut(string(data), "\n")
+		if strings.TrimSpace(status) == "enabled" {
+			return nil
+		}
+		return &InteropError{Reason: "WSL interop is disabled in " + filepath.Join(dir, name) +
+			"; re-enable it with 'echo 1 | sudo tee " + filepath.Join(dir, name) + "'"}
+	}
+	if _, err := os.Stat(filepath.Join(dir, "status")); err != nil {
+		return &InteropError{Reason: "binfmt_misc is not mounted, so Windows executables cannot be started; " +
+			"mount it with 'sudo mount -t binfmt_misc binfmt_misc " + dir + "'"}
+	}
+	return &InteropError{Reason: "interop is disabled in /etc/wsl.conf, or not running under WSL: " +
+		"WSL has not registered WSLInterop in binfmt_misc; set \"enabled = true\" in the [interop] section " +
+		"of /etc/wsl.conf and run 'wsl --shutdown' from Windows (if systemd-binfmt is enabled, it may have " +
+		"removed the entry)"}
+}
+
+// explainExecError replaces the "exec format error" of starting the helper
+// without WSL interop by the reason interop is unavailable.
+func explainExecError(err error) error {
+	if errors.Is(err, syscall.ENOEXEC) {
+		if ierr := CheckInterop(); ierr != nil {
+			return ierr
+		}
+	}
+	return err
+}
+
+// Check implements backend.Checker: it reports a disabled WSL interop,
+// unless the helper is reached over a Hyper-V socket, and then exchanges a
+// hello with the helper, which fails for a missing or incompatible one.
+func (b *Bridge) Check(ctx context.Context) error {
+	if b.vsock == nil {
+		if err := CheckInterop(); err != nil {
+			return err
+		}
+	}
+	_, err := b.hello(ctx)
+	return err
+}
@@ -0,0 +1,55 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package wincred
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"testing"
+)
+
+func TestCheckInterop(t *testing.T) {
+	for _, tc := range []struct {
+		name  string
+		files map[string]string
+		want  string // in the error; "" = no error
+	}{
+		{"enabled", map[string]string{"status": "enabled\n", "WSLInterop": "enabled\ninterpreter /init\n"}, ""},
+		{"late", map[string]string{"status": "enabled\n", "WSLInterop-late": "enabled\n"}, ""},
+		{"disabled", map[string]string{"status": "enabled\n", "WSLInterop": "disabled\n"}, "echo 1"},
+		{"unregistered", map[string]string{"status": "enabled\n"}, "/etc/wsl.conf"},
+		{"unmounted", nil, "binfmt_misc is not mounted"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			dir := t.TempDir()
+			for name, content := range tc.files {
+				if err := os.WriteFile(filepath.Join(dir, name), []byte(content), 0o644); err != nil {
+					t.Fatal(err)
+				}
+			}
+			err := checkInterop(dir)
+			switch {
+			case tc.want == "" && err != nil:
+				t.Fatalf("checkInterop: %v", err)
+			case tc.want != "" && (err == nil || !strings.Contains(err.Error(), tc.want)):
+				t.Fatalf("checkInterop = %v, want an error mentioning %q", err, tc.want)
+			}
+			var ierr *InteropError
+			if err != nil && !errors.As(err, &ierr) {
+				t.Errorf("checkInterop returned %T, want *InteropError", err)
+			}
+		})
+	}
+}
+
+func TestExplainExecError(t *testing.T) {
+	// Errors other than ENOEXEC are not about interop.
+	err := fmt.Errorf("exec: %w", syscall.ENOENT)
+	if got := explainExecError(err); got != err {
+		t.Errorf("explainExecError(%v) = %v, want it unchanged", err, got)
+	}
+}
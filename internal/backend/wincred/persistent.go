@@ -0,0 +1,276 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package wincred
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/akihiro/wsl-secret-service/internal/ipc"
+)
+
+// defaultReplyTimeout is how long the persistent helper may take to answer
+// requests that do not wait for the user, unless ctx ends sooner. A helper
+// hanging longer is killed rather than holding up every later call.
+const defaultReplyTimeout = time.Minute
+
+// interactiveActions wait for the user to answer a dialog, so they are not
+// bound by the reply timeout.
+var interactiveActions = []string{"confirm", "password"}
+
+// resendableActions change nothing and show nothing, so a batch made of
+// them alone can be sent again when its replies were lost. A "set" or
+// "delete" that may have reached the helper is not repeated: it may have
+// taken effect, possibly after a later change by another client.
+var resendableActions = []string{"hello", "get", "list", "verify", "protect", "unprotect"}
+
+// resendable reports whether reqs may be sent again after they may have
+// reached the helper.
+func resendable(reqs []ipc.Request) bool {
+	for _, req := range reqs {
+		if !slices.Contains(resendableActions, req.Action) {
+			return false
+		}
+	}
+	return true
+}
+
+// interactive reports whether any of reqs waits for the user.
+func interactive(reqs []ipc.Request) bool {
+	for _, req := range reqs {
+		if slices.Contains(interactiveActions, req.Action) {
+			return true
+		}
+	}
+	return false
+}
+
+// persistentHelper keeps a single "wincred-helper.exe --serve" process
+// running and exchanges requests with it, avoiding the WSL
+// interop process-spawn cost on every backend call. The process is restarted
+// automatically if it dies and stopped after an idle period.
+type persistentHelper struct {
+	path         string
+	idle         time.Duration
+	replyTimeout time.Duration
+
+	mu      sync.Mutex
+	proc    *helperProcess
+	idleGen uint64
+	timer   *time.Timer
+}
+
+// helperProcess is one running helper instance.
+type helperProcess struct {
+	cmd    *exec.Cmd
+	stdin  io.WriteCloser
+	stdout *bufio.Reader // reads from pipe
+	pipe   *os.File      // read end of stdout, closed by stopLocked
+	stderr *limitedBuffer
+	done   chan struct{} // closed once the process has exited
+}
+
+func newPersistentHelper(path string, idle time.Duration) *persistentHelper {
+	return &persistentHelper{path: path, idle: idle, replyTimeout: defaultReplyTimeout}
+}
+
+// exchange writes reqData, the encoding of reqs, to the helper and lets
+// read consume the responses. If the round-trip fails (typically because
+// the helper crashed or exited), the process is restarted and the requests
+// retried once, provided they never reached the helper or are resendable.
+// If ctx is done or the helper does not answer within h.replyTimeout, the
+// helper is killed, as its replies can no longer be told apart from those
+// to later requests, and no retry is made.
+func (h *persistentHelper) exchange(ctx context.Context, reqs []ipc.Request, reqData []byte, read func(*bufio.Reader) error) error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if !interactive(reqs) {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeoutCause(ctx, h.replyTimeout,
+			fmt.Errorf("no reply from wincred-helper within %s", h.replyTimeout))
+		defer cancel()
+	}
+	sent, err := h.roundTrip(ctx, reqData, read)
+	if err != nil {
+		h.stopLocked()
+		if ctx.Err() != nil {
+			return fmt.Errorf("%w: %w", context.Cause(ctx), err)
+		}
+		if sent && !resendable(reqs) {
+			return err
+		}
+		logger.Debug("restarting persistent helper", "err", err)
+		if _, err := h.roundTrip(ctx, reqData, read); err != nil {
+			h.stopLocked()
+			return err
+		}
+	}
+	h.armIdleLocked()
+	return nil
+}
+
+// roundTrip performs one exchange on the current process, starting one if
+// necessary or if the current one has exited. sent reports whether any of
+// reqData may have reached the helper. Caller must hold h.mu.
+func (h *persistentHelper) roundTrip(ctx context.Context, reqData []byte, read func(*bufio.Reader) error) (sent bool, err error) {
+	if h.proc != nil {
+		select {
+		case <-h.proc.done:
+			// Exited while idle: it cannot have read anything.
+			h.stopLocked()
+		default:
+		}
+	}
+	if h.proc == nil {
+		p, err := startHelperProcess(h.path)
+		if err != nil {
+			return false, err
+		}
+		logger.Debug("persistent helper started", "pid", p.cmd.Process.Pid)
+		h.proc = p
+	}
+	p := h.proc
+	stop := context.AfterFunc(ctx, func() { _ = p.cmd.Process.Kill() })
+	defer stop()
+	if n, err := p.stdin.Write(reqData); err != nil {
+		return n > 0, p.failure(fmt.Errorf("write request: %w", err))
+	}
+	if err := read(p.stdout); err != nil {
+		return true, p.failure(err)
+	}
+	return true, nil
+}
+
+// armIdleLocked (re)starts the idle timer. Caller must hold h.mu.
+func (h *persistentHelper) armIdleLocked() {
+	if h.idle <= 0 {
+		return
+	}
+	h.idleGen++
+	gen := h.idleGen
+	if h.timer != nil {
+		h.timer.Stop()
+	}
+	h.timer = time.AfterFunc(h.idle, func() {
+		h.mu.Lock()
+		defer h.mu.Unlock()
+		if h.idleGen == gen {
+			h.stopLocked()
+		}
+	})
+}
+
+// close stops the helper process, if running.
+func (h *persistentHelper) close() {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.timer != nil {
+		h.timer.Stop()
+	}
+	h.stopLocked()
+}
+
+// stopLocked closes the helper's stdin, which makes it exit, and kills it
+// if it does not do so promptly. Caller must hold h.mu.
+func (h *persistentHelper) stopLocked() {
+	p := h.proc
+	if p == nil {
+		return
+	}
+	h.proc = nil
+	logger.Debug("stopping persistent helper", "pid", p.cmd.Process.Pid)
+	_ = p.stdin.Close()
+	select {
+	case <-p.done:
+	case <-time.After(2 * time.Second):
+		_ = p.cmd.Process.Kill()
+		<-p.done
+	}
+	_ = p.pipe.Close()
+}
+
+// startHelperProcess launches the helper in --serve mode.
+//
+// Its stdout is a pipe of our own rather than cmd.StdoutPipe: Wait, which
+// runs as soon as the process is started to tell when it exits, closes the
+// pipes it created, and that must not happen while a reply is being read.
+func startHelperProcess(path string) (*helperProcess, error) {
+	cmd := exec.Command(path, "--serve")
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		return nil, fmt.Errorf("helper stdin: %w", err)
+	}
+	pr, pw, err := os.Pipe()
+	if err != nil {
+		return nil, fmt.Errorf("helper stdout: %w", err)
+	}
+	cmd.Stdout = pw
+	stderr := &limitedBuffer{max: 4096}
+	cmd.Stderr = stderr
+	err = cmd.Start()
+	_ = pw.Close()
+	if err != nil {
+		_ = pr.Close()
+		_ = stdin.Close()
+		err = explainExecError(err)
+		return nil, fmt.Errorf("start wincred-helper: %w", err)
+	}
+	p := &helperProcess{
+		cmd:    cmd,
+		stdin:  stdin,
+		stdout: bufio.NewReader(pr),
+		pipe:   pr,
+		stderr: stderr,
+		done:   make(chan struct{}),
+	}
+	go func() {
+		_ = cmd.Wait()
+		close(p.done)
+	}()
+	return p, nil
+}
+
+// failure decorates err with the helper's stderr output, if it has exited.
+func (p *helperProcess) failure(err error) error {
+	select {
+	case <-p.done:
+		if msg := strings.TrimSpace(p.stderr.String()); msg != "" {
+			return fmt.Errorf("wincred-helper exited: %s: %w", msg, err)
+		}
+		return fmt.Errorf("wincred-helper exited: %w", err)
+	case <-time.After(100 * time.Millisecond):
+		return err
+	}
+}
+
+// limitedBuffer is an io.Writer that keeps at most max bytes.
+type limitedBuffer struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+	max int
+}
+
+func (b *limitedBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if room := b.max - b.buf.Len(); room > 0 {
+		b.buf.Write(p[:min(len(p), room)])
+	}
+	return len(p), nil
+}
+
+func (b *limitedBuffer) String() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.String()
+}
@@ -0,0 +1,63 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package wincred
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// callLimiter spaces out helper calls: it allows bursts of up to burst
+// calls and perSecond calls per second on average. Calls over the limit
+// wait their turn instead of failing, unless the wait would exceed timeout.
+type callLimiter struct {
+	interval time.Duration
+	burst    time.Duration // burst allowance, as a multiple of interval
+	timeout  time.Duration
+
+	mu  sync.Mutex
+	tat time.Time // theoretical arrival time of the next call (GCRA)
+}
+
+func newCallLimiter(perSecond, burst int, timeout time.Duration) *callLimiter {
+	return &callLimiter{
+		interval: time.Second / time.Duration(perSecond),
+		burst:    time.Duration(max(burst, 1)-1) * (time.Second / time.Duration(perSecond)),
+		timeout:  timeout,
+	}
+}
+
+// wait blocks until a call may be made, or ctx is done. A nil limiter never
+// blocks.
+func (l *callLimiter) wait(ctx context.Context) error {
+	if l == nil {
+		return nil
+	}
+	l.mu.Lock()
+	now := time.Now()
+	tat := l.tat
+	if tat.Before(now) {
+		tat = now
+	}
+	delay := tat.Sub(now) - l.burst
+	if delay > l.timeout {
+		l.mu.Unlock()
+		return fmt.Errorf("too many wincred-helper calls: still queued after %s", l.timeout)
+	}
+	l.tat = tat.Add(l.interval)
+	l.mu.Unlock()
+
+	if delay > 0 {
+		logger.Debug("helper call queued", "delay", delay)
+		t := time.NewTimer(delay)
+		defer t.Stop()
+		select {
+		case <-t.C:
+		case <-ctx.Done():
+			return fmt.Errorf("wincred-helper call queued: %w", context.Cause(ctx))
+		}
+	}
+	return nil
+}
@@ -0,0 +1,71 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package wincred
+
+import (
+	"encoding/json"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/akihiro/wsl-secret-service/internal/ipc"
+)
+
+// WithRecording appends every request the Bridge sends to the helper, and
+// the response it gets, to the file at path as ipc.Record JSON lines.
+// Secrets are left out; only their lengths are kept. Serving the recording
+// with "mock-wincred-helper --replay" reproduces a user's backend problem
+// without access to their Windows machine.
+func WithRecording(path string) Option {
+	return func(b *Bridge) {
+		b.recordPath = path
+	}
+}
+
+// recorder writes the records of WithRecording.
+type recorder struct {
+	mu  sync.Mutex
+	f   *os.File
+	enc *json.Encoder
+}
+
+func openRecorder(path string) (*recorder, error) {
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0o600)
+	if err != nil {
+		return nil, err
+	}
+	return &recorder{f: f, enc: json.NewEncoder(f)}, nil
+}
+
+// record writes one ipc.Record per request of an exchange that started at
+// start. If the exchange failed, err is recorded for each request instead
+// of a response.
+func (r *recorder) record(start time.Time, reqs []ipc.Request, data [][]byte, replies []*reply, err error) {
+	if r == nil {
+		return
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for i, req := range reqs {
+		req.Secret = ""
+		rec := ipc.Record{Time: start.UTC(), Request: req, RequestSecretLen: len(data[i])}
+		if err != nil {
+			rec.Error = err.Error()
+		} else {
+			resp := replies[i].Response
+			resp.Secret = ""
+			rec.Response = &resp
+			rec.ResponseSecretLen = len(replies[i].data)
+		}
+		if err := r.enc.Encode(rec); err != nil {
+			logger.Warn("cannot record helper traffic", "path", r.f.Name(), "err", err)
+			return
+		}
+	}
+}
+
+func (r *recorder) close() {
+	if r != nil {
+		_ = r.f.Close()
+	}
+}
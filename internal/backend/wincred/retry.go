@@ -0,0 +1,124 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package wincred
+
+import (
+	"context"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/akihiro/wsl-secret-service/internal/backend"
+	"github.com/akihiro/wsl-secret-service/internal/ipc"
+)
+
+// A request failing with a transient Windows error is sent up to
+// retryAttempts times in total, waiting retryBaseDelay before the first
+// retry and twice as long before each further one.
+const (
+	retryAttempts  = 4
+	retryBaseDelay = 100 * time.Millisecond
+)
+
+// Windows error codes that describe a passing condition of the system
+// rather than a problem with the request.
+var transientCodes = []uint32{
+	170,  // ERROR_BUSY
+	1312, // ERROR_NO_SUCH_LOGON_SESSION, e.g. right after the session is unlocked
+	1722, // RPC_S_SERVER_UNAVAILABLE, e.g. while Windows resumes
+	1723, // RPC_S_SERVER_TOO_BUSY
+	1726, // RPC_S_CALL_FAILED
+}
+
+// transientMessages identify the same errors in replies from helpers that
+// do not report error codes.
+var transientMessages = []string{
+	"requested resource is in use",
+	"logon session does not exist",
+	"rpc server is unavailable",
+	"rpc server is too busy",
+	"remote procedure call failed",
+}
+
+// isTransient reports whether rep failed with an error that may go away
+// when the request is repeated.
+func isTransient(rep *reply) bool {
+	if rep.OK {
+		return false
+	}
+	if rep.Code != 0 {
+		return slices.Contains(transientCodes, rep.Code)
+	}
+	lower := strings.ToLower(rep.Error)
+	for _, m := range transientMessages {
+		if strings.Contains(lower, m) {
+			return true
+		}
+	}
+	return false
+}
+
+// replyError returns err, the error reported for the failed rep, as a
+// *backend.ErrUnavailable if rep failed with a transient error that
+// persisted through the retries.
+func replyError(rep *reply, err error) error {
+	if isTransient(rep) {
+		return &backend.ErrUnavailable{Err: err}
+	}
+	return err
+}
+
+// retryable reports whether requests for action may be repeated: they read
+// or store the same state again and do not ask the user anything.
+func retryable(action string) bool {
+	switch action {
+	case "get", "set", "delete", "list", "protect", "unprotect":
+		return true
+	}
+	return false
+}
+
+// retryTransient repeats the requests among reqs whose replies report a
+// transient Windows error, with exponential backoff, and replaces those
+// replies with the new ones. It gives up after retryAttempts calls, when a
+// retry fails as a whole or when ctx is done, leaving the last failures in
+// place for the caller to report.
+func (b *Bridge) retryTransient(ctx context.Context, reqs []ipc.Request, data [][]byte, framed bool, replies []*reply) {
+	delay := b.retryDelay
+	for attempt := 1; attempt < retryAttempts; attempt++ {
+		var idx []int
+		for i, rep := range replies {
+			if retryable(reqs[i].Action) && isTransient(rep) {
+				idx = append(idx, i)
+			}
+		}
+		if len(idx) == 0 {
+			return
+		}
+		first := replies[idx[0]]
+		logger.Debug("retrying after transient helper error", "action", reqs[idx[0]].Action, "target", reqs[idx[0]].Target,
+			"requests", len(idx), "error", first.Error, "code", first.Code, "attempt", attempt, "delay", delay)
+		t := time.NewTimer(delay)
+		select {
+		case <-t.C:
+		case <-ctx.Done():
+			t.Stop()
+			return
+		}
+
+		retryReqs := make([]ipc.Request, len(idx))
+		retryData := make([][]byte, len(idx))
+		for j, i := range idx {
+			retryReqs[j], retryData[j] = reqs[i], data[i]
+		}
+		again, err := b.send(ctx, retryReqs, retryData, framed)
+		if err != nil {
+			return
+		}
+		for j, i := range idx {
+			b.release(replies[i])
+			replies[i] = again[j]
+		}
+		delay *= 2
+	}
+}
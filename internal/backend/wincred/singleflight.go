@@ -0,0 +1,121 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package wincred
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+)
+
+// flights deduplicates concurrent Gets of the same target: at the start of
+// a desktop session several clients often ask for the same item at once,
+// and each would otherwise start its own helper. The first Get of a target
+// calls the helper; the Gets of that target that start before it returns
+// wait for its result and get a copy of the secret of their own, since
+// every caller wipes the buffer it gets. The zero value is ready to use.
+type flights struct {
+	mu    sync.Mutex
+	calls map[string]*flight
+}
+
+// flight is one helper "get" whose result the Gets of its target that
+// joined it share.
+type flight struct {
+	done    chan struct{}
+	waiters int      // Gets that joined the first one
+	copies  [][]byte // the secret for each waiter, nil if it could not be allocated
+	err     error
+}
+
+// forget makes later Gets of target call the helper instead of joining a
+// call in flight, whose result a write may have made stale.
+func (f *flights) forget(target string) {
+	f.mu.Lock()
+	delete(f.calls, target)
+	f.mu.Unlock()
+}
+
+// sharedGet returns the result of get(ctx) for target, or of the get of
+// target already in flight. A Get that joined a call whose caller gave up
+// calls the helper itself.
+func (b *Bridge) sharedGet(ctx context.Context, target string, get func(context.Context) ([]byte, error)) ([]byte, error) {
+	f := &b.gets
+	f.mu.Lock()
+	if c, ok := f.calls[target]; ok {
+		i := c.waiters
+		c.waiters++
+		f.mu.Unlock()
+		logger.Debug("get joins a call in flight", "target", target)
+		select {
+		case <-c.done:
+		case <-ctx.Done():
+			go func() {
+				<-c.done
+				if c.err == nil {
+					b.releaseCopy(c.copies[i])
+				}
+			}()
+			return nil, ctx.Err()
+		}
+		switch {
+		case errors.Is(c.err, context.Canceled) || errors.Is(c.err, context.DeadlineExceeded):
+			return b.sharedGet(ctx, target, get)
+		case c.err != nil:
+			return nil, c.err
+		case c.copies[i] == nil:
+			return nil, fmt.Errorf("allocate secret buffer for %q", target)
+		}
+		return c.copies[i], nil
+	}
+	c := &flight{done: make(chan struct{})}
+	if f.calls == nil {
+		f.calls = make(map[string]*flight)
+	}
+	f.calls[target] = c
+	f.mu.Unlock()
+
+	var secret []byte
+	defer func() {
+		f.mu.Lock()
+		if f.calls[target] == c {
+			delete(f.calls, target)
+		}
+		if c.err == nil {
+			c.copies = make([][]byte, c.waiters)
+			for i := range c.copies {
+				c.copies[i] = b.copySecret(secret)
+			}
+		}
+		f.mu.Unlock()
+		close(c.done)
+	}()
+	c.err = errors.New("get panicked") // what the waiters get if it does
+	secret, c.err = get(ctx)
+	return secret, c.err
+}
+
+// copySecret returns a copy of secret in a buffer from b.secretAlloc, if
+// set, or nil if none could be allocated.
+func (b *Bridge) copySecret(secret []byte) []byte {
+	if b.secretAlloc == nil || len(secret) == 0 {
+		return bytes.Clone(secret)
+	}
+	buf, err := b.secretAlloc(len(secret))
+	if err != nil {
+		return nil
+	}
+	copy(buf, secret)
+	return buf
+}
+
+// releaseCopy wipes and frees a copy from copySecret that no caller took.
+func (b *Bridge) releaseCopy(secret []byte) {
+	if b.secretAlloc != nil && len(secret) > 0 {
+		b.secretRelease(secret)
+	} else {
+		clear(secret)
+	}
+}
@@ -0,0 +1,164 @@
+// SPDX-License-Identifier: Apache-2.0
+
+//go:build linux
+
+package wincred
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+
+	"golang.org/x/sys/unix"
+
+	"github.com/akihiro/wsl-secret-service/internal/ipc"
+	"github.com/akihiro/wsl-secret-service/internal/version"
+)
+
+// vsockHelper talks to a resident "wincred-helper.exe --listen-vsock"
+// over a Hyper-V socket instead of starting helpers through WSL interop.
+// One connection is kept open and re-established if it breaks.
+type vsockHelper struct {
+	port uint32
+	// tokenFile is where the helper wrote its token, typically under
+	// /mnt/c. It is read again for every connection, as the helper writes
+	// a new token whenever it starts.
+	tokenFile string
+
+	mu   sync.Mutex
+	conn *os.File
+	r    *bufio.Reader
+}
+
+func newVsockHelper(port uint32, tokenFile string) *vsockHelper {
+	return &vsockHelper{port: port, tokenFile: tokenFile}
+}
+
+// exchange writes reqData, the encoding of reqs, to the helper and lets
+// read consume the responses. If the round-trip fails, for example because
+// the helper was restarted, it reconnects and retries once, provided the
+// requests never reached the helper or are resendable. If ctx is done
+// first, the connection is shut down, which fails the round-trip, and no
+// retry is made.
+func (h *vsockHelper) exchange(ctx context.Context, reqs []ipc.Request, reqData []byte, read func(*bufio.Reader) error) error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if sent, err := h.roundTrip(ctx, reqData, read); err != nil {
+		h.closeLocked()
+		if ctx.Err() != nil || (sent && !resendable(reqs)) {
+			return err
+		}
+		if _, err := h.roundTrip(ctx, reqData, read); err != nil {
+			h.closeLocked()
+			return err
+		}
+	}
+	return nil
+}
+
+// roundTrip performs one exchange, connecting first if necessary. sent
+// reports whether any of reqData may have reached the helper. Caller must
+// hold h.mu.
+func (h *vsockHelper) roundTrip(ctx context.Context, reqData []byte, read func(*bufio.Reader) error) (sent bool, err error) {
+	if h.conn != nil && peerClosed(h.conn) {
+		// The helper was restarted while idle: it cannot have read anything.
+		h.closeLocked()
+	}
+	connected := false
+	if h.conn == nil {
+		conn, err := dialVsock(unix.VMADDR_CID_HOST, h.port)
+		if err != nil {
+			return false, err
+		}
+		h.conn, h.r = conn, bufio.NewReader(conn)
+		connected = true
+	}
+	// Closing the file would not interrupt a blocked read; shutting the
+	// socket down does.
+	fd := int(h.conn.Fd())
+	stop := context.AfterFunc(ctx, func() { _ = unix.Shutdown(fd, unix.SHUT_RDWR) })
+	defer stop()
+	if connected {
+		token, err := os.ReadFile(h.tokenFile)
+		if err != nil {
+			return false, fmt.Errorf("vsock port %d: read the helper's token: %w", h.port, err)
+		}
+		if err := authenticate(h.conn, h.r, strings.TrimSpace(string(token))); err != nil {
+			return false, fmt.Errorf("vsock port %d: %w", h.port, err)
+		}
+		logger.Debug("connected to resident helper", "port", h.port)
+	}
+	if n, err := h.conn.Write(reqData); err != nil {
+		return n > 0, fmt.Errorf("write request to vsock port %d: %w", h.port, err)
+	}
+	if err := read(h.r); err != nil {
+		return true, fmt.Errorf("vsock port %d: %w", h.port, err)
+	}
+	return true, nil
+}
+
+// peerClosed reports whether conn is readable between exchanges. The
+// helper never writes unprompted, so that means it closed the connection.
+func peerClosed(conn *os.File) bool {
+	fds := []unix.PollFd{{Fd: int32(conn.Fd()), Events: unix.POLLIN}}
+	n, err := unix.Poll(fds, 0)
+	return err == nil && n > 0
+}
+
+// authenticate sends the "hello" that must open every connection to a
+// resident helper, carrying its token, and checks that it was accepted.
+func authenticate(w io.Writer, r *bufio.Reader, token string) error {
+	line, err := json.Marshal(ipc.Request{Action: "hello", Version: ipc.ProtocolVersion, DaemonVersion: version.String(), Token: token})
+	if err != nil {
+		return err
+	}
+	if _, err := w.Write(append(line, '\n')); err != nil {
+		return fmt.Errorf("write hello: %w", err)
+	}
+	line, err = r.ReadBytes('\n')
+	if err != nil {
+		return fmt.Errorf("read hello response: %w", err)
+	}
+	var resp ipc.Response
+	if err := json.Unmarshal(line, &resp); err != nil {
+		return fmt.Errorf("decode hello response: %w", err)
+	}
+	if !resp.OK {
+		return fmt.Errorf("helper refused the connection: %s", resp.Error)
+	}
+	return nil
+}
+
+// close drops the connection, if any.
+func (h *vsockHelper) close() {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.closeLocked()
+}
+
+func (h *vsockHelper) closeLocked() {
+	if h.conn != nil {
+		_ = h.conn.Close()
+		h.conn, h.r = nil, nil
+	}
+}
+
+// dialVsock connects a stream socket to port on the VM with context id cid.
+// In WSL2, the Windows host is unix.VMADDR_CID_HOST.
+func dialVsock(cid, port uint32) (*os.File, error) {
+	fd, err := unix.Socket(unix.AF_VSOCK, unix.SOCK_STREAM|unix.SOCK_CLOEXEC, 0)
+	if err != nil {
+		return nil, fmt.Errorf("vsock socket: %w", err)
+	}
+	if err := unix.Connect(fd, &unix.SockaddrVM{CID: cid, Port: port}); err != nil {
+		_ = unix.Close(fd)
+		return nil, fmt.Errorf("connect to vsock port %d: %w", port, err)
+	}
+	return os.NewFile(uintptr(fd), fmt.Sprintf("vsock:%d:%d", cid, port)), nil
+}
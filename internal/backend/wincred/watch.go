@@ -0,0 +1,83 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package wincred
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os/exec"
+	"strings"
+	"time"
+
+	"github.com/akihiro/wsl-secret-service/internal/backend"
+	"github.com/akihiro/wsl-secret-service/internal/ipc"
+)
+
+// defaultWatchInterval is how often the helper started by Watch polls the
+// Credential Manager.
+const defaultWatchInterval = 2 * time.Second
+
+// watchStopDelay is how long Watch waits for the helper to exit after
+// closing its stdin before killing it.
+const watchStopDelay = 5 * time.Second
+
+// Watch implements backend.Watcher by running "wincred-helper.exe --watch
+// prefix", which polls the Credential Manager and reports every credential
+// created, changed or deleted, also by the Bridge itself. Targets reported
+// are dropped from the not-found cache. Watch needs interop and a helper
+// speaking ipc.WatchProtocolVersion.
+func (b *Bridge) Watch(ctx context.Context, prefix string, changed func(backend.Change)) error {
+	if b.vsock != nil {
+		return errors.New("watching the Credential Manager needs a helper started through interop, not over vsock")
+	}
+	v, err := b.hello(ctx)
+	if err != nil {
+		return &backend.ErrUnavailable{Err: err}
+	}
+	if v < ipc.WatchProtocolVersion {
+		return fmt.Errorf("wincred-helper at %s speaks protocol v%d and cannot watch credentials: reinstall it with 'wsl-secret-service install'",
+			b.location(), v)
+	}
+
+	cmd := exec.CommandContext(ctx, b.helperPath, "--watch", prefix, "--watch-interval", b.watchInterval.String())
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		return err
+	}
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return err
+	}
+	stderr := &limitedBuffer{max: 4096}
+	cmd.Stderr = stderr
+	// The helper stops when its stdin is closed; it is only killed if it
+	// does not.
+	cmd.Cancel = stdin.Close
+	cmd.WaitDelay = watchStopDelay
+	if err := cmd.Start(); err != nil {
+		return fmt.Errorf("start wincred-helper: %w", err)
+	}
+	logger.Debug("watching credentials", "prefix", prefix, "pid", cmd.Process.Pid)
+
+	sc := bufio.NewScanner(stdout)
+	for sc.Scan() {
+		var e ipc.Event
+		if err := json.Unmarshal(sc.Bytes(), &e); err != nil {
+			logger.Warn("undecodable watch event", "err", err)
+			continue
+		}
+		b.missing.remove(e.Target)
+		changed(backend.Change{Target: e.Target, Deleted: e.Kind == ipc.EventDeleted})
+	}
+	err = cmd.Wait()
+	if ctx.Err() != nil {
+		return context.Cause(ctx)
+	}
+	if err == nil {
+		err = errors.New("exited")
+	}
+	return fmt.Errorf("wincred-helper --watch: %w: %s", err, strings.TrimSpace(stderr.String()))
+}
@@ -0,0 +1,279 @@
+// SPDX-License-Identifier: Apache-2.0
+
+// Package config loads the optional daemon configuration file,
+// config.json in the config directory. Command-line flags take precedence
+// over values set in the file.
+package config
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"time"
+
+	"github.com/akihiro/wsl-secret-service/internal/ipc"
+)
+
+// FileName is the name of the configuration file inside the config directory.
+const FileName = "config.json"
+
+// Config holds the settings that can be given in config.json.
+// Zero values mean "not set".
+type Config struct {
+	// Backend selects the secret storage backend by registered name
+	// (e.g. "wincred", "file", "memory").
+	Backend string `json:"backend,omitempty"`
+
+	// LocalBackend replaces a backend that needs wincred-helper.exe when
+	// the daemon does not run under WSL with interop, e.g. on native Linux
+	// sharing the same dotfiles. "" means "local"; "none" disables the
+	// replacement.
+	LocalBackend string `json:"local_backend,omitempty"`
+
+	// ProxyBus is the D-Bus address of the upstream Secret Service used by
+	// the proxy backend, as given by --proxy-bus.
+	ProxyBus string `json:"proxy_bus,omitempty"`
+
+	// Middleware lists backend middlewares ("name" or "name:arg") to stack
+	// on the backend, outermost first, e.g. ["cache", "retry", "metrics"].
+	Middleware []string `json:"middleware,omitempty"`
+
+	// Store selects the metadata store format, "json" or "bolt".
+	Store string `json:"store,omitempty"`
+
+	// CaseInsensitiveSearch compares attribute values case-insensitively
+	// in searches, as does the --case-insensitive-search flag.
+	CaseInsensitiveSearch bool `json:"case_insensitive_search,omitempty"`
+
+	// AuditLog enables the audit log (audit.log in the config directory),
+	// as does the --audit-log flag.
+	AuditLog bool `json:"audit_log,omitempty"`
+
+	// Policy restricts which applications may use which secrets.
+	Policy *Policy `json:"policy,omitempty"`
+
+	// AutoLock locks collections again after a period without access.
+	AutoLock AutoLock `json:"auto_lock,omitempty"`
+
+	// Persistence chooses the Windows persistence scope of the credentials
+	// of matching collections (wincred backend only).
+	Persistence Persistence `json:"persistence,omitempty"`
+
+	// RequireMemoryLock makes the daemon refuse to start when secret
+	// material cannot be kept in locked memory, instead of warning that it
+	// may reach swap.
+	RequireMemoryLock bool `json:"require_memory_lock,omitempty"`
+
+	// Hooks run commands when matching items are created, changed or
+	// deleted.
+	Hooks []Hook `json:"hooks,omitempty"`
+}
+
+// AutoLock maps collection name patterns (path.Match syntax) to the number
+// of minutes without access after which a matching collection is locked.
+type AutoLock map[string]int
+
+// Period returns how long collection may go without access before it is
+// locked, or 0 if no pattern matches. If several patterns match, the
+// shortest period wins.
+func (a AutoLock) Period(collection string) time.Duration {
+	var period time.Duration
+	for pat, minutes := range a {
+		if ok, _ := path.Match(pat, collection); !ok {
+			continue
+		}
+		if d := time.Duration(minutes) * time.Minute; period == 0 || d < period {
+			period = d
+		}
+	}
+	return period
+}
+
+// validate rejects malformed patterns and non-positive periods.
+func (a AutoLock) validate() error {
+	for pat, minutes := range a {
+		if _, err := path.Match(pat, ""); err != nil {
+			return fmt.Errorf("auto_lock: invalid pattern %q: %w", pat, err)
+		}
+		if minutes <= 0 {
+			return fmt.Errorf("auto_lock: %q: minutes must be positive, got %d", pat, minutes)
+		}
+	}
+	return nil
+}
+
+// Persistence maps collection name patterns (path.Match syntax) to the
+// persistence scope of their Windows credentials: "session",
+// "local_machine" or "enterprise" (see ipc.PersistSession and friends).
+type Persistence map[string]string
+
+// Scope returns the persistence scope for collection, or "" if no pattern
+// matches. If several patterns match, the longest wins, so "work-*"
+// overrides "*".
+func (p Persistence) Scope(collection string) string {
+	var best, scope string
+	for pat, s := range p {
+		if ok, _ := path.Match(pat, collection); !ok {
+			continue
+		}
+		if scope == "" || len(pat) > len(best) || len(pat) == len(best) && pat < best {
+			best, scope = pat, s
+		}
+	}
+	return scope
+}
+
+// validate rejects malformed patterns and unknown scopes.
+func (p Persistence) validate() error {
+	for pat, s := range p {
+		if _, err := path.Match(pat, ""); err != nil {
+			return fmt.Errorf("persistence: invalid pattern %q: %w", pat, err)
+		}
+		if s == "" || !ipc.ValidPersist(s) {
+			return fmt.Errorf("persistence: %q: invalid scope %q (want %q, %q or %q)", pat, s, ipc.PersistSession, ipc.PersistLocalMachine, ipc.PersistEnterprise)
+		}
+	}
+	return nil
+}
+
+// Policy actions. Confirm is only valid in rules: it allows the request
+// but releases a secret only after the user approves it on the Windows
+// desktop.
+const (
+	Allow   = "allow"
+	Deny    = "deny"
+	Confirm = "confirm"
+)
+
+// Policy is an ordered list of access rules. The first rule matching a
+// request decides it; requests matching no rule get Default.
+type Policy struct {
+	// Default is Allow (the default) or Deny.
+	Default string `json:"default,omitempty"`
+	Rules   []Rule `json:"rules"`
+}
+
+// Rule allows or denies matching requests. Empty match fields match
+// anything; patterns use path.Match syntax ("*" does not cross "/").
+type Rule struct {
+	Action string `json:"action"` // Allow, Deny or Confirm
+
+	// Exe matches the caller's executable path (/proc/<pid>/exe).
+	Exe string `json:"exe,omitempty"`
+	// Collection matches the collection name.
+	Collection string `json:"collection,omitempty"`
+	// Attributes match item attributes; every listed attribute must be
+	// present and match. Rules with attributes never match
+	// collection-level requests.
+	Attributes map[string]string `json:"attributes,omitempty"`
+}
+
+// validate rejects unknown actions and malformed patterns.
+func (p *Policy) validate() error {
+	if p.Default != "" && p.Default != Allow && p.Default != Deny {
+		return fmt.Errorf("policy: invalid default %q (want %q or %q)", p.Default, Allow, Deny)
+	}
+	for i, r := range p.Rules {
+		if r.Action != Allow && r.Action != Deny && r.Action != Confirm {
+			return fmt.Errorf("policy rule %d: invalid action %q (want %q, %q or %q)", i+1, r.Action, Allow, Deny, Confirm)
+		}
+		patterns := []string{r.Exe, r.Collection}
+		for _, v := range r.Attributes {
+			patterns = append(patterns, v)
+		}
+		for _, pat := range patterns {
+			if _, err := path.Match(pat, ""); err != nil {
+				return fmt.Errorf("policy rule %d: invalid pattern %q: %w", i+1, pat, err)
+			}
+		}
+	}
+	return nil
+}
+
+// Hook events.
+const (
+	HookCreated = "created"
+	HookChanged = "changed"
+	HookDeleted = "deleted"
+)
+
+// Hook runs Command when an item matching it is created, changed or
+// deleted, for example to restart a service after its token was rotated.
+// Empty match fields match anything; patterns use path.Match syntax.
+type Hook struct {
+	// Events lists the events to run on (HookCreated, ...); empty means
+	// all of them.
+	Events []string `json:"events,omitempty"`
+	// Collection matches the collection name.
+	Collection string `json:"collection,omitempty"`
+	// Attributes match item attributes; every listed attribute must be
+	// present and match.
+	Attributes map[string]string `json:"attributes,omitempty"`
+	// Command is the program, by absolute path, and its arguments. It is
+	// run without a shell.
+	Command []string `json:"command"`
+}
+
+// validateHooks rejects unknown events, malformed patterns and commands
+// that are missing or not given by absolute path.
+func validateHooks(hooks []Hook) error {
+	for i, h := range hooks {
+		for _, e := range h.Events {
+			if e != HookCreated && e != HookChanged && e != HookDeleted {
+				return fmt.Errorf("hook %d: invalid event %q (want %q, %q or %q)", i+1, e, HookCreated, HookChanged, HookDeleted)
+			}
+		}
+		patterns := []string{h.Collection}
+		for _, v := range h.Attributes {
+			patterns = append(patterns, v)
+		}
+		for _, pat := range patterns {
+			if _, err := path.Match(pat, ""); err != nil {
+				return fmt.Errorf("hook %d: invalid pattern %q: %w", i+1, pat, err)
+			}
+		}
+		if len(h.Command) == 0 || !filepath.IsAbs(h.Command[0]) {
+			return fmt.Errorf("hook %d: command must start with an absolute program path", i+1)
+		}
+	}
+	return nil
+}
+
+// Load reads config.json from configDir. A missing file yields an empty
+// Config; unknown keys are rejected so typos do not go unnoticed.
+func Load(configDir string) (*Config, error) {
+	path := filepath.Join(configDir, FileName)
+	f, err := os.Open(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return &Config{}, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("open config: %w", err)
+	}
+	defer f.Close()
+
+	var c Config
+	dec := json.NewDecoder(f)
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&c); err != nil {
+		return nil, fmt.Errorf("parse %s: %w", path, err)
+	}
+	if c.Policy != nil {
+		if err := c.Policy.validate(); err != nil {
+			return nil, fmt.Errorf("%s: %w", path, err)
+		}
+	}
+	if err := c.AutoLock.validate(); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	if err := c.Persistence.validate(); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	if err := validateHooks(c.Hooks); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return &c, nil
+}
@@ -0,0 +1,185 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestLoadMissing(t *testing.T) {
+	c, err := Load(t.TempDir())
+	if err != nil {
+		t.Fatalf("Load: %v", err)
+	}
+	if c.Backend != "" {
+		t.Errorf("Backend = %q, want empty", c.Backend)
+	}
+}
+
+func TestLoad(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, FileName), []byte(`{"backend": "file", "local_backend": "pass"}`), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	c, err := Load(dir)
+	if err != nil {
+		t.Fatalf("Load: %v", err)
+	}
+	if c.Backend != "file" {
+		t.Errorf("Backend = %q, want %q", c.Backend, "file")
+	}
+	if c.LocalBackend != "pass" {
+		t.Errorf("LocalBackend = %q, want %q", c.LocalBackend, "pass")
+	}
+}
+
+func TestLoadRejectsUnknownKeys(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, FileName), []byte(`{"backnd": "file"}`), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Load(dir); err == nil {
+		t.Error("expected error for unknown key")
+	}
+}
+
+func TestLoadPolicy(t *testing.T) {
+	dir := t.TempDir()
+	data := `{"policy": {"default": "deny", "rules": [
+		{"action": "allow", "exe": "/usr/bin/git*", "attributes": {"protocol": "https"}},
+		{"action": "deny", "collection": "login"},
+		{"action": "confirm", "attributes": {"service": "aws*"}}
+	]}}`
+	if err := os.WriteFile(filepath.Join(dir, FileName), []byte(data), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	c, err := Load(dir)
+	if err != nil {
+		t.Fatalf("Load: %v", err)
+	}
+	p := c.Policy
+	if p == nil || p.Default != Deny || len(p.Rules) != 3 {
+		t.Fatalf("Policy = %+v", p)
+	}
+	if r := p.Rules[0]; r.Action != Allow || r.Exe != "/usr/bin/git*" || r.Attributes["protocol"] != "https" {
+		t.Errorf("rule 1 = %+v", r)
+	}
+	if r := p.Rules[2]; r.Action != Confirm {
+		t.Errorf("rule 3 = %+v", r)
+	}
+}
+
+func TestLoadRejectsInvalidPolicy(t *testing.T) {
+	for _, data := range []string{
+		`{"policy": {"default": "maybe", "rules": []}}`,
+		`{"policy": {"default": "confirm", "rules": []}}`,
+		`{"policy": {"rules": [{"action": "permit"}]}}`,
+		`{"policy": {"rules": [{"action": "deny", "exe": "/usr/bin/["}]}}`,
+		`{"policy": {"rules": [{"action": "deny", "attributes": {"a": "[x"}}]}}`,
+	} {
+		dir := t.TempDir()
+		if err := os.WriteFile(filepath.Join(dir, FileName), []byte(data), 0o600); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := Load(dir); err == nil {
+			t.Errorf("Load(%s) succeeded, want error", data)
+		}
+	}
+}
+
+func TestLoadAutoLock(t *testing.T) {
+	dir := t.TempDir()
+	data := `{"auto_lock": {"work": 15, "*": 60}}`
+	if err := os.WriteFile(filepath.Join(dir, FileName), []byte(data), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	c, err := Load(dir)
+	if err != nil {
+		t.Fatalf("Load: %v", err)
+	}
+	if got := c.AutoLock.Period("work"); got != 15*time.Minute {
+		t.Errorf("Period(work) = %v, want 15m", got)
+	}
+	if got := c.AutoLock.Period("login"); got != time.Hour {
+		t.Errorf("Period(login) = %v, want 1h", got)
+	}
+	if got := AutoLock(nil).Period("login"); got != 0 {
+		t.Errorf("Period without auto_lock = %v, want 0", got)
+	}
+
+	for _, data := range []string{
+		`{"auto_lock": {"work": 0}}`,
+		`{"auto_lock": {"[": 5}}`,
+	} {
+		if err := os.WriteFile(filepath.Join(dir, FileName), []byte(data), 0o600); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := Load(dir); err == nil {
+			t.Errorf("Load(%s) succeeded, want error", data)
+		}
+	}
+}
+
+func TestLoadPersistence(t *testing.T) {
+	dir := t.TempDir()
+	data := `{"persistence": {"*": "enterprise", "tmp-*": "session", "tmp-build": "local_machine"}}`
+	if err := os.WriteFile(filepath.Join(dir, FileName), []byte(data), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	c, err := Load(dir)
+	if err != nil {
+		t.Fatalf("Load: %v", err)
+	}
+	for col, want := range map[string]string{"login": "enterprise", "tmp-x": "session", "tmp-build": "local_machine"} {
+		if got := c.Persistence.Scope(col); got != want {
+			t.Errorf("Scope(%s) = %q, want %q", col, got, want)
+		}
+	}
+	if got := Persistence(nil).Scope("login"); got != "" {
+		t.Errorf("Scope without persistence = %q, want \"\"", got)
+	}
+
+	for _, data := range []string{
+		`{"persistence": {"*": "roaming"}}`,
+		`{"persistence": {"[": "session"}}`,
+	} {
+		if err := os.WriteFile(filepath.Join(dir, FileName), []byte(data), 0o600); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := Load(dir); err == nil {
+			t.Errorf("Load(%s) succeeded, want error", data)
+		}
+	}
+}
+
+func TestLoadHooks(t *testing.T) {
+	dir := t.TempDir()
+	data := `{"hooks": [{"events": ["changed"], "attributes": {"service": "github"}, "command": ["/usr/bin/systemctl", "--user", "restart", "gh-sync"]}]}`
+	if err := os.WriteFile(filepath.Join(dir, FileName), []byte(data), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	c, err := Load(dir)
+	if err != nil {
+		t.Fatalf("Load: %v", err)
+	}
+	if len(c.Hooks) != 1 || c.Hooks[0].Command[0] != "/usr/bin/systemctl" || c.Hooks[0].Events[0] != HookChanged {
+		t.Errorf("Hooks = %+v", c.Hooks)
+	}
+
+	for _, data := range []string{
+		`{"hooks": [{"events": ["rotated"], "command": ["/bin/true"]}]}`,
+		`{"hooks": [{"collection": "[", "command": ["/bin/true"]}]}`,
+		`{"hooks": [{"command": ["true"]}]}`,
+		`{"hooks": [{"events": ["deleted"]}]}`,
+	} {
+		if err := os.WriteFile(filepath.Join(dir, FileName), []byte(data), 0o600); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := Load(dir); err == nil {
+			t.Errorf("Load(%s) succeeded, want error", data)
+		}
+	}
+}
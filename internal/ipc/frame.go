@@ -0,0 +1,173 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package ipc
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// From protocol version 3 on, requests and responses may be sent as
+// length-prefixed frames instead of JSON lines. A frame is
+//
+//	uint32 length of header | header | uint32 length of data | data
+//
+// with big-endian lengths. The header is the JSON Request or Response
+// without its Secret, which travels as raw bytes in data instead of base64.
+// A connection carries any number of frames in each direction, answered in
+// order. Helpers tell the two encodings apart message by message: headers
+// are limited to MaxHeaderSize, so a frame always starts with a zero byte,
+// which cannot start a JSON line.
+const (
+	MaxHeaderSize = 1 << 20
+	MaxDataSize   = 1 << 20
+)
+
+// WriteFrame writes header and data as one frame.
+func WriteFrame(w io.Writer, header any, data []byte) error {
+	h, err := json.Marshal(header)
+	if err != nil {
+		return fmt.Errorf("marshal frame header: %w", err)
+	}
+	if len(h) > MaxHeaderSize || len(data) > MaxDataSize {
+		return fmt.Errorf("frame too large (%d byte header, %d byte data)", len(h), len(data))
+	}
+	buf := make([]byte, 0, 8+len(h))
+	buf = binary.BigEndian.AppendUint32(buf, uint32(len(h)))
+	buf = append(buf, h...)
+	buf = binary.BigEndian.AppendUint32(buf, uint32(len(data)))
+	if _, err := w.Write(buf); err != nil {
+		return err
+	}
+	_, err = w.Write(data)
+	return err
+}
+
+// ReadFrame reads one frame, decoding its header into header. The data is
+// read into a buffer from alloc (make if nil), so that secrets can go
+// straight into locked memory. It returns io.EOF if r ends before a frame.
+func ReadFrame(r io.Reader, header any, alloc func(n int) ([]byte, error)) ([]byte, error) {
+	hlen, err := readLength(r, MaxHeaderSize)
+	if err != nil {
+		return nil, err
+	}
+	h := make([]byte, hlen)
+	if _, err := io.ReadFull(r, h); err != nil {
+		return nil, unexpected(err)
+	}
+	if err := json.Unmarshal(h, header); err != nil {
+		return nil, fmt.Errorf("decode frame header: %w", err)
+	}
+	dlen, err := readLength(r, MaxDataSize)
+	if err != nil {
+		return nil, unexpected(err)
+	}
+	if dlen == 0 {
+		return nil, nil
+	}
+	var data []byte
+	if alloc != nil {
+		if data, err = alloc(dlen); err != nil {
+			return nil, fmt.Errorf("allocate frame data: %w", err)
+		}
+	} else {
+		data = make([]byte, dlen)
+	}
+	if _, err := io.ReadFull(r, data); err != nil {
+		clear(data)
+		return nil, unexpected(err)
+	}
+	return data, nil
+}
+
+func readLength(r io.Reader, max int) (int, error) {
+	var b [4]byte
+	if _, err := io.ReadFull(r, b[:]); err != nil {
+		return 0, err
+	}
+	n := binary.BigEndian.Uint32(b[:])
+	if n > uint32(max) {
+		return 0, fmt.Errorf("frame length %d exceeds %d", n, max)
+	}
+	return int(n), nil
+}
+
+// unexpected turns an EOF in the middle of a frame into an error.
+func unexpected(err error) error {
+	if errors.Is(err, io.EOF) {
+		return io.ErrUnexpectedEOF
+	}
+	return err
+}
+
+// ServerConn is the helper side of a connection. It accepts JSON lines as
+// well as frames and answers each request in the encoding it came in. In
+// both cases Request.Secret and Response.Secret hold base64, so handlers
+// need not care about the encoding.
+type ServerConn struct {
+	r      *bufio.Reader
+	w      io.Writer
+	framed bool // encoding of the last request
+}
+
+// NewServerConn returns a connection reading requests from r and writing
+// responses to w.
+func NewServerConn(r io.Reader, w io.Writer) *ServerConn {
+	return &ServerConn{r: bufio.NewReader(r), w: w}
+}
+
+// ReadRequest reads the next request. It returns io.EOF once the client has
+// closed the connection.
+func (c *ServerConn) ReadRequest() (Request, error) {
+	var req Request
+	for {
+		first, err := c.r.Peek(1)
+		if err != nil {
+			return req, err
+		}
+		c.framed = first[0] == 0
+		if c.framed {
+			data, err := ReadFrame(c.r, &req, nil)
+			if err != nil {
+				return req, err
+			}
+			if data != nil {
+				req.Secret = base64.StdEncoding.EncodeToString(data)
+				clear(data)
+			}
+			return req, nil
+		}
+		line, err := c.r.ReadBytes('\n')
+		if err != nil && (!errors.Is(err, io.EOF) || len(line) == 0) {
+			return req, err
+		}
+		line = bytes.TrimSpace(line)
+		if len(line) == 0 {
+			continue
+		}
+		if err := json.Unmarshal(line, &req); err != nil {
+			return req, fmt.Errorf("decode request: %w", err)
+		}
+		return req, nil
+	}
+}
+
+// WriteResponse writes resp in the encoding of the last request.
+func (c *ServerConn) WriteResponse(resp Response) error {
+	if !c.framed {
+		return json.NewEncoder(c.w).Encode(resp)
+	}
+	data, err := base64.StdEncoding.DecodeString(resp.Secret)
+	if err != nil {
+		return fmt.Errorf("decode response secret: %w", err)
+	}
+	defer clear(data)
+	resp.Secret = ""
+	return WriteFrame(c.w, resp, data)
+}
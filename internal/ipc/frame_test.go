@@ -0,0 +1,154 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package ipc
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"io"
+	"testing"
+)
+
+func TestFrameRoundTrip(t *testing.T) {
+	var buf bytes.Buffer
+	secret := []byte("a\nb\x00c")
+	if err := WriteFrame(&buf, Request{Action: "set", Target: "t"}, secret); err != nil {
+		t.Fatalf("WriteFrame: %v", err)
+	}
+	if err := WriteFrame(&buf, Request{Action: "list"}, nil); err != nil {
+		t.Fatalf("WriteFrame: %v", err)
+	}
+	if buf.Bytes()[0] != 0 {
+		t.Errorf("frame starts with %#x, want 0", buf.Bytes()[0])
+	}
+
+	var req Request
+	data, err := ReadFrame(&buf, &req, nil)
+	if err != nil || req.Action != "set" || req.Target != "t" || !bytes.Equal(data, secret) {
+		t.Fatalf("ReadFrame = %+v, %q, %v", req, data, err)
+	}
+	data, err = ReadFrame(&buf, &req, nil)
+	if err != nil || req.Action != "list" || data != nil {
+		t.Fatalf("ReadFrame = %+v, %q, %v", req, data, err)
+	}
+	if _, err := ReadFrame(&buf, &req, nil); err != io.EOF {
+		t.Errorf("ReadFrame at end = %v, want io.EOF", err)
+	}
+}
+
+func TestReadFrameTruncated(t *testing.T) {
+	var buf bytes.Buffer
+	if err := WriteFrame(&buf, Request{Action: "get"}, []byte("secret")); err != nil {
+		t.Fatal(err)
+	}
+	short := bytes.NewReader(buf.Bytes()[:buf.Len()-2])
+	var req Request
+	if _, err := ReadFrame(short, &req, nil); !errors.Is(err, io.ErrUnexpectedEOF) {
+		t.Errorf("ReadFrame of a truncated frame = %v, want io.ErrUnexpectedEOF", err)
+	}
+}
+
+// TestServerConnMixedEncodings checks that a connection answers each
+// request in its own encoding, as happens when the daemon sends "hello" as
+// a JSON line and then switches to frames.
+func TestServerConnMixedEncodings(t *testing.T) {
+	var in bytes.Buffer
+	in.WriteString(`{"action":"hello"}` + "\n")
+	if err := WriteFrame(&in, Request{Action: "set"}, []byte("raw\n")); err != nil {
+		t.Fatal(err)
+	}
+	var out bytes.Buffer
+	c := NewServerConn(&in, &out)
+
+	req, err := c.ReadRequest()
+	if err != nil || req.Action != "hello" {
+		t.Fatalf("ReadRequest = %+v, %v", req, err)
+	}
+	if err := c.WriteResponse(Hello("test")); err != nil {
+		t.Fatal(err)
+	}
+	var hello Response
+	line, _ := out.ReadBytes('\n')
+	if err := json.Unmarshal(line, &hello); err != nil || hello.Version != ProtocolVersion {
+		t.Fatalf("hello response %q: %v", line, err)
+	}
+
+	req, err = c.ReadRequest()
+	if err != nil || req.Action != "set" || req.Secret != base64.StdEncoding.EncodeToString([]byte("raw\n")) {
+		t.Fatalf("ReadRequest = %+v, %v", req, err)
+	}
+	if err := c.WriteResponse(Response{OK: true, Secret: req.Secret}); err != nil {
+		t.Fatal(err)
+	}
+	var resp Response
+	data, err := ReadFrame(&out, &resp, nil)
+	if err != nil || !resp.OK || resp.Secret != "" || string(data) != "raw\n" {
+		t.Fatalf("framed response = %+v, %q, %v", resp, data, err)
+	}
+
+	if _, err := c.ReadRequest(); err != io.EOF {
+		t.Errorf("ReadRequest at end = %v, want io.EOF", err)
+	}
+}
+
+// FuzzReadFrame checks that ReadFrame, which the daemon uses to decode the
+// helper's responses, fails cleanly on any input and that whatever it
+// accepts survives another WriteFrame/ReadFrame round.
+func FuzzReadFrame(f *testing.F) {
+	for _, resp := range []Response{
+		{OK: true},
+		{OK: true, Targets: []string{"wsl-ss/login/a"}},
+		{OK: true, Entries: []Entry{{Target: "wsl-ss/login/a", Attributes: map[string]string{"k": "v"}}}},
+		{Error: "Element not found.", Code: 1168},
+		Hello("test"),
+	} {
+		var buf bytes.Buffer
+		if err := WriteFrame(&buf, resp, []byte("secret")); err != nil {
+			f.Fatal(err)
+		}
+		f.Add(buf.Bytes())
+	}
+	f.Add([]byte{0, 0, 0, 2, '{', '}', 0xff, 0xff, 0xff, 0xff})
+	f.Fuzz(func(t *testing.T, in []byte) {
+		var resp Response
+		data, err := ReadFrame(bytes.NewReader(in), &resp, nil)
+		if err != nil {
+			return
+		}
+		var buf bytes.Buffer
+		if err := WriteFrame(&buf, resp, data); err != nil {
+			t.Fatalf("WriteFrame of a decoded frame: %v", err)
+		}
+		var again Response
+		data2, err := ReadFrame(&buf, &again, nil)
+		if err != nil || !bytes.Equal(data, data2) {
+			t.Fatalf("ReadFrame of a re-encoded frame = %q, %v, want %q", data2, err, data)
+		}
+	})
+}
+
+// FuzzReadRequest feeds arbitrary input, mixing JSON lines and frames, to
+// the helper side of a connection, which must return an error rather than
+// panic or hang.
+func FuzzReadRequest(f *testing.F) {
+	var framed bytes.Buffer
+	if err := WriteFrame(&framed, Request{Action: "set", Target: "wsl-ss/login/a"}, []byte("pw")); err != nil {
+		f.Fatal(err)
+	}
+	f.Add(framed.Bytes())
+	f.Add([]byte(`{"action":"hello","version":3}` + "\n" + `{"action":"get","target":"t"}`))
+	f.Add([]byte("\n\n{\"action\":\"list\",\"filter\":\"wsl-ss/*\",\"details\":true}\n"))
+	f.Add(append([]byte(`{"action":"hello"}`+"\n"), framed.Bytes()...))
+	f.Fuzz(func(t *testing.T, in []byte) {
+		c := NewServerConn(bytes.NewReader(in), io.Discard)
+		// Each request consumes at least one byte.
+		for range len(in) + 1 {
+			if _, err := c.ReadRequest(); err != nil {
+				return
+			}
+		}
+		t.Fatal("ReadRequest did not reach the end of the input")
+	})
+}
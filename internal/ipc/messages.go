@@ -2,18 +2,158 @@
 
 package ipc
 
+import (
+	"fmt"
+	"time"
+)
+
+// ProtocolVersion is the version of the request/response protocol spoken
+// by this build. It is increased whenever a change would make an older
+// helper misbehave. Helpers predating versioning do not know the "hello"
+// action and count as version 1. Version 3 added length-prefixed frames
+// (see WriteFrame), version 4 Request.Persist, version 5 Request.Type and
+// version 6 the --watch mode (see Event).
+const ProtocolVersion = 6
+
+// FramedProtocolVersion is the first protocol version with frames.
+const FramedProtocolVersion = 3
+
+// PersistProtocolVersion is the first protocol version honouring
+// Request.Persist; older helpers store every credential with
+// PersistLocalMachine.
+const PersistProtocolVersion = 4
+
+// CredTypeProtocolVersion is the first protocol version honouring
+// Request.Type; older helpers only reach generic credentials.
+const CredTypeProtocolVersion = 5
+
+// MinProtocolVersion is the oldest helper protocol the daemon still talks
+// to. Version 2 helpers only understand JSON lines.
+const MinProtocolVersion = 2
+
 // Request is the JSON message sent to wincred-helper.exe on stdin.
 type Request struct {
-	Action string `json:"action"`           // "get", "set", "delete", "list"
-	Target string `json:"target"`           // credential target name
-	Secret string `json:"secret,omitempty"` // base64-encoded secret for "set"
-	Filter string `json:"filter,omitempty"` // prefix filter for "list"
+	Action  string `json:"action"`            // "hello", "get", "set", "delete", "list", "verify", "confirm", "password", "protect", "unprotect"
+	Target  string `json:"target"`            // credential target name, or the collection for "password"
+	Secret  string `json:"secret,omitempty"`  // base64-encoded secret for "set", or data for "protect"/"unprotect"
+	Filter  string `json:"filter,omitempty"`  // prefix filter for "list"
+	Details bool   `json:"details,omitempty"` // "list" also returns Entries
+	Message string `json:"message,omitempty"` // text shown in the dialog for "verify", "confirm" and "password"
+	Version int    `json:"version,omitempty"` // ProtocolVersion of the sender
+
+	// DaemonVersion is the daemon's release version, sent with "hello" so
+	// that a helper rejecting it can say which daemon it does not fit.
+	DaemonVersion string `json:"daemon_version,omitempty"`
+
+	// Token is the secret a resident helper (wincred-helper --listen-vsock)
+	// writes to its token file when it starts. The daemon sends it with the
+	// "hello" that opens each connection; the helper serves no request on a
+	// connection before it has received it.
+	Token string `json:"token,omitempty"`
+
+	// Descriptive data stored with the credential by "set": the item label
+	// as its Comment and the attributes as its CRED_ATTRIBUTEs. Older
+	// helpers ignore them.
+	Comment    string            `json:"comment,omitempty"`
+	Attributes map[string]string `json:"attributes,omitempty"`
+
+	// Persist is the persistence scope of the credential written by "set",
+	// one of the Persist constants ("" = PersistLocalMachine).
+	Persist string `json:"persist,omitempty"`
+
+	// Type is the credential type "get", "set", "delete" and "list" work
+	// on, one of the Cred constants ("" = CredGeneric).
+	Type string `json:"type,omitempty"`
+
+	// Window is the title of the window the "verify", "confirm" or
+	// "password" dialog belongs to: the client's window as shown on the
+	// Windows desktop under WSLg. The helper makes the dialog its owned
+	// window if it finds it. Older helpers ignore it.
+	Window string `json:"window,omitempty"`
 }
 
+// Credential types (CRED_TYPE_* in wincred.h). The password of a
+// CredDomainPassword credential is used by Windows to log on to the target
+// and can be written but not read back by applications.
+const (
+	CredGeneric        = "generic"
+	CredDomainPassword = "domain_password"
+)
+
+// ValidCredType reports whether s is a credential type, or "" for the
+// default.
+func ValidCredType(s string) bool {
+	return s == "" || s == CredGeneric || s == CredDomainPassword
+}
+
+// Persistence scopes of a credential, see CRED_PERSIST_* in wincred.h:
+// PersistSession lasts until the Windows user logs off, PersistLocalMachine
+// stays on this machine, and PersistEnterprise roams with the user's
+// profile in a domain.
+const (
+	PersistSession      = "session"
+	PersistLocalMachine = "local_machine"
+	PersistEnterprise   = "enterprise"
+)
+
+// ValidPersist reports whether s is a persistence scope, or "" for the
+// default.
+func ValidPersist(s string) bool {
+	return s == "" || s == PersistSession || s == PersistLocalMachine || s == PersistEnterprise
+}
+
+// MaxSecretSize (CRED_MAX_CREDENTIAL_BLOB_SIZE, in bytes) and MaxTargetLen
+// (CRED_MAX_GENERIC_TARGET_NAME_LENGTH, in UTF-16 code units) are the
+// Credential Manager's limits on a credential, which the helper cannot
+// exceed.
+const (
+	MaxSecretSize = 2560
+	MaxTargetLen  = 32767
+)
+
+// CollectionAttribute is the credential attribute holding the name of the
+// collection an item belongs to, next to the item's own attributes.
+const CollectionAttribute = "wsl-ss:collection"
+
 // Response is the JSON message received from wincred-helper.exe on stdout.
 type Response struct {
 	OK      bool     `json:"ok"`
-	Secret  string   `json:"secret,omitempty"`  // base64-encoded secret for "get"
+	Secret  string   `json:"secret,omitempty"`  // base64-encoded secret for "get", the entered "password", or the "protect"/"unprotect" result
 	Targets []string `json:"targets,omitempty"` // for "list"
+	Entries []Entry  `json:"entries,omitempty"` // for "list" with Details; older helpers leave it empty
 	Error   string   `json:"error,omitempty"`
+	Code    uint32   `json:"code,omitempty"` // Windows error code behind Error, if known
+
+	// Set by "hello": the helper's ProtocolVersion and release version.
+	Version       int    `json:"version,omitempty"`
+	HelperVersion string `json:"helper_version,omitempty"`
+}
+
+// Entry describes one credential listed with Request.Details: its target,
+// user name and time of the last change, and the comment and attributes
+// stored by "set". Older helpers leave UserName and LastWritten empty.
+type Entry struct {
+	Target      string            `json:"target"`
+	UserName    string            `json:"user_name,omitempty"`
+	LastWritten time.Time         `json:"last_written,omitzero"`
+	Comment     string            `json:"comment,omitempty"`
+	Attributes  map[string]string `json:"attributes,omitempty"`
+}
+
+// CheckRequestVersion returns an error response if req was sent by a newer
+// daemon than the helper understands, for helpers to return as is.
+func CheckRequestVersion(req Request) (Response, bool) {
+	if req.Version > ProtocolVersion {
+		daemon := "daemon"
+		if req.DaemonVersion != "" {
+			daemon += " " + req.DaemonVersion
+		}
+		return Response{Error: fmt.Sprintf("helper speaks protocol v%d, %s needs v%d: update wincred-helper.exe", ProtocolVersion, daemon, req.Version)}, false
+	}
+	return Response{}, true
+}
+
+// Hello returns the response to a "hello" request.
+func Hello(helperVersion string) Response {
+	return Response{OK: true, Version: ProtocolVersion, HelperVersion: helperVersion}
 }
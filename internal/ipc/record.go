@@ -0,0 +1,48 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package ipc
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"time"
+)
+
+// Record is one request to the helper and its outcome, as written, one
+// JSON object per line, by a daemon recording its helper traffic and
+// served again by mock-wincred-helper --replay.
+//
+// Secrets are not recorded: the Secret fields of Request and Response are
+// empty and only their decoded lengths are kept, so that a recording can
+// be attached to a bug report. Targets, comments and attributes are kept.
+type Record struct {
+	Time     time.Time `json:"time"`
+	Request  Request   `json:"request"`
+	Response *Response `json:"response,omitempty"`
+	// Error is set instead of Response when the exchange failed as a
+	// whole, e.g. because the helper could not be started or exited.
+	Error string `json:"error,omitempty"`
+
+	RequestSecretLen  int `json:"request_secret_len,omitempty"`
+	ResponseSecretLen int `json:"response_secret_len,omitempty"`
+}
+
+// ReadRecords reads the Records of a recording.
+func ReadRecords(r io.Reader) ([]Record, error) {
+	var records []Record
+	sc := bufio.NewScanner(r)
+	sc.Buffer(nil, 16<<20)
+	for line := 1; sc.Scan(); line++ {
+		if len(sc.Bytes()) == 0 {
+			continue
+		}
+		var rec Record
+		if err := json.Unmarshal(sc.Bytes(), &rec); err != nil {
+			return nil, fmt.Errorf("line %d: %w", line, err)
+		}
+		records = append(records, rec)
+	}
+	return records, sc.Err()
+}
@@ -0,0 +1,90 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package ipc
+
+import (
+	"encoding/json"
+	"io"
+	"maps"
+	"slices"
+	"time"
+)
+
+// WatchProtocolVersion is the first protocol version whose helpers can be
+// started with --watch.
+const WatchProtocolVersion = 6
+
+// Event is written as a JSON line by "wincred-helper.exe --watch <prefix>"
+// for every credential under prefix that was created, changed or deleted.
+// The Credential Manager has no change notifications, so the helper polls
+// it.
+type Event struct {
+	Kind   string `json:"kind"` // EventCreated, EventChanged or EventDeleted
+	Target string `json:"target"`
+}
+
+// Kinds of Event.
+const (
+	EventCreated = "created"
+	EventChanged = "changed"
+	EventDeleted = "deleted"
+)
+
+// DiffCredentials returns the events that turn the credentials in old into
+// those in cur, both mapping target names to their last write time, in
+// target order.
+func DiffCredentials(old, cur map[string]time.Time) []Event {
+	var events []Event
+	for _, t := range slices.Sorted(maps.Keys(cur)) {
+		prev, ok := old[t]
+		switch {
+		case !ok:
+			events = append(events, Event{Kind: EventCreated, Target: t})
+		case !prev.Equal(cur[t]):
+			events = append(events, Event{Kind: EventChanged, Target: t})
+		}
+	}
+	for _, t := range slices.Sorted(maps.Keys(old)) {
+		if _, ok := cur[t]; !ok {
+			events = append(events, Event{Kind: EventDeleted, Target: t})
+		}
+	}
+	return events
+}
+
+// Watch implements the helpers' --watch mode: it calls snapshot every
+// interval and writes the events between consecutive snapshots to w as JSON
+// lines. It returns when stdin reaches EOF, which is how the daemon stops
+// the helper, or when snapshot or a write fails.
+func Watch(w io.Writer, stdin io.Reader, interval time.Duration, snapshot func() (map[string]time.Time, error)) error {
+	done := make(chan struct{})
+	go func() {
+		_, _ = io.Copy(io.Discard, stdin)
+		close(done)
+	}()
+
+	prev, err := snapshot()
+	if err != nil {
+		return err
+	}
+	enc := json.NewEncoder(w)
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-done:
+			return nil
+		case <-ticker.C:
+		}
+		cur, err := snapshot()
+		if err != nil {
+			return err
+		}
+		for _, e := range DiffCredentials(prev, cur) {
+			if err := enc.Encode(e); err != nil {
+				return err
+			}
+		}
+		prev = cur
+	}
+}
@@ -0,0 +1,26 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package ipc
+
+import (
+	"slices"
+	"testing"
+	"time"
+)
+
+func TestDiffCredentials(t *testing.T) {
+	t0 := time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC)
+	old := map[string]time.Time{"a": t0, "b": t0, "c": t0}
+	cur := map[string]time.Time{"a": t0, "b": t0.Add(time.Second), "d": t0}
+	want := []Event{
+		{Kind: EventChanged, Target: "b"},
+		{Kind: EventCreated, Target: "d"},
+		{Kind: EventDeleted, Target: "c"},
+	}
+	if got := DiffCredentials(old, cur); !slices.Equal(got, want) {
+		t.Errorf("DiffCredentials = %v, want %v", got, want)
+	}
+	if got := DiffCredentials(cur, cur); len(got) != 0 {
+		t.Errorf("DiffCredentials of equal snapshots = %v", got)
+	}
+}
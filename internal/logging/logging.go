@@ -0,0 +1,173 @@
+// SPDX-License-Identifier: Apache-2.0
+
+// Package logging configures the daemon's structured (log/slog) logging.
+//
+// Each subsystem obtains its logger with For, typically in a package-level
+// variable. Such loggers resolve the output handler and level at log time,
+// so they may be created before Setup runs, and their records carry a
+// "subsystem" attribute that --log-level can target individually:
+//
+//	--log-level debug              everything at debug
+//	--log-level warn,store=debug   warnings, but debug detail for the store
+package logging
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"math"
+	"os"
+	"strings"
+	"sync"
+)
+
+// Subsystem names used with For.
+const (
+	Main       = "main"
+	Service    = "service"
+	Store      = "store"
+	Backend    = "backend"
+	Memprotect = "memprotect"
+	SSHAgent   = "sshagent"
+	Notify     = "notify"
+	DBus       = "dbus" // D-Bus method calls, logged with --trace
+)
+
+// Output formats accepted by Setup.
+const (
+	FormatText = "text"
+	FormatJSON = "json"
+)
+
+// SubsystemKey is the attribute naming the subsystem of a record.
+const SubsystemKey = "subsystem"
+
+// config is the state installed by Setup. Before Setup, records go to
+// fallback at info level.
+var config struct {
+	sync.RWMutex
+	handler    slog.Handler // output handler; nil before Setup
+	def        slog.Level
+	subsystems map[string]slog.Level
+}
+
+// fallback is used before Setup: plain text on stderr.
+var fallback slog.Handler = slog.NewTextHandler(os.Stderr, nil)
+
+// Setup installs the default slog logger writing to w in the given format,
+// filtered according to spec ("<level>[,<subsystem>=<level>...]"). Output of
+// the standard log package is routed through it as well.
+func Setup(w io.Writer, spec, format string) error {
+	def, subs, err := ParseLevels(spec)
+	if err != nil {
+		return err
+	}
+	// The handler lets everything through; filtering happens per subsystem
+	// in subsystemHandler.Enabled.
+	opts := &slog.HandlerOptions{Level: slog.Level(math.MinInt)}
+	if _, ok := os.LookupEnv("JOURNAL_STREAM"); ok {
+		// journald timestamps every line itself.
+		opts.ReplaceAttr = func(groups []string, a slog.Attr) slog.Attr {
+			if len(groups) == 0 && a.Key == slog.TimeKey {
+				return slog.Attr{}
+			}
+			return a
+		}
+	}
+	var h slog.Handler
+	switch format {
+	case FormatText, "":
+		h = slog.NewTextHandler(w, opts)
+	case FormatJSON:
+		h = slog.NewJSONHandler(w, opts)
+	default:
+		return fmt.Errorf("invalid log format %q (want %q or %q)", format, FormatText, FormatJSON)
+	}
+
+	config.Lock()
+	config.handler, config.def, config.subsystems = h, def, subs
+	config.Unlock()
+	slog.SetDefault(For(Main))
+	return nil
+}
+
+// ParseLevels parses a --log-level spec: a default level optionally
+// followed by comma-separated subsystem=level overrides. Levels are the
+// slog names (debug, info, warn, error). An empty spec means info.
+func ParseLevels(spec string) (slog.Level, map[string]slog.Level, error) {
+	def := slog.LevelInfo
+	subs := map[string]slog.Level{}
+	for _, part := range strings.Split(spec, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		name, lvl, scoped := strings.Cut(part, "=")
+		if !scoped {
+			lvl = name
+		}
+		var l slog.Level
+		if err := l.UnmarshalText([]byte(lvl)); err != nil {
+			return 0, nil, fmt.Errorf("invalid log level %q: %w", part, err)
+		}
+		if scoped {
+			subs[name] = l
+		} else {
+			def = l
+		}
+	}
+	return def, subs, nil
+}
+
+// For returns the logger of the named subsystem.
+func For(subsystem string) *slog.Logger {
+	return slog.New(&subsystemHandler{subsystem: subsystem}).With(SubsystemKey, subsystem)
+}
+
+// subsystemHandler filters records by the subsystem's level and forwards
+// them to the handler installed by Setup. Attributes and groups added with
+// With/WithGroup are replayed onto that handler when a record is handled.
+type subsystemHandler struct {
+	subsystem string
+	ops       []func(slog.Handler) slog.Handler
+}
+
+func (h *subsystemHandler) Enabled(ctx context.Context, l slog.Level) bool {
+	config.RLock()
+	defer config.RUnlock()
+	if config.handler == nil {
+		return fallback.Enabled(ctx, l)
+	}
+	if lvl, ok := config.subsystems[h.subsystem]; ok {
+		return l >= lvl
+	}
+	return l >= config.def
+}
+
+func (h *subsystemHandler) Handle(ctx context.Context, r slog.Record) error {
+	config.RLock()
+	t := config.handler
+	config.RUnlock()
+	if t == nil {
+		t = fallback
+	}
+	for _, op := range h.ops {
+		t = op(t)
+	}
+	return t.Handle(ctx, r)
+}
+
+func (h *subsystemHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return h.with(func(t slog.Handler) slog.Handler { return t.WithAttrs(attrs) })
+}
+
+func (h *subsystemHandler) WithGroup(name string) slog.Handler {
+	return h.with(func(t slog.Handler) slog.Handler { return t.WithGroup(name) })
+}
+
+func (h *subsystemHandler) with(op func(slog.Handler) slog.Handler) *subsystemHandler {
+	ops := make([]func(slog.Handler) slog.Handler, len(h.ops), len(h.ops)+1)
+	copy(ops, h.ops)
+	return &subsystemHandler{subsystem: h.subsystem, ops: append(ops, op)}
+}
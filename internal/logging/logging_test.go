@@ -0,0 +1,68 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package logging
+
+import (
+	"bytes"
+	"encoding/json"
+	"log/slog"
+	"strings"
+	"testing"
+)
+
+func TestParseLevels(t *testing.T) {
+	def, subs, err := ParseLevels("warn, store=debug,backend=error")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if def != slog.LevelWarn {
+		t.Errorf("default = %v, want WARN", def)
+	}
+	if subs[Store] != slog.LevelDebug || subs[Backend] != slog.LevelError {
+		t.Errorf("subsystems = %v", subs)
+	}
+
+	if def, _, err := ParseLevels(""); err != nil || def != slog.LevelInfo {
+		t.Errorf(`ParseLevels("") = %v, %v; want INFO`, def, err)
+	}
+	if _, _, err := ParseLevels("store=loud"); err == nil {
+		t.Error("expected error for invalid level")
+	}
+}
+
+func TestSubsystemLevels(t *testing.T) {
+	// Loggers created before Setup must pick up its configuration.
+	storeLog, serviceLog := For(Store), For(Service).With("path", "/x")
+
+	var buf bytes.Buffer
+	if err := Setup(&buf, "warn,store=debug", FormatJSON); err != nil {
+		t.Fatal(err)
+	}
+	storeLog.Debug("store detail")
+	serviceLog.Info("service chatter")
+	serviceLog.Warn("service warning")
+
+	var got []map[string]any
+	for _, line := range strings.Split(strings.TrimSpace(buf.String()), "\n") {
+		var rec map[string]any
+		if err := json.Unmarshal([]byte(line), &rec); err != nil {
+			t.Fatalf("invalid JSON %q: %v", line, err)
+		}
+		got = append(got, rec)
+	}
+	if len(got) != 2 {
+		t.Fatalf("got %d records, want 2:\n%s", len(got), buf.String())
+	}
+	if got[0]["msg"] != "store detail" || got[0][SubsystemKey] != Store {
+		t.Errorf("record 0 = %v", got[0])
+	}
+	if got[1]["msg"] != "service warning" || got[1][SubsystemKey] != Service || got[1]["path"] != "/x" {
+		t.Errorf("record 1 = %v", got[1])
+	}
+}
+
+func TestSetupInvalidFormat(t *testing.T) {
+	if err := Setup(&bytes.Buffer{}, "info", "xml"); err == nil {
+		t.Error("expected error for invalid format")
+	}
+}
@@ -0,0 +1,151 @@
+// SPDX-License-Identifier: Apache-2.0
+
+//go:build linux
+
+package memprotect
+
+import (
+	"fmt"
+	"unsafe"
+
+	"golang.org/x/sys/unix"
+)
+
+// The locked arena serves LockedAlloc requests of up to arenaChunkSize bytes
+// from mlocked chunks carved up by a buddy allocator, so that each small
+// secret costs a block of its own size instead of a whole locked page. This
+// keeps the locked footprint within the default RLIMIT_MEMLOCK of containers,
+// which mlocking the whole process rarely does.
+const (
+	arenaMinBlock  = 32        // smallest block, bytes
+	arenaChunkSize = 32 * 1024 // must be arenaMinBlock << (arenaOrders-1)
+	arenaOrders    = 11
+)
+
+// arenaChunk is one mlocked mapping of arenaChunkSize bytes. free holds the
+// offsets of free blocks per order (block size arenaMinBlock<<order), used
+// the order of each allocated block by offset.
+type arenaChunk struct {
+	mem  []byte
+	free [arenaOrders]map[int]struct{}
+	used map[int]int
+}
+
+// arena is guarded by lockedMu. Its first chunk is kept for the lifetime of
+// the process once mapped; later chunks are released when they become free.
+var arena []*arenaChunk
+
+func newArenaChunk() (*arenaChunk, error) {
+	mem, err := unix.Mmap(-1, 0, arenaChunkSize, unix.PROT_READ|unix.PROT_WRITE, unix.MAP_PRIVATE|unix.MAP_ANON)
+	if err != nil {
+		return nil, fmt.Errorf("mmap %d bytes: %w", arenaChunkSize, err)
+	}
+	if err := unix.Mlock(mem); err != nil {
+		_ = unix.Munmap(mem)
+		return nil, fmt.Errorf("mlock %d bytes: %w", arenaChunkSize, err)
+	}
+	_ = unix.Madvise(mem, unix.MADV_DONTDUMP)
+	c := &arenaChunk{mem: mem, used: make(map[int]int)}
+	for i := range c.free {
+		c.free[i] = make(map[int]struct{})
+	}
+	c.free[arenaOrders-1][0] = struct{}{}
+	lockedBytes.Add(arenaChunkSize)
+	return c, nil
+}
+
+// arenaOrder returns the order of the smallest block that holds n bytes.
+func arenaOrder(n int) int {
+	order := 0
+	for arenaMinBlock<<order < n {
+		order++
+	}
+	return order
+}
+
+// arenaAlloc returns a zeroed n-byte slice from the arena, mapping a new
+// chunk if no free block is large enough. n must be at most arenaChunkSize.
+// Caller must hold lockedMu.
+func arenaAlloc(n int) ([]byte, error) {
+	order := arenaOrder(n)
+	for _, c := range arena {
+		if b, ok := c.alloc(order, n); ok {
+			return b, nil
+		}
+	}
+	c, err := newArenaChunk()
+	if err != nil {
+		return nil, err
+	}
+	arena = append(arena, c)
+	b, _ := c.alloc(order, n)
+	return b, nil
+}
+
+// alloc takes a block of the given order, splitting a larger free block if
+// necessary, and returns its first n bytes.
+func (c *arenaChunk) alloc(order, n int) ([]byte, bool) {
+	k := order
+	for k < arenaOrders && len(c.free[k]) == 0 {
+		k++
+	}
+	if k == arenaOrders {
+		return nil, false
+	}
+	var off int
+	for off = range c.free[k] {
+		break
+	}
+	delete(c.free[k], off)
+	for ; k > order; k-- {
+		c.free[k-1][off+arenaMinBlock<<(k-1)] = struct{}{}
+	}
+	c.used[off] = order
+	return c.mem[off : off+n : off+n], true
+}
+
+// release returns the block at off to the free lists, merging it with its
+// buddy while that is free too, and reports whether the whole chunk is now
+// free.
+func (c *arenaChunk) release(off int) bool {
+	order := c.used[off]
+	delete(c.used, off)
+	for order < arenaOrders-1 {
+		buddy := off ^ (arenaMinBlock << order)
+		if _, ok := c.free[order][buddy]; !ok {
+			break
+		}
+		delete(c.free[order], buddy)
+		off = min(off, buddy)
+		order++
+	}
+	c.free[order][off] = struct{}{}
+	return order == arenaOrders-1
+}
+
+// arenaFree zeroes and releases b if it is an arena block and reports
+// whether it was. Caller must hold lockedMu.
+func arenaFree(b []byte) bool {
+	addr := uintptr(unsafe.Pointer(&b[0]))
+	for i, c := range arena {
+		base := uintptr(unsafe.Pointer(&c.mem[0]))
+		if addr < base || addr >= base+arenaChunkSize {
+			continue
+		}
+		off := int(addr - base)
+		order, ok := c.used[off]
+		if !ok {
+			// A slice into the middle of a block: its owner frees it.
+			return true
+		}
+		clear(c.mem[off : off+arenaMinBlock<<order])
+		if c.release(off) && i > 0 {
+			arena = append(arena[:i], arena[i+1:]...)
+			_ = unix.Munlock(c.mem)
+			_ = unix.Munmap(c.mem)
+			lockedBytes.Add(-arenaChunkSize)
+		}
+		return true
+	}
+	return false
+}
@@ -0,0 +1,69 @@
+// SPDX-License-Identifier: Apache-2.0
+
+//go:build linux
+
+package memprotect
+
+import (
+	"testing"
+)
+
+func TestLockedAllocArena(t *testing.T) {
+	base := LockedBytes()
+	var bufs [][]byte
+	for _, n := range []int{1, 16, 33, 100, 4096, 5000, arenaChunkSize / 2} {
+		b, err := LockedAlloc(n)
+		if err != nil {
+			t.Skipf("cannot lock memory: %v", err)
+		}
+		if len(b) != n || cap(b) != n {
+			t.Fatalf("LockedAlloc(%d): len %d cap %d", n, len(b), cap(b))
+		}
+		for i := range b {
+			if b[i] != 0 {
+				t.Fatalf("LockedAlloc(%d): byte %d not zeroed", n, i)
+			}
+			b[i] = 0xff
+		}
+		bufs = append(bufs, b)
+	}
+	// Blocks must not overlap: each still holds only its own fill.
+	for i, b := range bufs {
+		b[0] = byte(i)
+	}
+	for i, b := range bufs {
+		if b[0] != byte(i) {
+			t.Fatalf("buffer %d overwritten", i)
+		}
+	}
+	for _, b := range bufs {
+		Wipe(b)
+	}
+	// All blocks merged back: the first chunk stays, any other was released.
+	if got := LockedBytes() - base; got > arenaChunkSize {
+		t.Errorf("%d bytes still locked after wiping every buffer", got)
+	}
+	b, err := LockedAlloc(arenaChunkSize)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(arena) != 1 || &b[0] != &arena[0].mem[0] {
+		t.Errorf("a whole-chunk allocation did not reuse the merged first chunk")
+	}
+	Wipe(b)
+}
+
+func TestLockedAllocLarge(t *testing.T) {
+	base := LockedBytes()
+	b, err := LockedAlloc(arenaChunkSize + 1)
+	if err != nil {
+		t.Skipf("cannot lock memory: %v", err)
+	}
+	if got := LockedBytes() - base; got < arenaChunkSize+1 {
+		t.Errorf("LockedBytes grew by %d, want a dedicated mapping", got)
+	}
+	Wipe(b)
+	if got := LockedBytes(); got != base {
+		t.Errorf("LockedBytes = %d after Wipe, want %d", got, base)
+	}
+}
@@ -0,0 +1,91 @@
+// SPDX-License-Identifier: Apache-2.0
+
+//go:build linux
+
+package memprotect
+
+import (
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"unsafe"
+
+	"golang.org/x/sys/unix"
+)
+
+// lockedRegions records buffers handed out by LockedAlloc and SecretAlloc,
+// keyed by the address of their first byte, so that Wipe can tell them
+// apart from ordinary heap slices.
+var (
+	lockedMu      sync.Mutex
+	lockedRegions = make(map[uintptr][]byte)
+	lockedBytes   atomic.Int64
+)
+
+// LockedAlloc returns a zeroed n-byte slice of mlocked memory, so its
+// contents never reach swap. Buffers of up to arenaChunkSize bytes come from
+// the locked arena, larger ones from a private anonymous mapping of their
+// own. Either way the memory lives outside the Go heap; release it with Wipe
+// once the secret is no longer needed.
+func LockedAlloc(n int) ([]byte, error) {
+	if n <= 0 {
+		return []byte{}, nil
+	}
+	if n <= arenaChunkSize {
+		lockedMu.Lock()
+		defer lockedMu.Unlock()
+		return arenaAlloc(n)
+	}
+	size := (n + unix.Getpagesize() - 1) &^ (unix.Getpagesize() - 1)
+	mem, err := unix.Mmap(-1, 0, size, unix.PROT_READ|unix.PROT_WRITE, unix.MAP_PRIVATE|unix.MAP_ANON)
+	if err != nil {
+		return nil, fmt.Errorf("mmap %d bytes: %w", size, err)
+	}
+	if err := unix.Mlock(mem); err != nil {
+		_ = unix.Munmap(mem)
+		return nil, fmt.Errorf("mlock %d bytes: %w", size, err)
+	}
+	// Keep locked pages out of any core dump that might still be produced.
+	_ = unix.Madvise(mem, unix.MADV_DONTDUMP)
+
+	lockedMu.Lock()
+	lockedRegions[uintptr(unsafe.Pointer(&mem[0]))] = mem
+	lockedMu.Unlock()
+	lockedBytes.Add(int64(size))
+	return mem[:n:n], nil
+}
+
+// Wipe zeroes b. If b was returned by LockedAlloc or SecretAlloc, its
+// memory is also returned to the arena or unmapped; b must not be used
+// afterwards in that case.
+// It is safe to call Wipe on any slice, including nil.
+func Wipe(b []byte) {
+	if cap(b) == 0 {
+		return
+	}
+	b = b[:cap(b)]
+	clear(b)
+
+	lockedMu.Lock()
+	if arenaFree(b) {
+		lockedMu.Unlock()
+		return
+	}
+	mem, ok := lockedRegions[uintptr(unsafe.Pointer(&b[0]))]
+	if ok {
+		delete(lockedRegions, uintptr(unsafe.Pointer(&b[0])))
+	}
+	lockedMu.Unlock()
+	if !ok {
+		return
+	}
+	_ = unix.Munlock(mem)
+	_ = unix.Munmap(mem)
+	lockedBytes.Add(-int64(len(mem)))
+}
+
+// LockedBytes reports the total size of mappings currently held by
+// LockedAlloc and SecretAlloc.
+func LockedBytes() int64 {
+	return lockedBytes.Load()
+}
@@ -9,11 +9,55 @@ package memprotect
 
 import (
 	"fmt"
-	"log"
+	"sync/atomic"
 
 	"golang.org/x/sys/unix"
+
+	"github.com/akihiro/wsl-secret-service/internal/logging"
+)
+
+var logger = logging.For(logging.Memprotect)
+
+// LockMode describes how secret material is kept out of swap.
+type LockMode int32
+
+const (
+	// LockNone means no swap protection is active.
+	LockNone LockMode = iota
+	// LockArena means the buffers allocated via AllocSecret and LockedAlloc
+	// (session keys, plaintext secrets) come from the locked arena.
+	LockArena
 )
 
+func (m LockMode) String() string {
+	switch m {
+	case LockArena:
+		return "locked arena"
+	default:
+		return "none"
+	}
+}
+
+var lockMode atomic.Int32
+
+// CurrentLockMode reports the swap protection established by HardenProcess.
+func CurrentLockMode() LockMode {
+	return LockMode(lockMode.Load())
+}
+
+// AllocSecret returns a zeroed n-byte buffer for secret material. Under
+// LockArena the buffer is mlocked memory from LockedAlloc; otherwise (or if
+// RLIMIT_MEMLOCK is exhausted) it is an ordinary heap slice. Either way it
+// should be released with Wipe.
+func AllocSecret(n int) []byte {
+	if CurrentLockMode() == LockArena {
+		if b, err := LockedAlloc(n); err == nil {
+			return b
+		}
+	}
+	return make([]byte, n)
+}
+
 // HardenProcess applies two protections and must be called as early as
 // possible in main(), before any secret material is loaded.
 //
@@ -22,20 +66,41 @@ import (
 //     including processes running as the same UID.  It also prevents ptrace
 //     attachment by unprivileged peers.
 //
-//  2. mlockall(MCL_CURRENT|MCL_FUTURE) — pins all present and future memory
-//     pages in RAM so they are never written to swap, which would otherwise
-//     leave secret material on disk in plaintext.
+//  2. It raises the soft RLIMIT_MEMLOCK to the hard limit and maps the
+//     first chunk of the locked arena, from which the buffers holding
+//     secret material are allocated, so that they are never written to
+//     swap, which would otherwise leave secret material on disk in
+//     plaintext.  Unlike mlockall, which pins the whole Go heap, the arena
+//     fits the default RLIMIT_MEMLOCK of restricted containers.
 func HardenProcess() error {
 	if err := unix.Prctl(unix.PR_SET_DUMPABLE, 0, 0, 0, 0); err != nil {
 		return fmt.Errorf("prctl PR_SET_DUMPABLE=0: %w", err)
 	}
 
-	if err := unix.Mlockall(unix.MCL_CURRENT | unix.MCL_FUTURE); err != nil {
-		// mlockall may fail in restricted container environments or when
-		// RLIMIT_MEMLOCK is too small.  Log a warning rather than aborting
-		// so the service still runs with the dumpable protection active.
-		log.Printf("warning: mlockall failed (secrets may reach swap): %v", err)
+	raiseMemlockLimit()
+	probe, err := LockedAlloc(1)
+	if err != nil {
+		logger.Warn("cannot lock memory for secret material; secrets may reach swap", "err", err)
+		return nil
 	}
+	Wipe(probe)
+	lockMode.Store(int32(LockArena))
 
 	return nil
 }
+
+// raiseMemlockLimit lifts the soft RLIMIT_MEMLOCK to the hard limit, which
+// needs no privilege, so that the arena can grow as far as allowed.
+func raiseMemlockLimit() {
+	var lim unix.Rlimit
+	if err := unix.Getrlimit(unix.RLIMIT_MEMLOCK, &lim); err != nil || lim.Cur >= lim.Max {
+		return
+	}
+	old := lim.Cur
+	lim.Cur = lim.Max
+	if err := unix.Setrlimit(unix.RLIMIT_MEMLOCK, &lim); err != nil {
+		logger.Debug("raise RLIMIT_MEMLOCK", "err", err)
+		return
+	}
+	logger.Debug("raised RLIMIT_MEMLOCK to the hard limit", "from", old, "to", lim.Cur)
+}
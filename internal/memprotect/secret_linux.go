@@ -0,0 +1,63 @@
+// SPDX-License-Identifier: Apache-2.0
+
+//go:build linux
+
+package memprotect
+
+import (
+	"fmt"
+	"sync/atomic"
+	"unsafe"
+
+	"golang.org/x/sys/unix"
+)
+
+// secretMemUnavailable is set once the kernel has refused memfd_secret, so
+// that later allocations go straight to LockedAlloc.
+var secretMemUnavailable atomic.Bool
+
+// SecretAlloc returns a zeroed n-byte slice for key material. It is backed
+// by memfd_secret(2) memory where the kernel offers it: such pages are
+// removed from the kernel's direct map, so neither other processes nor
+// kernel code reading physical memory through it (e.g. /dev/mem, kcore or a
+// compromised driver) can see them, and they are never swapped. Otherwise
+// the buffer comes from LockedAlloc. Release it with Wipe.
+func SecretAlloc(n int) ([]byte, error) {
+	if n <= 0 {
+		return []byte{}, nil
+	}
+	if !secretMemUnavailable.Load() {
+		b, err := secretMemAlloc(n)
+		if err == nil {
+			return b, nil
+		}
+		logger.Debug("memfd_secret allocation failed, using mlocked memory", "err", err)
+	}
+	return LockedAlloc(n)
+}
+
+// secretMemAlloc maps n bytes, rounded up to whole pages, of memfd_secret
+// memory and registers the mapping for Wipe.
+func secretMemAlloc(n int) ([]byte, error) {
+	fd, _, errno := unix.Syscall(unix.SYS_MEMFD_SECRET, unix.O_CLOEXEC, 0, 0)
+	if errno != 0 {
+		secretMemUnavailable.Store(true)
+		return nil, fmt.Errorf("memfd_secret: %w", errno)
+	}
+	defer unix.Close(int(fd))
+	size := (n + unix.Getpagesize() - 1) &^ (unix.Getpagesize() - 1)
+	if err := unix.Ftruncate(int(fd), int64(size)); err != nil {
+		return nil, fmt.Errorf("ftruncate memfd_secret to %d bytes: %w", size, err)
+	}
+	mem, err := unix.Mmap(int(fd), 0, size, unix.PROT_READ|unix.PROT_WRITE, unix.MAP_SHARED)
+	if err != nil {
+		return nil, fmt.Errorf("mmap %d bytes of memfd_secret: %w", size, err)
+	}
+	// The pages are implicitly locked and charged to RLIMIT_MEMLOCK by the
+	// mmap above; mlock and MADV_POPULATE_WRITE are refused on them.
+	lockedMu.Lock()
+	lockedRegions[uintptr(unsafe.Pointer(&mem[0]))] = mem
+	lockedMu.Unlock()
+	lockedBytes.Add(int64(size))
+	return mem[:n:n], nil
+}
@@ -0,0 +1,116 @@
+// SPDX-License-Identifier: Apache-2.0
+
+//go:build linux
+
+package memprotect
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+
+	"golang.org/x/sys/unix"
+)
+
+// Status describes which memory protections are actually in effect for the
+// running process, as opposed to which ones were requested.
+type Status struct {
+	// Dumpable is true when core dumps and same-user ptrace are still allowed
+	// (i.e. PR_SET_DUMPABLE=0 is not in effect).
+	Dumpable bool
+	// LockMode is the swap protection established by HardenProcess.
+	LockMode LockMode
+	// Seccomp is the seccomp mode from /proc/self/status:
+	// "disabled", "strict", "filter" or "unknown".
+	Seccomp string
+	// LandlockABI is the Landlock ABI version offered by the kernel,
+	// or 0 if Landlock is unavailable.
+	LandlockABI int
+	// MemfdSecret reports whether memfd_secret(2) is usable on this kernel.
+	MemfdSecret bool
+	// MemlockLimit is the soft RLIMIT_MEMLOCK in bytes, unix.RLIM_INFINITY
+	// if unlimited.
+	MemlockLimit uint64
+}
+
+// QueryStatus inspects the current process and kernel and reports the
+// effective protections.
+func QueryStatus() Status {
+	st := Status{
+		LockMode: CurrentLockMode(),
+		Seccomp:  seccompMode(),
+	}
+	var lim unix.Rlimit
+	if err := unix.Getrlimit(unix.RLIMIT_MEMLOCK, &lim); err == nil {
+		st.MemlockLimit = lim.Cur
+	}
+	if d, err := unix.PrctlRetInt(unix.PR_GET_DUMPABLE, 0, 0, 0, 0); err != nil || d != 0 {
+		st.Dumpable = true
+	}
+	if abi, _, errno := unix.Syscall(unix.SYS_LANDLOCK_CREATE_RULESET, 0, 0, unix.LANDLOCK_CREATE_RULESET_VERSION); errno == 0 {
+		st.LandlockABI = int(abi)
+	}
+	if fd, _, errno := unix.Syscall(unix.SYS_MEMFD_SECRET, 0, 0, 0); errno == 0 {
+		_ = unix.Close(int(fd))
+		st.MemfdSecret = true
+	}
+	return st
+}
+
+// Map returns the status as string key/value pairs for D-Bus and status output.
+func (s Status) Map() map[string]string {
+	landlock := "unavailable"
+	if s.LandlockABI > 0 {
+		landlock = fmt.Sprintf("available (ABI v%d, not enforced)", s.LandlockABI)
+	}
+	memfd := "unavailable"
+	if s.MemfdSecret {
+		memfd = "available"
+	}
+	memlock := "unlimited"
+	if s.MemlockLimit != unix.RLIM_INFINITY {
+		memlock = strconv.FormatUint(s.MemlockLimit, 10)
+	}
+	return map[string]string{
+		"dumpable":      strconv.FormatBool(s.Dumpable),
+		"swap":          s.LockMode.String(),
+		"seccomp":       s.Seccomp,
+		"landlock":      landlock,
+		"memfd_secret":  memfd,
+		"memlock_limit": memlock,
+	}
+}
+
+// String formats the status as a single log line.
+func (s Status) String() string {
+	m := s.Map()
+	return fmt.Sprintf("dumpable=%s swap=%q seccomp=%s landlock=%q memfd_secret=%s memlock_limit=%s",
+		m["dumpable"], m["swap"], m["seccomp"], m["landlock"], m["memfd_secret"], m["memlock_limit"])
+}
+
+// seccompMode reads the Seccomp field from /proc/self/status.
+func seccompMode() string {
+	f, err := os.Open("/proc/self/status")
+	if err != nil {
+		return "unknown"
+	}
+	defer f.Close()
+	sc := bufio.NewScanner(f)
+	for sc.Scan() {
+		v, ok := strings.CutPrefix(sc.Text(), "Seccomp:")
+		if !ok {
+			continue
+		}
+		switch strings.TrimSpace(v) {
+		case "0":
+			return "disabled"
+		case "1":
+			return "strict"
+		case "2":
+			return "filter"
+		}
+	}
+	return "unknown"
+}
@@ -0,0 +1,188 @@
+// SPDX-License-Identifier: Apache-2.0
+
+// Package notify sends change events, for monitoring built around the
+// daemon, to a local HTTP endpoint (as a POST of one JSON object) or to a
+// FIFO (as one JSON object per line). Events are redacted: they name the
+// item, its collection and the operation, but carry no label, attributes
+// or secret.
+package notify
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+
+	"github.com/akihiro/wsl-secret-service/internal/logging"
+)
+
+var logger = logging.For(logging.Notify)
+
+// queueSize is how many events may wait to be delivered; more are dropped.
+const queueSize = 64
+
+// httpTimeout bounds each POST to the endpoint.
+const httpTimeout = 5 * time.Second
+
+// Event describes a change of an item.
+type Event struct {
+	Time       time.Time `json:"time"`
+	Op         string    `json:"op"` // "created", "changed" or "deleted"
+	Collection string    `json:"collection"`
+	Item       string    `json:"item"` // D-Bus object path
+}
+
+// Notifier delivers events in the background, in order. It is safe for
+// concurrent use.
+type Notifier struct {
+	dest    string
+	deliver func([]byte) error
+	queue   chan Event
+	done    chan struct{}
+	mu      sync.RWMutex // guards closed against closing queue under a Send
+	closed  bool
+	dropped atomic.Uint64
+	fifo    *os.File // open FIFO, nil until the first event
+}
+
+// Open returns a Notifier for dest: an http:// or https:// URL on a
+// loopback address, or the path of an existing FIFO.
+func Open(dest string) (*Notifier, error) {
+	n := &Notifier{dest: dest, queue: make(chan Event, queueSize), done: make(chan struct{})}
+	if strings.HasPrefix(dest, "http://") || strings.HasPrefix(dest, "https://") {
+		u, err := url.Parse(dest)
+		if err != nil {
+			return nil, fmt.Errorf("notify: %w", err)
+		}
+		if !loopback(u.Hostname()) {
+			return nil, fmt.Errorf("notify: %s is not a local address", u.Host)
+		}
+		client := &http.Client{Timeout: httpTimeout}
+		n.deliver = func(body []byte) error { return post(client, dest, body) }
+	} else {
+		fi, err := os.Stat(dest)
+		if err != nil {
+			return nil, fmt.Errorf("notify: %w", err)
+		}
+		if fi.Mode().Type() != fs.ModeNamedPipe {
+			return nil, fmt.Errorf("notify: %s is neither an http(s) URL nor a FIFO", dest)
+		}
+		n.deliver = n.writeFIFO
+	}
+	go n.run()
+	return n, nil
+}
+
+// loopback reports whether host names the local machine.
+func loopback(host string) bool {
+	if host == "localhost" {
+		return true
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
+}
+
+// Send queues e for delivery, filling in the time if unset. If the queue
+// is full, because the endpoint is slow or the FIFO has no reader, e is
+// dropped. After Close, events are discarded: the daemon's event sources
+// may still be winding down.
+func (n *Notifier) Send(e Event) {
+	if e.Time.IsZero() {
+		e.Time = time.Now()
+	}
+	n.mu.RLock()
+	defer n.mu.RUnlock()
+	if n.closed {
+		logger.Debug("notifier closed, event discarded", "op", e.Op, "item", e.Item)
+		return
+	}
+	select {
+	case n.queue <- e:
+	default:
+		if n.dropped.Add(1) == 1 {
+			logger.Warn("notification queue full, dropping events", "dest", n.dest)
+		}
+	}
+}
+
+// Dropped returns how many events were dropped.
+func (n *Notifier) Dropped() uint64 {
+	return n.dropped.Load()
+}
+
+// Close delivers the queued events and stops the Notifier. Later Sends
+// are ignored.
+func (n *Notifier) Close() error {
+	n.mu.Lock()
+	if n.closed {
+		n.mu.Unlock()
+		return nil
+	}
+	n.closed = true
+	close(n.queue)
+	n.mu.Unlock()
+	<-n.done
+	if n.fifo != nil {
+		return n.fifo.Close()
+	}
+	return nil
+}
+
+func (n *Notifier) run() {
+	defer close(n.done)
+	for e := range n.queue {
+		body, err := json.Marshal(e)
+		if err != nil {
+			continue
+		}
+		if err := n.deliver(body); err != nil {
+			logger.Warn("could not deliver notification", "dest", n.dest, "op", e.Op, "item", e.Item, "err", err)
+		}
+	}
+}
+
+func post(client *http.Client, dest string, body []byte) error {
+	resp, err := client.Post(dest, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	_ = resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		return fmt.Errorf("%s: %s", dest, resp.Status)
+	}
+	return nil
+}
+
+// writeFIFO writes body as one line to the FIFO, opening it if needed. It
+// waits for a reader only while the queue fills up: when nobody has the
+// FIFO open, the event is dropped.
+func (n *Notifier) writeFIFO(body []byte) error {
+	if n.fifo == nil {
+		f, err := os.OpenFile(n.dest, os.O_WRONLY|syscall.O_NONBLOCK, 0)
+		if err != nil {
+			if errors.Is(err, syscall.ENXIO) {
+				logger.Debug("no reader on notification FIFO, event dropped", "dest", n.dest)
+				return nil
+			}
+			return err
+		}
+		n.fifo = f
+	}
+	if _, err := n.fifo.Write(append(body, '\n')); err != nil {
+		// The reader went away; reopen for the next event.
+		_ = n.fifo.Close()
+		n.fifo = nil
+		return err
+	}
+	return nil
+}
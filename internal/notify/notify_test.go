@@ -0,0 +1,96 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package notify
+
+import (
+	"bufio"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+)
+
+func TestHTTP(t *testing.T) {
+	got := make(chan Event, 2)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var e Event
+		body, _ := io.ReadAll(r.Body)
+		if err := json.Unmarshal(body, &e); err != nil {
+			t.Errorf("bad event %q: %v", body, err)
+		}
+		got <- e
+	}))
+	defer srv.Close()
+
+	n, err := Open(srv.URL + "/events")
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	n.Send(Event{Op: "created", Collection: "login", Item: "/org/freedesktop/secrets/collection/login/a"})
+	n.Send(Event{Op: "deleted", Collection: "login", Item: "/org/freedesktop/secrets/collection/login/a"})
+	if err := n.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+	for _, op := range []string{"created", "deleted"} {
+		e := <-got
+		if e.Op != op || e.Collection != "login" || e.Time.IsZero() {
+			t.Errorf("event = %+v, want op %s", e, op)
+		}
+	}
+
+	// Events sent while the daemon shuts down are discarded.
+	n.Send(Event{Op: "changed", Collection: "login", Item: "/org/freedesktop/secrets/collection/login/a"})
+	if err := n.Close(); err != nil {
+		t.Errorf("second Close: %v", err)
+	}
+}
+
+func TestFIFO(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "events")
+	if err := syscall.Mkfifo(path, 0o600); err != nil {
+		t.Skipf("mkfifo: %v", err)
+	}
+	// Without a reader the event is dropped.
+	idle := &Notifier{dest: path}
+	if err := idle.writeFIFO([]byte(`{}`)); err != nil || idle.fifo != nil {
+		t.Fatalf("writeFIFO without reader = %v, want the event dropped", err)
+	}
+
+	r, err := os.OpenFile(path, os.O_RDONLY|syscall.O_NONBLOCK, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	n, err := Open(path)
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	n.Send(Event{Op: "changed", Item: "/b"})
+	if err := n.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+	line, err := bufio.NewReader(r).ReadBytes('\n')
+	if err != nil {
+		t.Fatalf("read FIFO: %v", err)
+	}
+	var e Event
+	if err := json.Unmarshal(line, &e); err != nil || e.Op != "changed" || e.Item != "/b" {
+		t.Errorf("event = %s (%v), want the changed event", line, err)
+	}
+}
+
+func TestOpenRejects(t *testing.T) {
+	regular := filepath.Join(t.TempDir(), "file")
+	if err := os.WriteFile(regular, nil, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	for _, dest := range []string{"http://example.com/events", "https://10.0.0.1/", regular, "/nonexistent/fifo"} {
+		if _, err := Open(dest); err == nil {
+			t.Errorf("Open(%s) succeeded, want error", dest)
+		}
+	}
+}
@@ -0,0 +1,51 @@
+// SPDX-License-Identifier: Apache-2.0
+
+// Package sdnotify implements the client side of the systemd service
+// notification protocol (sd_notify(3)) without linking libsystemd.
+package sdnotify
+
+import (
+	"net"
+	"os"
+	"strings"
+)
+
+// Well-known notification states.
+const (
+	Ready    = "READY=1"
+	Stopping = "STOPPING=1"
+)
+
+// Status returns a STATUS= line describing the service state.
+func Status(msg string) string {
+	return "STATUS=" + msg
+}
+
+// Notify sends the given newline-separated states to the service manager.
+// It reports false with a nil error when $NOTIFY_SOCKET is not set, i.e.
+// the process was not started by systemd with Type=notify.
+func Notify(states ...string) (bool, error) {
+	addr := os.Getenv("NOTIFY_SOCKET")
+	if addr == "" {
+		return false, nil
+	}
+	// A leading '@' denotes a socket in the abstract namespace.
+	if strings.HasPrefix(addr, "@") {
+		addr = "\x00" + addr[1:]
+	}
+	conn, err := net.DialUnix("unixgram", nil, &net.UnixAddr{Name: addr, Net: "unixgram"})
+	if err != nil {
+		return false, err
+	}
+	defer conn.Close()
+	if _, err := conn.Write([]byte(strings.Join(states, "\n"))); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// UnderSystemd reports whether the process was started by the systemd
+// service manager.
+func UnderSystemd() bool {
+	return os.Getenv("INVOCATION_ID") != "" || os.Getenv("NOTIFY_SOCKET") != ""
+}
@@ -0,0 +1,40 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package sdnotify
+
+import (
+	"net"
+	"path/filepath"
+	"testing"
+)
+
+func TestNotifyUnset(t *testing.T) {
+	t.Setenv("NOTIFY_SOCKET", "")
+	sent, err := Notify(Ready)
+	if sent || err != nil {
+		t.Errorf("Notify = %v, %v; want false, nil", sent, err)
+	}
+}
+
+func TestNotify(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "notify")
+	l, err := net.ListenUnixgram("unixgram", &net.UnixAddr{Name: path, Net: "unixgram"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	t.Setenv("NOTIFY_SOCKET", path)
+
+	sent, err := Notify(Ready, Status("serving"))
+	if !sent || err != nil {
+		t.Fatalf("Notify = %v, %v; want true, nil", sent, err)
+	}
+	buf := make([]byte, 256)
+	n, _, err := l.ReadFromUnix(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := string(buf[:n]); got != "READY=1\nSTATUS=serving" {
+		t.Errorf("received %q", got)
+	}
+}
@@ -0,0 +1,147 @@
+// SPDX-License-Identifier: Apache-2.0
+
+// Package secretcache keeps secrets for a short time in mlocked buffers
+// outside the Go heap, wiped when they expire or are removed. It backs
+// both --secret-cache-ttl in the service and the cache backend middleware.
+package secretcache
+
+import (
+	"sync"
+	"time"
+
+	"github.com/akihiro/wsl-secret-service/internal/memprotect"
+)
+
+// Cache maps backend targets to secrets. A nil *Cache is disabled: it
+// caches nothing.
+//
+// A secret read from the backend may be stale by the time it is put, if
+// the target was written meanwhile. Callers therefore take Version before
+// reading and pass it to Put, which drops the secret if any entry was
+// removed since; writers call Remove both before and after writing.
+type Cache struct {
+	mu      sync.Mutex
+	ttl     time.Duration
+	entries map[string]*entry
+	version uint64 // incremented by every Remove and Flush
+}
+
+type entry struct {
+	value []byte // from memprotect.LockedAlloc
+	timer *time.Timer
+}
+
+// New returns a cache keeping secrets for ttl, or nil if ttl is not
+// positive.
+func New(ttl time.Duration) *Cache {
+	if ttl <= 0 {
+		return nil
+	}
+	return &Cache{ttl: ttl, entries: make(map[string]*entry)}
+}
+
+// Get returns a copy of the cached secret of target, to be released with
+// memprotect.Wipe like any value returned by a backend.
+func (c *Cache) Get(target string) ([]byte, bool) {
+	if c == nil {
+		return nil, false
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	e, ok := c.entries[target]
+	if !ok {
+		return nil, false
+	}
+	b := memprotect.AllocSecret(len(e.value))
+	copy(b, e.value)
+	return b, true
+}
+
+// Version returns the version to pass to Put for a secret about to be
+// read from the backend.
+func (c *Cache) Version() uint64 {
+	if c == nil {
+		return 0
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.version
+}
+
+// Put caches a copy of secret for target until the TTL elapses, unless an
+// entry was removed since version was taken. The expiry is not extended
+// by later reads. It fails if the secret cannot be locked into memory.
+func (c *Cache) Put(target string, secret []byte, version uint64) error {
+	if c == nil {
+		return nil
+	}
+	value, err := memprotect.LockedAlloc(len(secret))
+	if err != nil {
+		return err
+	}
+	copy(value, secret)
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.version != version {
+		memprotect.Wipe(value)
+		return nil
+	}
+	c.removeLocked(target)
+	e := &entry{value: value}
+	e.timer = time.AfterFunc(c.ttl, func() {
+		c.mu.Lock()
+		defer c.mu.Unlock()
+		// The entry may have been replaced since the timer was set.
+		if c.entries[target] == e {
+			c.removeLocked(target)
+		}
+	})
+	c.entries[target] = e
+	return nil
+}
+
+// Remove wipes the cached secret of target, if any, and fails the Puts of
+// secrets read before.
+func (c *Cache) Remove(target string) {
+	if c == nil {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.version++
+	c.removeLocked(target)
+}
+
+func (c *Cache) removeLocked(target string) {
+	e, ok := c.entries[target]
+	if !ok {
+		return
+	}
+	e.timer.Stop()
+	memprotect.Wipe(e.value)
+	delete(c.entries, target)
+}
+
+// Flush wipes all cached secrets.
+func (c *Cache) Flush() {
+	if c == nil {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.version++
+	for target := range c.entries {
+		c.removeLocked(target)
+	}
+}
+
+// Len reports the number of cached secrets.
+func (c *Cache) Len() int {
+	if c == nil {
+		return 0
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return len(c.entries)
+}
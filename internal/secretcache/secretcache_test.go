@@ -0,0 +1,63 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package secretcache
+
+import (
+	"testing"
+	"time"
+)
+
+func TestCache(t *testing.T) {
+	c := New(time.Hour)
+	version := c.Version()
+	if err := c.Put("a", []byte("secret"), version); err != nil {
+		t.Fatal(err)
+	}
+	if got, ok := c.Get("a"); !ok || string(got) != "secret" {
+		t.Errorf("Get = %q, %v", got, ok)
+	}
+
+	// A secret read before a Remove is stale and not cached.
+	version = c.Version()
+	c.Remove("a")
+	if err := c.Put("a", []byte("stale"), version); err != nil {
+		t.Fatal(err)
+	}
+	if got, ok := c.Get("a"); ok {
+		t.Errorf("Get after a stale Put = %q", got)
+	}
+
+	if err := c.Put("b", []byte("secret"), c.Version()); err != nil {
+		t.Fatal(err)
+	}
+	c.Flush()
+	if c.Len() != 0 {
+		t.Errorf("Len after Flush = %d", c.Len())
+	}
+}
+
+func TestCacheExpiry(t *testing.T) {
+	c := New(10 * time.Millisecond)
+	if err := c.Put("a", []byte("secret"), c.Version()); err != nil {
+		t.Fatal(err)
+	}
+	deadline := time.Now().Add(5 * time.Second)
+	for c.Len() != 0 {
+		if time.Now().After(deadline) {
+			t.Fatal("entry not removed after its TTL")
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestDisabled(t *testing.T) {
+	c := New(0)
+	if err := c.Put("a", []byte("secret"), c.Version()); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := c.Get("a"); ok || c.Len() != 0 {
+		t.Error("disabled cache returned a secret")
+	}
+	c.Remove("a")
+	c.Flush()
+}
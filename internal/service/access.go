@@ -0,0 +1,80 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package service
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/akihiro/wsl-secret-service/internal/store"
+)
+
+// accessFlushInterval is how often recorded item accesses are written to
+// the store.
+const accessFlushInterval = time.Minute
+
+// accessLog gathers the reads of item secrets in memory, so that reading a
+// secret does not cost a durable write; flushAccesses writes them to the
+// store in one change.
+type accessLog struct {
+	mu      sync.Mutex // held across flushes, so reads see either side
+	pending map[store.ItemRef]store.ItemAccess
+}
+
+// recordAccess notes that the secret of an item was released and updates
+// its LastAccessed and AccessCount properties.
+func (svc *Service) recordAccess(colName, itemUUID string) {
+	ref := store.ItemRef{Collection: colName, UUID: itemUUID}
+	svc.accesses.mu.Lock()
+	if svc.accesses.pending == nil {
+		svc.accesses.pending = make(map[store.ItemRef]store.ItemAccess)
+	}
+	a := svc.accesses.pending[ref]
+	a.Collection, a.UUID, a.Time = colName, itemUUID, uint64(time.Now().Unix())
+	a.Count++
+	svc.accesses.pending[ref] = a
+	meta, _ := svc.store.GetItem(colName, itemUUID)
+	svc.accesses.mu.Unlock()
+
+	if item, ok := svc.exportedItem(colName, itemUUID); ok && item.props != nil {
+		item.props.SetMust(ItemExtIface, "LastAccessed", a.Time)
+		item.props.SetMust(ItemExtIface, "AccessCount", meta.AccessCount+a.Count)
+	}
+}
+
+// flushAccesses writes the accesses recorded since the last flush to the
+// store. They are kept for the next flush if that fails.
+func (svc *Service) flushAccesses() {
+	svc.accesses.mu.Lock()
+	defer svc.accesses.mu.Unlock()
+	if len(svc.accesses.pending) == 0 {
+		return
+	}
+	accesses := make([]store.ItemAccess, 0, len(svc.accesses.pending))
+	for _, a := range svc.accesses.pending {
+		accesses = append(accesses, a)
+	}
+	if err := svc.store.RecordAccesses(accesses); err != nil {
+		logger.Warn("could not record item accesses", "items", len(accesses), "err", err)
+		return
+	}
+	clear(svc.accesses.pending)
+}
+
+// startAccessFlush launches a background goroutine flushing recorded
+// accesses every accessFlushInterval.
+func (svc *Service) startAccessFlush(ctx context.Context) {
+	go func() {
+		ticker := time.NewTicker(accessFlushInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				svc.flushAccesses()
+			}
+		}
+	}()
+}
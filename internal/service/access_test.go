@@ -0,0 +1,40 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package service
+
+import (
+	"testing"
+
+	"github.com/akihiro/wsl-secret-service/internal/store"
+	"github.com/akihiro/wsl-secret-service/pkg/backend/memory"
+)
+
+func TestRecordAccessIsFlushedInBatches(t *testing.T) {
+	st := store.NewMemory()
+	for _, id := range []string{"a", "b"} {
+		if err := st.CreateItem("login", id, store.ItemMeta{Label: id}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	svc := &Service{store: st, backend: memory.New(), stopped: t.Context()}
+
+	svc.recordAccess("login", "a")
+	svc.recordAccess("login", "a")
+	svc.recordAccess("login", "b")
+	if meta, _ := st.GetItem("login", "a"); meta.AccessCount != 0 {
+		t.Errorf("AccessCount = %d before the flush, want 0", meta.AccessCount)
+	}
+
+	svc.flushAccesses()
+	for id, want := range map[string]uint64{"a": 2, "b": 1} {
+		meta, _ := st.GetItem("login", id)
+		if meta.AccessCount != want || meta.LastAccessed == 0 {
+			t.Errorf("%s: AccessCount = %d, LastAccessed = %d, want %d and set", id, meta.AccessCount, meta.LastAccessed, want)
+		}
+	}
+
+	svc.flushAccesses()
+	if meta, _ := st.GetItem("login", "a"); meta.AccessCount != 2 {
+		t.Errorf("AccessCount = %d after a second flush, want 2", meta.AccessCount)
+	}
+}
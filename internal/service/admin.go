@@ -0,0 +1,173 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package service
+
+import (
+	"cmp"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/akihiro/wsl-secret-service/internal/backend"
+	"github.com/akihiro/wsl-secret-service/internal/config"
+	"github.com/akihiro/wsl-secret-service/internal/memprotect"
+	"github.com/akihiro/wsl-secret-service/internal/version"
+	"github.com/godbus/dbus/v5"
+)
+
+// Admin implements org.akihiro.WslSecretService.Admin on the root object,
+// letting tooling inspect and control the daemon without signals or log
+// scraping. It is a separate type so that only these methods are exported
+// under AdminIface.
+type Admin struct {
+	svc *Service
+}
+
+// Stats implements Admin.Stats(). It returns runtime counters keyed by
+// name; see the README for the list.
+func (a *Admin) Stats() (map[string]dbus.Variant, *dbus.Error) {
+	svc := a.svc
+	items, locked := 0, 0
+	cols := svc.store.ListCollections()
+	for _, name := range cols {
+		items += len(svc.store.ListItems(name))
+		if svc.isLocked(name) {
+			locked++
+		}
+	}
+	svc.sessions.mu.Lock()
+	sessions := len(svc.sessions.sessions)
+	svc.sessions.mu.Unlock()
+	callers := svc.callers.len()
+
+	rules := 0
+	if p := svc.policy.Load(); p != nil {
+		rules = len(p.Rules)
+	}
+	now := time.Now()
+	stats := map[string]dbus.Variant{
+		"version":            dbus.MakeVariant(version.String()),
+		"uptime_seconds":     dbus.MakeVariant(uint64(now.Sub(svc.started).Seconds())),
+		"idle_seconds":       dbus.MakeVariant(uint64(now.Unix() - svc.lastActivityTimestamp.Load())),
+		"collections":        dbus.MakeVariant(uint32(len(cols))),
+		"locked_collections": dbus.MakeVariant(uint32(locked)),
+		"items":              dbus.MakeVariant(uint32(items)),
+		"sessions":           dbus.MakeVariant(uint32(sessions)),
+		"plaintext_secrets":  dbus.MakeVariant(uint32(len(svc.plaintext))),
+		"cached_secrets":     dbus.MakeVariant(uint32(svc.secrets.Len())),
+		"cached_callers":     dbus.MakeVariant(uint32(callers)),
+		"policy_rules":       dbus.MakeVariant(uint32(rules)),
+		"audit_log":          dbus.MakeVariant(svc.auditLog != nil),
+		"swap_protection":    dbus.MakeVariant(memprotect.CurrentLockMode().String()),
+		"locked_bytes":       dbus.MakeVariant(uint64(memprotect.LockedBytes())),
+	}
+	if r, ok := backend.As[backend.StatsReporter](svc.backend); ok {
+		for name, v := range r.Stats() {
+			stats[name] = dbus.MakeVariant(v)
+		}
+	}
+	return stats, nil
+}
+
+// authorizeAdmin lets sender call the Admin method method only if it runs
+// as the daemon's user outside a sandbox, like wsl-secret-ctl, and the
+// access policy allows it. Admin methods are matched as requests on no
+// collection, so only policy rules without a collection pattern apply;
+// under a "confirm" rule the user is asked.
+func (svc *Service) authorizeAdmin(sender dbus.Sender, method string) *dbus.Error {
+	c := svc.caller(sender)
+	deny := func(reason string) *dbus.Error {
+		logger.Info("admin call refused", "method", method, "sender", c.Sender, "exe", c.Exe, "reason", reason)
+		return dbusError("org.freedesktop.Secret.Error.AccessDenied",
+			fmt.Sprintf("%s is not allowed to call %s: %s", c.Sender, method, reason))
+	}
+	if c.UID == nil || *c.UID != uint32(os.Getuid()) {
+		return deny("it does not run as the daemon's user")
+	}
+	if c.AppID != "" {
+		return deny("it runs in the sandbox " + c.AppID)
+	}
+	policy := svc.policy.Load()
+	if policy == nil {
+		return nil
+	}
+	switch policyDecision(policy, c.Exe, "", nil) {
+	case config.Deny:
+		return deny("the access policy denies it")
+	case config.Confirm:
+		app := cmp.Or(c.Exe, "An unidentified process")
+		derr, _ := svc.approve(c.Sender, ServicePath, fmt.Sprintf("%s in WSL wants to call %s on the keyring.", app, method))
+		return derr
+	}
+	return nil
+}
+
+// Reload implements Admin.Reload(): config.json is read again and the
+// access policy and auto-lock periods replaced. Other settings (backend,
+// audit log) take effect only after a restart.
+func (a *Admin) Reload(sender dbus.Sender) *dbus.Error {
+	svc := a.svc
+	svc.recordActivity()
+	if derr := svc.authorizeAdmin(sender, "Reload"); derr != nil {
+		return derr
+	}
+	if svc.loadConfig == nil {
+		return dbusError("org.freedesktop.DBus.Error.NotSupported", "configuration reload is not available")
+	}
+	cfg, err := svc.loadConfig()
+	if err != nil {
+		return dbusError("org.freedesktop.DBus.Error.Failed", fmt.Sprintf("reload configuration: %v", err))
+	}
+	svc.policy.Store(cfg.Policy)
+	svc.autoLockConfig.Store(&cfg.AutoLock)
+	svc.hooks.Store(&cfg.Hooks)
+	rules := 0
+	if cfg.Policy != nil {
+		rules = len(cfg.Policy.Rules)
+	}
+	logger.Info("configuration reloaded", "policy_rules", rules, "hooks", len(cfg.Hooks))
+	if svc.mirrorWindows {
+		added, removed, err := svc.MirrorWindowsCredentials()
+		if err != nil {
+			return dbusError("org.freedesktop.DBus.Error.Failed", fmt.Sprintf("mirror Windows credentials: %v", err))
+		}
+		logger.Info("Windows credentials mirrored", "added", added, "removed", removed)
+	}
+	return nil
+}
+
+// FlushCache implements Admin.FlushCache(): the resolved caller identities
+// and the checksum key are dropped, cached secrets are wiped, and the
+// backend discards its own state (e.g. the persistent helper process).
+// Everything is reloaded on demand.
+func (a *Admin) FlushCache(sender dbus.Sender) *dbus.Error {
+	svc := a.svc
+	svc.recordActivity()
+	if derr := svc.authorizeAdmin(sender, "FlushCache"); derr != nil {
+		return derr
+	}
+	svc.callers.mu.Lock()
+	clear(svc.callers.callers)
+	svc.callers.mu.Unlock()
+	svc.checksumKey.flush()
+	svc.secrets.Flush()
+	svc.approvals.flush()
+	if f, ok := svc.backend.(backend.Flusher); ok {
+		if err := f.Flush(); err != nil {
+			return dbusError("org.freedesktop.DBus.Error.Failed", fmt.Sprintf("flush backend: %v", err))
+		}
+	}
+	logger.Info("caches flushed")
+	return nil
+}
+
+// Shutdown implements Admin.Shutdown(): the daemon exits gracefully, as on
+// idle timeout, after replying.
+func (a *Admin) Shutdown(sender dbus.Sender) *dbus.Error {
+	if derr := a.svc.authorizeAdmin(sender, "Shutdown"); derr != nil {
+		return derr
+	}
+	logger.Info("shutdown requested over D-Bus")
+	a.svc.shutdownFn(ErrShutdownRequested)
+	return nil
+}
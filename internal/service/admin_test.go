@@ -0,0 +1,62 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package service
+
+import (
+	"os"
+	"testing"
+
+	"github.com/akihiro/wsl-secret-service/internal/config"
+	"github.com/akihiro/wsl-secret-service/internal/store"
+	"github.com/akihiro/wsl-secret-service/pkg/backend/memory"
+	"github.com/godbus/dbus/v5"
+)
+
+func TestAdminCallerChecks(t *testing.T) {
+	uid, other := uint32(os.Getuid()), uint32(os.Getuid()+1)
+	p := &countingPrompter{err: errDenied}
+	svc := &Service{store: store.NewMemory(), backend: memory.New(), confirmPrompter: p, stopped: t.Context()}
+	svc.callers.callers = map[string]callerInfo{
+		":1.1": {Sender: ":1.1", UID: &uid, Exe: "/usr/bin/wsl-secret-ctl"},
+		":1.2": {Sender: ":1.2", UID: &other, Exe: "/usr/bin/wsl-secret-ctl"},
+		":1.3": {Sender: ":1.3", UID: &uid, Exe: "/app/bin/tool", AppID: "flatpak:org.example.Tool"},
+		":1.4": {Sender: ":1.4", Exe: "/usr/bin/wsl-secret-ctl"},
+		":1.5": {Sender: ":1.5", UID: &uid, Exe: "/usr/bin/python3"},
+	}
+	var shutdowns int
+	svc.shutdownFn = func(error) { shutdowns++ }
+	a := &Admin{svc: svc}
+
+	for _, sender := range []string{":1.2", ":1.3", ":1.4"} {
+		if derr := a.Shutdown(dbus.Sender(sender)); derr == nil || derr.Name != "org.freedesktop.Secret.Error.AccessDenied" {
+			t.Errorf("Shutdown by %s = %v, want AccessDenied", sender, derr)
+		}
+		if derr := a.FlushCache(dbus.Sender(sender)); derr == nil {
+			t.Errorf("FlushCache by %s succeeded", sender)
+		}
+		if _, derr := a.Fsck(dbus.Sender(sender), true); derr == nil {
+			t.Errorf("Fsck with repair by %s succeeded", sender)
+		}
+	}
+	if _, derr := a.Fsck(":1.2", false); derr != nil {
+		t.Errorf("Fsck without repair by another user: %v", derr)
+	}
+	if shutdowns != 0 {
+		t.Fatalf("refused Shutdown calls shut down %d times", shutdowns)
+	}
+
+	svc.policy.Store(&config.Policy{Rules: []config.Rule{
+		{Action: config.Deny, Exe: "/usr/bin/python3"},
+		{Action: config.Confirm, Exe: "/usr/bin/wsl-secret-ctl"},
+	}})
+	if derr := a.Shutdown(":1.5"); derr == nil {
+		t.Error("Shutdown by a caller the policy denies succeeded")
+	}
+	if derr := a.Shutdown(":1.1"); derr == nil || p.asked != 1 {
+		t.Errorf("Shutdown under a confirm rule, declined: %v, asked %d times", derr, p.asked)
+	}
+	p.err = nil
+	if derr := a.Shutdown(":1.1"); derr != nil || shutdowns != 1 {
+		t.Errorf("Shutdown under a confirm rule, confirmed: %v, shut down %d times", derr, shutdowns)
+	}
+}
@@ -0,0 +1,82 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package service
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/akihiro/wsl-secret-service/internal/config"
+	"github.com/akihiro/wsl-secret-service/internal/store"
+	"github.com/google/uuid"
+)
+
+// externalEntrySchema is the xdg:schema attribute given to adopted backend
+// entries; the entry name is stored in the "entry" attribute.
+const externalEntrySchema = "org.akihiro.WslSecretService.ExternalEntry"
+
+// AdoptExternalEntries exposes backend entries that were not created by
+// this daemon (e.g. existing pass(1) passwords) as items of the collection
+// labelled label, creating it if needed. Only entries starting with prefix
+// are considered, and those under TargetPrefix and entries already
+// referenced by an item are skipped, so calling this on every start only
+// picks up new entries. The secret stays where it is: the item's recorded
+// Target points at the entry. Items are labelled with the entry name after
+// prefix. It returns the number of entries adopted.
+func (svc *Service) AdoptExternalEntries(label, prefix string) (int, error) {
+	ctx, cancel := svc.backendContext()
+	defer cancel()
+	targets, err := svc.backend.List(ctx, prefix)
+	if err != nil {
+		return 0, fmt.Errorf("list backend entries: %w", err)
+	}
+
+	colName := collectionSlug(label)
+	adopted := 0
+	for _, target := range targets {
+		if strings.HasPrefix(target, TargetPrefix) || svc.store.TargetInUse(target) {
+			continue
+		}
+		if _, ok := svc.store.GetCollection(colName); !ok {
+			if err := svc.addCollection(colName, label, ""); err != nil {
+				return adopted, fmt.Errorf("create collection %q: %w", colName, err)
+			}
+		}
+		if err := svc.adoptEntry(colName, target, strings.TrimPrefix(target, prefix)); err != nil {
+			logger.Warn("could not adopt backend entry", "target", target, "err", err)
+			continue
+		}
+		adopted++
+	}
+	return adopted, nil
+}
+
+// adoptEntry records target as a new item labelled label in colName and
+// exports it.
+func (svc *Service) adoptEntry(colName, target, label string) error {
+	return svc.addExternalItem(colName, store.ItemMeta{
+		Label: label,
+		Attributes: map[string]string{
+			"xdg:schema": externalEntrySchema,
+			"entry":      target,
+		},
+		ContentType: "text/plain; charset=utf8",
+		Target:      target,
+	})
+}
+
+// addExternalItem records an item described by meta, whose secret already
+// is in the backend under meta.Target, in colName and exports it.
+func (svc *Service) addExternalItem(colName string, meta store.ItemMeta) error {
+	itemUUID := uuid.New().String()
+	if err := svc.store.CreateItem(colName, itemUUID, meta); err != nil {
+		return err
+	}
+	if err := svc.exportItem(&Item{collectionName: colName, uuid: itemUUID, svc: svc}); err != nil {
+		return err
+	}
+	svc.updateCollectionItemsProp(colName)
+	_ = svc.conn.Emit(CollectionPath(colName), CollectionIface+".ItemCreated", ItemPath(colName, itemUUID))
+	svc.itemEvent(config.HookCreated, colName, itemUUID, meta)
+	return nil
+}
@@ -0,0 +1,31 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package service
+
+import (
+	"github.com/akihiro/wsl-secret-service/internal/audit"
+	"github.com/godbus/dbus/v5"
+)
+
+// audit records op on object by sender in the audit log, if one is
+// configured. derr is the outcome returned to the client (nil for success).
+func (svc *Service) audit(sender dbus.Sender, op string, object dbus.ObjectPath, derr *dbus.Error) {
+	svc.auditEntry(sender, audit.Entry{Op: op, Object: string(object)}, derr)
+}
+
+// auditEntry completes e with the caller's identity and the result and
+// appends it to the audit log, if one is configured.
+func (svc *Service) auditEntry(sender dbus.Sender, e audit.Entry, derr *dbus.Error) {
+	if svc.auditLog == nil {
+		return
+	}
+	c := svc.caller(sender)
+	e.Sender, e.PID, e.UID, e.Exe = c.Sender, c.PID, c.UID, c.Exe
+	e.Result = audit.ResultOK
+	if derr != nil {
+		e.Result = derr.Name
+	}
+	if err := svc.auditLog.Record(e); err != nil {
+		logger.Error("could not write audit log", "err", err)
+	}
+}
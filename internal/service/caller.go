@@ -0,0 +1,76 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package service
+
+import (
+	"fmt"
+	"os"
+	"sync"
+
+	"github.com/godbus/dbus/v5"
+)
+
+// callerInfo identifies the process behind a D-Bus connection.
+type callerInfo struct {
+	Sender string
+	PID    uint32  // 0 if the bus did not report it
+	UID    *uint32 // nil if the bus did not report it
+	Exe    string  // /proc/<pid>/exe target; "" if unreadable
+	AppID  string  // Flatpak or Snap application (see sandboxAppID); "" if not sandboxed
+}
+
+// callerCache remembers resolved callers per unique bus name, so each
+// client costs a single GetConnectionCredentials round trip. Entries are
+// dropped when the client disconnects (see watchNameOwnerChanged).
+type callerCache struct {
+	mu      sync.Mutex
+	callers map[string]callerInfo
+}
+
+func (c *callerCache) len() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return len(c.callers)
+}
+
+// caller resolves the process behind sender. Resolution failures are
+// logged and yield a callerInfo carrying only the sender name.
+func (svc *Service) caller(sender dbus.Sender) callerInfo {
+	name := string(sender)
+	svc.callers.mu.Lock()
+	info, ok := svc.callers.callers[name]
+	svc.callers.mu.Unlock()
+	if ok {
+		return info
+	}
+
+	info = callerInfo{Sender: name}
+	var creds map[string]dbus.Variant
+	err := svc.conn.BusObject().Call("org.freedesktop.DBus.GetConnectionCredentials", 0, name).Store(&creds)
+	if err != nil {
+		logger.Warn("could not resolve caller credentials", "sender", name, "err", err)
+		return info
+	}
+	if v, ok := creds["ProcessID"].Value().(uint32); ok {
+		info.PID = v
+		if exe, err := os.Readlink(fmt.Sprintf("/proc/%d/exe", v)); err == nil {
+			info.Exe = exe
+		}
+		info.AppID = sandboxAppID(fmt.Sprintf("/proc/%d", v))
+	}
+	if v, ok := creds["UnixUserID"].Value().(uint32); ok {
+		info.UID = &v
+	}
+
+	svc.callers.mu.Lock()
+	svc.callers.callers[name] = info
+	svc.callers.mu.Unlock()
+	return info
+}
+
+// forgetCaller drops the cached identity of a disconnected client.
+func (svc *Service) forgetCaller(name string) {
+	svc.callers.mu.Lock()
+	delete(svc.callers.callers, name)
+	svc.callers.mu.Unlock()
+}
@@ -0,0 +1,94 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package service
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"sync"
+
+	"github.com/akihiro/wsl-secret-service/internal/backend"
+)
+
+// checksumKeyTarget is the backend target holding the HMAC key used for
+// secret checksums. The key lives next to the secrets rather than in
+// metadata.json so that a leaked metadata file cannot be used to brute-force
+// low-entropy secrets from their checksums.
+const checksumKeyTarget = TargetPrefix + ".checksum-key"
+
+// checksumKeySize is the length in bytes of the generated HMAC key.
+const checksumKeySize = 32
+
+// checksumKeeper lazily loads (or creates) the checksum HMAC key from the backend.
+type checksumKeeper struct {
+	mu  sync.Mutex
+	key []byte
+}
+
+// get returns the checksum key, loading it from be on first use and
+// generating a new one if the backend has none. A stored key of the wrong
+// size is an error rather than replaced: the checksums of existing items
+// were computed with it.
+func (k *checksumKeeper) get(ctx context.Context, be backend.Backend) ([]byte, error) {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	if k.key != nil {
+		return k.key, nil
+	}
+
+	key, err := be.Get(ctx, checksumKeyTarget)
+	var notFound *backend.ErrNotFound
+	switch {
+	case err == nil && len(key) == checksumKeySize:
+	case err == nil:
+		n := len(key)
+		clear(key)
+		return nil, fmt.Errorf("checksum key %s is %d bytes, want %d", checksumKeyTarget, n, checksumKeySize)
+	case errors.As(err, &notFound):
+		key = make([]byte, checksumKeySize)
+		if _, err := rand.Read(key); err != nil {
+			return nil, fmt.Errorf("generate checksum key: %w", err)
+		}
+		if err := be.Set(ctx, checksumKeyTarget, key); err != nil {
+			return nil, fmt.Errorf("store checksum key: %w", err)
+		}
+	default:
+		return nil, fmt.Errorf("load checksum key: %w", err)
+	}
+	k.key = key
+	return key, nil
+}
+
+// flush wipes and forgets the cached key; the next get reloads it.
+func (k *checksumKeeper) flush() {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	clear(k.key)
+	k.key = nil
+}
+
+// secretChecksum returns the hex-encoded HMAC-SHA256 of secret under key.
+func secretChecksum(key, secret []byte) string {
+	mac := hmac.New(sha256.New, key)
+	mac.Write(secret)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// checksum computes the checksum of secret for storage in ItemMeta.
+// Failures are logged and yield an empty checksum so that a backend problem
+// with the key never blocks storing the secret itself.
+func (svc *Service) checksum(secret []byte) string {
+	ctx, cancel := svc.backendContext()
+	defer cancel()
+	key, err := svc.checksumKey.get(ctx, svc.backend)
+	if err != nil {
+		logger.Warn("secret checksum unavailable", "err", err)
+		return ""
+	}
+	return secretChecksum(key, secret)
+}
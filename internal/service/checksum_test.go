@@ -0,0 +1,40 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package service
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/akihiro/wsl-secret-service/pkg/backend/memory"
+)
+
+func TestChecksumKeeper(t *testing.T) {
+	ctx := t.Context()
+	be := memory.New()
+	var k checksumKeeper
+	key, err := k.get(ctx, be)
+	if err != nil || len(key) != checksumKeySize {
+		t.Fatalf("get = %x, %v", key, err)
+	}
+	stored, err := be.Get(ctx, checksumKeyTarget)
+	if err != nil || !bytes.Equal(stored, key) {
+		t.Errorf("backend holds %x, %v, want the generated key", stored, err)
+	}
+	k.flush()
+	if again, err := k.get(ctx, be); err != nil || !bytes.Equal(again, stored) {
+		t.Errorf("get after flush = %x, %v, want the stored key", again, err)
+	}
+
+	// A damaged key is reported, not replaced.
+	if err := be.Set(ctx, checksumKeyTarget, []byte("short")); err != nil {
+		t.Fatal(err)
+	}
+	k.flush()
+	if key, err := k.get(ctx, be); err == nil {
+		t.Errorf("get of a 5-byte key = %x, want an error", key)
+	}
+	if stored, _ := be.Get(ctx, checksumKeyTarget); string(stored) != "short" {
+		t.Errorf("backend holds %x after a failed get, want the old value", stored)
+	}
+}
@@ -3,8 +3,13 @@
 package service
 
 import (
+	"errors"
 	"fmt"
 
+	"github.com/akihiro/wsl-secret-service/internal/audit"
+	"github.com/akihiro/wsl-secret-service/internal/backend"
+	"github.com/akihiro/wsl-secret-service/internal/config"
+	"github.com/akihiro/wsl-secret-service/internal/store"
 	"github.com/godbus/dbus/v5"
 	"github.com/godbus/dbus/v5/prop"
 	"github.com/google/uuid"
@@ -16,36 +21,67 @@ type Collection struct {
 	name  string
 	svc   *Service
 	props *prop.Properties
+	items map[string]*Item // exported items, keyed by UUID
 }
 
 // Delete implements org.freedesktop.Secret.Collection.Delete().
-// Removes all items from the backend and metadata store, then unexports the object.
+// Removes the collection from the metadata store, with tombstones for the
+// secrets of its items, then the secrets from the backend (as deleteItem
+// does for one item), and unexports the objects.
 // Returns "/" (no prompt needed).
-func (c *Collection) Delete() (dbus.ObjectPath, *dbus.Error) {
+func (c *Collection) Delete(sender dbus.Sender) (_ dbus.ObjectPath, derr *dbus.Error) {
 	c.svc.recordActivity()
 
 	path := CollectionPath(c.name)
+	defer func() { c.svc.audit(sender, audit.OpDelete, path, derr) }()
 
-	// Delete all items from backend and store.
+	// Nothing in a locked collection, such as one whose master password
+	// was not entered yet, is deleted until it is unlocked.
+	if c.svc.isLocked(c.name) {
+		return StubPromptPath, errLocked(c.name)
+	}
+	// Deleting a collection deletes its items, so the caller needs access
+	// to all of them.
+	if derr := c.svc.authorize(sender, path, c.name, nil); derr != nil {
+		return StubPromptPath, derr
+	}
 	for _, itemUUID := range c.svc.store.ListItems(c.name) {
-		target := fmt.Sprintf("wsl-ss/%s/%s", c.name, itemUUID)
-		_ = c.svc.backend.Delete(target)
-		itemPath := ItemPath(c.name, itemUUID)
-		_ = c.svc.conn.Export(nil, itemPath, ItemIface)
-		_ = c.svc.conn.Export(nil, itemPath, "org.freedesktop.DBus.Properties")
+		if derr := c.svc.authorizeItem(sender, c.name, itemUUID); derr != nil {
+			return StubPromptPath, derr
+		}
+		if derr := c.svc.checkWritable(c.name, itemUUID); derr != nil {
+			return StubPromptPath, derr
+		}
+	}
+
+	itemUUIDs := c.svc.store.ListItems(c.name)
+	targets := make([]string, len(itemUUIDs))
+	for i, itemUUID := range itemUUIDs {
+		targets[i] = c.svc.itemTarget(c.name, itemUUID)
 	}
 
 	// Delete from store (removes collection + all items).
-	if err := c.svc.store.DeleteCollection(c.name); err != nil {
+	if err := c.svc.store.TombstoneCollection(c.name, targets); err != nil {
 		return StubPromptPath, dbusError("org.freedesktop.Secret.Error.NoSuchObject", err.Error())
 	}
+	for _, itemUUID := range itemUUIDs {
+		itemPath := ItemPath(c.name, itemUUID)
+		_ = c.svc.conn.Export(nil, itemPath, ItemIface)
+		_ = c.svc.conn.Export(nil, itemPath, "org.freedesktop.DBus.Properties")
+		c.svc.unexportIntrospection(itemPath)
+	}
+	c.svc.clearTombstones(targets, c.svc.deleteSecrets(targets))
 
 	// Unexport collection D-Bus objects.
 	_ = c.svc.conn.Export(nil, path, CollectionIface)
 	_ = c.svc.conn.Export(nil, path, "org.freedesktop.DBus.Properties")
+	c.svc.unexportIntrospection(path)
 
-	// Remove from in-memory map.
+	// Remove from in-memory maps.
+	c.svc.objectsMu.Lock()
 	delete(c.svc.collections, c.name)
+	c.svc.objectsMu.Unlock()
+	c.svc.setLocked(c.name, false)
 
 	// Emit signal and update Service.Collections property.
 	_ = c.svc.conn.Emit(
@@ -60,13 +96,16 @@ func (c *Collection) Delete() (dbus.ObjectPath, *dbus.Error) {
 
 // SearchItems implements org.freedesktop.Secret.Collection.SearchItems(attributes).
 // Returns all item paths in this collection whose attributes are a superset of attrs.
-func (c *Collection) SearchItems(attributes map[string]string) ([]dbus.ObjectPath, *dbus.Error) {
+func (c *Collection) SearchItems(sender dbus.Sender, attributes map[string]string) ([]dbus.ObjectPath, *dbus.Error) {
 	c.svc.recordActivity()
+	c.svc.auditEntry(sender, audit.Entry{Op: audit.OpSearch, Object: string(CollectionPath(c.name)), Attributes: attributes}, nil)
 
-	refs := c.svc.store.SearchItemsInCollection(c.name, attributes)
-	paths := make([]dbus.ObjectPath, len(refs))
-	for i, ref := range refs {
-		paths[i] = ItemPath(ref.Collection, ref.UUID)
+	paths := []dbus.ObjectPath{}
+	for _, ref := range c.svc.searchAttributes(c.name, attributes) {
+		if c.svc.authorizeItem(sender, ref.Collection, ref.UUID) != nil {
+			continue
+		}
+		paths = append(paths, ItemPath(ref.Collection, ref.UUID))
 	}
 	return paths, nil
 }
@@ -75,11 +114,36 @@ func (c *Collection) SearchItems(attributes map[string]string) ([]dbus.ObjectPat
 // Creates a new item (or replaces an existing one if replace=true and attributes match).
 // Returns (itemPath, "/") — no prompt is ever needed.
 func (c *Collection) CreateItem(
+	sender dbus.Sender,
 	properties map[string]dbus.Variant,
 	secret dbus.Variant,
 	replace bool,
-) (dbus.ObjectPath, dbus.ObjectPath, *dbus.Error) {
+) (itemPath dbus.ObjectPath, _ dbus.ObjectPath, derr *dbus.Error) {
 	c.svc.recordActivity()
+	defer func() {
+		object := itemPath
+		if derr != nil {
+			object = CollectionPath(c.name)
+		}
+		c.svc.audit(sender, audit.OpCreateItem, object, derr)
+	}()
+
+	if c.svc.isLocked(c.name) {
+		return "/", StubPromptPath, errLocked(c.name)
+	}
+	if c.svc.mirrorWindows && c.name == windowsCollection {
+		return "/", StubPromptPath, errReadOnly(CollectionPath(c.name))
+	}
+
+	meta := itemMetaFromProperties(properties)
+	attrs := meta.Attributes
+	if attrs == nil {
+		attrs = map[string]string{}
+	}
+	if derr := c.svc.authorize(sender, CollectionPath(c.name), c.name, attrs); derr != nil {
+		return "/", StubPromptPath, derr
+	}
+	c.svc.touchCollection(c.name)
 
 	// Unmarshal the secret variant into the Secret struct.
 	var sec Secret
@@ -95,72 +159,162 @@ func (c *Collection) CreateItem(
 			fmt.Sprintf("session %s is not open", sec.Session))
 	}
 
+	c.svc.plaintext.acquire()
 	plaintext, err := sess.decryptSecret(sec.Parameters, sec.Value)
 	if err != nil {
+		c.svc.plaintext.release()
 		return "/", StubPromptPath, dbusError("org.freedesktop.DBus.Error.Failed",
 			fmt.Sprintf("decrypt secret: %v", err))
 	}
+	defer c.svc.plaintext.release(plaintext)
 
-	meta := itemMetaFromProperties(properties)
 	if meta.ContentType == "" && sec.ContentType != "" {
 		meta.ContentType = sec.ContentType
 	}
+	caller := c.svc.caller(sender)
+	meta.Creator = &store.Creator{Exe: caller.Exe, UID: caller.UID, AppID: caller.AppID}
+	// A sandboxed application must not overwrite another one's item.
+	if replace && c.svc.isolateApps && caller.AppID != "" {
+		refs := c.svc.store.SearchItemsInCollection(c.name, meta.Attributes)
+		if len(refs) > 0 && c.svc.authorizeItem(sender, c.name, refs[0].UUID) != nil {
+			replace = false
+		}
+	}
+
+	itemPath, err = c.svc.createItem(c.name, meta, plaintext, replace)
+	if err != nil {
+		return "/", StubPromptPath, dbusError("org.freedesktop.DBus.Error.Failed", err.Error())
+	}
+	return itemPath, StubPromptPath, nil
+}
+
+// createItem stores plaintext as a new item in collection colName, persists
+// meta (with its checksum filled in) and exports the item on the bus.
+// If replace is set and an item with identical attributes already exists in
+// the collection, that item is overwritten instead. On failure the
+// collection is left as it was.
+func (svc *Service) createItem(colName string, meta store.ItemMeta, plaintext []byte, replace bool) (dbus.ObjectPath, error) {
+	meta.Checksum = svc.checksum(plaintext)
 
 	// Check for replace: look for an existing item with identical attributes.
 	var targetUUID string
 	if replace && len(meta.Attributes) > 0 {
-		refs := c.svc.store.SearchItemsInCollection(c.name, meta.Attributes)
+		refs := svc.store.SearchItemsInCollection(colName, meta.Attributes)
 		if len(refs) > 0 {
 			targetUUID = refs[0].UUID
 		}
 	}
 
-	if targetUUID == "" {
-		// Generate a new UUID for this item.
-		targetUUID = uuid.New().String()
+	var existing store.ItemMeta
+	if targetUUID != "" {
+		// The item may have been deleted since the search.
+		var ok bool
+		if existing, ok = svc.store.GetItem(colName, targetUUID); !ok {
+			targetUUID = ""
+		}
 	}
 
-	target := fmt.Sprintf("wsl-ss/%s/%s", c.name, targetUUID)
-
-	// Store the plaintext secret in the backend.
-	if err := c.svc.backend.Set(target, plaintext); err != nil {
-		return "/", StubPromptPath, dbusError("org.freedesktop.DBus.Error.Failed",
-			fmt.Sprintf("store secret: %v", err))
+	var target string
+	newItem := targetUUID == ""
+	if newItem {
+		// Generate a new UUID and backend target for this item.
+		targetUUID = uuid.New().String()
+		var release func()
+		target, release = svc.newItemTarget(colName, targetUUID, meta)
+		defer release()
+		if target != uuidTarget(colName, targetUUID) {
+			meta.Target = target
+		}
+	} else {
+		// Replacing keeps the existing item's target, creator and access
+		// statistics.
+		target = svc.itemTarget(colName, targetUUID)
+		meta.Target = existing.Target
+		meta.Creator = existing.Creator
+		meta.LastAccessed, meta.AccessCount = existing.LastAccessed, existing.AccessCount
 	}
 
-	// Persist metadata.
-	if _, exists := c.svc.store.GetItem(c.name, targetUUID); exists {
-		if err := c.svc.store.UpdateItem(c.name, targetUUID, meta); err != nil {
-			return "/", StubPromptPath, dbusError("org.freedesktop.DBus.Error.Failed", err.Error())
+	// Store the secret and the metadata so that a failure leaves neither
+	// behind: a new item's secret is removed again if its metadata cannot
+	// be saved, and a replaced item's metadata is restored if its new
+	// secret cannot be stored.
+	desc := backend.Metadata{Collection: colName, Label: meta.Label, Attributes: meta.Attributes}
+	if newItem {
+		// Under a hashed name if the composed one is too long for the
+		// backend.
+		err := svc.setSecret(target, plaintext, desc)
+		var tooLong *backend.ErrTargetTooLong
+		if errors.As(err, &tooLong) {
+			logger.Info("target name too long for the backend, using a hashed name", "collection", colName, "length", len(target))
+			var release func()
+			target, release = svc.targetReservations.reserve(hashedTarget(target))
+			defer release()
+			meta.Target = target
+			err = svc.setSecret(target, plaintext, desc)
+		}
+		if err != nil {
+			return "/", fmt.Errorf("store secret: %w", err)
+		}
+		if err := svc.store.CreateItem(colName, targetUUID, meta); err != nil {
+			svc.rollbackSecret(colName, targetUUID, target)
+			return "/", err
 		}
 	} else {
-		if err := c.svc.store.CreateItem(c.name, targetUUID, meta); err != nil {
-			return "/", StubPromptPath, dbusError("org.freedesktop.DBus.Error.Failed", err.Error())
+		if err := svc.store.UpdateItem(colName, targetUUID, meta); err != nil {
+			return "/", err
+		}
+		if err := svc.setSecret(target, plaintext, desc); err != nil {
+			if rerr := svc.store.UpdateItem(colName, targetUUID, existing); rerr != nil {
+				logger.Error("cannot restore the metadata of an item whose secret could not be replaced", "collection", colName, "item", targetUUID, "err", rerr)
+			}
+			return "/", fmt.Errorf("store secret: %w", err)
 		}
 	}
 
 	// Export the Item D-Bus object.
 	item := &Item{
-		collectionName: c.name,
+		collectionName: colName,
 		uuid:           targetUUID,
-		svc:            c.svc,
+		svc:            svc,
 	}
-	if err := c.svc.exportItem(item); err != nil {
-		return "/", StubPromptPath, dbusError("org.freedesktop.DBus.Error.Failed", err.Error())
+	if err := svc.exportItem(item); err != nil {
+		if newItem {
+			if derr := svc.store.DeleteItem(colName, targetUUID); derr != nil {
+				logger.Error("cannot remove the metadata of an item that could not be exported", "collection", colName, "item", targetUUID, "err", derr)
+			}
+			svc.rollbackSecret(colName, targetUUID, target)
+		}
+		return "/", err
 	}
 
-	itemPath := ItemPath(c.name, targetUUID)
+	itemPath := ItemPath(colName, targetUUID)
 
 	// Update the Items property and emit signal.
-	c.svc.updateCollectionItemsProp(c.name)
-	_ = c.svc.conn.Emit(CollectionPath(c.name), CollectionIface+".ItemCreated", itemPath)
+	svc.updateCollectionItemsProp(colName)
+	_ = svc.conn.Emit(CollectionPath(colName), CollectionIface+".ItemCreated", itemPath)
+	logger.Debug("item created", "item", itemPath)
+	if newItem {
+		svc.itemEvent(config.HookCreated, colName, targetUUID, meta)
+	} else {
+		svc.itemEvent(config.HookChanged, colName, targetUUID, meta)
+	}
 
-	return itemPath, StubPromptPath, nil
+	return itemPath, nil
+}
+
+// rollbackSecret removes the secret stored for an item whose creation
+// failed, so that it is not left in the backend without metadata.
+func (svc *Service) rollbackSecret(colName, itemUUID, target string) {
+	if err := svc.deleteSecret(target); err != nil {
+		logger.Error("cannot remove the secret of an item whose creation failed; it stays in the backend without metadata",
+			"collection", colName, "item", itemUUID, "target", target, "err", err)
+	}
 }
 
 // exportCollection exports all D-Bus interfaces for a collection onto the connection.
 func (svc *Service) exportCollection(col *Collection) error {
 	path := CollectionPath(col.name)
+	col.items = make(map[string]*Item)
 
 	// Export the Collection interface (methods).
 	if err := svc.conn.Export(col, path, CollectionIface); err != nil {
@@ -191,13 +345,15 @@ func (svc *Service) exportCollection(col *Collection) error {
 				Emit:     prop.EmitTrue,
 				Callback: func(c *prop.Change) *dbus.Error {
 					if label, ok := c.Value.(string); ok {
-						_ = svc.store.UpdateCollectionLabel(col.name, label)
+						if err := svc.store.UpdateCollectionLabel(col.name, label); err != nil {
+							return dbusError("org.freedesktop.DBus.Error.Failed", err.Error())
+						}
 					}
 					return nil
 				},
 			},
 			"Locked": {
-				Value:    false,
+				Value:    svc.isLocked(col.name),
 				Writable: false,
 				Emit:     prop.EmitFalse,
 			},
@@ -219,12 +375,13 @@ func (svc *Service) exportCollection(col *Collection) error {
 		return fmt.Errorf("export collection properties at %s: %w", path, err)
 	}
 	col.props = props
-
-	// Explicitly export the standard D-Bus Properties interface for proper introspection.
-	// This ensures clients can discover that the object implements org.freedesktop.DBus.Properties.
-	if err := svc.conn.Export(col, path, "org.freedesktop.DBus.Properties"); err != nil {
+	// Explicitly export the standard D-Bus Properties interface for proper
+	// introspection, with a handler that knows the caller in place of the
+	// one prop.Export registered.
+	if err := svc.conn.Export(&collectionProperties{props: props, col: col}, path, "org.freedesktop.DBus.Properties"); err != nil {
 		return fmt.Errorf("export collection properties interface at %s: %w", path, err)
 	}
+	svc.exportIntrospection(path, svc.collectionChildren(col.name), CollectionIface)
 
 	return nil
 }
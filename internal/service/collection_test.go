@@ -0,0 +1,154 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package service
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/akihiro/wsl-secret-service/internal/store"
+	"github.com/akihiro/wsl-secret-service/pkg/backend/memory"
+)
+
+var errInjected = errors.New("injected failure")
+
+// failingStore fails to save item metadata.
+type failingStore struct {
+	store.MetadataStore
+}
+
+func (failingStore) CreateItem(string, string, store.ItemMeta) error { return errInjected }
+func (failingStore) UpdateItem(string, string, store.ItemMeta) error { return errInjected }
+
+// failingBackend fails to store the secrets of items.
+type failingBackend struct {
+	*memory.Backend
+}
+
+func (b failingBackend) Set(ctx context.Context, target string, secret []byte) error {
+	if strings.HasPrefix(target, "wsl-ss/login/") {
+		return errInjected
+	}
+	return b.Backend.Set(ctx, target, secret)
+}
+
+func TestCreateItemRollsBack(t *testing.T) {
+	attrs := map[string]string{"service": "s"}
+
+	t.Run("new item", func(t *testing.T) {
+		be := memory.New()
+		svc := &Service{store: failingStore{store.NewMemory()}, backend: be, stopped: t.Context()}
+		if _, err := svc.createItem("login", store.ItemMeta{Label: "new", Attributes: attrs}, []byte("secret"), false); !errors.Is(err, errInjected) {
+			t.Fatalf("createItem: err = %v, want the store's error", err)
+		}
+		if targets, err := be.List(t.Context(), "wsl-ss/login/"); err != nil || len(targets) != 0 {
+			t.Errorf("backend holds %q after the metadata failed to save, want nothing", targets)
+		}
+	})
+
+	// replacing sets up an item to be replaced and returns its store.
+	const id = "0b6f1c0e-8a43-4b8e-9f61-2f1a3c5d7e90"
+	replacing := func(t *testing.T, be *memory.Backend) *store.Store {
+		t.Helper()
+		st := store.NewMemory()
+		if err := st.CreateItem("login", id, store.ItemMeta{Label: "old", Attributes: attrs, Checksum: "c"}); err != nil {
+			t.Fatal(err)
+		}
+		if err := be.Set(t.Context(), uuidTarget("login", id), []byte("old secret")); err != nil {
+			t.Fatal(err)
+		}
+		return st
+	}
+	check := func(t *testing.T, st *store.Store, be *memory.Backend) {
+		t.Helper()
+		if meta, _ := st.GetItem("login", id); meta.Label != "old" || meta.Checksum != "c" {
+			t.Errorf("metadata = %+v, want the old one", meta)
+		}
+		if got, err := be.Get(t.Context(), uuidTarget("login", id)); err != nil || string(got) != "old secret" {
+			t.Errorf("secret = %q, %v, want the old one", got, err)
+		}
+	}
+
+	t.Run("replace, metadata fails", func(t *testing.T) {
+		be := memory.New()
+		st := replacing(t, be)
+		svc := &Service{store: failingStore{st}, backend: be, stopped: t.Context()}
+		if _, err := svc.createItem("login", store.ItemMeta{Label: "new", Attributes: attrs}, []byte("new secret"), true); !errors.Is(err, errInjected) {
+			t.Fatalf("createItem: err = %v, want the store's error", err)
+		}
+		check(t, st, be)
+	})
+
+	t.Run("replace, secret fails", func(t *testing.T) {
+		be := memory.New()
+		st := replacing(t, be)
+		svc := &Service{store: st, backend: failingBackend{be}, stopped: t.Context()}
+		if _, err := svc.createItem("login", store.ItemMeta{Label: "new", Attributes: attrs}, []byte("new secret"), true); !errors.Is(err, errInjected) {
+			t.Fatalf("createItem: err = %v, want the backend's error", err)
+		}
+		check(t, st, be)
+	})
+}
+
+func TestSetItemMetaRewritesBackend(t *testing.T) {
+	const id = "5d0e7a52-3c1b-4f7e-8d2a-9b6c4e1f0a37"
+	target := uuidTarget("login", id)
+	setup := func(t *testing.T) (*store.Store, *memory.Backend) {
+		t.Helper()
+		st := store.NewMemory()
+		if err := st.CreateItem("login", id, store.ItemMeta{Label: "old"}); err != nil {
+			t.Fatal(err)
+		}
+		be := memory.New()
+		if err := be.Set(t.Context(), target, []byte("secret")); err != nil {
+			t.Fatal(err)
+		}
+		return st, be
+	}
+
+	st, be := setup(t)
+	svc := &Service{store: st, backend: be, stopped: t.Context()}
+	attrs := map[string]string{"service": "s"}
+	if derr := svc.setItemMeta("login", id, store.ItemMeta{Label: "new", Attributes: attrs}); derr != nil {
+		t.Fatalf("setItemMeta: %v", derr)
+	}
+	metas, err := be.ListMetadata(t.Context(), target)
+	if err != nil || metas[target].Label != "new" || metas[target].Attributes["service"] != "s" {
+		t.Errorf("backend metadata = %+v, %v, want the new label and attributes", metas[target], err)
+	}
+	if got, err := be.Get(t.Context(), target); err != nil || string(got) != "secret" {
+		t.Errorf("secret = %q, %v, want it unchanged", got, err)
+	}
+
+	st, be = setup(t)
+	svc = &Service{store: st, backend: failingBackend{be}, stopped: t.Context()}
+	if derr := svc.setItemMeta("login", id, store.ItemMeta{Label: "new"}); derr == nil {
+		t.Fatal("setItemMeta succeeded although the backend failed")
+	}
+	if meta, _ := st.GetItem("login", id); meta.Label != "old" {
+		t.Errorf("label = %q after the backend failed, want the old one", meta.Label)
+	}
+}
+
+func TestDeleteRefusedWhileLocked(t *testing.T) {
+	const id = "8f2c6b1e-4d7a-4a3e-9c5b-0e1d7f6a2b84"
+	st := store.NewMemory()
+	if err := st.CreateItem("login", id, store.ItemMeta{Label: "kept"}); err != nil {
+		t.Fatal(err)
+	}
+	svc := &Service{store: st, backend: memory.New(), stopped: t.Context()}
+	svc.locks = lockState{locked: map[string]bool{"login": true}, accessed: map[string]time.Time{}}
+
+	if _, derr := (&Item{collectionName: "login", uuid: id, svc: svc}).Delete(":1.7"); derr == nil || derr.Name != "org.freedesktop.Secret.Error.IsLocked" {
+		t.Errorf("Item.Delete in a locked collection = %v, want IsLocked", derr)
+	}
+	if _, derr := (&Collection{name: "login", svc: svc}).Delete(":1.7"); derr == nil || derr.Name != "org.freedesktop.Secret.Error.IsLocked" {
+		t.Errorf("Collection.Delete of a locked collection = %v, want IsLocked", derr)
+	}
+	if _, ok := st.GetItem("login", id); !ok {
+		t.Error("item deleted from a locked collection")
+	}
+}
@@ -0,0 +1,123 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package service
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/akihiro/wsl-secret-service/internal/audit"
+	"github.com/akihiro/wsl-secret-service/internal/config"
+	"github.com/akihiro/wsl-secret-service/internal/store"
+	"github.com/godbus/dbus/v5"
+)
+
+// ConfirmAttribute is the item attribute that, when set to "true", makes
+// the daemon ask the user to allow each client's access in a Windows dialog
+// before the item's secret is returned, as a "confirm" policy rule does.
+const ConfirmAttribute = "wsl:confirm"
+
+// approvalTTL is how long an approval lets the same client read the same
+// item again without asking, so that a tool reading a token several times
+// in a row shows a single dialog.
+const approvalTTL = time.Minute
+
+// confirmAccess asks the user (see Options.Prompter) to allow sender to
+// read the secret of the item at path if the item has ConfirmAttribute or
+// a "confirm" policy rule matches it. Other items pass without interaction.
+// The user's answer is recorded in the audit log.
+func (svc *Service) confirmAccess(sender dbus.Sender, path dbus.ObjectPath, colName string, meta store.ItemMeta) *dbus.Error {
+	policy := svc.policy.Load()
+	flagged := meta.Attributes[ConfirmAttribute] == "true"
+	if !flagged && policy == nil {
+		return nil
+	}
+	c := svc.caller(sender)
+	if !flagged && policyDecision(policy, c.Exe, colName, meta.Attributes) != config.Confirm {
+		return nil
+	}
+	app := c.Exe
+	if app == "" {
+		app = "An unidentified process"
+	}
+	derr, asked := svc.approve(c.Sender, path, fmt.Sprintf("%s in WSL wants to read the secret %q.", app, meta.Label))
+	if asked {
+		svc.audit(sender, audit.OpConfirm, path, derr)
+	}
+	return derr
+}
+
+// confirmFlagChange asks the user, as confirmAccess does for a read,
+// before attrs replace the attributes of the item at path, described by
+// old, without its ConfirmAttribute. Otherwise a client could clear the
+// flag and read the secret unasked.
+func (svc *Service) confirmFlagChange(sender dbus.Sender, path dbus.ObjectPath, colName string, old store.ItemMeta, attrs map[string]string) *dbus.Error {
+	if old.Attributes[ConfirmAttribute] != "true" || attrs[ConfirmAttribute] == "true" {
+		return nil
+	}
+	return svc.confirmAccess(sender, path, colName, old)
+}
+
+// approve shows message through the confirmation prompter unless client
+// was allowed to access path within approvalTTL. asked reports whether the
+// user was asked.
+func (svc *Service) approve(client string, path dbus.ObjectPath, message string) (derr *dbus.Error, asked bool) {
+	if svc.approvals.has(client, path) {
+		return nil, false
+	}
+	if err := svc.confirmPrompter.Confirm(svc.stopped, message); err != nil {
+		logger.Info("access not confirmed", "client", client, "object", path, "err", err)
+		return dbusError("org.freedesktop.Secret.Error.AccessDenied", err.Error()), true
+	}
+	svc.approvals.add(client, path)
+	return nil, true
+}
+
+// approvalCache remembers recent approvals per client and object. The zero
+// value is ready to use.
+type approvalCache struct {
+	mu      sync.Mutex
+	expires map[approvalKey]time.Time
+}
+
+type approvalKey struct {
+	client string
+	object dbus.ObjectPath
+}
+
+func (c *approvalCache) has(client string, object dbus.ObjectPath) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	exp, ok := c.expires[approvalKey{client, object}]
+	return ok && time.Now().Before(exp)
+}
+
+func (c *approvalCache) add(client string, object dbus.ObjectPath) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	now := time.Now()
+	if c.expires == nil {
+		c.expires = make(map[approvalKey]time.Time)
+	}
+	for k, exp := range c.expires {
+		if now.After(exp) {
+			delete(c.expires, k)
+		}
+	}
+	c.expires[approvalKey{client, object}] = now.Add(approvalTTL)
+}
+
+// flush forgets all approvals.
+func (c *approvalCache) flush() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	clear(c.expires)
+}
+
+// len returns the number of approvals held, including expired ones.
+func (c *approvalCache) len() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return len(c.expires)
+}
@@ -0,0 +1,79 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package service
+
+import (
+	"context"
+	"testing"
+
+	"github.com/akihiro/wsl-secret-service/internal/store"
+	"github.com/akihiro/wsl-secret-service/pkg/backend/memory"
+)
+
+// countingPrompter answers Confirm with err and counts the questions.
+type countingPrompter struct {
+	err   error
+	asked int
+}
+
+func (p *countingPrompter) Confirm(context.Context, string) error {
+	p.asked++
+	return p.err
+}
+
+// newConfirmService returns a service holding one item with attrs, whose
+// confirmations go to p, and a handle on that item.
+func newConfirmService(t *testing.T, p *countingPrompter, attrs map[string]string) (*Service, *Item) {
+	t.Helper()
+	const id = "0b6f3d9e-2c4a-4e71-9a5d-7f1e8c3b2a60"
+	st := store.NewMemory()
+	if err := st.CreateItem("login", id, store.ItemMeta{Label: "token", Attributes: attrs}); err != nil {
+		t.Fatal(err)
+	}
+	svc := &Service{store: st, backend: memory.New(), confirmPrompter: p, stopped: t.Context()}
+	svc.callers.callers = map[string]callerInfo{":1.7": {Sender: ":1.7"}, ":1.8": {Sender: ":1.8"}}
+	return svc, &Item{collectionName: "login", uuid: id, svc: svc}
+}
+
+func TestConfirmAccess(t *testing.T) {
+	p := &countingPrompter{}
+	svc, item := newConfirmService(t, p, map[string]string{ConfirmAttribute: "true"})
+	path := ItemPath(item.collectionName, item.uuid)
+	meta, _ := svc.store.GetItem(item.collectionName, item.uuid)
+
+	if derr := svc.confirmAccess(":1.7", path, "login", meta); derr != nil {
+		t.Fatalf("allowed read: %v", derr)
+	}
+	if derr := svc.confirmAccess(":1.7", path, "login", meta); derr != nil || p.asked != 1 {
+		t.Errorf("second read by the same client: %v, asked %d times, want once", derr, p.asked)
+	}
+	p.err = errDenied
+	if derr := svc.confirmAccess(":1.8", path, "login", meta); derr == nil {
+		t.Error("another client read the item although the user said no")
+	}
+	if derr := svc.confirmAccess(":1.8", path, "login", store.ItemMeta{Label: "plain"}); derr != nil {
+		t.Errorf("unflagged item: %v", derr)
+	}
+	if p.asked != 2 {
+		t.Errorf("asked %d times, want 2", p.asked)
+	}
+}
+
+func TestClearingConfirmNeedsConfirmation(t *testing.T) {
+	p := &countingPrompter{err: errDenied}
+	svc, item := newConfirmService(t, p, map[string]string{"service": "x", ConfirmAttribute: "true"})
+
+	if derr := svc.checkFlagChange(":1.7", item, map[string]string{"service": "x"}); derr == nil {
+		t.Error("flag cleared although the user said no")
+	}
+	if derr := svc.checkFlagChange(":1.7", item, map[string]string{"service": "y", ConfirmAttribute: "true"}); derr != nil {
+		t.Errorf("keeping the flag: %v", derr)
+	}
+	if p.asked != 1 {
+		t.Errorf("asked %d times, want 1", p.asked)
+	}
+	p.err = nil
+	if derr := svc.checkFlagChange(":1.7", item, map[string]string{"service": "x"}); derr != nil {
+		t.Errorf("clearing the flag with the user's approval: %v", derr)
+	}
+}
@@ -0,0 +1,89 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package service
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/akihiro/wsl-secret-service/internal/store"
+)
+
+// systemdCredentialSchema is the xdg:schema attribute given to items seeded
+// from systemd credentials; the credential name is stored in the
+// "credential" attribute.
+const systemdCredentialSchema = "io.systemd.Credential"
+
+// ImportSystemdCredentials seeds a collection with every credential file in
+// dir, the directory systemd passes in $CREDENTIALS_DIRECTORY for
+// LoadCredential= and SetCredential= entries. The collection is named after
+// the slug of label and created, labelled label, if it does not exist. Each
+// credential becomes (or replaces) one item labelled with the credential
+// name; items whose secret is unchanged are left alone so that restarts do
+// not rewrite the Credential Manager. It returns the number of items
+// created or updated.
+func (svc *Service) ImportSystemdCredentials(dir, label string) (int, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return 0, fmt.Errorf("read credentials directory: %w", err)
+	}
+
+	colName := collectionSlug(label)
+	if _, ok := svc.store.GetCollection(colName); !ok {
+		if err := svc.addCollection(colName, label, ""); err != nil {
+			return 0, fmt.Errorf("create collection %q: %w", colName, err)
+		}
+	}
+
+	imported := 0
+	for _, e := range entries {
+		if !e.Type().IsRegular() {
+			continue
+		}
+		name := e.Name()
+		changed, err := svc.importSystemdCredential(filepath.Join(dir, name), name, colName)
+		if err != nil {
+			logger.Warn("could not import systemd credential", "credential", name, "err", err)
+			continue
+		}
+		if changed {
+			imported++
+		}
+	}
+	return imported, nil
+}
+
+// importSystemdCredential stores a single credential file and reports
+// whether the stored secret changed.
+func (svc *Service) importSystemdCredential(path, name, colName string) (bool, error) {
+	attrs := map[string]string{
+		"xdg:schema": systemdCredentialSchema,
+		"credential": name,
+	}
+
+	svc.plaintext.acquire()
+	plaintext, err := os.ReadFile(path)
+	if err != nil {
+		svc.plaintext.release()
+		return false, err
+	}
+	defer svc.plaintext.release(plaintext)
+
+	if refs := svc.store.SearchItemsInCollection(colName, attrs); len(refs) > 0 {
+		meta, _ := svc.store.GetItem(colName, refs[0].UUID)
+		if meta.Checksum != "" && meta.Checksum == svc.checksum(plaintext) {
+			return false, nil
+		}
+	}
+
+	meta := store.ItemMeta{
+		Label:       name,
+		Attributes:  attrs,
+		ContentType: "text/plain; charset=utf8",
+	}
+	if _, err := svc.createItem(colName, meta, plaintext, true); err != nil {
+		return false, err
+	}
+	return true, nil
+}
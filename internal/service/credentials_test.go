@@ -0,0 +1,71 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package service
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/akihiro/wsl-secret-service/internal/store"
+	"github.com/akihiro/wsl-secret-service/pkg/backend/memory"
+)
+
+func TestImportSystemdCredentials(t *testing.T) {
+	addr := privateBus(t)
+	st, be := store.NewMemory(), memory.New()
+	svc, err := New(t.Context(), dialBus(t, addr), st, be, Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	dir := t.TempDir()
+	write := func(name, value string) {
+		t.Helper()
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(value), 0o600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	write("db-password", "hunter2")
+	write("api-token", "abc123")
+	if err := os.Mkdir(filepath.Join(dir, "subdir"), 0o700); err != nil {
+		t.Fatal(err)
+	}
+	secretOf := func(name string) string {
+		t.Helper()
+		refs := st.SearchItemsInCollection("systemd", map[string]string{"xdg:schema": systemdCredentialSchema, "credential": name})
+		if len(refs) != 1 {
+			t.Fatalf("%d items for credential %s, want 1", len(refs), name)
+		}
+		secret, err := be.Get(t.Context(), svc.itemTarget("systemd", refs[0].UUID))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return string(secret)
+	}
+
+	if n, err := svc.ImportSystemdCredentials(dir, "Systemd"); err != nil || n != 2 {
+		t.Fatalf("first import = %d, %v, want 2", n, err)
+	}
+	if col, ok := st.GetCollection("systemd"); !ok || col.Label != "Systemd" {
+		t.Fatalf("collection systemd = %+v, %v", col, ok)
+	}
+	if got := secretOf("db-password"); got != "hunter2" {
+		t.Errorf("db-password = %q", got)
+	}
+
+	// A restart with the same credentials changes nothing.
+	if n, err := svc.ImportSystemdCredentials(dir, "Systemd"); err != nil || n != 0 {
+		t.Errorf("second import = %d, %v, want 0", n, err)
+	}
+
+	write("db-password", "correct horse")
+	if n, err := svc.ImportSystemdCredentials(dir, "Systemd"); err != nil || n != 1 {
+		t.Errorf("import after a change = %d, %v, want 1", n, err)
+	}
+	if got := secretOf("db-password"); got != "correct horse" {
+		t.Errorf("db-password after the change = %q", got)
+	}
+	if items := st.ListItems("systemd"); len(items) != 2 {
+		t.Errorf("collection holds %d items, want 2", len(items))
+	}
+}
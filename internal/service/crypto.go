@@ -5,10 +5,13 @@ package service
 import (
 	"crypto/aes"
 	"crypto/cipher"
+	"crypto/hkdf"
 	"crypto/rand"
 	"crypto/sha256"
 	"errors"
 	"math/big"
+
+	"github.com/akihiro/wsl-secret-service/internal/memprotect"
 )
 
 // ietf1024Prime is the 1024-bit prime for the IETF DH group (RFC 2409 Group 2).
@@ -45,19 +48,54 @@ func dhGenerateKeyPair() (priv, pub *big.Int, err error) {
 	return priv, pub, nil
 }
 
-// dhDeriveAESKey computes the DH shared secret and derives a 16-byte AES-128 key.
-// sharedSecret = peerPubKey^privKey mod p, then aesKey = SHA256(sharedSecret)[0:16].
-func dhDeriveAESKey(privKey, peerPubKey *big.Int) []byte {
+// errInvalidPublicKey is returned for a client DH public key outside
+// [2, p-2]. 0 and 1, p-1 and values of p or more would force a shared secret
+// the client or an attacker in the middle can predict.
+var errInvalidPublicKey = errors.New("invalid DH public key")
+
+// dhCheckPublicKey rejects degenerate and out-of-range public keys. With
+// the safe prime of the IETF group, every value in [2, p-2] generates a
+// subgroup of order (p-1)/2 or p-1, so no further check is needed.
+func dhCheckPublicKey(pub *big.Int) error {
+	pMinus2 := new(big.Int).Sub(ietf1024Prime, big.NewInt(2))
+	if pub.Cmp(big.NewInt(2)) < 0 || pub.Cmp(pMinus2) > 0 {
+		return errInvalidPublicKey
+	}
+	return nil
+}
+
+// dhDeriveAESKey computes the DH shared secret and derives a 16-byte AES-128
+// key as the Secret Service specification does: sharedSecret =
+// peerPubKey^privKey mod p, then aesKey = HKDF-SHA256(sharedSecret) with a
+// NUL salt and empty info, as libsecret implements it.
+// With legacy set it instead returns SHA256(sharedSecret)[0:16], the
+// derivation of earlier versions of this daemon.
+func dhDeriveAESKey(privKey, peerPubKey *big.Int, legacy bool) ([]byte, error) {
 	shared := new(big.Int).Exp(peerPubKey, privKey, ietf1024Prime)
 
 	// Encode the shared secret as a fixed-size big-endian byte array (pad to group size).
-	sharedBytes := make([]byte, dhGroupSize)
+	sharedBytes := allocKey(dhGroupSize)
+	defer memprotect.Wipe(sharedBytes)
 	b := shared.Bytes()
 	copy(sharedBytes[dhGroupSize-len(b):], b)
+	clear(b)
 
-	// AES-128 key = first 16 bytes of SHA-256(sharedSecret).
-	hash := sha256.Sum256(sharedBytes)
-	return hash[:16]
+	if legacy {
+		hash := sha256.Sum256(sharedBytes)
+		defer clear(hash[:])
+		return append([]byte(nil), hash[:16]...), nil
+	}
+	return hkdf.Key(sha256.New, sharedBytes, nil, "", 16)
+}
+
+// allocKey returns a zeroed n-byte buffer for session key material, in
+// memfd_secret memory where available (see memprotect.SecretAlloc) and
+// otherwise from memprotect.AllocSecret. Release it with memprotect.Wipe.
+func allocKey(n int) []byte {
+	if b, err := memprotect.SecretAlloc(n); err == nil {
+		return b
+	}
+	return memprotect.AllocSecret(n)
 }
 
 // bigIntToGroupBytes serializes a big.Int to a fixed-size big-endian byte slice padded
@@ -81,6 +119,7 @@ func aesEncrypt(key, plaintext []byte) (iv, ciphertext []byte, err error) {
 		return nil, nil, err
 	}
 	padded := pkcs7Pad(plaintext, aes.BlockSize)
+	defer memprotect.Wipe(padded)
 	ciphertext = make([]byte, len(padded))
 	cipher.NewCBCEncrypter(block, iv).CryptBlocks(ciphertext, padded)
 	return iv, ciphertext, nil
@@ -95,14 +134,20 @@ func aesDecrypt(key, iv, ciphertext []byte) ([]byte, error) {
 	if err != nil {
 		return nil, err
 	}
-	plaintext := make([]byte, len(ciphertext))
+	plaintext := memprotect.AllocSecret(len(ciphertext))
 	cipher.NewCBCDecrypter(block, iv).CryptBlocks(plaintext, ciphertext)
-	return pkcs7Unpad(plaintext)
+	unpadded, err := pkcs7Unpad(plaintext)
+	if err != nil {
+		memprotect.Wipe(plaintext)
+		return nil, err
+	}
+	return unpadded, nil
 }
 
+// pkcs7Pad returns a padded copy of data allocated with memprotect.AllocSecret.
 func pkcs7Pad(data []byte, blockSize int) []byte {
 	padding := blockSize - len(data)%blockSize
-	out := make([]byte, len(data)+padding)
+	out := memprotect.AllocSecret(len(data) + padding)
 	copy(out, data)
 	for i := len(data); i < len(out); i++ {
 		out[i] = byte(padding)
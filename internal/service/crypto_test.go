@@ -0,0 +1,213 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package service
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"math/big"
+	"testing"
+
+	"github.com/akihiro/wsl-secret-service/internal/store"
+	"github.com/akihiro/wsl-secret-service/pkg/backend/memory"
+	"github.com/godbus/dbus/v5"
+)
+
+func TestDHCheckPublicKey(t *testing.T) {
+	pMinus1 := new(big.Int).Sub(ietf1024Prime, big.NewInt(1))
+	pMinus2 := new(big.Int).Sub(ietf1024Prime, big.NewInt(2))
+	for _, tc := range []struct {
+		name string
+		pub  *big.Int
+		ok   bool
+	}{
+		{"zero", big.NewInt(0), false},
+		{"one", big.NewInt(1), false},
+		{"p-1", pMinus1, false},
+		{"p", ietf1024Prime, false},
+		{"p+1", new(big.Int).Add(ietf1024Prime, big.NewInt(1)), false},
+		{"two", big.NewInt(2), true},
+		{"p-2", pMinus2, true},
+	} {
+		err := dhCheckPublicKey(tc.pub)
+		if tc.ok && err != nil {
+			t.Errorf("%s: unexpected error %v", tc.name, err)
+		}
+		if !tc.ok && !errors.Is(err, errInvalidPublicKey) {
+			t.Errorf("%s: err = %v, want errInvalidPublicKey", tc.name, err)
+		}
+	}
+}
+
+func TestNegotiateSessionKeyRejectsDegenerateKeys(t *testing.T) {
+	for _, pub := range [][]byte{nil, {0}, {1}, new(big.Int).Sub(ietf1024Prime, big.NewInt(1)).Bytes()} {
+		if _, _, err := negotiateSessionKey(pub, false); !errors.Is(err, errInvalidPublicKey) {
+			t.Errorf("negotiateSessionKey(%x) err = %v, want errInvalidPublicKey", pub, err)
+		}
+	}
+}
+
+func TestNegotiateSessionKeyAgrees(t *testing.T) {
+	for _, legacy := range []bool{false, true} {
+		clientPriv, clientPub, err := dhGenerateKeyPair()
+		if err != nil {
+			t.Fatal(err)
+		}
+		serverKey, serverPub, err := negotiateSessionKey(clientPub.Bytes(), legacy)
+		if err != nil {
+			t.Fatalf("negotiateSessionKey: %v", err)
+		}
+		clientKey, err := dhDeriveAESKey(clientPriv, new(big.Int).SetBytes(serverPub), legacy)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(serverKey) != 16 || !bytes.Equal(serverKey, clientKey) {
+			t.Errorf("legacy=%v: server key %x, client key %x", legacy, serverKey, clientKey)
+		}
+	}
+}
+
+// TestDHDeriveAESKeyKnownAnswer checks both derivations against values
+// computed independently from the same DH exchange.
+func TestDHDeriveAESKeyKnownAnswer(t *testing.T) {
+	priv, _ := new(big.Int).SetString("0123456789abcdef0123456789abcdef0123456789abcdef0123456789abcdef", 16)
+	peerPriv, _ := new(big.Int).SetString("fedcba9876543210fedcba9876543210fedcba9876543210fedcba9876543210", 16)
+	peerPub := new(big.Int).Exp(ietf1024Generator, peerPriv, ietf1024Prime)
+	for _, tc := range []struct {
+		legacy bool
+		want   string
+	}{
+		{false, "121115bc961e0921dd6543a1249ea1df"}, // HKDF-SHA256, NUL salt, empty info
+		{true, "5a69ee0d11894973f676b4e8d01d08ee"},  // SHA256(shared)[0:16]
+	} {
+		key, err := dhDeriveAESKey(priv, peerPub, tc.legacy)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := hex.EncodeToString(key); got != tc.want {
+			t.Errorf("legacy=%v: key %s, want %s", tc.legacy, got, tc.want)
+		}
+	}
+}
+
+// referenceClientKey derives the session key the way libsecret's client
+// does, with HKDF written out from RFC 5869 rather than crypto/hkdf.
+func referenceClientKey(priv *big.Int, serverPub []byte) []byte {
+	shared := new(big.Int).Exp(new(big.Int).SetBytes(serverPub), priv, ietf1024Prime)
+	ikm := make([]byte, dhGroupSize)
+	shared.FillBytes(ikm)
+	extract := hmac.New(sha256.New, make([]byte, sha256.Size))
+	extract.Write(ikm)
+	expand := hmac.New(sha256.New, extract.Sum(nil))
+	expand.Write([]byte{1})
+	return expand.Sum(nil)[:16]
+}
+
+// referenceDecrypt decrypts a secret sent in a DH session.
+func referenceDecrypt(t *testing.T, key []byte, s Secret) []byte {
+	t.Helper()
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(s.Value) == 0 || len(s.Value)%aes.BlockSize != 0 {
+		t.Fatalf("ciphertext of %d bytes", len(s.Value))
+	}
+	out := make([]byte, len(s.Value))
+	cipher.NewCBCDecrypter(block, s.Parameters).CryptBlocks(out, s.Value)
+	n := int(out[len(out)-1])
+	if n < 1 || n > aes.BlockSize {
+		t.Fatalf("bad padding in %x: the keys do not match", out)
+	}
+	return out[:len(out)-n]
+}
+
+func TestSessionKeyMatchesReferenceClient(t *testing.T) {
+	addr := privateBus(t)
+	conn := dialBus(t, addr)
+	client := dialBus(t, addr)
+	st, be := store.NewMemory(), memory.New()
+	if err := st.CreateItem("login", "tok", store.ItemMeta{Label: "tok"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := be.Set(t.Context(), uuidTarget("login", "tok"), []byte("hunter2")); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := New(t.Context(), conn, st, be, Options{}); err != nil {
+		t.Fatal(err)
+	}
+	root := client.Object(conn.Names()[0], ServicePath)
+	item := client.Object(conn.Names()[0], ItemPath("login", "tok"))
+
+	priv, pub, err := dhGenerateKeyPair()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var output dbus.Variant
+	var session dbus.ObjectPath
+	if err := root.Call(ServiceIface+".OpenSession", 0, "dh-ietf1024-sha256-aes128-cbc-pkcs7", dbus.MakeVariant(bigIntToGroupBytes(pub))).Store(&output, &session); err != nil {
+		t.Fatalf("OpenSession: %v", err)
+	}
+	serverPub, _ := output.Value().([]byte)
+	var secret Secret
+	if err := item.Call(ItemIface+".GetSecret", 0, session).Store(&secret); err != nil {
+		t.Fatalf("GetSecret: %v", err)
+	}
+	if got := referenceDecrypt(t, referenceClientKey(priv, serverPub), secret); string(got) != "hunter2" {
+		t.Errorf("secret after OpenSession = %q", got)
+	}
+
+	priv, pub, err = dhGenerateKeyPair()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := client.Object(conn.Names()[0], session).Call(SessionExtIface+".Rotate", 0, bigIntToGroupBytes(pub)).Store(&serverPub); err != nil {
+		t.Fatalf("Rotate: %v", err)
+	}
+	if err := item.Call(ItemIface+".GetSecret", 0, session).Store(&secret); err != nil {
+		t.Fatalf("GetSecret: %v", err)
+	}
+	if got := referenceDecrypt(t, referenceClientKey(priv, serverPub), secret); string(got) != "hunter2" {
+		t.Errorf("secret after Rotate = %q", got)
+	}
+}
+
+func TestRotateRefusesOtherClients(t *testing.T) {
+	s := &Session{svc: &Service{}, path: "/org/freedesktop/secrets/session/s1", owner: ":1.1", aesKey: make([]byte, 16)}
+	_, derr := (&sessionExt{s}).Rotate(dbus.Sender(":1.2"), nil)
+	if derr == nil || derr.Name != "org.freedesktop.DBus.Error.AccessDenied" {
+		t.Errorf("Rotate by another client = %v, want AccessDenied", derr)
+	}
+}
+
+// FuzzPKCS7Unpad checks that pkcs7Unpad, which sees the decrypted secrets
+// of clients, rejects malformed padding without panicking, strips exactly
+// the padding it accepts, and undoes pkcs7Pad.
+func FuzzPKCS7Unpad(f *testing.F) {
+	f.Add([]byte{})
+	f.Add([]byte{0})
+	f.Add(bytes.Repeat([]byte{16}, 16))
+	f.Add(bytes.Repeat([]byte{17}, 17))
+	f.Add([]byte("secret\x02\x02"))
+	f.Add([]byte("secret\x01\x02"))
+	f.Fuzz(func(t *testing.T, data []byte) {
+		if out, err := pkcs7Unpad(data); err == nil {
+			n := len(data) - len(out)
+			if n < 1 || n > 16 || !bytes.Equal(out, data[:len(out)]) || !bytes.Equal(data[len(out):], bytes.Repeat([]byte{byte(n)}, n)) {
+				t.Fatalf("pkcs7Unpad(%x) = %x", data, out)
+			}
+		}
+		padded := pkcs7Pad(data, 16)
+		if len(padded)%16 != 0 {
+			t.Fatalf("pkcs7Pad(%x) = %x is not a multiple of the block size", data, padded)
+		}
+		if out, err := pkcs7Unpad(padded); err != nil || !bytes.Equal(out, data) {
+			t.Fatalf("pkcs7Unpad(pkcs7Pad(%x)) = %x, %v", data, out, err)
+		}
+	})
+}
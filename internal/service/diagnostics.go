@@ -0,0 +1,99 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package service
+
+import (
+	"fmt"
+	"log/slog"
+	"maps"
+	"runtime"
+	"slices"
+	"time"
+
+	"github.com/akihiro/wsl-secret-service/internal/backend"
+	"github.com/akihiro/wsl-secret-service/internal/memprotect"
+	"github.com/akihiro/wsl-secret-service/internal/version"
+)
+
+// DumpState logs the daemon's state at info level, for debugging hangs in
+// the field (wsl-secret-service does so on SIGUSR1): sessions with their
+// algorithm and age, collections with their item counts and lock state,
+// cache sizes, the idle timer, and what the backend reports through
+// backend.StatsReporter and backend.Diagnoser. Secrets, session keys and
+// item labels are never included. At debug level the stacks of all
+// goroutines follow.
+//
+// DumpState only takes locks that are held briefly, so that it works while
+// the daemon hangs elsewhere.
+func (svc *Service) DumpState() {
+	now := time.Now()
+	idle := now.Sub(time.Unix(svc.lastActivityTimestamp.Load(), 0)).Round(time.Second)
+	attrs := []slog.Attr{
+		slog.String("version", version.String()),
+		slog.Duration("uptime", now.Sub(svc.started).Round(time.Second)),
+		slog.Duration("idle", idle),
+		slog.Duration("idle_timeout", time.Duration(svc.timeoutDuration)*time.Second),
+		slog.Bool("busy", svc.busy()),
+		slog.Int("prompts_running", int(svc.promptsRunning.Load())),
+		slog.Any("sessions", svc.sessions.describe(now)),
+		slog.Any("collections", svc.describeCollections()),
+		slog.Int("plaintext_secrets", len(svc.plaintext)),
+		slog.Int("cached_secrets", svc.secrets.Len()),
+		slog.Int("cached_callers", svc.callers.len()),
+		slog.Int("cached_approvals", svc.approvals.len()),
+		slog.String("swap_protection", memprotect.CurrentLockMode().String()),
+		slog.Int64("locked_bytes", memprotect.LockedBytes()),
+		slog.Int("goroutines", runtime.NumGoroutine()),
+	}
+	if r, ok := backend.As[backend.StatsReporter](svc.backend); ok {
+		stats := r.Stats()
+		for _, name := range slices.Sorted(maps.Keys(stats)) {
+			attrs = append(attrs, slog.Uint64(name, stats[name]))
+		}
+	}
+	if d, ok := backend.As[backend.Diagnoser](svc.backend); ok {
+		attrs = append(attrs, d.Diagnostics()...)
+	}
+	logger.LogAttrs(svc.stopped, slog.LevelInfo, "diagnostic dump", attrs...)
+
+	if logger.Enabled(svc.stopped, slog.LevelDebug) {
+		buf := make([]byte, 1<<20)
+		buf = buf[:runtime.Stack(buf, true)]
+		logger.Debug("goroutine stacks", "stacks", string(buf))
+	}
+}
+
+// describe lists the open sessions as "path algorithm age=... idle=...".
+func (r *sessionRegistry) describe(now time.Time) []string {
+	r.mu.Lock()
+	sessions := make([]*Session, 0, len(r.sessions))
+	for _, s := range r.sessions {
+		sessions = append(sessions, s)
+	}
+	r.mu.Unlock()
+
+	list := make([]string, 0, len(sessions))
+	for _, s := range sessions {
+		s.mu.Lock()
+		algorithm := "plain"
+		if s.aesKey != nil {
+			algorithm = "dh-ietf1024-sha256-aes128-cbc-pkcs7"
+		}
+		age, idle := now.Sub(s.keyed).Round(time.Second), now.Sub(s.lastUsed).Round(time.Second)
+		s.mu.Unlock()
+		list = append(list, fmt.Sprintf("%s %s owner=%s age=%s idle=%s", s.path, algorithm, s.owner, age, idle))
+	}
+	slices.Sort(list)
+	return list
+}
+
+// describeCollections lists the collections as "name items=n locked=b".
+func (svc *Service) describeCollections() []string {
+	cols := svc.store.ListCollections()
+	slices.Sort(cols)
+	list := make([]string, 0, len(cols))
+	for _, name := range cols {
+		list = append(list, fmt.Sprintf("%s items=%d locked=%t password=%t", name, len(svc.store.ListItems(name)), svc.isLocked(name), svc.hasPassword(name)))
+	}
+	return list
+}
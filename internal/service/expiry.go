@@ -0,0 +1,84 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package service
+
+import (
+	"context"
+	"time"
+
+	"github.com/akihiro/wsl-secret-service/internal/store"
+)
+
+// ExpiresAttribute is the item attribute making an item expire: an RFC 3339
+// time, or a Go duration such as "15m" counted from the last change of the
+// item, so that storing a new secret extends it. The secrets of expired
+// items are no longer returned, and a background task deletes the items
+// from the store and the backend.
+const ExpiresAttribute = "wsl:expires"
+
+// expiryInterval is how often items are checked for expiry.
+const expiryInterval = time.Minute
+
+// itemExpiry returns when the item described by meta expires, if it has a
+// valid ExpiresAttribute.
+func itemExpiry(meta store.ItemMeta) (time.Time, bool) {
+	v, ok := meta.Attributes[ExpiresAttribute]
+	if !ok {
+		return time.Time{}, false
+	}
+	if t, err := time.Parse(time.RFC3339, v); err == nil {
+		return t, true
+	}
+	if ttl, err := time.ParseDuration(v); err == nil {
+		return time.Unix(int64(meta.Modified), 0).Add(ttl), true
+	}
+	return time.Time{}, false
+}
+
+// expired reports whether the item described by meta has expired at now.
+// Reads check it, so that no secret is released between its expiry and
+// the next run of expireItems.
+func expired(meta store.ItemMeta, now time.Time) bool {
+	exp, ok := itemExpiry(meta)
+	return ok && !now.Before(exp)
+}
+
+// startItemExpiry launches a background goroutine deleting expired items.
+func (svc *Service) startItemExpiry(ctx context.Context) {
+	go func() {
+		ticker := time.NewTicker(expiryInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case now := <-ticker.C:
+				svc.expireItems(now)
+			}
+		}
+	}()
+}
+
+// expireItems deletes the items that have expired at now, with their
+// secrets, and emits ItemDeleted for them. Like Item.Delete it tombstones
+// the item first, so a secret that cannot be deleted is retried at the
+// next start.
+func (svc *Service) expireItems(now time.Time) {
+	for _, colName := range svc.store.ListCollections() {
+		for _, id := range svc.store.ListItems(colName) {
+			meta, ok := svc.store.GetItem(colName, id)
+			if !ok || readOnlyItem(meta) {
+				continue
+			}
+			exp, ok := itemExpiry(meta)
+			if !ok || now.Before(exp) {
+				continue
+			}
+			if err := svc.deleteItem(colName, id, svc.itemTarget(colName, id)); err != nil {
+				logger.Warn("could not delete expired item", "collection", colName, "item", id, "err", err)
+				continue
+			}
+			logger.Info("expired item deleted", "collection", colName, "item", id, "expired", exp)
+		}
+	}
+}
@@ -0,0 +1,81 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package service
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/akihiro/wsl-secret-service/internal/store"
+	"github.com/akihiro/wsl-secret-service/pkg/backend/memory"
+	"github.com/godbus/dbus/v5"
+)
+
+func TestItemExpiry(t *testing.T) {
+	modified := time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC)
+	for _, tc := range []struct {
+		value string
+		want  time.Time
+		ok    bool
+	}{
+		{"2026-03-02T00:00:00Z", time.Date(2026, 3, 2, 0, 0, 0, 0, time.UTC), true},
+		{"15m", modified.Add(15 * time.Minute), true},
+		{"tomorrow", time.Time{}, false},
+	} {
+		meta := store.ItemMeta{Attributes: map[string]string{ExpiresAttribute: tc.value}, Modified: uint64(modified.Unix())}
+		got, ok := itemExpiry(meta)
+		if ok != tc.ok || !got.Equal(tc.want) {
+			t.Errorf("itemExpiry(%q) = %v, %v, want %v, %v", tc.value, got, ok, tc.want, tc.ok)
+		}
+	}
+	if _, ok := itemExpiry(store.ItemMeta{}); ok {
+		t.Error("itemExpiry of an item without the attribute reports an expiry")
+	}
+}
+
+func TestExpiredItems(t *testing.T) {
+	addr := privateBus(t)
+	conn := dialBus(t, addr)
+	client := dialBus(t, addr)
+	st, be := store.NewMemory(), memory.New()
+	past := time.Now().Add(-time.Hour).Format(time.RFC3339)
+	if err := st.CreateItem("login", "old", store.ItemMeta{Label: "old", Attributes: map[string]string{ExpiresAttribute: past}}); err != nil {
+		t.Fatal(err)
+	}
+	if err := be.Set(t.Context(), uuidTarget("login", "old"), []byte("secret")); err != nil {
+		t.Fatal(err)
+	}
+	svc, err := New(t.Context(), conn, st, be, Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var output dbus.Variant
+	var session dbus.ObjectPath
+	root := client.Object(conn.Names()[0], ServicePath)
+	if err := root.Call(ServiceIface+".OpenSession", 0, "plain", dbus.MakeVariant("")).Store(&output, &session); err != nil {
+		t.Fatalf("OpenSession: %v", err)
+	}
+	item := client.Object(conn.Names()[0], ItemPath("login", "old"))
+	var secret Secret
+	err = item.Call(ItemIface+".GetSecret", 0, session).Store(&secret)
+	var derr dbus.Error
+	if !errors.As(err, &derr) || derr.Name != "org.freedesktop.Secret.Error.NoSuchObject" {
+		t.Fatalf("GetSecret of an expired item = %v, want NoSuchObject", err)
+	}
+
+	svc.expireItems(time.Now())
+	if _, ok := st.GetItem("login", "old"); ok {
+		t.Error("expired item is still stored")
+	}
+	if _, err := be.Get(t.Context(), uuidTarget("login", "old")); err == nil {
+		t.Error("secret of the expired item is still in the backend")
+	}
+	if ts := st.Tombstones(); len(ts) != 0 {
+		t.Errorf("tombstones after expiry = %v, want none", ts)
+	}
+	if _, ok := svc.exportedItem("login", "old"); ok {
+		t.Error("expired item is still exported")
+	}
+}
@@ -0,0 +1,189 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package service
+
+import (
+	"cmp"
+	"fmt"
+	"maps"
+	"slices"
+	"strings"
+
+	"github.com/akihiro/wsl-secret-service/internal/backend"
+	"github.com/akihiro/wsl-secret-service/internal/store"
+	"github.com/godbus/dbus/v5"
+)
+
+// Kinds of inconsistency reported by Admin.Fsck.
+const (
+	// FsckMissingSecret is an item whose backend entry is gone. Repair
+	// deletes the item.
+	FsckMissingSecret = "missing-secret"
+	// FsckOrphanedSecret is a backend entry under TargetPrefix that no item
+	// refers to. Repair deletes the entry if it is tombstoned, i.e. left by
+	// an interrupted delete of this keyring, and otherwise only once the
+	// user confirms: keyrings of other WSL distributions sharing the
+	// Credential Manager use the same prefix.
+	FsckOrphanedSecret = "orphaned-secret"
+	// FsckDanglingAlias is an alias naming a collection that does not
+	// exist. Repair removes the alias.
+	FsckDanglingAlias = "dangling-alias"
+)
+
+// FsckFinding is one inconsistency found by Admin.Fsck; D-Bus signature
+// (sssb).
+type FsckFinding struct {
+	Kind     string // one of the Fsck* constants
+	Object   string // item path, backend target or alias name
+	Detail   string
+	Repaired bool
+}
+
+// Fsck implements Admin.Fsck(repair): it cross-checks the metadata store
+// against the entries listed by the backend and reports items without
+// secrets, secrets without items and aliases of missing collections. With
+// repair set the inconsistencies are also removed, orphaned secrets as
+// described at FsckOrphanedSecret. Backend entries outside TargetPrefix
+// (e.g. adopted pass entries) and the service's own entries such as the
+// checksum key are never reported, nor are the secrets of CreateItem calls
+// still in progress.
+func (a *Admin) Fsck(sender dbus.Sender, repair bool) ([]FsckFinding, *dbus.Error) {
+	svc := a.svc
+	svc.recordActivity()
+	if repair {
+		if derr := svc.authorizeAdmin(sender, "Fsck"); derr != nil {
+			return nil, derr
+		}
+	}
+
+	// Snapshot the items before listing the backend: an item's secret is
+	// stored before its metadata, so an item created meanwhile cannot be
+	// mistaken for one without a secret.
+	type itemRef struct{ col, uuid, target string }
+	var items []itemRef
+	for _, col := range svc.store.ListCollections() {
+		for _, id := range svc.store.ListItems(col) {
+			items = append(items, itemRef{col, id, svc.itemTarget(col, id)})
+		}
+	}
+	// Creations reserve their target before storing the secret, so while
+	// the reservations are held no new secret appears and those of
+	// creations in progress are known.
+	r := &svc.targetReservations
+	r.mu.Lock()
+	ctx, cancel := svc.backendContext()
+	targets, err := svc.backend.List(ctx, "")
+	// Adopted entries outside the default listing, such as Windows domain
+	// credentials, must not be taken for missing secrets.
+	if ext, ok := backend.As[backend.ExternalEntries](svc.backend); ok && ext.ExternalPrefix() != "" && err == nil {
+		var more []string
+		more, err = svc.backend.List(ctx, ext.ExternalPrefix())
+		targets = append(targets, more...)
+	}
+	cancel()
+	// Conversely, take the targets in use only after listing the backend.
+	inUse := targetsInUse(svc.store)
+	for target := range r.pending {
+		inUse[target] = true
+	}
+	r.mu.Unlock()
+	if err != nil {
+		return nil, dbusError("org.freedesktop.DBus.Error.Failed", fmt.Sprintf("list backend entries: %v", err))
+	}
+	slices.Sort(targets)
+
+	findings := []FsckFinding{}
+	for _, it := range items {
+		if _, found := slices.BinarySearch(targets, it.target); found {
+			continue
+		}
+		f := FsckFinding{Kind: FsckMissingSecret, Object: string(ItemPath(it.col, it.uuid)), Detail: "no backend entry " + it.target}
+		if repair {
+			if err := svc.removeItem(it.col, it.uuid); err != nil {
+				f.Detail += ": " + err.Error()
+			} else {
+				f.Repaired = true
+			}
+		}
+		findings = append(findings, f)
+	}
+
+	tombstones := svc.store.Tombstones()
+	var orphans []string
+	for _, target := range targets {
+		name, ours := strings.CutPrefix(target, TargetPrefix)
+		// Names starting with "." hold service state, not items.
+		if !ours || strings.HasPrefix(name, ".") || inUse[target] {
+			continue
+		}
+		if _, ok := tombstones[target]; !ok {
+			orphans = append(orphans, target)
+			continue
+		}
+		f := FsckFinding{Kind: FsckOrphanedSecret, Object: target, Detail: "left by an interrupted delete"}
+		if repair {
+			err := svc.deleteSecret(target)
+			svc.clearTombstones([]string{target}, []error{err})
+			if err != nil {
+				f.Detail += ": " + err.Error()
+			} else {
+				f.Repaired = true
+			}
+		}
+		findings = append(findings, f)
+	}
+	confirmed := false
+	if repair && len(orphans) > 0 {
+		msg := fmt.Sprintf("Delete %d %s entries of the Credential Manager that no item refers to? The keyring of another WSL distribution may own them.", len(orphans), TargetPrefix)
+		if err := svc.confirmPrompter.Confirm(svc.stopped, msg); err != nil {
+			logger.Info("deleting orphaned secrets not confirmed", "count", len(orphans), "err", err)
+		} else {
+			confirmed = true
+		}
+	}
+	for _, target := range orphans {
+		f := FsckFinding{Kind: FsckOrphanedSecret, Object: target, Detail: "no item refers to it"}
+		switch {
+		case confirmed:
+			if err := svc.deleteSecret(target); err != nil {
+				f.Detail += ": " + err.Error()
+			} else {
+				f.Repaired = true
+			}
+		case repair:
+			f.Detail += "; kept, deleting it was not confirmed"
+		}
+		findings = append(findings, f)
+	}
+
+	for _, alias := range slices.Sorted(maps.Keys(svc.store.ListAliases())) {
+		colName := svc.store.GetAlias(alias)
+		if _, ok := svc.store.GetCollection(colName); ok {
+			continue
+		}
+		f := FsckFinding{Kind: FsckDanglingAlias, Object: alias, Detail: "collection " + colName + " does not exist"}
+		if repair {
+			if err := svc.store.SetAlias(alias, ""); err != nil {
+				f.Detail += ": " + err.Error()
+			} else {
+				f.Repaired = true
+			}
+		}
+		findings = append(findings, f)
+	}
+
+	logger.Info("fsck finished", "findings", len(findings), "repair", repair)
+	return findings, nil
+}
+
+// targetsInUse returns the backend targets of all items in st.
+func targetsInUse(st store.MetadataStore) map[string]bool {
+	inUse := make(map[string]bool)
+	for _, col := range st.ListCollections() {
+		for _, id := range st.ListItems(col) {
+			meta, _ := st.GetItem(col, id)
+			inUse[cmp.Or(meta.Target, uuidTarget(col, id))] = true
+		}
+	}
+	return inUse
+}
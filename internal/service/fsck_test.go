@@ -0,0 +1,85 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package service
+
+import (
+	"os"
+	"testing"
+
+	"github.com/akihiro/wsl-secret-service/internal/store"
+	"github.com/akihiro/wsl-secret-service/pkg/backend/memory"
+)
+
+func TestFsckOrphanedSecrets(t *testing.T) {
+	const (
+		foreign   = TargetPrefix + "login/foreign"
+		tombstone = TargetPrefix + "login/deleted"
+		creating  = TargetPrefix + "login/creating"
+	)
+	st, be := store.NewMemory(), memory.New()
+	if err := st.CreateItem("login", "deleted", store.ItemMeta{}); err != nil {
+		t.Fatal(err)
+	}
+	if err := st.TombstoneItem("login", "deleted", tombstone); err != nil {
+		t.Fatal(err)
+	}
+	for _, target := range []string{foreign, tombstone, creating} {
+		if err := be.Set(t.Context(), target, []byte("secret")); err != nil {
+			t.Fatal(err)
+		}
+	}
+	p := &countingPrompter{err: errDenied}
+	svc := &Service{store: st, backend: be, confirmPrompter: p, stopped: t.Context()}
+	uid := uint32(os.Getuid())
+	svc.callers.callers = map[string]callerInfo{":1.7": {Sender: ":1.7", UID: &uid}}
+	_, release := svc.targetReservations.reserve(creating)
+	defer release()
+	admin := &Admin{svc: svc}
+
+	orphans := func(findings []FsckFinding) map[string]bool {
+		repaired := make(map[string]bool)
+		for _, f := range findings {
+			if f.Kind == FsckOrphanedSecret {
+				repaired[f.Object] = f.Repaired
+			}
+		}
+		return repaired
+	}
+
+	findings, derr := admin.Fsck(":1.7", false)
+	if derr != nil {
+		t.Fatal(derr)
+	}
+	if got := orphans(findings); len(got) != 2 || got[foreign] || got[tombstone] {
+		t.Errorf("orphans = %v, want %s and %s unrepaired", got, foreign, tombstone)
+	}
+
+	findings, derr = admin.Fsck(":1.7", true)
+	if derr != nil {
+		t.Fatal(derr)
+	}
+	if got := orphans(findings); got[foreign] || !got[tombstone] || p.asked != 1 {
+		t.Errorf("repair without confirmation: orphans = %v, asked %d times, want only %s repaired after one question", got, p.asked, tombstone)
+	}
+	if _, err := be.Get(t.Context(), foreign); err != nil {
+		t.Errorf("unconfirmed orphan deleted: %v", err)
+	}
+	if _, err := be.Get(t.Context(), creating); err != nil {
+		t.Errorf("secret of a creation in progress deleted: %v", err)
+	}
+	if ts := st.Tombstones(); len(ts) != 0 {
+		t.Errorf("tombstones = %v after the repair, want none", ts)
+	}
+
+	p.err = nil
+	findings, derr = admin.Fsck(":1.7", true)
+	if derr != nil {
+		t.Fatal(derr)
+	}
+	if got := orphans(findings); len(got) != 1 || !got[foreign] {
+		t.Errorf("confirmed repair: orphans = %v, want %s repaired", got, foreign)
+	}
+	if _, err := be.Get(t.Context(), foreign); err == nil {
+		t.Error("confirmed orphan still in the backend")
+	}
+}
@@ -0,0 +1,52 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package service
+
+import (
+	"errors"
+
+	"github.com/godbus/dbus/v5"
+)
+
+// ErrNameLost is reported by Cause when another instance took over BusName,
+// e.g. because it was started with --replace.
+var ErrNameLost = errors.New("bus name taken over by another instance")
+
+// handleNameLost retires this instance after it lost BusName. Every object
+// is unexported, so that clients still addressing this daemon by its unique
+// name get UnknownObject instead of answers from a state that is about to
+// diverge from the new owner's, and the service is stopped. Metadata needs
+// no flushing: every change is written through to the store.
+func (svc *Service) handleNameLost() {
+	if svc.stopped.Err() != nil {
+		return // we released the name ourselves while shutting down
+	}
+	logger.Warn("another instance took over the bus name; shutting down", "name", BusName)
+	svc.unexportAll()
+	svc.shutdownFn(ErrNameLost)
+}
+
+// unexportAll removes every object this service exported from the bus.
+func (svc *Service) unexportAll() {
+	unexport := func(path dbus.ObjectPath, ifaces ...string) {
+		for _, iface := range ifaces {
+			_ = svc.conn.Export(nil, path, iface)
+		}
+		svc.unexportIntrospection(path)
+	}
+
+	for _, s := range svc.sessions.removeAll() {
+		s.shutdown() // unexports SessionIface and SessionExtIface
+	}
+	for alias := range svc.store.ListAliases() {
+		unexport(dbus.ObjectPath(AliasPathPrefix+alias), CollectionIface, "org.freedesktop.DBus.Properties")
+	}
+	for _, colName := range svc.store.ListCollections() {
+		for _, itemUUID := range svc.store.ListItems(colName) {
+			unexport(ItemPath(colName, itemUUID), ItemIface, "org.freedesktop.DBus.Properties")
+		}
+		unexport(CollectionPath(colName), CollectionIface, "org.freedesktop.DBus.Properties")
+	}
+	unexport(PromptStubObjPath, PromptIface)
+	unexport(dbus.ObjectPath(ServicePath), ServiceIface, ServiceExtIface, AdminIface, "org.freedesktop.DBus.Properties")
+}
@@ -0,0 +1,103 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package service
+
+import (
+	"bufio"
+	"errors"
+	"os/exec"
+	"strings"
+	"testing"
+
+	"github.com/godbus/dbus/v5"
+
+	"github.com/akihiro/wsl-secret-service/internal/store"
+	"github.com/akihiro/wsl-secret-service/pkg/backend/memory"
+)
+
+// privateBus starts a dbus-daemon for the test and returns its address. The
+// test is skipped if dbus-daemon is not installed.
+func privateBus(t *testing.T) string {
+	t.Helper()
+	path, err := exec.LookPath("dbus-daemon")
+	if err != nil {
+		t.Skip("dbus-daemon not installed")
+	}
+	cmd := exec.Command(path, "--session", "--nofork", "--print-address=1")
+	out, err := cmd.StdoutPipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := cmd.Start(); err != nil {
+		t.Skipf("cannot start dbus-daemon: %v", err)
+	}
+	t.Cleanup(func() {
+		_ = cmd.Process.Kill()
+		_ = cmd.Wait()
+	})
+	addr, err := bufio.NewReader(out).ReadString('\n')
+	if err != nil {
+		t.Fatalf("read bus address: %v", err)
+	}
+	return strings.TrimSpace(addr)
+}
+
+// dialBus connects to the bus at addr.
+func dialBus(t *testing.T, addr string) *dbus.Conn {
+	t.Helper()
+	conn, err := dbus.Connect(addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = conn.Close() })
+	return conn
+}
+
+func TestNameLostUnexportsExtensions(t *testing.T) {
+	addr := privateBus(t)
+	conn := dialBus(t, addr)
+	client := dialBus(t, addr)
+	svc, err := New(t.Context(), conn, store.NewMemory(), memory.New(), Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	root := client.Object(conn.Names()[0], ServicePath)
+
+	var output dbus.Variant
+	var sessionPath dbus.ObjectPath
+	if err := root.Call(ServiceIface+".OpenSession", 0, "plain", dbus.MakeVariant("")).Store(&output, &sessionPath); err != nil {
+		t.Fatalf("OpenSession: %v", err)
+	}
+	session := client.Object(conn.Names()[0], sessionPath)
+	calls := map[string]func() error{
+		"SearchItemsEx": func() error {
+			return root.Call(ServiceExtIface+".SearchItemsEx", 0, []AttributeMatch{}, map[string]dbus.Variant{}).Err
+		},
+		"SearchItemsByLabel": func() error {
+			return root.Call(ServiceExtIface+".SearchItemsByLabel", 0, "", map[string]dbus.Variant{}).Err
+		},
+		"Rotate": func() error {
+			// Plain sessions have no key to rotate, but do answer.
+			err := session.Call(SessionExtIface+".Rotate", 0, []byte{1}).Err
+			var derr dbus.Error
+			if errors.As(err, &derr) && derr.Name == "org.freedesktop.Secret.Error.NotSupported" {
+				return nil
+			}
+			return err
+		},
+	}
+	for name, call := range calls {
+		if err := call(); err != nil {
+			t.Errorf("%s before losing the name: %v", name, err)
+		}
+	}
+
+	svc.handleNameLost()
+	for name, call := range calls {
+		err := call()
+		var derr dbus.Error
+		if !errors.As(err, &derr) || !strings.HasPrefix(derr.Name, "org.freedesktop.DBus.Error.Unknown") {
+			t.Errorf("%s after losing the name = %v, want an Unknown* error", name, err)
+		}
+	}
+}
@@ -0,0 +1,123 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package service
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"os"
+	"os/exec"
+	"slices"
+	"syscall"
+	"time"
+
+	"github.com/akihiro/wsl-secret-service/internal/config"
+	"github.com/akihiro/wsl-secret-service/internal/notify"
+	"github.com/akihiro/wsl-secret-service/internal/store"
+)
+
+// Hook commands are killed, with their process group, after hookTimeout.
+// At most maxRunningHooks run at the same time; further ones wait.
+const (
+	hookTimeout     = time.Minute
+	maxRunningHooks = 4
+)
+
+// hookOutputLimit caps how much output of a failed hook is logged.
+const hookOutputLimit = 4096
+
+// itemEvent reports that the item itemUUID of collection colName, described
+// by meta, was created, changed or deleted (config.HookCreated, ...) to the
+// hooks and the notifier. Callers emit the D-Bus signal themselves.
+func (svc *Service) itemEvent(event, colName, itemUUID string, meta store.ItemMeta) {
+	svc.runHooks(event, colName, itemUUID, meta)
+	if svc.notifier != nil {
+		svc.notifier.Send(notify.Event{Op: event, Collection: colName, Item: string(ItemPath(colName, itemUUID))})
+	}
+}
+
+// runHooks starts the configured hooks matching event on the item itemUUID
+// of collection colName, described by meta, in the background. Hooks get
+// the item by environment (see hookEnv), never its secret; one wanting the
+// secret must ask for it over D-Bus like any other client.
+func (svc *Service) runHooks(event, colName, itemUUID string, meta store.ItemMeta) {
+	hooks := svc.hooks.Load()
+	if hooks == nil {
+		return
+	}
+	for _, h := range *hooks {
+		if !hookMatches(h, event, colName, meta.Attributes) {
+			continue
+		}
+		env := hookEnv(event, colName, itemUUID, meta)
+		go svc.runHook(h.Command, env)
+	}
+}
+
+// hookMatches reports whether h runs on event for an item of collection
+// colName with attributes attrs.
+func hookMatches(h config.Hook, event, colName string, attrs map[string]string) bool {
+	if len(h.Events) > 0 && !slices.Contains(h.Events, event) {
+		return false
+	}
+	if h.Collection != "" && !globMatch(h.Collection, colName) {
+		return false
+	}
+	for k, pat := range h.Attributes {
+		v, ok := attrs[k]
+		if !ok || !globMatch(pat, v) {
+			return false
+		}
+	}
+	return true
+}
+
+// hookEnv returns the environment of a hook: a fixed PATH, what the hook
+// needs to reach the daemon over D-Bus, and the event and item as
+// WSL_SECRET_* variables. Nothing else of the daemon's environment is
+// passed on.
+func hookEnv(event, colName, itemUUID string, meta store.ItemMeta) []string {
+	env := []string{"PATH=/usr/local/bin:/usr/bin:/bin"}
+	for _, name := range []string{"HOME", "USER", "XDG_RUNTIME_DIR", "DBUS_SESSION_BUS_ADDRESS"} {
+		if v, ok := os.LookupEnv(name); ok {
+			env = append(env, name+"="+v)
+		}
+	}
+	attrs, _ := json.Marshal(attrsOrEmpty(meta.Attributes))
+	return append(env,
+		"WSL_SECRET_EVENT="+event,
+		"WSL_SECRET_COLLECTION="+colName,
+		"WSL_SECRET_ITEM="+string(ItemPath(colName, itemUUID)),
+		"WSL_SECRET_LABEL="+meta.Label,
+		"WSL_SECRET_ATTRIBUTES="+string(attrs),
+	)
+}
+
+// runHook runs command with env and logs its failure. It runs from "/",
+// with no input, in its own process group, so that a timeout or the daemon
+// stopping kills it with its children.
+func (svc *Service) runHook(command, env []string) {
+	svc.hookSlots <- struct{}{}
+	defer func() { <-svc.hookSlots }()
+
+	ctx, cancel := context.WithTimeout(svc.stopped, hookTimeout)
+	defer cancel()
+	cmd := exec.CommandContext(ctx, command[0], command[1:]...)
+	cmd.Env = env
+	cmd.Dir = "/"
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+	cmd.Cancel = func() error { return syscall.Kill(-cmd.Process.Pid, syscall.SIGKILL) }
+	var out bytes.Buffer
+	cmd.Stdout = &out
+	cmd.Stderr = &out
+	if err := cmd.Run(); err != nil {
+		output := out.Bytes()
+		if len(output) > hookOutputLimit {
+			output = output[len(output)-hookOutputLimit:]
+		}
+		logger.Warn("hook failed", "command", command[0], "err", err, "output", string(output))
+		return
+	}
+	logger.Debug("hook ran", "command", command[0])
+}
@@ -0,0 +1,59 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package service
+
+import (
+	"slices"
+	"testing"
+
+	"github.com/akihiro/wsl-secret-service/internal/config"
+	"github.com/akihiro/wsl-secret-service/internal/store"
+)
+
+func TestHookMatches(t *testing.T) {
+	h := config.Hook{
+		Events:     []string{config.HookChanged, config.HookDeleted},
+		Collection: "work-*",
+		Attributes: map[string]string{"service": "git*"},
+		Command:    []string{"/bin/true"},
+	}
+	attrs := map[string]string{"service": "github", "user": "me"}
+	for _, tc := range []struct {
+		event, col string
+		attrs      map[string]string
+		want       bool
+	}{
+		{config.HookChanged, "work-a", attrs, true},
+		{config.HookDeleted, "work-b", attrs, true},
+		{config.HookCreated, "work-a", attrs, false},
+		{config.HookChanged, "login", attrs, false},
+		{config.HookChanged, "work-a", map[string]string{"service": "aws"}, false},
+		{config.HookChanged, "work-a", nil, false},
+	} {
+		if got := hookMatches(h, tc.event, tc.col, tc.attrs); got != tc.want {
+			t.Errorf("hookMatches(%s, %s, %v) = %v, want %v", tc.event, tc.col, tc.attrs, got, tc.want)
+		}
+	}
+	if !hookMatches(config.Hook{Command: []string{"/bin/true"}}, config.HookCreated, "login", nil) {
+		t.Error("hook without match fields does not match")
+	}
+}
+
+func TestHookEnv(t *testing.T) {
+	t.Setenv("SECRET_TOKEN", "hunter2")
+	env := hookEnv(config.HookChanged, "login", "0f8e", store.ItemMeta{Label: "GitHub", Attributes: map[string]string{"service": "github"}})
+	for _, want := range []string{
+		"WSL_SECRET_EVENT=changed",
+		"WSL_SECRET_COLLECTION=login",
+		"WSL_SECRET_ITEM=/org/freedesktop/secrets/collection/login/0f8e",
+		"WSL_SECRET_LABEL=GitHub",
+		`WSL_SECRET_ATTRIBUTES={"service":"github"}`,
+	} {
+		if !slices.Contains(env, want) {
+			t.Errorf("hook environment lacks %s: %q", want, env)
+		}
+	}
+	if slices.Contains(env, "SECRET_TOKEN=hunter2") {
+		t.Error("hook environment inherits the daemon's environment")
+	}
+}
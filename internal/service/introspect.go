@@ -0,0 +1,233 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package service
+
+import (
+	"encoding/xml"
+	"strings"
+
+	"github.com/godbus/dbus/v5"
+	"github.com/godbus/dbus/v5/introspect"
+	"github.com/godbus/dbus/v5/prop"
+)
+
+// IntrospectableIface is the standard D-Bus introspection interface.
+const IntrospectableIface = "org.freedesktop.DBus.Introspectable"
+
+// secretSig is the D-Bus signature of the Secret struct.
+const secretSig = "(oayays)"
+
+// schema describes every interface exported by the service, including
+// signals and property access/annotations. It is the single source for the
+// Introspect() data of all objects; keep it in sync when adding methods,
+// signals or properties.
+var schema = map[string]introspect.Interface{
+	ServiceIface: {
+		Name: ServiceIface,
+		Methods: []introspect.Method{
+			method("OpenSession", in("algorithm", "s"), in("input", "v"), out("output", "v"), out("result", "o")),
+			method("CreateCollection", in("properties", "a{sv}"), in("alias", "s"), out("collection", "o"), out("prompt", "o")),
+			method("SearchItems", in("attributes", "a{ss}"), out("unlocked", "ao"), out("locked", "ao")),
+			method("Unlock", in("objects", "ao"), out("unlocked", "ao"), out("prompt", "o")),
+			method("Lock", in("objects", "ao"), out("locked", "ao"), out("Prompt", "o")),
+			method("GetSecrets", in("items", "ao"), in("session", "o"), out("secrets", "a{o"+secretSig+"}")),
+			method("ReadAlias", in("name", "s"), out("collection", "o")),
+			method("SetAlias", in("name", "s"), in("collection", "o")),
+		},
+		Signals: []introspect.Signal{
+			signal("CollectionCreated", arg("collection", "o")),
+			signal("CollectionDeleted", arg("collection", "o")),
+			signal("CollectionChanged", arg("collection", "o")),
+		},
+		Properties: []introspect.Property{
+			property("Collections", "ao", false, prop.EmitTrue),
+		},
+	},
+	ServiceExtIface: {
+		Name: ServiceExtIface,
+		Methods: []introspect.Method{
+			method("SearchItemsEx", in("criteria", "a(sss)"), in("options", "a{sv}"), out("unlocked", "ao"), out("locked", "ao")),
+			method("SearchItemsByLabel", in("label", "s"), in("options", "a{sv}"), out("unlocked", "ao"), out("locked", "ao")),
+		},
+		Properties: []introspect.Property{
+			property("Version", "s", false, prop.EmitFalse),
+			property("BuildInfo", "a{ss}", false, prop.EmitFalse),
+			property("Capabilities", "as", false, prop.EmitFalse),
+			property("MemoryProtection", "a{ss}", false, prop.EmitFalse),
+		},
+	},
+	AdminIface: {
+		Name: AdminIface,
+		Methods: []introspect.Method{
+			method("Stats", out("stats", "a{sv}")),
+			method("Reload"),
+			method("FlushCache"),
+			method("Fsck", in("repair", "b"), out("findings", "a(sssb)")),
+			method("SetCollectionPassword", in("collection", "o"), in("enable", "b")),
+			method("Shutdown"),
+		},
+	},
+	CollectionIface: {
+		Name: CollectionIface,
+		Methods: []introspect.Method{
+			method("Delete", out("prompt", "o")),
+			method("SearchItems", in("attributes", "a{ss}"), out("results", "ao")),
+			method("CreateItem", in("properties", "a{sv}"), in("secret", secretSig), in("replace", "b"), out("item", "o"), out("prompt", "o")),
+		},
+		Signals: []introspect.Signal{
+			signal("ItemCreated", arg("item", "o")),
+			signal("ItemDeleted", arg("item", "o")),
+			signal("ItemChanged", arg("item", "o")),
+		},
+		Properties: []introspect.Property{
+			property("Items", "ao", false, prop.EmitTrue),
+			property("Label", "s", true, prop.EmitTrue),
+			property("Locked", "b", false, prop.EmitTrue),
+			property("Created", "t", false, prop.EmitFalse),
+			property("Modified", "t", false, prop.EmitFalse),
+		},
+	},
+	ItemIface: {
+		Name: ItemIface,
+		Methods: []introspect.Method{
+			method("Delete", out("Prompt", "o")),
+			method("GetSecret", in("session", "o"), out("secret", secretSig)),
+			method("SetSecret", in("secret", secretSig)),
+		},
+		Properties: []introspect.Property{
+			property("Locked", "b", false, prop.EmitTrue),
+			property("Attributes", "a{ss}", true, prop.EmitTrue),
+			property("Label", "s", true, prop.EmitTrue),
+			property("Created", "t", false, prop.EmitFalse),
+			property("Modified", "t", false, prop.EmitFalse),
+		},
+	},
+	ItemExtIface: {
+		Name: ItemExtIface,
+		Properties: []introspect.Property{
+			property("SecretChecksum", "s", false, prop.EmitTrue),
+			property("Creator", "a{ss}", false, prop.EmitConst),
+			property("LastAccessed", "t", false, prop.EmitFalse),
+			property("AccessCount", "t", false, prop.EmitFalse),
+		},
+	},
+	SessionIface: {
+		Name:    SessionIface,
+		Methods: []introspect.Method{method("Close")},
+	},
+	SessionExtIface: {
+		Name:    SessionExtIface,
+		Methods: []introspect.Method{method("Rotate", in("input", "ay"), out("output", "ay"))},
+	},
+	PromptIface: {
+		Name: PromptIface,
+		Methods: []introspect.Method{
+			method("Prompt", in("window-id", "s")),
+			method("Dismiss"),
+		},
+		Signals: []introspect.Signal{
+			signal("Completed", arg("dismissed", "b"), arg("result", "v")),
+		},
+	},
+}
+
+// introspectable implements org.freedesktop.DBus.Introspectable for one
+// object, listing the schema of its interfaces and its current children.
+type introspectable struct {
+	ifaces   []string
+	children func() []string // nil for leaf objects
+}
+
+// Introspect implements org.freedesktop.DBus.Introspectable.Introspect().
+func (i *introspectable) Introspect() (string, *dbus.Error) {
+	node := introspect.Node{}
+	hasProps := false
+	for _, name := range i.ifaces {
+		node.Interfaces = append(node.Interfaces, schema[name])
+		hasProps = hasProps || len(schema[name].Properties) > 0
+	}
+	if hasProps {
+		node.Interfaces = append(node.Interfaces, prop.IntrospectData)
+	}
+	node.Interfaces = append(node.Interfaces, introspect.IntrospectData, introspect.PeerData)
+	if i.children != nil {
+		for _, c := range i.children() {
+			node.Children = append(node.Children, introspect.Node{Name: c})
+		}
+	}
+	b, err := xml.Marshal(node)
+	if err != nil {
+		return "", dbusError("org.freedesktop.DBus.Error.Failed", err.Error())
+	}
+	return strings.TrimSpace(introspect.IntrospectDeclarationString) + string(b), nil
+}
+
+// exportIntrospection exports introspection data at path describing ifaces.
+// children, if not nil, lists the path components of child objects.
+func (svc *Service) exportIntrospection(path dbus.ObjectPath, children func() []string, ifaces ...string) {
+	_ = svc.conn.Export(&introspectable{ifaces: ifaces, children: children}, path, IntrospectableIface)
+}
+
+// unexportIntrospection removes introspection data exported at path.
+func (svc *Service) unexportIntrospection(path dbus.ObjectPath) {
+	_ = svc.conn.Export(nil, path, IntrospectableIface)
+}
+
+// serviceChildren lists the subtrees below the Service object.
+func serviceChildren() []string {
+	return []string{"collection", "aliases", "session", "prompt"}
+}
+
+// collectionChildren returns the child lister for a collection's items.
+func (svc *Service) collectionChildren(colName string) func() []string {
+	return func() []string {
+		uuids := svc.store.ListItems(colName)
+		names := make([]string, len(uuids))
+		for i, u := range uuids {
+			names[i] = strings.ReplaceAll(u, "-", "_")
+		}
+		return names
+	}
+}
+
+func method(name string, args ...introspect.Arg) introspect.Method {
+	return introspect.Method{Name: name, Args: args}
+}
+
+func signal(name string, args ...introspect.Arg) introspect.Signal {
+	return introspect.Signal{Name: name, Args: args}
+}
+
+func in(name, typ string) introspect.Arg {
+	return introspect.Arg{Name: name, Type: typ, Direction: "in"}
+}
+
+func out(name, typ string) introspect.Arg {
+	return introspect.Arg{Name: name, Type: typ, Direction: "out"}
+}
+
+func arg(name, typ string) introspect.Arg {
+	return introspect.Arg{Name: name, Type: typ}
+}
+
+// property describes a property, annotated with how changes are signalled.
+func property(name, typ string, writable bool, emit prop.EmitType) introspect.Property {
+	access := "read"
+	if writable {
+		access = "readwrite"
+	}
+	emits := map[prop.EmitType]string{
+		prop.EmitTrue:        "true",
+		prop.EmitFalse:       "false",
+		prop.EmitInvalidates: "invalidates",
+		prop.EmitConst:       "const",
+	}[emit]
+	return introspect.Property{
+		Name:   name,
+		Type:   typ,
+		Access: access,
+		Annotations: []introspect.Annotation{
+			{Name: "org.freedesktop.DBus.Property.EmitsChangedSignal", Value: emits},
+		},
+	}
+}
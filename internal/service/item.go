@@ -4,7 +4,13 @@ package service
 
 import (
 	"fmt"
+	"os/user"
+	"strconv"
+	"time"
 
+	"github.com/akihiro/wsl-secret-service/internal/audit"
+	"github.com/akihiro/wsl-secret-service/internal/backend"
+	"github.com/akihiro/wsl-secret-service/internal/config"
 	"github.com/akihiro/wsl-secret-service/internal/store"
 	"github.com/godbus/dbus/v5"
 	"github.com/godbus/dbus/v5/prop"
@@ -21,39 +27,82 @@ type Item struct {
 
 // itemTarget returns the Windows Credential Manager TargetName for this item.
 func (i *Item) itemTarget() string {
-	return fmt.Sprintf("wsl-ss/%s/%s", i.collectionName, i.uuid)
+	return i.svc.itemTarget(i.collectionName, i.uuid)
 }
 
 // Delete implements org.freedesktop.Secret.Item.Delete().
-// Removes the item from the metadata store and backend, then unexports the D-Bus object.
+// Removes the item from the metadata store and backend (see deleteItem),
+// then unexports the D-Bus object.
 // Returns "/" (no prompt needed).
-func (i *Item) Delete() (dbus.ObjectPath, *dbus.Error) {
+func (i *Item) Delete(sender dbus.Sender) (_ dbus.ObjectPath, derr *dbus.Error) {
 	i.svc.recordActivity()
 
 	target := i.itemTarget()
 	path := ItemPath(i.collectionName, i.uuid)
+	defer func() { i.svc.audit(sender, audit.OpDelete, path, derr) }()
 
-	// Remove from backend (ignore not-found since metadata may exist without a secret).
-	_ = i.svc.backend.Delete(target)
+	if i.svc.isLocked(i.collectionName) {
+		return StubPromptPath, errLocked(i.collectionName)
+	}
+	if derr := i.svc.authorizeItem(sender, i.collectionName, i.uuid); derr != nil {
+		return StubPromptPath, derr
+	}
+	if derr := i.svc.checkWritable(i.collectionName, i.uuid); derr != nil {
+		return StubPromptPath, derr
+	}
 
-	// Remove from metadata store.
-	if err := i.svc.store.DeleteItem(i.collectionName, i.uuid); err != nil {
+	if err := i.svc.deleteItem(i.collectionName, i.uuid, target); err != nil {
 		return StubPromptPath, dbusError("org.freedesktop.Secret.Error.NoSuchObject", err.Error())
 	}
+	return StubPromptPath, nil
+}
+
+// removeItem deletes the metadata of an item, unexports its D-Bus object
+// and announces the deletion. The secret is left to the caller.
+func (svc *Service) removeItem(colName, itemUUID string) error {
+	meta, _ := svc.store.GetItem(colName, itemUUID)
+
+	// Remove from metadata store.
+	if err := svc.store.DeleteItem(colName, itemUUID); err != nil {
+		return err
+	}
+	svc.itemRemoved(colName, itemUUID, meta)
+	return nil
+}
+
+// itemRemoved unexports the D-Bus object of an item whose metadata meta
+// was deleted and announces the deletion.
+func (svc *Service) itemRemoved(colName, itemUUID string, meta store.ItemMeta) {
+	path := ItemPath(colName, itemUUID)
 
 	// Unexport D-Bus object.
-	_ = i.svc.conn.Export(nil, path, ItemIface)
-	_ = i.svc.conn.Export(nil, path, "org.freedesktop.DBus.Properties")
+	_ = svc.conn.Export(nil, path, ItemIface)
+	_ = svc.conn.Export(nil, path, "org.freedesktop.DBus.Properties")
+	svc.unexportIntrospection(path)
+	svc.objectsMu.Lock()
+	if col, ok := svc.collections[colName]; ok {
+		delete(col.items, itemUUID)
+	}
+	svc.objectsMu.Unlock()
 
 	// Notify the collection that an item was deleted and update its Items property.
-	i.svc.notifyItemDeleted(i.collectionName, path)
-
-	return StubPromptPath, nil
+	svc.notifyItemDeleted(colName, path)
+	logger.Debug("item deleted", "item", path)
+	svc.itemEvent(config.HookDeleted, colName, itemUUID, meta)
 }
 
 // GetSecret implements org.freedesktop.Secret.Item.GetSecret(session).
-func (i *Item) GetSecret(session dbus.ObjectPath) (dbus.Variant, *dbus.Error) {
+func (i *Item) GetSecret(sender dbus.Sender, session dbus.ObjectPath) (_ dbus.Variant, derr *dbus.Error) {
 	i.svc.recordActivity()
+	defer func() { i.svc.audit(sender, audit.OpGetSecret, ItemPath(i.collectionName, i.uuid), derr) }()
+
+	if i.svc.isLocked(i.collectionName) {
+		return dbus.Variant{}, errLocked(i.collectionName)
+	}
+	if derr := i.svc.authorizeItem(sender, i.collectionName, i.uuid); derr != nil {
+		return dbus.Variant{}, derr
+	}
+	i.svc.touchCollection(i.collectionName)
 
 	sess, ok := i.svc.sessions.get(session)
 	if !ok {
@@ -66,9 +115,21 @@ func (i *Item) GetSecret(session dbus.ObjectPath) (dbus.Variant, *dbus.Error) {
 		return dbus.Variant{}, dbusError("org.freedesktop.Secret.Error.NoSuchObject",
 			fmt.Sprintf("item %s/%s not found", i.collectionName, i.uuid))
 	}
+	if expired(meta, time.Now()) {
+		return dbus.Variant{}, dbusError("org.freedesktop.Secret.Error.NoSuchObject",
+			fmt.Sprintf("item %s/%s has expired", i.collectionName, i.uuid))
+	}
+	if derr := i.svc.verifyAccess(meta); derr != nil {
+		return dbus.Variant{}, derr
+	}
+	if derr := i.svc.confirmAccess(sender, ItemPath(i.collectionName, i.uuid), i.collectionName, meta); derr != nil {
+		return dbus.Variant{}, derr
+	}
 
-	secretBytes, err := i.svc.backend.Get(i.itemTarget())
+	i.svc.plaintext.acquire()
+	secretBytes, err := i.svc.getSecret(i.itemTarget())
 	if err != nil {
+		i.svc.plaintext.release()
 		return dbus.Variant{}, dbusError("org.freedesktop.Secret.Error.IsLocked",
 			fmt.Sprintf("retrieve secret: %v", err))
 	}
@@ -79,10 +140,12 @@ func (i *Item) GetSecret(session dbus.ObjectPath) (dbus.Variant, *dbus.Error) {
 	}
 
 	params, value, err := sess.encryptSecret(secretBytes)
+	i.svc.plaintext.release(secretBytes)
 	if err != nil {
 		return dbus.Variant{}, dbusError("org.freedesktop.DBus.Error.Failed",
 			fmt.Sprintf("encrypt secret: %v", err))
 	}
+	i.svc.recordAccess(i.collectionName, i.uuid)
 
 	secret := Secret{
 		Session:     session,
@@ -95,8 +158,20 @@ func (i *Item) GetSecret(session dbus.ObjectPath) (dbus.Variant, *dbus.Error) {
 
 // SetSecret implements org.freedesktop.Secret.Item.SetSecret(secret).
 // Stores the new secret value and updates the Modified timestamp.
-func (i *Item) SetSecret(secret dbus.Variant) *dbus.Error {
+func (i *Item) SetSecret(sender dbus.Sender, secret dbus.Variant) (derr *dbus.Error) {
 	i.svc.recordActivity()
+	defer func() { i.svc.audit(sender, audit.OpSetSecret, ItemPath(i.collectionName, i.uuid), derr) }()
+
+	if i.svc.isLocked(i.collectionName) {
+		return errLocked(i.collectionName)
+	}
+	if derr := i.svc.authorizeItem(sender, i.collectionName, i.uuid); derr != nil {
+		return derr
+	}
+	i.svc.touchCollection(i.collectionName)
+	if derr := i.svc.checkWritable(i.collectionName, i.uuid); derr != nil {
+		return derr
+	}
 
 	// Unmarshal the secret variant into the Secret struct.
 	var sec Secret
@@ -111,25 +186,66 @@ func (i *Item) SetSecret(secret dbus.Variant) *dbus.Error {
 			fmt.Sprintf("session %s is not open", sec.Session))
 	}
 
+	i.svc.plaintext.acquire()
 	plaintext, err := sess.decryptSecret(sec.Parameters, sec.Value)
 	if err != nil {
+		i.svc.plaintext.release()
 		return dbusError("org.freedesktop.DBus.Error.Failed",
 			fmt.Sprintf("decrypt secret: %v", err))
 	}
 
-	if err := i.svc.backend.Set(i.itemTarget(), plaintext); err != nil {
+	meta, ok := i.svc.store.GetItem(i.collectionName, i.uuid)
+	desc := backend.Metadata{Collection: i.collectionName, Label: meta.Label, Attributes: meta.Attributes}
+	err = i.svc.setSecret(i.itemTarget(), plaintext, desc)
+	checksum := i.svc.checksum(plaintext)
+	i.svc.plaintext.release(plaintext)
+	if err != nil {
 		return dbusError("org.freedesktop.DBus.Error.Failed",
 			fmt.Sprintf("store secret: %v", err))
 	}
 
-	// Update content type and modified timestamp in the store.
-	meta, ok := i.svc.store.GetItem(i.collectionName, i.uuid)
+	// Update content type, checksum and modified timestamp in the store.
 	if ok {
 		meta.ContentType = sec.ContentType
+		meta.Checksum = checksum
 		_ = i.svc.store.UpdateItem(i.collectionName, i.uuid, meta)
 	}
+	if i.props != nil {
+		i.props.SetMust(ItemExtIface, "SecretChecksum", checksum)
+	}
 
 	i.svc.notifyItemChanged(i.collectionName, ItemPath(i.collectionName, i.uuid))
+	i.svc.itemEvent(config.HookChanged, i.collectionName, i.uuid, meta)
+	return nil
+}
+
+// setItemMeta saves the label and attributes of an item changed through
+// its properties. Backends that keep them with the secret (see
+// backend.MetadataLister) get the secret stored again with the new ones,
+// so that RecoverMetadata does not bring back the old; if that fails, the
+// old metadata is restored.
+func (svc *Service) setItemMeta(colName, itemUUID string, meta store.ItemMeta) *dbus.Error {
+	old, _ := svc.store.GetItem(colName, itemUUID)
+	if err := svc.store.UpdateItem(colName, itemUUID, meta); err != nil {
+		return dbusError("org.freedesktop.DBus.Error.Failed", fmt.Sprintf("update item: %v", err))
+	}
+	if _, ok := backend.As[backend.MetadataLister](svc.backend); !ok {
+		return nil
+	}
+
+	target := svc.itemTarget(colName, itemUUID)
+	svc.plaintext.acquire()
+	secret, err := svc.getSecret(target)
+	if err == nil {
+		err = svc.setSecret(target, secret, backend.Metadata{Collection: colName, Label: meta.Label, Attributes: meta.Attributes})
+	}
+	svc.plaintext.release(secret)
+	if err != nil {
+		if rerr := svc.store.UpdateItem(colName, itemUUID, old); rerr != nil {
+			logger.Error("cannot restore the metadata of an item whose secret could not be updated", "collection", colName, "item", itemUUID, "err", rerr)
+		}
+		return dbusError("org.freedesktop.DBus.Error.Failed", fmt.Sprintf("store secret: %v", err))
+	}
 	return nil
 }
 
@@ -148,20 +264,23 @@ func (svc *Service) exportItem(item *Item) error {
 	propsSpec := prop.Map{
 		ItemIface: {
 			"Locked": {
-				Value:    false,
+				Value:    svc.isLocked(item.collectionName),
 				Writable: false,
-				Emit:     prop.EmitFalse,
+				Emit:     prop.EmitTrue,
 			},
 			"Attributes": {
 				Value:    attrsOrEmpty(meta.Attributes),
 				Writable: true,
 				Emit:     prop.EmitTrue,
 				Callback: func(c *prop.Change) *dbus.Error {
+					if derr := svc.checkWritable(item.collectionName, item.uuid); derr != nil {
+						return derr
+					}
 					if newAttrs, ok := c.Value.(map[string]string); ok {
 						m, exists := svc.store.GetItem(item.collectionName, item.uuid)
 						if exists {
 							m.Attributes = newAttrs
-							_ = svc.store.UpdateItem(item.collectionName, item.uuid, m)
+							return svc.setItemMeta(item.collectionName, item.uuid, m)
 						}
 					}
 					return nil
@@ -172,11 +291,14 @@ func (svc *Service) exportItem(item *Item) error {
 				Writable: true,
 				Emit:     prop.EmitTrue,
 				Callback: func(c *prop.Change) *dbus.Error {
+					if derr := svc.checkWritable(item.collectionName, item.uuid); derr != nil {
+						return derr
+					}
 					if label, ok := c.Value.(string); ok {
 						m, exists := svc.store.GetItem(item.collectionName, item.uuid)
 						if exists {
 							m.Label = label
-							_ = svc.store.UpdateItem(item.collectionName, item.uuid, m)
+							return svc.setItemMeta(item.collectionName, item.uuid, m)
 						}
 					}
 					return nil
@@ -193,6 +315,28 @@ func (svc *Service) exportItem(item *Item) error {
 				Emit:     prop.EmitFalse,
 			},
 		},
+		ItemExtIface: {
+			"SecretChecksum": {
+				Value:    meta.Checksum,
+				Writable: false,
+				Emit:     prop.EmitTrue,
+			},
+			"Creator": {
+				Value:    creatorInfo(meta.Creator),
+				Writable: false,
+				Emit:     prop.EmitConst,
+			},
+			"LastAccessed": {
+				Value:    meta.LastAccessed,
+				Writable: false,
+				Emit:     prop.EmitFalse,
+			},
+			"AccessCount": {
+				Value:    meta.AccessCount,
+				Writable: false,
+				Emit:     prop.EmitFalse,
+			},
+		},
 	}
 
 	props, err := prop.Export(svc.conn, path, propsSpec)
@@ -200,12 +344,18 @@ func (svc *Service) exportItem(item *Item) error {
 		return fmt.Errorf("export item properties at %s: %w", path, err)
 	}
 	item.props = props
-
-	// Explicitly export the standard D-Bus Properties interface for proper introspection.
-	// This ensures clients can discover that the object implements org.freedesktop.DBus.Properties.
-	if err := svc.conn.Export(item, path, "org.freedesktop.DBus.Properties"); err != nil {
+	// Explicitly export the standard D-Bus Properties interface for proper
+	// introspection, with a handler that knows the caller in place of the
+	// one prop.Export registered.
+	if err := svc.conn.Export(&itemProperties{props: props, item: item}, path, "org.freedesktop.DBus.Properties"); err != nil {
 		return fmt.Errorf("export item properties interface at %s: %w", path, err)
 	}
+	svc.exportIntrospection(path, nil, ItemIface, ItemExtIface)
+	svc.objectsMu.Lock()
+	if col, ok := svc.collections[item.collectionName]; ok {
+		col.items[item.uuid] = item
+	}
+	svc.objectsMu.Unlock()
 
 	return nil
 }
@@ -237,7 +387,7 @@ func dbusError(name, msg string) *dbus.Error {
 
 // updateCollectionItemsProp refreshes the Items property of a collection.
 func (svc *Service) updateCollectionItemsProp(collectionName string) {
-	col, ok := svc.collections[collectionName]
+	col, ok := svc.collection(collectionName)
 	if !ok {
 		return
 	}
@@ -274,3 +424,27 @@ func itemMetaFromProperties(properties map[string]dbus.Variant) store.ItemMeta {
 	}
 	return meta
 }
+
+// creatorInfo describes an item's creator for the Creator property: the
+// keys "exe", "app_id", "uid" and "user" (the account name of uid), each
+// present only if known.
+func creatorInfo(c *store.Creator) map[string]string {
+	info := map[string]string{}
+	if c == nil {
+		return info
+	}
+	if c.Exe != "" {
+		info["exe"] = c.Exe
+	}
+	if c.AppID != "" {
+		info["app_id"] = c.AppID
+	}
+	if c.UID != nil {
+		uid := strconv.FormatUint(uint64(*c.UID), 10)
+		info["uid"] = uid
+		if u, err := user.LookupId(uid); err == nil {
+			info["user"] = u.Username
+		}
+	}
+	return info
+}
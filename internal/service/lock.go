@@ -0,0 +1,154 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package service
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/godbus/dbus/v5"
+)
+
+// lockState tracks which collections are locked.
+//
+// Lock state lives in memory only. Collections with a master password start
+// locked and are unlocked through a prompt asking for it; all others start
+// unlocked, as their secrets at rest are protected by Windows Credential
+// Manager. Locking lets a user or a screen-locker hide a collection's
+// secrets from clients until it is explicitly unlocked again.
+type lockState struct {
+	changes  sync.Mutex // serializes setLocked, so Locked follows the last change
+	mu       sync.Mutex
+	locked   map[string]bool      // keyed by collection name
+	accessed map[string]time.Time // last access or unlock, for auto-lock
+}
+
+// autoLockInterval is how often collections are checked for auto-lock.
+const autoLockInterval = 30 * time.Second
+
+// isLocked reports whether collection colName is currently locked.
+func (svc *Service) isLocked(colName string) bool {
+	svc.locks.mu.Lock()
+	defer svc.locks.mu.Unlock()
+	return svc.locks.locked[colName]
+}
+
+// setLocked changes the lock state of collection colName. When the state
+// actually changes it updates the Locked property of the collection and of
+// each of its items, and emits Service.CollectionChanged. The auto-lock
+// goroutine calls it concurrently with D-Bus methods.
+func (svc *Service) setLocked(colName string, locked bool) {
+	svc.locks.changes.Lock()
+	defer svc.locks.changes.Unlock()
+	svc.locks.mu.Lock()
+	if svc.locks.locked[colName] == locked {
+		svc.locks.mu.Unlock()
+		return
+	}
+	if locked {
+		svc.locks.locked[colName] = true
+		// Cache entries are not tracked per collection; dropping them all
+		// only costs a few backend reads.
+		svc.secrets.Flush()
+		svc.approvals.flush()
+	} else {
+		delete(svc.locks.locked, colName)
+		svc.locks.accessed[colName] = time.Now()
+	}
+	svc.locks.mu.Unlock()
+	logger.Debug("collection lock changed", "collection", colName, "locked", locked)
+
+	col, ok := svc.collection(colName)
+	if !ok {
+		return
+	}
+	if col.props != nil {
+		col.props.SetMust(CollectionIface, "Locked", locked)
+	}
+	for _, item := range svc.exportedItems(colName) {
+		if item.props != nil {
+			item.props.SetMust(ItemIface, "Locked", locked)
+		}
+	}
+	_ = svc.conn.Emit(dbus.ObjectPath(ServicePath), ServiceIface+".CollectionChanged", CollectionPath(colName))
+}
+
+// touchCollection records an access to the items of collection colName,
+// postponing its auto-lock.
+func (svc *Service) touchCollection(colName string) {
+	svc.locks.mu.Lock()
+	defer svc.locks.mu.Unlock()
+	svc.locks.accessed[colName] = time.Now()
+}
+
+// startAutoLock launches a background goroutine that locks unlocked
+// collections whose config.AutoLock period has passed since their last
+// access or unlock. Collections not accessed since the daemon started count
+// from the start.
+func (svc *Service) startAutoLock(ctx context.Context) {
+	go func() {
+		ticker := time.NewTicker(autoLockInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case now := <-ticker.C:
+				svc.autoLock(now)
+			}
+		}
+	}()
+}
+
+// autoLock locks the collections that have been idle for their auto-lock
+// period at now.
+func (svc *Service) autoLock(now time.Time) {
+	autoLock := svc.autoLockConfig.Load()
+	if autoLock == nil || len(*autoLock) == 0 {
+		return
+	}
+	expired := make(map[string]time.Duration)
+	svc.locks.mu.Lock()
+	for _, colName := range svc.store.ListCollections() {
+		period := autoLock.Period(colName)
+		if period == 0 || svc.locks.locked[colName] {
+			continue
+		}
+		last, ok := svc.locks.accessed[colName]
+		if !ok {
+			last = svc.started
+		}
+		if now.Sub(last) >= period {
+			expired[colName] = period
+		}
+	}
+	svc.locks.mu.Unlock()
+	for colName, period := range expired {
+		logger.Info("collection auto-locked", "collection", colName, "after", period)
+		svc.setLocked(colName, true)
+	}
+}
+
+// lockTarget resolves a collection, alias or item path to the name of the
+// collection whose lock state governs it. It returns "" for unknown objects.
+func (svc *Service) lockTarget(path dbus.ObjectPath) string {
+	var colName string
+	if alias, ok := strings.CutPrefix(string(path), AliasPathPrefix); ok {
+		colName = svc.store.GetAlias(alias)
+	} else {
+		colName = CollectionNameFromPath(path)
+	}
+	if _, ok := svc.collection(colName); !ok {
+		return ""
+	}
+	return colName
+}
+
+// errLocked is returned by operations on objects in a locked collection.
+func errLocked(colName string) *dbus.Error {
+	return dbusError("org.freedesktop.Secret.Error.IsLocked",
+		fmt.Sprintf("collection %s is locked", colName))
+}
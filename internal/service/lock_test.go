@@ -0,0 +1,103 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package service
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/godbus/dbus/v5"
+
+	"github.com/akihiro/wsl-secret-service/internal/config"
+	"github.com/akihiro/wsl-secret-service/internal/store"
+	"github.com/akihiro/wsl-secret-service/pkg/backend/memory"
+)
+
+// newLockService returns a service without a bus holding the collections
+// login and work.
+func newLockService(t *testing.T) *Service {
+	t.Helper()
+	st := store.NewMemory()
+	if err := st.CreateCollection("work", "Work"); err != nil {
+		t.Fatal(err)
+	}
+	svc := &Service{store: st, backend: memory.New(), stopped: t.Context(), started: time.Now()}
+	svc.locks = lockState{locked: map[string]bool{}, accessed: map[string]time.Time{}}
+	return svc
+}
+
+func TestAutoLock(t *testing.T) {
+	svc := newLockService(t)
+	svc.autoLockConfig.Store(&config.AutoLock{"work": 1})
+
+	svc.touchCollection("work")
+	svc.autoLock(time.Now().Add(30 * time.Second))
+	if svc.isLocked("work") {
+		t.Error("work locked before its period passed")
+	}
+	svc.autoLock(time.Now().Add(2 * time.Minute))
+	if !svc.isLocked("work") {
+		t.Error("work not locked after its period passed")
+	}
+	if svc.isLocked("login") {
+		t.Error("login locked without an auto-lock period")
+	}
+	svc.setLocked("work", false)
+	if svc.isLocked("work") {
+		t.Error("work still locked after unlocking")
+	}
+}
+
+func TestCollectionPropertiesSetChecks(t *testing.T) {
+	svc := newLockService(t)
+	svc.callers.callers = map[string]callerInfo{":1.7": {Sender: ":1.7", Exe: "/usr/bin/denied"}}
+	svc.policy.Store(&config.Policy{Rules: []config.Rule{{Action: config.Deny, Exe: "/usr/bin/denied"}}})
+	p := &collectionProperties{col: &Collection{name: "work", svc: svc}}
+
+	if derr := p.Set(":1.7", CollectionIface, "Label", dbus.MakeVariant("renamed")); derr == nil || derr.Name != "org.freedesktop.Secret.Error.AccessDenied" {
+		t.Errorf("Set by a denied caller = %v, want AccessDenied", derr)
+	}
+
+	svc.policy.Store(nil)
+	svc.setLocked("work", true)
+	if derr := p.Set(":1.7", CollectionIface, "Label", dbus.MakeVariant("renamed")); derr == nil || derr.Name != "org.freedesktop.Secret.Error.IsLocked" {
+		t.Errorf("Set on a locked collection = %v, want IsLocked", derr)
+	}
+	if meta, _ := svc.store.GetCollection("work"); meta.Label != "Work" {
+		t.Errorf("label = %q, want it unchanged", meta.Label)
+	}
+}
+
+func TestAutoLockWhileItemsChange(t *testing.T) {
+	addr := privateBus(t)
+	conn := dialBus(t, addr)
+	svc, err := New(t.Context(), conn, store.NewMemory(), memory.New(), Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	svc.autoLockConfig.Store(&config.AutoLock{"login": 1})
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := range 100 {
+			id := fmt.Sprintf("item%d", i)
+			if err := svc.exportItem(&Item{collectionName: "login", uuid: id, svc: svc}); err != nil {
+				t.Error(err)
+				return
+			}
+			svc.itemRemoved("login", id, store.ItemMeta{})
+		}
+	}()
+	for range 100 {
+		svc.autoLock(time.Now().Add(time.Hour))
+		svc.setLocked("login", false)
+	}
+	<-done
+
+	col, _ := svc.collection("login")
+	if v, _ := col.props.Get(CollectionIface, "Locked"); v.Value() != false {
+		t.Errorf("Locked property = %v after the last unlock", v.Value())
+	}
+}
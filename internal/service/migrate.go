@@ -0,0 +1,127 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package service
+
+import (
+	"bytes"
+	"cmp"
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/akihiro/wsl-secret-service/internal/backend"
+	"github.com/akihiro/wsl-secret-service/internal/memprotect"
+	"github.com/akihiro/wsl-secret-service/internal/store"
+)
+
+// MigratedEntry describes a backend entry moved by MigratePrefix.
+type MigratedEntry struct {
+	From, To string
+	Items    int // items now referring to To
+}
+
+// MigratePrefix moves every backend entry whose target starts with from to
+// the same name under to, for when the target naming scheme changes. Each
+// secret is copied, read back and compared, the items referring to it are
+// pointed at the new target, and only then is the old entry deleted. Entries
+// already under to, names starting with "." (service state such as the
+// checksum key, which is looked up under a fixed name) and entries whose new
+// target exists already are left alone. With dryRun nothing is written. It
+// returns the entries moved, in target order; on error, those moved before
+// it.
+//
+// Like RecoverMetadata, it must not run while the daemon uses st's
+// directory.
+func MigratePrefix(ctx context.Context, st store.MetadataStore, be backend.Backend, from, to string, dryRun bool) ([]MigratedEntry, error) {
+	if from == "" || to == "" || from == to {
+		return nil, errors.New("the old and new prefixes must differ and not be empty")
+	}
+	targets, err := be.List(ctx, from)
+	if err != nil {
+		return nil, fmt.Errorf("list backend entries: %w", err)
+	}
+	existing, err := be.List(ctx, to)
+	if err != nil {
+		return nil, fmt.Errorf("list backend entries: %w", err)
+	}
+	exists := make(map[string]bool, len(existing))
+	for _, t := range existing {
+		exists[t] = true
+	}
+
+	refs := itemRefs(st)
+	var moved []MigratedEntry
+	for _, old := range targets {
+		name := strings.TrimPrefix(old, from)
+		if strings.HasPrefix(old, to) || strings.HasPrefix(name, ".") {
+			continue
+		}
+		e := MigratedEntry{From: old, To: to + name, Items: len(refs[old])}
+		if exists[e.To] {
+			logger.Warn("not migrating entry: the new target exists", "from", e.From, "to", e.To)
+			continue
+		}
+		if !dryRun {
+			if err := migrateEntry(ctx, st, be, e, refs[old]); err != nil {
+				return moved, fmt.Errorf("migrate %s: %w", old, err)
+			}
+		}
+		moved = append(moved, e)
+	}
+	return moved, nil
+}
+
+// itemRefs maps every backend target to the items of st referring to it.
+func itemRefs(st store.MetadataStore) map[string][]store.ItemRef {
+	refs := make(map[string][]store.ItemRef)
+	for _, col := range st.ListCollections() {
+		for _, id := range st.ListItems(col) {
+			meta, _ := st.GetItem(col, id)
+			t := cmp.Or(meta.Target, uuidTarget(col, id))
+			refs[t] = append(refs[t], store.ItemRef{Collection: col, UUID: id})
+		}
+	}
+	return refs
+}
+
+// migrateEntry copies e.From to e.To, verifies the copy, points the items
+// in refs at it and deletes e.From. If the copy cannot be verified, it is
+// deleted again and e.From kept.
+func migrateEntry(ctx context.Context, st store.MetadataStore, be backend.Backend, e MigratedEntry, refs []store.ItemRef) error {
+	secret, err := be.Get(ctx, e.From)
+	if err != nil {
+		return fmt.Errorf("read: %w", err)
+	}
+	defer memprotect.Wipe(secret)
+
+	setCtx := ctx
+	if len(refs) > 0 {
+		meta, _ := st.GetItem(refs[0].Collection, refs[0].UUID)
+		setCtx = backend.WithMetadata(ctx, backend.Metadata{Collection: refs[0].Collection, Label: meta.Label, Attributes: meta.Attributes})
+	}
+	if err := be.Set(setCtx, e.To, secret); err != nil {
+		return fmt.Errorf("write %s: %w", e.To, err)
+	}
+	copied, err := be.Get(ctx, e.To)
+	if err == nil && !bytes.Equal(copied, secret) {
+		err = errors.New("the copy differs")
+	}
+	memprotect.Wipe(copied)
+	if err != nil {
+		_ = be.Delete(ctx, e.To)
+		return fmt.Errorf("verify %s: %w", e.To, err)
+	}
+
+	for _, r := range refs {
+		meta, _ := st.GetItem(r.Collection, r.UUID)
+		meta.Target = e.To
+		if err := st.UpdateItem(r.Collection, r.UUID, meta); err != nil {
+			return fmt.Errorf("update item %s/%s: %w", r.Collection, r.UUID, err)
+		}
+	}
+	if err := be.Delete(ctx, e.From); err != nil {
+		return fmt.Errorf("delete: %w", err)
+	}
+	return nil
+}
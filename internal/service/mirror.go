@@ -0,0 +1,146 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package service
+
+import (
+	"errors"
+	"fmt"
+	"maps"
+	"strings"
+
+	"github.com/akihiro/wsl-secret-service/internal/backend"
+	"github.com/akihiro/wsl-secret-service/internal/config"
+	"github.com/akihiro/wsl-secret-service/internal/store"
+	"github.com/godbus/dbus/v5"
+)
+
+// Windows credentials mirrored by MirrorWindowsCredentials live in the
+// collection windowsCollection and carry the xdg:schema
+// windowsCredentialSchema, their TargetName in the "target" attribute and
+// their UserName, if any, in "username".
+const (
+	windowsCollection       = "windows"
+	windowsCollectionLabel  = "Windows Credentials"
+	windowsCredentialSchema = "org.akihiro.WslSecretService.WindowsCredential"
+)
+
+// MirrorWindowsCredentials exposes the credentials Windows applications
+// keep in the Credential Manager, such as those of Git Credential Manager
+// ("git:https://github.com"), as read-only items of the "windows"
+// collection, creating it if needed. Credentials under TargetPrefix and
+// those referenced by other items are left out. Items of credentials that
+// no longer exist are removed, so calling it again brings the collection
+// up to date. It needs a backend implementing backend.EntryLister for the
+// user names, and returns the number of items added and removed. Calls
+// are serialized, so that concurrent reloads do not mirror a credential
+// twice.
+func (svc *Service) MirrorWindowsCredentials() (added, removed int, err error) {
+	svc.mirrorMu.Lock()
+	defer svc.mirrorMu.Unlock()
+	l, ok := backend.As[backend.EntryLister](svc.backend)
+	if !ok {
+		return 0, 0, errors.New("the backend cannot list Windows credentials")
+	}
+	ctx, cancel := svc.backendContext()
+	defer cancel()
+	entries, err := l.ListEntries(ctx, "")
+	if err != nil {
+		return 0, 0, fmt.Errorf("list Windows credentials: %w", err)
+	}
+
+	mirrored := make(map[string]string) // target → item UUID
+	for _, id := range svc.store.ListItems(windowsCollection) {
+		if meta, ok := svc.store.GetItem(windowsCollection, id); ok && readOnlyItem(meta) {
+			mirrored[meta.Target] = id
+		}
+	}
+	for _, e := range entries {
+		if strings.HasPrefix(e.Target, TargetPrefix) {
+			continue
+		}
+		want := windowsItemMeta(e)
+		id, ok := mirrored[e.Target]
+		delete(mirrored, e.Target)
+		switch {
+		case ok:
+			svc.updateMirroredItem(id, want)
+		case svc.store.TargetInUse(e.Target):
+		default:
+			if _, ok := svc.store.GetCollection(windowsCollection); !ok {
+				if err := svc.addCollection(windowsCollection, windowsCollectionLabel, ""); err != nil {
+					return added, removed, fmt.Errorf("create collection %q: %w", windowsCollection, err)
+				}
+			}
+			if err := svc.addExternalItem(windowsCollection, want); err != nil {
+				logger.Warn("could not mirror Windows credential", "target", e.Target, "err", err)
+				continue
+			}
+			added++
+		}
+	}
+	for _, id := range mirrored {
+		if err := svc.removeItem(windowsCollection, id); err != nil {
+			logger.Warn("could not remove mirrored Windows credential", "item", id, "err", err)
+			continue
+		}
+		removed++
+	}
+	return added, removed, nil
+}
+
+// windowsItemMeta returns the metadata of the item mirroring e.
+func windowsItemMeta(e backend.Entry) store.ItemMeta {
+	attrs := map[string]string{
+		"xdg:schema": windowsCredentialSchema,
+		"target":     e.Target,
+	}
+	if e.UserName != "" {
+		attrs["username"] = e.UserName
+	}
+	return store.ItemMeta{
+		Label:       e.Target,
+		Attributes:  attrs,
+		ContentType: "text/plain; charset=utf8",
+		Target:      e.Target,
+	}
+}
+
+// updateMirroredItem brings the label and attributes of mirrored item id
+// in line with want, e.g. after the user name was changed on Windows.
+func (svc *Service) updateMirroredItem(id string, want store.ItemMeta) {
+	meta, ok := svc.store.GetItem(windowsCollection, id)
+	if !ok || meta.Label == want.Label && maps.Equal(meta.Attributes, want.Attributes) {
+		return
+	}
+	meta.Label, meta.Attributes = want.Label, want.Attributes
+	if err := svc.store.UpdateItem(windowsCollection, id, meta); err != nil {
+		logger.Warn("could not update mirrored Windows credential", "item", id, "err", err)
+		return
+	}
+	if item, ok := svc.exportedItem(windowsCollection, id); ok && item.props != nil {
+		item.props.SetMust(ItemIface, "Label", meta.Label)
+		item.props.SetMust(ItemIface, "Attributes", meta.Attributes)
+	}
+	svc.notifyItemChanged(windowsCollection, ItemPath(windowsCollection, id))
+	svc.itemEvent(config.HookChanged, windowsCollection, id, meta)
+}
+
+// readOnlyItem reports whether the item described by meta mirrors a
+// Windows credential, which clients may read but not change or delete.
+func readOnlyItem(meta store.ItemMeta) bool {
+	return meta.Attributes["xdg:schema"] == windowsCredentialSchema && meta.Target != ""
+}
+
+// checkWritable fails for items of colName that are read-only.
+func (svc *Service) checkWritable(colName, itemUUID string) *dbus.Error {
+	if meta, ok := svc.store.GetItem(colName, itemUUID); ok && readOnlyItem(meta) {
+		return errReadOnly(ItemPath(colName, itemUUID))
+	}
+	return nil
+}
+
+// errReadOnly is returned for changes to read-only objects.
+func errReadOnly(path dbus.ObjectPath) *dbus.Error {
+	return dbusError("org.freedesktop.DBus.Error.AccessDenied",
+		fmt.Sprintf("%s mirrors a Windows credential and is read-only", path))
+}
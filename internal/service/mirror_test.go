@@ -0,0 +1,45 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package service
+
+import (
+	"sync"
+	"testing"
+
+	"github.com/akihiro/wsl-secret-service/internal/store"
+	"github.com/akihiro/wsl-secret-service/pkg/backend/memory"
+)
+
+func TestMirrorWindowsCredentialsConcurrently(t *testing.T) {
+	addr := privateBus(t)
+	conn := dialBus(t, addr)
+	st, be := store.NewMemory(), memory.New()
+	if err := be.Set(t.Context(), "git:https://github.com", []byte("token")); err != nil {
+		t.Fatal(err)
+	}
+	svc, err := New(t.Context(), conn, st, be, Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var wg sync.WaitGroup
+	for range 8 {
+		wg.Go(func() {
+			if _, _, err := svc.MirrorWindowsCredentials(); err != nil {
+				t.Error(err)
+			}
+		})
+	}
+	wg.Wait()
+
+	if ids := st.ListItems(windowsCollection); len(ids) != 1 {
+		t.Fatalf("mirrored items = %v, want one", ids)
+	}
+	if items := svc.exportedItems(windowsCollection); len(items) != 1 {
+		t.Errorf("exported items = %d, want one", len(items))
+	}
+	added, removed, err := svc.MirrorWindowsCredentials()
+	if err != nil || added != 0 || removed != 0 {
+		t.Errorf("MirrorWindowsCredentials again = %d, %d, %v, want nothing to do", added, removed, err)
+	}
+}
@@ -0,0 +1,156 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package service
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/subtle"
+	"errors"
+	"fmt"
+
+	"golang.org/x/crypto/argon2"
+
+	"github.com/akihiro/wsl-secret-service/internal/backend"
+	"github.com/akihiro/wsl-secret-service/internal/store"
+	"github.com/godbus/dbus/v5"
+)
+
+// Argon2id parameters for new collection passwords: the OWASP recommended
+// minimum, which keeps the memory of each derivation modest. Existing verifiers keep the parameters they were created with.
+const (
+	passwordTime    = 2
+	passwordMemory  = 19 * 1024 // KiB
+	passwordThreads = 1
+	passwordKeyLen  = 32
+	passwordSaltLen = 16
+)
+
+// passwordAttempts is how many times an unlock asks for the password before
+// giving up.
+const passwordAttempts = 3
+
+// errWrongPassword is returned when the user entered a wrong password
+// passwordAttempts times.
+var errWrongPassword = errors.New("wrong password")
+
+// newPasswordVerifier derives a verifier for password with a fresh salt.
+func newPasswordVerifier(password []byte) (*store.PasswordVerifier, error) {
+	salt := make([]byte, passwordSaltLen)
+	if _, err := rand.Read(salt); err != nil {
+		return nil, err
+	}
+	return &store.PasswordVerifier{
+		Salt:    salt,
+		Time:    passwordTime,
+		Memory:  passwordMemory,
+		Threads: passwordThreads,
+		Hash:    argon2.IDKey(password, salt, passwordTime, passwordMemory, passwordThreads, passwordKeyLen),
+	}, nil
+}
+
+// passwordMatches reports whether password derives the key in v.
+func passwordMatches(v *store.PasswordVerifier, password []byte) bool {
+	key := argon2.IDKey(password, v.Salt, v.Time, v.Memory, v.Threads, uint32(len(v.Hash)))
+	defer clear(key)
+	return subtle.ConstantTimeCompare(key, v.Hash) == 1
+}
+
+// hasPassword reports whether collection colName is protected by a master
+// password.
+func (svc *Service) hasPassword(colName string) bool {
+	meta, ok := svc.store.GetCollection(colName)
+	return ok && meta.Password != nil
+}
+
+// checkPassword asks the user for the master password of collection
+// colName, showing message, until it matches or passwordAttempts answers
+// were wrong. Collections without a password pass without asking. ctx is
+// svc.stopped, with the prompting client's window attached (see
+// promptContext) if there is one.
+func (svc *Service) checkPassword(ctx context.Context, colName, message string) error {
+	meta, ok := svc.store.GetCollection(colName)
+	if !ok || meta.Password == nil {
+		return nil
+	}
+	p, ok := backend.As[backend.PasswordPrompter](svc.backend)
+	if !ok {
+		return errors.New("the backend cannot ask for a password")
+	}
+	for range passwordAttempts {
+		pw, err := p.PromptPassword(ctx, colName, message)
+		if err != nil {
+			return err
+		}
+		matches := passwordMatches(meta.Password, pw)
+		clear(pw)
+		if matches {
+			return nil
+		}
+		logger.Info("wrong collection password", "collection", colName)
+		message = "Wrong password. " + message
+	}
+	return errWrongPassword
+}
+
+// promptNewPassword asks twice for a new master password of collection
+// colName and returns its verifier.
+func (svc *Service) promptNewPassword(colName, label string) (*store.PasswordVerifier, error) {
+	p, ok := backend.As[backend.PasswordPrompter](svc.backend)
+	if !ok {
+		return nil, errors.New("the backend cannot ask for a password")
+	}
+	pw, err := p.PromptPassword(svc.stopped, colName, fmt.Sprintf("Enter a new password for the collection %q.", label))
+	if err != nil {
+		return nil, err
+	}
+	defer clear(pw)
+	if len(pw) == 0 {
+		return nil, errors.New("the password must not be empty")
+	}
+	again, err := p.PromptPassword(svc.stopped, colName, fmt.Sprintf("Enter the new password for the collection %q again.", label))
+	if err != nil {
+		return nil, err
+	}
+	defer clear(again)
+	if subtle.ConstantTimeCompare(pw, again) != 1 {
+		return nil, errors.New("the passwords do not match")
+	}
+	return newPasswordVerifier(pw)
+}
+
+// SetCollectionPassword implements Admin.SetCollectionPassword(collection,
+// enable). The user is asked on the Windows desktop for the current
+// password, if the collection has one, and with enable for a new password,
+// entered twice. Without enable the password is removed. Either way the
+// collection is left unlocked, as the user has just proved to know its
+// password.
+func (a *Admin) SetCollectionPassword(sender dbus.Sender, collection dbus.ObjectPath, enable bool) *dbus.Error {
+	svc := a.svc
+	svc.recordActivity()
+	if derr := svc.authorizeAdmin(sender, "SetCollectionPassword"); derr != nil {
+		return derr
+	}
+	colName := svc.lockTarget(collection)
+	meta, ok := svc.store.GetCollection(colName)
+	if colName == "" || !ok {
+		return dbusError("org.freedesktop.Secret.Error.NoSuchObject",
+			fmt.Sprintf("collection %s does not exist", collection))
+	}
+	if err := svc.checkPassword(svc.stopped, colName, fmt.Sprintf("Enter the current password of the collection %q.", meta.Label)); err != nil {
+		return dbusError("org.freedesktop.Secret.Error.AccessDenied", err.Error())
+	}
+	var v *store.PasswordVerifier
+	if enable {
+		var err error
+		if v, err = svc.promptNewPassword(colName, meta.Label); err != nil {
+			return dbusError("org.freedesktop.Secret.Error.AccessDenied", err.Error())
+		}
+	}
+	if err := svc.store.SetCollectionPassword(colName, v); err != nil {
+		return dbusError("org.freedesktop.DBus.Error.Failed", err.Error())
+	}
+	svc.setLocked(colName, false)
+	logger.Info("collection password changed", "collection", colName, "enabled", enable)
+	return nil
+}
@@ -0,0 +1,35 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package service
+
+import (
+	"github.com/akihiro/wsl-secret-service/internal/memprotect"
+)
+
+// plaintextLimiter bounds how many plaintext secrets the daemon holds in
+// memory at once. A nil limiter (the default) imposes no bound.
+type plaintextLimiter chan struct{}
+
+func newPlaintextLimiter(max int) plaintextLimiter {
+	if max <= 0 {
+		return nil
+	}
+	return make(plaintextLimiter, max)
+}
+
+// acquire blocks until a plaintext slot is free.
+func (l plaintextLimiter) acquire() {
+	if l != nil {
+		l <- struct{}{}
+	}
+}
+
+// release wipes the given plaintext buffers and frees the slot taken by acquire.
+func (l plaintextLimiter) release(plaintext ...[]byte) {
+	for _, b := range plaintext {
+		memprotect.Wipe(b)
+	}
+	if l != nil {
+		<-l
+	}
+}
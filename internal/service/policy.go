@@ -0,0 +1,98 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package service
+
+import (
+	"fmt"
+	"path"
+
+	"github.com/akihiro/wsl-secret-service/internal/config"
+	"github.com/godbus/dbus/v5"
+)
+
+// authorize evaluates the access policy for sender acting on object, an
+// item with the given attributes in collection colName, or the collection
+// itself when attrs is nil. It returns nil if access is allowed.
+func (svc *Service) authorize(sender dbus.Sender, object dbus.ObjectPath, colName string, attrs map[string]string) *dbus.Error {
+	policy := svc.policy.Load()
+	if policy == nil {
+		return nil
+	}
+	c := svc.caller(sender)
+	if policyAllows(policy, c.Exe, colName, attrs) {
+		return nil
+	}
+	exe := c.Exe
+	if exe == "" {
+		exe = "unidentified process " + c.Sender
+	}
+	logger.Info("access denied by policy", "exe", c.Exe, "sender", c.Sender, "object", object)
+	return dbusError("org.freedesktop.Secret.Error.AccessDenied",
+		fmt.Sprintf("access policy denies %s access to %s", exe, object))
+}
+
+// authorizeItem is authorize for the item colName/itemUUID, looking up its
+// attributes in the store, after checking that a sandboxed sender may use
+// it at all (isolateItem).
+func (svc *Service) authorizeItem(sender dbus.Sender, colName, itemUUID string) *dbus.Error {
+	meta, ok := svc.store.GetItem(colName, itemUUID)
+	if derr := svc.isolateItem(sender, colName, itemUUID, meta); derr != nil {
+		return derr
+	}
+	if svc.policy.Load() == nil {
+		return nil
+	}
+	attrs := map[string]string{}
+	if ok && meta.Attributes != nil {
+		attrs = meta.Attributes
+	}
+	return svc.authorize(sender, ItemPath(colName, itemUUID), colName, attrs)
+}
+
+// policyAllows reports whether p allows exe to access an item with attrs in
+// collection colName (attrs nil: the collection itself). Items under a
+// "confirm" rule are allowed; their secrets are released by confirmAccess.
+func policyAllows(p *config.Policy, exe, colName string, attrs map[string]string) bool {
+	return policyDecision(p, exe, colName, attrs) != config.Deny
+}
+
+// policyDecision returns the action of the first rule of p matching the
+// request, or p.Default (config.Allow if unset).
+func policyDecision(p *config.Policy, exe, colName string, attrs map[string]string) string {
+	for _, r := range p.Rules {
+		if ruleMatches(r, exe, colName, attrs) {
+			return r.Action
+		}
+	}
+	if p.Default == config.Deny {
+		return config.Deny
+	}
+	return config.Allow
+}
+
+// ruleMatches reports whether every match field of r is satisfied. An
+// unknown executable ("") never matches an Exe pattern.
+func ruleMatches(r config.Rule, exe, colName string, attrs map[string]string) bool {
+	if r.Exe != "" && (exe == "" || !globMatch(r.Exe, exe)) {
+		return false
+	}
+	if r.Collection != "" && !globMatch(r.Collection, colName) {
+		return false
+	}
+	if len(r.Attributes) > 0 && attrs == nil {
+		return false
+	}
+	for k, pat := range r.Attributes {
+		v, ok := attrs[k]
+		if !ok || !globMatch(pat, v) {
+			return false
+		}
+	}
+	return true
+}
+
+// globMatch matches s against a pattern validated by config.Load.
+func globMatch(pattern, s string) bool {
+	ok, _ := path.Match(pattern, s)
+	return ok
+}
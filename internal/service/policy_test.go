@@ -0,0 +1,114 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package service
+
+import (
+	"testing"
+	"time"
+
+	"github.com/akihiro/wsl-secret-service/internal/config"
+	"github.com/akihiro/wsl-secret-service/internal/store"
+	"github.com/akihiro/wsl-secret-service/pkg/backend/memory"
+	"github.com/godbus/dbus/v5"
+)
+
+func TestRuleMatches(t *testing.T) {
+	rule := config.Rule{Action: config.Allow, Exe: "/usr/bin/git*", Collection: "log*", Attributes: map[string]string{"service": "github.*"}}
+	github := map[string]string{"service": "github.com", "user": "me"}
+	for _, tc := range []struct {
+		name     string
+		rule     config.Rule
+		exe, col string
+		attrs    map[string]string
+		want     bool
+	}{
+		{"every field matches", rule, "/usr/bin/git-credential-libsecret", "login", github, true},
+		{"other executable", rule, "/usr/bin/curl", "login", github, false},
+		{"unknown executable", rule, "", "login", github, false},
+		{"other collection", rule, "/usr/bin/git", "work", github, false},
+		{"attribute differs", rule, "/usr/bin/git", "login", map[string]string{"service": "gitlab.com"}, false},
+		{"attribute missing", rule, "/usr/bin/git", "login", map[string]string{"user": "me"}, false},
+		{"collection request", rule, "/usr/bin/git", "login", nil, false},
+		{"empty rule", config.Rule{Action: config.Deny}, "", "work", nil, true},
+		{"exe only, unknown executable", config.Rule{Exe: "*"}, "", "login", github, false},
+	} {
+		if got := ruleMatches(tc.rule, tc.exe, tc.col, tc.attrs); got != tc.want {
+			t.Errorf("%s: ruleMatches = %v, want %v", tc.name, got, tc.want)
+		}
+	}
+}
+
+// newPolicyService returns a service holding the items "github" and
+// "other" in the login collection, with callers ":1.7" (git), ":1.8"
+// (curl) and ":1.9" (executable unknown), enforcing policy.
+func newPolicyService(t *testing.T, policy *config.Policy) *Service {
+	t.Helper()
+	st := store.NewMemory()
+	for id, service := range map[string]string{"github": "github.com", "other": "example.org"} {
+		if err := st.CreateItem("login", id, store.ItemMeta{Label: id, Attributes: map[string]string{"service": service}}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	svc := &Service{store: st, backend: memory.New(), stopped: t.Context()}
+	svc.locks = lockState{locked: map[string]bool{}, accessed: map[string]time.Time{}}
+	svc.callers.callers = map[string]callerInfo{
+		":1.7": {Sender: ":1.7", Exe: "/usr/bin/git"},
+		":1.8": {Sender: ":1.8", Exe: "/usr/bin/curl"},
+		":1.9": {Sender: ":1.9"},
+	}
+	svc.policy.Store(policy)
+	return svc
+}
+
+func TestAuthorize(t *testing.T) {
+	svc := newPolicyService(t, &config.Policy{
+		Default: config.Deny,
+		Rules: []config.Rule{
+			{Action: config.Deny, Exe: "/usr/bin/git", Attributes: map[string]string{"service": "example.*"}},
+			{Action: config.Allow, Exe: "/usr/bin/git"},
+		},
+	})
+	for _, tc := range []struct {
+		sender, item string
+		allowed      bool
+	}{
+		{":1.7", "github", true},
+		{":1.7", "other", false}, // the first matching rule wins
+		{":1.8", "github", false},
+		{":1.9", "github", false}, // unidentified callers get the default
+	} {
+		derr := svc.authorizeItem(dbus.Sender(tc.sender), "login", tc.item)
+		if tc.allowed && derr != nil {
+			t.Errorf("%s reading %s: %v", tc.sender, tc.item, derr)
+		}
+		if !tc.allowed && (derr == nil || derr.Name != "org.freedesktop.Secret.Error.AccessDenied") {
+			t.Errorf("%s reading %s: err = %v, want AccessDenied", tc.sender, tc.item, derr)
+		}
+	}
+	if derr := svc.authorize(":1.7", CollectionPath("login"), "login", nil); derr != nil {
+		t.Errorf("git opening the collection: %v", derr)
+	}
+	if derr := svc.authorize(":1.8", CollectionPath("login"), "login", nil); derr == nil {
+		t.Error("curl opened the collection despite the default")
+	}
+
+	// Without a policy everything is allowed.
+	svc.policy.Store(nil)
+	if derr := svc.authorizeItem(":1.8", "login", "other"); derr != nil {
+		t.Errorf("no policy: %v", derr)
+	}
+}
+
+func TestDeniedAccessDoesNotKeepCollectionUnlocked(t *testing.T) {
+	svc := newPolicyService(t, &config.Policy{Default: config.Deny})
+	item := &Item{collectionName: "login", uuid: "github", svc: svc}
+	if _, derr := item.GetSecret(":1.8", "/"); derr == nil || derr.Name != "org.freedesktop.Secret.Error.AccessDenied" {
+		t.Fatalf("GetSecret = %v, want AccessDenied", derr)
+	}
+	if derr := (&itemProperties{item: item}).Set(":1.8", ItemIface, "Label", dbus.MakeVariant("renamed")); derr == nil {
+		t.Fatal("Properties.Set succeeded against the policy")
+	}
+	if _, ok := svc.locks.accessed["login"]; ok {
+		t.Error("denied requests counted as accesses for auto-lock")
+	}
+}
@@ -3,41 +3,94 @@
 package service
 
 import (
+	"strings"
+	"sync"
+
 	"github.com/godbus/dbus/v5"
+	"github.com/google/uuid"
 )
 
-// Prompt is a stub implementation of org.freedesktop.Secret.Prompt.
-// Since all collections are always unlocked and no master password is required,
-// this service never needs real user interaction. The stub is exported at
-// PromptStubObjPath but should never be called in normal operation.
+// Prompt implements org.freedesktop.Secret.Prompt.
+//
+// Operations that need user involvement (such as unlocking a collection when
+// PromptOnUnlock is set) return the path of a one-shot Prompt created with
+// newPrompt. Calling Prompt() runs the operation and emits Completed with its
+// result; Dismiss() cancels it. Either way the object is unexported afterwards.
 //
-// When a method returns "/" as the prompt path, clients must not call Prompt().
-// This stub exists only for strict spec compliance.
+// A stub instance is also exported at PromptStubObjPath for strict spec
+// compliance. It has no operation and completes immediately; clients should
+// never need it because "/" is returned whenever no prompt is required.
 type Prompt struct {
 	path dbus.ObjectPath
 	conn *dbus.Conn
+
+	// run performs the prompted operation and returns its result, or
+	// dismissed=true if the user declined. nil for the stub prompt.
+	run  func(windowID string) (result dbus.Variant, dismissed bool)
+	once sync.Once
+}
+
+// newPrompt exports a one-shot prompt that performs run when prompted.
+// While run is in progress the daemon does not shut down when idle.
+func (svc *Service) newPrompt(run func(windowID string) (dbus.Variant, bool)) (dbus.ObjectPath, error) {
+	p := &Prompt{
+		path: dbus.ObjectPath(PromptPathPrefix + strings.ReplaceAll(uuid.New().String(), "-", "_")),
+		conn: svc.conn,
+		run: func(windowID string) (dbus.Variant, bool) {
+			svc.promptsRunning.Add(1)
+			defer svc.promptsRunning.Add(-1)
+			return run(windowID)
+		},
+	}
+	if err := svc.conn.Export(p, p.path, PromptIface); err != nil {
+		return "/", err
+	}
+	svc.exportIntrospection(p.path, nil, PromptIface)
+	return p.path, nil
 }
 
 // Prompt implements org.freedesktop.Secret.Prompt.Prompt(window-id).
-// It immediately emits a Completed signal with dismissed=false and an empty result.
+// The operation runs asynchronously; its outcome is delivered through the
+// Completed signal. The stub prompt completes immediately with an empty result.
 func (p *Prompt) Prompt(windowID string) *dbus.Error {
-	_ = p.conn.Emit(
-		p.path,
-		PromptIface+".Completed",
-		false,                // dismissed = false (operation proceeds)
-		dbus.MakeVariant(""), // result = empty variant
-	)
+	if p.run == nil {
+		p.complete(false, dbus.MakeVariant(""))
+		return nil
+	}
+	p.once.Do(func() {
+		go func() {
+			result, dismissed := p.run(windowID)
+			if dismissed {
+				result = dbus.MakeVariant("")
+			}
+			p.complete(dismissed, result)
+			p.unexport()
+		}()
+	})
 	return nil
 }
 
 // Dismiss implements org.freedesktop.Secret.Prompt.Dismiss().
 // It emits a Completed signal with dismissed=true.
 func (p *Prompt) Dismiss() *dbus.Error {
-	_ = p.conn.Emit(
-		p.path,
-		PromptIface+".Completed",
-		true,                 // dismissed = true
-		dbus.MakeVariant(""), // result = empty variant
-	)
+	if p.run == nil {
+		p.complete(true, dbus.MakeVariant(""))
+		return nil
+	}
+	p.once.Do(func() {
+		p.complete(true, dbus.MakeVariant(""))
+		p.unexport()
+	})
 	return nil
 }
+
+// complete emits the Completed signal.
+func (p *Prompt) complete(dismissed bool, result dbus.Variant) {
+	_ = p.conn.Emit(p.path, PromptIface+".Completed", dismissed, result)
+}
+
+// unexport removes a one-shot prompt from the bus once it has completed.
+func (p *Prompt) unexport() {
+	_ = p.conn.Export(nil, p.path, PromptIface)
+	_ = p.conn.Export(nil, p.path, IntrospectableIface)
+}
@@ -0,0 +1,144 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package service
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+	"sync"
+
+	"github.com/akihiro/wsl-secret-service/internal/backend"
+)
+
+// Prompter asks the user whether an operation may proceed: unlocking a
+// collection through a Prompt, or reading an item that needs confirmation
+// (ConfirmAttribute, "confirm" policy rules).
+type Prompter interface {
+	// Confirm shows message and returns nil only if the user allowed the
+	// operation. ctx may carry the client's window (backend.WindowFrom).
+	Confirm(ctx context.Context, message string) error
+}
+
+// Names of the prompters for NewPrompter (--prompter).
+const (
+	PrompterAuto     = "auto"
+	PrompterTerminal = "terminal"
+	PrompterZenity   = "zenity"
+	PrompterWindows  = "windows"
+)
+
+// errDenied is returned by prompters when the user said no.
+var errDenied = errors.New("denied by the user")
+
+// NewPrompter returns the prompter called name, which may use be.
+func NewPrompter(name string, be backend.Backend) (Prompter, error) {
+	switch name {
+	case PrompterAuto:
+		return AutoApprove{}, nil
+	case PrompterTerminal:
+		return &TerminalPrompter{Path: "/dev/tty"}, nil
+	case PrompterZenity:
+		return ZenityPrompter{}, nil
+	case PrompterWindows:
+		return NewWindowsPrompter(be), nil
+	default:
+		return nil, fmt.Errorf("unknown prompter %q (available: %s, %s, %s, %s)", name,
+			PrompterAuto, PrompterTerminal, PrompterZenity, PrompterWindows)
+	}
+}
+
+// AutoApprove allows everything without asking. It is what unlock prompts
+// do unless another prompter is configured.
+type AutoApprove struct{}
+
+// Confirm implements Prompter.
+func (AutoApprove) Confirm(context.Context, string) error {
+	return nil
+}
+
+// TerminalPrompter asks on a terminal, such as the one the daemon was
+// started from with --foreground, for an answer of "y" or "yes". Questions
+// are asked one at a time.
+type TerminalPrompter struct {
+	Path string // the terminal device, e.g. /dev/tty
+
+	mu sync.Mutex
+}
+
+// Confirm implements Prompter.
+func (p *TerminalPrompter) Confirm(ctx context.Context, message string) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	tty, err := os.OpenFile(p.Path, os.O_RDWR, 0)
+	if err != nil {
+		return fmt.Errorf("no terminal to ask on: %w", err)
+	}
+	defer tty.Close()
+	// Closing the terminal ends the read below if ctx is done first.
+	stop := context.AfterFunc(ctx, func() { _ = tty.Close() })
+	defer stop()
+
+	if _, err := fmt.Fprintf(tty, "\nwsl-secret-service: %s\nAllow? [y/N] ", message); err != nil {
+		return err
+	}
+	answer, err := bufio.NewReader(tty).ReadString('\n')
+	if ctx.Err() != nil {
+		return context.Cause(ctx)
+	}
+	if err != nil {
+		return fmt.Errorf("read answer: %w", err)
+	}
+	switch strings.ToLower(strings.TrimSpace(answer)) {
+	case "y", "yes":
+		return nil
+	}
+	return errDenied
+}
+
+// ZenityPrompter asks with a zenity(1) question dialog, which WSLg shows
+// on the Windows desktop.
+type ZenityPrompter struct{}
+
+// Confirm implements Prompter.
+func (ZenityPrompter) Confirm(ctx context.Context, message string) error {
+	cmd := exec.CommandContext(ctx, "zenity", "--question", "--title=wsl-secret-service",
+		"--text="+message, "--ok-label=Allow", "--cancel-label=Deny", "--default-cancel")
+	err := cmd.Run()
+	var exitErr *exec.ExitError
+	switch {
+	case err == nil:
+		return nil
+	case ctx.Err() != nil:
+		return context.Cause(ctx)
+	case errors.As(err, &exitErr) && exitErr.ExitCode() == 1:
+		return errDenied
+	default:
+		return fmt.Errorf("run zenity: %w", err)
+	}
+}
+
+// WindowsPrompter asks in a dialog on the Windows desktop through the
+// backend's backend.Approver (the helper's "confirm" action). It is what
+// access confirmations use unless another prompter is configured.
+type WindowsPrompter struct {
+	approver backend.Approver // nil if the backend cannot ask
+}
+
+// NewWindowsPrompter returns a WindowsPrompter asking through be.
+func NewWindowsPrompter(be backend.Backend) WindowsPrompter {
+	a, _ := backend.As[backend.Approver](be)
+	return WindowsPrompter{approver: a}
+}
+
+// Confirm implements Prompter.
+func (p WindowsPrompter) Confirm(ctx context.Context, message string) error {
+	if p.approver == nil {
+		return errors.New("the backend cannot ask the user")
+	}
+	return p.approver.Approve(ctx, message)
+}
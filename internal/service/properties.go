@@ -0,0 +1,120 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package service
+
+import (
+	"github.com/godbus/dbus/v5"
+	"github.com/godbus/dbus/v5/prop"
+)
+
+// itemProperties serves org.freedesktop.DBus.Properties for an item in
+// place of the prop.Properties holding its values, which cannot tell who
+// is calling: a caller may neither read nor change the properties of an
+// item it may not use (authorizeItem), and changes also need the
+// collection unlocked, like SetSecret.
+type itemProperties struct {
+	props *prop.Properties
+	item  *Item
+}
+
+// Get implements org.freedesktop.DBus.Properties.Get.
+func (p *itemProperties) Get(sender dbus.Sender, iface, name string) (dbus.Variant, *dbus.Error) {
+	if derr := p.item.svc.authorizeItem(sender, p.item.collectionName, p.item.uuid); derr != nil {
+		return dbus.Variant{}, derr
+	}
+	return p.props.Get(iface, name)
+}
+
+// GetAll implements org.freedesktop.DBus.Properties.GetAll.
+func (p *itemProperties) GetAll(sender dbus.Sender, iface string) (map[string]dbus.Variant, *dbus.Error) {
+	if derr := p.item.svc.authorizeItem(sender, p.item.collectionName, p.item.uuid); derr != nil {
+		return nil, derr
+	}
+	return p.props.GetAll(iface)
+}
+
+// Set implements org.freedesktop.DBus.Properties.Set.
+func (p *itemProperties) Set(sender dbus.Sender, iface, name string, value dbus.Variant) *dbus.Error {
+	svc, colName := p.item.svc, p.item.collectionName
+	svc.recordActivity()
+	if svc.isLocked(colName) {
+		return errLocked(colName)
+	}
+	if derr := svc.authorizeItem(sender, colName, p.item.uuid); derr != nil {
+		return derr
+	}
+	svc.touchCollection(colName)
+	if iface == ItemIface && name == "Attributes" {
+		if attrs, ok := value.Value().(map[string]string); ok {
+			if derr := svc.checkFlagChange(sender, p.item, attrs); derr != nil {
+				return derr
+			}
+		}
+	}
+	return p.props.Set(iface, name, value)
+}
+
+// checkFlagChange fails unless sender may set attrs on item as far as the
+// attributes that make reads of it ask the user are concerned.
+func (svc *Service) checkFlagChange(sender dbus.Sender, item *Item, attrs map[string]string) *dbus.Error {
+	old, ok := svc.store.GetItem(item.collectionName, item.uuid)
+	if !ok {
+		return nil
+	}
+	if derr := svc.verifyFlagChange(old, attrs); derr != nil {
+		return derr
+	}
+	return svc.confirmFlagChange(sender, ItemPath(item.collectionName, item.uuid), item.collectionName, old, attrs)
+}
+
+// collectionProperties serves org.freedesktop.DBus.Properties for a
+// collection, at its path and its alias paths. The Items property lists
+// only the items the caller may use; changes need the access policy to
+// allow the caller the collection, and the collection unlocked.
+type collectionProperties struct {
+	props *prop.Properties
+	col   *Collection
+}
+
+// Get implements org.freedesktop.DBus.Properties.Get.
+func (p *collectionProperties) Get(sender dbus.Sender, iface, name string) (dbus.Variant, *dbus.Error) {
+	if iface == CollectionIface && name == "Items" {
+		return dbus.MakeVariant(p.col.svc.visibleItems(sender, p.col.name)), nil
+	}
+	return p.props.Get(iface, name)
+}
+
+// GetAll implements org.freedesktop.DBus.Properties.GetAll.
+func (p *collectionProperties) GetAll(sender dbus.Sender, iface string) (map[string]dbus.Variant, *dbus.Error) {
+	all, derr := p.props.GetAll(iface)
+	if derr == nil && iface == CollectionIface {
+		all["Items"] = dbus.MakeVariant(p.col.svc.visibleItems(sender, p.col.name))
+	}
+	return all, derr
+}
+
+// Set implements org.freedesktop.DBus.Properties.Set.
+func (p *collectionProperties) Set(sender dbus.Sender, iface, name string, value dbus.Variant) *dbus.Error {
+	svc, colName := p.col.svc, p.col.name
+	svc.recordActivity()
+	if derr := svc.authorize(sender, CollectionPath(colName), colName, nil); derr != nil {
+		return derr
+	}
+	if svc.isLocked(colName) {
+		return errLocked(colName)
+	}
+	svc.touchCollection(colName)
+	return p.props.Set(iface, name, value)
+}
+
+// visibleItems returns the paths of the items of colName that sender may
+// use (authorizeItem).
+func (svc *Service) visibleItems(sender dbus.Sender, colName string) []dbus.ObjectPath {
+	paths := []dbus.ObjectPath{}
+	for _, u := range svc.store.ListItems(colName) {
+		if svc.authorizeItem(sender, colName, u) == nil {
+			paths = append(paths, ItemPath(colName, u))
+		}
+	}
+	return paths
+}
@@ -0,0 +1,115 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package service
+
+import (
+	"cmp"
+	"context"
+	"fmt"
+	"maps"
+	"slices"
+	"strings"
+
+	"github.com/akihiro/wsl-secret-service/internal/backend"
+	"github.com/akihiro/wsl-secret-service/internal/store"
+	"github.com/google/uuid"
+)
+
+// recoveredCollection receives recovered entries whose collection is not
+// known, such as hashed targets stored without metadata.
+const recoveredCollection = "recovered"
+
+// RecoveredItem describes an item created by RecoverMetadata.
+type RecoveredItem struct {
+	Collection string
+	UUID       string
+	Target     string
+	Label      string
+}
+
+// RecoverMetadata rebuilds item metadata from the backend, for when the
+// metadata store was lost: every entry under TargetPrefix that no item
+// refers to becomes an item. Its collection, label and attributes come from
+// the backend.Metadata stored with it (see backend.MetadataLister) or,
+// failing that, from the target name; missing collections are created. The
+// secrets stay where they are. With dryRun nothing is written. It returns
+// the items created, in target order.
+//
+// The daemon must not be running on st's directory, as it would refuse
+// further changes once RecoverMetadata has written to the store.
+func RecoverMetadata(ctx context.Context, st store.MetadataStore, be backend.Backend, dryRun bool) ([]RecoveredItem, error) {
+	var metas map[string]backend.Metadata
+	if l, ok := backend.As[backend.MetadataLister](be); ok {
+		var err error
+		if metas, err = l.ListMetadata(ctx, TargetPrefix); err != nil {
+			return nil, fmt.Errorf("list backend entries: %w", err)
+		}
+	} else {
+		targets, err := be.List(ctx, TargetPrefix)
+		if err != nil {
+			return nil, fmt.Errorf("list backend entries: %w", err)
+		}
+		metas = make(map[string]backend.Metadata, len(targets))
+		for _, t := range targets {
+			metas[t] = backend.Metadata{}
+		}
+	}
+
+	inUse := targetsInUse(st)
+	created := make(map[string]bool) // collections created in a dry run
+	var items []RecoveredItem
+	for _, target := range slices.Sorted(maps.Keys(metas)) {
+		name, ours := strings.CutPrefix(target, TargetPrefix)
+		// Names starting with "." hold service state, not items.
+		if !ours || strings.HasPrefix(name, ".") || inUse[target] {
+			continue
+		}
+		it, meta := recoveredItem(target, name, metas[target])
+		if _, ok := st.GetCollection(it.Collection); !ok && !created[it.Collection] {
+			if !dryRun {
+				if err := st.CreateCollection(it.Collection, it.Collection); err != nil {
+					return items, fmt.Errorf("create collection %q: %w", it.Collection, err)
+				}
+			}
+			created[it.Collection] = true
+		}
+		if !dryRun {
+			if err := st.CreateItem(it.Collection, it.UUID, meta); err != nil {
+				return items, fmt.Errorf("create item for %s: %w", target, err)
+			}
+		}
+		items = append(items, it)
+	}
+	return items, nil
+}
+
+// recoveredItem builds the item for target, whose name after TargetPrefix
+// is name, from m.
+func recoveredItem(target, name string, m backend.Metadata) (RecoveredItem, store.ItemMeta) {
+	dir, base, _ := strings.Cut(name, "/")
+	it := RecoveredItem{Collection: m.Collection, Target: target, Label: m.Label}
+	if it.Collection == "" {
+		// Hashed targets say nothing about their collection; label-based
+		// ones name it by label.
+		it.Collection = recoveredCollection
+		if dir != "sha256" && base != "" {
+			it.Collection = collectionSlug(dir)
+		}
+	}
+	if it.Label == "" {
+		it.Label = cmp.Or(base, dir)
+	}
+
+	meta := store.ItemMeta{
+		Label:       it.Label,
+		Attributes:  m.Attributes,
+		ContentType: "text/plain; charset=utf8",
+	}
+	if err := uuid.Validate(base); err == nil && uuidTarget(it.Collection, base) == target {
+		it.UUID = base
+	} else {
+		it.UUID = uuid.New().String()
+		meta.Target = target
+	}
+	return it, meta
+}
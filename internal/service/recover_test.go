@@ -0,0 +1,112 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package service
+
+import (
+	"maps"
+	"slices"
+	"testing"
+
+	"github.com/akihiro/wsl-secret-service/internal/backend"
+	"github.com/akihiro/wsl-secret-service/internal/store"
+	"github.com/akihiro/wsl-secret-service/pkg/backend/memory"
+)
+
+func TestRecoverMetadata(t *testing.T) {
+	const id = "0b6f1c0e-8a43-4b8e-9f61-2f1a3c5d7e90"
+	be := memory.New()
+	for _, target := range []string{
+		"wsl-ss/login/" + id,     // uuid scheme, no metadata
+		"wsl-ss/Personal/GitHub", // label scheme, no metadata
+		"wsl-ss/.checksum-key",   // service state
+		"other/entry",            // not ours
+	} {
+		if err := be.Set(t.Context(), target, []byte("s")); err != nil {
+			t.Fatal(err)
+		}
+	}
+	described := backend.WithMetadata(t.Context(), backend.Metadata{Collection: "work", Label: "VPN", Attributes: map[string]string{"service": "vpn"}})
+	if err := be.Set(described, "wsl-ss/sha256/abc", []byte("s")); err != nil { // hashed, with metadata
+		t.Fatal(err)
+	}
+	st, err := store.New(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	items, err := RecoverMetadata(t.Context(), st, be, false)
+	if err != nil {
+		t.Fatalf("RecoverMetadata: %v", err)
+	}
+	if len(items) != 3 {
+		t.Fatalf("recovered %+v, want 3 items", items)
+	}
+	byTarget := make(map[string]RecoveredItem)
+	for _, it := range items {
+		byTarget[it.Target] = it
+	}
+	if it := byTarget["wsl-ss/login/"+id]; it.Collection != "login" || it.UUID != id {
+		t.Errorf("uuid target recovered as %+v", it)
+	}
+	if it := byTarget["wsl-ss/Personal/GitHub"]; it.Collection != "personal" || it.Label != "GitHub" {
+		t.Errorf("label target recovered as %+v", it)
+	}
+	it := byTarget["wsl-ss/sha256/abc"]
+	meta, _ := st.GetItem(it.Collection, it.UUID)
+	if it.Collection != "work" || meta.Label != "VPN" || !maps.Equal(meta.Attributes, map[string]string{"service": "vpn"}) {
+		t.Errorf("described target recovered as %+v, %+v", it, meta)
+	}
+
+	// A second run finds every entry referenced.
+	if items, err := RecoverMetadata(t.Context(), st, be, false); err != nil || len(items) != 0 {
+		t.Errorf("second run recovered %+v, %v", items, err)
+	}
+}
+
+func TestMigratePrefix(t *testing.T) {
+	const id = "0b6f1c0e-8a43-4b8e-9f61-2f1a3c5d7e90"
+	be := memory.New()
+	for _, target := range []string{
+		"wsl-ss/login/" + id,           // referred to by an item
+		"wsl-ss/Personal/GitHub",       // not referred to
+		"wsl-ss/.checksum-key",         // service state
+		"wsl-ss/ubuntu/login/existing", // already moved
+		"other/entry",                  // not ours
+	} {
+		if err := be.Set(t.Context(), target, []byte(target)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	st, err := store.New(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := st.CreateItem("login", id, store.ItemMeta{Label: "item"}); err != nil {
+		t.Fatal(err)
+	}
+
+	moved, err := MigratePrefix(t.Context(), st, be, "wsl-ss/", "wsl-ss/ubuntu/", false)
+	if err != nil {
+		t.Fatalf("MigratePrefix: %v", err)
+	}
+	want := []MigratedEntry{
+		{From: "wsl-ss/Personal/GitHub", To: "wsl-ss/ubuntu/Personal/GitHub"},
+		{From: "wsl-ss/login/" + id, To: "wsl-ss/ubuntu/login/" + id, Items: 1},
+	}
+	if !slices.Equal(moved, want) {
+		t.Errorf("moved %+v, want %+v", moved, want)
+	}
+	if meta, _ := st.GetItem("login", id); meta.Target != "wsl-ss/ubuntu/login/"+id {
+		t.Errorf("item Target = %q, want the new target", meta.Target)
+	}
+	got, err := be.Get(t.Context(), "wsl-ss/ubuntu/login/"+id)
+	if err != nil || string(got) != "wsl-ss/login/"+id {
+		t.Errorf("Get of the new target = %q, %v", got, err)
+	}
+	left, _ := be.List(t.Context(), "")
+	slices.Sort(left)
+	wantLeft := []string{"other/entry", "wsl-ss/.checksum-key", "wsl-ss/ubuntu/Personal/GitHub", "wsl-ss/ubuntu/login/" + id, "wsl-ss/ubuntu/login/existing"}
+	if !slices.Equal(left, wantLeft) {
+		t.Errorf("entries after migration = %v, want %v", left, wantLeft)
+	}
+}
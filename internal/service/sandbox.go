@@ -0,0 +1,95 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package service
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"github.com/akihiro/wsl-secret-service/internal/store"
+	"github.com/godbus/dbus/v5"
+)
+
+// Prefixes of sandboxed application ids (callerInfo.AppID).
+const (
+	appIDFlatpak = "flatpak:"
+	appIDSnap    = "snap:"
+)
+
+var (
+	// flatpakScope matches the systemd scope Flatpak runs an application
+	// in, e.g. "app-flatpak-org.gnome.Evolution-12345.scope".
+	flatpakScope = regexp.MustCompile(`/app-flatpak-([A-Za-z0-9_.-]+)-[0-9]+\.scope$`)
+	// snapScope matches the scope of a snap application, e.g.
+	// "snap.firefox.firefox-1a2b3c.scope", capturing the snap name.
+	snapScope = regexp.MustCompile(`/snap\.([a-z0-9-]+)\.[A-Za-z0-9_.-]+\.scope$`)
+)
+
+// sandboxAppID returns the application id of the sandboxed process whose
+// /proc directory is procDir: "flatpak:<app id>" from the sandbox's
+// /.flatpak-info or its systemd scope, "snap:<snap name>" from its scope,
+// or "" for processes outside a sandbox.
+func sandboxAppID(procDir string) string {
+	if info, err := os.ReadFile(filepath.Join(procDir, "root", ".flatpak-info")); err == nil {
+		if id := flatpakInfoName(info); id != "" {
+			return appIDFlatpak + id
+		}
+	}
+	cgroup, err := os.ReadFile(filepath.Join(procDir, "cgroup"))
+	if err != nil {
+		return ""
+	}
+	for line := range bytes.Lines(cgroup) {
+		// hierarchy-ID:controllers:path
+		parts := strings.SplitN(strings.TrimSpace(string(line)), ":", 3)
+		if len(parts) != 3 {
+			continue
+		}
+		if m := flatpakScope.FindStringSubmatch(parts[2]); m != nil {
+			return appIDFlatpak + m[1]
+		}
+		if m := snapScope.FindStringSubmatch(parts[2]); m != nil {
+			return appIDSnap + m[1]
+		}
+	}
+	return ""
+}
+
+// flatpakInfoName returns the "name" key of the [Application] group of a
+// .flatpak-info file.
+func flatpakInfoName(info []byte) string {
+	sc := bufio.NewScanner(bytes.NewReader(info))
+	group := ""
+	for sc.Scan() {
+		line := strings.TrimSpace(sc.Text())
+		if strings.HasPrefix(line, "[") && strings.HasSuffix(line, "]") {
+			group = line[1 : len(line)-1]
+			continue
+		}
+		if k, v, ok := strings.Cut(line, "="); ok && group == "Application" && strings.TrimSpace(k) == "name" {
+			return strings.TrimSpace(v)
+		}
+	}
+	return ""
+}
+
+// isolateItem enforces Options.IsolateApps: a sandboxed caller may only
+// use items created by the same application. Unsandboxed callers are not
+// restricted.
+func (svc *Service) isolateItem(sender dbus.Sender, colName, itemUUID string, meta store.ItemMeta) *dbus.Error {
+	if !svc.isolateApps {
+		return nil
+	}
+	c := svc.caller(sender)
+	if c.AppID == "" || meta.Creator != nil && meta.Creator.AppID == c.AppID {
+		return nil
+	}
+	logger.Info("access denied to another application's item", "app_id", c.AppID, "sender", c.Sender, "item", ItemPath(colName, itemUUID))
+	return dbusError("org.freedesktop.Secret.Error.AccessDenied",
+		fmt.Sprintf("%s may only use its own items", c.AppID))
+}
@@ -0,0 +1,122 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package service
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/godbus/dbus/v5"
+
+	"github.com/akihiro/wsl-secret-service/internal/store"
+	"github.com/akihiro/wsl-secret-service/pkg/backend/memory"
+)
+
+func TestSandboxAppID(t *testing.T) {
+	for _, tc := range []struct {
+		name        string
+		flatpakInfo string
+		cgroup      string
+		want        string
+	}{
+		{
+			name:        "flatpak info",
+			flatpakInfo: "[Application]\nname=org.gnome.Evolution\nruntime=runtime/org.gnome.Platform/x86_64/46\n\n[Instance]\ninstance-id=1\n",
+			cgroup:      "0::/user.slice/user-1000.slice/user@1000.service/app.slice/app-flatpak-org.gnome.Evolution-4242.scope\n",
+			want:        "flatpak:org.gnome.Evolution",
+		},
+		{
+			name:   "flatpak scope",
+			cgroup: "0::/user.slice/user-1000.slice/user@1000.service/app.slice/app-flatpak-com.example.App-17.scope\n",
+			want:   "flatpak:com.example.App",
+		},
+		{
+			name:   "snap",
+			cgroup: "0::/user.slice/user-1000.slice/user@1000.service/app.slice/snap.firefox.firefox-3f1c.scope\n",
+			want:   "snap:firefox",
+		},
+		{
+			name:   "unsandboxed",
+			cgroup: "0::/user.slice/user-1000.slice/user@1000.service/app.slice/wsl-secret-service.service\n",
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			dir := t.TempDir()
+			if err := os.MkdirAll(filepath.Join(dir, "root"), 0o755); err != nil {
+				t.Fatal(err)
+			}
+			if tc.flatpakInfo != "" {
+				if err := os.WriteFile(filepath.Join(dir, "root", ".flatpak-info"), []byte(tc.flatpakInfo), 0o644); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if err := os.WriteFile(filepath.Join(dir, "cgroup"), []byte(tc.cgroup), 0o644); err != nil {
+				t.Fatal(err)
+			}
+			if got := sandboxAppID(dir); got != tc.want {
+				t.Errorf("sandboxAppID = %q, want %q", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestIsolatedProperties(t *testing.T) {
+	addr := privateBus(t)
+	conn := dialBus(t, addr)
+	sandboxed := dialBus(t, addr)
+	unsandboxed := dialBus(t, addr)
+
+	const id = "3e8b2f4a-7c1d-4a9e-b5f0-6d2c8a1e9b47"
+	st := store.NewMemory()
+	owner := &store.Creator{AppID: appIDFlatpak + "org.example.Owner"}
+	if err := st.CreateItem("login", id, store.ItemMeta{Label: "owned", Creator: owner}); err != nil {
+		t.Fatal(err)
+	}
+	be := memory.New()
+	if err := be.Set(t.Context(), uuidTarget("login", id), []byte("secret")); err != nil {
+		t.Fatal(err)
+	}
+	svc, err := New(t.Context(), conn, st, be, Options{IsolateApps: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	other := sandboxed.Names()[0]
+	svc.callers.callers[other] = callerInfo{Sender: other, AppID: appIDFlatpak + "org.example.Other"}
+
+	itemPath, colPath := ItemPath("login", id), CollectionPath("login")
+	labelOf := func(c *dbus.Conn) (string, error) {
+		v, err := c.Object(conn.Names()[0], itemPath).GetProperty(ItemIface + ".Label")
+		if err != nil {
+			return "", err
+		}
+		return v.Value().(string), nil
+	}
+	itemsOf := func(c *dbus.Conn) ([]dbus.ObjectPath, error) {
+		var all map[string]dbus.Variant
+		if err := c.Object(conn.Names()[0], colPath).Call("org.freedesktop.DBus.Properties.GetAll", 0, CollectionIface).Store(&all); err != nil {
+			return nil, err
+		}
+		return all["Items"].Value().([]dbus.ObjectPath), nil
+	}
+
+	if _, err := labelOf(sandboxed); err == nil {
+		t.Error("another application read the label")
+	}
+	if err := sandboxed.Object(conn.Names()[0], itemPath).SetProperty(ItemIface+".Label", dbus.MakeVariant("renamed")); err == nil {
+		t.Error("another application renamed the item")
+	}
+	if items, err := itemsOf(sandboxed); err != nil || len(items) != 0 {
+		t.Errorf("Items for another application = %v, %v, want none", items, err)
+	}
+
+	if label, err := labelOf(unsandboxed); err != nil || label != "owned" {
+		t.Errorf("label for an unsandboxed client = %q, %v, want the unchanged one", label, err)
+	}
+	if err := unsandboxed.Object(conn.Names()[0], itemPath).SetProperty(ItemIface+".Label", dbus.MakeVariant("renamed")); err != nil {
+		t.Errorf("unsandboxed client could not rename the item: %v", err)
+	}
+	if items, err := itemsOf(unsandboxed); err != nil || len(items) != 1 || items[0] != itemPath {
+		t.Errorf("Items for an unsandboxed client = %v, %v, want the item", items, err)
+	}
+}
@@ -0,0 +1,144 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package service
+
+import (
+	"fmt"
+
+	"github.com/akihiro/wsl-secret-service/internal/audit"
+	"github.com/akihiro/wsl-secret-service/internal/store"
+	"github.com/godbus/dbus/v5"
+)
+
+// AttributeMatch is the D-Bus type (sss) of one SearchItemsEx criterion:
+// the item's attribute Attribute must match Pattern in Mode, one of
+// "exact", "prefix", "glob" or "regex" (see store.AttrMatch).
+type AttributeMatch struct {
+	Attribute string
+	Mode      string
+	Pattern   string
+}
+
+// serviceExt implements the methods of org.akihiro.WslSecretService.Service
+// on the root object. It is a separate type so that only its methods are
+// exported under ServiceExtIface.
+type serviceExt struct {
+	svc *Service
+}
+
+// SearchOptionIgnoreCase is the option (b) of SearchItemsEx and
+// SearchItemsByLabel that compares values case-insensitively. It defaults
+// to Options.CaseInsensitiveSearch for SearchItemsEx and to true for
+// SearchItemsByLabel.
+const SearchOptionIgnoreCase = "ignore-case"
+
+// SearchItemsEx implements Service.SearchItemsEx(criteria, options):
+// SearchItems with a match mode per attribute, e.g. ("url", "glob",
+// "*.corp.example.com"). Items must match every criterion. Returns
+// (unlocked, locked) like SearchItems.
+func (e *serviceExt) SearchItemsEx(sender dbus.Sender, criteria []AttributeMatch, options map[string]dbus.Variant) (_, _ []dbus.ObjectPath, derr *dbus.Error) {
+	svc := e.svc
+	svc.recordActivity()
+	logged := make(map[string]string, len(criteria))
+	for _, c := range criteria {
+		logged[c.Attribute] = c.Mode + ":" + c.Pattern
+	}
+	defer func() {
+		svc.auditEntry(sender, audit.Entry{Op: audit.OpSearch, Object: ServicePath, Attributes: logged}, derr)
+	}()
+
+	ignoreCase, derr := searchIgnoreCase(options, svc.caseInsensitiveSearch)
+	if derr != nil {
+		return nil, nil, derr
+	}
+	matches := make([]store.AttrMatch, len(criteria))
+	for i, c := range criteria {
+		matches[i] = store.AttrMatch{Attribute: c.Attribute, Mode: c.Mode, Pattern: c.Pattern, IgnoreCase: ignoreCase}
+	}
+
+	refs, err := svc.store.SearchItemsMatching("", matches)
+	if err != nil {
+		return nil, nil, dbusError("org.freedesktop.DBus.Error.InvalidArgs", err.Error())
+	}
+	unlocked, locked := svc.partitionItems(sender, refs)
+	return unlocked, locked, nil
+}
+
+// SearchItemsByLabel implements Service.SearchItemsByLabel(label, options):
+// the items, in any collection, whose label contains label. The
+// "ignore-case" option defaults to true, as users rarely remember the case
+// of a label. Returns (unlocked, locked) like SearchItems.
+func (e *serviceExt) SearchItemsByLabel(sender dbus.Sender, label string, options map[string]dbus.Variant) (_, _ []dbus.ObjectPath, derr *dbus.Error) {
+	svc := e.svc
+	svc.recordActivity()
+	defer func() {
+		svc.auditEntry(sender, audit.Entry{Op: audit.OpSearch, Object: ServicePath, Attributes: map[string]string{"label": label}}, derr)
+	}()
+
+	ignoreCase, derr := searchIgnoreCase(options, true)
+	if derr != nil {
+		return nil, nil, derr
+	}
+	unlocked, locked := svc.partitionItems(sender, svc.store.SearchItemsByLabel(label, ignoreCase))
+	return unlocked, locked, nil
+}
+
+// searchIgnoreCase returns the "ignore-case" option of a search, or def if
+// it is not given. Other options are refused.
+func searchIgnoreCase(options map[string]dbus.Variant, def bool) (bool, *dbus.Error) {
+	ignoreCase := def
+	for name, v := range options {
+		switch name {
+		case SearchOptionIgnoreCase:
+			b, ok := v.Value().(bool)
+			if !ok {
+				return false, dbusError("org.freedesktop.DBus.Error.InvalidArgs", fmt.Sprintf("option %q must be a boolean", name))
+			}
+			ignoreCase = b
+		default:
+			return false, dbusError("org.freedesktop.DBus.Error.InvalidArgs", fmt.Sprintf("unknown option %q", name))
+		}
+	}
+	return ignoreCase, nil
+}
+
+// searchAttributes returns the items, in collection or in every collection
+// if it is "", whose attributes include attrs: compared exactly, as the
+// Secret Service API specifies, or with Options.CaseInsensitiveSearch
+// case-insensitively.
+func (svc *Service) searchAttributes(collection string, attrs map[string]string) []store.ItemRef {
+	if !svc.caseInsensitiveSearch {
+		if collection == "" {
+			return svc.store.SearchItems(attrs)
+		}
+		return svc.store.SearchItemsInCollection(collection, attrs)
+	}
+	matches := make([]store.AttrMatch, 0, len(attrs))
+	for k, v := range attrs {
+		matches = append(matches, store.AttrMatch{Attribute: k, Mode: store.MatchExact, Pattern: v, IgnoreCase: true})
+	}
+	refs, err := svc.store.SearchItemsMatching(collection, matches)
+	if err != nil { // exact matches are always valid
+		logger.Error("attribute search failed", "err", err)
+	}
+	return refs
+}
+
+// partitionItems returns the paths of the items of refs sender may use,
+// split by the lock state of their collections.
+func (svc *Service) partitionItems(sender dbus.Sender, refs []store.ItemRef) (unlocked, locked []dbus.ObjectPath) {
+	unlocked = []dbus.ObjectPath{}
+	locked = []dbus.ObjectPath{}
+	for _, ref := range refs {
+		if svc.authorizeItem(sender, ref.Collection, ref.UUID) != nil {
+			continue
+		}
+		path := ItemPath(ref.Collection, ref.UUID)
+		if svc.isLocked(ref.Collection) {
+			locked = append(locked, path)
+		} else {
+			unlocked = append(unlocked, path)
+		}
+	}
+	return unlocked, locked
+}
@@ -0,0 +1,118 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package service
+
+import (
+	"context"
+
+	"github.com/akihiro/wsl-secret-service/internal/backend"
+)
+
+// backendContext returns the context for one backend call: it is cancelled
+// when the service stops or, if Options.BackendTimeout is set, once the call
+// has taken that long.
+func (svc *Service) backendContext() (context.Context, context.CancelFunc) {
+	if svc.backendTimeout > 0 {
+		return context.WithTimeout(svc.stopped, svc.backendTimeout)
+	}
+	return context.WithCancel(svc.stopped)
+}
+
+// getSecret reads the secret of target from the cache or, on a miss, from
+// the backend.
+func (svc *Service) getSecret(target string) ([]byte, error) {
+	if b, ok := svc.secrets.Get(target); ok {
+		return b, nil
+	}
+	version := svc.secrets.Version()
+	ctx, cancel := svc.backendContext()
+	defer cancel()
+	b, err := svc.backend.Get(ctx, target)
+	if err == nil {
+		svc.cacheSecret(target, b, version)
+	}
+	return b, err
+}
+
+// cacheSecret puts secret, read from the backend after taking version, in
+// the cache.
+func (svc *Service) cacheSecret(target string, secret []byte, version uint64) {
+	if err := svc.secrets.Put(target, secret, version); err != nil {
+		logger.Debug("not caching secret", "err", err)
+	}
+}
+
+// getSecrets reads the secrets of targets, taking what it can from the cache
+// and fetching the rest from the backend in one batch. secrets[i] and
+// errs[i] belong to targets[i].
+func (svc *Service) getSecrets(targets []string) (secrets [][]byte, errs []error) {
+	secrets = make([][]byte, len(targets))
+	errs = make([]error, len(targets))
+	var missIdx []int
+	var missTargets []string
+	for i, t := range targets {
+		if b, ok := svc.secrets.Get(t); ok {
+			secrets[i] = b
+			continue
+		}
+		missIdx = append(missIdx, i)
+		missTargets = append(missTargets, t)
+	}
+	if len(missTargets) == 0 {
+		return secrets, errs
+	}
+	version := svc.secrets.Version()
+	ctx, cancel := svc.backendContext()
+	defer cancel()
+	fetched, fetchErrs := backend.GetMany(ctx, svc.backend, missTargets)
+	for j, i := range missIdx {
+		secrets[i], errs[i] = fetched[j], fetchErrs[j]
+		if errs[i] == nil {
+			svc.cacheSecret(targets[i], secrets[i], version)
+		}
+	}
+	return secrets, errs
+}
+
+// setSecret stores secret in the backend, dropping any cached old value.
+// meta describes the item for backends that store it with the secret.
+func (svc *Service) setSecret(target string, secret []byte, meta backend.Metadata) error {
+	svc.secrets.Remove(target)
+	defer svc.secrets.Remove(target)
+	ctx, cancel := svc.backendContext()
+	defer cancel()
+	return svc.backend.Set(backend.WithMetadata(ctx, meta), target, secret)
+}
+
+// deleteSecret removes target from the backend and the cache.
+func (svc *Service) deleteSecret(target string) error {
+	svc.secrets.Remove(target)
+	defer svc.secrets.Remove(target)
+	ctx, cancel := svc.backendContext()
+	defer cancel()
+	return svc.backend.Delete(ctx, target)
+}
+
+// deleteSecrets removes targets from the backend, in one batch if it
+// supports that, and from the cache.
+func (svc *Service) deleteSecrets(targets []string) []error {
+	for _, t := range targets {
+		svc.secrets.Remove(t)
+	}
+	defer func() {
+		for _, t := range targets {
+			svc.secrets.Remove(t)
+		}
+	}()
+	ctx, cancel := svc.backendContext()
+	defer cancel()
+	return backend.DeleteMany(ctx, svc.backend, targets)
+}
+
+// Close wipes secrets cached in memory and writes the recorded item
+// accesses to the store. It is called once the daemon has stopped serving
+// requests.
+func (svc *Service) Close() {
+	svc.secrets.Flush()
+	svc.flushAccesses()
+}
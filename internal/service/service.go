@@ -7,66 +7,243 @@ package service
 
 import (
 	"context"
+	"errors"
 	"fmt"
-	"log"
-	"math/big"
-	"runtime/secret"
+	"maps"
+	"runtime"
+	"slices"
 	"strings"
+	"sync"
 	"sync/atomic"
 	"time"
 
+	"github.com/akihiro/wsl-secret-service/internal/audit"
 	"github.com/akihiro/wsl-secret-service/internal/backend"
+	"github.com/akihiro/wsl-secret-service/internal/config"
+	"github.com/akihiro/wsl-secret-service/internal/logging"
+	"github.com/akihiro/wsl-secret-service/internal/memprotect"
+	"github.com/akihiro/wsl-secret-service/internal/notify"
+	"github.com/akihiro/wsl-secret-service/internal/secretcache"
 	"github.com/akihiro/wsl-secret-service/internal/store"
+	"github.com/akihiro/wsl-secret-service/internal/version"
 	"github.com/godbus/dbus/v5"
 	"github.com/godbus/dbus/v5/prop"
 	"github.com/google/uuid"
 )
 
+var logger = logging.For(logging.Service)
+
+// Reasons reported by Cause when the service shuts itself down.
+var (
+	ErrIdleTimeout       = errors.New("idle timeout")
+	ErrShutdownRequested = errors.New("shutdown requested over D-Bus")
+)
+
 // Service is the root D-Bus object at /org/freedesktop/secrets.
 // It implements org.freedesktop.Secret.Service.
 type Service struct {
 	conn                  *dbus.Conn
-	store                 *store.Store
+	store                 store.MetadataStore
 	backend               backend.Backend
 	sessions              *sessionRegistry
+	checksumKey           checksumKeeper
+	plaintext             plaintextLimiter
+	secrets               *secretcache.Cache // nil unless Options.SecretCacheTTL is set
+	targetNames           string
+	targetReservations    targetReservations
+	locks                 lockState
+	promptOnUnlock        bool
+	legacySessionKDF      bool
+	mirrorWindows         bool
+	isolateApps           bool
+	caseInsensitiveSearch bool
+	unlockPrompter        Prompter
+	confirmPrompter       Prompter
+	auditLog              *audit.Log
+	notifier              *notify.Notifier
+	policy                atomic.Pointer[config.Policy]   // replaced by Admin.Reload
+	autoLockConfig        atomic.Pointer[config.AutoLock] // replaced by Admin.Reload
+	hooks                 atomic.Pointer[[]config.Hook]   // replaced by Admin.Reload
+	hookSlots             chan struct{}                   // limits running hooks to maxRunningHooks
+	loadConfig            func() (*config.Config, error)
+	started               time.Time
+	callers               callerCache
+	approvals             approvalCache
+	accesses              accessLog
+	mirrorMu              sync.Mutex             // serializes MirrorWindowsCredentials
+	objectsMu             sync.RWMutex           // guards collections and the items of each
 	collections           map[string]*Collection // keyed by collection name
 	svcProps              *prop.Properties
-	lastActivityTimestamp atomic.Int64       // unix timestamp of last API call
-	timeoutDuration       int64              // timeout threshold in seconds
-	shutdownFn            context.CancelFunc // to trigger graceful shutdown
+	lastActivityTimestamp atomic.Int64            // unix timestamp of last API call
+	promptsRunning        atomic.Int32            // prompts whose operation is in progress
+	timeoutDuration       int64                   // timeout threshold in seconds
+	backendTimeout        time.Duration           // per-call limit, see backendContext
+	shutdownFn            context.CancelCauseFunc // to trigger graceful shutdown
+	stopped               context.Context         // cancelled once shutdownFn is called
+}
+
+// Options holds the tunable behaviour of the Secret Service.
+type Options struct {
+	// IdleTimeout shuts the daemon down after this period without API calls.
+	// Zero or negative disables it.
+	IdleTimeout time.Duration
+
+	// MaxPlaintextSecrets caps how many decrypted secrets may be held in
+	// memory concurrently; further requests wait for a free slot.
+	// Zero means no limit.
+	MaxPlaintextSecrets int
+
+	// BackendTimeout bounds each backend call, such as one run of
+	// wincred-helper.exe, so that a hung helper fails the D-Bus call instead
+	// of blocking it forever. Calls waiting for the user (Windows Hello,
+	// confirmation and password dialogs) are not bounded. Zero disables it.
+	BackendTimeout time.Duration
+
+	// SecretCacheTTL keeps secrets read from the backend in locked memory
+	// for this long, so repeated reads skip the backend. Zero disables the
+	// cache.
+	SecretCacheTTL time.Duration
+
+	// SessionMaxAge closes sessions whose key was negotiated (or rotated
+	// with Session.Rotate) longer ago than this; SessionIdleTimeout closes
+	// sessions unused for this long. Their keys are wiped and further use
+	// fails with NoSession. Zero disables the limit.
+	SessionMaxAge      time.Duration
+	SessionIdleTimeout time.Duration
+
+	// LegacySessionKDF derives the AES key of dh-ietf1024-sha256-aes128-cbc-pkcs7
+	// sessions as truncated SHA-256 of the shared secret, as earlier
+	// versions did, instead of with HKDF-SHA256 as the specification
+	// requires. Only for clients written against those versions.
+	LegacySessionKDF bool
+
+	// TargetNames selects how backend targets of new items are named:
+	// TargetNamesUUID (default) or TargetNamesLabel.
+	TargetNames string
+
+	// PromptOnUnlock makes Unlock return a Prompt object that the client must
+	// call before locked collections are unlocked, instead of unlocking them
+	// immediately.
+	PromptOnUnlock bool
+
+	// AuditLog, if set, receives a record of every secret access, change,
+	// deletion and search, with the identity of the calling process.
+	AuditLog *audit.Log
+
+	// Notifier, if set, receives an event for every item created, changed
+	// or deleted.
+	Notifier *notify.Notifier
+
+	// Policy, if set, decides which applications may use which items.
+	Policy *config.Policy
+
+	// AutoLock locks collections again after a period without access.
+	AutoLock config.AutoLock
+
+	// Hooks run commands when matching items are created, changed or
+	// deleted.
+	Hooks []config.Hook
+
+	// WatchBackend watches the backend for changes made by other programs
+	// and emits ItemChanged for the items affected, if the backend is a
+	// backend.Watcher.
+	WatchBackend bool
+
+	// Prompter asks the user to allow unlocking a collection without a
+	// master password through a Prompt, and reading items that need
+	// confirmation. If nil, unlocking is allowed without asking and
+	// confirmations are asked through the backend (WindowsPrompter).
+	Prompter Prompter
+
+	// IsolateApps restricts Flatpak and Snap applications to the items they
+	// created themselves: other items are left out of their searches and
+	// cannot be read, changed or deleted by them.
+	IsolateApps bool
+
+	// CaseInsensitiveSearch makes SearchItems compare attribute values
+	// case-insensitively, and is the default of the "ignore-case" option of
+	// SearchItemsEx. Matching items for CreateItem's replace stays exact.
+	CaseInsensitiveSearch bool
+
+	// MirrorWindows keeps the "windows" collection, filled by
+	// MirrorWindowsCredentials, read-only, and refreshes it on Admin.Reload.
+	MirrorWindows bool
+
+	// LoadConfig re-reads the configuration for Admin.Reload. If nil,
+	// Reload is not supported.
+	LoadConfig func() (*config.Config, error)
 }
 
 // New creates and fully initialises the Secret Service:
+//   - finishes deletes interrupted by a crash (store tombstones)
 //   - exports all D-Bus objects (Service, existing Collections, their Items, the stub Prompt)
 //   - subscribes to NameOwnerChanged to clean up orphaned sessions
-//   - starts idle timeout monitor with the given timeout duration
+//   - starts idle timeout monitor with opts.IdleTimeout
+//   - starts the auto-lock monitor for opts.AutoLock
+//   - starts deleting expired items (ExpiresAttribute)
+//   - with opts.WatchBackend, starts watching the backend for changes
 //
-// The caller is responsible for requesting the well-known bus name before
-// calling New, or passing replaceExisting=true to RequestName.
-func New(ctx context.Context, conn *dbus.Conn, st *store.Store, be backend.Backend, timeoutDuration time.Duration) (*Service, error) {
+// The caller is responsible for requesting the well-known bus name after New
+// returns, so that no request (in particular the one that triggered D-Bus
+// activation) arrives before the objects are exported.
+func New(ctx context.Context, conn *dbus.Conn, st store.MetadataStore, be backend.Backend, opts Options) (*Service, error) {
 	svc := &Service{
 		conn:                  conn,
 		store:                 st,
 		backend:               be,
-		sessions:              newSessionRegistry(),
+		sessions:              newSessionRegistry(opts.SessionMaxAge, opts.SessionIdleTimeout),
 		collections:           make(map[string]*Collection),
 		lastActivityTimestamp: atomic.Int64{},
-		timeoutDuration:       int64(timeoutDuration.Seconds()),
+		timeoutDuration:       int64(opts.IdleTimeout.Seconds()),
+		backendTimeout:        opts.BackendTimeout,
+		plaintext:             newPlaintextLimiter(opts.MaxPlaintextSecrets),
+		secrets:               secretcache.New(opts.SecretCacheTTL),
+		targetNames:           opts.TargetNames,
+		locks:                 lockState{locked: make(map[string]bool), accessed: make(map[string]time.Time)},
+		promptOnUnlock:        opts.PromptOnUnlock,
+		legacySessionKDF:      opts.LegacySessionKDF,
+		mirrorWindows:         opts.MirrorWindows,
+		isolateApps:           opts.IsolateApps,
+		caseInsensitiveSearch: opts.CaseInsensitiveSearch,
+		unlockPrompter:        opts.Prompter,
+		confirmPrompter:       opts.Prompter,
+		auditLog:              opts.AuditLog,
+		notifier:              opts.Notifier,
+		loadConfig:            opts.LoadConfig,
+		started:               time.Now(),
+		callers:               callerCache{callers: make(map[string]callerInfo)},
+		hookSlots:             make(chan struct{}, maxRunningHooks),
 		shutdownFn:            nil, // will be set from context
 	}
 
+	if opts.Prompter == nil {
+		svc.unlockPrompter = AutoApprove{}
+		svc.confirmPrompter = NewWindowsPrompter(be)
+	}
+
 	// Extract cancel function from context (will be used by timeout monitor)
 	// We need a context with cancel, so create one if background context is passed
-	ctxWithCancel, cancel := context.WithCancel(ctx)
+	ctxWithCancel, cancel := context.WithCancelCause(ctx)
 	svc.shutdownFn = cancel
+	svc.stopped = ctxWithCancel
 
 	// Initialize activity timestamp to current time
 	svc.lastActivityTimestamp.Store(time.Now().Unix())
+	svc.policy.Store(opts.Policy)
+	svc.autoLockConfig.Store(&opts.AutoLock)
+	svc.hooks.Store(&opts.Hooks)
 
 	// Export Service methods.
 	if err := conn.Export(svc, dbus.ObjectPath(ServicePath), ServiceIface); err != nil {
 		return nil, fmt.Errorf("export service: %w", err)
 	}
+	if err := conn.Export(&serviceExt{svc: svc}, dbus.ObjectPath(ServicePath), ServiceExtIface); err != nil {
+		return nil, fmt.Errorf("export service extension: %w", err)
+	}
+	if err := conn.Export(&Admin{svc: svc}, dbus.ObjectPath(ServicePath), AdminIface); err != nil {
+		return nil, fmt.Errorf("export admin interface: %w", err)
+	}
+	svc.exportIntrospection(dbus.ObjectPath(ServicePath), serviceChildren, ServiceIface, ServiceExtIface, AdminIface)
 
 	// Export Service properties.
 	if err := svc.exportServiceProps(); err != nil {
@@ -78,11 +255,16 @@ func New(ctx context.Context, conn *dbus.Conn, st *store.Store, be backend.Backe
 	if err := conn.Export(prompt, PromptStubObjPath, PromptIface); err != nil {
 		return nil, fmt.Errorf("export prompt: %w", err)
 	}
+	svc.exportIntrospection(PromptStubObjPath, nil, PromptIface)
+
+	// Finish deletes interrupted by a crash before anything can reuse
+	// their targets.
+	svc.completeDeletes()
 
 	// Export all persisted collections and their items.
 	for _, colName := range st.ListCollections() {
 		if err := svc.loadCollection(colName); err != nil {
-			log.Printf("warning: could not load collection %q: %v", colName, err)
+			logger.Warn("could not load collection", "collection", colName, "err", err)
 		}
 	}
 
@@ -94,7 +276,16 @@ func New(ctx context.Context, conn *dbus.Conn, st *store.Store, be backend.Backe
 	go svc.watchNameOwnerChanged()
 
 	// Start the idle timeout monitor.
-	svc.startTimeoutMonitor(ctxWithCancel)
+	if svc.timeoutDuration > 0 {
+		svc.startTimeoutMonitor(ctxWithCancel)
+	}
+	svc.startAutoLock(ctxWithCancel)
+	svc.startSessionExpiry(ctxWithCancel)
+	svc.startItemExpiry(ctxWithCancel)
+	svc.startAccessFlush(ctxWithCancel)
+	if opts.WatchBackend {
+		svc.startBackendWatch(ctxWithCancel)
+	}
 
 	return svc, nil
 }
@@ -115,6 +306,28 @@ func (svc *Service) exportServiceProps() error {
 				Emit:     prop.EmitTrue,
 			},
 		},
+		ServiceExtIface: {
+			"Version": {
+				Value:    version.String(),
+				Writable: false,
+				Emit:     prop.EmitFalse,
+			},
+			"BuildInfo": {
+				Value:    buildInfo(),
+				Writable: false,
+				Emit:     prop.EmitFalse,
+			},
+			"Capabilities": {
+				Value:    svc.capabilities(),
+				Writable: false,
+				Emit:     prop.EmitFalse,
+			},
+			"MemoryProtection": {
+				Value:    memprotect.QueryStatus().Map(),
+				Writable: false,
+				Emit:     prop.EmitFalse,
+			},
+		},
 	}
 	p, err := prop.Export(svc.conn, dbus.ObjectPath(ServicePath), propsSpec)
 	if err != nil {
@@ -124,19 +337,61 @@ func (svc *Service) exportServiceProps() error {
 	return nil
 }
 
+// buildInfo describes the daemon build for the BuildInfo property. Unknown
+// values are left out.
+func buildInfo() map[string]string {
+	info := map[string]string{"version": version.String(), "go": runtime.Version()}
+	if c := version.CommitString(); c != "" {
+		info["commit"] = c
+	}
+	if version.BuildDate != "" {
+		info["build_date"] = version.BuildDate
+	}
+	return info
+}
+
+// capabilities lists the optional features enabled in this daemon instance.
+func (svc *Service) capabilities() []string {
+	caps := []string{
+		CapSecretChecksum,
+		CapMemoryProtection,
+		CapLocking,
+		CapCreator,
+		CapSearchEx,
+	}
+	if _, ok := backend.As[backend.Verifier](svc.backend); ok {
+		caps = append(caps, CapUserVerification)
+	}
+	if _, ok := backend.As[backend.Approver](svc.backend); ok {
+		caps = append(caps, CapUserApproval)
+	}
+	if _, ok := backend.As[backend.PasswordPrompter](svc.backend); ok {
+		caps = append(caps, CapPassword)
+	}
+	return caps
+}
+
 // loadCollection exports an existing collection and all its items from the store.
 func (svc *Service) loadCollection(name string) error {
 	col := &Collection{name: name, svc: svc}
+	if svc.hasPassword(name) {
+		// Password-protected collections start locked.
+		svc.locks.mu.Lock()
+		svc.locks.locked[name] = true
+		svc.locks.mu.Unlock()
+	}
 	if err := svc.exportCollection(col); err != nil {
 		return err
 	}
+	svc.objectsMu.Lock()
 	svc.collections[name] = col
+	svc.objectsMu.Unlock()
 
 	// Export each item in the collection.
 	for _, itemUUID := range svc.store.ListItems(name) {
 		item := &Item{collectionName: name, uuid: itemUUID, svc: svc}
 		if err := svc.exportItem(item); err != nil {
-			log.Printf("warning: could not export item %s/%s: %v", name, itemUUID, err)
+			logger.Warn("could not export item", "collection", name, "item", itemUUID, "err", err)
 		}
 	}
 	return nil
@@ -154,18 +409,50 @@ func (svc *Service) exportAliasedCollections() {
 
 // exportCollectionAtAlias exports a collection at a specific alias path.
 func (svc *Service) exportCollectionAtAlias(alias, colName string) {
-	col, ok := svc.collections[colName]
+	col, ok := svc.collection(colName)
 	if !ok {
 		return
 	}
-	aliasPath := dbus.ObjectPath(fmt.Sprintf("/org/freedesktop/secrets/aliases/%s", alias))
+	aliasPath := dbus.ObjectPath(AliasPathPrefix + alias)
 	if err := svc.conn.Export(col, aliasPath, CollectionIface); err != nil {
-		log.Printf("warning: could not export collection at alias path %s: %v", aliasPath, err)
+		logger.Warn("could not export collection at alias path", "path", aliasPath, "err", err)
 	}
 	// Also export the Properties interface at the alias path.
-	if err := svc.conn.Export(col, aliasPath, "org.freedesktop.DBus.Properties"); err != nil {
-		log.Printf("warning: could not export properties at alias path %s: %v", aliasPath, err)
+	if err := svc.conn.Export(&collectionProperties{props: col.props, col: col}, aliasPath, "org.freedesktop.DBus.Properties"); err != nil {
+		logger.Warn("could not export properties at alias path", "path", aliasPath, "err", err)
+	}
+	svc.exportIntrospection(aliasPath, nil, CollectionIface)
+}
+
+// collection returns the exported collection colName.
+func (svc *Service) collection(colName string) (*Collection, bool) {
+	svc.objectsMu.RLock()
+	defer svc.objectsMu.RUnlock()
+	col, ok := svc.collections[colName]
+	return col, ok
+}
+
+// exportedItem returns the exported item itemUUID of collection colName.
+func (svc *Service) exportedItem(colName, itemUUID string) (*Item, bool) {
+	svc.objectsMu.RLock()
+	defer svc.objectsMu.RUnlock()
+	col, ok := svc.collections[colName]
+	if !ok {
+		return nil, false
 	}
+	item, ok := col.items[itemUUID]
+	return item, ok
+}
+
+// exportedItems returns the exported items of collection colName.
+func (svc *Service) exportedItems(colName string) []*Item {
+	svc.objectsMu.RLock()
+	defer svc.objectsMu.RUnlock()
+	col, ok := svc.collections[colName]
+	if !ok {
+		return nil
+	}
+	return slices.Collect(maps.Values(col.items))
 }
 
 // updateCollectionsProp refreshes the Collections property on the Service object.
@@ -182,11 +469,18 @@ func (svc *Service) updateCollectionsProp() {
 }
 
 // watchNameOwnerChanged listens for D-Bus client disconnections and removes
-// any sessions owned by the disconnected client.
+// any sessions owned by the disconnected client. It also retires the
+// service when BusName is taken over (see handleNameLost).
 func (svc *Service) watchNameOwnerChanged() {
 	ch := make(chan *dbus.Signal, 16)
 	svc.conn.Signal(ch)
 	for sig := range ch {
+		if sig.Name == "org.freedesktop.DBus.NameLost" {
+			if len(sig.Body) > 0 && sig.Body[0] == BusName {
+				svc.handleNameLost()
+			}
+			continue
+		}
 		if sig.Name != "org.freedesktop.DBus.NameOwnerChanged" {
 			continue
 		}
@@ -194,23 +488,44 @@ func (svc *Service) watchNameOwnerChanged() {
 			continue
 		}
 		// Body: [name, oldOwner, newOwner]
+		name, _ := sig.Body[0].(string)
 		newOwner, _ := sig.Body[2].(string)
 		if newOwner != "" {
 			continue // name gained a new owner — not a disconnect
 		}
-		// A client disconnected; remove all sessions in memory whose path
-		// ends with that client's unique name (we don't currently track
-		// per-sender sessions, so this is a best-effort cleanup for future
-		// sender-tagged sessions).
-		// For now, just let sessions GC naturally on Close().
+		svc.forgetCaller(name)
+		// A client disconnected without closing its sessions.
+		for _, s := range svc.sessions.removeOwner(name) {
+			s.shutdown()
+			logger.Debug("session closed after its client disconnected", "session", s.path, "client", name)
+		}
 	}
 }
 
+// Done returns a channel that is closed when the service shuts itself down
+// after the idle timeout, or when the context passed to New is cancelled.
+func (svc *Service) Done() <-chan struct{} {
+	return svc.stopped.Done()
+}
+
+// Cause reports why Done was closed: ErrIdleTimeout, ErrShutdownRequested,
+// ErrNameLost, or the cause of the parent context's cancellation. It returns nil while
+// the service is running.
+func (svc *Service) Cause() error {
+	return context.Cause(svc.stopped)
+}
+
 // recordActivity updates the last API activity timestamp to the current time.
 func (svc *Service) recordActivity() {
 	svc.lastActivityTimestamp.Store(time.Now().Unix())
 }
 
+// busy reports whether a client has an open session or a prompt in
+// progress, which keep the daemon from shutting down when idle.
+func (svc *Service) busy() bool {
+	return svc.sessions.count() > 0 || svc.promptsRunning.Load() > 0
+}
+
 // startTimeoutMonitor launches a background goroutine that monitors idle timeout.
 // It sleeps until the calculated timeout deadline, then checks if the timeout has been exceeded.
 // If so, it calls the shutdown function. Otherwise, it recalculates and sleeps again.
@@ -228,10 +543,16 @@ func (svc *Service) startTimeoutMonitor(ctx context.Context) {
 			timeoutDeadline := lastActivity + svc.timeoutDuration
 			now := time.Now().Unix()
 
+			if now >= timeoutDeadline && svc.busy() {
+				// A client still holds a session or waits for a prompt: count
+				// it as activity, so the timeout runs from when it is done.
+				svc.recordActivity()
+				continue
+			}
 			if now >= timeoutDeadline {
 				// Idle timeout exceeded, initiate graceful shutdown
-				log.Printf("idle timeout (%d seconds) exceeded, initiating shutdown", svc.timeoutDuration)
-				svc.shutdownFn()
+				logger.Info("idle timeout exceeded, initiating shutdown", "timeout", time.Duration(svc.timeoutDuration)*time.Second)
+				svc.shutdownFn(ErrIdleTimeout)
 				return
 			}
 
@@ -251,7 +572,7 @@ func (svc *Service) startTimeoutMonitor(ctx context.Context) {
 
 // OpenSession implements Service.OpenSession(algorithm, input).
 // Supports "plain" and "dh-ietf1024-sha256-aes128-cbc-pkcs7".
-func (svc *Service) OpenSession(algorithm string, input dbus.Variant) (dbus.Variant, dbus.ObjectPath, *dbus.Error) {
+func (svc *Service) OpenSession(sender dbus.Sender, algorithm string, input dbus.Variant) (dbus.Variant, dbus.ObjectPath, *dbus.Error) {
 	svc.recordActivity()
 
 	var sess *Session
@@ -268,28 +589,14 @@ func (svc *Service) OpenSession(algorithm string, input dbus.Variant) (dbus.Vari
 			return dbus.MakeVariant(""), "/",
 				dbusError("org.freedesktop.DBus.Error.InvalidArgs", "expected client DH public key as byte array")
 		}
-		clientPubKey := new(big.Int).SetBytes(clientPubBytes)
-
-		// Perform DH key generation and AES key derivation inside secret.Do so
-		// that the DH private key and shared secret (both allocated within Do)
-		// are marked for eager zeroing by the GC once they become unreachable.
-		// aesKey and serverPubBytes intentionally escape Do to be stored in the
-		// Session and returned to the caller respectively.
-		var aesKey []byte
-		var serverPubBytes []byte
-		var dhErr error
-		secret.Do(func() {
-			var privKey, pubKey *big.Int
-			privKey, pubKey, dhErr = dhGenerateKeyPair()
-			if dhErr != nil {
-				return
-			}
-			aesKey = dhDeriveAESKey(privKey, clientPubKey)
-			serverPubBytes = bigIntToGroupBytes(pubKey)
-		})
-		if dhErr != nil {
+		aesKey, serverPubBytes, err := negotiateSessionKey(clientPubBytes, svc.legacySessionKDF)
+		if errors.Is(err, errInvalidPublicKey) {
+			return dbus.MakeVariant(""), "/",
+				dbusError("org.freedesktop.DBus.Error.InvalidArgs", err.Error())
+		}
+		if err != nil {
 			return dbus.MakeVariant(""), "/",
-				dbusError("org.freedesktop.DBus.Error.Failed", fmt.Sprintf("generate DH key pair: %v", dhErr))
+				dbusError("org.freedesktop.DBus.Error.Failed", err.Error())
 		}
 
 		sess = &Session{
@@ -308,11 +615,20 @@ func (svc *Service) OpenSession(algorithm string, input dbus.Variant) (dbus.Vari
 			}
 	}
 
+	sess.owner = string(sender)
+	sess.keyed = time.Now()
+	sess.lastUsed = sess.keyed
 	if err := svc.conn.Export(sess, sess.path, SessionIface); err != nil {
 		return dbus.MakeVariant(""), "/",
 			dbusError("org.freedesktop.DBus.Error.Failed", fmt.Sprintf("export session: %v", err))
 	}
+	if err := svc.conn.Export(&sessionExt{sess}, sess.path, SessionExtIface); err != nil {
+		return dbus.MakeVariant(""), "/",
+			dbusError("org.freedesktop.DBus.Error.Failed", fmt.Sprintf("export session: %v", err))
+	}
+	svc.exportIntrospection(sess.path, nil, SessionIface, SessionExtIface)
 	svc.sessions.add(sess)
+	logger.Debug("session opened", "session", sess.path, "algorithm", algorithm)
 	return output, sess.path, nil
 }
 
@@ -340,20 +656,43 @@ func (svc *Service) CreateCollection(
 		}
 	}
 
-	// Derive a slug from the label for the collection name.
-	name := collectionSlug(label)
-	// Ensure uniqueness.
-	base := name
-	for i := 2; ; i++ {
-		if _, exists := svc.store.GetCollection(name); !exists {
-			break
+	var name string
+	if v, ok := properties[CollectionNameProperty]; ok {
+		// The client chose the name, e.g. to restore a backup.
+		name, _ = v.Value().(string)
+		if name == "" || collectionSlug(name) != name {
+			return "/", StubPromptPath, dbusError("org.freedesktop.DBus.Error.InvalidArgs",
+				fmt.Sprintf("invalid collection name %q: use lowercase letters and digits", name))
+		}
+		if _, exists := svc.store.GetCollection(name); exists {
+			return "/", StubPromptPath, dbusError("org.freedesktop.DBus.Error.Failed",
+				fmt.Sprintf("collection %q already exists", name))
+		}
+	} else {
+		// Derive a slug from the label for the collection name.
+		name = collectionSlug(label)
+		// Ensure uniqueness.
+		base := name
+		for i := 2; ; i++ {
+			if _, exists := svc.store.GetCollection(name); !exists {
+				break
+			}
+			name = fmt.Sprintf("%s%d", base, i)
 		}
-		name = fmt.Sprintf("%s%d", base, i)
 	}
 
+	if err := svc.addCollection(name, label, alias); err != nil {
+		return "/", StubPromptPath, dbusError("org.freedesktop.DBus.Error.Failed", err.Error())
+	}
+	return CollectionPath(name), StubPromptPath, nil
+}
+
+// addCollection persists a new collection, optionally points alias at it,
+// exports it on the bus and announces it with CollectionCreated.
+func (svc *Service) addCollection(name, label, alias string) error {
 	// Persist.
 	if err := svc.store.CreateCollection(name, label); err != nil {
-		return "/", StubPromptPath, dbusError("org.freedesktop.DBus.Error.Failed", err.Error())
+		return err
 	}
 
 	// Set alias if requested.
@@ -363,50 +702,128 @@ func (svc *Service) CreateCollection(
 
 	// Export.
 	col := &Collection{name: name, svc: svc}
+	if svc.hasPassword(name) {
+		// Password-protected collections start locked.
+		svc.locks.mu.Lock()
+		svc.locks.locked[name] = true
+		svc.locks.mu.Unlock()
+	}
 	if err := svc.exportCollection(col); err != nil {
-		return "/", StubPromptPath, dbusError("org.freedesktop.DBus.Error.Failed", err.Error())
+		return err
 	}
+	svc.objectsMu.Lock()
 	svc.collections[name] = col
+	svc.objectsMu.Unlock()
 
 	colPath := CollectionPath(name)
 	_ = svc.conn.Emit(dbus.ObjectPath(ServicePath), ServiceIface+".CollectionCreated", colPath)
 	svc.updateCollectionsProp()
-
-	return colPath, StubPromptPath, nil
+	logger.Debug("collection created", "collection", colPath, "alias", alias)
+	return nil
 }
 
 // SearchItems implements Service.SearchItems(attributes).
-// Returns (unlocked, locked) — all items are always unlocked.
-func (svc *Service) SearchItems(attributes map[string]string) ([]dbus.ObjectPath, []dbus.ObjectPath, *dbus.Error) {
+// Returns (unlocked, locked) according to the lock state of each item's collection.
+func (svc *Service) SearchItems(sender dbus.Sender, attributes map[string]string) ([]dbus.ObjectPath, []dbus.ObjectPath, *dbus.Error) {
 	svc.recordActivity()
+	svc.auditEntry(sender, audit.Entry{Op: audit.OpSearch, Object: ServicePath, Attributes: attributes}, nil)
 
-	refs := svc.store.SearchItems(attributes)
-	paths := make([]dbus.ObjectPath, len(refs))
-	for i, ref := range refs {
-		paths[i] = ItemPath(ref.Collection, ref.UUID)
-	}
-	return paths, []dbus.ObjectPath{}, nil
+	unlocked, locked := svc.partitionItems(sender, svc.searchAttributes("", attributes))
+	return unlocked, locked, nil
 }
 
 // Unlock implements Service.Unlock(objects).
-// All objects are always unlocked. Returns (objects, "/").
+// Objects whose collection is already unlocked are returned directly. The
+// remaining collections are unlocked immediately, or, with PromptOnUnlock
+// or for collections with a master password, only once the client has
+// called Prompt() on the returned prompt object and the user has entered
+// the password. The prompt's Completed result lists the objects that were
+// unlocked; it is dismissed if none were.
 func (svc *Service) Unlock(objects []dbus.ObjectPath) ([]dbus.ObjectPath, dbus.ObjectPath, *dbus.Error) {
 	svc.recordActivity()
 
-	return objects, StubPromptPath, nil
+	unlocked := []dbus.ObjectPath{}
+	var pending []dbus.ObjectPath
+	for _, obj := range objects {
+		colName := svc.lockTarget(obj)
+		switch {
+		case colName == "":
+			continue
+		case !svc.isLocked(colName):
+			unlocked = append(unlocked, obj)
+		case svc.promptOnUnlock || svc.hasPassword(colName):
+			pending = append(pending, obj)
+		default:
+			svc.setLocked(colName, false)
+			unlocked = append(unlocked, obj)
+		}
+	}
+	if len(pending) == 0 {
+		return unlocked, StubPromptPath, nil
+	}
+
+	prompt, err := svc.newPrompt(func(windowID string) (dbus.Variant, bool) {
+		ctx := svc.promptContext(windowID)
+		done := []dbus.ObjectPath{}
+		for _, obj := range pending {
+			colName := svc.lockTarget(obj)
+			if colName == "" {
+				continue
+			}
+			if svc.isLocked(colName) {
+				label := colName
+				if meta, ok := svc.store.GetCollection(colName); ok {
+					label = meta.Label
+				}
+				var err error
+				if svc.hasPassword(colName) {
+					err = svc.checkPassword(ctx, colName, fmt.Sprintf("Enter the password to unlock the collection %q.", label))
+				} else {
+					err = svc.unlockPrompter.Confirm(ctx, fmt.Sprintf("An application wants to unlock the collection %q.", label))
+				}
+				if err != nil {
+					logger.Info("collection not unlocked", "collection", colName, "err", err)
+					continue
+				}
+				svc.setLocked(colName, false)
+			}
+			done = append(done, obj)
+		}
+		if len(done) == 0 {
+			return dbus.Variant{}, true
+		}
+		return dbus.MakeVariant(done), false
+	})
+	if err != nil {
+		return nil, StubPromptPath, dbusError("org.freedesktop.DBus.Error.Failed", err.Error())
+	}
+	return unlocked, prompt, nil
 }
 
 // Lock implements Service.Lock(objects).
-// Locking is not supported; returns ([], "/").
+// Locks the collections of all given objects and returns the objects that
+// are now locked. No prompt is ever needed.
 func (svc *Service) Lock(objects []dbus.ObjectPath) ([]dbus.ObjectPath, dbus.ObjectPath, *dbus.Error) {
 	svc.recordActivity()
 
-	return []dbus.ObjectPath{}, StubPromptPath, nil
+	locked := []dbus.ObjectPath{}
+	for _, obj := range objects {
+		colName := svc.lockTarget(obj)
+		if colName == "" {
+			continue
+		}
+		svc.setLocked(colName, true)
+		locked = append(locked, obj)
+	}
+	return locked, StubPromptPath, nil
 }
 
 // GetSecrets implements Service.GetSecrets(items, session).
 // Returns a map of item path → Secret for each requested item.
+// Items in locked collections, and flagged items the user declines to
+// verify, are omitted.
 func (svc *Service) GetSecrets(
+	sender dbus.Sender,
 	items []dbus.ObjectPath,
 	session dbus.ObjectPath,
 ) (map[dbus.ObjectPath]dbus.Variant, *dbus.Error) {
@@ -418,37 +835,85 @@ func (svc *Service) GetSecrets(
 			fmt.Sprintf("session %s is not open", session))
 	}
 
-	result := make(map[dbus.ObjectPath]dbus.Variant, len(items))
+	// Collect the releasable items first so that their secrets can be
+	// fetched from the backend in one batch.
+	type pendingSecret struct {
+		path   dbus.ObjectPath
+		meta   store.ItemMeta
+		target string
+	}
+	var pending []pendingSecret
 	for _, itemPath := range items {
 		colName, itemUUID := ItemUUIDFromPath(itemPath)
 		if colName == "" || itemUUID == "" {
 			continue
 		}
+		if svc.isLocked(colName) {
+			svc.audit(sender, audit.OpGetSecret, itemPath, errLocked(colName))
+			continue
+		}
 		meta, ok := svc.store.GetItem(colName, itemUUID)
-		if !ok {
+		if !ok || expired(meta, time.Now()) {
 			continue
 		}
-		target := fmt.Sprintf("wsl-ss/%s/%s", colName, itemUUID)
-		secretBytes, err := svc.backend.Get(target)
-		if err != nil {
-			continue // Skip items whose secrets can't be retrieved.
+		if derr := svc.authorizeItem(sender, colName, itemUUID); derr != nil {
+			svc.audit(sender, audit.OpGetSecret, itemPath, derr)
+			continue
 		}
-		ct := meta.ContentType
-		if ct == "" {
-			ct = "text/plain; charset=utf8"
+		svc.touchCollection(colName)
+		if derr := svc.verifyAccess(meta); derr != nil {
+			logger.Warn("not releasing secret", "item", itemPath, "reason", derr.Body[0])
+			svc.audit(sender, audit.OpGetSecret, itemPath, derr)
+			continue
 		}
-		params, value, err := sess.encryptSecret(secretBytes)
-		if err != nil {
-			log.Printf("warning: could not encrypt secret for %s: %v", itemPath, err)
+		if derr := svc.confirmAccess(sender, itemPath, colName, meta); derr != nil {
+			svc.audit(sender, audit.OpGetSecret, itemPath, derr)
 			continue
 		}
-		secret := Secret{
-			Session:     session,
-			Parameters:  params,
-			Value:       value,
-			ContentType: ct,
+		pending = append(pending, pendingSecret{itemPath, meta, svc.itemTarget(colName, itemUUID)})
+	}
+
+	// A plaintext cap forbids holding a whole batch decrypted at once, and
+	// acquiring several slots at a time could deadlock, so fetch one by
+	// one then.
+	batch := len(pending)
+	if svc.plaintext != nil {
+		batch = 1
+	}
+	result := make(map[dbus.ObjectPath]dbus.Variant, len(pending))
+	for chunk := range slices.Chunk(pending, max(batch, 1)) {
+		targets := make([]string, len(chunk))
+		for i, p := range chunk {
+			targets[i] = p.target
+			svc.plaintext.acquire()
+		}
+		secrets, errs := svc.getSecrets(targets)
+		for i, p := range chunk {
+			if errs[i] != nil {
+				svc.plaintext.release()
+				svc.audit(sender, audit.OpGetSecret, p.path, dbusError("org.freedesktop.DBus.Error.Failed", errs[i].Error()))
+				continue // Skip items whose secrets can't be retrieved.
+			}
+			ct := p.meta.ContentType
+			if ct == "" {
+				ct = "text/plain; charset=utf8"
+			}
+			params, value, err := sess.encryptSecret(secrets[i])
+			svc.plaintext.release(secrets[i])
+			if err != nil {
+				logger.Warn("could not encrypt secret", "item", p.path, "err", err)
+				continue
+			}
+			secret := Secret{
+				Session:     session,
+				Parameters:  params,
+				Value:       value,
+				ContentType: ct,
+			}
+			result[p.path] = dbus.MakeVariant(secret)
+			svc.recordAccess(ItemUUIDFromPath(p.path))
+			svc.audit(sender, audit.OpGetSecret, p.path, nil)
 		}
-		result[itemPath] = dbus.MakeVariant(secret)
 	}
 	return result, nil
 }
@@ -476,9 +941,10 @@ func (svc *Service) SetAlias(name string, collection dbus.ObjectPath) *dbus.Erro
 			return dbusError("org.freedesktop.DBus.Error.Failed", err.Error())
 		}
 		// Unpublish the alias path
-		aliasPath := dbus.ObjectPath(fmt.Sprintf("/org/freedesktop/secrets/aliases/%s", name))
+		aliasPath := dbus.ObjectPath(AliasPathPrefix + name)
 		_ = svc.conn.Export(nil, aliasPath, CollectionIface)
 		_ = svc.conn.Export(nil, aliasPath, "org.freedesktop.DBus.Properties")
+		svc.unexportIntrospection(aliasPath)
 		return nil
 	}
 	colName := CollectionNameFromPath(collection)
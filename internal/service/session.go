@@ -3,22 +3,40 @@
 package service
 
 import (
+	"context"
+	"errors"
 	"fmt"
+	"math/big"
 	"runtime/secret"
 	"sync"
+	"time"
 
+	"github.com/akihiro/wsl-secret-service/internal/memprotect"
 	"github.com/godbus/dbus/v5"
 )
 
-// sessionRegistry tracks open D-Bus sessions keyed by their object path.
+// sessionSweepInterval is how often sessions are checked for expiry when
+// Options.SessionMaxAge or Options.SessionIdleTimeout is set.
+const sessionSweepInterval = time.Minute
+
+// sessionRegistry tracks open D-Bus sessions keyed by their object path,
+// and indexes them by the unique bus name of the client that opened them.
 type sessionRegistry struct {
 	mu       sync.Mutex
 	sessions map[dbus.ObjectPath]*Session
+	byOwner  map[string]map[dbus.ObjectPath]*Session
+
+	// maxAge and idle expire sessions whose key is older than maxAge or
+	// that were not used for idle. Zero disables the limit.
+	maxAge, idle time.Duration
 }
 
-func newSessionRegistry() *sessionRegistry {
+func newSessionRegistry(maxAge, idle time.Duration) *sessionRegistry {
 	return &sessionRegistry{
 		sessions: make(map[dbus.ObjectPath]*Session),
+		byOwner:  make(map[string]map[dbus.ObjectPath]*Session),
+		maxAge:   maxAge,
+		idle:     idle,
 	}
 }
 
@@ -26,36 +44,208 @@ func (r *sessionRegistry) add(s *Session) {
 	r.mu.Lock()
 	defer r.mu.Unlock()
 	r.sessions[s.path] = s
+	owned := r.byOwner[s.owner]
+	if owned == nil {
+		owned = make(map[dbus.ObjectPath]*Session)
+		r.byOwner[s.owner] = owned
+	}
+	owned[s.path] = s
 }
 
 func (r *sessionRegistry) remove(path dbus.ObjectPath) {
 	r.mu.Lock()
 	defer r.mu.Unlock()
-	delete(r.sessions, path)
+	if s, ok := r.sessions[path]; ok {
+		r.deleteLocked(s)
+	}
 }
 
-func (r *sessionRegistry) get(path dbus.ObjectPath) (*Session, bool) {
+// count returns the number of open sessions.
+func (r *sessionRegistry) count() int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return len(r.sessions)
+}
+
+// removeOwner removes and returns all sessions opened by owner.
+func (r *sessionRegistry) removeOwner(owner string) []*Session {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	var removed []*Session
+	for _, s := range r.byOwner[owner] {
+		removed = append(removed, s)
+	}
+	for _, s := range removed {
+		r.deleteLocked(s)
+	}
+	return removed
+}
+
+// removeAll removes and returns all sessions.
+func (r *sessionRegistry) removeAll() []*Session {
 	r.mu.Lock()
 	defer r.mu.Unlock()
+	removed := make([]*Session, 0, len(r.sessions))
+	for _, s := range r.sessions {
+		removed = append(removed, s)
+	}
+	for _, s := range removed {
+		r.deleteLocked(s)
+	}
+	return removed
+}
+
+// deleteLocked removes s from both indexes. Caller must hold r.mu.
+func (r *sessionRegistry) deleteLocked(s *Session) {
+	delete(r.sessions, s.path)
+	if owned := r.byOwner[s.owner]; owned != nil {
+		delete(owned, s.path)
+		if len(owned) == 0 {
+			delete(r.byOwner, s.owner)
+		}
+	}
+}
+
+// get returns the open session at path and marks it used. An expired
+// session is closed and reported as missing, so that callers answer
+// NoSession.
+func (r *sessionRegistry) get(path dbus.ObjectPath) (*Session, bool) {
+	r.mu.Lock()
 	s, ok := r.sessions[path]
+	now := time.Now()
+	if ok && s.expired(now, r.maxAge, r.idle) {
+		r.deleteLocked(s)
+		r.mu.Unlock()
+		s.expire()
+		return nil, false
+	}
+	r.mu.Unlock()
+	if ok {
+		s.touch(now)
+	}
 	return s, ok
 }
 
+// expireIdle closes every session that has expired by now.
+func (r *sessionRegistry) expireIdle(now time.Time) {
+	var expired []*Session
+	r.mu.Lock()
+	for _, s := range r.sessions {
+		if s.expired(now, r.maxAge, r.idle) {
+			expired = append(expired, s)
+		}
+	}
+	for _, s := range expired {
+		r.deleteLocked(s)
+	}
+	r.mu.Unlock()
+	for _, s := range expired {
+		s.expire()
+	}
+}
+
+// startSessionExpiry launches a background goroutine that closes expired
+// sessions, so that keys of abandoned sessions do not stay in memory until
+// their next use. It does nothing without a session limit.
+func (svc *Service) startSessionExpiry(ctx context.Context) {
+	if svc.sessions.maxAge <= 0 && svc.sessions.idle <= 0 {
+		return
+	}
+	go func() {
+		ticker := time.NewTicker(sessionSweepInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case now := <-ticker.C:
+				svc.sessions.expireIdle(now)
+			}
+		}
+	}()
+}
+
 // Session represents an open Secret Service session with a client application.
 // aesKey is nil for plain sessions (no encryption); 16 bytes for DH sessions.
 type Session struct {
-	path   dbus.ObjectPath
-	conn   *dbus.Conn
-	svc    *Service
-	aesKey []byte // nil → plain; 16 bytes → dh-ietf1024-sha256-aes128-cbc-pkcs7
+	path  dbus.ObjectPath
+	conn  *dbus.Conn
+	svc   *Service
+	owner string // unique bus name of the client that opened the session
+
+	mu       sync.Mutex
+	aesKey   []byte    // nil → plain; 16 bytes → dh-ietf1024-sha256-aes128-cbc-pkcs7
+	keyed    time.Time // when aesKey was negotiated or last rotated
+	lastUsed time.Time
+	closed   bool
+}
+
+// errSessionClosed is returned when a session is used after it was closed
+// or expired, instead of falling back to an unencrypted transfer.
+var errSessionClosed = errors.New("session is closed")
+
+// negotiateSessionKey performs the server side of the
+// dh-ietf1024-sha256-aes128-cbc-pkcs7 exchange with the client's public key
+// and returns the AES key, in allocKey memory, and the server's public key.
+// legacyKDF selects the pre-HKDF key derivation (see dhDeriveAESKey).
+func negotiateSessionKey(clientPubBytes []byte, legacyKDF bool) (aesKey, serverPubBytes []byte, err error) {
+	clientPubKey := new(big.Int).SetBytes(clientPubBytes)
+	if err := dhCheckPublicKey(clientPubKey); err != nil {
+		return nil, nil, err
+	}
+
+	// Perform DH key generation and AES key derivation inside secret.Do so
+	// that the DH private key and shared secret (both allocated within Do)
+	// are marked for eager zeroing by the GC once they become unreachable.
+	// aesKey and serverPubBytes intentionally escape Do to be stored in the
+	// Session and returned to the caller respectively.
+	secret.Do(func() {
+		var privKey, pubKey *big.Int
+		privKey, pubKey, err = dhGenerateKeyPair()
+		if err != nil {
+			return
+		}
+		var key []byte
+		if key, err = dhDeriveAESKey(privKey, clientPubKey, legacyKDF); err != nil {
+			return
+		}
+		aesKey = allocKey(len(key))
+		copy(aesKey, key)
+		clear(key)
+		serverPubBytes = bigIntToGroupBytes(pubKey)
+	})
+	if err != nil {
+		return nil, nil, fmt.Errorf("negotiate session key: %w", err)
+	}
+	return aesKey, serverPubBytes, nil
+}
+
+// expired reports whether the session's key is older than maxAge or the
+// session was last used more than idle before now.
+func (s *Session) expired(now time.Time, maxAge, idle time.Duration) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return (maxAge > 0 && now.Sub(s.keyed) >= maxAge) || (idle > 0 && now.Sub(s.lastUsed) >= idle)
+}
+
+func (s *Session) touch(now time.Time) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.lastUsed = now
 }
 
 // encryptSecret encrypts plaintext for delivery over D-Bus.
-// For plain sessions it is a no-op. For DH sessions it uses AES-128-CBC.
+// For plain sessions the value is a copy of plaintext, so the caller may wipe
+// plaintext as soon as this returns. For DH sessions it uses AES-128-CBC.
 // Returns (parameters/IV, ciphertext).
 func (s *Session) encryptSecret(plaintext []byte) (params, value []byte, err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed {
+		return nil, nil, errSessionClosed
+	}
 	if s.aesKey == nil {
-		return []byte{}, plaintext, nil
+		return []byte{}, append([]byte(nil), plaintext...), nil
 	}
 	iv, ciphertext, err := aesEncrypt(s.aesKey, plaintext)
 	if err != nil {
@@ -67,6 +257,11 @@ func (s *Session) encryptSecret(plaintext []byte) (params, value []byte, err err
 // decryptSecret decrypts a secret received over D-Bus.
 // For plain sessions it is a no-op. For DH sessions it uses AES-128-CBC.
 func (s *Session) decryptSecret(params, ciphertext []byte) ([]byte, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed {
+		return nil, errSessionClosed
+	}
 	if s.aesKey == nil {
 		return ciphertext, nil
 	}
@@ -82,19 +277,94 @@ func (s *Session) decryptSecret(params, ciphertext []byte) ([]byte, error) {
 
 // Close implements org.freedesktop.Secret.Session.Close().
 // It removes this session from the service registry and unexports its D-Bus object.
-// The AES session key is wiped inside secret.Do so that the key bytes in the
-// backing array are zeroed and registers that held key material are cleared
-// before returning.  Setting s.aesKey to nil makes the backing array
-// unreachable; because it was allocated inside a secret.Do call in OpenSession,
-// the GC will eagerly zero it when it is collected.
 func (s *Session) Close() *dbus.Error {
 	s.svc.recordActivity()
 
 	s.svc.sessions.remove(s.path)
+	s.shutdown()
+	logger.Debug("session closed", "session", s.path)
+	return nil
+}
+
+// expire closes a session that the registry found expired.
+func (s *Session) expire() {
+	s.shutdown()
+	logger.Debug("session expired", "session", s.path)
+}
+
+// shutdown unexports the session and wipes its key.
+// The AES session key is wiped inside secret.Do so that the key bytes in the
+// backing array are zeroed (and its memfd_secret or mlocked page released)
+// and registers that held key material are cleared before returning.  Setting
+// s.aesKey to nil makes the backing array unreachable; because it was
+// allocated inside a secret.Do call in negotiateSessionKey, the GC will
+// eagerly zero it when it is collected.
+func (s *Session) shutdown() {
 	_ = s.conn.Export(nil, s.path, SessionIface)
+	_ = s.conn.Export(nil, s.path, SessionExtIface)
+	_ = s.conn.Export(nil, s.path, IntrospectableIface)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.closed = true
 	secret.Do(func() {
-		clear(s.aesKey)
+		memprotect.Wipe(s.aesKey)
 		s.aesKey = nil
 	})
-	return nil
+}
+
+// sessionExt implements org.akihiro.WslSecretService.Session on session
+// objects. It is a separate type so that only its methods are exported
+// under SessionExtIface.
+type sessionExt struct {
+	s *Session
+}
+
+// Rotate implements Session.Rotate(input): a new
+// dh-ietf1024-sha256-aes128-cbc-pkcs7 exchange with the client's new
+// public key that replaces the session's AES key, restarting the key's age
+// for Options.SessionMaxAge. It returns the server's public key. The old
+// key is wiped; secrets already in flight must have been decrypted by then.
+// Only the client that opened the session may rotate its key.
+func (e *sessionExt) Rotate(sender dbus.Sender, input []byte) ([]byte, *dbus.Error) {
+	s := e.s
+	s.svc.recordActivity()
+	if string(sender) != s.owner {
+		return nil, dbusError("org.freedesktop.DBus.Error.AccessDenied",
+			fmt.Sprintf("session %s belongs to another client", s.path))
+	}
+	if _, ok := s.svc.sessions.get(s.path); !ok {
+		return nil, dbusError("org.freedesktop.Secret.Error.NoSession",
+			fmt.Sprintf("session %s is not open", s.path))
+	}
+	s.mu.Lock()
+	plain := s.aesKey == nil
+	s.mu.Unlock()
+	if plain {
+		return nil, dbusError("org.freedesktop.Secret.Error.NotSupported", "plain sessions have no key to rotate")
+	}
+	if len(input) == 0 {
+		return nil, dbusError("org.freedesktop.DBus.Error.InvalidArgs", "expected client DH public key as byte array")
+	}
+	aesKey, serverPubBytes, err := negotiateSessionKey(input, s.svc.legacySessionKDF)
+	if errors.Is(err, errInvalidPublicKey) {
+		return nil, dbusError("org.freedesktop.DBus.Error.InvalidArgs", err.Error())
+	}
+	if err != nil {
+		return nil, dbusError("org.freedesktop.DBus.Error.Failed", err.Error())
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed {
+		memprotect.Wipe(aesKey)
+		return nil, dbusError("org.freedesktop.Secret.Error.NoSession",
+			fmt.Sprintf("session %s is not open", s.path))
+	}
+	secret.Do(func() {
+		memprotect.Wipe(s.aesKey)
+		s.aesKey = aesKey
+	})
+	s.keyed = time.Now()
+	logger.Debug("session key rotated", "session", s.path)
+	return serverPubBytes, nil
 }
@@ -0,0 +1,96 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package service
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/akihiro/wsl-secret-service/internal/audit"
+	"github.com/akihiro/wsl-secret-service/internal/config"
+	"github.com/akihiro/wsl-secret-service/internal/sshagent"
+	"github.com/godbus/dbus/v5"
+)
+
+var _ sshagent.Source = (*Service)(nil)
+
+// SSHKeys implements sshagent.Source: the items with xdg:schema=ssh-key in
+// unlocked collections. Key IDs are "collection/uuid".
+func (svc *Service) SSHKeys() []sshagent.KeyRef {
+	svc.recordActivity()
+	var refs []sshagent.KeyRef
+	for _, ref := range svc.store.SearchItems(map[string]string{sshagent.SchemaAttribute: sshagent.Schema}) {
+		if svc.isLocked(ref.Collection) {
+			continue
+		}
+		meta, ok := svc.store.GetItem(ref.Collection, ref.UUID)
+		if !ok || expired(meta, time.Now()) {
+			continue
+		}
+		refs = append(refs, sshagent.KeyRef{ID: ref.Collection + "/" + ref.UUID, Comment: meta.Label})
+	}
+	return refs
+}
+
+// SSHPrivateKey implements sshagent.Source. Agent clients are not D-Bus
+// peers, so the access policy is evaluated for an unidentified executable:
+// only rules without an exe pattern, and the default, apply. Keys requiring
+// user verification are verified, and audited, when used to sign. The key
+// takes a plaintext slot (see Options.MaxPlaintextSecrets) while use runs.
+func (svc *Service) SSHPrivateKey(id string, sign bool, use func(key []byte) error) (err error) {
+	svc.recordActivity()
+	colName, itemUUID, _ := strings.Cut(id, "/")
+	path := ItemPath(colName, itemUUID)
+	if sign && svc.auditLog != nil {
+		defer func() {
+			e := audit.Entry{Op: audit.OpSSHSign, Object: string(path), Result: audit.ResultOK}
+			if err != nil {
+				e.Result = err.Error()
+				var derr *dbus.Error
+				if errors.As(err, &derr) {
+					e.Result = derr.Name
+				}
+			}
+			if err := svc.auditLog.Record(e); err != nil {
+				logger.Error("could not write audit log", "err", err)
+			}
+		}()
+	}
+
+	if svc.isLocked(colName) {
+		return errLocked(colName)
+	}
+	meta, ok := svc.store.GetItem(colName, itemUUID)
+	if !ok || expired(meta, time.Now()) {
+		return fmt.Errorf("item %s not found", path)
+	}
+	decision := config.Allow
+	if policy := svc.policy.Load(); policy != nil {
+		decision = policyDecision(policy, "", colName, meta.Attributes)
+	}
+	if decision == config.Deny {
+		return dbusError("org.freedesktop.Secret.Error.AccessDenied",
+			fmt.Sprintf("access policy denies the ssh-agent access to %s", path))
+	}
+	svc.touchCollection(colName)
+	if sign {
+		if derr := svc.verifyAccess(meta); derr != nil {
+			return derr
+		}
+		if decision == config.Confirm || meta.Attributes[ConfirmAttribute] == "true" {
+			msg := fmt.Sprintf("The ssh-agent in WSL wants to sign with the key %q.", meta.Label)
+			if derr, _ := svc.approve("ssh-agent", path, msg); derr != nil {
+				return derr
+			}
+		}
+	}
+	svc.plaintext.acquire()
+	key, err := svc.getSecret(svc.itemTarget(colName, itemUUID))
+	defer svc.plaintext.release(key)
+	if err != nil {
+		return err
+	}
+	return use(key)
+}
@@ -0,0 +1,46 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package service
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	"github.com/akihiro/wsl-secret-service/internal/sshagent"
+	"github.com/akihiro/wsl-secret-service/internal/store"
+	"github.com/akihiro/wsl-secret-service/pkg/backend/memory"
+)
+
+func TestSSHPrivateKeyTakesPlaintextSlot(t *testing.T) {
+	st, be := store.NewMemory(), memory.New()
+	if err := st.CreateItem("login", "key", store.ItemMeta{Label: "key", Attributes: map[string]string{sshagent.SchemaAttribute: sshagent.Schema}}); err != nil {
+		t.Fatal(err)
+	}
+	if err := be.Set(t.Context(), uuidTarget("login", "key"), []byte("private key")); err != nil {
+		t.Fatal(err)
+	}
+	svc := &Service{store: st, backend: be, stopped: t.Context(), plaintext: newPlaintextLimiter(1)}
+	svc.locks = lockState{locked: map[string]bool{}, accessed: map[string]time.Time{}}
+
+	var held []byte
+	err := svc.SSHPrivateKey("login/key", false, func(key []byte) error {
+		if !bytes.Equal(key, []byte("private key")) {
+			t.Errorf("key = %q", key)
+		}
+		if len(svc.plaintext) != 1 {
+			t.Errorf("plaintext slots in use = %d while the key is used, want 1", len(svc.plaintext))
+		}
+		held = key
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(svc.plaintext) != 0 {
+		t.Errorf("plaintext slots in use = %d afterwards, want 0", len(svc.plaintext))
+	}
+	if !bytes.Equal(held, make([]byte, len(held))) {
+		t.Errorf("key = %q afterwards, want it wiped", held)
+	}
+}
@@ -0,0 +1,118 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package service
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"strings"
+	"sync"
+	"unicode"
+
+	"github.com/akihiro/wsl-secret-service/internal/store"
+)
+
+// TargetPrefix prefixes every Credential Manager TargetName owned by this service.
+const TargetPrefix = "wsl-ss/"
+
+// Naming schemes for the backend targets of newly created items.
+const (
+	// TargetNamesUUID names targets wsl-ss/<collection>/<item-uuid> (default).
+	TargetNamesUUID = "uuid"
+	// TargetNamesLabel names targets wsl-ss/<collection label>/<item label>,
+	// adding " (2)", " (3)", ... on collision, so entries are recognisable in
+	// the Windows Credential Manager UI.
+	TargetNamesLabel = "label"
+)
+
+// uuidTarget returns the opaque TargetName used for items that have no
+// explicit target recorded in their metadata.
+func uuidTarget(colName, itemUUID string) string {
+	return fmt.Sprintf("%s%s/%s", TargetPrefix, colName, itemUUID)
+}
+
+// hashedTarget returns a short TargetName standing in for target, for
+// backends that cannot store target itself (see backend.ErrTargetTooLong).
+// The item records it as its target like a label-based one.
+func hashedTarget(target string) string {
+	sum := sha256.Sum256([]byte(target))
+	return TargetPrefix + "sha256/" + hex.EncodeToString(sum[:])
+}
+
+// itemTarget returns the backend target of an item, honouring the mapping
+// recorded in its metadata when the item was created with label naming.
+func (svc *Service) itemTarget(colName, itemUUID string) string {
+	if meta, ok := svc.store.GetItem(colName, itemUUID); ok && meta.Target != "" {
+		return meta.Target
+	}
+	return uuidTarget(colName, itemUUID)
+}
+
+// targetReservations tracks targets handed out to item creations that have
+// not been persisted yet, so concurrent creations with the same label
+// receive distinct suffixes and Fsck does not take their secrets for
+// orphans.
+type targetReservations struct {
+	mu      sync.Mutex
+	pending map[string]bool
+}
+
+// newItemTarget picks the target for a new item according to the configured
+// naming scheme. The target stays reserved until release is called.
+func (svc *Service) newItemTarget(colName, itemUUID string, meta store.ItemMeta) (target string, release func()) {
+	if svc.targetNames != TargetNamesLabel {
+		return svc.targetReservations.reserve(uuidTarget(colName, itemUUID))
+	}
+	col, _ := svc.store.GetCollection(colName)
+	base := TargetPrefix + targetComponent(col.Label, colName) + "/" + targetComponent(meta.Label, itemUUID)
+
+	r := &svc.targetReservations
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	target = base
+	for n := 2; r.pending[target] || svc.store.TargetInUse(target); n++ {
+		target = fmt.Sprintf("%s (%d)", base, n)
+	}
+	return target, r.add(target)
+}
+
+// reserve reserves target until release is called.
+func (r *targetReservations) reserve(target string) (_ string, release func()) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return target, r.add(target)
+}
+
+// add reserves target and returns its release; r.mu must be held.
+func (r *targetReservations) add(target string) (release func()) {
+	if r.pending == nil {
+		r.pending = make(map[string]bool)
+	}
+	r.pending[target] = true
+	return func() {
+		r.mu.Lock()
+		delete(r.pending, target)
+		r.mu.Unlock()
+	}
+}
+
+// targetComponent turns a label into a single TargetName path component:
+// control characters are dropped and slashes replaced so the component
+// cannot be confused with the separators. Falls back to fallback if nothing
+// printable remains.
+func targetComponent(label, fallback string) string {
+	s := strings.Map(func(r rune) rune {
+		switch {
+		case r == '/' || r == '\\':
+			return '-'
+		case unicode.IsControl(r):
+			return -1
+		}
+		return r
+	}, strings.TrimSpace(label))
+	if s == "" {
+		return fallback
+	}
+	return s
+}
@@ -0,0 +1,66 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package service
+
+import (
+	"errors"
+	"maps"
+	"slices"
+
+	"github.com/akihiro/wsl-secret-service/internal/backend"
+)
+
+// deleteItem deletes an item with its secret. The metadata goes first,
+// together with a tombstone for target, then the secret, then the
+// tombstone: a crash in between leaves a tombstone, not an item without a
+// secret or a secret without an item, and completeDeletes finishes the
+// delete at the next start. A secret the backend fails to delete keeps its
+// tombstone, too.
+func (svc *Service) deleteItem(colName, itemUUID, target string) error {
+	meta, _ := svc.store.GetItem(colName, itemUUID)
+	if err := svc.store.TombstoneItem(colName, itemUUID, target); err != nil {
+		return err
+	}
+	svc.itemRemoved(colName, itemUUID, meta)
+	svc.clearTombstones([]string{target}, []error{svc.deleteSecret(target)})
+	return nil
+}
+
+// clearTombstones removes the tombstones of the targets whose secrets are
+// gone; errs[i] is the result of deleting the secret of targets[i]. A
+// target the backend does not have is gone as well.
+func (svc *Service) clearTombstones(targets []string, errs []error) {
+	var gone []string
+	for i, target := range targets {
+		var notFound *backend.ErrNotFound
+		if errs[i] != nil && !errors.As(errs[i], &notFound) {
+			logger.Warn("could not delete the secret of a deleted item, will retry at the next start", "target", target, "err", errs[i])
+			continue
+		}
+		gone = append(gone, target)
+	}
+	if err := svc.store.ClearTombstones(gone); err != nil {
+		logger.Warn("could not clear tombstones", "err", err)
+	}
+}
+
+// completeDeletes deletes the secrets of the tombstones left by deletes
+// that were interrupted, and clears the tombstones. A target an item uses
+// again is only cleared: its secret now belongs to that item.
+func (svc *Service) completeDeletes() {
+	tombstones := svc.store.Tombstones()
+	if len(tombstones) == 0 {
+		return
+	}
+	var targets, reused []string
+	for _, target := range slices.Sorted(maps.Keys(tombstones)) {
+		if svc.store.TargetInUse(target) {
+			reused = append(reused, target)
+		} else {
+			targets = append(targets, target)
+		}
+	}
+	logger.Info("completing interrupted deletes", "secrets", len(targets))
+	svc.clearTombstones(reused, make([]error, len(reused)))
+	svc.clearTombstones(targets, svc.deleteSecrets(targets))
+}
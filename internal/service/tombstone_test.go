@@ -0,0 +1,82 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package service
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/akihiro/wsl-secret-service/internal/backend"
+	"github.com/akihiro/wsl-secret-service/internal/store"
+	"github.com/akihiro/wsl-secret-service/pkg/backend/memory"
+)
+
+// unavailableBackend cannot delete anything.
+type unavailableBackend struct {
+	*memory.Backend
+}
+
+func (unavailableBackend) Delete(context.Context, string) error {
+	return &backend.ErrUnavailable{Err: errInjected}
+}
+
+func TestCompleteDeletes(t *testing.T) {
+	const id = "6c1d3e0a-2f4b-4c8e-9a17-5b2e8d9f0c31"
+	target := uuidTarget("login", id)
+	st := store.NewMemory()
+	if err := st.CreateItem("login", id, store.ItemMeta{Label: "x"}); err != nil {
+		t.Fatal(err)
+	}
+	// A delete interrupted after the metadata was removed.
+	if err := st.TombstoneItem("login", id, target); err != nil {
+		t.Fatal(err)
+	}
+	be := memory.New()
+	if err := be.Set(t.Context(), target, []byte("secret")); err != nil {
+		t.Fatal(err)
+	}
+
+	svc := &Service{store: st, backend: unavailableBackend{be}, stopped: t.Context()}
+	svc.completeDeletes()
+	if _, ok := st.Tombstones()[target]; !ok {
+		t.Fatalf("tombstone of %s cleared although the backend failed to delete it", target)
+	}
+
+	svc = &Service{store: st, backend: be, stopped: t.Context()}
+	svc.completeDeletes()
+	var notFound *backend.ErrNotFound
+	if _, err := be.Get(t.Context(), target); !errors.As(err, &notFound) {
+		t.Errorf("secret of the deleted item: err = %v, want not found", err)
+	}
+	if ts := st.Tombstones(); len(ts) != 0 {
+		t.Errorf("tombstones left: %v", ts)
+	}
+}
+
+func TestCompleteDeletesSparesReusedTargets(t *testing.T) {
+	const target = "wsl-ss/login/by-label"
+	st := store.NewMemory()
+	if err := st.CreateItem("login", "old", store.ItemMeta{Label: "x", Target: target}); err != nil {
+		t.Fatal(err)
+	}
+	if err := st.TombstoneItem("login", "old", target); err != nil {
+		t.Fatal(err)
+	}
+	if err := st.CreateItem("login", "new", store.ItemMeta{Label: "x", Target: target}); err != nil {
+		t.Fatal(err)
+	}
+	be := memory.New()
+	if err := be.Set(t.Context(), target, []byte("new secret")); err != nil {
+		t.Fatal(err)
+	}
+
+	svc := &Service{store: st, backend: be, stopped: t.Context()}
+	svc.completeDeletes()
+	if got, err := be.Get(t.Context(), target); err != nil || string(got) != "new secret" {
+		t.Errorf("secret of the new item = %q, %v, want it kept", got, err)
+	}
+	if ts := st.Tombstones(); len(ts) != 0 {
+		t.Errorf("tombstones left: %v", ts)
+	}
+}
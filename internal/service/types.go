@@ -18,9 +18,25 @@ const (
 	SessionIface    = "org.freedesktop.Secret.Session"
 	PromptIface     = "org.freedesktop.Secret.Prompt"
 
+	// ServiceExtIface and ItemExtIface carry non-spec properties specific to
+	// this service on the root object and on items respectively, and
+	// ServiceExtIface the extended searches; SessionExtIface adds key
+	// rotation to sessions.
+	ServiceExtIface = "org.akihiro.WslSecretService.Service"
+	ItemExtIface    = "org.akihiro.WslSecretService.Item"
+	SessionExtIface = "org.akihiro.WslSecretService.Session"
+
+	// AdminIface is the non-spec management interface on the root object.
+	AdminIface = "org.akihiro.WslSecretService.Admin"
+
+	// CollectionNameProperty, passed to CreateCollection, names the new
+	// collection instead of a name derived from its label.
+	CollectionNameProperty = "org.akihiro.WslSecretService.Collection.Name"
+
 	CollectionPathPrefix = "/org/freedesktop/secrets/collection/"
 	SessionPathPrefix    = "/org/freedesktop/secrets/session/"
 	PromptPathPrefix     = "/org/freedesktop/secrets/prompt/"
+	AliasPathPrefix      = "/org/freedesktop/secrets/aliases/"
 
 	DefaultAlias    = "default"
 	LoginCollection = "login"
@@ -32,6 +48,19 @@ const (
 	PromptStubObjPath = dbus.ObjectPath("/org/freedesktop/secrets/prompt/stub")
 )
 
+// Capability names reported by the Capabilities extension property, letting
+// clients adapt to optional features without probing methods.
+const (
+	CapSecretChecksum   = "secret-checksum"
+	CapMemoryProtection = "memory-protection-status"
+	CapLocking          = "locking"
+	CapUserVerification = "user-verification"
+	CapUserApproval     = "user-approval"
+	CapCreator          = "creator"
+	CapPassword         = "collection-password"
+	CapSearchEx         = "search-ex"
+)
+
 // Secret is the D-Bus type (oayays) representing an encoded secret.
 type Secret struct {
 	Session     dbus.ObjectPath
@@ -0,0 +1,100 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package service
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/godbus/dbus/v5"
+)
+
+// FuzzItemUUIDFromPath checks the object path parsing of the Item and
+// Collection methods on arbitrary paths: whatever collection and UUID they
+// find must be the ones the path was made of.
+func FuzzItemUUIDFromPath(f *testing.F) {
+	f.Add(string(ItemPath("login", "0f8e9c2a-1b2c-4d5e-8f90-123456789abc")))
+	f.Add(string(CollectionPath("login")))
+	f.Add(CollectionPathPrefix)
+	f.Add(CollectionPathPrefix + "/")
+	f.Add(CollectionPathPrefix + "login/")
+	f.Add(CollectionPathPrefix + "a/b/c")
+	f.Add("/org/freedesktop/secrets/aliases/default")
+	f.Fuzz(func(t *testing.T, path string) {
+		collection, uuid := ItemUUIDFromPath(dbus.ObjectPath(path))
+		name := CollectionNameFromPath(dbus.ObjectPath(path))
+		if !strings.HasPrefix(path, CollectionPathPrefix) {
+			// The prefix is not checked, only skipped; callers look the
+			// result up, so all that matters is not to panic.
+			return
+		}
+		rest := path[len(CollectionPathPrefix):]
+		wantName, wantUUID, isItem := strings.Cut(rest, "/")
+		if name != wantName {
+			t.Fatalf("CollectionNameFromPath(%q) = %q, want %q", path, name, wantName)
+		}
+		if !isItem {
+			wantName = ""
+		}
+		wantUUID = strings.ReplaceAll(wantUUID, "_", "-")
+		if collection != wantName || uuid != wantUUID {
+			t.Fatalf("ItemUUIDFromPath(%q) = %q, %q, want %q, %q", path, collection, uuid, wantName, wantUUID)
+		}
+	})
+}
+
+// FuzzCreateItemArgs checks the parsing of CreateItem arguments, whose
+// properties variants and (oayays) Secret struct come straight from the
+// client: the values the bus connection decodes, of the expected types or
+// not, are stored into the method's argument types the way godbus does
+// before the call, and the properties are parsed and the secret decrypted
+// as the service does, which must fail cleanly on anything malformed.
+//
+// Raw messages are not fuzzed: DecodeMessage allocates whatever lengths
+// they claim, which the bus daemon validating every message keeps clients
+// from exploiting, but which would exhaust the fuzzer's memory.
+func FuzzCreateItemArgs(f *testing.F) {
+	f.Add("label", "service", "s", uint8(0), "/org/freedesktop/secrets/session/s", bytes.Repeat([]byte{1}, 16), bytes.Repeat([]byte{2}, 32), "text/plain")
+	f.Add("", "", "", uint8(1), "/", []byte{}, []byte("plain"), "")
+	f.Add("x", "k", "v", uint8(2), "", []byte{1}, []byte{3}, "x")
+	f.Add("x", "k", "v", uint8(3), "/s", bytes.Repeat([]byte{1}, 16), []byte{}, "x")
+	key := bytes.Repeat([]byte{0x42}, 16)
+	f.Fuzz(func(t *testing.T, label, attrKey, attrValue string, shape uint8, session string, params, value []byte, contentType string) {
+		// shape picks how the client got the types right or wrong.
+		var labelV, attrsV any = label, map[string]string{attrKey: attrValue}
+		var secretV any = []any{dbus.ObjectPath(session), params, value, contentType}
+		switch shape % 4 {
+		case 1:
+			labelV, attrsV = []byte(label), map[string]dbus.Variant{attrKey: dbus.MakeVariant(attrValue)}
+		case 2:
+			attrsV = []any{attrKey, attrValue}
+			secretV = []any{session, params, value}
+		case 3:
+			secretV = []any{dbus.ObjectPath(session), string(params), value, []byte(contentType)}
+		}
+		properties := map[string]dbus.Variant{
+			CollectionIface + ".Label": dbus.MakeVariant(labelV),
+			ItemIface + ".Attributes":  dbus.MakeVariant(attrsV),
+		}
+		var (
+			props   map[string]dbus.Variant
+			secret  Secret
+			replace bool
+		)
+		if err := dbus.Store([]any{properties, secretV, shape > 127}, &props, &secret, &replace); err != nil {
+			return
+		}
+		if meta := itemMetaFromProperties(props); meta.Attributes == nil {
+			t.Fatal("itemMetaFromProperties returned nil attributes")
+		}
+		s := &Session{aesKey: key}
+		plaintext, err := s.decryptSecret(secret.Parameters, secret.Value)
+		if err != nil {
+			return
+		}
+		if len(secret.Value)%16 != 0 || len(plaintext) >= len(secret.Value) {
+			t.Fatalf("decrypted %d bytes of ciphertext to %d bytes", len(secret.Value), len(plaintext))
+		}
+	})
+}
@@ -0,0 +1,46 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package service
+
+import (
+	"fmt"
+
+	"github.com/akihiro/wsl-secret-service/internal/backend"
+	"github.com/akihiro/wsl-secret-service/internal/store"
+	"github.com/godbus/dbus/v5"
+)
+
+// VerificationAttribute is the item attribute that, when set to "true",
+// makes the daemon ask the user to confirm with Windows Hello before the
+// item's secret is returned.
+const VerificationAttribute = "wsl:require-verification"
+
+// verifyAccess asks the user to approve the release of the secret described
+// by meta if the item is flagged with VerificationAttribute. Unflagged items
+// pass without interaction.
+func (svc *Service) verifyAccess(meta store.ItemMeta) *dbus.Error {
+	if meta.Attributes[VerificationAttribute] != "true" {
+		return nil
+	}
+	v, ok := backend.As[backend.Verifier](svc.backend)
+	if !ok {
+		return dbusError("org.freedesktop.DBus.Error.AccessDenied",
+			"item requires verification but the backend cannot verify the user")
+	}
+	msg := fmt.Sprintf("A WSL application wants to read the secret %q.", meta.Label)
+	if err := v.Verify(svc.stopped, msg); err != nil {
+		return dbusError("org.freedesktop.DBus.Error.AccessDenied", err.Error())
+	}
+	return nil
+}
+
+// verifyFlagChange asks the user to verify, as verifyAccess does, before
+// attrs replace the attributes of the item described by old without its
+// VerificationAttribute. Otherwise a client could clear the flag and read
+// the secret unverified.
+func (svc *Service) verifyFlagChange(old store.ItemMeta, attrs map[string]string) *dbus.Error {
+	if old.Attributes[VerificationAttribute] != "true" || attrs[VerificationAttribute] == "true" {
+		return nil
+	}
+	return svc.verifyAccess(old)
+}
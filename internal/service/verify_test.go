@@ -0,0 +1,57 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package service
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/akihiro/wsl-secret-service/internal/backend"
+	"github.com/akihiro/wsl-secret-service/internal/store"
+	"github.com/akihiro/wsl-secret-service/pkg/backend/memory"
+)
+
+// verifyingBackend answers Verify with err and counts the calls.
+type verifyingBackend struct {
+	backend.Backend
+	err   error
+	calls int
+}
+
+func (b *verifyingBackend) Verify(context.Context, string) error {
+	b.calls++
+	return b.err
+}
+
+func TestClearingVerificationNeedsVerification(t *testing.T) {
+	const id = "5c0e2a7d-93b1-4f6e-8d2a-1b7c4e9f0a36"
+	st := store.NewMemory()
+	flagged := map[string]string{"service": "x", VerificationAttribute: "true"}
+	if err := st.CreateItem("login", id, store.ItemMeta{Label: "flagged", Attributes: flagged}); err != nil {
+		t.Fatal(err)
+	}
+	be := &verifyingBackend{Backend: memory.New(), err: errors.New("declined")}
+	svc := &Service{store: st, backend: be, stopped: t.Context()}
+	item := &Item{collectionName: "login", uuid: id, svc: svc}
+
+	for _, attrs := range []map[string]string{
+		{"service": "x"},
+		{"service": "x", VerificationAttribute: "false"},
+	} {
+		if derr := svc.checkFlagChange(":1.7", item, attrs); derr == nil {
+			t.Errorf("attributes %v accepted without verification", attrs)
+		}
+	}
+	if be.calls != 2 {
+		t.Errorf("Verify called %d times, want 2", be.calls)
+	}
+	if derr := svc.checkFlagChange(":1.7", item, map[string]string{"service": "y", VerificationAttribute: "true"}); derr != nil {
+		t.Errorf("keeping the flag: %v", derr)
+	}
+
+	be.err = nil
+	if derr := svc.checkFlagChange(":1.7", item, map[string]string{"service": "x"}); derr != nil {
+		t.Errorf("clearing the flag after verification: %v", derr)
+	}
+}
@@ -0,0 +1,98 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package service
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/akihiro/wsl-secret-service/internal/backend"
+	"github.com/akihiro/wsl-secret-service/internal/config"
+)
+
+// watchRetryDelay is how long startBackendWatch waits before watching
+// again after the backend's Watch failed.
+const watchRetryDelay = 30 * time.Second
+
+// startBackendWatch watches the backend for changes made by other programs,
+// such as the Credential Manager UI on Windows, and announces changed items
+// with ItemChanged (see backendChanged). Backends that cannot watch are left
+// alone.
+func (svc *Service) startBackendWatch(ctx context.Context) {
+	w, ok := backend.As[backend.Watcher](svc.backend)
+	if !ok {
+		logger.Warn("the backend cannot report changes; not watching it")
+		return
+	}
+	go func() {
+		for {
+			err := w.Watch(ctx, TargetPrefix, svc.backendChanged)
+			if ctx.Err() != nil {
+				return
+			}
+			if errors.Is(err, errors.ErrUnsupported) {
+				logger.Warn("the backend cannot report changes; not watching it")
+				return
+			}
+			logger.Warn("watching the backend failed", "err", err, "retry_in", watchRetryDelay)
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(watchRetryDelay):
+			}
+		}
+	}()
+}
+
+// backendChanged refreshes the item stored under the changed target, if
+// any: its cached secret is dropped and its checksum recomputed from the
+// backend, and ItemChanged is emitted unless the secret is the one the
+// daemon stored itself.
+func (svc *Service) backendChanged(ch backend.Change) {
+	svc.secrets.Remove(ch.Target)
+	for _, colName := range svc.store.ListCollections() {
+		for _, id := range svc.store.ListItems(colName) {
+			if svc.itemTarget(colName, id) == ch.Target {
+				svc.refreshItem(colName, id, ch)
+			}
+		}
+	}
+}
+
+// refreshItem updates item id of collection colName after ch.
+func (svc *Service) refreshItem(colName, id string, ch backend.Change) {
+	meta, ok := svc.store.GetItem(colName, id)
+	if !ok {
+		return
+	}
+	checksum := ""
+	if !ch.Deleted {
+		svc.plaintext.acquire()
+		secret, err := svc.getSecret(ch.Target)
+		if err != nil {
+			svc.plaintext.release()
+			logger.Warn("could not read changed secret", "target", ch.Target, "err", err)
+			return
+		}
+		checksum = svc.checksum(secret)
+		svc.plaintext.release(secret)
+		// A client may have changed the item during the backend read.
+		if meta, ok = svc.store.GetItem(colName, id); !ok {
+			return
+		}
+		if checksum != "" && checksum == meta.Checksum {
+			return
+		}
+	}
+	meta.Checksum = checksum
+	if err := svc.store.UpdateItem(colName, id, meta); err != nil {
+		logger.Warn("could not update changed item", "collection", colName, "item", id, "err", err)
+	}
+	if item, ok := svc.exportedItem(colName, id); ok && item.props != nil {
+		item.props.SetMust(ItemExtIface, "SecretChecksum", checksum)
+	}
+	logger.Info("item changed outside the daemon", "collection", colName, "item", id, "deleted", ch.Deleted)
+	svc.notifyItemChanged(colName, ItemPath(colName, id))
+	svc.itemEvent(config.HookChanged, colName, id, meta)
+}
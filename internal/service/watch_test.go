@@ -0,0 +1,58 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package service
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/akihiro/wsl-secret-service/internal/backend"
+	"github.com/akihiro/wsl-secret-service/internal/store"
+	"github.com/akihiro/wsl-secret-service/pkg/backend/memory"
+)
+
+func TestBackendChangedWhileItemsChange(t *testing.T) {
+	addr := privateBus(t)
+	conn := dialBus(t, addr)
+	st, be := store.NewMemory(), memory.New()
+	if err := st.CreateItem("login", "watched", store.ItemMeta{Label: "watched"}); err != nil {
+		t.Fatal(err)
+	}
+	target := uuidTarget("login", "watched")
+	if err := be.Set(t.Context(), target, []byte("old")); err != nil {
+		t.Fatal(err)
+	}
+	svc, err := New(t.Context(), conn, st, be, Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := range 100 {
+			id := fmt.Sprintf("item%d", i)
+			if err := svc.exportItem(&Item{collectionName: "login", uuid: id, svc: svc}); err != nil {
+				t.Error(err)
+				return
+			}
+			svc.itemRemoved("login", id, store.ItemMeta{})
+		}
+	}()
+	for i := range 100 {
+		if err := be.Set(t.Context(), target, fmt.Appendf(nil, "secret %d", i)); err != nil {
+			t.Fatal(err)
+		}
+		svc.backendChanged(backend.Change{Target: target})
+	}
+	<-done
+
+	want := svc.checksum([]byte("secret 99"))
+	if meta, _ := st.GetItem("login", "watched"); meta.Checksum != want {
+		t.Errorf("stored checksum = %q, want %q", meta.Checksum, want)
+	}
+	item, _ := svc.exportedItem("login", "watched")
+	if v, _ := item.props.Get(ItemExtIface, "SecretChecksum"); v.Value() != want {
+		t.Errorf("SecretChecksum = %v, want %q", v.Value(), want)
+	}
+}
@@ -0,0 +1,80 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package service
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os/exec"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/akihiro/wsl-secret-service/internal/backend"
+)
+
+// windowTitleTimeout bounds the xprop(1) call looking up a window title.
+const windowTitleTimeout = 2 * time.Second
+
+// promptContext returns the context for the dialogs of a prompt called
+// with windowID: svc.stopped, carrying the title of the client's window
+// (backend.WithWindow) if it can be found, so that the Windows dialog is
+// shown in front of that window under WSLg.
+func (svc *Service) promptContext(windowID string) context.Context {
+	title := windowTitle(windowID)
+	if title == "" {
+		return svc.stopped
+	}
+	return backend.WithWindow(svc.stopped, title)
+}
+
+// parseWindowID returns the X11 window in the window-id argument of
+// Prompt.Prompt: a number in decimal or 0x-prefixed hex as libsecret
+// clients pass it, or "x11:<hex>" as in the XDG desktop portal. Anything
+// else, such as "" or a Wayland handle, yields 0.
+func parseWindowID(windowID string) uint64 {
+	s := windowID
+	base := 0
+	if rest, ok := strings.CutPrefix(s, "x11:"); ok {
+		s, base = strings.TrimPrefix(rest, "0x"), 16
+	}
+	id, err := strconv.ParseUint(s, base, 32)
+	if err != nil {
+		return 0
+	}
+	return id
+}
+
+// windowTitle returns the title of the X11 window windowID, as xprop(1)
+// reports it, or "" if it cannot be found (no X server, no xprop, no such
+// window).
+func windowTitle(windowID string) string {
+	id := parseWindowID(windowID)
+	if id == 0 {
+		return ""
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), windowTitleTimeout)
+	defer cancel()
+	out, err := exec.CommandContext(ctx, "xprop", "-id", fmt.Sprintf("0x%x", id), "_NET_WM_NAME", "WM_NAME").Output()
+	if err != nil {
+		logger.Debug("could not look up the prompt window", "window_id", windowID, "err", err)
+		return ""
+	}
+	return parseXpropTitle(out)
+}
+
+// parseXpropTitle returns the first quoted property value in the output
+// of xprop(1), such as `_NET_WM_NAME(UTF8_STRING) = "Title"`.
+func parseXpropTitle(out []byte) string {
+	for line := range bytes.Lines(out) {
+		_, value, ok := strings.Cut(string(line), " = ")
+		if !ok {
+			continue
+		}
+		if title, err := strconv.Unquote(strings.TrimSpace(value)); err == nil {
+			return title
+		}
+	}
+	return ""
+}
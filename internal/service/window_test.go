@@ -0,0 +1,33 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package service
+
+import "testing"
+
+func TestParseWindowID(t *testing.T) {
+	for _, tc := range []struct {
+		id   string
+		want uint64
+	}{
+		{"", 0},
+		{"37748743", 37748743},
+		{"0x2400007", 0x2400007},
+		{"x11:2400007", 0x2400007},
+		{"x11:0x2400007", 0x2400007},
+		{"wayland:abc", 0},
+	} {
+		if got := parseWindowID(tc.id); got != tc.want {
+			t.Errorf("parseWindowID(%q) = %#x, want %#x", tc.id, got, tc.want)
+		}
+	}
+}
+
+func TestParseXpropTitle(t *testing.T) {
+	out := []byte("_NET_WM_NAME(UTF8_STRING) = \"Seahorse \\\"work\\\"\"\nWM_NAME(STRING) = \"Seahorse\"\n")
+	if got := parseXpropTitle(out); got != `Seahorse "work"` {
+		t.Errorf("parseXpropTitle = %q", got)
+	}
+	if got := parseXpropTitle([]byte("_NET_WM_NAME:  not found.\n")); got != "" {
+		t.Errorf("parseXpropTitle of a missing property = %q, want \"\"", got)
+	}
+}
@@ -0,0 +1,206 @@
+// SPDX-License-Identifier: Apache-2.0
+
+// Package sshagent implements an ssh-agent that serves private keys stored
+// as Secret Service items. Keys are fetched from the Source and parsed for
+// every request and never cached or written to disk; the agent keeps no
+// key material between requests.
+package sshagent
+
+import (
+	"bytes"
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+
+	"github.com/akihiro/wsl-secret-service/internal/logging"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/agent"
+)
+
+var logger = logging.For(logging.SSHAgent)
+
+// Items whose SchemaAttribute equals Schema hold an SSH private key in
+// OpenSSH or PEM format. Passphrase-protected keys are not supported.
+const (
+	SchemaAttribute = "xdg:schema"
+	Schema          = "ssh-key"
+)
+
+// KeyRef identifies a key item.
+type KeyRef struct {
+	ID      string // opaque to the agent, passed back to SSHPrivateKey
+	Comment string // shown by ssh-add -l, normally the item label
+}
+
+// Source supplies the keys served by the agent.
+type Source interface {
+	// SSHKeys lists the key items currently available (e.g. in unlocked
+	// collections).
+	SSHKeys() []KeyRef
+	// SSHPrivateKey calls use with the private key of the item id and
+	// returns its error. sign is true when the key is about to be used for
+	// a signature rather than only to derive its public key, so the source
+	// may ask the user to confirm. The source wipes the key with
+	// memprotect.Wipe once use returns, so use must not retain it.
+	SSHPrivateKey(id string, sign bool, use func(key []byte) error) error
+}
+
+// errReadOnly is returned for requests that would modify the key set:
+// keys are managed as Secret Service items instead.
+var errReadOnly = errors.New("keys are managed as Secret Service items (xdg:schema=ssh-key)")
+
+// Agent is an agent.ExtendedAgent serving the keys of a Source.
+type Agent struct {
+	src Source
+}
+
+var _ agent.ExtendedAgent = (*Agent)(nil)
+
+// New returns an agent serving the keys of src.
+func New(src Source) *Agent {
+	return &Agent{src: src}
+}
+
+// Listen creates a Unix socket at path for the agent, accessible only to
+// the current user. A stale socket left by a previous run is replaced.
+func Listen(path string) (net.Listener, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0o700); err != nil {
+		return nil, err
+	}
+	if fi, err := os.Lstat(path); err == nil && fi.Mode().Type() == os.ModeSocket {
+		if c, err := net.Dial("unix", path); err == nil {
+			c.Close()
+			return nil, fmt.Errorf("%s is in use by another agent", path)
+		}
+		_ = os.Remove(path)
+	}
+	l, err := net.Listen("unix", path)
+	if err != nil {
+		return nil, err
+	}
+	if err := os.Chmod(path, 0o600); err != nil {
+		l.Close()
+		return nil, err
+	}
+	return l, nil
+}
+
+// Serve answers agent requests on connections accepted from l until l is
+// closed.
+func (a *Agent) Serve(l net.Listener) error {
+	for {
+		conn, err := l.Accept()
+		if errors.Is(err, net.ErrClosed) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		go func() {
+			defer conn.Close()
+			_ = agent.ServeAgent(a, conn)
+		}()
+	}
+}
+
+// signer parses the private key of ref. The parsed key does not refer to
+// the raw key bytes, which the source wipes afterwards.
+func (a *Agent) signer(ref KeyRef, sign bool) (s ssh.Signer, err error) {
+	err = a.src.SSHPrivateKey(ref.ID, sign, func(raw []byte) error {
+		s, err = ssh.ParsePrivateKey(raw)
+		return err
+	})
+	return s, err
+}
+
+// List implements agent.Agent. Keys that cannot be read or parsed are
+// skipped.
+func (a *Agent) List() ([]*agent.Key, error) {
+	var keys []*agent.Key
+	for _, ref := range a.src.SSHKeys() {
+		s, err := a.signer(ref, false)
+		if err != nil {
+			logger.Warn("skipping unusable ssh key", "item", ref.Comment, "err", err)
+			continue
+		}
+		pub := s.PublicKey()
+		keys = append(keys, &agent.Key{Format: pub.Type(), Blob: pub.Marshal(), Comment: ref.Comment})
+	}
+	return keys, nil
+}
+
+// Sign implements agent.Agent.
+func (a *Agent) Sign(key ssh.PublicKey, data []byte) (*ssh.Signature, error) {
+	return a.SignWithFlags(key, data, 0)
+}
+
+// SignWithFlags implements agent.ExtendedAgent. Each candidate key is
+// parsed to compare public keys, so the source's confirmation (sign=true)
+// applies only to the key actually used.
+func (a *Agent) SignWithFlags(key ssh.PublicKey, data []byte, flags agent.SignatureFlags) (*ssh.Signature, error) {
+	wanted := key.Marshal()
+	for _, ref := range a.src.SSHKeys() {
+		s, err := a.signer(ref, false)
+		if err != nil || !bytes.Equal(s.PublicKey().Marshal(), wanted) {
+			continue
+		}
+		if s, err = a.signer(ref, true); err != nil {
+			return nil, err
+		}
+		logger.Info("ssh signature", "item", ref.Comment, "key", ssh.FingerprintSHA256(key))
+		return sign(s, data, flags)
+	}
+	return nil, errors.New("key not found")
+}
+
+func sign(s ssh.Signer, data []byte, flags agent.SignatureFlags) (*ssh.Signature, error) {
+	if flags == 0 {
+		return s.Sign(rand.Reader, data)
+	}
+	as, ok := s.(ssh.AlgorithmSigner)
+	if !ok {
+		return nil, fmt.Errorf("key type %s does not support signature flags", s.PublicKey().Type())
+	}
+	switch flags {
+	case agent.SignatureFlagRsaSha256:
+		return as.SignWithAlgorithm(rand.Reader, data, ssh.KeyAlgoRSASHA256)
+	case agent.SignatureFlagRsaSha512:
+		return as.SignWithAlgorithm(rand.Reader, data, ssh.KeyAlgoRSASHA512)
+	}
+	return nil, fmt.Errorf("unsupported signature flags %d", flags)
+}
+
+// Signers implements agent.Agent. It is not used by the protocol server.
+func (a *Agent) Signers() ([]ssh.Signer, error) {
+	var signers []ssh.Signer
+	for _, ref := range a.src.SSHKeys() {
+		if s, err := a.signer(ref, true); err == nil {
+			signers = append(signers, s)
+		}
+	}
+	return signers, nil
+}
+
+// Add implements agent.Agent; adding keys is not supported.
+func (a *Agent) Add(agent.AddedKey) error { return errReadOnly }
+
+// Remove implements agent.Agent; removing keys is not supported.
+func (a *Agent) Remove(ssh.PublicKey) error { return errReadOnly }
+
+// RemoveAll implements agent.Agent; removing keys is not supported.
+func (a *Agent) RemoveAll() error { return errReadOnly }
+
+// Lock implements agent.Agent. Lock the collections holding the keys
+// instead.
+func (a *Agent) Lock([]byte) error { return errReadOnly }
+
+// Unlock implements agent.Agent; see Lock.
+func (a *Agent) Unlock([]byte) error { return errReadOnly }
+
+// Extension implements agent.ExtendedAgent; no extensions are supported.
+func (a *Agent) Extension(string, []byte) ([]byte, error) {
+	return nil, agent.ErrExtensionUnsupported
+}
@@ -0,0 +1,174 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package sshagent
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/pem"
+	"errors"
+	"net"
+	"path/filepath"
+	"testing"
+
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/agent"
+)
+
+// fakeSource serves keys from memory and records which were fetched for
+// signing.
+type fakeSource struct {
+	keys   map[string][]byte
+	labels map[string]string
+	signed []string
+}
+
+func (s *fakeSource) SSHKeys() []KeyRef {
+	var refs []KeyRef
+	for _, id := range []string{"login/a", "login/b", "login/broken"} {
+		if _, ok := s.keys[id]; ok {
+			refs = append(refs, KeyRef{ID: id, Comment: s.labels[id]})
+		}
+	}
+	return refs
+}
+
+func (s *fakeSource) SSHPrivateKey(id string, sign bool, use func([]byte) error) error {
+	k, ok := s.keys[id]
+	if !ok {
+		return errors.New("not found")
+	}
+	if sign {
+		s.signed = append(s.signed, id)
+	}
+	raw := append([]byte(nil), k...)
+	defer clear(raw)
+	return use(raw)
+}
+
+func marshalKey(t *testing.T, key any) []byte {
+	t.Helper()
+	block, err := ssh.MarshalPrivateKey(key, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return pem.EncodeToMemory(block)
+}
+
+// newClient serves a over an in-memory connection.
+func newClient(t *testing.T, a *Agent) agent.ExtendedAgent {
+	t.Helper()
+	c1, c2 := net.Pipe()
+	t.Cleanup(func() { c1.Close(); c2.Close() })
+	go agent.ServeAgent(a, c2)
+	return agent.NewClient(c1)
+}
+
+func TestListAndSign(t *testing.T) {
+	_, edKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	src := &fakeSource{
+		keys: map[string][]byte{
+			"login/a":      marshalKey(t, edKey),
+			"login/b":      marshalKey(t, rsaKey),
+			"login/broken": []byte("not a key"),
+		},
+		labels: map[string]string{"login/a": "ed25519 key", "login/b": "rsa key", "login/broken": "broken"},
+	}
+	client := newClient(t, New(src))
+
+	keys, err := client.List()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(keys) != 2 || keys[0].Comment != "ed25519 key" || keys[1].Comment != "rsa key" {
+		t.Fatalf("List() = %v, want the ed25519 and rsa keys", keys)
+	}
+	if len(src.signed) != 0 {
+		t.Errorf("List fetched keys for signing: %v", src.signed)
+	}
+
+	data := []byte("session data")
+	sig, err := client.Sign(keys[0], data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := keys[0].Verify(data, sig); err != nil {
+		t.Errorf("ed25519 signature does not verify: %v", err)
+	}
+	if len(src.signed) != 1 || src.signed[0] != "login/a" {
+		t.Errorf("keys fetched for signing = %v, want [login/a]", src.signed)
+	}
+
+	sig, err = client.SignWithFlags(keys[1], data, agent.SignatureFlagRsaSha256)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sig.Format != ssh.KeyAlgoRSASHA256 {
+		t.Errorf("signature format = %s, want %s", sig.Format, ssh.KeyAlgoRSASHA256)
+	}
+	if err := keys[1].Verify(data, sig); err != nil {
+		t.Errorf("rsa-sha2-256 signature does not verify: %v", err)
+	}
+}
+
+func TestSignUnknownKey(t *testing.T) {
+	_, edKey, _ := ed25519.GenerateKey(rand.Reader)
+	other, _, _ := ed25519.GenerateKey(rand.Reader)
+	src := &fakeSource{keys: map[string][]byte{"login/a": marshalKey(t, edKey)}}
+	client := newClient(t, New(src))
+
+	pub, err := ssh.NewPublicKey(other)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := client.Sign(pub, []byte("x")); err == nil {
+		t.Error("Sign with an unknown key succeeded")
+	}
+	if len(src.signed) != 0 {
+		t.Errorf("keys fetched for signing = %v, want none", src.signed)
+	}
+}
+
+func TestReadOnly(t *testing.T) {
+	_, edKey, _ := ed25519.GenerateKey(rand.Reader)
+	client := newClient(t, New(&fakeSource{}))
+
+	if err := client.Add(agent.AddedKey{PrivateKey: edKey}); err == nil {
+		t.Error("Add succeeded")
+	}
+	if err := client.RemoveAll(); err == nil {
+		t.Error("RemoveAll succeeded")
+	}
+	if err := client.Lock([]byte("pw")); err == nil {
+		t.Error("Lock succeeded")
+	}
+}
+
+func TestListenReplacesStaleSocket(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "agent", "ssh.sock")
+	l, err := Listen(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// A second agent must not steal a live socket.
+	if _, err := Listen(path); err == nil {
+		t.Fatal("Listen on a live socket succeeded")
+	}
+
+	// Simulate a crash: the socket file stays but nobody listens.
+	l.(*net.UnixListener).SetUnlinkOnClose(false)
+	l.Close()
+	l, err = Listen(path)
+	if err != nil {
+		t.Fatalf("Listen over a stale socket: %v", err)
+	}
+	l.Close()
+}
@@ -0,0 +1,149 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package store
+
+import (
+	"errors"
+	"fmt"
+	"maps"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Rotated backups of the metadata, in the format of metadata.json:
+//
+//	backups/metadata.json.1 (newest) ... backups/metadata.json.<keep>
+//
+// Copying one of them to metadata.json restores the store; for FormatBolt,
+// with metadata.db moved away, Open then migrates it.
+const (
+	backupDirName  = "backups"
+	backupBaseName = "metadata.json"
+	// corruptSuffix is appended to metadata replaced by a backup.
+	corruptSuffix = ".corrupt"
+)
+
+// backups keeps the rotated backups of a store; the zero value keeps none.
+type backups struct {
+	dir      string // "" for stores without files
+	keep     int
+	interval time.Duration
+	last     time.Time // of the newest backup
+}
+
+// SetBackups makes the store keep up to keep rotated copies of its metadata
+// in the backups directory beside it. A copy of the metadata as it was is
+// taken before a change, unless the newest copy is younger than interval.
+// Zero keep turns backups off; existing copies are left alone. Memory
+// stores keep no backups.
+func (s *Store) SetBackups(keep int, interval time.Duration) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.backups.keep, s.backups.interval = keep, interval
+	s.backups.last = time.Time{}
+	if s.backups.dir == "" {
+		return
+	}
+	if fi, err := os.Stat(s.backups.path(1)); err == nil {
+		s.backups.last = fi.ModTime()
+	}
+}
+
+func (b *backups) path(n int) string {
+	return filepath.Join(b.dir, backupBaseName+"."+strconv.Itoa(n))
+}
+
+// due reports whether a backup should be taken before the next change.
+func (b *backups) due(now time.Time) bool {
+	return b.dir != "" && b.keep > 0 && now.Sub(b.last) >= b.interval
+}
+
+// take shifts the existing backups by one, dropping the oldest, and writes
+// d as the newest.
+func (b *backups) take(d *storeData, now time.Time) error {
+	data, err := marshalSealed(d)
+	if err != nil {
+		return fmt.Errorf("marshal metadata: %w", err)
+	}
+	if err := os.MkdirAll(b.dir, 0o700); err != nil {
+		return err
+	}
+	if err := os.Remove(b.path(b.keep)); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	for n := b.keep - 1; n >= 1; n-- {
+		if err := os.Rename(b.path(n), b.path(n+1)); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	if err := writeFileAtomic(b.path(1), data); err != nil {
+		return err
+	}
+	b.last = now
+	return nil
+}
+
+// backupIfDue takes a backup of the current state if one is due. A failed
+// backup is logged and does not stop the change.
+// Caller must hold s.mu (write lock) and s.lock.
+func (s *Store) backupIfDue() {
+	now := time.Now()
+	if !s.backups.due(now) {
+		return
+	}
+	if err := s.backups.take(&s.data, now); err != nil {
+		logger.Warn("could not back up metadata", "dir", s.backups.dir, "err", err)
+		return
+	}
+	logger.Debug("metadata backed up", "path", s.backups.path(1))
+}
+
+// restoreBackup replaces the state, which could not be loaded because of
+// loadErr, with the newest backup that can be, after setting the damaged
+// files aside. It fails if there is no such backup.
+// Caller must hold s.lock, before the store is shared.
+func (s *Store) restoreBackup(loadErr error) error {
+	paths, _ := filepath.Glob(filepath.Join(s.backups.dir, backupBaseName+".*"))
+	numbered := make(map[string]int, len(paths))
+	for _, p := range paths {
+		if n, err := strconv.Atoi(strings.TrimPrefix(filepath.Ext(p), ".")); err == nil && n > 0 {
+			numbered[p] = n
+		}
+	}
+	order := slices.SortedFunc(maps.Keys(numbered), func(a, b string) int { return numbered[a] - numbered[b] })
+	for _, p := range order {
+		data, err := os.ReadFile(p)
+		if err != nil {
+			logger.Warn("skipping unreadable backup", "path", p, "err", err)
+			continue
+		}
+		d := newStore().data
+		if err := unmarshalSealed(data, &d); err != nil {
+			logger.Warn("skipping damaged backup", "path", p, "err", err)
+			continue
+		}
+		if d.Collections == nil {
+			d.Collections = make(map[string]CollectionMeta)
+		}
+		if d.Aliases == nil {
+			d.Aliases = make(map[string]string)
+		}
+		aside, err := s.format.setAside()
+		if err != nil {
+			return fmt.Errorf("set damaged metadata aside: %w", err)
+		}
+		s.data = d
+		var taken time.Time
+		if fi, err := os.Stat(p); err == nil {
+			taken = fi.ModTime()
+		}
+		logger.Error("METADATA IS CORRUPT AND WAS RESTORED FROM A BACKUP; changes made after the backup are lost, their secrets can be found with \"wsl-secret-service recover\"",
+			"err", loadErr, "backup", p, "taken", taken, "damaged", aside)
+		return nil
+	}
+	return errors.New("no usable backup in " + s.backups.dir)
+}
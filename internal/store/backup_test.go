@@ -0,0 +1,143 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package store
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestBackupsRotate(t *testing.T) {
+	dir := t.TempDir()
+	s, err := New(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.SetBackups(2, 0)
+	for i := range 3 {
+		if err := s.CreateCollection("c"+strconv.Itoa(i), ""); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// Each backup holds the state before a change: the newest lacks only
+	// the last collection.
+	for n, want := range map[int][]string{1: {"c0", "c1"}, 2: {"c0"}} {
+		data, err := os.ReadFile(filepath.Join(dir, "backups", "metadata.json."+strconv.Itoa(n)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		var d storeData
+		if err := json.Unmarshal(data, &d); err != nil {
+			t.Fatal(err)
+		}
+		for _, name := range want {
+			if _, ok := d.Collections[name]; !ok {
+				t.Errorf("backup %d lacks collection %s", n, name)
+			}
+		}
+		if len(d.Collections) != len(want)+1 { // and login
+			t.Errorf("backup %d has %d collections, want %d", n, len(d.Collections), len(want)+1)
+		}
+	}
+	if _, err := os.Stat(filepath.Join(dir, "backups", "metadata.json.3")); !os.IsNotExist(err) {
+		t.Errorf("a third backup was kept: %v", err)
+	}
+}
+
+func TestBackupsInterval(t *testing.T) {
+	dir := t.TempDir()
+	s, err := New(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.SetBackups(3, time.Hour)
+	for i := range 3 {
+		if err := s.CreateCollection("c"+strconv.Itoa(i), ""); err != nil {
+			t.Fatal(err)
+		}
+	}
+	entries, err := os.ReadDir(filepath.Join(dir, "backups"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 1 {
+		t.Errorf("%d backups within the interval, want 1", len(entries))
+	}
+}
+
+func TestCorruptMetadataRestoresBackup(t *testing.T) {
+	for _, format := range []string{FormatJSON, FormatBolt} {
+		dir := t.TempDir()
+		s1, err := Open(dir, format)
+		if err != nil {
+			t.Fatalf("Open(%s): %v", format, err)
+		}
+		s1.SetBackups(3, 0)
+		if err := s1.CreateItem("login", "u1", ItemMeta{Label: "kept"}); err != nil {
+			t.Fatal(err)
+		}
+		// Takes a backup holding u1.
+		if err := s1.CreateItem("login", "u2", ItemMeta{Label: "lost"}); err != nil {
+			t.Fatal(err)
+		}
+
+		damaged := filepath.Join(dir, "metadata.json")
+		if format == FormatBolt {
+			damaged = filepath.Join(dir, boltFileName)
+		}
+		data, err := os.ReadFile(damaged)
+		if err != nil {
+			t.Fatal(err)
+		}
+		switch format {
+		case FormatJSON:
+			// Still valid JSON, but not what was written.
+			data = bytes.Replace(data, []byte(`"kept"`), []byte(`"KEPT"`), 1)
+		case FormatBolt:
+			// Both meta pages.
+			clear(data[:2*os.Getpagesize()])
+		}
+		if err := os.WriteFile(damaged, data, 0o600); err != nil {
+			t.Fatal(err)
+		}
+
+		s2, err := Open(dir, format)
+		if err != nil {
+			t.Fatalf("%s: Open of damaged metadata: %v", format, err)
+		}
+		if meta, ok := s2.GetItem("login", "u1"); !ok || meta.Label != "kept" {
+			t.Errorf("%s: u1 after restore = %+v, %v, want the backed up item", format, meta, ok)
+		}
+		if _, ok := s2.GetItem("login", "u2"); ok {
+			t.Errorf("%s: u2, created after the backup, exists", format)
+		}
+		aside := filepath.Join(dir, "metadata.json.corrupt")
+		if format == FormatBolt {
+			aside = filepath.Join(dir, boltFileName+".corrupt")
+		}
+		if _, err := os.Stat(aside); err != nil {
+			t.Errorf("%s: damaged metadata not set aside: %v", format, err)
+		}
+		// The restored metadata was written back.
+		if _, err := Open(dir, format); err != nil {
+			t.Errorf("%s: reopening restored metadata: %v", format, err)
+		}
+	}
+}
+
+func TestCorruptMetadataWithoutBackupFails(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "metadata.json"), []byte("{"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := New(dir); !errors.Is(err, ErrCorrupt) {
+		t.Errorf("New: err = %v, want ErrCorrupt", err)
+	}
+}
@@ -0,0 +1,323 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package store
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+	berrors "go.etcd.io/bbolt/errors"
+)
+
+// Layout of the FormatBolt database, metadata.db:
+//
+//	bucket "store":       "aliases", "tombstones" (JSON)
+//	bucket "collections": a bucket per collection name, holding
+//	                      "collection" (collectionRecord, JSON) and
+//	                      bucket "items": ItemMeta (JSON) per UUID
+//
+// Names are keys as they are, so no name needs escaping. Each commit is a
+// single bbolt transaction: a crash leaves either the old or the new state.
+const (
+	boltFileName       = "metadata.db"
+	migratedJSONSuffix = ".migrated"
+	// boltOpenTimeout bounds the wait for another process that has the
+	// database open.
+	boltOpenTimeout = 10 * time.Second
+)
+
+var (
+	bucketStore       = []byte("store")
+	bucketCollections = []byte("collections")
+	bucketItems       = []byte("items")
+	keyAliases        = []byte("aliases")
+	keyTombstones     = []byte("tombstones")
+	keyCollection     = []byte("collection")
+)
+
+// boltFormat is FormatBolt. bbolt locks a database for as long as it is
+// open, so it is opened for each load, commit and flush only: other
+// processes must be able to open it while the daemon runs.
+type boltFormat struct {
+	path string
+}
+
+// collectionRecord is the "collection" record: CollectionMeta without its
+// items, which have records of their own.
+type collectionRecord struct {
+	Label    string `json:"label"`
+	Created  uint64 `json:"created"`
+	Modified uint64 `json:"modified"`
+
+	Password *PasswordVerifier `json:"password,omitempty"`
+}
+
+// openBolt returns the FormatBolt store of configDir. If metadata.db does
+// not exist yet but metadata.json does, its content (including unreplayed
+// journal entries) is copied into metadata.db and metadata.json is renamed
+// to metadata.json.migrated.
+func openBolt(configDir string) (*boltFormat, error) {
+	f := &boltFormat{path: filepath.Join(configDir, boltFileName)}
+	if _, err := os.Stat(f.path); err == nil || !errors.Is(err, os.ErrNotExist) {
+		return f, err
+	}
+	legacy := newJSONFile(configDir)
+	if _, err := os.Stat(legacy.path); errors.Is(err, os.ErrNotExist) {
+		return f, nil
+	}
+	d := &storeData{Collections: make(map[string]CollectionMeta), Aliases: make(map[string]string)}
+	if err := legacy.load(d); err != nil {
+		return nil, fmt.Errorf("migrate %s: %w", legacy.path, err)
+	}
+	// Build the database beside the final location so that an interrupted
+	// migration is simply redone.
+	final := f.path
+	f.path = final + ".tmp"
+	if err := os.Remove(f.path); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return nil, err
+	}
+	if err := f.flush(d); err != nil {
+		return nil, fmt.Errorf("migrate metadata: %w", err)
+	}
+	if err := os.Rename(f.path, final); err != nil {
+		return nil, err
+	}
+	f.path = final
+	if err := syncDir(configDir); err != nil {
+		return nil, err
+	}
+	if err := os.Rename(legacy.path, legacy.path+migratedJSONSuffix); err != nil {
+		return nil, err
+	}
+	logger.Info("migrated metadata", "from", legacy.path, "to", f.path, "collections", len(d.Collections))
+	return f, nil
+}
+
+// open opens the database; damage that keeps bbolt from opening it is
+// reported as ErrCorrupt.
+func (f *boltFormat) open(readOnly bool) (*bolt.DB, error) {
+	db, err := bolt.Open(f.path, 0o600, &bolt.Options{Timeout: boltOpenTimeout, ReadOnly: readOnly})
+	if errors.Is(err, berrors.ErrInvalid) || errors.Is(err, berrors.ErrChecksum) || errors.Is(err, berrors.ErrVersionMismatch) {
+		return nil, fmt.Errorf("%w: open %s: %w", ErrCorrupt, f.path, err)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("open %s: %w", f.path, err)
+	}
+	return db, nil
+}
+
+// update runs fn in a write transaction.
+func (f *boltFormat) update(fn func(tx *bolt.Tx) error) error {
+	db, err := f.open(false)
+	if err != nil {
+		return err
+	}
+	err = db.Update(fn)
+	return errors.Join(err, db.Close())
+}
+
+// load reads the whole database.
+func (f *boltFormat) load(d *storeData) error {
+	if _, err := os.Stat(f.path); errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	db, err := f.open(true)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+	return db.View(func(tx *bolt.Tx) error {
+		if b := tx.Bucket(bucketStore); b != nil {
+			if err := getJSON(b, keyAliases, &d.Aliases); err != nil {
+				return err
+			}
+			if err := getJSON(b, keyTombstones, &d.Tombstones); err != nil {
+				return err
+			}
+		}
+		if d.Aliases == nil {
+			d.Aliases = make(map[string]string)
+		}
+		cols := tx.Bucket(bucketCollections)
+		if cols == nil {
+			return nil
+		}
+		return cols.ForEachBucket(func(name []byte) error {
+			cb := cols.Bucket(name)
+			var rec collectionRecord
+			if err := getJSON(cb, keyCollection, &rec); err != nil {
+				return err
+			}
+			col := CollectionMeta{Label: rec.Label, Created: rec.Created, Modified: rec.Modified, Password: rec.Password, Items: make(map[string]ItemMeta)}
+			if items := cb.Bucket(bucketItems); items != nil {
+				err := items.ForEach(func(uuid, v []byte) error {
+					var item ItemMeta
+					if err := json.Unmarshal(v, &item); err != nil {
+						return fmt.Errorf("%w: item %q of collection %q: %w", ErrCorrupt, uuid, name, err)
+					}
+					col.Items[string(uuid)] = item
+					return nil
+				})
+				if err != nil {
+					return err
+				}
+			}
+			d.Collections[string(name)] = col
+			return nil
+		})
+	})
+}
+
+// commit applies e to d and writes the records it changed in one
+// transaction.
+func (f *boltFormat) commit(d *storeData, e journalEntry) error {
+	if err := d.apply(e); err != nil {
+		return err
+	}
+	return f.update(func(tx *bolt.Tx) error {
+		if len(e.Targets) > 0 {
+			if err := putStoreJSON(tx, keyTombstones, d.Tombstones); err != nil {
+				return err
+			}
+		}
+		switch e.Op {
+		case opCreateCollection, opUpdateCollection, opSetPassword:
+			_, err := putCollection(tx, e.Collection, d.Collections[e.Collection])
+			return err
+		case opDeleteCollection:
+			if cols := tx.Bucket(bucketCollections); cols != nil {
+				if err := cols.DeleteBucket([]byte(e.Collection)); err != nil && !errors.Is(err, berrors.ErrBucketNotFound) {
+					return err
+				}
+			}
+			return putStoreJSON(tx, keyAliases, d.Aliases)
+		case opCreateItem, opUpdateItem:
+			col := d.Collections[e.Collection]
+			items, err := putCollection(tx, e.Collection, col)
+			if err != nil {
+				return err
+			}
+			return putJSON(items, []byte(e.UUID), col.Items[e.UUID])
+		case opDeleteItem:
+			items, err := putCollection(tx, e.Collection, d.Collections[e.Collection])
+			if err != nil {
+				return err
+			}
+			return items.Delete([]byte(e.UUID))
+		case opRecordAccesses:
+			for _, a := range e.Accesses {
+				item, ok := d.Collections[a.Collection].Items[a.UUID]
+				if !ok {
+					continue
+				}
+				items, err := putCollection(tx, a.Collection, d.Collections[a.Collection])
+				if err != nil {
+					return err
+				}
+				if err := putJSON(items, []byte(a.UUID), item); err != nil {
+					return err
+				}
+			}
+			return nil
+		case opSetAlias:
+			return putStoreJSON(tx, keyAliases, d.Aliases)
+		}
+		return nil
+	})
+}
+
+// flush replaces the content of the database with d.
+func (f *boltFormat) flush(d *storeData) error {
+	return f.update(func(tx *bolt.Tx) error {
+		for _, name := range [][]byte{bucketStore, bucketCollections} {
+			if err := tx.DeleteBucket(name); err != nil && !errors.Is(err, berrors.ErrBucketNotFound) {
+				return err
+			}
+		}
+		for name, col := range d.Collections {
+			items, err := putCollection(tx, name, col)
+			if err != nil {
+				return err
+			}
+			for uuid, item := range col.Items {
+				if err := putJSON(items, []byte(uuid), item); err != nil {
+					return err
+				}
+			}
+		}
+		if err := putStoreJSON(tx, keyTombstones, d.Tombstones); err != nil {
+			return err
+		}
+		return putStoreJSON(tx, keyAliases, d.Aliases)
+	})
+}
+
+// changed always reports false: a change to the database is only detected
+// through the store generation, i.e. when it was made by another instance
+// of the daemon.
+func (f *boltFormat) changed() bool {
+	return false
+}
+
+// setAside renames metadata.db to metadata.db.corrupt, replacing an older
+// one.
+func (f *boltFormat) setAside() (string, error) {
+	aside := f.path + corruptSuffix
+	if err := os.Rename(f.path, aside); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return "", err
+	}
+	return aside, nil
+}
+
+// putCollection writes the record of collection name, creating its
+// buckets if needed, and returns its items bucket.
+func putCollection(tx *bolt.Tx, name string, col CollectionMeta) (*bolt.Bucket, error) {
+	cols, err := tx.CreateBucketIfNotExists(bucketCollections)
+	if err != nil {
+		return nil, err
+	}
+	cb, err := cols.CreateBucketIfNotExists([]byte(name))
+	if err != nil {
+		return nil, fmt.Errorf("collection %q: %w", name, err)
+	}
+	rec := collectionRecord{Label: col.Label, Created: col.Created, Modified: col.Modified, Password: col.Password}
+	if err := putJSON(cb, keyCollection, rec); err != nil {
+		return nil, err
+	}
+	return cb.CreateBucketIfNotExists(bucketItems)
+}
+
+// putStoreJSON writes v as JSON under key in the "store" bucket.
+func putStoreJSON(tx *bolt.Tx, key []byte, v any) error {
+	b, err := tx.CreateBucketIfNotExists(bucketStore)
+	if err != nil {
+		return err
+	}
+	return putJSON(b, key, v)
+}
+
+func putJSON(b *bolt.Bucket, key []byte, v any) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return fmt.Errorf("marshal %s: %w", key, err)
+	}
+	return b.Put(key, data)
+}
+
+// getJSON reads the JSON value under key into v; a missing key leaves v
+// unchanged.
+func getJSON(b *bolt.Bucket, key []byte, v any) error {
+	data := b.Get(key)
+	if data == nil {
+		return nil
+	}
+	if err := json.Unmarshal(data, v); err != nil {
+		return fmt.Errorf("%w: parse %s: %w", ErrCorrupt, key, err)
+	}
+	return nil
+}
@@ -0,0 +1,70 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package store
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestBoltPersistence(t *testing.T) {
+	dir := t.TempDir()
+	s1, err := Open(dir, FormatBolt)
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	_ = s1.CreateCollection("my work", "Work")
+	_ = s1.CreateItem("my work", "u1", ItemMeta{Label: "one", Attributes: map[string]string{"k": "v"}})
+	_ = s1.CreateItem("my work", "u2", ItemMeta{Label: "two"})
+	_ = s1.DeleteItem("my work", "u2")
+	_ = s1.SetAlias("work", "my work")
+	_ = s1.CreateCollection("tmp", "Tmp")
+	_ = s1.CreateItem("tmp", "u3", ItemMeta{Label: "three"})
+	_ = s1.SetAlias("scratch", "tmp")
+	if err := s1.DeleteCollection("tmp"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "metadata.json")); !os.IsNotExist(err) {
+		t.Error("bolt format should not write metadata.json")
+	}
+
+	// The database is not held open between changes.
+	s2, err := Open(dir, FormatBolt)
+	if err != nil {
+		t.Fatalf("reload: %v", err)
+	}
+	if meta, ok := s2.GetItem("my work", "u1"); !ok || meta.Attributes["k"] != "v" {
+		t.Errorf("item after reload = %+v, %v", meta, ok)
+	}
+	if _, ok := s2.GetItem("my work", "u2"); ok {
+		t.Error("deleted item came back after reload")
+	}
+	if _, ok := s2.GetCollection("tmp"); ok {
+		t.Error("deleted collection came back after reload")
+	}
+	if s2.GetAlias("work") != "my work" || s2.GetAlias("default") != "login" || s2.GetAlias("scratch") != "" {
+		t.Errorf("aliases after reload = %v", s2.ListAliases())
+	}
+}
+
+func TestBoltMigratesJSON(t *testing.T) {
+	dir := t.TempDir()
+	s1, _ := New(dir)
+	_ = s1.CreateCollection("work", "Work")
+	_ = s1.CreateItem("work", "u1", ItemMeta{Label: "one"})
+
+	s2, err := Open(dir, FormatBolt)
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	if _, ok := s2.GetItem("work", "u1"); !ok {
+		t.Error("item not migrated")
+	}
+	if _, err := os.Stat(filepath.Join(dir, "metadata.json"+migratedJSONSuffix)); err != nil {
+		t.Errorf("migrated metadata.json: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(dir, boltFileName+".tmp")); !os.IsNotExist(err) {
+		t.Error("temporary database left behind")
+	}
+}
@@ -0,0 +1,66 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package store
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// ErrCorrupt is wrapped by the errors of loading metadata that cannot be
+// parsed or does not match its checksum.
+var ErrCorrupt = errors.New("metadata is corrupt")
+
+// metadata.json (and its backups) carries the SHA-256 of its own content in
+// the top-level "checksum" field, computed with the field's value set to
+// checksumPlaceholder. The sum covers the bytes as written, so fields a
+// newer version added do not make it fail.
+var (
+	checksumField       = []byte("\n  \"checksum\": \"")
+	checksumPlaceholder = strings.Repeat("0", sha256.Size*2)
+)
+
+// marshalSealed returns d as indented JSON with its checksum.
+func marshalSealed(d *storeData) ([]byte, error) {
+	sealed := *d
+	sealed.Checksum = checksumPlaceholder
+	data, err := json.MarshalIndent(&sealed, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	i := bytes.Index(data, checksumField)
+	if i < 0 {
+		return nil, errors.New("marshal metadata: no checksum field")
+	}
+	sum := sha256.Sum256(data)
+	hex.Encode(data[i+len(checksumField):], sum[:])
+	return data, nil
+}
+
+// unmarshalSealed parses data, written by marshalSealed, into d after
+// verifying its checksum. Data without a checksum, written by versions
+// before checksums, is accepted as is.
+func unmarshalSealed(data []byte, d *storeData) error {
+	if i := bytes.Index(data, checksumField); i >= 0 {
+		start := i + len(checksumField)
+		end := start + len(checksumPlaceholder)
+		if end > len(data) {
+			return fmt.Errorf("%w: truncated checksum", ErrCorrupt)
+		}
+		want := string(data[start:end])
+		unsealed := bytes.Clone(data)
+		copy(unsealed[start:end], checksumPlaceholder)
+		if sum := sha256.Sum256(unsealed); hex.EncodeToString(sum[:]) != want {
+			return fmt.Errorf("%w: checksum mismatch", ErrCorrupt)
+		}
+	}
+	if err := json.Unmarshal(data, d); err != nil {
+		return fmt.Errorf("%w: %w", ErrCorrupt, err)
+	}
+	return nil
+}
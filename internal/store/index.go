@@ -0,0 +1,115 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package store
+
+import "maps"
+
+// attrIndex is an inverted index from attribute name and value to the items
+// carrying that pair, so that searches by libsecret schema attributes do
+// not scan every item. It is rebuilt from the loaded state when the store
+// is opened rather than persisted, and kept current by Store.commit.
+// It is guarded by the store's mutex.
+type attrIndex map[string]map[string]map[ItemRef]struct{}
+
+// build indexes every item in d.
+func (x attrIndex) build(d *storeData) {
+	clear(x)
+	for colName, col := range d.Collections {
+		for uuid, item := range col.Items {
+			x.add(ItemRef{Collection: colName, UUID: uuid}, item.Attributes)
+		}
+	}
+}
+
+func (x attrIndex) add(ref ItemRef, attrs map[string]string) {
+	for k, v := range attrs {
+		values := x[k]
+		if values == nil {
+			values = make(map[string]map[ItemRef]struct{})
+			x[k] = values
+		}
+		refs := values[v]
+		if refs == nil {
+			refs = make(map[ItemRef]struct{})
+			values[v] = refs
+		}
+		refs[ref] = struct{}{}
+	}
+}
+
+func (x attrIndex) remove(ref ItemRef, attrs map[string]string) {
+	for k, v := range attrs {
+		refs := x[k][v]
+		delete(refs, ref)
+		if len(refs) == 0 {
+			delete(x[k], v)
+			if len(x[k]) == 0 {
+				delete(x, k)
+			}
+		}
+	}
+}
+
+// indexedItem is an item affected by a journal entry, with the attributes
+// it was indexed under before the entry was applied.
+type indexedItem struct {
+	ref   ItemRef
+	attrs map[string]string
+}
+
+// affectedItems returns the items of d that e creates, changes or deletes.
+// The attributes are copied, as callers may have modified the map of the
+// stored item in place before updating it.
+func affectedItems(d *storeData, e journalEntry) []indexedItem {
+	col := d.Collections[e.Collection]
+	switch e.Op {
+	case opCreateItem, opUpdateItem, opDeleteItem:
+		ref := ItemRef{Collection: e.Collection, UUID: e.UUID}
+		return []indexedItem{{ref: ref, attrs: maps.Clone(col.Items[e.UUID].Attributes)}}
+	case opDeleteCollection:
+		items := make([]indexedItem, 0, len(col.Items))
+		for uuid, item := range col.Items {
+			items = append(items, indexedItem{ref: ItemRef{Collection: e.Collection, UUID: uuid}, attrs: maps.Clone(item.Attributes)})
+		}
+		return items
+	}
+	return nil
+}
+
+// reindex replaces the entries of items, as returned by affectedItems, with
+// their attributes in d.
+func (x attrIndex) reindex(d *storeData, items []indexedItem) {
+	for _, it := range items {
+		x.remove(it.ref, it.attrs)
+		if item, ok := d.Collections[it.ref.Collection].Items[it.ref.UUID]; ok {
+			x.add(it.ref, item.Attributes)
+		}
+	}
+}
+
+// search returns the items of d whose attributes are a superset of attrs,
+// restricted to collection unless it is empty. attrs must not be empty.
+// Candidates come from the smallest posting list and are checked against
+// the remaining pairs.
+func (x attrIndex) search(d *storeData, collection string, attrs map[string]string) []ItemRef {
+	var smallest map[ItemRef]struct{}
+	for k, v := range attrs {
+		refs := x[k][v]
+		if len(refs) == 0 {
+			return nil
+		}
+		if smallest == nil || len(refs) < len(smallest) {
+			smallest = refs
+		}
+	}
+	var results []ItemRef
+	for ref := range smallest {
+		if collection != "" && ref.Collection != collection {
+			continue
+		}
+		if matchesAll(d.Collections[ref.Collection].Items[ref.UUID].Attributes, attrs) {
+			results = append(results, ref)
+		}
+	}
+	return results
+}
@@ -0,0 +1,355 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package store
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"maps"
+	"os"
+	"path/filepath"
+)
+
+// Journal operations. Each mutation of the store is described by exactly one
+// journalEntry so that it can be replayed after a crash.
+const (
+	opCreateCollection = "create_collection"
+	opUpdateCollection = "update_collection_label"
+	opDeleteCollection = "delete_collection"
+	opCreateItem       = "create_item"
+	opUpdateItem       = "update_item"
+	opDeleteItem       = "delete_item"
+	opSetAlias         = "set_alias"
+	opSetPassword      = "set_collection_password"
+	opRecordAccess     = "record_access" // journals written before opRecordAccesses
+	opRecordAccesses   = "record_accesses"
+	opClearTombstones  = "clear_tombstones"
+)
+
+// journalEntry is one line of the write-ahead journal.
+type journalEntry struct {
+	Op         string    `json:"op"`
+	Collection string    `json:"collection,omitempty"`
+	UUID       string    `json:"uuid,omitempty"`
+	Label      string    `json:"label,omitempty"`
+	Alias      string    `json:"alias,omitempty"`
+	Item       *ItemMeta `json:"item,omitempty"`
+	Time       uint64    `json:"time"`
+
+	Password *PasswordVerifier `json:"password,omitempty"`
+	// Targets are the backend targets tombstoned by a delete, or the
+	// tombstones cleared by opClearTombstones.
+	Targets []string `json:"targets,omitempty"`
+	// Accesses are the item reads recorded by opRecordAccesses.
+	Accesses []ItemAccess `json:"accesses,omitempty"`
+}
+
+// check reports whether e can be applied to d without modifying d.
+func (d *storeData) check(e journalEntry) error {
+	switch e.Op {
+	case opCreateCollection:
+		if _, ok := d.Collections[e.Collection]; ok {
+			return fmt.Errorf("collection %q already exists", e.Collection)
+		}
+	case opUpdateCollection, opDeleteCollection, opCreateItem, opSetPassword:
+		if _, ok := d.Collections[e.Collection]; !ok {
+			return fmt.Errorf("collection %q not found", e.Collection)
+		}
+	case opUpdateItem, opDeleteItem, opRecordAccess:
+		c, ok := d.Collections[e.Collection]
+		if !ok {
+			return fmt.Errorf("collection %q not found", e.Collection)
+		}
+		if _, ok := c.Items[e.UUID]; !ok {
+			return fmt.Errorf("item %q not found in collection %q", e.UUID, e.Collection)
+		}
+	case opSetAlias:
+		if _, ok := d.Collections[e.Collection]; e.Collection != "" && !ok {
+			return fmt.Errorf("collection %q not found", e.Collection)
+		}
+	case opClearTombstones, opRecordAccesses:
+	default:
+		return fmt.Errorf("unknown journal op %q", e.Op)
+	}
+	return nil
+}
+
+// apply performs e on d. It returns the check error, if any, and leaves d
+// untouched in that case.
+func (d *storeData) apply(e journalEntry) error {
+	if err := d.check(e); err != nil {
+		return err
+	}
+	switch e.Op {
+	case opCreateCollection:
+		d.Collections[e.Collection] = CollectionMeta{
+			Label:    e.Label,
+			Created:  e.Time,
+			Modified: e.Time,
+			Items:    make(map[string]ItemMeta),
+		}
+	case opUpdateCollection:
+		c := d.Collections[e.Collection]
+		c.Label = e.Label
+		c.Modified = e.Time
+		d.Collections[e.Collection] = c
+	case opSetPassword:
+		c := d.Collections[e.Collection]
+		c.Password = e.Password
+		c.Modified = e.Time
+		d.Collections[e.Collection] = c
+	case opDeleteCollection:
+		d.addTombstones(e)
+		delete(d.Collections, e.Collection)
+		// Remove any aliases pointing to this collection.
+		for alias, target := range d.Aliases {
+			if target == e.Collection {
+				delete(d.Aliases, alias)
+			}
+		}
+	case opCreateItem, opUpdateItem:
+		c := d.Collections[e.Collection]
+		if c.Items == nil {
+			c.Items = make(map[string]ItemMeta)
+		}
+		c.Items[e.UUID] = *e.Item
+		c.Modified = e.Time
+		d.Collections[e.Collection] = c
+	case opRecordAccess:
+		item := d.Collections[e.Collection].Items[e.UUID]
+		item.LastAccessed = e.Time
+		item.AccessCount++
+		d.Collections[e.Collection].Items[e.UUID] = item
+	case opRecordAccesses:
+		// Items deleted since they were read are skipped.
+		for _, a := range e.Accesses {
+			item, ok := d.Collections[a.Collection].Items[a.UUID]
+			if !ok {
+				continue
+			}
+			item.LastAccessed = max(item.LastAccessed, a.Time)
+			item.AccessCount += a.Count
+			d.Collections[a.Collection].Items[a.UUID] = item
+		}
+	case opDeleteItem:
+		d.addTombstones(e)
+		c := d.Collections[e.Collection]
+		delete(c.Items, e.UUID)
+		c.Modified = e.Time
+		d.Collections[e.Collection] = c
+	case opSetAlias:
+		if e.Collection == "" {
+			delete(d.Aliases, e.Alias)
+		} else {
+			d.Aliases[e.Alias] = e.Collection
+		}
+	case opClearTombstones:
+		for _, t := range e.Targets {
+			delete(d.Tombstones, t)
+		}
+	}
+	return nil
+}
+
+// cloneFor returns a copy of d that e can be applied to while d stays
+// unchanged until the change is durable. Only the maps apply changes for e
+// are copied; the rest is shared with d.
+func (d *storeData) cloneFor(e journalEntry) *storeData {
+	c := *d
+	c.Collections = maps.Clone(d.Collections)
+	c.Aliases = maps.Clone(d.Aliases)
+	c.Tombstones = maps.Clone(d.Tombstones)
+	names := []string{e.Collection}
+	for _, a := range e.Accesses {
+		names = append(names, a.Collection)
+	}
+	cloned := make(map[string]bool)
+	for _, name := range names {
+		col, ok := c.Collections[name]
+		if !ok || cloned[name] {
+			continue
+		}
+		col.Items = maps.Clone(col.Items)
+		c.Collections[name] = col
+		cloned[name] = true
+	}
+	return &c
+}
+
+// addTombstones records the targets of the delete e as tombstones.
+func (d *storeData) addTombstones(e journalEntry) {
+	if len(e.Targets) == 0 {
+		return
+	}
+	if d.Tombstones == nil {
+		d.Tombstones = make(map[string]Tombstone)
+	}
+	for _, t := range e.Targets {
+		d.Tombstones[t] = Tombstone{Collection: e.Collection, UUID: e.UUID, Deleted: e.Time}
+	}
+}
+
+// jsonFile is FormatJSON: the whole state in metadata.json, with every
+// change first appended to metadata.journal so that it survives a crash
+// before metadata.json is rewritten.
+type jsonFile struct {
+	path        string
+	journalPath string
+
+	// written describes metadata.json as last loaded or written, nil if it
+	// did not exist.
+	written os.FileInfo
+}
+
+func newJSONFile(configDir string) *jsonFile {
+	return &jsonFile{
+		path:        filepath.Join(configDir, "metadata.json"),
+		journalPath: filepath.Join(configDir, "metadata.journal"),
+	}
+}
+
+// load reads metadata.json and replays mutations that were journaled but
+// not checkpointed before the previous run stopped.
+func (f *jsonFile) load(d *storeData) error {
+	data, err := os.ReadFile(f.path)
+	if err == nil {
+		err = unmarshalSealed(data, d)
+	}
+	if err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("%s: %w", f.path, err)
+	}
+	replayed, err := f.replayJournal(d)
+	if err != nil {
+		return err
+	}
+	if replayed > 0 {
+		logger.Info("replayed journaled changes", "count", replayed)
+		if err := f.flush(d); err != nil {
+			return fmt.Errorf("checkpoint metadata: %w", err)
+		}
+	} else if err := os.Remove(f.journalPath); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("truncate journal: %w", err)
+	}
+	f.written, _ = os.Stat(f.path)
+	return nil
+}
+
+// changed reports whether metadata.json was replaced or modified since it
+// was last loaded or written.
+func (f *jsonFile) changed() bool {
+	fi, err := os.Stat(f.path)
+	if err != nil {
+		return f.written != nil
+	}
+	if f.written == nil {
+		return true
+	}
+	return !os.SameFile(fi, f.written) || !fi.ModTime().Equal(f.written.ModTime()) || fi.Size() != f.written.Size()
+}
+
+// commit records e in the journal, which makes it durable, applies it to d
+// and checkpoints the result into metadata.json. If the checkpoint fails
+// the entry stays in the journal and is replayed by the next load, so the
+// change still succeeds.
+func (f *jsonFile) commit(d *storeData, e journalEntry) error {
+	if err := f.appendJournal(e); err != nil {
+		return err
+	}
+	if err := d.apply(e); err != nil {
+		return err
+	}
+	if err := f.flush(d); err != nil {
+		logger.Warn("could not checkpoint metadata; the change stays in the journal", "err", err)
+	}
+	return nil
+}
+
+// setAside renames metadata.json and the journal to *.corrupt.
+func (f *jsonFile) setAside() (string, error) {
+	for _, p := range []string{f.journalPath, f.path} {
+		if err := os.Rename(p, p+corruptSuffix); err != nil && !os.IsNotExist(err) {
+			return "", err
+		}
+	}
+	f.written = nil
+	return f.path + corruptSuffix, nil
+}
+
+// appendJournal durably appends e to the journal file.
+func (f *jsonFile) appendJournal(e journalEntry) error {
+	line, err := json.Marshal(e)
+	if err != nil {
+		return fmt.Errorf("marshal journal entry: %w", err)
+	}
+	_, statErr := os.Stat(f.journalPath)
+	jf, err := os.OpenFile(f.journalPath, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0o600)
+	if err != nil {
+		return fmt.Errorf("open journal: %w", err)
+	}
+	if _, err := jf.Write(append(line, '\n')); err != nil {
+		_ = jf.Close()
+		return fmt.Errorf("append journal: %w", err)
+	}
+	if err := jf.Sync(); err != nil {
+		_ = jf.Close()
+		return fmt.Errorf("sync journal: %w", err)
+	}
+	if err := jf.Close(); err != nil {
+		return err
+	}
+	if errors.Is(statErr, os.ErrNotExist) {
+		// A new journal must survive a crash as a directory entry, too.
+		return syncDir(filepath.Dir(f.journalPath))
+	}
+	return nil
+}
+
+// flush writes d to metadata.json atomically and durably (see
+// writeFileAtomic) and then discards the journal, whose entries are now all
+// reflected in that file.
+func (f *jsonFile) flush(d *storeData) error {
+	data, err := marshalSealed(d)
+	if err != nil {
+		return fmt.Errorf("marshal metadata: %w", err)
+	}
+	if err := writeFileAtomic(f.path, data); err != nil {
+		return fmt.Errorf("write metadata: %w", err)
+	}
+	f.written, _ = os.Stat(f.path)
+	if err := os.Remove(f.journalPath); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("truncate journal: %w", err)
+	}
+	return nil
+}
+
+// replayJournal applies entries left over from an interrupted run and
+// reports how many were applied. A torn final line (crash during append) is
+// ignored, as are entries that no longer apply because the checkpoint
+// completed before the journal could be removed.
+func (f *jsonFile) replayJournal(d *storeData) (int, error) {
+	data, err := os.ReadFile(f.journalPath)
+	if os.IsNotExist(err) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, fmt.Errorf("read journal: %w", err)
+	}
+	applied := 0
+	sc := bufio.NewScanner(bytes.NewReader(data))
+	sc.Buffer(make([]byte, 0, 64*1024), len(data)+1)
+	for sc.Scan() {
+		var e journalEntry
+		if err := json.Unmarshal(sc.Bytes(), &e); err != nil {
+			logger.Warn("ignoring unreadable journal entry", "err", err)
+			continue
+		}
+		if err := d.apply(e); err != nil {
+			continue
+		}
+		applied++
+	}
+	return applied, nil
+}
@@ -0,0 +1,82 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package store
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+
+	"golang.org/x/sys/unix"
+)
+
+// ErrModifiedExternally is returned by writes after another process changed
+// the metadata on disk since this store loaded it. The in-memory state is
+// stale, so writing would discard that change; restarting the daemon picks
+// it up.
+var ErrModifiedExternally = errors.New("metadata was changed by another process; restart the daemon to load the changes")
+
+// storeLock serializes writers of one config directory across processes
+// with an advisory flock on metadata.lock. The file also holds the store's
+// generation, a counter each write increments, so that a Store notices
+// writes by other instances of the daemon. The lock of a memory store has no
+// file and only keeps the generation.
+type storeLock struct {
+	f   *os.File
+	gen uint64 // the generation when f is nil
+}
+
+func openStoreLock(configDir string) (*storeLock, error) {
+	f, err := os.OpenFile(filepath.Join(configDir, "metadata.lock"), os.O_RDWR|os.O_CREATE, 0o600)
+	if err != nil {
+		return nil, fmt.Errorf("open metadata lock: %w", err)
+	}
+	return &storeLock{f: f}, nil
+}
+
+// lock takes the lock, waiting for other writers, and returns the current
+// generation.
+func (l *storeLock) lock() (uint64, error) {
+	if l.f == nil {
+		return l.gen, nil
+	}
+	for {
+		err := unix.Flock(int(l.f.Fd()), unix.LOCK_EX)
+		if err == nil {
+			break
+		}
+		if !errors.Is(err, unix.EINTR) {
+			return 0, fmt.Errorf("lock metadata: %w", err)
+		}
+	}
+	var buf [8]byte
+	if _, err := l.f.ReadAt(buf[:], 0); err != nil && !errors.Is(err, io.EOF) {
+		l.unlock()
+		return 0, fmt.Errorf("read metadata generation: %w", err)
+	}
+	return binary.LittleEndian.Uint64(buf[:]), nil
+}
+
+// setGeneration records gen. The lock must be held.
+func (l *storeLock) setGeneration(gen uint64) error {
+	if l.f == nil {
+		l.gen = gen
+		return nil
+	}
+	var buf [8]byte
+	binary.LittleEndian.PutUint64(buf[:], gen)
+	if _, err := l.f.WriteAt(buf[:], 0); err != nil {
+		return fmt.Errorf("write metadata generation: %w", err)
+	}
+	return nil
+}
+
+func (l *storeLock) unlock() {
+	if l.f == nil {
+		return
+	}
+	_ = unix.Flock(int(l.f.Fd()), unix.LOCK_UN)
+}
@@ -0,0 +1,171 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package store
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// Match modes of AttrMatch.
+const (
+	MatchExact  = "exact"  // the value equals Pattern
+	MatchPrefix = "prefix" // the value starts with Pattern
+	MatchGlob   = "glob"   // the whole value matches Pattern, see globRegexp
+	MatchRegex  = "regex"  // Pattern, a Go regular expression, matches in the value
+)
+
+// AttrMatch is one criterion of SearchItemsMatching: the value of the
+// item's attribute Attribute must match Pattern in Mode. Items without the
+// attribute do not match.
+type AttrMatch struct {
+	Attribute string
+	Mode      string
+	Pattern   string
+	// IgnoreCase compares the value and Pattern case-insensitively, with
+	// Unicode case folding.
+	IgnoreCase bool
+}
+
+// matcher returns the predicate m applies to attribute values.
+func (m AttrMatch) matcher() (func(string) bool, error) {
+	flags := ""
+	if m.IgnoreCase {
+		flags = "(?i)"
+	}
+	switch {
+	case m.Mode == MatchExact && m.IgnoreCase:
+		return func(v string) bool { return strings.EqualFold(v, m.Pattern) }, nil
+	case m.Mode == MatchExact:
+		return func(v string) bool { return v == m.Pattern }, nil
+	case m.Mode == MatchPrefix && m.IgnoreCase:
+		prefix := foldCase(m.Pattern)
+		return func(v string) bool { return strings.HasPrefix(foldCase(v), prefix) }, nil
+	case m.Mode == MatchPrefix:
+		return func(v string) bool { return strings.HasPrefix(v, m.Pattern) }, nil
+	case m.Mode == MatchGlob:
+		re, err := regexp.Compile(flags + globRegexp(m.Pattern))
+		if err != nil {
+			return nil, fmt.Errorf("invalid glob %q for attribute %q: %w", m.Pattern, m.Attribute, err)
+		}
+		return re.MatchString, nil
+	case m.Mode == MatchRegex:
+		re, err := regexp.Compile(flags + m.Pattern)
+		if err != nil {
+			return nil, fmt.Errorf("invalid regular expression for attribute %q: %w", m.Attribute, err)
+		}
+		return re.MatchString, nil
+	}
+	return nil, fmt.Errorf("unknown match mode %q for attribute %q (want %s, %s, %s or %s)", m.Mode, m.Attribute, MatchExact, MatchPrefix, MatchGlob, MatchRegex)
+}
+
+// foldCase maps the case variants of s to one string, so that prefixes can
+// be compared; like strings.EqualFold, "ſ" and "s" are the same.
+func foldCase(s string) string {
+	return strings.ToLower(strings.ToUpper(s))
+}
+
+// globRegexp translates a glob pattern into an anchored regular
+// expression. "*" matches any characters, including "/" and ".", so that
+// "*.corp.example.com" matches URLs; "?" matches one character, and "\"
+// makes the next character literal.
+func globRegexp(glob string) string {
+	var b strings.Builder
+	b.WriteString(`^(?s:`)
+	for i := 0; i < len(glob); i++ {
+		switch c := glob[i]; c {
+		case '*':
+			b.WriteString(`.*`)
+		case '?':
+			b.WriteString(`.`)
+		case '\\':
+			if i+1 < len(glob) {
+				i++
+			}
+			b.WriteString(regexp.QuoteMeta(glob[i : i+1]))
+		default:
+			b.WriteString(regexp.QuoteMeta(glob[i : i+1]))
+		}
+	}
+	b.WriteString(`)$`)
+	return b.String()
+}
+
+// SearchItemsMatching finds the items, in collection or in every collection
+// if collection is "", whose attributes match all of matches. It fails if
+// a mode is unknown or a pattern invalid. Only case-sensitive exact
+// criteria use the attribute index; other searches scan every item.
+func (s *Store) SearchItemsMatching(collection string, matches []AttrMatch) ([]ItemRef, error) {
+	preds := make([]func(string) bool, len(matches))
+	exact := make(map[string]string)
+	for i, m := range matches {
+		var err error
+		if preds[i], err = m.matcher(); err != nil {
+			return nil, err
+		}
+		if _, ok := exact[m.Attribute]; m.Mode == MatchExact && !m.IgnoreCase && !ok {
+			exact[m.Attribute] = m.Pattern
+		}
+	}
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	var candidates []ItemRef
+	if len(exact) > 0 {
+		candidates = s.index.search(&s.data, collection, exact)
+	} else {
+		for colName, col := range s.data.Collections {
+			if collection != "" && colName != collection {
+				continue
+			}
+			for uuid := range col.Items {
+				candidates = append(candidates, ItemRef{Collection: colName, UUID: uuid})
+			}
+		}
+	}
+	var results []ItemRef
+	for _, ref := range candidates {
+		attrs := s.data.Collections[ref.Collection].Items[ref.UUID].Attributes
+		if matchesEach(attrs, matches, preds) {
+			results = append(results, ref)
+		}
+	}
+	return results, nil
+}
+
+// matchesEach reports whether attrs has every attribute of matches, with a
+// value preds accepts.
+func matchesEach(attrs map[string]string, matches []AttrMatch, preds []func(string) bool) bool {
+	for i, m := range matches {
+		v, ok := attrs[m.Attribute]
+		if !ok || !preds[i](v) {
+			return false
+		}
+	}
+	return true
+}
+
+// SearchItemsByLabel finds the items, in any collection, whose label
+// contains substr; with ignoreCase, compared case-insensitively. An empty
+// substr matches every item.
+func (s *Store) SearchItemsByLabel(substr string, ignoreCase bool) []ItemRef {
+	if ignoreCase {
+		substr = foldCase(substr)
+	}
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	var results []ItemRef
+	for colName, col := range s.data.Collections {
+		for uuid, item := range col.Items {
+			label := item.Label
+			if ignoreCase {
+				label = foldCase(label)
+			}
+			if strings.Contains(label, substr) {
+				results = append(results, ItemRef{Collection: colName, UUID: uuid})
+			}
+		}
+	}
+	return results
+}
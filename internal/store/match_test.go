@@ -0,0 +1,148 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package store
+
+import (
+	"slices"
+	"testing"
+)
+
+func TestSearchItemsMatching(t *testing.T) {
+	s := NewMemory()
+	items := map[string]map[string]string{
+		"a": {"url": "https://git.corp.example.com/x", "user": "alice"},
+		"b": {"url": "https://wiki.corp.example.com", "user": "bob"},
+		"c": {"url": "https://example.com", "user": "alice"},
+		"d": {"user": "alice"},
+	}
+	for uuid, attrs := range items {
+		if err := s.CreateItem("login", uuid, ItemMeta{Attributes: attrs}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tests := []struct {
+		matches []AttrMatch
+		want    []string
+	}{
+		{[]AttrMatch{{Attribute: "url", Mode: MatchGlob, Pattern: "*.corp.example.com*"}}, []string{"a", "b"}},
+		{[]AttrMatch{{Attribute: "url", Mode: MatchGlob, Pattern: "https://?xample.com"}}, []string{"c"}},
+		{[]AttrMatch{{Attribute: "url", Mode: MatchGlob, Pattern: "*.corp.example.com"}}, []string{"b"}},
+		{[]AttrMatch{{Attribute: "url", Mode: MatchPrefix, Pattern: "https://git."}}, []string{"a"}},
+		{[]AttrMatch{{Attribute: "url", Mode: MatchRegex, Pattern: `corp\.example`}, {Attribute: "user", Mode: MatchExact, Pattern: "alice"}}, []string{"a"}},
+		{[]AttrMatch{{Attribute: "user", Mode: MatchRegex, Pattern: "^(alice|bob)$"}}, []string{"a", "b", "c", "d"}},
+		{[]AttrMatch{{Attribute: "url", Mode: MatchRegex, Pattern: ""}}, []string{"a", "b", "c"}},
+		{[]AttrMatch{{Attribute: "user", Mode: MatchExact, Pattern: "alice"}, {Attribute: "user", Mode: MatchExact, Pattern: "bob"}}, nil},
+		{nil, []string{"a", "b", "c", "d"}},
+	}
+	for _, tt := range tests {
+		refs, err := s.SearchItemsMatching("", tt.matches)
+		if err != nil {
+			t.Errorf("%v: %v", tt.matches, err)
+			continue
+		}
+		var got []string
+		for _, r := range refs {
+			got = append(got, r.UUID)
+		}
+		slices.Sort(got)
+		if !slices.Equal(got, tt.want) {
+			t.Errorf("%v: got %v, want %v", tt.matches, got, tt.want)
+		}
+	}
+
+	if refs, err := s.SearchItemsMatching("missing", nil); err != nil || len(refs) != 0 {
+		t.Errorf("search of a missing collection = %v, %v", refs, err)
+	}
+	for _, m := range []AttrMatch{{Attribute: "url", Mode: "fuzzy", Pattern: "x"}, {Attribute: "url", Mode: MatchRegex, Pattern: "("}} {
+		if _, err := s.SearchItemsMatching("", []AttrMatch{m}); err == nil {
+			t.Errorf("%v: want an error", m)
+		}
+	}
+}
+
+func TestGlobRegexp(t *testing.T) {
+	tests := []struct {
+		glob, value string
+		want        bool
+	}{
+		{"*.example.com", "a.b.example.com", true},
+		{"*.example.com", "example.com", false},
+		{"a?c", "abc", true},
+		{"a?c", "ac", false},
+		{`a\*c`, "a*c", true},
+		{`a\*c`, "abc", false},
+		{"(x)+", "(x)+", true},
+		{"*", "multi\nline", true},
+	}
+	for _, tt := range tests {
+		m := AttrMatch{Attribute: "k", Mode: MatchGlob, Pattern: tt.glob}
+		pred, err := m.matcher()
+		if err != nil {
+			t.Fatalf("%q: %v", tt.glob, err)
+		}
+		if got := pred(tt.value); got != tt.want {
+			t.Errorf("glob %q on %q = %v, want %v", tt.glob, tt.value, got, tt.want)
+		}
+	}
+}
+
+func TestSearchItemsMatchingIgnoreCase(t *testing.T) {
+	s := NewMemory()
+	if err := s.CreateItem("login", "a", ItemMeta{Attributes: map[string]string{"host": "Git.Corp.Example.COM"}}); err != nil {
+		t.Fatal(err)
+	}
+	for _, m := range []AttrMatch{
+		{Attribute: "host", Mode: MatchExact, Pattern: "git.corp.example.com", IgnoreCase: true},
+		{Attribute: "host", Mode: MatchPrefix, Pattern: "GIT.corp", IgnoreCase: true},
+		{Attribute: "host", Mode: MatchGlob, Pattern: "*.example.com", IgnoreCase: true},
+		{Attribute: "host", Mode: MatchRegex, Pattern: "^git\\.", IgnoreCase: true},
+	} {
+		refs, err := s.SearchItemsMatching("", []AttrMatch{m})
+		if err != nil || len(refs) != 1 {
+			t.Errorf("%v: %v, %v, want the item", m, refs, err)
+		}
+		m.IgnoreCase = false
+		if refs, _ := s.SearchItemsMatching("", []AttrMatch{m}); len(refs) != 0 {
+			t.Errorf("%v: %v, want no match", m, refs)
+		}
+	}
+}
+
+func TestSearchItemsByLabel(t *testing.T) {
+	s := NewMemory()
+	if err := s.CreateCollection("work", "Work"); err != nil {
+		t.Fatal(err)
+	}
+	for _, it := range []struct{ col, uuid, label string }{
+		{"login", "a", "GitHub token"},
+		{"work", "b", "github.com/corp"},
+		{"login", "c", "Mail"},
+	} {
+		if err := s.CreateItem(it.col, it.uuid, ItemMeta{Label: it.label}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	tests := []struct {
+		substr     string
+		ignoreCase bool
+		want       []string
+	}{
+		{"github", true, []string{"a", "b"}},
+		{"github", false, []string{"b"}},
+		{"GITHUB", true, []string{"a", "b"}},
+		{"token", false, []string{"a"}},
+		{"", false, []string{"a", "b", "c"}},
+		{"none", true, nil},
+	}
+	for _, tt := range tests {
+		var got []string
+		for _, r := range s.SearchItemsByLabel(tt.substr, tt.ignoreCase) {
+			got = append(got, r.UUID)
+		}
+		slices.Sort(got)
+		if !slices.Equal(got, tt.want) {
+			t.Errorf("SearchItemsByLabel(%q, %v) = %v, want %v", tt.substr, tt.ignoreCase, got, tt.want)
+		}
+	}
+}
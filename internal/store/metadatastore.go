@@ -0,0 +1,79 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package store
+
+// MetadataStore is the metadata storage the service works on: collections,
+// items, aliases and attribute search. *Store, with its JSON and directory
+// formats, is the default implementation; others (a database, an encrypted
+// file) only need to provide these methods with the same semantics.
+//
+// Results of the getters must not be modified: their maps may be shared
+// with the store. Methods that change the store persist the change before
+// returning and fail if it could not be persisted. All methods are safe for
+// concurrent use.
+type MetadataStore interface {
+	// GetCollection returns the metadata of collection name.
+	GetCollection(name string) (CollectionMeta, bool)
+	// ListCollections returns all collection names.
+	ListCollections() []string
+	// CreateCollection adds an empty collection; it fails if name exists.
+	CreateCollection(name, label string) error
+	// UpdateCollectionLabel changes the label of an existing collection.
+	UpdateCollectionLabel(name, label string) error
+	// SetCollectionPassword sets the master password verifier of an
+	// existing collection; nil removes it.
+	SetCollectionPassword(name string, v *PasswordVerifier) error
+	// DeleteCollection removes a collection with its items and aliases.
+	DeleteCollection(name string) error
+	// TombstoneCollection is DeleteCollection recording, in the same
+	// change, a tombstone for each of targets.
+	TombstoneCollection(name string, targets []string) error
+
+	// GetItem returns the metadata of an item.
+	GetItem(collection, uuid string) (ItemMeta, bool)
+	// ListItems returns the UUIDs of the items in collection.
+	ListItems(collection string) []string
+	// CreateItem adds an item to an existing collection.
+	CreateItem(collection, uuid string, meta ItemMeta) error
+	// UpdateItem replaces the metadata of an existing item.
+	UpdateItem(collection, uuid string, meta ItemMeta) error
+	// RecordAccesses adds reads to the LastAccessed and AccessCount of
+	// their items, leaving Modified alone.
+	RecordAccesses(accesses []ItemAccess) error
+	// DeleteItem removes an item.
+	DeleteItem(collection, uuid string) error
+	// TombstoneItem is DeleteItem recording, in the same change, a
+	// tombstone for target.
+	TombstoneItem(collection, uuid, target string) error
+
+	// Tombstones returns the backend targets of deletes that may not have
+	// removed their secrets yet.
+	Tombstones() map[string]Tombstone
+	// ClearTombstones removes the tombstones of targets.
+	ClearTombstones(targets []string) error
+
+	// SearchItems returns the items, in any collection, whose attributes
+	// include all of attrs; an empty attrs matches every item.
+	SearchItems(attrs map[string]string) []ItemRef
+	// SearchItemsInCollection is SearchItems restricted to collection.
+	SearchItemsInCollection(collection string, attrs map[string]string) []ItemRef
+	// SearchItemsMatching returns the items, in collection or in any
+	// collection if it is "", whose attributes match all of matches; it
+	// fails for unknown modes and invalid patterns.
+	SearchItemsMatching(collection string, matches []AttrMatch) ([]ItemRef, error)
+	// SearchItemsByLabel returns the items, in any collection, whose label
+	// contains substr, optionally compared case-insensitively.
+	SearchItemsByLabel(substr string, ignoreCase bool) []ItemRef
+	// TargetInUse reports whether an item records target as its backend
+	// target.
+	TargetInUse(target string) bool
+
+	// GetAlias returns the collection alias name refers to, or "".
+	GetAlias(name string) string
+	// ListAliases returns all aliases with their collections.
+	ListAliases() map[string]string
+	// SetAlias points alias name at collection; "" removes it.
+	SetAlias(name, collection string) error
+}
+
+var _ MetadataStore = (*Store)(nil)
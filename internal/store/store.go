@@ -6,14 +6,19 @@
 package store
 
 import (
-	"encoding/json"
+	"errors"
 	"fmt"
+	"maps"
 	"os"
 	"path/filepath"
 	"sync"
 	"time"
+
+	"github.com/akihiro/wsl-secret-service/internal/logging"
 )
 
+var logger = logging.For(logging.Store)
+
 // ItemMeta holds the metadata for a single secret item.
 type ItemMeta struct {
 	Label       string            `json:"label"`
@@ -21,6 +26,28 @@ type ItemMeta struct {
 	Created     uint64            `json:"created"`
 	Modified    uint64            `json:"modified"`
 	ContentType string            `json:"content_type"`
+	// Checksum is a keyed hash of the secret value (see service.secretChecksum),
+	// letting clients detect changes without fetching the secret itself.
+	Checksum string `json:"checksum,omitempty"`
+	// Target is the backend target (Credential Manager TargetName) holding
+	// the secret. Empty means the default wsl-ss/<collection>/<uuid>.
+	Target string `json:"target,omitempty"`
+	// Creator identifies the client that created the item. Nil for items
+	// created by the daemon itself or before creators were recorded.
+	Creator *Creator `json:"creator,omitempty"`
+	// LastAccessed is when the secret was last read (unix seconds, 0 if
+	// never) and AccessCount how often, as recorded by RecordAccesses.
+	LastAccessed uint64 `json:"last_accessed,omitempty"`
+	AccessCount  uint64 `json:"access_count,omitempty"`
+}
+
+// Creator describes the process that called CreateItem.
+type Creator struct {
+	Exe string  `json:"exe,omitempty"` // executable path; "" if unknown
+	UID *uint32 `json:"uid,omitempty"` // unix user ID; nil if unknown
+	// AppID is the Flatpak or Snap application, e.g. "flatpak:org.gnome.Evolution";
+	// "" for processes outside a sandbox.
+	AppID string `json:"app_id,omitempty"`
 }
 
 // CollectionMeta holds the metadata for a collection of items.
@@ -29,13 +56,44 @@ type CollectionMeta struct {
 	Created  uint64              `json:"created"`
 	Modified uint64              `json:"modified"`
 	Items    map[string]ItemMeta `json:"items"`
+	// Password verifies the master password of a password-protected
+	// collection. Nil for collections without a password.
+	Password *PasswordVerifier `json:"password,omitempty"`
+}
+
+// PasswordVerifier holds the Argon2id parameters and the derived key that a
+// collection's master password must reproduce. The password itself is never
+// stored.
+type PasswordVerifier struct {
+	Salt    []byte `json:"salt"`
+	Time    uint32 `json:"time"`
+	Memory  uint32 `json:"memory"` // KiB
+	Threads uint8  `json:"threads"`
+	Hash    []byte `json:"hash"`
+}
+
+// Tombstone records that the item, or collection, whose secret is kept in a
+// backend target was deleted while the secret may not have been: deletes
+// remove the metadata first, together with tombstones for the targets, then
+// the secrets, then the tombstones. A tombstone left by a crash or a backend
+// failure in between tells the service to finish the delete.
+type Tombstone struct {
+	Collection string `json:"collection"`
+	// UUID is the deleted item; "" if the whole collection was deleted.
+	UUID    string `json:"uuid,omitempty"`
+	Deleted uint64 `json:"deleted"`
 }
 
 // storeData is the top-level JSON structure persisted to disk.
 type storeData struct {
-	Version     int                       `json:"version"`
+	Version int `json:"version"`
+	// Checksum is the SHA-256 of metadata.json (see marshalSealed); it is
+	// not kept up to date in memory.
+	Checksum    string                    `json:"checksum,omitempty"`
 	Collections map[string]CollectionMeta `json:"collections"`
 	Aliases     map[string]string         `json:"aliases"`
+	// Tombstones maps backend targets to the deletes that left them.
+	Tombstones map[string]Tombstone `json:"tombstones,omitempty"`
 }
 
 // ItemRef identifies an item by collection name and UUID.
@@ -44,78 +102,240 @@ type ItemRef struct {
 	UUID       string
 }
 
-// Store provides thread-safe access to Secret Service metadata.
+// ItemAccess counts the reads of the secret of an item: Count reads, the
+// last one at Time (unix seconds).
+type ItemAccess struct {
+	Collection string `json:"collection"`
+	UUID       string `json:"uuid"`
+	Time       uint64 `json:"time"`
+	Count      uint64 `json:"count"`
+}
+
+// Store provides thread-safe access to Secret Service metadata. The state
+// is held in memory and persisted on every change by a format.
 type Store struct {
-	path string
-	mu   sync.RWMutex
-	data storeData
+	format format
+	mu     sync.RWMutex
+	data   storeData
+	index  attrIndex
+
+	// lock guards writes against other processes; gen is the generation
+	// of the metadata this store last loaded or wrote.
+	lock *storeLock
+	gen  uint64
+
+	backups backups
+}
+
+// Storage formats accepted by Open.
+const (
+	// FormatJSON keeps everything in metadata.json, rewritten on every
+	// change, with a write-ahead journal (metadata.journal).
+	FormatJSON = "json"
+	// FormatBolt keeps the records in a bbolt database, metadata.db, and
+	// writes each change in one transaction, so a change rewrites only the
+	// records it touches.
+	FormatBolt = "bolt"
+)
+
+// format persists the store's state. Its methods are called with the
+// store's write lock held (or before the store is shared).
+type format interface {
+	// load reads the persisted state into d. A store that does not exist
+	// yet leaves d empty.
+	load(d *storeData) error
+	// commit applies e, already checked, to d, a copy of the state made by
+	// cloneFor, and makes the change durable. The store takes d as its
+	// state only if commit succeeds.
+	commit(d *storeData, e journalEntry) error
+	// flush writes the complete state.
+	flush(d *storeData) error
+	// changed reports whether the files were modified, by a process not
+	// taking the store lock, since the last load, commit or flush.
+	changed() bool
+	// setAside moves the files out of the way, to be replaced by a flush,
+	// and returns where they went.
+	setAside() (string, error)
 }
 
 // New creates (or loads) the metadata store at configDir/metadata.json.
 // If the store is new, it creates a default "login" collection with the "default" alias.
 func New(configDir string) (*Store, error) {
+	return Open(configDir, FormatJSON)
+}
+
+// Open creates (or loads) the metadata store in configDir using the given
+// storage format. Opening a FormatBolt store in a directory that holds
+// only metadata.json migrates it. If the store is new, it creates a default
+// "login" collection with the "default" alias.
+func Open(configDir, storageFormat string) (*Store, error) {
 	if err := os.MkdirAll(configDir, 0o700); err != nil {
 		return nil, fmt.Errorf("create config dir: %w", err)
 	}
 
-	s := &Store{
-		path: filepath.Join(configDir, "metadata.json"),
-		data: storeData{
-			Version:     1,
-			Collections: make(map[string]CollectionMeta),
-			Aliases:     make(map[string]string),
-		},
+	s := newStore()
+	s.backups.dir = filepath.Join(configDir, backupDirName)
+	var err error
+	if s.lock, err = openStoreLock(configDir); err != nil {
+		return nil, err
+	}
+	// Hold the lock while loading, so that a migration or the initial
+	// metadata is not written concurrently with another instance.
+	if s.gen, err = s.lock.lock(); err != nil {
+		return nil, err
+	}
+	defer s.lock.unlock()
+
+	switch storageFormat {
+	case FormatJSON, "":
+		s.format = newJSONFile(configDir)
+	case FormatBolt:
+		f, err := openBolt(configDir)
+		if err != nil {
+			return nil, err
+		}
+		s.format = f
+	default:
+		return nil, fmt.Errorf("unknown metadata store format %q (want %q or %q)", storageFormat, FormatJSON, FormatBolt)
 	}
 
-	if err := s.load(); err != nil && !os.IsNotExist(err) {
-		return nil, fmt.Errorf("load metadata: %w", err)
+	restored := false
+	if err := s.format.load(&s.data); err != nil {
+		if !errors.Is(err, ErrCorrupt) {
+			return nil, fmt.Errorf("load metadata: %w", err)
+		}
+		if rerr := s.restoreBackup(err); rerr != nil {
+			return nil, fmt.Errorf("load metadata: %w (%w)", err, rerr)
+		}
+		restored = true
 	}
+	s.index.build(&s.data)
 
 	// Ensure the "login" collection and "default" alias always exist.
-	if _, ok := s.data.Collections["login"]; !ok {
-		now := uint64(time.Now().Unix())
-		s.data.Collections["login"] = CollectionMeta{
-			Label:    "Login",
-			Created:  now,
-			Modified: now,
-			Items:    make(map[string]ItemMeta),
-		}
-		s.data.Aliases["default"] = "login"
-		if err := s.save(); err != nil {
+	if s.addLogin() || restored {
+		if err := s.format.flush(&s.data); err != nil {
 			return nil, fmt.Errorf("save initial metadata: %w", err)
 		}
+		s.gen++
+		if err := s.lock.setGeneration(s.gen); err != nil {
+			return nil, err
+		}
 	}
 
 	return s, nil
 }
 
-func (s *Store) load() error {
-	data, err := os.ReadFile(s.path)
-	if err != nil {
-		return err
+// NewMemory returns a store that keeps its metadata in memory only:
+// nothing is read from or written to disk, and everything is lost with the
+// store. It starts with the "login" collection and the "default" alias.
+func NewMemory() *Store {
+	s := newStore()
+	s.format = memoryFormat{}
+	s.lock = &storeLock{}
+	s.addLogin()
+	return s
+}
+
+func newStore() *Store {
+	return &Store{
+		data: storeData{
+			Version:     1,
+			Collections: make(map[string]CollectionMeta),
+			Aliases:     make(map[string]string),
+		},
+		index: make(attrIndex),
 	}
-	return json.Unmarshal(data, &s.data)
 }
 
-// save writes metadata.json atomically via a temp file + rename.
-// Caller must hold s.mu (write lock).
-func (s *Store) save() error {
-	data, err := json.MarshalIndent(s.data, "", "  ")
-	if err != nil {
-		return fmt.Errorf("marshal metadata: %w", err)
+// addLogin creates the "login" collection and the "default" alias unless
+// the collection exists, and reports whether it did.
+func (s *Store) addLogin() bool {
+	if _, ok := s.data.Collections["login"]; ok {
+		return false
 	}
-	tmp := s.path + ".tmp"
-	if err := os.WriteFile(tmp, data, 0o600); err != nil {
-		return fmt.Errorf("write tmp metadata: %w", err)
+	now := uint64(time.Now().Unix())
+	s.data.Collections["login"] = CollectionMeta{
+		Label:    "Login",
+		Created:  now,
+		Modified: now,
+		Items:    make(map[string]ItemMeta),
 	}
-	return os.Rename(tmp, s.path)
+	s.data.Aliases["default"] = "login"
+	return true
 }
 
+// memoryFormat is the format of NewMemory stores, which persist nothing.
+type memoryFormat struct{}
+
+func (memoryFormat) load(*storeData) error                     { return nil }
+func (memoryFormat) commit(d *storeData, e journalEntry) error { return d.apply(e) }
+func (memoryFormat) flush(*storeData) error                    { return nil }
+func (memoryFormat) changed() bool                             { return false }
+func (memoryFormat) setAside() (string, error)                 { return "", nil }
+
 // Save persists current state to disk.
 func (s *Store) Save() error {
 	s.mu.Lock()
 	defer s.mu.Unlock()
-	return s.save()
+	if err := s.lockFresh(); err != nil {
+		return err
+	}
+	defer s.lock.unlock()
+	s.backupIfDue()
+	if err := s.format.flush(&s.data); err != nil {
+		return err
+	}
+	return s.bumpGeneration()
+}
+
+// lockFresh takes the store lock, failing with ErrModifiedExternally if
+// another process changed the metadata since this store last loaded or
+// wrote it. On success the caller must release s.lock.
+// Caller must hold s.mu (write lock).
+func (s *Store) lockFresh() error {
+	gen, err := s.lock.lock()
+	if err != nil {
+		return err
+	}
+	if gen != s.gen || s.format.changed() {
+		s.lock.unlock()
+		logger.Warn("refusing to overwrite metadata changed by another process")
+		return ErrModifiedExternally
+	}
+	return nil
+}
+
+// bumpGeneration records a write by this store.
+// Caller must hold s.mu and s.lock.
+func (s *Store) bumpGeneration() error {
+	s.gen++
+	return s.lock.setGeneration(s.gen)
+}
+
+// commit checks e against the current state and hands it to the format,
+// which applies and persists it.
+// Caller must hold s.mu (write lock).
+func (s *Store) commit(e journalEntry) error {
+	if err := s.data.check(e); err != nil {
+		return err
+	}
+	if err := s.lockFresh(); err != nil {
+		return err
+	}
+	defer s.lock.unlock()
+	s.backupIfDue()
+	affected := affectedItems(&s.data, e)
+	next := s.data.cloneFor(e)
+	if err := s.format.commit(next, e); err != nil {
+		return err
+	}
+	s.data = *next
+	s.index.reindex(&s.data, affected)
+	if err := s.bumpGeneration(); err != nil {
+		return err
+	}
+	logger.Debug("metadata changed", "op", e.Op, "collection", e.Collection, "uuid", e.UUID)
+	return nil
 }
 
 // --- Collections ---
@@ -143,48 +363,56 @@ func (s *Store) ListCollections() []string {
 func (s *Store) CreateCollection(name, label string) error {
 	s.mu.Lock()
 	defer s.mu.Unlock()
-	if _, ok := s.data.Collections[name]; ok {
-		return fmt.Errorf("collection %q already exists", name)
-	}
-	now := uint64(time.Now().Unix())
-	s.data.Collections[name] = CollectionMeta{
-		Label:    label,
-		Created:  now,
-		Modified: now,
-		Items:    make(map[string]ItemMeta),
-	}
-	return s.save()
+	return s.commit(journalEntry{
+		Op:         opCreateCollection,
+		Collection: name,
+		Label:      label,
+		Time:       uint64(time.Now().Unix()),
+	})
 }
 
 // UpdateCollectionLabel updates the label of an existing collection.
 func (s *Store) UpdateCollectionLabel(name, label string) error {
 	s.mu.Lock()
 	defer s.mu.Unlock()
-	c, ok := s.data.Collections[name]
-	if !ok {
-		return fmt.Errorf("collection %q not found", name)
-	}
-	c.Label = label
-	c.Modified = uint64(time.Now().Unix())
-	s.data.Collections[name] = c
-	return s.save()
+	return s.commit(journalEntry{
+		Op:         opUpdateCollection,
+		Collection: name,
+		Label:      label,
+		Time:       uint64(time.Now().Unix()),
+	})
+}
+
+// SetCollectionPassword sets the master password verifier of an existing
+// collection. A nil v removes the password.
+func (s *Store) SetCollectionPassword(name string, v *PasswordVerifier) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.commit(journalEntry{
+		Op:         opSetPassword,
+		Collection: name,
+		Password:   v,
+		Time:       uint64(time.Now().Unix()),
+	})
 }
 
 // DeleteCollection removes a collection and all its items.
 func (s *Store) DeleteCollection(name string) error {
+	return s.TombstoneCollection(name, nil)
+}
+
+// TombstoneCollection removes a collection and all its items like
+// DeleteCollection and, in the same change, records a tombstone for each of
+// targets, the backend targets of its items.
+func (s *Store) TombstoneCollection(name string, targets []string) error {
 	s.mu.Lock()
 	defer s.mu.Unlock()
-	if _, ok := s.data.Collections[name]; !ok {
-		return fmt.Errorf("collection %q not found", name)
-	}
-	delete(s.data.Collections, name)
-	// Remove any aliases pointing to this collection.
-	for alias, target := range s.data.Aliases {
-		if target == name {
-			delete(s.data.Aliases, alias)
-		}
-	}
-	return s.save()
+	return s.commit(journalEntry{
+		Op:         opDeleteCollection,
+		Collection: name,
+		Targets:    targets,
+		Time:       uint64(time.Now().Unix()),
+	})
 }
 
 // --- Items ---
@@ -220,10 +448,6 @@ func (s *Store) ListItems(collection string) []string {
 func (s *Store) CreateItem(collection, uuid string, meta ItemMeta) error {
 	s.mu.Lock()
 	defer s.mu.Unlock()
-	c, ok := s.data.Collections[collection]
-	if !ok {
-		return fmt.Errorf("collection %q not found", collection)
-	}
 	if meta.Attributes == nil {
 		meta.Attributes = make(map[string]string)
 	}
@@ -232,45 +456,97 @@ func (s *Store) CreateItem(collection, uuid string, meta ItemMeta) error {
 		meta.Created = now
 	}
 	meta.Modified = now
-	c.Items[uuid] = meta
-	c.Modified = now
-	s.data.Collections[collection] = c
-	return s.save()
+	return s.commit(journalEntry{
+		Op:         opCreateItem,
+		Collection: collection,
+		UUID:       uuid,
+		Item:       &meta,
+		Time:       now,
+	})
 }
 
 // UpdateItem replaces the metadata for an existing item.
 func (s *Store) UpdateItem(collection, uuid string, meta ItemMeta) error {
 	s.mu.Lock()
 	defer s.mu.Unlock()
-	c, ok := s.data.Collections[collection]
-	if !ok {
-		return fmt.Errorf("collection %q not found", collection)
-	}
-	if _, ok := c.Items[uuid]; !ok {
-		return fmt.Errorf("item %q not found in collection %q", uuid, collection)
-	}
 	meta.Modified = uint64(time.Now().Unix())
-	c.Items[uuid] = meta
-	c.Modified = meta.Modified
-	s.data.Collections[collection] = c
-	return s.save()
+	return s.commit(journalEntry{
+		Op:         opUpdateItem,
+		Collection: collection,
+		UUID:       uuid,
+		Item:       &meta,
+		Time:       meta.Modified,
+	})
+}
+
+// RecordAccesses adds the reads of accesses to the LastAccessed and
+// AccessCount of their items, in one change. Unlike UpdateItem, it leaves
+// Modified alone. Accesses to items that no longer exist are ignored.
+func (s *Store) RecordAccesses(accesses []ItemAccess) error {
+	if len(accesses) == 0 {
+		return nil
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.commit(journalEntry{
+		Op:       opRecordAccesses,
+		Accesses: accesses,
+		Time:     uint64(time.Now().Unix()),
+	})
 }
 
 // DeleteItem removes an item from a collection.
 func (s *Store) DeleteItem(collection, uuid string) error {
 	s.mu.Lock()
 	defer s.mu.Unlock()
-	c, ok := s.data.Collections[collection]
-	if !ok {
-		return fmt.Errorf("collection %q not found", collection)
+	return s.commit(journalEntry{
+		Op:         opDeleteItem,
+		Collection: collection,
+		UUID:       uuid,
+		Time:       uint64(time.Now().Unix()),
+	})
+}
+
+// TombstoneItem removes an item like DeleteItem and, in the same change,
+// records a tombstone for target, the backend target of its secret.
+func (s *Store) TombstoneItem(collection, uuid, target string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.commit(journalEntry{
+		Op:         opDeleteItem,
+		Collection: collection,
+		UUID:       uuid,
+		Targets:    []string{target},
+		Time:       uint64(time.Now().Unix()),
+	})
+}
+
+// Tombstones returns the tombstones, keyed by backend target.
+func (s *Store) Tombstones() map[string]Tombstone {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return maps.Clone(s.data.Tombstones)
+}
+
+// ClearTombstones removes the tombstones of targets, whose secrets are
+// gone. Targets without a tombstone are ignored.
+func (s *Store) ClearTombstones(targets []string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var present []string
+	for _, t := range targets {
+		if _, ok := s.data.Tombstones[t]; ok {
+			present = append(present, t)
+		}
 	}
-	if _, ok := c.Items[uuid]; !ok {
-		return fmt.Errorf("item %q not found in collection %q", uuid, collection)
+	if len(present) == 0 {
+		return nil
 	}
-	delete(c.Items, uuid)
-	c.Modified = uint64(time.Now().Unix())
-	s.data.Collections[collection] = c
-	return s.save()
+	return s.commit(journalEntry{
+		Op:      opClearTombstones,
+		Targets: present,
+		Time:    uint64(time.Now().Unix()),
+	})
 }
 
 // SearchItems finds all items whose attributes are a superset of attrs.
@@ -278,6 +554,9 @@ func (s *Store) DeleteItem(collection, uuid string) error {
 func (s *Store) SearchItems(attrs map[string]string) []ItemRef {
 	s.mu.RLock()
 	defer s.mu.RUnlock()
+	if len(attrs) > 0 {
+		return s.index.search(&s.data, "", attrs)
+	}
 	var results []ItemRef
 	for colName, col := range s.data.Collections {
 		for uuid, item := range col.Items {
@@ -297,6 +576,9 @@ func (s *Store) SearchItemsInCollection(collection string, attrs map[string]stri
 	if !ok {
 		return nil
 	}
+	if len(attrs) > 0 {
+		return s.index.search(&s.data, collection, attrs)
+	}
 	var results []ItemRef
 	for uuid, item := range col.Items {
 		if matchesAll(item.Attributes, attrs) {
@@ -306,6 +588,20 @@ func (s *Store) SearchItemsInCollection(collection string, attrs map[string]stri
 	return results
 }
 
+// TargetInUse reports whether any item records target as its backend target.
+func (s *Store) TargetInUse(target string) bool {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	for _, col := range s.data.Collections {
+		for _, item := range col.Items {
+			if item.Target == target {
+				return true
+			}
+		}
+	}
+	return false
+}
+
 // matchesAll returns true if itemAttrs contains all key/value pairs in want.
 func matchesAll(itemAttrs, want map[string]string) bool {
 	for k, v := range want {
@@ -341,13 +637,10 @@ func (s *Store) ListAliases() map[string]string {
 func (s *Store) SetAlias(name, collection string) error {
 	s.mu.Lock()
 	defer s.mu.Unlock()
-	if collection == "" {
-		delete(s.data.Aliases, name)
-	} else {
-		if _, ok := s.data.Collections[collection]; !ok {
-			return fmt.Errorf("collection %q not found", collection)
-		}
-		s.data.Aliases[name] = collection
-	}
-	return s.save()
+	return s.commit(journalEntry{
+		Op:         opSetAlias,
+		Alias:      name,
+		Collection: collection,
+		Time:       uint64(time.Now().Unix()),
+	})
 }
@@ -3,6 +3,7 @@
 package store
 
 import (
+	"errors"
 	"os"
 	"path/filepath"
 	"testing"
@@ -166,6 +167,82 @@ func TestSearchItemsInCollection(t *testing.T) {
 	}
 }
 
+func TestSearchIndexFollowsChanges(t *testing.T) {
+	dir := t.TempDir()
+	s, _ := New(dir)
+	_ = s.CreateCollection("work", "Work")
+	_ = s.CreateItem("login", "u1", ItemMeta{Attributes: map[string]string{"svc": "a"}})
+	_ = s.CreateItem("work", "u2", ItemMeta{Attributes: map[string]string{"svc": "a"}})
+
+	meta, _ := s.GetItem("login", "u1")
+	meta.Attributes["svc"] = "b"
+	_ = s.UpdateItem("login", "u1", meta)
+	if refs := s.SearchItems(map[string]string{"svc": "a"}); len(refs) != 1 || refs[0].UUID != "u2" {
+		t.Errorf("svc=a after update: got %v, want work/u2", refs)
+	}
+	if refs := s.SearchItems(map[string]string{"svc": "b"}); len(refs) != 1 || refs[0].UUID != "u1" {
+		t.Errorf("svc=b after update: got %v, want login/u1", refs)
+	}
+
+	_ = s.DeleteCollection("work")
+	if refs := s.SearchItems(map[string]string{"svc": "a"}); len(refs) != 0 {
+		t.Errorf("svc=a after deleting its collection: got %v", refs)
+	}
+
+	// The index is rebuilt on load.
+	s2, _ := New(dir)
+	if refs := s2.SearchItemsInCollection("login", map[string]string{"svc": "b"}); len(refs) != 1 || refs[0].UUID != "u1" {
+		t.Errorf("svc=b after reload: got %v, want login/u1", refs)
+	}
+	_ = s2.DeleteItem("login", "u1")
+	if refs := s2.SearchItems(map[string]string{"svc": "b"}); len(refs) != 0 {
+		t.Errorf("svc=b after delete: got %v", refs)
+	}
+	if len(s2.index) != 0 {
+		t.Errorf("index not empty after deleting every item: %v", s2.index)
+	}
+}
+
+func TestWriteAfterExternalChangeFails(t *testing.T) {
+	for _, format := range []string{FormatJSON, FormatBolt} {
+		t.Run(format, func(t *testing.T) {
+			dir := t.TempDir()
+			s1, err := Open(dir, format)
+			if err != nil {
+				t.Fatal(err)
+			}
+			s2, err := Open(dir, format)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := s1.CreateCollection("work", "Work"); err != nil {
+				t.Fatalf("first writer: %v", err)
+			}
+			if err := s2.CreateCollection("home", "Home"); !errors.Is(err, ErrModifiedExternally) {
+				t.Fatalf("stale writer: err = %v, want ErrModifiedExternally", err)
+			}
+			if err := s1.CreateCollection("home", "Home"); err != nil {
+				t.Fatalf("first writer again: %v", err)
+			}
+		})
+	}
+}
+
+func TestWriteAfterExternalEditFails(t *testing.T) {
+	s := newTestStore(t)
+	path := s.format.(*jsonFile).path
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, append(data, '\n'), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.CreateCollection("work", "Work"); !errors.Is(err, ErrModifiedExternally) {
+		t.Fatalf("err = %v, want ErrModifiedExternally", err)
+	}
+}
+
 func TestAliases(t *testing.T) {
 	s := newTestStore(t)
 	_ = s.CreateCollection("work", "Work")
@@ -191,8 +268,275 @@ func TestAtomicSave(t *testing.T) {
 	_ = s.CreateCollection("col", "Col")
 
 	// No .tmp file should remain after save.
-	tmpPath := filepath.Join(filepath.Dir(s.path), "metadata.json.tmp")
+	tmpPath := s.format.(*jsonFile).path + ".tmp"
 	if _, err := os.Stat(tmpPath); !os.IsNotExist(err) {
 		t.Error(".tmp file was left behind after atomic save")
 	}
 }
+
+func TestJournalReplay(t *testing.T) {
+	dir := t.TempDir()
+	s, err := New(dir)
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+
+	// Simulate a crash after the intent was journaled but before the
+	// checkpoint into metadata.json: append entries without applying them.
+	jf := s.format.(*jsonFile)
+	meta := ItemMeta{Label: "Journaled", Attributes: map[string]string{"k": "v"}}
+	if err := jf.appendJournal(journalEntry{Op: opCreateItem, Collection: "login", UUID: "u1", Item: &meta, Time: 1}); err != nil {
+		t.Fatalf("appendJournal: %v", err)
+	}
+	// A torn final line must be ignored.
+	f, err := os.OpenFile(jf.journalPath, os.O_WRONLY|os.O_APPEND, 0o600)
+	if err != nil {
+		t.Fatalf("open journal: %v", err)
+	}
+	_, _ = f.WriteString(`{"op":"create_item","collec`)
+	_ = f.Close()
+
+	s2, err := New(dir)
+	if err != nil {
+		t.Fatalf("reload: %v", err)
+	}
+	got, ok := s2.GetItem("login", "u1")
+	if !ok || got.Label != "Journaled" {
+		t.Fatalf("journaled item not replayed: %+v, %v", got, ok)
+	}
+	if _, err := os.Stat(jf.journalPath); !os.IsNotExist(err) {
+		t.Error("journal should be removed after checkpoint")
+	}
+
+	// The replayed item must now be part of metadata.json itself.
+	s3, err := New(dir)
+	if err != nil {
+		t.Fatalf("second reload: %v", err)
+	}
+	if _, ok := s3.GetItem("login", "u1"); !ok {
+		t.Error("replayed item lost after checkpoint")
+	}
+}
+
+func TestTargetInUse(t *testing.T) {
+	s := newTestStore(t)
+	_ = s.CreateItem("login", "u1", ItemMeta{Label: "GitHub", Target: "wsl-ss/Login/GitHub"})
+	_ = s.CreateItem("login", "u2", ItemMeta{Label: "Legacy"})
+
+	if !s.TargetInUse("wsl-ss/Login/GitHub") {
+		t.Error("recorded target should be in use")
+	}
+	if s.TargetInUse("wsl-ss/Login/GitHub (2)") {
+		t.Error("unrecorded target should not be in use")
+	}
+}
+
+func TestCreatorPersists(t *testing.T) {
+	dir := t.TempDir()
+	s1, _ := New(dir)
+	uid := uint32(1000)
+	creator := &Creator{Exe: "/usr/bin/secret-tool", UID: &uid}
+	if err := s1.CreateItem("login", "u1", ItemMeta{Label: "x", Creator: creator}); err != nil {
+		t.Fatal(err)
+	}
+
+	s2, err := New(dir)
+	if err != nil {
+		t.Fatalf("reload: %v", err)
+	}
+	meta, ok := s2.GetItem("login", "u1")
+	if !ok {
+		t.Fatal("item not found after reload")
+	}
+	if meta.Creator == nil || meta.Creator.Exe != creator.Exe || meta.Creator.UID == nil || *meta.Creator.UID != uid {
+		t.Errorf("Creator = %+v, want %+v", meta.Creator, creator)
+	}
+}
+
+func TestCollectionPasswordPersists(t *testing.T) {
+	for _, format := range []string{FormatJSON, FormatBolt} {
+		dir := t.TempDir()
+		s1, err := Open(dir, format)
+		if err != nil {
+			t.Fatalf("Open(%s): %v", format, err)
+		}
+		v := &PasswordVerifier{Salt: []byte("salt"), Time: 2, Memory: 19456, Threads: 1, Hash: []byte("hash")}
+		if err := s1.SetCollectionPassword("login", v); err != nil {
+			t.Fatal(err)
+		}
+		if err := s1.SetCollectionPassword("missing", v); err == nil {
+			t.Errorf("%s: setting the password of a missing collection should fail", format)
+		}
+
+		s2, err := Open(dir, format)
+		if err != nil {
+			t.Fatalf("reload: %v", err)
+		}
+		col, _ := s2.GetCollection("login")
+		if col.Password == nil || string(col.Password.Hash) != "hash" || col.Password.Memory != 19456 {
+			t.Errorf("%s: Password after reload = %+v", format, col.Password)
+		}
+		if err := s2.SetCollectionPassword("login", nil); err != nil {
+			t.Fatal(err)
+		}
+		s3, _ := Open(dir, format)
+		if col, _ := s3.GetCollection("login"); col.Password != nil {
+			t.Errorf("%s: removed password came back: %+v", format, col.Password)
+		}
+	}
+}
+
+func TestRecordAccessesPersists(t *testing.T) {
+	for _, format := range []string{FormatJSON, FormatBolt} {
+		dir := t.TempDir()
+		s1, err := Open(dir, format)
+		if err != nil {
+			t.Fatalf("Open(%s): %v", format, err)
+		}
+		if err := s1.CreateItem("login", "u1", ItemMeta{Label: "x"}); err != nil {
+			t.Fatal(err)
+		}
+		before, _ := s1.GetItem("login", "u1")
+		for _, at := range []uint64{200, 100} {
+			err := s1.RecordAccesses([]ItemAccess{
+				{Collection: "login", UUID: "u1", Time: at, Count: 1},
+				{Collection: "login", UUID: "missing", Time: at, Count: 1},
+			})
+			if err != nil {
+				t.Fatalf("%s: %v", format, err)
+			}
+		}
+
+		s2, err := Open(dir, format)
+		if err != nil {
+			t.Fatalf("reload: %v", err)
+		}
+		meta, _ := s2.GetItem("login", "u1")
+		if meta.AccessCount != 2 || meta.LastAccessed != 200 {
+			t.Errorf("%s: AccessCount = %d, LastAccessed = %d, want 2 and 200", format, meta.AccessCount, meta.LastAccessed)
+		}
+		if _, ok := s2.GetItem("login", "missing"); ok {
+			t.Errorf("%s: recording an access created a missing item", format)
+		}
+		if meta.Modified != before.Modified {
+			t.Errorf("%s: Modified changed from %d to %d", format, before.Modified, meta.Modified)
+		}
+	}
+}
+
+func TestTombstonesPersist(t *testing.T) {
+	for _, format := range []string{FormatJSON, FormatBolt} {
+		dir := t.TempDir()
+		s1, err := Open(dir, format)
+		if err != nil {
+			t.Fatalf("Open(%s): %v", format, err)
+		}
+		if err := s1.CreateItem("login", "u1", ItemMeta{Label: "x"}); err != nil {
+			t.Fatal(err)
+		}
+		if err := s1.CreateCollection("work", "Work"); err != nil {
+			t.Fatal(err)
+		}
+		if err := s1.TombstoneItem("login", "u1", "wsl-ss/login/u1"); err != nil {
+			t.Fatal(err)
+		}
+		if err := s1.TombstoneCollection("work", []string{"wsl-ss/work/a", "wsl-ss/work/b"}); err != nil {
+			t.Fatal(err)
+		}
+		if err := s1.TombstoneItem("login", "missing", "wsl-ss/login/missing"); err == nil {
+			t.Errorf("%s: tombstoning a missing item should fail", format)
+		}
+
+		s2, err := Open(dir, format)
+		if err != nil {
+			t.Fatalf("reload: %v", err)
+		}
+		if _, ok := s2.GetItem("login", "u1"); ok {
+			t.Errorf("%s: tombstoned item still exists", format)
+		}
+		got := s2.Tombstones()
+		want := map[string]Tombstone{
+			"wsl-ss/login/u1": {Collection: "login", UUID: "u1"},
+			"wsl-ss/work/a":   {Collection: "work"},
+			"wsl-ss/work/b":   {Collection: "work"},
+		}
+		if len(got) != len(want) {
+			t.Fatalf("%s: Tombstones() = %v, want %v", format, got, want)
+		}
+		for target, w := range want {
+			if g := got[target]; g.Collection != w.Collection || g.UUID != w.UUID || g.Deleted == 0 {
+				t.Errorf("%s: tombstone of %s = %+v, want %+v", format, target, g, w)
+			}
+		}
+
+		if err := s2.ClearTombstones([]string{"wsl-ss/login/u1", "wsl-ss/work/a", "unknown"}); err != nil {
+			t.Fatal(err)
+		}
+		s3, _ := Open(dir, format)
+		if got := s3.Tombstones(); len(got) != 1 || got["wsl-ss/work/b"].Collection != "work" {
+			t.Errorf("%s: Tombstones() after clearing = %v, want only wsl-ss/work/b", format, got)
+		}
+	}
+}
+
+func TestNewMemory(t *testing.T) {
+	dir := t.TempDir()
+	t.Chdir(dir)
+	s := NewMemory()
+	if s.GetAlias("default") != "login" {
+		t.Fatalf("default alias = %q, want login", s.GetAlias("default"))
+	}
+	for i, id := range []string{"a", "b"} {
+		if err := s.CreateItem("login", id, ItemMeta{Label: id, Attributes: map[string]string{"n": id}}); err != nil {
+			t.Fatalf("CreateItem %d: %v", i, err)
+		}
+	}
+	if refs := s.SearchItems(map[string]string{"n": "b"}); len(refs) != 1 || refs[0].UUID != "b" {
+		t.Errorf("SearchItems = %+v", refs)
+	}
+	if err := s.DeleteItem("login", "a"); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Save(); err != nil {
+		t.Fatal(err)
+	}
+	if entries, _ := os.ReadDir(dir); len(entries) != 0 {
+		t.Errorf("memory store wrote %d files", len(entries))
+	}
+}
+
+func TestFailedCommitLeavesState(t *testing.T) {
+	for format, block := range map[string]func(dir string) error{
+		// The journal cannot be opened.
+		FormatJSON: func(dir string) error {
+			return os.Mkdir(filepath.Join(dir, "metadata.journal"), 0o700)
+		},
+		// The database cannot be opened.
+		FormatBolt: func(dir string) error {
+			path := filepath.Join(dir, boltFileName)
+			if err := os.Remove(path); err != nil {
+				return err
+			}
+			return os.Mkdir(path, 0o700)
+		},
+	} {
+		dir := t.TempDir()
+		s, err := Open(dir, format)
+		if err != nil {
+			t.Fatalf("Open(%s): %v", format, err)
+		}
+		if err := block(dir); err != nil {
+			t.Fatal(err)
+		}
+		err = s.CreateItem("login", "u1", ItemMeta{Label: "x", Attributes: map[string]string{"k": "v"}})
+		if err == nil || errors.Is(err, ErrModifiedExternally) {
+			t.Fatalf("%s: CreateItem = %v, want a write error", format, err)
+		}
+		if _, ok := s.GetItem("login", "u1"); ok {
+			t.Errorf("%s: item visible after its write failed", format)
+		}
+		if refs := s.SearchItems(map[string]string{"k": "v"}); len(refs) != 0 {
+			t.Errorf("%s: item indexed after its write failed: %v", format, refs)
+		}
+	}
+}
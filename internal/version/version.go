@@ -0,0 +1,74 @@
+// SPDX-License-Identifier: Apache-2.0
+
+// Package version reports the version of the wsl-secret-service binaries.
+package version
+
+import (
+	"fmt"
+	"runtime"
+	"runtime/debug"
+	"strings"
+)
+
+// Version, Commit and BuildDate describe the build. Release builds set them
+// with -ldflags "-X github.com/akihiro/wsl-secret-service/internal/version.Version=..."
+// (see the Makefile); otherwise the module version and VCS revision recorded
+// by the Go toolchain are used.
+var (
+	Version   = ""
+	Commit    = ""
+	BuildDate = ""
+)
+
+// String returns the daemon version, or "dev" for untagged builds.
+func String() string {
+	if Version != "" {
+		return Version
+	}
+	if info, ok := debug.ReadBuildInfo(); ok && info.Main.Version != "" && info.Main.Version != "(devel)" {
+		return info.Main.Version
+	}
+	return "dev"
+}
+
+// CommitString returns the VCS revision the binary was built from, with a
+// "-dirty" suffix for modified trees, or "" if unknown.
+func CommitString() string {
+	if Commit != "" {
+		return Commit
+	}
+	info, ok := debug.ReadBuildInfo()
+	if !ok {
+		return ""
+	}
+	var rev, modified string
+	for _, s := range info.Settings {
+		switch s.Key {
+		case "vcs.revision":
+			rev = s.Value
+		case "vcs.modified":
+			modified = s.Value
+		}
+	}
+	if len(rev) > 12 {
+		rev = rev[:12]
+	}
+	if rev != "" && modified == "true" {
+		rev += "-dirty"
+	}
+	return rev
+}
+
+// Long returns the version with the commit, build date and Go version, as
+// printed by --version: "v1.2.0 (commit 0123456789ab, built 2026-01-02, go1.26.0)".
+func Long() string {
+	details := []string{}
+	if c := CommitString(); c != "" {
+		details = append(details, "commit "+c)
+	}
+	if BuildDate != "" {
+		details = append(details, "built "+BuildDate)
+	}
+	details = append(details, runtime.Version())
+	return fmt.Sprintf("%s (%s)", String(), strings.Join(details, ", "))
+}
@@ -0,0 +1,235 @@
+// SPDX-License-Identifier: Apache-2.0
+
+// Package memory provides a backend that keeps secrets in process memory
+// only. Everything is lost when the daemon exits; it is intended for tests,
+// demos and throwaway environments.
+//
+// By default it enforces the Windows Credential Manager's limits on secret
+// and target sizes, so that code tested against it behaves as it will with
+// the wincred backend. It also keeps the Metadata passed on Set, so it can
+// stand in for wincred in tests of metadata recovery and mirroring.
+//
+// The package is public so that other modules can use it in their tests
+// as a Credential Manager that needs no Windows host; IsNotFound
+// recognizes the error of a missing target.
+package memory
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"maps"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf16"
+
+	"github.com/akihiro/wsl-secret-service/internal/backend"
+	"github.com/akihiro/wsl-secret-service/internal/ipc"
+)
+
+func init() {
+	backend.Register("memory", func(opts backend.Options) (backend.Backend, error) {
+		if opts.SecretAlloc != nil {
+			return New(WithAllocator(opts.SecretAlloc, opts.SecretRelease)), nil
+		}
+		return New(), nil
+	})
+}
+
+// Backend implements backend.Backend with an in-memory map. It is safe for
+// concurrent use.
+type Backend struct {
+	mu        sync.Mutex
+	entries   map[string]*entry
+	unlimited bool
+	now       func() time.Time
+	alloc     func(n int) ([]byte, error)
+	release   func([]byte)
+}
+
+type entry struct {
+	secret  []byte
+	meta    backend.Metadata
+	written time.Time
+}
+
+// Option configures a Backend.
+type Option func(*Backend)
+
+// Unlimited lifts the Credential Manager's size limits, for tests of
+// backends layered on top that store more than a credential can hold.
+func Unlimited() Option {
+	return func(b *Backend) { b.unlimited = true }
+}
+
+// WithClock makes the Backend stamp entries with the times now returns
+// instead of the wall clock.
+func WithClock(now func() time.Time) Option {
+	return func(b *Backend) { b.now = now }
+}
+
+// WithAllocator makes the Backend keep secrets, and return them from Get,
+// in buffers obtained from alloc, e.g. mlocked memory, and give them back
+// to release when they are overwritten or deleted. A nil release clears
+// them.
+func WithAllocator(alloc func(n int) ([]byte, error), release func([]byte)) Option {
+	return func(b *Backend) { b.alloc, b.release = alloc, release }
+}
+
+// New returns an empty Backend.
+func New(opts ...Option) *Backend {
+	b := &Backend{entries: make(map[string]*entry), now: time.Now}
+	for _, opt := range opts {
+		opt(b)
+	}
+	return b
+}
+
+// IsNotFound reports whether err says that a target does not exist.
+func IsNotFound(err error) bool {
+	var notFound *backend.ErrNotFound
+	return errors.As(err, &notFound)
+}
+
+// Get returns the raw secret bytes for the given target.
+func (b *Backend) Get(ctx context.Context, target string) ([]byte, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	e, ok := b.entries[target]
+	if !ok {
+		return nil, &backend.ErrNotFound{Target: target}
+	}
+	return b.copy(e.secret)
+}
+
+// Set stores raw secret bytes under the given target, along with the
+// Metadata attached to ctx, if any.
+func (b *Backend) Set(ctx context.Context, target string, secret []byte) error {
+	if err := b.check(target, secret); err != nil {
+		return err
+	}
+	meta, _ := backend.MetadataFrom(ctx)
+	meta.Attributes = maps.Clone(meta.Attributes)
+	v, err := b.copy(secret)
+	if err != nil {
+		return err
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if e, ok := b.entries[target]; ok {
+		b.drop(e.secret)
+	}
+	b.entries[target] = &entry{secret: v, meta: meta, written: b.now()}
+	return nil
+}
+
+// copy returns a copy of secret in a buffer from b.alloc, if set.
+func (b *Backend) copy(secret []byte) ([]byte, error) {
+	if b.alloc == nil {
+		return append([]byte(nil), secret...), nil
+	}
+	v, err := b.alloc(len(secret))
+	if err != nil {
+		return nil, err
+	}
+	copy(v, secret)
+	return v, nil
+}
+
+// drop wipes a stored secret.
+func (b *Backend) drop(v []byte) {
+	if b.release != nil {
+		b.release(v)
+	} else {
+		clear(v)
+	}
+}
+
+// check enforces the Credential Manager's limits unless b is unlimited.
+func (b *Backend) check(target string, secret []byte) error {
+	if b.unlimited {
+		return nil
+	}
+	if len(secret) > ipc.MaxSecretSize {
+		return fmt.Errorf("secret too large (max %d bytes, got %d)", ipc.MaxSecretSize, len(secret))
+	}
+	n := 0
+	for _, r := range target {
+		n += utf16.RuneLen(r)
+	}
+	if n > ipc.MaxTargetLen {
+		return &backend.ErrTargetTooLong{Target: target, Max: ipc.MaxTargetLen}
+	}
+	return nil
+}
+
+// Delete removes the secret for the given target.
+func (b *Backend) Delete(ctx context.Context, target string) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	e, ok := b.entries[target]
+	if !ok {
+		return &backend.ErrNotFound{Target: target}
+	}
+	b.drop(e.secret)
+	delete(b.entries, target)
+	return nil
+}
+
+// List returns all target strings that have the given prefix.
+func (b *Backend) List(ctx context.Context, prefix string) ([]string, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	targets := []string{}
+	for t := range b.entries {
+		if strings.HasPrefix(t, prefix) {
+			targets = append(targets, t)
+		}
+	}
+	sort.Strings(targets)
+	return targets, nil
+}
+
+// ListMetadata implements backend.MetadataLister.
+func (b *Backend) ListMetadata(ctx context.Context, prefix string) (map[string]backend.Metadata, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	metas := make(map[string]backend.Metadata)
+	for t, e := range b.entries {
+		if strings.HasPrefix(t, prefix) {
+			m := e.meta
+			m.Attributes = maps.Clone(m.Attributes)
+			metas[t] = m
+		}
+	}
+	return metas, nil
+}
+
+// ListEntries implements backend.EntryLister.
+func (b *Backend) ListEntries(ctx context.Context, prefix string) ([]backend.Entry, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	entries := []backend.Entry{}
+	for t, e := range b.entries {
+		if strings.HasPrefix(t, prefix) {
+			m := e.meta
+			m.Attributes = maps.Clone(m.Attributes)
+			entries = append(entries, backend.Entry{Target: t, LastWritten: e.written, Metadata: m})
+		}
+	}
+	sort.Slice(entries, func(i, j int) bool { return entries[i].Target < entries[j].Target })
+	return entries, nil
+}
+
+// Close wipes all stored secrets.
+func (b *Backend) Close() error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for t, e := range b.entries {
+		b.drop(e.secret)
+		delete(b.entries, t)
+	}
+	return nil
+}
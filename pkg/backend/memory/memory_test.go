@@ -0,0 +1,72 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package memory
+
+import (
+	"bytes"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/akihiro/wsl-secret-service/internal/backend"
+	"github.com/akihiro/wsl-secret-service/internal/ipc"
+)
+
+func TestLimits(t *testing.T) {
+	b := New()
+	if err := b.Set(t.Context(), "t", bytes.Repeat([]byte("x"), ipc.MaxSecretSize)); err != nil {
+		t.Errorf("Set at the size limit: %v", err)
+	}
+	if err := b.Set(t.Context(), "t", bytes.Repeat([]byte("x"), ipc.MaxSecretSize+1)); err == nil {
+		t.Error("Set beyond the size limit succeeded")
+	}
+	var tooLong *backend.ErrTargetTooLong
+	if err := b.Set(t.Context(), strings.Repeat("\U0001F511", ipc.MaxTargetLen/2+1), []byte("s")); !errors.As(err, &tooLong) {
+		t.Errorf("Set with a long target = %v, want ErrTargetTooLong", err)
+	}
+
+	u := New(Unlimited())
+	if err := u.Set(t.Context(), "t", bytes.Repeat([]byte("x"), 10000)); err != nil {
+		t.Errorf("unlimited Set: %v", err)
+	}
+}
+
+func TestIsNotFound(t *testing.T) {
+	b := New()
+	if _, err := b.Get(t.Context(), "missing"); !IsNotFound(err) {
+		t.Errorf("Get of a missing target = %v, want not found", err)
+	}
+	if err := b.Delete(t.Context(), "missing"); !IsNotFound(err) {
+		t.Errorf("Delete of a missing target = %v, want not found", err)
+	}
+	if IsNotFound(errors.New("other")) {
+		t.Error("IsNotFound accepted an unrelated error")
+	}
+}
+
+func TestMetadata(t *testing.T) {
+	written := time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC)
+	b := New(WithClock(func() time.Time { return written }))
+	attrs := map[string]string{"service": "vpn"}
+	ctx := backend.WithMetadata(t.Context(), backend.Metadata{Collection: "work", Label: "VPN", Attributes: attrs})
+	if err := b.Set(ctx, "wsl-ss/a", []byte("s")); err != nil {
+		t.Fatal(err)
+	}
+	if err := b.Set(t.Context(), "wsl-ss/b", []byte("s")); err != nil {
+		t.Fatal(err)
+	}
+	attrs["service"] = "changed"
+
+	metas, err := b.ListMetadata(t.Context(), "wsl-ss/")
+	if err != nil || len(metas) != 2 {
+		t.Fatalf("ListMetadata = %+v, %v", metas, err)
+	}
+	if m := metas["wsl-ss/a"]; m.Label != "VPN" || m.Attributes["service"] != "vpn" {
+		t.Errorf("metadata of a = %+v", m)
+	}
+	entries, err := b.ListEntries(t.Context(), "")
+	if err != nil || len(entries) != 2 || entries[0].Target != "wsl-ss/a" || !entries[0].LastWritten.Equal(written) || entries[1].Label != "" {
+		t.Errorf("ListEntries = %+v, %v", entries, err)
+	}
+}
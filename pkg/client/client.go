@@ -0,0 +1,424 @@
+// SPDX-License-Identifier: Apache-2.0
+
+// Package client is a Go client for the Freedesktop.org Secret Service API,
+// as implemented by wsl-secret-service, gnome-keyring and KeePassXC. It
+// opens an encrypted (dh-ietf1024-sha256-aes128-cbc-pkcs7) session by
+// default, runs the prompts the service asks for, and wraps collections and
+// items in small types, so that applications need no godbus calls of their
+// own:
+//
+//	c, err := client.Dial(ctx)
+//	if err != nil {
+//		return err
+//	}
+//	defer c.Close()
+//	token, err := c.Get(ctx, map[string]string{"service": "github", "user": "me"})
+//
+// Every method takes a context that bounds the D-Bus calls and any prompt
+// the call waits for. A Client is safe for concurrent use.
+package client
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/godbus/dbus/v5"
+)
+
+// D-Bus names of the Secret Service API.
+const (
+	BusName         = "org.freedesktop.secrets"
+	ServicePath     = dbus.ObjectPath("/org/freedesktop/secrets")
+	ServiceIface    = "org.freedesktop.Secret.Service"
+	CollectionIface = "org.freedesktop.Secret.Collection"
+	ItemIface       = "org.freedesktop.Secret.Item"
+	SessionIface    = "org.freedesktop.Secret.Session"
+	PromptIface     = "org.freedesktop.Secret.Prompt"
+
+	// DefaultAlias names the collection new items go to by default.
+	DefaultAlias = "default"
+)
+
+// noPath is the "/" the API returns for "no object", e.g. no prompt.
+const noPath = dbus.ObjectPath("/")
+
+var (
+	// ErrNotFound is returned when no item or collection matches.
+	ErrNotFound = errors.New("secret service: not found")
+
+	// ErrDismissed is returned when the user dismissed a prompt.
+	ErrDismissed = errors.New("secret service: prompt dismissed")
+)
+
+// Secret is a secret value with its content type.
+type Secret struct {
+	Value       []byte
+	ContentType string
+}
+
+// wireSecret is the (oayays) Secret struct of the API.
+type wireSecret struct {
+	Session     dbus.ObjectPath
+	Parameters  []byte
+	Value       []byte
+	ContentType string
+}
+
+// Option configures Dial and New.
+type Option func(*options)
+
+type options struct {
+	plain  bool
+	window string
+}
+
+// WithPlainSession opens a plain session, which sends secrets unencrypted
+// over the bus, instead of a DH-encrypted one.
+func WithPlainSession() Option {
+	return func(o *options) { o.plain = true }
+}
+
+// WithWindow passes the platform window ID of the application to prompts,
+// so the service can show them on top of it.
+func WithWindow(id string) Option {
+	return func(o *options) { o.window = id }
+}
+
+// Client is a session with a Secret Service.
+type Client struct {
+	conn     *dbus.Conn
+	ownsConn bool
+	session  dbus.ObjectPath
+	key      []byte // AES session key; nil for a plain session
+	window   string
+}
+
+// Dial connects to the session bus and opens a session with the Secret
+// Service there, starting it through D-Bus activation if needed.
+func Dial(ctx context.Context, opts ...Option) (*Client, error) {
+	conn, err := dbus.ConnectSessionBus(dbus.WithContext(ctx))
+	if err != nil {
+		return nil, fmt.Errorf("connect to session bus: %w", err)
+	}
+	c, err := New(ctx, conn, opts...)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	c.ownsConn = true
+	return c, nil
+}
+
+// New opens a session with the Secret Service on conn. Close leaves conn
+// open.
+func New(ctx context.Context, conn *dbus.Conn, opts ...Option) (*Client, error) {
+	var o options
+	for _, opt := range opts {
+		opt(&o)
+	}
+	c := &Client{conn: conn, window: o.window}
+	var output dbus.Variant
+	if o.plain {
+		if err := c.service().CallWithContext(ctx, ServiceIface+".OpenSession", 0, AlgorithmPlain, dbus.MakeVariant("")).Store(&output, &c.session); err != nil {
+			return nil, fmt.Errorf("open session: %w", err)
+		}
+		return c, nil
+	}
+
+	priv, pub, err := dhKeyPair()
+	if err != nil {
+		return nil, err
+	}
+	defer priv.SetInt64(0)
+	input := make([]byte, dhGroupSize)
+	pub.FillBytes(input)
+	if err := c.service().CallWithContext(ctx, ServiceIface+".OpenSession", 0, AlgorithmDH, dbus.MakeVariant(input)).Store(&output, &c.session); err != nil {
+		return nil, fmt.Errorf("open session: %w", err)
+	}
+	peer, ok := output.Value().([]byte)
+	if !ok {
+		c.closeSession()
+		return nil, fmt.Errorf("open session: unexpected output of type %s", output.Signature())
+	}
+	if c.key, err = dhSessionKey(priv, peer); err != nil {
+		c.closeSession()
+		return nil, err
+	}
+	return c, nil
+}
+
+// Close closes the session and, if Dial opened it, the bus connection.
+func (c *Client) Close() error {
+	c.closeSession()
+	clear(c.key)
+	if c.ownsConn {
+		return c.conn.Close()
+	}
+	return nil
+}
+
+func (c *Client) closeSession() {
+	c.conn.Object(BusName, c.session).Call(SessionIface+".Close", 0)
+}
+
+// Encrypted reports whether secrets are encrypted on the bus.
+func (c *Client) Encrypted() bool {
+	return c.key != nil
+}
+
+func (c *Client) service() dbus.BusObject {
+	return c.conn.Object(BusName, ServicePath)
+}
+
+func (c *Client) object(path dbus.ObjectPath) dbus.BusObject {
+	return c.conn.Object(BusName, path)
+}
+
+// property reads iface.name of the object at path into v.
+func (c *Client) property(ctx context.Context, path dbus.ObjectPath, iface, name string, v any) error {
+	var variant dbus.Variant
+	if err := c.object(path).CallWithContext(ctx, "org.freedesktop.DBus.Properties.Get", 0, iface, name).Store(&variant); err != nil {
+		return fmt.Errorf("read %s of %s: %w", name, path, err)
+	}
+	return variant.Store(v)
+}
+
+// setProperty sets iface.name of the object at path to v.
+func (c *Client) setProperty(ctx context.Context, path dbus.ObjectPath, iface, name string, v any) error {
+	if err := c.object(path).CallWithContext(ctx, "org.freedesktop.DBus.Properties.Set", 0, iface, name, dbus.MakeVariant(v)).Err; err != nil {
+		return fmt.Errorf("set %s of %s: %w", name, path, err)
+	}
+	return nil
+}
+
+// encode turns s into the Secret struct of this session.
+func (c *Client) encode(s Secret) (wireSecret, error) {
+	w := wireSecret{Session: c.session, Parameters: []byte{}, Value: s.Value, ContentType: s.ContentType}
+	if w.ContentType == "" {
+		w.ContentType = "text/plain"
+	}
+	if c.key == nil {
+		return w, nil
+	}
+	var err error
+	w.Parameters, w.Value, err = encrypt(c.key, s.Value)
+	return w, err
+}
+
+// decode returns the value of a Secret struct of this session.
+func (c *Client) decode(w wireSecret) (Secret, error) {
+	if c.key == nil {
+		return Secret{Value: w.Value, ContentType: w.ContentType}, nil
+	}
+	value, err := decrypt(c.key, w.Parameters, w.Value)
+	if err != nil {
+		return Secret{}, err
+	}
+	return Secret{Value: value, ContentType: w.ContentType}, nil
+}
+
+// prompt runs the prompt at path, unless it is "/", and returns its result.
+func (c *Client) prompt(ctx context.Context, path dbus.ObjectPath) (dbus.Variant, error) {
+	if path == noPath || path == "" {
+		return dbus.Variant{}, nil
+	}
+	match := []dbus.MatchOption{dbus.WithMatchObjectPath(path), dbus.WithMatchInterface(PromptIface), dbus.WithMatchMember("Completed")}
+	if err := c.conn.AddMatchSignalContext(ctx, match...); err != nil {
+		return dbus.Variant{}, fmt.Errorf("watch prompt: %w", err)
+	}
+	defer func() { _ = c.conn.RemoveMatchSignal(match...) }()
+	signals := make(chan *dbus.Signal, 4)
+	c.conn.Signal(signals)
+	defer c.conn.RemoveSignal(signals)
+
+	if err := c.object(path).CallWithContext(ctx, PromptIface+".Prompt", 0, c.window).Err; err != nil {
+		return dbus.Variant{}, fmt.Errorf("prompt: %w", err)
+	}
+	for {
+		select {
+		case sig, ok := <-signals:
+			if !ok {
+				return dbus.Variant{}, errors.New("connection closed while waiting for a prompt")
+			}
+			if sig.Path != path || sig.Name != PromptIface+".Completed" || len(sig.Body) < 2 {
+				continue
+			}
+			if dismissed, _ := sig.Body[0].(bool); dismissed {
+				return dbus.Variant{}, ErrDismissed
+			}
+			result, _ := sig.Body[1].(dbus.Variant)
+			return result, nil
+		case <-ctx.Done():
+			c.object(path).Call(PromptIface+".Dismiss", 0)
+			return dbus.Variant{}, ctx.Err()
+		}
+	}
+}
+
+// Collections returns all collections.
+func (c *Client) Collections(ctx context.Context) ([]*Collection, error) {
+	var paths []dbus.ObjectPath
+	if err := c.property(ctx, ServicePath, ServiceIface, "Collections", &paths); err != nil {
+		return nil, err
+	}
+	cols := make([]*Collection, len(paths))
+	for i, p := range paths {
+		cols[i] = c.Collection(p)
+	}
+	return cols, nil
+}
+
+// Collection returns the collection at path, without checking that it
+// exists.
+func (c *Client) Collection(path dbus.ObjectPath) *Collection {
+	return &Collection{c: c, Path: path}
+}
+
+// ReadAlias returns the collection alias name refers to, or ErrNotFound.
+func (c *Client) ReadAlias(ctx context.Context, name string) (*Collection, error) {
+	var path dbus.ObjectPath
+	if err := c.service().CallWithContext(ctx, ServiceIface+".ReadAlias", 0, name).Store(&path); err != nil {
+		return nil, fmt.Errorf("read alias %q: %w", name, err)
+	}
+	if path == noPath {
+		return nil, fmt.Errorf("alias %q: %w", name, ErrNotFound)
+	}
+	return c.Collection(path), nil
+}
+
+// SetAlias points alias name at col, or removes it if col is nil.
+func (c *Client) SetAlias(ctx context.Context, name string, col *Collection) error {
+	path := noPath
+	if col != nil {
+		path = col.Path
+	}
+	if err := c.service().CallWithContext(ctx, ServiceIface+".SetAlias", 0, name, path).Err; err != nil {
+		return fmt.Errorf("set alias %q: %w", name, err)
+	}
+	return nil
+}
+
+// CreateCollection creates a collection labelled label, also known by
+// alias unless it is "", and returns it.
+func (c *Client) CreateCollection(ctx context.Context, label, alias string) (*Collection, error) {
+	props := map[string]dbus.Variant{CollectionIface + ".Label": dbus.MakeVariant(label)}
+	var path, prompt dbus.ObjectPath
+	if err := c.service().CallWithContext(ctx, ServiceIface+".CreateCollection", 0, props, alias).Store(&path, &prompt); err != nil {
+		return nil, fmt.Errorf("create collection %q: %w", label, err)
+	}
+	if path == noPath {
+		result, err := c.prompt(ctx, prompt)
+		if err != nil {
+			return nil, fmt.Errorf("create collection %q: %w", label, err)
+		}
+		if err := result.Store(&path); err != nil {
+			return nil, fmt.Errorf("create collection %q: %w", label, err)
+		}
+	}
+	return c.Collection(path), nil
+}
+
+// Search returns the items of all collections whose attributes include
+// attrs, unlocked ones first.
+func (c *Client) Search(ctx context.Context, attrs map[string]string) ([]*Item, error) {
+	var unlocked, locked []dbus.ObjectPath
+	if err := c.service().CallWithContext(ctx, ServiceIface+".SearchItems", 0, attrs).Store(&unlocked, &locked); err != nil {
+		return nil, fmt.Errorf("search items: %w", err)
+	}
+	return c.items(append(unlocked, locked...)), nil
+}
+
+func (c *Client) items(paths []dbus.ObjectPath) []*Item {
+	items := make([]*Item, len(paths))
+	for i, p := range paths {
+		items[i] = &Item{c: c, Path: p}
+	}
+	return items
+}
+
+// Unlock unlocks the collections and items at paths, running the prompt
+// the service asks for.
+func (c *Client) Unlock(ctx context.Context, paths ...dbus.ObjectPath) error {
+	return c.lockCall(ctx, "Unlock", paths)
+}
+
+// Lock locks the collections and items at paths.
+func (c *Client) Lock(ctx context.Context, paths ...dbus.ObjectPath) error {
+	return c.lockCall(ctx, "Lock", paths)
+}
+
+func (c *Client) lockCall(ctx context.Context, method string, paths []dbus.ObjectPath) error {
+	var done []dbus.ObjectPath
+	var prompt dbus.ObjectPath
+	if err := c.service().CallWithContext(ctx, ServiceIface+"."+method, 0, paths).Store(&done, &prompt); err != nil {
+		return fmt.Errorf("%s: %w", method, err)
+	}
+	_, err := c.prompt(ctx, prompt)
+	return err
+}
+
+// Secrets returns the secrets of items in one GetSecrets call, keyed by
+// item path. Locked items are left out; unlock them first.
+func (c *Client) Secrets(ctx context.Context, items ...*Item) (map[dbus.ObjectPath]Secret, error) {
+	paths := make([]dbus.ObjectPath, len(items))
+	for i, it := range items {
+		paths[i] = it.Path
+	}
+	var wire map[dbus.ObjectPath]wireSecret
+	if err := c.service().CallWithContext(ctx, ServiceIface+".GetSecrets", 0, paths, c.session).Store(&wire); err != nil {
+		return nil, fmt.Errorf("get secrets: %w", err)
+	}
+	secrets := make(map[dbus.ObjectPath]Secret, len(wire))
+	for p, w := range wire {
+		s, err := c.decode(w)
+		if err != nil {
+			return nil, fmt.Errorf("get secret of %s: %w", p, err)
+		}
+		secrets[p] = s
+	}
+	return secrets, nil
+}
+
+// Get returns the secret value of the first item matching attrs, or
+// ErrNotFound.
+func (c *Client) Get(ctx context.Context, attrs map[string]string) ([]byte, error) {
+	items, err := c.Search(ctx, attrs)
+	if err != nil {
+		return nil, err
+	}
+	if len(items) == 0 {
+		return nil, ErrNotFound
+	}
+	s, err := items[0].Secret(ctx)
+	return s.Value, err
+}
+
+// Set stores value as an item labelled label with attributes attrs in the
+// default collection, replacing an item with the same attributes.
+func (c *Client) Set(ctx context.Context, label string, attrs map[string]string, value []byte) (*Item, error) {
+	col, err := c.ReadAlias(ctx, DefaultAlias)
+	if errors.Is(err, ErrNotFound) {
+		col = c.Collection(ServicePath + "/collection/login")
+	} else if err != nil {
+		return nil, err
+	}
+	return col.CreateItem(ctx, label, attrs, Secret{Value: value}, true)
+}
+
+// Delete deletes every item matching attrs; ErrNotFound if there is none.
+func (c *Client) Delete(ctx context.Context, attrs map[string]string) error {
+	items, err := c.Search(ctx, attrs)
+	if err != nil {
+		return err
+	}
+	if len(items) == 0 {
+		return ErrNotFound
+	}
+	for _, it := range items {
+		if err := it.Delete(ctx); err != nil {
+			return err
+		}
+	}
+	return nil
+}
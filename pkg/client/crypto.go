@@ -0,0 +1,111 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package client
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hkdf"
+	"crypto/rand"
+	"crypto/sha256"
+	"errors"
+	"math/big"
+)
+
+// AlgorithmDH and AlgorithmPlain are the session algorithms of the Secret
+// Service API that the client supports.
+const (
+	AlgorithmDH    = "dh-ietf1024-sha256-aes128-cbc-pkcs7"
+	AlgorithmPlain = "plain"
+)
+
+// dhPrime is the 1024-bit MODP group of RFC 2409 (Group 2), the group of
+// AlgorithmDH; its generator is 2.
+var dhPrime, _ = new(big.Int).SetString(
+	"FFFFFFFFFFFFFFFFC90FDAA22168C234C4C6628B80DC1CD1"+
+		"29024E088A67CC74020BBEA63B139B22514A08798E3404DD"+
+		"EF9519B3CD3A431B302B0A6DF25F14374FE1356D6D51C245"+
+		"E485B576625E7EC6F44C42E9A637ED6B0BFF5CB6F406B7ED"+
+		"EE386BFB5A899FA5AE9F24117C4B1FE649286651ECE65381"+
+		"FFFFFFFFFFFFFFFF",
+	16,
+)
+
+// dhGroupSize is the byte length of dhPrime, and of public keys on the wire.
+const dhGroupSize = 128
+
+// dhKeyPair returns a private exponent in [2, p-2] and its public key.
+func dhKeyPair() (priv, pub *big.Int, err error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return nil, nil, err
+	}
+	defer clear(b)
+	pMinus3 := new(big.Int).Sub(dhPrime, big.NewInt(3))
+	priv = new(big.Int).SetBytes(b)
+	priv.Mod(priv, pMinus3).Add(priv, big.NewInt(2))
+	return priv, new(big.Int).Exp(big.NewInt(2), priv, dhPrime), nil
+}
+
+// dhSessionKey derives the AES-128 session key from the service's public
+// key as libsecret does: HKDF-SHA256 of the shared secret, padded to the
+// group size, with no salt and empty info.
+func dhSessionKey(priv *big.Int, peer []byte) ([]byte, error) {
+	pub := new(big.Int).SetBytes(peer)
+	pMinus2 := new(big.Int).Sub(dhPrime, big.NewInt(2))
+	if len(peer) > dhGroupSize || pub.Cmp(big.NewInt(2)) < 0 || pub.Cmp(pMinus2) > 0 {
+		return nil, errors.New("secret service sent an invalid DH public key")
+	}
+	shared := make([]byte, dhGroupSize)
+	defer clear(shared)
+	new(big.Int).Exp(pub, priv, dhPrime).FillBytes(shared)
+	return hkdf.Key(sha256.New, shared, nil, "", 16)
+}
+
+// encrypt returns the IV and the AES-128-CBC ciphertext of plaintext,
+// padded with PKCS #7.
+func encrypt(key, plaintext []byte) (iv, ciphertext []byte, err error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, nil, err
+	}
+	iv = make([]byte, aes.BlockSize)
+	if _, err := rand.Read(iv); err != nil {
+		return nil, nil, err
+	}
+	padding := aes.BlockSize - len(plaintext)%aes.BlockSize
+	padded := make([]byte, len(plaintext)+padding)
+	defer clear(padded)
+	copy(padded, plaintext)
+	for i := len(plaintext); i < len(padded); i++ {
+		padded[i] = byte(padding)
+	}
+	ciphertext = make([]byte, len(padded))
+	cipher.NewCBCEncrypter(block, iv).CryptBlocks(ciphertext, padded)
+	return iv, ciphertext, nil
+}
+
+// decrypt reverses encrypt.
+func decrypt(key, iv, ciphertext []byte) ([]byte, error) {
+	if len(iv) != aes.BlockSize || len(ciphertext) == 0 || len(ciphertext)%aes.BlockSize != 0 {
+		return nil, errors.New("malformed encrypted secret")
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	plaintext := make([]byte, len(ciphertext))
+	cipher.NewCBCDecrypter(block, iv).CryptBlocks(plaintext, ciphertext)
+	padding := int(plaintext[len(plaintext)-1])
+	if padding == 0 || padding > aes.BlockSize {
+		clear(plaintext)
+		return nil, errors.New("invalid padding in encrypted secret")
+	}
+	for _, b := range plaintext[len(plaintext)-padding:] {
+		if int(b) != padding {
+			clear(plaintext)
+			return nil, errors.New("invalid padding in encrypted secret")
+		}
+	}
+	return plaintext[:len(plaintext)-padding], nil
+}
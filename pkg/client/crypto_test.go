@@ -0,0 +1,59 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package client
+
+import (
+	"bytes"
+	"math/big"
+	"testing"
+)
+
+func TestSessionKeyAgreement(t *testing.T) {
+	privA, pubA, err := dhKeyPair()
+	if err != nil {
+		t.Fatal(err)
+	}
+	privB, pubB, err := dhKeyPair()
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyA, err := dhSessionKey(privA, pubB.Bytes())
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyB, err := dhSessionKey(privB, pubA.Bytes())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(keyA) != 16 || !bytes.Equal(keyA, keyB) {
+		t.Fatalf("session keys differ or are not AES-128: %x, %x", keyA, keyB)
+	}
+
+	for _, bad := range []*big.Int{big.NewInt(0), big.NewInt(1), new(big.Int).Sub(dhPrime, big.NewInt(1)), dhPrime} {
+		if _, err := dhSessionKey(privA, bad.Bytes()); err == nil {
+			t.Errorf("dhSessionKey accepted the public key %x", bad)
+		}
+	}
+}
+
+func TestEncryptDecrypt(t *testing.T) {
+	key := bytes.Repeat([]byte{7}, 16)
+	for _, plaintext := range [][]byte{{}, []byte("s3cret"), bytes.Repeat([]byte("x"), 16)} {
+		iv, ciphertext, err := encrypt(key, plaintext)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(ciphertext)%16 != 0 || len(ciphertext) <= len(plaintext) {
+			t.Errorf("ciphertext of %d bytes is %d bytes", len(plaintext), len(ciphertext))
+		}
+		got, err := decrypt(key, iv, ciphertext)
+		if err != nil || !bytes.Equal(got, plaintext) {
+			t.Errorf("decrypt = %q, %v; want %q", got, err, plaintext)
+		}
+	}
+
+	iv, ciphertext, _ := encrypt(key, []byte("s3cret"))
+	if _, err := decrypt(key, iv[:8], ciphertext); err == nil {
+		t.Error("decrypt with a short IV succeeded")
+	}
+}
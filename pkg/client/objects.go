@@ -0,0 +1,201 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package client
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/godbus/dbus/v5"
+)
+
+// Collection is a collection of the Secret Service.
+type Collection struct {
+	c    *Client
+	Path dbus.ObjectPath
+}
+
+// Label returns the label of the collection.
+func (col *Collection) Label(ctx context.Context) (string, error) {
+	var label string
+	return label, col.c.property(ctx, col.Path, CollectionIface, "Label", &label)
+}
+
+// SetLabel changes the label of the collection.
+func (col *Collection) SetLabel(ctx context.Context, label string) error {
+	return col.c.setProperty(ctx, col.Path, CollectionIface, "Label", label)
+}
+
+// Locked reports whether the collection is locked.
+func (col *Collection) Locked(ctx context.Context) (bool, error) {
+	var locked bool
+	return locked, col.c.property(ctx, col.Path, CollectionIface, "Locked", &locked)
+}
+
+// Unlock unlocks the collection.
+func (col *Collection) Unlock(ctx context.Context) error {
+	return col.c.Unlock(ctx, col.Path)
+}
+
+// Items returns the items of the collection.
+func (col *Collection) Items(ctx context.Context) ([]*Item, error) {
+	var paths []dbus.ObjectPath
+	if err := col.c.property(ctx, col.Path, CollectionIface, "Items", &paths); err != nil {
+		return nil, err
+	}
+	return col.c.items(paths), nil
+}
+
+// Search returns the items of the collection whose attributes include
+// attrs.
+func (col *Collection) Search(ctx context.Context, attrs map[string]string) ([]*Item, error) {
+	var paths []dbus.ObjectPath
+	if err := col.c.object(col.Path).CallWithContext(ctx, CollectionIface+".SearchItems", 0, attrs).Store(&paths); err != nil {
+		return nil, fmt.Errorf("search items of %s: %w", col.Path, err)
+	}
+	return col.c.items(paths), nil
+}
+
+// CreateItem stores secret as a new item labelled label with attributes
+// attrs and returns it. With replace, an item with the same attributes is
+// replaced instead. A locked collection is unlocked first.
+func (col *Collection) CreateItem(ctx context.Context, label string, attrs map[string]string, secret Secret, replace bool) (*Item, error) {
+	if locked, err := col.Locked(ctx); err != nil {
+		return nil, err
+	} else if locked {
+		if err := col.Unlock(ctx); err != nil {
+			return nil, err
+		}
+	}
+	w, err := col.c.encode(secret)
+	if err != nil {
+		return nil, err
+	}
+	props := map[string]dbus.Variant{
+		ItemIface + ".Label":      dbus.MakeVariant(label),
+		ItemIface + ".Attributes": dbus.MakeVariant(attrs),
+	}
+	var path, prompt dbus.ObjectPath
+	if err := col.c.object(col.Path).CallWithContext(ctx, CollectionIface+".CreateItem", 0, props, w, replace).Store(&path, &prompt); err != nil {
+		return nil, fmt.Errorf("create item %q: %w", label, err)
+	}
+	if path == noPath {
+		result, err := col.c.prompt(ctx, prompt)
+		if err != nil {
+			return nil, fmt.Errorf("create item %q: %w", label, err)
+		}
+		if err := result.Store(&path); err != nil {
+			return nil, fmt.Errorf("create item %q: %w", label, err)
+		}
+	}
+	return &Item{c: col.c, Path: path}, nil
+}
+
+// Delete deletes the collection and its items.
+func (col *Collection) Delete(ctx context.Context) error {
+	var prompt dbus.ObjectPath
+	if err := col.c.object(col.Path).CallWithContext(ctx, CollectionIface+".Delete", 0).Store(&prompt); err != nil {
+		return fmt.Errorf("delete %s: %w", col.Path, err)
+	}
+	_, err := col.c.prompt(ctx, prompt)
+	return err
+}
+
+// Item is an item of the Secret Service.
+type Item struct {
+	c    *Client
+	Path dbus.ObjectPath
+}
+
+// Label returns the label of the item.
+func (it *Item) Label(ctx context.Context) (string, error) {
+	var label string
+	return label, it.c.property(ctx, it.Path, ItemIface, "Label", &label)
+}
+
+// SetLabel changes the label of the item.
+func (it *Item) SetLabel(ctx context.Context, label string) error {
+	return it.c.setProperty(ctx, it.Path, ItemIface, "Label", label)
+}
+
+// Attributes returns the attributes of the item.
+func (it *Item) Attributes(ctx context.Context) (map[string]string, error) {
+	var attrs map[string]string
+	return attrs, it.c.property(ctx, it.Path, ItemIface, "Attributes", &attrs)
+}
+
+// SetAttributes replaces the attributes of the item.
+func (it *Item) SetAttributes(ctx context.Context, attrs map[string]string) error {
+	return it.c.setProperty(ctx, it.Path, ItemIface, "Attributes", attrs)
+}
+
+// Locked reports whether the item is locked.
+func (it *Item) Locked(ctx context.Context) (bool, error) {
+	var locked bool
+	return locked, it.c.property(ctx, it.Path, ItemIface, "Locked", &locked)
+}
+
+// Created returns when the item was created.
+func (it *Item) Created(ctx context.Context) (time.Time, error) {
+	return it.time(ctx, "Created")
+}
+
+// Modified returns when the item was last changed.
+func (it *Item) Modified(ctx context.Context) (time.Time, error) {
+	return it.time(ctx, "Modified")
+}
+
+func (it *Item) time(ctx context.Context, name string) (time.Time, error) {
+	var secs uint64
+	if err := it.c.property(ctx, it.Path, ItemIface, name, &secs); err != nil {
+		return time.Time{}, err
+	}
+	return time.Unix(int64(secs), 0), nil
+}
+
+// Secret returns the secret of the item, unlocking it first if needed.
+// The caller may clear the returned value.
+func (it *Item) Secret(ctx context.Context) (Secret, error) {
+	if err := it.unlock(ctx); err != nil {
+		return Secret{}, err
+	}
+	var w wireSecret
+	if err := it.c.object(it.Path).CallWithContext(ctx, ItemIface+".GetSecret", 0, it.c.session).Store(&w); err != nil {
+		return Secret{}, fmt.Errorf("get secret of %s: %w", it.Path, err)
+	}
+	return it.c.decode(w)
+}
+
+// SetSecret replaces the secret of the item, unlocking it first if needed.
+func (it *Item) SetSecret(ctx context.Context, secret Secret) error {
+	if err := it.unlock(ctx); err != nil {
+		return err
+	}
+	w, err := it.c.encode(secret)
+	if err != nil {
+		return err
+	}
+	if err := it.c.object(it.Path).CallWithContext(ctx, ItemIface+".SetSecret", 0, w).Err; err != nil {
+		return fmt.Errorf("set secret of %s: %w", it.Path, err)
+	}
+	return nil
+}
+
+func (it *Item) unlock(ctx context.Context) error {
+	locked, err := it.Locked(ctx)
+	if err != nil || !locked {
+		return err
+	}
+	return it.c.Unlock(ctx, it.Path)
+}
+
+// Delete deletes the item.
+func (it *Item) Delete(ctx context.Context) error {
+	var prompt dbus.ObjectPath
+	if err := it.c.object(it.Path).CallWithContext(ctx, ItemIface+".Delete", 0).Store(&prompt); err != nil {
+		return fmt.Errorf("delete %s: %w", it.Path, err)
+	}
+	_, err := it.c.prompt(ctx, prompt)
+	return err
+}
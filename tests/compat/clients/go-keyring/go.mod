@@ -0,0 +1,12 @@
+module github.com/akihiro/wsl-secret-service/tests/compat/clients/go-keyring
+
+go 1.24
+
+require github.com/zalando/go-keyring v0.2.1
+
+require (
+	github.com/alessio/shellescape v1.4.1 // indirect
+	github.com/danieljoos/wincred v1.1.0 // indirect
+	github.com/godbus/dbus/v5 v5.2.2 // indirect
+	golang.org/x/sys v0.27.0 // indirect
+)
@@ -0,0 +1,64 @@
+// SPDX-License-Identifier: Apache-2.0
+
+// go-keyring drives github.com/zalando/go-keyring through the flows of the
+// compatibility harness (see tests/compat/run.sh) and prints one
+// "<case> ok" or "<case> FAIL <reason>" line per case.
+//
+// It is a module of its own so that the daemon does not depend on
+// go-keyring.
+package main
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/zalando/go-keyring"
+)
+
+const (
+	service = "wss-compat-go-keyring"
+	user    = "alice"
+)
+
+func check(name string, err error) {
+	if err != nil {
+		fmt.Printf("%s FAIL %v\n", name, err)
+		return
+	}
+	fmt.Printf("%s ok\n", name)
+}
+
+func expect(want string) error {
+	got, err := keyring.Get(service, user)
+	if err != nil {
+		return err
+	}
+	if got != want {
+		return fmt.Errorf("got %q, want %q", got, want)
+	}
+	return nil
+}
+
+func main() {
+	check("store", keyring.Set(service, user, "s3cret"))
+	check("lookup", expect("s3cret"))
+	check("update", func() error {
+		if err := keyring.Set(service, user, "changed"); err != nil {
+			return err
+		}
+		return expect("changed")
+	}())
+	check("unicode", func() error {
+		if err := keyring.Set(service, user, "pässwörd 🔑"); err != nil {
+			return err
+		}
+		return expect("pässwörd 🔑")
+	}())
+	check("delete", keyring.Delete(service, user))
+	check("lookup-deleted", func() error {
+		if _, err := keyring.Get(service, user); !errors.Is(err, keyring.ErrNotFound) {
+			return fmt.Errorf("got err = %v, want ErrNotFound", err)
+		}
+		return nil
+	}())
+}
@@ -0,0 +1,32 @@
+#!/bin/bash
+# SPDX-License-Identifier: Apache-2.0
+# Drives secret-tool (libsecret) through the compatibility flows and prints
+# one "<case> ok" or "<case> FAIL <reason>" line per case.
+
+ATTRS=(service wss-compat-secret-tool user alice)
+
+report() {
+    local name=$1; shift
+    if [ -z "$*" ]; then
+        echo "$name ok"
+    else
+        echo "$name FAIL $*"
+    fi
+}
+
+store() {
+    printf '%s' "$1" | secret-tool store --label="wss compat" "${ATTRS[@]}" 2>&1
+}
+
+expect() {
+    local got
+    got=$(secret-tool lookup "${ATTRS[@]}" 2>&1) || { echo "lookup failed: $got"; return; }
+    [ "$got" = "$1" ] || echo "got '$got', want '$1'"
+}
+
+report store "$(store s3cret)"
+report lookup "$(expect s3cret)"
+report update "$(store changed; expect changed)"
+report unicode "$(store 'pässwörd 🔑'; expect 'pässwörd 🔑')"
+report delete "$(secret-tool clear "${ATTRS[@]}" 2>&1)"
+report lookup-deleted "$(out=$(secret-tool lookup "${ATTRS[@]}" 2>&1) && echo "still found: '$out'")"
@@ -0,0 +1,54 @@
+#!/usr/bin/env python3
+# SPDX-License-Identifier: Apache-2.0
+"""Drives python-secretstorage through the compatibility flows and prints
+one "<case> ok" or "<case> FAIL <reason>" line per case."""
+
+import secretstorage
+
+ATTRS = {"service": "wss-compat-secretstorage", "user": "alice"}
+
+
+def check(name, fn):
+    try:
+        problem = fn()
+    except Exception as e:  # report every client error as a failure
+        problem = f"{type(e).__name__}: {e}"
+    print(f"{name} ok" if not problem else f"{name} FAIL {problem}")
+
+
+conn = secretstorage.dbus_init()
+collection = secretstorage.get_default_collection(conn)
+
+
+def store(secret):
+    if collection.is_locked():
+        collection.unlock()
+    collection.create_item("wss compat", ATTRS, secret.encode(), replace=True)
+
+
+def expect(want):
+    items = list(collection.search_items(ATTRS))
+    if len(items) != 1:
+        return f"found {len(items)} items, want 1"
+    got = items[0].get_secret().decode()
+    if got != want:
+        return f"got {got!r}, want {want!r}"
+    return None
+
+
+def delete():
+    for item in collection.search_items(ATTRS):
+        item.delete()
+
+
+def lookup_deleted():
+    items = list(collection.search_items(ATTRS))
+    return f"still found {len(items)} items" if items else None
+
+
+check("store", lambda: store("s3cret"))
+check("lookup", lambda: expect("s3cret"))
+check("update", lambda: store("changed") or expect("changed"))
+check("unicode", lambda: store("pässwörd 🔑") or expect("pässwörd 🔑"))
+check("delete", delete)
+check("lookup-deleted", lookup_deleted)
@@ -0,0 +1,185 @@
+#!/bin/bash
+# SPDX-License-Identifier: Apache-2.0
+# Compatibility harness: runs real Secret Service clients against the daemon
+# on a private D-Bus session bus and prints which flows work with which
+# client. The daemon runs with --ephemeral, so nothing touches the user's
+# keyring, metadata or the Windows Credential Manager.
+#
+# Each client driver in clients/ prints one "<case> ok" or
+# "<case> FAIL <reason>" line per case. Clients that are not installed are
+# reported as skipped. Exits 1 if any case failed.
+
+set -o pipefail
+
+SCRIPT_DIR="$(cd "$(dirname "${BASH_SOURCE[0]}")" && pwd)"
+REPO_DIR="$(cd "$SCRIPT_DIR/../.." && pwd)"
+DAEMON_BIN="${DAEMON_BIN:-$REPO_DIR/bin/wsl-secret-service}"
+# Extra daemon flags, e.g. DAEMON_ARGS=--disable-memprotect in containers
+# that do not allow the memory hardening.
+DAEMON_ARGS="${DAEMON_ARGS:-}"
+TEST_TIMEOUT=30
+
+CASES=(store lookup update unicode delete lookup-deleted)
+CLIENTS=(secret-tool secretstorage go-keyring)
+
+VERBOSE=0
+while [[ $# -gt 0 ]]; do
+    case $1 in
+        -v|--verbose)
+            VERBOSE=1
+            shift
+            ;;
+        *)
+            echo "Unknown option: $1"
+            echo "Usage: $0 [-v|--verbose]"
+            exit 1
+            ;;
+    esac
+done
+
+WORK_DIR=$(mktemp -d)
+BUS_PID=""
+DAEMON_PID=""
+
+cleanup() {
+    [ -n "$DAEMON_PID" ] && kill "$DAEMON_PID" 2>/dev/null
+    [ -n "$BUS_PID" ] && kill "$BUS_PID" 2>/dev/null
+    rm -rf "$WORK_DIR"
+}
+trap cleanup EXIT
+
+log_info() {
+    echo "[INFO] $*"
+}
+
+#############################################################################
+# Private bus and daemon
+#############################################################################
+
+start_bus() {
+    local out
+    out=$(dbus-daemon --session --fork --print-address=1 --print-pid=1) || return 1
+    DBUS_SESSION_BUS_ADDRESS=$(echo "$out" | sed -n 1p)
+    BUS_PID=$(echo "$out" | sed -n 2p)
+    export DBUS_SESSION_BUS_ADDRESS
+    log_info "Private session bus: $DBUS_SESSION_BUS_ADDRESS"
+}
+
+start_daemon() {
+    if [ ! -x "$DAEMON_BIN" ]; then
+        echo "[ERROR] Daemon binary not found: $DAEMON_BIN (run 'make build-linux')"
+        return 1
+    fi
+    # shellcheck disable=SC2086
+    "$DAEMON_BIN" --ephemeral --timeout 0 --config-dir "$WORK_DIR/config" $DAEMON_ARGS \
+        &> "$WORK_DIR/daemon.log" &
+    DAEMON_PID=$!
+
+    local attempt=0
+    while [ $attempt -lt $((TEST_TIMEOUT * 10)) ]; do
+        if grep -q 'msg=ready' "$WORK_DIR/daemon.log"; then
+            log_info "Daemon ready (PID $DAEMON_PID)"
+            return 0
+        fi
+        if ! kill -0 "$DAEMON_PID" 2>/dev/null; then
+            break
+        fi
+        sleep 0.1
+        attempt=$((attempt + 1))
+    done
+    echo "[ERROR] Daemon did not become ready:"
+    cat "$WORK_DIR/daemon.log"
+    return 1
+}
+
+#############################################################################
+# Clients
+#############################################################################
+
+# prepare_<client> prints the command running the client's driver, or
+# fails with the reason the client is unavailable.
+
+prepare_secret-tool() {
+    command -v secret-tool > /dev/null || { echo "secret-tool not installed (libsecret-tools)"; return 1; }
+    echo "$SCRIPT_DIR/clients/secret-tool.sh"
+}
+
+prepare_secretstorage() {
+    python3 -c 'import secretstorage' 2> /dev/null || { echo "python3 module secretstorage not installed"; return 1; }
+    echo "python3 $SCRIPT_DIR/clients/secretstorage.py"
+}
+
+prepare_go-keyring() {
+    local out
+    out=$(cd "$SCRIPT_DIR/clients/go-keyring" && go build -o "$WORK_DIR/go-keyring" . 2>&1) \
+        || { echo "cannot build the go-keyring driver: $(echo "$out" | tail -1)"; return 1; }
+    echo "$WORK_DIR/go-keyring"
+}
+
+declare -A RESULT
+FAILED=0
+
+run_client() {
+    local client=$1 cmd output line name status detail
+    if ! cmd=$("prepare_$client"); then
+        log_info "$client: skipped: $cmd"
+        for name in "${CASES[@]}"; do
+            RESULT[$client,$name]=skip
+        done
+        return
+    fi
+    log_info "$client: running"
+    output=$(timeout "$TEST_TIMEOUT" $cmd 2>&1)
+    [ "$VERBOSE" = "1" ] && echo "$output"
+    for name in "${CASES[@]}"; do
+        line=$(echo "$output" | grep -m1 "^$name ")
+        status=$(echo "$line" | cut -d' ' -f2)
+        detail=$(echo "$line" | cut -d' ' -f3-)
+        case $status in
+            ok)
+                RESULT[$client,$name]=ok
+                ;;
+            FAIL)
+                RESULT[$client,$name]=FAIL
+                FAILED=1
+                echo "  $client $name: $detail"
+                ;;
+            *)
+                RESULT[$client,$name]=FAIL
+                FAILED=1
+                echo "  $client $name: no result (driver output: $(echo "$output" | tail -1))"
+                ;;
+        esac
+    done
+}
+
+print_matrix() {
+    local name client
+    echo ""
+    printf '%-16s' "case"
+    for client in "${CLIENTS[@]}"; do
+        printf '%-15s' "$client"
+    done
+    echo ""
+    for name in "${CASES[@]}"; do
+        printf '%-16s' "$name"
+        for client in "${CLIENTS[@]}"; do
+            printf '%-15s' "${RESULT[$client,$name]}"
+        done
+        echo ""
+    done
+    echo ""
+}
+
+main() {
+    start_bus || { echo "[ERROR] Cannot start dbus-daemon"; exit 1; }
+    start_daemon || exit 1
+    for client in "${CLIENTS[@]}"; do
+        run_client "$client"
+    done
+    print_matrix
+    [ "$VERBOSE" = "1" ] && { echo "--- daemon log ---"; cat "$WORK_DIR/daemon.log"; }
+    exit $FAILED
+}
+
+main